@@ -0,0 +1,261 @@
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyProduce = kafkatest.ApiKeyProduce
+	apiKeyFetch   = kafkatest.ApiKeyFetch
+)
+
+var (
+	c = config.NewConfig()
+
+	connections   int
+	duration      time.Duration
+	api           string
+	requestSize   int
+	responseSize  int
+	maxP99Latency time.Duration
+)
+
+var Bench = &cobra.Command{
+	Use:   "bench",
+	Short: "Drive synthetic Produce / Fetch traffic through an in-process proxy against a stub broker",
+	RunE:  Run,
+}
+
+func init() {
+	Bench.Flags().IntVar(&connections, "connections", 4, "Number of concurrent client connections")
+	Bench.Flags().DurationVar(&duration, "duration", 10*time.Second, "How long to generate the traffic")
+	Bench.Flags().StringVar(&api, "api", "produce", "Type of generated requests: produce, fetch or mixed")
+	Bench.Flags().IntVar(&requestSize, "request-size", 1024, "Size of the request payload in bytes")
+	Bench.Flags().IntVar(&responseSize, "response-size", 64, "Size of the response payload in bytes returned by the stub broker. If zero, the request payload is echoed")
+	Bench.Flags().DurationVar(&maxP99Latency, "max-p99-latency", 0, "Fail when the 99th latency percentile is greater than this value. If zero, check is disabled")
+
+	Bench.Flags().IntVar(&c.Proxy.RequestBufferSize, "proxy-request-buffer-size", 4096, "Request buffer size pro tcp connection")
+	Bench.Flags().IntVar(&c.Proxy.ResponseBufferSize, "proxy-response-buffer-size", 4096, "Response buffer size pro tcp connection")
+	Bench.Flags().IntVar(&c.Kafka.MaxOpenRequests, "kafka-max-open-requests", 256, "Maximal number of open requests pro tcp connection before sending on it blocks")
+}
+
+type result struct {
+	requests  int
+	bytesSent int64
+	bytesRecv int64
+	latencies []time.Duration
+	err       error
+}
+
+func Run(_ *cobra.Command, _ []string) error {
+	if connections < 1 {
+		return errors.New("connections must be greater than 0")
+	}
+	if requestSize < 0 || responseSize < 0 {
+		return errors.New("request-size and response-size must be greater or equal 0")
+	}
+	apiKeys, err := getApiKeys(api)
+	if err != nil {
+		return err
+	}
+	// do not measure the connection logging
+	logrus.SetLevel(logrus.WarnLevel)
+	return runBench(apiKeys)
+}
+
+// runBench drives the requests of the api keys through a proxy in front of a stub broker and reports the results
+func runBench(apiKeys []int16) error {
+	broker, err := kafkatest.NewBroker(kafkatest.Config{ResponseSize: responseSize})
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+
+	listenerAddress, err := freeLocalAddress()
+	if err != nil {
+		return err
+	}
+	if err = c.InitBootstrapServers([]string{broker.Addr() + "," + listenerAddress}); err != nil {
+		return err
+	}
+	c.Proxy.DisableDynamicListeners = true
+
+	p, err := proxy.New(c)
+	if err != nil {
+		return err
+	}
+	go p.Run(context.Background())
+	defer p.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	results := make([]result, connections)
+	deadline := time.Now().Add(duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runConnection(listenerAddress, apiKeys, deadline)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return report(results, elapsed, after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+}
+
+func getApiKeys(api string) ([]int16, error) {
+	switch api {
+	case "produce":
+		return []int16{apiKeyProduce}, nil
+	case "fetch":
+		return []int16{apiKeyFetch}, nil
+	case "mixed":
+		return []int16{apiKeyProduce, apiKeyFetch}, nil
+	default:
+		return nil, fmt.Errorf("unsupported api %s, expected produce, fetch or mixed", api)
+	}
+}
+
+func freeLocalAddress() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func runConnection(address string, apiKeys []int16, deadline time.Time) (res result) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer conn.Close()
+
+	clientID := "kafka-proxy-bench"
+	// Size => int32, ApiKey => int16, ApiVersion => int16, CorrelationId => int32, ClientId => string, payload
+	request := make([]byte, 4+2+2+4+2+len(clientID)+requestSize)
+	binary.BigEndian.PutUint32(request[0:], uint32(len(request)-4))
+	binary.BigEndian.PutUint16(request[12:], uint16(len(clientID)))
+	copy(request[14:], clientID)
+
+	header := make([]byte, 8)
+	buf := make([]byte, 4096)
+	for correlationID := int32(0); time.Now().Before(deadline); correlationID++ {
+		apiKey := apiKeys[int(correlationID)%len(apiKeys)]
+		binary.BigEndian.PutUint16(request[4:], uint16(apiKey))
+		if apiKey == apiKeyProduce && requestSize >= 2 {
+			// acks -1, the requests with acks 0 are not answered by the broker
+			binary.BigEndian.PutUint16(request[14+len(clientID):], 0xffff)
+		} else if requestSize >= 2 {
+			binary.BigEndian.PutUint16(request[14+len(clientID):], 0)
+		}
+		binary.BigEndian.PutUint32(request[8:], uint32(correlationID))
+
+		start := time.Now()
+		if _, err = conn.Write(request); err != nil {
+			res.err = err
+			return
+		}
+		if _, err = io.ReadFull(conn, header); err != nil {
+			res.err = err
+			return
+		}
+		if got := int32(binary.BigEndian.Uint32(header[4:])); got != correlationID {
+			res.err = fmt.Errorf("expected correlation id %d, got %d", correlationID, got)
+			return
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4])) - 4
+		if _, err = io.CopyBuffer(discard{}, io.LimitReader(conn, length), buf); err != nil {
+			res.err = err
+			return
+		}
+		res.latencies = append(res.latencies, time.Since(start))
+		res.requests++
+		res.bytesSent += int64(len(request))
+		res.bytesRecv += 8 + length
+	}
+	return
+}
+
+// discard is like ioutil.Discard but without the ReaderFrom implementation allocating its own buffers
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func report(results []result, elapsed time.Duration, mallocs uint64, allocated uint64) error {
+	var requests int
+	var bytesSent, bytesRecv int64
+	latencies := make([]time.Duration, 0)
+	for _, r := range results {
+		if r.err != nil {
+			return errors.Wrap(r.err, "benchmark connection failed")
+		}
+		requests += r.requests
+		bytesSent += r.bytesSent
+		bytesRecv += r.bytesRecv
+		latencies = append(latencies, r.latencies...)
+	}
+	if requests == 0 {
+		return errors.New("no requests were sent")
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	seconds := elapsed.Seconds()
+	fmt.Printf("api:             %s\n", api)
+	fmt.Printf("connections:     %d\n", connections)
+	fmt.Printf("duration:        %v\n", elapsed)
+	fmt.Printf("requests:        %d\n", requests)
+	fmt.Printf("throughput:      %.0f req/s\n", float64(requests)/seconds)
+	fmt.Printf("sent:            %.2f MB/s\n", float64(bytesSent)/seconds/1024/1024)
+	fmt.Printf("received:        %.2f MB/s\n", float64(bytesRecv)/seconds/1024/1024)
+	fmt.Printf("latency p50:     %v\n", percentile(latencies, 50))
+	fmt.Printf("latency p90:     %v\n", percentile(latencies, 90))
+	fmt.Printf("latency p99:     %v\n", percentile(latencies, 99))
+	fmt.Printf("latency max:     %v\n", latencies[len(latencies)-1])
+	fmt.Printf("allocs/request:  %.1f\n", float64(mallocs)/float64(requests))
+	fmt.Printf("bytes/request:   %.0f\n", float64(allocated)/float64(requests))
+
+	if maxP99Latency > 0 {
+		if p99 := percentile(latencies, 99); p99 > maxP99Latency {
+			return fmt.Errorf("latency p99 %v is greater than %v", p99, maxP99Latency)
+		}
+	}
+	return nil
+}
+
+// percentile expects sorted latencies
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	idx := (len(latencies)*p + 99) / 100
+	if idx > 0 {
+		idx--
+	}
+	return latencies[idx]
+}
@@ -0,0 +1,31 @@
+package bench
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunBench(t *testing.T) {
+	a := assert.New(t)
+
+	connections, duration, api, requestSize, responseSize = 2, 200*time.Millisecond, "mixed", 128, 64
+	apiKeys, err := getApiKeys(api)
+	a.Nil(err)
+	a.Nil(runBench(apiKeys))
+
+	// the latency check fails the benchmark
+	maxP99Latency = time.Nanosecond
+	defer func() { maxP99Latency = 0 }()
+	a.NotNil(runBench(apiKeys))
+}
+
+func TestGetApiKeys(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := getApiKeys("metadata")
+	a.NotNil(err)
+	apiKeys, err := getApiKeys("produce")
+	a.Nil(err)
+	a.Equal([]int16{apiKeyProduce}, apiKeys)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/secrets"
+	"github.com/grepplabs/kafka-proxy/proxy"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var Agent = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the tunnel agent which connects the relay kafka-proxy to the brokers in the private network",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		SetLogger()
+
+		if err := c.ResolveSecrets(secrets.NewResolver().Resolve); err != nil {
+			return err
+		}
+		return c.ValidateTunnelAgent()
+	},
+	RunE: runAgent,
+}
+
+func init() {
+	Agent.Flags().StringVar(&c.Tunnel.Agent.RelayAddress, "relay-address", "", "Address (host:port) of the tunnel relay kafka-proxy")
+	Agent.Flags().StringVar(&c.Tunnel.Token, "tunnel-token", "", "Shared secret authenticating the agent to the relay")
+	Agent.Flags().DurationVar(&c.Tunnel.Agent.ReconnectInterval, "reconnect-interval", 5*time.Second, "How long to wait before reconnecting to the relay")
+	Agent.Flags().BoolVar(&c.Tunnel.Agent.TLS.Enable, "tls-enable", false, "Whether or not to use TLS when connecting to the relay")
+	Agent.Flags().StringVar(&c.Tunnel.Agent.TLS.CAChainCertFile, "tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file used to verify the relay")
+	Agent.Flags().StringVar(&c.Tunnel.Agent.TLS.ServerName, "tls-server-name", "", "Server name used to verify the relay certificate. If empty, host of the relay address is used")
+	Agent.Flags().BoolVar(&c.Tunnel.Agent.TLS.InsecureSkipVerify, "tls-insecure-skip-verify", false, "It controls whether the agent verifies the relay's certificate chain and host name")
+	Agent.Flags().StringVar(&c.Tunnel.JoinToken, "join-token", "", "Shared secret used to request the client certificate from the relay CA if the agent has no valid certificate in cert-dir")
+	Agent.Flags().StringVar(&c.Tunnel.Agent.CertDir, "cert-dir", "", "Directory of the client certificate, key and relay CA received on join. If set, mutual TLS is used")
+	Agent.Flags().StringVar(&c.Tunnel.Agent.CAPin, "ca-pin", "", "SHA-256 of the relay CA certificate logged by the relay, it verifies the relay on join")
+
+	Agent.Flags().DurationVar(&c.Kafka.DialTimeout, "kafka-dial-timeout", 15*time.Second, "How long to wait for the initial connection")
+	Agent.Flags().DurationVar(&c.Kafka.KeepAlive, "kafka-keep-alive", 60*time.Second, "Keep alive period for an active network connection. If zero, keep-alives are disabled")
+	Agent.Flags().StringArrayVar(&c.Resolver.Servers, "resolver-server", []string{}, "DNS server address (host:port) used to resolve broker names. If not set, system resolver is used")
+	Agent.Flags().StringArrayVar(&c.Resolver.Hosts, "resolver-host", []string{}, "Static resolver override in form 'host=ip(,ip)'")
+
+	Agent.Flags().StringVar(&c.Log.Format, "log-format", "text", "Log format text or json")
+	Agent.Flags().StringVar(&c.Log.Level, "log-level", "info", "Log level debug, info, warning, error, fatal or panic")
+}
+
+func runAgent(_ *cobra.Command, _ []string) error {
+	logrus.Infof("Starting kafka-proxy tunnel agent version %s", config.Version)
+
+	agent, err := proxy.NewTunnelAgent(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logrus.Infof("Received signal %s", sig)
+		cancel()
+	}()
+	return agent.Run(ctx)
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/proxy"
@@ -16,11 +20,16 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 
 	"errors"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	certverifier "github.com/grepplabs/kafka-proxy/plugin/cert-verifier/shared"
+	"github.com/grepplabs/kafka-proxy/plugin/discovery"
+	keysigner "github.com/grepplabs/kafka-proxy/plugin/key-signer/shared"
 	localauth "github.com/grepplabs/kafka-proxy/plugin/local-auth/shared"
 	tokeninfo "github.com/grepplabs/kafka-proxy/plugin/token-info/shared"
 	tokenprovider "github.com/grepplabs/kafka-proxy/plugin/token-provider/shared"
@@ -28,8 +37,11 @@ import (
 	"github.com/hashicorp/go-plugin"
 	"strings"
 
+	"github.com/grepplabs/kafka-proxy/pkg/libs/secrets"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/service"
 	"github.com/grepplabs/kafka-proxy/pkg/registry"
 	// built-in plugins
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/azure-provider"
 	_ "github.com/grepplabs/kafka-proxy/pkg/libs/googleid-info"
 	_ "github.com/grepplabs/kafka-proxy/pkg/libs/googleid-provider"
 	"github.com/spf13/viper"
@@ -40,6 +52,19 @@ var (
 
 	bootstrapServersMapping = make([]string, 0)
 	externalServersMapping  = make([]string, 0)
+
+	clustersConfigFile    string
+	listenerTLSConfigFile string
+	// configurations of the additional clusters
+	clusterConfigs []*config.Config
+	// refreshes the upstream SASL password, nil if the password is not a refreshed secret reference
+	saslPasswordRefresher *secrets.Refresher
+	// shared session ticket keys are read again on every rotation
+	sessionTicketKeysRefresher *secrets.Refresher
+	// name of the Windows service, empty if the process is not run by the Service Control Manager
+	windowsServiceName string
+	// resolves the plugin commands to the verified binaries of the plugin directory, nil if the commands are launched as configured
+	pluginDiscovery *discovery.Discovery
 )
 
 var Server = &cobra.Command{
@@ -51,15 +76,69 @@ var Server = &cobra.Command{
 		if err := c.InitSASLCredentials(); err != nil {
 			return err
 		}
+		if listenerTLSConfigFile != "" {
+			listenerTLS, err := config.LoadListenerTLS(listenerTLSConfigFile)
+			if err != nil {
+				return err
+			}
+			c.Proxy.ListenerTLS = listenerTLS
+		}
+		resolver := secrets.NewResolver()
+		saslPasswordReference := c.Kafka.SASL.Password
+		sessionTicketKeysReference := c.Proxy.TLS.ListenerSessionTicketKeys
+		if err := c.ResolveSecrets(resolver.Resolve); err != nil {
+			return err
+		}
+		if c.Secrets.RefreshInterval > 0 && c.Kafka.SASL.JaasConfigFile == "" && resolver.IsReference(saslPasswordReference) {
+			saslPasswordRefresher = resolver.NewRefresher(saslPasswordReference, c.Kafka.SASL.Password)
+		}
+		if c.Proxy.TLS.ListenerSessionTicketKeyRotation > 0 && resolver.IsReference(sessionTicketKeysReference) {
+			sessionTicketKeysRefresher = resolver.NewRefresher(sessionTicketKeysReference, c.Proxy.TLS.ListenerSessionTicketKeys)
+		}
 		if err := c.InitBootstrapServers(getOrEnvStringSlice(bootstrapServersMapping, "BOOTSTRAP_SERVER_MAPPING")); err != nil {
 			return err
 		}
 		if err := c.InitExternalServers(getOrEnvStringSlice(externalServersMapping, "EXTERNAL_SERVER_MAPPING")); err != nil {
 			return err
 		}
+		if c.Proxy.ListenersStateFile != "" {
+			mappings, err := config.LoadListenerMappings(c.Proxy.ListenersStateFile)
+			if err != nil {
+				return err
+			}
+			// mappings changed with the admin API replace the configured ones
+			if mappings != nil {
+				logrus.Infof("Using listener mappings of %s", c.Proxy.ListenersStateFile)
+				if err := c.InitBootstrapServers(mappings.BootstrapServerMappings); err != nil {
+					return err
+				}
+				if err := c.InitExternalServers(mappings.ExternalServerMappings); err != nil {
+					return err
+				}
+			}
+		}
+		if err := c.ApplyKafkaPreset(); err != nil {
+			return err
+		}
 		if err := c.Validate(); err != nil {
 			return err
 		}
+		if clustersConfigFile != "" {
+			clusters, err := config.LoadClusters(clustersConfigFile)
+			if err != nil {
+				return err
+			}
+			for _, cluster := range clusters {
+				if err := cluster.ResolveSecrets(resolver.Resolve); err != nil {
+					return err
+				}
+				clusterConfig, err := c.ForCluster(cluster)
+				if err != nil {
+					return err
+				}
+				clusterConfigs = append(clusterConfigs, clusterConfig)
+			}
+		}
 		return nil
 	},
 	Run: Run,
@@ -82,13 +161,39 @@ func initFlags() {
 	Server.Flags().StringArrayVar(&bootstrapServersMapping, "bootstrap-server-mapping", []string{}, "Mapping of Kafka bootstrap server address to local address (host:port,host:port(,advhost:advport))")
 	Server.Flags().StringArrayVar(&externalServersMapping, "external-server-mapping", []string{}, "Mapping of Kafka server address to external address (host:port,host:port). A listener for the external address is not started")
 	Server.Flags().BoolVar(&c.Proxy.DisableDynamicListeners, "dynamic-listeners-disable", false, "Disable dynamic listeners.")
+	Server.Flags().StringVar(&c.Proxy.UnmappedBrokers, "unmapped-brokers", "", "Strategy for the brokers in the responses without a mapping: error, passthrough or auto-map. If empty auto-map, or error when the dynamic listeners are disabled")
+	Server.Flags().StringVar(&c.Proxy.DynamicPorts.Coordination, "dynamic-ports-coordination", "", "Coordination of the dynamic listener ports, so all replicas behind a load balancer advertise the same port for a broker: file, etcd, hash or broker-index (the port of the b<N>- brokers is the min port plus N). If empty a random port is used")
+	Server.Flags().IntVar(&c.Proxy.DynamicPorts.MinPort, "dynamic-ports-min", 0, "Lowest port of the coordinated dynamic listeners")
+	Server.Flags().IntVar(&c.Proxy.DynamicPorts.MaxPort, "dynamic-ports-max", 0, "Highest port of the coordinated dynamic listeners")
+	Server.Flags().StringVar(&c.Proxy.DynamicPorts.File, "dynamic-ports-file", "", "YAML file with the assigned ports shared by the replicas, e.g. on a network file system. Required by the file coordination")
+	Server.Flags().StringArrayVar(&c.Proxy.DynamicPorts.EtcdEndpoints, "dynamic-ports-etcd-endpoint", []string{}, "URL of the etcd v3 JSON gateway storing the assigned ports, e.g. http://etcd:2379. Required by the etcd coordination")
+	Server.Flags().StringVar(&c.Proxy.DynamicPorts.EtcdPrefix, "dynamic-ports-etcd-prefix", "/kafka-proxy/dynamic-ports", "Prefix of the etcd keys of the assigned ports")
+	Server.Flags().DurationVar(&c.Proxy.DynamicPorts.Timeout, "dynamic-ports-timeout", 5*time.Second, "How long to wait for the etcd requests or the lock of the ports file")
+	Server.Flags().StringVar(&c.Proxy.DynamicPorts.AdvertisedHost, "dynamic-advertised-host", "", "Host advertised for the dynamic listeners, e.g. the load balancer of the replicas. If empty the default listener IP is advertised")
+	Server.Flags().BoolVar(&c.Proxy.ListenersAdminEnable, "listeners-admin-enable", false, "Enable the HTTP admin API on the path /listeners to list (GET), add (POST) or remove (DELETE) bootstrap, external and dynamic server mappings and their listeners at runtime. Already accepted connections are not closed")
+	Server.Flags().StringVar(&c.Proxy.ListenersStateFile, "listeners-state-file", "", "YAML file the bootstrap and external server mappings changed with the listeners admin API are saved to. If the file exists, its mappings replace the configured ones on start")
+	Server.Flags().StringVar(&clustersConfigFile, "clusters-config-file", "", "YAML file with additional upstream clusters served by the same process, each with its own server mappings, TLS and SASL settings")
 
-	Server.Flags().IntVar(&c.Proxy.RequestBufferSize, "proxy-request-buffer-size", 4096, "Request buffer size pro tcp connection")
-	Server.Flags().IntVar(&c.Proxy.ResponseBufferSize, "proxy-response-buffer-size", 4096, "Response buffer size pro tcp connection")
+	Server.Flags().IntVar(&c.Proxy.RequestBufferSize, "proxy-request-buffer-size", 4096, "Request buffer size pro tcp connection. Requests up to the size are read from the client and written to the broker with a single syscall")
+	Server.Flags().IntVar(&c.Proxy.ResponseBufferSize, "proxy-response-buffer-size", 4096, "Response buffer size pro tcp connection. Responses up to the size are read from the broker and written to the client with a single syscall")
+	Server.Flags().IntVar(&c.Proxy.ResponseSpillThreshold, "proxy-response-spill-threshold", 0, "Responses bigger than the threshold which are inspected or rewritten by the proxy, e.g. Fetch responses of huge batches, are read into memory-mapped temporary files instead of the heap. If zero, responses are always read into the heap")
+	Server.Flags().StringVar(&c.Proxy.ResponseSpillDir, "proxy-response-spill-dir", "", "Directory of the memory-mapped temporary files of the spilled responses. If empty, the temporary directory is used")
+	Server.Flags().StringVar(&c.Proxy.ConnectionEngine, "proxy-connection-engine", config.ConnectionEngineGoroutines, "Engine of the connections: goroutines or eventloop. The eventloop engine (Linux only) parks the goroutines of the idle plaintext connections and resumes them on the epoll readiness events, for very high counts of mostly idle connections")
+	Server.Flags().DurationVar(&c.Proxy.EventLoopIdleTime, "proxy-eventloop-idle-time", 5*time.Second, "The eventloop engine parks the requests or responses loop of a connection when no byte is received from the client or the broker for the time")
 
 	Server.Flags().IntVar(&c.Proxy.ListenerReadBufferSize, "proxy-listener-read-buffer-size", 0, "Size of the operating system's receive buffer associated with the connection. If zero, system default is used")
 	Server.Flags().IntVar(&c.Proxy.ListenerWriteBufferSize, "proxy-listener-write-buffer-size", 0, "Sets the size of the operating system's transmit buffer associated with the connection. If zero, system default is used")
 	Server.Flags().DurationVar(&c.Proxy.ListenerKeepAlive, "proxy-listener-keep-alive", 60*time.Second, "Keep alive period for an active network connection. If zero, keep-alives are disabled")
+	Server.Flags().StringArrayVar(&c.Proxy.RackAdvertisedHosts, "rack-advertised-host", []string{}, "Host advertised to the clients connecting from the network, e.g. the proxy in the availability zone of the clients. Format: rack,cidr,advertised host")
+	Server.Flags().StringArrayVar(&c.Proxy.ListenerAllowedCIDRs, "proxy-listener-allow-cidr", []string{}, "Accept connections only from the source network (cidr or listenerAddress=cidr). Listener specific networks replace the global ones")
+	Server.Flags().StringArrayVar(&c.Proxy.ListenerDeniedCIDRs, "proxy-listener-deny-cidr", []string{}, "Reject connections from the source network (cidr or listenerAddress=cidr). Listener specific networks replace the global ones")
+	Server.Flags().Float64Var(&c.Proxy.ListenerAcceptRate, "proxy-listener-accept-rate", 0, "Maximal number of connections accepted per second pro listener. If zero, accept rate is not limited")
+	Server.Flags().IntVar(&c.Proxy.ListenerAcceptBurst, "proxy-listener-accept-burst", 10, "Number of connections which can be accepted at once when accept rate is limited")
+	Server.Flags().StringArrayVar(&c.Proxy.ListenerUnixSockets, "proxy-listener-unix-socket", []string{}, "Accept local connections to the broker of the listener also on the Unix socket (listenerAddress=socket path). TLS is not used on Unix sockets")
+	Server.Flags().StringVar(&c.Proxy.Socks5.ListenAddress, "proxy-socks5-listen-address", "", "Accept SOCKS5 connections of the clients on the address, e.g. 0.0.0.0:1080. The clients can CONNECT only to the mapped broker or advertised addresses")
+	Server.Flags().StringVar(&c.Proxy.Socks5.Username, "proxy-socks5-username", "", "Username the SOCKS5 clients authenticate with. If empty, the clients are not authenticated")
+	Server.Flags().StringVar(&c.Proxy.Socks5.Password, "proxy-socks5-password", "", "Password the SOCKS5 clients authenticate with")
+	Server.Flags().DurationVar(&c.Proxy.Socks5.HandshakeTimeout, "proxy-socks5-handshake-timeout", 10*time.Second, "How long to wait for the SOCKS5 authentication and CONNECT request of the clients")
 
 	Server.Flags().BoolVar(&c.Proxy.TLS.Enable, "proxy-listener-tls-enable", false, "Whether or not to use TLS listener")
 	Server.Flags().StringVar(&c.Proxy.TLS.ListenerCertFile, "proxy-listener-cert-file", "", "PEM encoded file with server certificate")
@@ -96,15 +201,48 @@ func initFlags() {
 	Server.Flags().StringVar(&c.Proxy.TLS.ListenerKeyPassword, "proxy-listener-key-password", "", "Password to decrypt rsa private key")
 	Server.Flags().StringVar(&c.Proxy.TLS.CAChainCertFile, "proxy-listener-ca-chain-cert-file", "", "PEM encoded CA's certificate file. If provided, client certificate is required and verified")
 	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCipherSuites, "proxy-listener-cipher-suites", []string{}, "List of supported cipher suites")
-	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCurvePreferences, "proxy-listener-curve-preferences", []string{}, "List of curve preferences")
+	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCurvePreferences, "proxy-listener-curve-preferences", []string{}, "List of curve preferences, the post-quantum hybrid X25519MLKEM768 and X25519Kyber768Draft00 are opt-in")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerClientAuth, "proxy-listener-client-auth", "", "Client certificate policy: none, request-any (requested, invalid certificates are logged), warn (verified if given, clients without certificate are logged), request (verified if given) or require. If empty, require when proxy-listener-ca-chain-cert-file is provided and none otherwise")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerMinVersion, "proxy-listener-tls-min-version", "", "Minimal TLS version: 1.0, 1.1, 1.2 or 1.3. If empty, 1.2 is used")
+	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerALPNProtocols, "proxy-listener-alpn-protocols", []string{}, "Protocols accepted in the TLS ALPN extension of the clients")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerALPNRequired, "proxy-listener-alpn-required", false, "Reject the TLS handshake of the clients which offer none of the proxy-listener-alpn-protocols")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerSessionTicketsDisabled, "proxy-listener-tls-session-tickets-disable", false, "Disable the TLS session resumption with session tickets on the listeners")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerSessionTicketKeys, "proxy-listener-tls-session-ticket-keys", "", "Session ticket keys shared by the proxy replicas, hex or base64 encoded 32 byte keys one per line. The first key encrypts new tickets. Usually a secret reference e.g. file:/path/to/keys or aws-sm:name")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerSessionTicketKeyRotation, "proxy-listener-tls-session-ticket-key-rotation", 0, "Interval of the session ticket key rotation. Shared keys are read again, otherwise a new random key is generated. If zero, keys are not rotated")
+	Server.Flags().StringVar(&listenerTLSConfigFile, "proxy-listener-tls-config-file", "", "YAML file with TLS settings of the listeners overriding the global listener TLS settings, e.g. to require client certificates on an external listener and disable TLS on a localhost listener")
+	Server.Flags().IntVar(&c.Proxy.TLS.ListenerMaxConcurrentHandshakes, "proxy-listener-tls-max-concurrent-handshakes", 0, "Maximal number of concurrent TLS handshakes pro listener. If zero, handshakes are not limited")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerHandshakeTimeout, "proxy-listener-tls-handshake-timeout", 10*time.Second, "How long to wait for a free handshake slot and the TLS handshake when concurrent handshakes are limited")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerClientCertFingerprintsFile, "proxy-listener-client-cert-fingerprints-file", "", "File with the SHA-256 fingerprints of the allowed client certificates, one per line. If provided, a client certificate with an allowed fingerprint is required on all TLS listeners in addition to the CA verification")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerClientCertFingerprintsReloadInterval, "proxy-listener-client-cert-fingerprints-reload-interval", 30*time.Second, "How often the client certificate fingerprints file is reloaded. If zero, the file is not reloaded")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ACME.Enable, "proxy-listener-tls-acme-enable", false, "Obtain and renew the certificate of the TLS listeners without cert file from an ACME CA, e.g. Let's Encrypt")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.DirectoryURL, "proxy-listener-tls-acme-directory-url", config.ACMELetsEncryptDirectoryURL, "URL of the ACME directory of the CA")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.Email, "proxy-listener-tls-acme-email", "", "Contact email of the ACME account")
+	Server.Flags().StringArrayVar(&c.Proxy.TLS.ACME.Hosts, "proxy-listener-tls-acme-host", []string{}, "Hostname of the ACME certificate. If empty, the advertised hostnames of the server mappings and dynamic listeners are used")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.Challenge, "proxy-listener-tls-acme-challenge", config.ACMEChallengeHTTP, "ACME challenge proving the control of the hosts. One of: http-01, dns-01")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.HTTPListenAddress, "proxy-listener-tls-acme-http-listen-address", "0.0.0.0:80", "Listen address for the http-01 challenges, the CA connects to port 80 of the hosts")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.DNSHookCommand, "proxy-listener-tls-acme-dns-hook-command", "", "Command creating and removing the dns-01 TXT records, called with present or cleanup, the record name and the value")
+	Server.Flags().StringVar(&c.Proxy.TLS.ACME.CacheDir, "proxy-listener-tls-acme-cache-dir", "", "Directory of the ACME account key and certificate kept across restarts")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ACME.RenewBefore, "proxy-listener-tls-acme-renew-before", 30*24*time.Hour, "Renew the ACME certificate the duration before it expires")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ACME.Timeout, "proxy-listener-tls-acme-timeout", 5*time.Minute, "How long to wait for the ACME certificate to be issued")
 
 	// local authentication plugin
-	Server.Flags().BoolVar(&c.Auth.Local.Enable, "auth-local-enable", false, "Enable local SASL/PLAIN authentication performed by listener - SASL handshake will not be passed to kafka brokers")
+	Server.Flags().BoolVar(&c.Auth.Local.Enable, "auth-local-enable", false, "Enable local SASL authentication performed by listener - SASL handshake will not be passed to kafka brokers")
 	Server.Flags().StringVar(&c.Auth.Local.Command, "auth-local-command", "", "Path to authentication plugin binary")
 	Server.Flags().StringVar(&c.Auth.Local.Mechanism, "auth-local-mechanism", "PLAIN", "SASL mechanism used for local authentication: PLAIN or OAUTHBEARER")
+	Server.Flags().BoolVar(&c.Auth.Local.DowngradeProtection, "auth-local-downgrade-protection", false, "Accept only the strongest of the local SASL mechanisms, e.g. SCRAM but not PLAIN, on the connections without TLS, so the mechanism cannot be downgraded by a man in the middle")
+	Server.Flags().StringSliceVar(&c.Auth.Local.Mechanisms, "auth-local-mechanisms", []string{}, "SASL mechanisms advertised to the clients and verified locally: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER. If empty, auth-local-mechanism is used")
+	Server.Flags().StringVar(&c.Auth.Local.TokenCommand, "auth-local-token-command", "", "Path to OAUTHBEARER token authentication plugin binary. If empty, auth-local-command is used")
+	Server.Flags().StringArrayVar(&c.Auth.Local.TokenParameters, "auth-local-token-param", []string{}, "OAUTHBEARER token authentication plugin parameter")
+	Server.Flags().StringVar(&c.Auth.Local.ScramCredentialsFile, "auth-local-scram-credentials-file", "", "File with SCRAM credentials of the users, one per line in form 'username SCRAM-SHA-256=[salt=...,stored_key=...,server_key=...,iterations=4096]' or 'username SCRAM-SHA-512=[password=...]'. If empty, SCRAM credentials are looked up in the credential store of auth-local-command")
 	Server.Flags().StringArrayVar(&c.Auth.Local.Parameters, "auth-local-param", []string{}, "Authentication plugin parameter")
 	Server.Flags().StringVar(&c.Auth.Local.LogLevel, "auth-local-log-level", "trace", "Log level of the auth plugin")
 	Server.Flags().DurationVar(&c.Auth.Local.Timeout, "auth-local-timeout", 10*time.Second, "Authentication timeout")
+	Server.Flags().BoolVar(&c.Auth.Local.RevocationAdminEnable, "auth-local-revocation-admin-enable", false, "Enable the HTTP admin API on the path /revocations to list (GET), revoke (POST) or clear (DELETE) revoked principals and OAUTHBEARER token ids. Active connections of a revoked principal or token are closed and new authentications are rejected")
+	Server.Flags().DurationVar(&c.Auth.Local.RevocationTTL, "auth-local-revocation-ttl", 0, "Time after which a revocation expires. If 0, revocations are kept until cleared")
+
+	// unix peer authentication
+	Server.Flags().BoolVar(&c.Auth.UnixPeer.Enable, "auth-unix-peer-enable", false, "Authenticate the clients connected to the Unix socket listeners by the peer credentials (SO_PEERCRED). Connections of peers without a mapped principal are rejected, the local SASL authentication is skipped")
+	Server.Flags().StringArrayVar(&c.Auth.UnixPeer.Principals, "auth-unix-peer-principal", []string{}, "Principal of the Unix socket peers with the uid or gid. Format: uid:<uid>=principal or gid:<gid>=principal")
 
 	Server.Flags().BoolVar(&c.Auth.Gateway.Client.Enable, "auth-gateway-client-enable", false, "Enable gateway client authentication")
 	Server.Flags().StringVar(&c.Auth.Gateway.Client.Command, "auth-gateway-client-command", "", "Path to authentication plugin binary")
@@ -124,16 +262,172 @@ func initFlags() {
 
 	// kafka
 	Server.Flags().StringVar(&c.Kafka.ClientID, "kafka-client-id", "kafka-proxy", "An optional identifier to track the source of requests")
+	Server.Flags().StringVar(&c.Kafka.Preset, "kafka-preset", "", "Preset of the connection settings for a Kafka service: confluent-cloud (SASL_SSL with the API key and secret as SASL username and password), aiven (TLS with the project CA and a client certificate or SASL) or redpanda (SASL with SaslAuthenticate requests). If empty no preset is applied")
 	Server.Flags().IntVar(&c.Kafka.MaxOpenRequests, "kafka-max-open-requests", 256, "Maximal number of open requests pro tcp connection before sending on it blocks")
+	Server.Flags().IntVar(&c.Kafka.MaxInFlightRequests, "kafka-max-in-flight-requests", 0, "Maximal number of requests of a client connection sent to the broker and not answered yet, as max.in.flight.requests.per.connection of the clients. Further requests are not read from the client until a response is written. If zero, the requests are limited by --kafka-max-open-requests only")
 	Server.Flags().DurationVar(&c.Kafka.DialTimeout, "kafka-dial-timeout", 15*time.Second, "How long to wait for the initial connection")
 	Server.Flags().DurationVar(&c.Kafka.WriteTimeout, "kafka-write-timeout", 30*time.Second, "How long to wait for a transmit")
 	Server.Flags().DurationVar(&c.Kafka.ReadTimeout, "kafka-read-timeout", 30*time.Second, "How long to wait for a response")
 	Server.Flags().DurationVar(&c.Kafka.KeepAlive, "kafka-keep-alive", 60*time.Second, "Keep alive period for an active network connection. If zero, keep-alives are disabled")
 	Server.Flags().IntVar(&c.Kafka.ConnectionReadBufferSize, "kafka-connection-read-buffer-size", 0, "Size of the operating system's receive buffer associated with the connection. If zero, system default is used")
 	Server.Flags().IntVar(&c.Kafka.ConnectionWriteBufferSize, "kafka-connection-write-buffer-size", 0, "Sets the size of the operating system's transmit buffer associated with the connection. If zero, system default is used")
+	Server.Flags().StringVar(&c.Kafka.LocalAddress, "kafka-local-address", "", "IP or network interface the connections to the brokers are bound to, e.g. the egress address allowlisted by the firewall on multi-homed hosts. If empty the operating system chooses the source address")
+	Server.Flags().StringArrayVar(&c.Kafka.DialOptions, "kafka-dial-options", []string{}, "Dial options of a broker as 'broker address=option=value,...' overriding --kafka-dial-timeout, --kafka-keep-alive, --kafka-local-address and the use of the forward proxy, *:port matches all brokers on the port. Options are dial-timeout, keep-alive, forward-proxy (true or false) and local-address")
+	Server.Flags().StringArrayVar(&c.Kafka.SecurityProtocols, "kafka-security-protocol", []string{}, "Security protocol of the connections to a broker as 'broker address=protocol' overriding --tls-enable and --sasl-enable, *:port matches all brokers on the port. Protocol is PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL")
 
 	// http://kafka.apache.org/protocol.html#protocol_api_keys
 	Server.Flags().IntSliceVar(&c.Kafka.ForbiddenApiKeys, "forbidden-api-keys", []int{}, "Forbidden Kafka request types. The restriction should prevent some Kafka operations e.g. 20 - DeleteTopics")
+	Server.Flags().StringVar(&c.Rewrite.TopicPrefix, "rewrite-topic-prefix", "", "Prefix added to the topic names sent to the brokers and removed from the topic names returned to the clients. Topics without the prefix are not visible to the clients")
+	Server.Flags().StringVar(&c.Rewrite.GroupPrefix, "rewrite-group-prefix", "", "Prefix added to the consumer group ids sent to the brokers and removed from the group ids returned to the clients. Groups without the prefix are not listed to the clients")
+	Server.Flags().BoolVar(&c.Kafka.ReadOnly, "read-only", false, "Forbid Produce, topic, config, ACL, transactional and other mutating Kafka requests. Metadata, Fetch and offset requests are allowed")
+	Server.Flags().StringArrayVar(&c.Kafka.ForbiddenApiVersions, "forbidden-api-versions", []string{}, "Forbidden Kafka request versions in form 'apiKey=minVersion-maxVersion', 'apiKey=version' or 'apiKey=minVersion-' e.g. 1=0-3 - old Fetch versions. Forbidden versions are not advertised in ApiVersions responses and their requests are answered with UNSUPPORTED_VERSION")
+
+	// schema validation
+	Server.Flags().StringArrayVar(&c.SchemaValidation.Topics, "schema-validation-topic", []string{}, "Validate records produced to topics matching the regular expression against schema registry. Records must be serialized with a schema registered under the subject given as 'regexp=subject' or '<topic>-value' by default")
+	Server.Flags().StringVar(&c.SchemaValidation.Registry.URL, "schema-validation-registry-url", "", "Schema registry URL")
+	Server.Flags().StringVar(&c.SchemaValidation.Registry.Username, "schema-validation-registry-username", "", "Schema registry basic auth username")
+	Server.Flags().StringVar(&c.SchemaValidation.Registry.Password, "schema-validation-registry-password", "", "Schema registry basic auth password")
+	Server.Flags().DurationVar(&c.SchemaValidation.Registry.Timeout, "schema-validation-registry-timeout", 5*time.Second, "Schema registry request timeout")
+	Server.Flags().DurationVar(&c.SchemaValidation.Registry.CacheTTL, "schema-validation-cache-ttl", 5*time.Minute, "How long the subjects of the schema ids are cached")
+
+	// compression policy
+	Server.Flags().StringSliceVar(&c.Compression.AllowedCodecs, "compression-allowed-codecs", []string{}, "Compression codecs (none, gzip, snappy, lz4, zstd) allowed in the produced record batches. If empty all codecs are allowed")
+	Server.Flags().IntVar(&c.Compression.MaxUncompressedBatchSize, "compression-max-uncompressed-batch-size", 0, "Maximum size in bytes of produced uncompressed record batches. If 0 the size is not limited")
+	Server.Flags().IntVar(&c.Compression.MaxDecompressedSize, "compression-max-decompressed-size", 64*1024*1024, "Maximum size in bytes of the records decompressed by the proxy when the record batches are inspected")
+
+	// recompression
+	Server.Flags().StringVar(&c.Recompression.ProduceCodec, "recompression-produce-codec", "", "Recompress produced record batches sent to the brokers with the codec (none or gzip). Only uncompressed and gzip batches are recompressed, snappy, lz4 and zstd batches are sent unchanged. If empty the batches are not recompressed")
+	Server.Flags().StringVar(&c.Recompression.FetchCodec, "recompression-fetch-codec", "", "Recompress fetched record batches sent to the clients with the codec (none or gzip). Only uncompressed and gzip batches are recompressed, snappy, lz4 and zstd batches are sent unchanged. If empty the batches are not recompressed")
+	Server.Flags().StringArrayVar(&c.Recompression.ClientCodecs, "recompression-client-codec", []string{}, "Recompress uncompressed and gzip fetched record batches sent to the clients from the network with the codec (none or gzip) instead of the fetch codec, e.g. clients on constrained links. Format: cidr=codec")
+	Server.Flags().IntVar(&c.Recompression.GzipLevel, "recompression-gzip-level", gzip.DefaultCompression, "Gzip compression level of the recompressed record batches")
+
+	// record statistics
+	Server.Flags().BoolVar(&c.RecordStats.Enable, "record-stats-enable", false, "Export the histograms of the record batch sizes and records per request of the Produce requests and Fetch responses by topic")
+	Server.Flags().StringArrayVar(&c.RecordStats.Topics, "record-stats-topic", []string{}, "Regexp of the topics labelled by name in the record statistics, other topics are labelled as other. If empty all topics are labelled by name")
+
+	// topic metrics
+	Server.Flags().BoolVar(&c.TopicMetrics.Enable, "topic-metrics-enable", false, "Count the Produce, Fetch and Metadata requests and the produced and fetched bytes per topic. Requires topic-metrics-topic or topic-metrics-hash-buckets")
+	Server.Flags().StringArrayVar(&c.TopicMetrics.Topics, "topic-metrics-topic", []string{}, "Regexp of the topics labelled by name in the topic metrics")
+	Server.Flags().IntVar(&c.TopicMetrics.HashBuckets, "topic-metrics-hash-buckets", 0, "Number of the hash buckets the topics which are not labelled by name are counted in. If 0 they are labelled as other")
+
+	// fault injection
+	Server.Flags().BoolVar(&c.Faults.Enable, "faults-enable", false, "Inject the configured faults into the requests to test the resiliency of the clients")
+	Server.Flags().BoolVar(&c.Faults.AdminEnable, "faults-admin-enable", false, "Enable the HTTP admin API on the path /faults to get (GET), change (PUT) or disable (DELETE) the injected faults at runtime")
+	Server.Flags().IntSliceVar(&c.Faults.ApiKeys, "faults-api-keys", []int{}, "Api keys of the requests affected by the faults. If empty all requests are affected")
+	Server.Flags().DurationVar(&c.Faults.Latency, "faults-latency", 0, "Latency added to the affected requests")
+	Server.Flags().DurationVar(&c.Faults.LatencyJitter, "faults-latency-jitter", 0, "Maximal random jitter added to the latency")
+	Server.Flags().Float64Var(&c.Faults.DropPercent, "faults-drop-percent", 0, "Percentage of the affected requests which close the client connection")
+	Server.Flags().Float64Var(&c.Faults.CorruptPercent, "faults-corrupt-percent", 0, "Percentage of the responses to the affected requests which bodies are corrupted")
+
+	// maintenance
+	Server.Flags().BoolVar(&c.Maintenance.AdminEnable, "maintenance-admin-enable", false, "Enable the HTTP admin API on the path /maintenance to list (GET), add (POST) or clear (DELETE) the maintenance rules rejecting the writes at runtime")
+	Server.Flags().StringArrayVar(&c.Maintenance.Topics, "maintenance-topic", []string{}, "Regular expression of the topics which Produce requests are rejected with POLICY_VIOLATION during a maintenance, the reads continue")
+	Server.Flags().IntSliceVar(&c.Maintenance.ApiKeys, "maintenance-api-keys", []int{}, "Api keys of the requests rejected with POLICY_VIOLATION during a maintenance")
+	Server.Flags().BoolVar(&c.Maintenance.Writes, "maintenance-writes", false, "Reject Produce and the other mutating requests forbidden by read-only with POLICY_VIOLATION during a maintenance, the reads continue")
+	Server.Flags().StringVar(&c.Maintenance.Message, "maintenance-message", "Writes are frozen for a maintenance", "Error message of the requests rejected by the maintenance rules")
+
+	// transactions
+	Server.Flags().StringArrayVar(&c.Transactions.AllowPrincipals, "transactions-allow-principal", []string{}, "Regular expression of the principals authenticated by the local SASL which are allowed to use transactional producers. If empty all principals which are not denied are allowed")
+	Server.Flags().StringArrayVar(&c.Transactions.DenyPrincipals, "transactions-deny-principal", []string{}, "Regular expression of the principals which requests of transactional producers are rejected. Not authenticated clients have an empty principal")
+
+	// bootstrap endpoints
+	Server.Flags().StringArrayVar(&c.Bootstrap.Endpoints, "bootstrap-endpoint", []string{}, "Endpoint to which the connections of the broker address are balanced with weighted round-robin, e.g. one of several load balancers of the cluster. Format: broker address,endpoint address(,weight)")
+	Server.Flags().DurationVar(&c.Bootstrap.DownTimeout, "bootstrap-endpoint-down-timeout", 30*time.Second, "How long a bootstrap endpoint which failed to connect is skipped")
+
+	// topology
+	Server.Flags().DurationVar(&c.Topology.RefreshInterval, "topology-refresh-interval", 0, "Interval of the background upstream metadata refresh which starts the dynamic listeners of new brokers. If 0 the metadata is not refreshed in the background")
+	Server.Flags().BoolVar(&c.Topology.RetireListeners, "topology-retire-listeners", false, "Close the dynamic listeners of the brokers which are not in the refreshed metadata")
+
+	// shutdown
+	Server.Flags().DurationVar(&c.Shutdown.Timeout, "shutdown-timeout", 0, "How long the connections are drained on SIGTERM. The connections are closed in batches between their requests, the remaining ones after the timeout. If 0 the connections are closed at once")
+	Server.Flags().StringVar(&c.Shutdown.AdvertisedHost, "shutdown-advertised-host", "", "Host advertised in the responses while the connections are drained, e.g. the service of the other proxy replicas. If empty the listener host is advertised")
+	Server.Flags().IntVar(&c.Shutdown.CloseBatchSize, "shutdown-close-batch-size", 10, "Maximal number of connections closed at once while draining")
+	Server.Flags().DurationVar(&c.Shutdown.CloseInterval, "shutdown-close-interval", time.Second, "Interval between the batches of the closed connections while draining")
+
+	// load shedding
+	Server.Flags().IntVar(&c.LoadShedding.MaxConnections, "load-shedding-max-connections", 0, "New connections are rejected while the number of the proxied connections reaches the limit. If 0 the connections are not limited")
+	Server.Flags().Float64Var(&c.LoadShedding.MaxCPU, "load-shedding-max-cpu", 0, "New connections are rejected while the CPU usage of the process in percent of all cores exceeds the limit. If 0 the CPU usage is not limited")
+	Server.Flags().DurationVar(&c.LoadShedding.CPUInterval, "load-shedding-cpu-interval", 5*time.Second, "How often the CPU usage of the process is sampled")
+	Server.Flags().StringVar(&c.LoadShedding.AdvertisedHost, "load-shedding-advertised-host", "", "Host advertised in the responses while new connections are rejected, e.g. the service of the other proxy replicas. If empty the listener host is advertised")
+
+	// service
+	Server.Flags().StringVar(&windowsServiceName, "windows-service-name", "", "Run as the Windows service with the name, started, stopped, paused and continued by the Service Control Manager. Pause rejects new connections")
+
+	// upstream
+	Server.Flags().StringArrayVar(&c.Upstream.SecondaryMapping, "upstream-secondary-mapping", []string{}, "Secondary cluster broker to which the connections of the primary broker are routed when the secondary cluster is active. Format: primary broker address,secondary broker address")
+	Server.Flags().StringVar(&c.Upstream.Active, "upstream-active", "primary", "Upstream cluster to which new connections are routed (primary or secondary)")
+	Server.Flags().BoolVar(&c.Upstream.AdminEnable, "upstream-admin-enable", false, "Enable the HTTP admin API on the path /upstream to get (GET) or switch (PUT) the active upstream cluster at runtime")
+	Server.Flags().DurationVar(&c.Upstream.DrainTimeout, "upstream-drain-timeout", 30*time.Second, "How long the connections to the previously active cluster are kept open after a switch with drain")
+	Server.Flags().Float64Var(&c.Upstream.CanaryPercent, "upstream-canary-percent", 0, "Percentage of the client ids which connections are routed to the secondary cluster while the primary cluster is active. The same client ids are routed to the secondary cluster by all connections and replicas")
+	Server.Flags().StringArrayVar(&c.Upstream.Routes, "upstream-route", []string{}, "Route the connections of the matching clients to the cluster regardless of the active cluster, the first matching route is used. Format: cluster:attribute=pattern, the attribute is cidr (client network), sni, cert-subject (verified client certificate) or principal (Unix socket peer or verified client certificate common name, not the local SASL principal)")
+
+	// mirror
+	Server.Flags().StringArrayVar(&c.Mirror.BootstrapServers, "mirror-bootstrap-server", []string{}, "Bootstrap server address of the secondary cluster to which the produce requests are asynchronously mirrored. If empty the requests are not mirrored")
+	Server.Flags().IntVar(&c.Mirror.QueueSize, "mirror-queue-size", 1000, "Number of the produce requests waiting to be mirrored. Requests are dropped when the queue is full")
+	Server.Flags().DurationVar(&c.Mirror.WriteTimeout, "mirror-write-timeout", 10*time.Second, "How long to wait for a transmit to the mirror cluster")
+	Server.Flags().DurationVar(&c.Mirror.ReadTimeout, "mirror-read-timeout", 10*time.Second, "How long to wait for a metadata response from the mirror cluster")
+	Server.Flags().DurationVar(&c.Mirror.MetadataRefreshInterval, "mirror-metadata-refresh-interval", time.Minute, "Interval of the mirror cluster metadata refresh")
+
+	// ack spoofing
+	Server.Flags().BoolVar(&c.AckSpoofing.Enable, "ack-spoofing-enable", false, "DANGEROUS: Acknowledge produce requests with acks 1 by the proxy and forward them to the brokers asynchronously with acks 0. Records are lost without notice to the producers when the queue is full or the brokers fail or reject them")
+	Server.Flags().StringArrayVar(&c.AckSpoofing.Topics, "ack-spoofing-topic", []string{}, "Regular expression of the topic names (as seen by the brokers) which produce requests are acknowledged by the proxy. If empty all topics")
+	Server.Flags().IntVar(&c.AckSpoofing.QueueSize, "ack-spoofing-queue-size", 100, "Number of the acknowledged produce requests of a connection waiting to be forwarded. Requests are dropped when the queue is full")
+
+	// api versions cache
+	Server.Flags().DurationVar(&c.ApiVersionsCache.TTL, "api-versions-cache-ttl", 0, "Cache the api versions responses of the upstream cluster for the TTL and answer the api versions requests of the new connections by the proxy. If zero, responses are not cached")
+
+	// metadata cache
+	Server.Flags().DurationVar(&c.MetadataCache.TTL, "metadata-cache-ttl", 0, "Cache the metadata responses by the principal and the requested topics for the TTL and answer the repeated requests by the proxy. If zero, responses are not cached")
+	Server.Flags().IntVar(&c.MetadataCache.MaxEntries, "metadata-cache-max-entries", 10000, "Maximal number of the cached metadata responses")
+
+	// outage buffer
+	Server.Flags().StringVar(&c.OutageBuffer.Dir, "outage-buffer-dir", "", "Directory of the produce requests buffered by the proxy while all brokers are unreachable. The requests are acknowledged by the proxy and replayed when the brokers are reachable again. If empty, requests are not buffered")
+	Server.Flags().Int64Var(&c.OutageBuffer.MaxBytes, "outage-buffer-max-bytes", 256*1024*1024, "Size of the buffered produce requests of all brokers in bytes after which the connections are closed instead")
+	Server.Flags().DurationVar(&c.OutageBuffer.Window, "outage-buffer-window", time.Minute, "How long the produce requests are buffered after all brokers became unreachable")
+	Server.Flags().DurationVar(&c.OutageBuffer.RetryInterval, "outage-buffer-retry-interval", time.Second, "Interval of the connection attempts to the brokers with buffered produce requests")
+
+	// capture
+	Server.Flags().BoolVar(&c.Capture.Enable, "capture-enable", false, "Capture the sampled requests and their responses as JSON lines for debugging")
+	Server.Flags().StringVar(&c.Capture.File, "capture-file", "kafka-proxy-capture.jsonl", "Path of the capture file")
+	Server.Flags().IntVar(&c.Capture.MaxFileSize, "capture-max-file-size", 100*1024*1024, "Size of the capture file in bytes after which it is rotated")
+	Server.Flags().IntVar(&c.Capture.MaxBackups, "capture-max-backups", 5, "Number of the rotated capture files to keep")
+	Server.Flags().Float64Var(&c.Capture.SamplePercent, "capture-sample-percent", 100, "Percentage of the matching requests which are captured")
+	Server.Flags().IntSliceVar(&c.Capture.ApiKeys, "capture-api-keys", []int{}, "Api keys of the captured requests. If empty all requests are captured")
+	Server.Flags().StringArrayVar(&c.Capture.ClientIDs, "capture-client-id", []string{}, "Regular expression of the client ids which requests are captured. If empty all client ids are captured")
+	Server.Flags().BoolVar(&c.Capture.Raw, "capture-raw", false, "Capture the base64 encoded frames of the requests and responses, not only the summaries")
+
+	// broker errors
+	Server.Flags().BoolVar(&c.BrokerErrors.Enable, "broker-errors-enable", false, "Count the Kafka error codes of the broker responses decoded by the proxy, e.g. Metadata and FindCoordinator, by broker, api key and error in the proxy_broker_errors_total metric")
+	Server.Flags().DurationVar(&c.BrokerErrors.LogInterval, "broker-errors-log-interval", time.Minute, "An error code of a broker and api key is logged at most once per interval. If 0 the errors are not logged")
+	Server.Flags().DurationVar(&c.SlowRequests.Threshold, "slow-request-threshold", 0, "Log the requests which take longer than the threshold from reading the request until the response was written to the client and count them in the proxy_slow_requests_total metric. If 0 the requests are not timed")
+	Server.Flags().DurationVar(&c.SlowRequests.LogInterval, "slow-request-log-interval", 10*time.Second, "At most one slow request is logged per interval, the number of the slow requests which were not logged is reported with the next log")
+	Server.Flags().Float64Var(&c.CorrelationLog.SamplePercent, "correlation-log-sample-percent", 0, "Percentage of the requests which are logged with the correlation id, client id and api key when received from the client and when the response is written back. If 0 the correlation ids are not logged")
+	Server.Flags().IntSliceVar(&c.CorrelationLog.ApiKeys, "correlation-log-api-keys", []int{}, "Api keys of the requests logged with the correlation id. If empty all requests are sampled")
+	Server.Flags().BoolVar(&c.TopTalkers.AdminEnable, "top-talkers-admin-enable", false, "Enable the HTTP admin API on the path /top-talkers to report (GET) the connections, principals or client ids with most bytes or requests within the sliding window")
+	Server.Flags().DurationVar(&c.TopTalkers.Window, "top-talkers-window", time.Minute, "Sliding window over which the bytes and requests of the top talkers are counted")
+	Server.Flags().BoolVar(&c.SLO.Enable, "slo-enable", false, "Compute the success rate and latency SLIs and the burn rates of the error budget over the rolling windows 5m, 30m, 1h and 6h and export them as the proxy_slo_ratio and proxy_slo_burn_rate metrics")
+	Server.Flags().BoolVar(&c.SLO.AdminEnable, "slo-admin-enable", false, "Enable the HTTP admin API on the path /slo to report (GET) the SLIs and burn rates of the rolling windows")
+	Server.Flags().Float64Var(&c.SLO.Objective, "slo-objective", 99.9, "Percentage of the good requests, the error budget is the remainder")
+	Server.Flags().DurationVar(&c.SLO.LatencyThreshold, "slo-latency-threshold", 500*time.Millisecond, "Requests answered slower than the threshold are bad for the latency SLI")
+	Server.Flags().BoolVar(&c.Kubernetes.PodMetadataEnable, "kubernetes-pod-metadata-enable", false, "Resolve the client IPs to the Kubernetes pods with the API server, log the pod name and namespace of the connections and count them by namespace and app label in the proxy_pod_connections_total metric")
+	Server.Flags().StringVar(&c.Kubernetes.APIServerURL, "kubernetes-api-server-url", "", "URL of the Kubernetes API server. If empty, the in-cluster API server is used")
+	Server.Flags().StringVar(&c.Kubernetes.TokenFile, "kubernetes-token-file", "/var/run/secrets/kubernetes.io/serviceaccount/token", "Bearer token file used to list the pods, the file is read on every lookup")
+	Server.Flags().StringVar(&c.Kubernetes.CAChainCertFile, "kubernetes-ca-chain-cert-file", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt", "PEM encoded CA's certificate file of the Kubernetes API server")
+	Server.Flags().StringVar(&c.Kubernetes.AppLabel, "kubernetes-app-label", "app.kubernetes.io/name", "Pod label used as the app label of the metrics")
+	Server.Flags().DurationVar(&c.Kubernetes.CacheTTL, "kubernetes-cache-ttl", 5*time.Minute, "How long the pod of a client IP is cached, including the IPs which are not resolved")
+	Server.Flags().DurationVar(&c.Kubernetes.Timeout, "kubernetes-timeout", 5*time.Second, "Timeout of the Kubernetes API server requests")
+	Server.Flags().StringVar(&c.OTLP.Endpoint, "otlp-endpoint", "", "Push the metrics to the OpenTelemetry collector host:port with OTLP/gRPC, alongside the Prometheus metrics path. If empty, the metrics are not pushed")
+	Server.Flags().BoolVar(&c.OTLP.Insecure, "otlp-insecure", false, "Connect to the OpenTelemetry collector without TLS")
+	Server.Flags().StringVar(&c.OTLP.CAChainCertFile, "otlp-ca-chain-cert-file", "", "PEM encoded CA's certificate file of the OpenTelemetry collector")
+	Server.Flags().StringArrayVar(&c.OTLP.Headers, "otlp-header", []string{}, "Header key=value sent with the exported metrics e.g. for the authentication. Repeat for more headers")
+	Server.Flags().StringVar(&c.OTLP.ServiceName, "otlp-service-name", "kafka-proxy", "The service.name resource attribute of the exported metrics")
+	Server.Flags().DurationVar(&c.OTLP.Interval, "otlp-interval", 30*time.Second, "Interval between the metric exports")
+	Server.Flags().DurationVar(&c.OTLP.Timeout, "otlp-timeout", 10*time.Second, "Timeout of a metric export")
+
+	// record fields
+	Server.Flags().StringArrayVar(&c.RecordFields.Redact, "record-redact-field", []string{}, "Redact the field of JSON records produced to topics matching the regular expression in form 'regexp=field path' e.g. '^orders$=customer.email'")
+	Server.Flags().StringArrayVar(&c.RecordFields.Encrypt, "record-encrypt-field", []string{}, "Encrypt the field of JSON records produced to topics matching the regular expression in form 'regexp=field path'. The field is decrypted in the fetched records")
+	Server.Flags().StringVar(&c.RecordFields.EncryptionKeyFile, "record-encryption-key-file", "", "File with base64 encoded 256 bit key used to encrypt the data keys of the encrypted record fields")
 
 	// TLS
 	Server.Flags().BoolVar(&c.Kafka.TLS.Enable, "tls-enable", false, "Whether or not to use TLS when connecting to the broker")
@@ -142,12 +436,46 @@ func initFlags() {
 	Server.Flags().StringVar(&c.Kafka.TLS.ClientKeyFile, "tls-client-key-file", "", "PEM encoded file with private key for the client certificate")
 	Server.Flags().StringVar(&c.Kafka.TLS.ClientKeyPassword, "tls-client-key-password", "", "Password to decrypt rsa private key")
 	Server.Flags().StringVar(&c.Kafka.TLS.CAChainCertFile, "tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file")
+	Server.Flags().StringArrayVar(&c.Kafka.TLS.BrokerCAChainCertFiles, "tls-broker-ca-chain-cert-file", []string{}, "PEM encoded CA's certificate file of a broker as 'broker address=file' overriding --tls-ca-chain-cert-file, *:port matches all brokers on the port")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.ALPNProtocols, "tls-alpn-protocols", []string{}, "Protocols offered to the Kafka brokers in the TLS ALPN extension")
+	Server.Flags().BoolVar(&c.Kafka.TLS.ALPNRequired, "tls-alpn-required", false, "Fail the connections to the Kafka brokers which select none of the tls-alpn-protocols")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.CurvePreferences, "tls-curve-preferences", []string{}, "List of curve preferences offered to the Kafka brokers e.g. X25519MLKEM768,X25519,P256. If empty, Go defaults are used")
+	Server.Flags().IntVar(&c.Kafka.TLS.SessionCacheSize, "tls-session-cache-size", 0, "Number of TLS sessions cached to resume the connections to the Kafka brokers. If zero, sessions are not resumed")
+
+	// certificate verifier plugin
+	Server.Flags().BoolVar(&c.CertVerifier.Enable, "cert-verifier-enable", false, "Verify the peer certificates with the plugin after the standard chain verification")
+	Server.Flags().StringVar(&c.CertVerifier.Command, "cert-verifier-command", "", "Path to the certificate verifier plugin binary")
+	Server.Flags().StringArrayVar(&c.CertVerifier.Parameters, "cert-verifier-param", []string{}, "Certificate verifier plugin parameter")
+	Server.Flags().StringVar(&c.CertVerifier.LogLevel, "cert-verifier-log-level", "trace", "Log level of the certificate verifier plugin")
+	Server.Flags().DurationVar(&c.CertVerifier.Timeout, "cert-verifier-timeout", 10*time.Second, "Certificate verification timeout")
+	Server.Flags().BoolVar(&c.CertVerifier.Listener, "cert-verifier-listener", true, "Verify the client certificates presented to the proxy listeners")
+	Server.Flags().BoolVar(&c.CertVerifier.Broker, "cert-verifier-broker", true, "Verify the certificates presented by the Kafka brokers")
+
+	Server.Flags().BoolVar(&c.KeySigner.Enable, "key-signer-enable", false, "Sign the TLS handshakes of the listener certificates without key file with the key signer plugin, e.g. with the key of a PKCS#11 token, HSM or cloud KMS")
+	Server.Flags().StringVar(&c.KeySigner.Command, "key-signer-command", "", "Path to the key signer plugin binary")
+	Server.Flags().StringArrayVar(&c.KeySigner.Parameters, "key-signer-param", []string{}, "Key signer plugin parameter")
+	Server.Flags().StringVar(&c.KeySigner.LogLevel, "key-signer-log-level", "trace", "Log level of the key signer plugin")
+	Server.Flags().DurationVar(&c.KeySigner.Timeout, "key-signer-timeout", 5*time.Second, "Signature timeout")
+
+	// plugin discovery
+	Server.Flags().StringVar(&c.Plugins.Dir, "plugins-dir", "", "Directory of the plugin binaries, the plugin commands can be the plugin names of its manifest. The binaries of the manifest are verified at startup and launch")
+	Server.Flags().StringVar(&c.Plugins.ManifestFile, "plugins-manifest-file", "", "JSON manifest with the checksums and signatures of the plugin binaries. If empty, manifest.json of the plugin directory is used")
+	Server.Flags().StringVar(&c.Plugins.PublicKeyFile, "plugins-public-key-file", "", "PEM encoded ed25519 public keys verifying the plugin signatures of the manifest")
+	Server.Flags().BoolVar(&c.Plugins.Hardened, "plugins-hardened", false, "Launch only the plugins of the manifest with a valid signature")
+
+	// FIPS
+	Server.Flags().BoolVar(&c.FIPS.Enable, "fips-enable", false, "Restrict TLS of the listeners, brokers and HTTP endpoints to the FIPS approved cipher suites, curves and versions and reject non-compliant TLS settings")
+	Server.Flags().BoolVar(&c.FIPS.RequireBoringCrypto, "fips-require-boringcrypto", false, "Do not start if the binary is not built with the FIPS validated BoringCrypto module")
 
 	// SASL by Proxy
 	Server.Flags().BoolVar(&c.Kafka.SASL.Enable, "sasl-enable", false, "Connect using SASL")
 	Server.Flags().StringVar(&c.Kafka.SASL.Username, "sasl-username", "", "SASL user name")
-	Server.Flags().StringVar(&c.Kafka.SASL.Password, "sasl-password", "", "SASL user password")
+	Server.Flags().StringVar(&c.Kafka.SASL.Password, "sasl-password", "", "SASL user password. Passwords and tokens can be secret references 'env:NAME', 'file:/path', 'aws-sm:secret-id[#key]', 'gcp-sm:projects/<project>/secrets/<secret>[#key]' or 'vault:<path>#<key>'")
+	Server.Flags().DurationVar(&c.Secrets.RefreshInterval, "secrets-refresh-interval", 0, "Interval of refreshing the SASL password given as a secret reference, new upstream connections use the refreshed password. If 0, secrets are resolved only at startup")
 	Server.Flags().StringVar(&c.Kafka.SASL.JaasConfigFile, "sasl-jaas-config-file", "", "Location of JAAS config file with SASL username and password")
+	Server.Flags().StringVar(&c.Kafka.SASL.Version, "sasl-version", config.SASLVersionAuto, "SASL handshake version: v0 (raw authentication bytes), v1 (SaslAuthenticate requests) or auto to negotiate the version with ApiVersions request")
+	Server.Flags().DurationVar(&c.Kafka.SASL.HandshakeTimeout, "sasl-handshake-timeout", 10*time.Second, "Timeout of the SASL version negotiation and handshake")
+	Server.Flags().DurationVar(&c.Kafka.SASL.AuthenticateTimeout, "sasl-authenticate-timeout", 10*time.Second, "Timeout of the SASL authentication after the handshake")
 
 	// SASL by Proxy plugin
 	Server.Flags().BoolVar(&c.Kafka.SASL.Plugin.Enable, "sasl-plugin-enable", false, "Use plugin for SASL authentication")
@@ -160,8 +488,20 @@ func initFlags() {
 	// Web
 	Server.Flags().BoolVar(&c.Http.Disable, "http-disable", false, "Disable HTTP endpoints")
 	Server.Flags().StringVar(&c.Http.ListenAddress, "http-listen-address", "0.0.0.0:9080", "Address that kafka-proxy is listening on")
-	Server.Flags().StringVar(&c.Http.MetricsPath, "http-metrics-path", "/metrics", "Path on which to expose metrics")
+	Server.Flags().StringVar(&c.Http.MetricsPath, "http-metrics-path", "/metrics", "Path on which to expose metrics. If empty, the metrics are not exposed e.g. if they are pushed over OTLP only")
 	Server.Flags().StringVar(&c.Http.HealthPath, "http-health-path", "/health", "Path on which to health endpoint")
+	Server.Flags().StringVar(&c.Http.BrokersPath, "http-brokers-path", "/brokers", "Path on which to list the broker address mappings. If empty the mappings are not listed")
+	Server.Flags().StringVar(&c.Http.AdminListenAddress, "http-admin-listen-address", "", "Address on which the admin API is served. If empty, the admin API is served on http-listen-address")
+	Server.Flags().StringArrayVar(&c.Http.Auth.BearerTokens, "http-auth-bearer-token", []string{}, "Static bearer token accepted by the HTTP endpoints except the health endpoint")
+	Server.Flags().StringVar(&c.Http.Auth.BearerTokenFile, "http-auth-bearer-token-file", "", "File with static bearer tokens accepted by the HTTP endpoints, one per line")
+	Server.Flags().StringVar(&c.Http.Auth.OIDCIssuerURL, "http-auth-oidc-issuer-url", "", "OIDC issuer which RS256 signed JWT bearer tokens are accepted by the HTTP endpoints. The signing keys are discovered with the openid-configuration of the issuer")
+	Server.Flags().StringArrayVar(&c.Http.Auth.OIDCAudience, "http-auth-oidc-audience", []string{}, "Audience required in the OIDC bearer tokens. If empty, audience is not checked")
+	Server.Flags().DurationVar(&c.Http.Auth.OIDCRefreshInterval, "http-auth-oidc-refresh-interval", time.Hour, "Interval of refreshing the OIDC signing keys")
+	Server.Flags().BoolVar(&c.Http.TLS.Enable, "http-tls-enable", false, "Whether or not to serve the HTTP endpoints with TLS")
+	Server.Flags().StringVar(&c.Http.TLS.ListenerCertFile, "http-tls-cert-file", "", "PEM encoded file with the HTTP server certificate")
+	Server.Flags().StringVar(&c.Http.TLS.ListenerKeyFile, "http-tls-key-file", "", "PEM encoded file with private key for the HTTP server certificate")
+	Server.Flags().StringVar(&c.Http.TLS.ListenerKeyPassword, "http-tls-key-password", "", "Password to decrypt rsa private key")
+	Server.Flags().StringVar(&c.Http.TLS.CAChainCertFile, "http-tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file. If provided, client certificate is required and verified")
 
 	// Debug
 	Server.Flags().BoolVar(&c.Debug.Enabled, "debug-enable", false, "Enable Debug endpoint")
@@ -171,8 +511,72 @@ func initFlags() {
 	Server.Flags().StringVar(&c.Log.Format, "log-format", "text", "Log format text or json")
 	Server.Flags().StringVar(&c.Log.Level, "log-level", "info", "Log level debug, info, warning, error, fatal or panic")
 
+	// Client ID
+	Server.Flags().StringArrayVar(&c.ClientID.Deny, "client-id-deny", []string{}, "Regular expression of client ids which requests are rejected")
+	Server.Flags().StringArrayVar(&c.ClientID.Throttle, "client-id-throttle", []string{}, "Limit requests of client ids matching the regular expression in form 'regexp=requests per second'. The limit is shared by all matching connections")
+	Server.Flags().IntVar(&c.ClientID.MetricsLabelLimit, "client-id-metrics-label-limit", 100, "Maximal number of distinct client ids used as metrics label. Further client ids are reported as 'other'")
+	Server.Flags().StringVar(&c.OPA.URL, "opa-url", "", "Decision endpoint of the Open Policy Agent authorizing the requests e.g. http://127.0.0.1:8181/v1/data/kafka/authz/allow. The input carries the principal, client_id, api_key, api_version, topics and groups of the request. If empty the requests are not authorized by OPA")
+	Server.Flags().IntSliceVar(&c.OPA.ApiKeys, "opa-api-keys", []int{}, "Api keys of the requests authorized by OPA. If empty all requests are authorized")
+	Server.Flags().DurationVar(&c.OPA.Timeout, "opa-timeout", time.Second, "Timeout of the OPA decision request")
+	Server.Flags().DurationVar(&c.OPA.CacheTTL, "opa-cache-ttl", time.Minute, "Time the OPA decisions are cached for the same input. If 0 the decisions are not cached")
+	Server.Flags().IntVar(&c.OPA.CacheSize, "opa-cache-size", 10000, "Maximal number of the cached OPA decisions")
+	Server.Flags().BoolVar(&c.OPA.FailOpen, "opa-fail-open", false, "Allow the requests when the OPA decision cannot be evaluated. If false the connection is closed")
+	Server.Flags().StringArrayVar(&c.DryRun.Policies, "dry-run-policy", []string{}, "Evaluate the policy without rejecting or throttling the requests, the violations are logged and counted in the proxy_policy_dry_run_total metric: api-keys (forbidden api keys and read-only), client-id-deny, client-id-throttle, transactions, egress or opa")
+	Server.Flags().DurationVar(&c.DryRun.LogInterval, "dry-run-log-interval", time.Minute, "A violation of a policy evaluated in a dry run is logged at most once per interval, the number of the violations which were not logged is reported with the next log. If 0 the violations are not logged")
+
+	// Parse errors
+	Server.Flags().StringVar(&c.ParseErrors.Action, "parse-error-action", config.ParseErrorActionClose, "Handling of the requests and responses which cannot be parsed for the rewriting: close the connection, forward them unmodified or reject them with an error response")
+	Server.Flags().StringArrayVar(&c.ParseErrors.ApiKeyActions, "parse-error-api-key-action", []string{}, "Handling of the parse errors by api key in form apiKey=action e.g. 3=forward, overrides --parse-error-action")
+
+	// Rejections
+	Server.Flags().BoolVar(&c.Rejection.Enable, "rejection-enable", false, "Answer the requests rejected by the authentication, ACLs, limits or policies with an error response before the connection is closed")
+	Server.Flags().StringVar(&c.Rejection.Message, "rejection-message", "", "Error message of the rejected requests, {reason} is replaced by the reason of the rejection. If empty, the reason is used")
+	Server.Flags().StringArrayVar(&c.Rejection.ErrorCodes, "rejection-error-code", []string{}, "Error code of the rejected requests by reason auth, acl, limits or policy in form reason=code e.g. acl=29")
+
+	// Client software
+	Server.Flags().BoolVar(&c.ClientSoftware.MetricsEnable, "client-software-metrics-enable", false, "Count the ApiVersions requests and the client connections by the client software name and version (KIP-511) and ApiVersions version")
+	Server.Flags().IntVar(&c.ClientSoftware.MetricsLabelLimit, "client-software-metrics-label-limit", 100, "Maximal number of distinct client software names and versions used as metrics labels. Further ones are reported as 'other'")
+	Server.Flags().StringVar(&c.Telemetry.Mode, "telemetry-mode", config.TelemetryModeForward, "Client telemetry requests (KIP-714): forward to the brokers, strip the api keys from the ApiVersions responses or terminate at the proxy")
+	Server.Flags().DurationVar(&c.Telemetry.PushInterval, "telemetry-push-interval", 5*time.Minute, "Push interval of the client telemetry subscription returned by the proxy")
+	Server.Flags().IntVar(&c.Telemetry.MaxBytes, "telemetry-max-bytes", 1024*1024, "Maximal size of the client telemetry pushed to the proxy")
+	Server.Flags().StringArrayVar(&c.Telemetry.RequestedMetrics, "telemetry-requested-metric", []string{}, "Metric name prefix requested by the client telemetry subscription of the proxy. All metrics are requested if not set")
+	Server.Flags().BoolVar(&c.Telemetry.DeltaTemporality, "telemetry-delta-temporality", false, "Request the client telemetry metrics with delta instead of cumulative temporality")
+
+	// Egress
+	Server.Flags().StringArrayVar(&c.Egress.ClientIDLimits, "egress-client-id-limit", []string{}, "Limit the response bandwidth of each connection with client id matching the regular expression in form 'regexp=bytes per second(,burst bytes)'")
+	Server.Flags().StringArrayVar(&c.Egress.PrincipalLimits, "egress-principal-limit", []string{}, "Limit the response bandwidth of each connection with local SASL principal matching the regular expression in form 'regexp=bytes per second(,burst bytes)'. Principal limits take precedence over client id limits")
+
+	// Priority classes
+	Server.Flags().StringArrayVar(&c.Priority.Classes, "priority-class", []string{}, "Tag the connections of the matching clients with the priority class, the first matching rule is used and other connections are normal. Format: class:attribute=pattern, the class is high or low and the attribute is listener (local address of the connection), principal (local SASL or Unix socket peer) or client-id")
+	Server.Flags().IntVar(&c.Priority.MaxConcurrency, "priority-max-concurrency", 0, "Maximal number of requests and responses copied at once by all connections. The waiting ones get a free slot by their priority class, high before normal before low. If zero, the requests and responses are not scheduled")
+
+	// DNS resolver
+	Server.Flags().StringArrayVar(&c.Resolver.Servers, "resolver-server", []string{}, "DNS server address (host:port) used to resolve broker names. If not set, system resolver is used")
+	Server.Flags().StringArrayVar(&c.Resolver.Hosts, "resolver-host", []string{}, "Static resolver override in form 'host=ip(,ip)'")
+	Server.Flags().DurationVar(&c.Resolver.CacheTTL, "resolver-cache-ttl", 0, "Maximal time resolved addresses are cached. Record TTLs are respected when resolver-server is used. If zero, caching is disabled")
+	Server.Flags().DurationVar(&c.Resolver.Timeout, "resolver-timeout", 5*time.Second, "DNS query timeout")
+	Server.Flags().BoolVar(&c.Resolver.TLS.Enable, "resolver-tls-enable", false, "Whether or not to use DNS-over-TLS when connecting to the resolver servers")
+	Server.Flags().StringVar(&c.Resolver.TLS.ServerName, "resolver-tls-server-name", "", "Server name used to verify the DNS server certificate. If empty, host of the resolver server is used")
+	Server.Flags().BoolVar(&c.Resolver.TLS.InsecureSkipVerify, "resolver-tls-insecure-skip-verify", false, "It controls whether a client verifies the DNS server's certificate chain and host name")
+	Server.Flags().StringVar(&c.Resolver.TLS.CAChainCertFile, "resolver-tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file used to verify the DNS server")
+
 	// Connect through Socks5 or HTTP CONNECT to Kafka
-	Server.Flags().StringVar(&c.ForwardProxy.Url, "forward-proxy", "", "URL of the forward proxy. Supported schemas are socks5 and http")
+	Server.Flags().StringVar(&c.ForwardProxy.Url, "forward-proxy", "", "URL of the forward proxy. Supported schemas are socks5, socks5h and http. socks5 resolves the broker host names locally, socks5h sends them to the proxy for remote resolution")
+	Server.Flags().DurationVar(&c.ForwardProxy.ProbeInterval, "forward-proxy-probe-interval", 0, "How often the reachability and latency of the forward proxy are probed and exported as metrics. If 0, the probe is disabled")
+	Server.Flags().BoolVar(&c.ForwardProxy.Socks5Bind, "forward-proxy-socks5-bind", false, "Use the SOCKS5 BIND command, the remote side initiates the connection to the address bound by the SOCKS5 proxy instead of the proxy connecting to the broker")
+	Server.Flags().DurationVar(&c.ForwardProxy.Socks5BindTimeout, "forward-proxy-socks5-bind-timeout", 30*time.Second, "How long to wait for the remote side to connect to the address bound by the SOCKS5 proxy")
+	Server.Flags().StringVar(&c.ForwardProxy.Socks5BindNotifyUrl, "forward-proxy-socks5-bind-notify-url", "", "URL to which the broker and the address bound by the SOCKS5 proxy are posted as JSON e.g. to let a remote agent initiate the connection")
+
+	// Reach Kafka through the tunnels opened by the agents
+	Server.Flags().StringVar(&c.Tunnel.Relay.ListenAddress, "tunnel-relay-listen-address", "", "Address on which the tunnel agents are accepted. If set, the brokers are reached through the tunnels of the agents")
+	Server.Flags().StringVar(&c.Tunnel.Token, "tunnel-token", "", "Shared secret authenticating the tunnel agents")
+	Server.Flags().BoolVar(&c.Tunnel.Relay.TLS.Enable, "tunnel-relay-tls-enable", false, "Whether or not to use TLS for the tunnel agent connections")
+	Server.Flags().StringVar(&c.Tunnel.Relay.TLS.CertFile, "tunnel-relay-tls-cert-file", "", "PEM encoded file with server certificate of the tunnel relay")
+	Server.Flags().StringVar(&c.Tunnel.Relay.TLS.KeyFile, "tunnel-relay-tls-key-file", "", "PEM encoded file with private key of the tunnel relay")
+	Server.Flags().StringVar(&c.Tunnel.Relay.CA.CertFile, "tunnel-relay-ca-cert-file", "", "PEM encoded CA certificate file issuing the relay and agent certificates of the mutual TLS tunnels. The CA is created if the certificate and key files do not exist")
+	Server.Flags().StringVar(&c.Tunnel.Relay.CA.KeyFile, "tunnel-relay-ca-key-file", "", "PEM encoded CA private key file issuing the relay and agent certificates of the mutual TLS tunnels")
+	Server.Flags().DurationVar(&c.Tunnel.Relay.CA.AgentCertValidity, "tunnel-relay-agent-cert-validity", 365*24*time.Hour, "Validity of the certificates issued to the joining agents")
+	Server.Flags().StringVar(&c.Tunnel.JoinToken, "tunnel-join-token", "", "Shared secret authorizing the tunnel agents to request a certificate from the relay CA")
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
@@ -180,66 +584,117 @@ func initFlags() {
 
 func Run(_ *cobra.Command, _ []string) {
 	logrus.Infof("Starting kafka-proxy version %s", config.Version)
+	if c.FIPS.Enable {
+		if proxy.BoringCrypto {
+			logrus.Info("FIPS mode is enabled, the binary is built with BoringCrypto")
+		} else {
+			logrus.Warn("FIPS mode is enabled but the binary is not built with BoringCrypto, TLS is restricted to the FIPS approved settings only")
+		}
+	}
+
+	if c.Plugins.Dir != "" {
+		var err error
+		if pluginDiscovery, err = newPluginDiscovery(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
 
 	var localPasswordAuthenticator apis.PasswordAuthenticator
 	var localTokenAuthenticator apis.TokenInfo
+	var localScramCredentialStore apis.ScramCredentialStore
 	if c.Auth.Local.Enable {
-		switch c.Auth.Local.Mechanism {
-		case "PLAIN":
-			var err error
-			factory, ok := registry.GetComponent(new(apis.PasswordAuthenticatorFactory), c.Auth.Local.Command).(apis.PasswordAuthenticatorFactory)
-			if ok {
-				logrus.Infof("Using built-in '%s' PasswordAuthenticator for local PasswordAuthenticator", c.Auth.Local.Command)
-				localPasswordAuthenticator, err = factory.New(c.Auth.Local.Parameters)
-				if err != nil {
-					logrus.Fatal(err)
-				}
-			} else {
-				client := NewPluginClient(localauth.Handshake, localauth.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
-				defer client.Kill()
+		for _, mechanism := range c.LocalSASLMechanisms() {
+			switch mechanism {
+			case "PLAIN":
+				var err error
+				factory, ok := registry.GetComponent(new(apis.PasswordAuthenticatorFactory), c.Auth.Local.Command).(apis.PasswordAuthenticatorFactory)
+				if ok {
+					logrus.Infof("Using built-in '%s' PasswordAuthenticator for local PasswordAuthenticator", c.Auth.Local.Command)
+					localPasswordAuthenticator, err = factory.New(c.Auth.Local.Parameters)
+					if err != nil {
+						logrus.Fatal(err)
+					}
+				} else {
+					client := NewPluginClient(localauth.Handshake, localauth.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
+					defer client.Kill()
 
-				rpcClient, err := client.Client()
-				if err != nil {
-					logrus.Fatal(err)
+					rpcClient, err := client.Client()
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					raw, err := rpcClient.Dispense("passwordAuthenticator")
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					localPasswordAuthenticator, ok = raw.(apis.PasswordAuthenticator)
+					if !ok {
+						logrus.Fatal(errors.New("unsupported PasswordAuthenticator plugin type"))
+					}
 				}
-				raw, err := rpcClient.Dispense("passwordAuthenticator")
-				if err != nil {
-					logrus.Fatal(err)
+			case "OAUTHBEARER":
+				var err error
+				tokenCommand, tokenParameters := c.Auth.Local.Command, c.Auth.Local.Parameters
+				if c.Auth.Local.TokenCommand != "" {
+					tokenCommand, tokenParameters = c.Auth.Local.TokenCommand, c.Auth.Local.TokenParameters
 				}
-				localPasswordAuthenticator, ok = raw.(apis.PasswordAuthenticator)
-				if !ok {
-					logrus.Fatal(errors.New("unsupported PasswordAuthenticator plugin type"))
-				}
-			}
-		case "OAUTHBEARER":
-			var err error
-			factory, ok := registry.GetComponent(new(apis.TokenInfoFactory), c.Auth.Local.Command).(apis.TokenInfoFactory)
-			if ok {
-				logrus.Infof("Using built-in '%s' TokenInfo for local TokenAuthenticator", c.Auth.Local.Command)
+				factory, ok := registry.GetComponent(new(apis.TokenInfoFactory), tokenCommand).(apis.TokenInfoFactory)
+				if ok {
+					logrus.Infof("Using built-in '%s' TokenInfo for local TokenAuthenticator", tokenCommand)
 
-				localTokenAuthenticator, err = factory.New(c.Auth.Local.Parameters)
-				if err != nil {
-					logrus.Fatal(err)
-				}
-			} else {
-				client := NewPluginClient(tokeninfo.Handshake, tokeninfo.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
-				defer client.Kill()
+					localTokenAuthenticator, err = factory.New(tokenParameters)
+					if err != nil {
+						logrus.Fatal(err)
+					}
+				} else {
+					client := NewPluginClient(tokeninfo.Handshake, tokeninfo.PluginMap, c.Auth.Local.LogLevel, tokenCommand, tokenParameters)
+					defer client.Kill()
 
-				rpcClient, err := client.Client()
-				if err != nil {
-					logrus.Fatal(err)
+					rpcClient, err := client.Client()
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					raw, err := rpcClient.Dispense("tokenInfo")
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					localTokenAuthenticator, ok = raw.(apis.TokenInfo)
+					if !ok {
+						logrus.Fatal(errors.New("unsupported TokenInfo plugin type"))
+					}
 				}
-				raw, err := rpcClient.Dispense("tokenInfo")
-				if err != nil {
-					logrus.Fatal(err)
+			case config.SASLScramSHA256, config.SASLScramSHA512:
+				if c.Auth.Local.ScramCredentialsFile != "" || localScramCredentialStore != nil {
+					// verified with the SCRAM credentials file or the store of the other SCRAM mechanism
+					continue
 				}
-				localTokenAuthenticator, ok = raw.(apis.TokenInfo)
-				if !ok {
-					logrus.Fatal(errors.New("unsupported TokenInfo plugin type"))
+				var err error
+				factory, ok := registry.GetComponent(new(apis.ScramCredentialStoreFactory), c.Auth.Local.Command).(apis.ScramCredentialStoreFactory)
+				if ok {
+					logrus.Infof("Using built-in '%s' ScramCredentialStore for local ScramCredentialStore", c.Auth.Local.Command)
+					localScramCredentialStore, err = factory.New(c.Auth.Local.Parameters)
+					if err != nil {
+						logrus.Fatal(err)
+					}
+				} else {
+					client := NewPluginClient(localauth.Handshake, localauth.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
+					defer client.Kill()
+
+					rpcClient, err := client.Client()
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					raw, err := rpcClient.Dispense("scramCredentialStore")
+					if err != nil {
+						logrus.Fatal(err)
+					}
+					localScramCredentialStore, ok = raw.(apis.ScramCredentialStore)
+					if !ok {
+						logrus.Fatal(errors.New("unsupported ScramCredentialStore plugin type"))
+					}
 				}
+			default:
+				logrus.Fatal(errors.New("unsupported local auth mechanism"))
 			}
-		default:
-			logrus.Fatal(errors.New("unsupported local auth mechanism"))
 		}
 	}
 
@@ -337,28 +792,210 @@ func Run(_ *cobra.Command, _ []string) {
 		}
 	}
 
+	var certificateVerifier apis.CertificateVerifier
+	if c.CertVerifier.Enable {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.CertificateVerifierFactory), c.CertVerifier.Command).(apis.CertificateVerifierFactory)
+		if ok {
+			logrus.Infof("Using built-in '%s' CertificateVerifier", c.CertVerifier.Command)
+
+			certificateVerifier, err = factory.New(c.CertVerifier.Parameters)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		} else {
+			client := NewPluginClient(certverifier.Handshake, certverifier.PluginMap, c.CertVerifier.LogLevel, c.CertVerifier.Command, c.CertVerifier.Parameters)
+			defer client.Kill()
+
+			rpcClient, err := client.Client()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			raw, err := rpcClient.Dispense("certificateVerifier")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			certificateVerifier, ok = raw.(apis.CertificateVerifier)
+			if !ok {
+				logrus.Fatal(errors.New("unsupported CertificateVerifier plugin type"))
+			}
+		}
+	}
+
+	var keySigner apis.KeySigner
+	if c.KeySigner.Enable {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.KeySignerFactory), c.KeySigner.Command).(apis.KeySignerFactory)
+		if ok {
+			logrus.Infof("Using built-in '%s' KeySigner", c.KeySigner.Command)
+
+			keySigner, err = factory.New(c.KeySigner.Parameters)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		} else {
+			client := NewPluginClient(keysigner.Handshake, keysigner.PluginMap, c.KeySigner.LogLevel, c.KeySigner.Command, c.KeySigner.Parameters)
+			defer client.Kill()
+
+			rpcClient, err := client.Client()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			raw, err := rpcClient.Dispense("keySigner")
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			keySigner, ok = raw.(apis.KeySigner)
+			if !ok {
+				logrus.Fatal(errors.New("unsupported KeySigner plugin type"))
+			}
+		}
+	}
+
+	faultInjector, err := proxy.NewFaultInjector(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	maintenance, err := proxy.NewMaintenance(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	upstreamSwitch, err := proxy.NewUpstreamSwitch(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	revocations, err := proxy.NewRevocations(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	topTalkers := proxy.NewTopTalkers(c)
+	slo := proxy.NewSLO(c)
+	if slo != nil {
+		prometheus.MustRegister(slo)
+	}
+	otlpExporter, err := proxy.NewOTLPExporter(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	var g group.Group
+	var proxies []*proxy.Proxy
+	// the HTTP endpoints are served until the connections are drained
+	var proxiesRunning sync.WaitGroup
 	{
 		// All active connections are stored in this variable.
 		connset := proxy.NewConnSet()
 		prometheus.MustRegister(proxy.NewCollector(connset))
-		listeners, err := proxy.NewListeners(c)
+		opts := []proxy.Option{
+			proxy.WithConnSet(connset),
+			proxy.WithFaultInjector(faultInjector),
+			proxy.WithMaintenance(maintenance),
+			proxy.WithRevocations(revocations),
+			proxy.WithTopTalkers(topTalkers),
+			proxy.WithSLO(slo),
+			proxy.WithLocalPasswordAuthenticator(localPasswordAuthenticator),
+			proxy.WithLocalTokenAuthenticator(localTokenAuthenticator),
+			proxy.WithLocalScramCredentialStore(localScramCredentialStore),
+			proxy.WithSASLTokenProvider(saslTokenProvider),
+			proxy.WithGatewayTokenProvider(gatewayTokenProvider),
+			proxy.WithGatewayTokenInfo(gatewayTokenInfo),
+			proxy.WithCertificateVerifier(certificateVerifier),
+			proxy.WithKeySigner(keySigner),
+			proxy.WithTelemetryExporter(otlpExporter),
+		}
+		if sessionTicketKeysRefresher != nil {
+			opts = append(opts, proxy.WithSessionTicketKeys(sessionTicketKeysRefresher.Value))
+		}
+		baseOpts := append(opts, proxy.WithUpstreamSwitch(upstreamSwitch))
+		if saslPasswordRefresher != nil {
+			baseOpts = append(baseOpts, proxy.WithSASLPassword(saslPasswordRefresher.Value))
+		}
+		p, err := proxy.New(c, baseOpts...)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		connSrc, err := listeners.ListenInstances(c.Proxy.BootstrapServers)
+		proxies = append(proxies, p)
+		ctx, cancel := context.WithCancel(context.Background())
+		proxiesRunning.Add(1)
+		g.Add(func() error {
+			defer proxiesRunning.Done()
+			logrus.Print("Ready for new connections")
+			return p.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+		if saslPasswordRefresher != nil {
+			g.Add(func() error {
+				return saslPasswordRefresher.Run(ctx, c.Secrets.RefreshInterval)
+			}, func(error) {
+				cancel()
+			})
+		}
+		if sessionTicketKeysRefresher != nil {
+			g.Add(func() error {
+				return sessionTicketKeysRefresher.Run(ctx, c.Proxy.TLS.ListenerSessionTicketKeyRotation)
+			}, func(error) {
+				cancel()
+			})
+		}
+		// additional clusters share the connection set, the authenticators and the fault injector
+		for _, clusterConfig := range clusterConfigs {
+			p, err := proxy.New(clusterConfig, opts...)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			proxies = append(proxies, p)
+			proxiesRunning.Add(1)
+			g.Add(func() error {
+				defer proxiesRunning.Done()
+				return p.Run(ctx)
+			}, func(error) {
+				cancel()
+			})
+		}
+	}
+	brokerTable := proxy.NewBrokerTable(proxies...)
+	listenersAdmin := proxy.NewListenersAdmin(c, proxies[0])
+	prometheus.MustRegister(brokerTable)
+	var windowsService *service.WindowsService
+	if windowsServiceName != "" {
+		windowsService, err = service.StartWindowsService(windowsServiceName, proxiesController(proxies))
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		proxyClient, err := proxy.NewClient(connset, c, listeners.GetNetAddressMapping, localPasswordAuthenticator, localTokenAuthenticator, saslTokenProvider, gatewayTokenProvider, gatewayTokenInfo)
+		cancelStop := make(chan struct{})
+		g.Add(func() error {
+			select {
+			case <-windowsService.StopRequested():
+				// a requested stop is reported to the Service Control Manager as a successful exit
+				logrus.Info("Stop requested by Windows Service Control Manager")
+				return nil
+			case <-cancelStop:
+				return nil
+			}
+		}, func(error) {
+			close(cancelStop)
+		})
+	}
+	{
+		// systemd is notified when the process starts and stops, the watchdog is kept alive until then
+		watchdogInterval, err := service.WatchdogInterval()
 		if err != nil {
 			logrus.Fatal(err)
 		}
+		stopping := make(chan struct{})
 		g.Add(func() error {
-			logrus.Print("Ready for new connections")
-			return proxyClient.Run(connSrc)
+			if watchdogInterval > 0 {
+				service.RunWatchdog(watchdogInterval, stopping)
+			} else {
+				<-stopping
+			}
+			return nil
 		}, func(error) {
-			proxyClient.Close()
+			if _, err := service.Notify(service.NotifyStopping); err != nil {
+				logrus.Warn(err)
+			}
+			close(stopping)
 		})
 	}
 	{
@@ -377,15 +1014,42 @@ func Run(_ *cobra.Command, _ []string) {
 		})
 	}
 	if !c.Http.Disable {
-		httpListener, err := net.Listen("tcp", c.Http.ListenAddress)
+		httpAuth, err := proxy.NewHTTPAuth(c)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		httpListener, err := newHTTPListener(c.Http.ListenAddress)
 		if err != nil {
 			logrus.Fatal(err)
 		}
 		g.Add(func() error {
-			return http.Serve(httpListener, NewHTTPHandler())
+			return http.Serve(httpListener, NewHTTPHandler(httpAuth, faultInjector, maintenance, upstreamSwitch, revocations, listenersAdmin, topTalkers, slo, brokerTable))
 		}, func(error) {
+			proxiesRunning.Wait()
 			httpListener.Close()
 		})
+		if c.Http.AdminListenAddress != "" {
+			adminListener, err := newHTTPListener(c.Http.AdminListenAddress)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			g.Add(func() error {
+				return http.Serve(adminListener, NewAdminHTTPHandler(httpAuth, faultInjector, maintenance, upstreamSwitch, revocations, listenersAdmin, topTalkers, slo))
+			}, func(error) {
+				proxiesRunning.Wait()
+				adminListener.Close()
+			})
+		}
+	}
+	if otlpExporter != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return otlpExporter.Run(ctx)
+		}, func(error) {
+			// the last metrics are pushed after the connections are drained
+			proxiesRunning.Wait()
+			cancel()
+		})
 	}
 	if c.Debug.Enabled {
 		// https://golang.org/pkg/net/http/pprof/
@@ -401,13 +1065,55 @@ func Run(_ *cobra.Command, _ []string) {
 		})
 	}
 
-	err := g.Run()
+	if _, err := service.Notify(service.NotifyReady); err != nil {
+		logrus.Warn(err)
+	}
+	err = g.Run()
 	logrus.Info("Exit ", err)
+	if windowsService != nil {
+		windowsService.Stopped(err)
+	}
+}
+
+// proxiesController pauses and continues all proxies of the process
+type proxiesController []*proxy.Proxy
+
+func (ps proxiesController) Pause() {
+	for _, p := range ps {
+		p.Pause()
+	}
+}
+
+func (ps proxiesController) Resume() {
+	for _, p := range ps {
+		p.Resume()
+	}
+}
+
+func newHTTPListener(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if c.Http.TLS.Enable {
+		tlsConfig, err := proxy.NewHTTPTLSConfig(c)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener, nil
 }
 
-func NewHTTPHandler() http.Handler {
+// NewHTTPHandler serves the health check without authentication, the admin API is served if Http.AdminListenAddress is empty
+func NewHTTPHandler(httpAuth *proxy.HTTPAuth, faultInjector *proxy.FaultInjector, maintenance *proxy.Maintenance, upstreamSwitch *proxy.UpstreamSwitch, revocations *proxy.Revocations, listenersAdmin *proxy.ListenersAdmin, topTalkers *proxy.TopTalkers, slo *proxy.SLO, brokerTable *proxy.BrokerTable) http.Handler {
 	m := http.NewServeMux()
 	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var metricsLink string
+		if c.Http.MetricsPath != "" {
+			metricsLink = `<p><a href='` + c.Http.MetricsPath + `'>Metrics</a></p>`
+		}
 		w.Write([]byte(
 			`<html>
 				<head>
@@ -415,18 +1121,56 @@ func NewHTTPHandler() http.Handler {
 				</head>
 				<body>
 					<h1>Kafka Proxy</h1>
-					<p><a href='` + c.Http.MetricsPath + `'>Metrics</a></p>
+					` + metricsLink + `
 				</body>
 	        </html>`))
 	})
 	m.HandleFunc(c.Http.HealthPath, func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`OK`))
 	})
-	m.Handle(c.Http.MetricsPath, promhttp.Handler())
+	if c.Http.MetricsPath != "" {
+		m.Handle(c.Http.MetricsPath, httpAuth.Handler(promhttp.Handler()))
+	}
+	if c.Http.BrokersPath != "" && brokerTable != nil {
+		m.Handle(c.Http.BrokersPath, httpAuth.Handler(brokerTable))
+	}
+	if c.Http.AdminListenAddress == "" {
+		handleAdmin(m, httpAuth, faultInjector, maintenance, upstreamSwitch, revocations, listenersAdmin, topTalkers, slo)
+	}
+	return m
+}
 
+// NewAdminHTTPHandler serves the admin API on the Http.AdminListenAddress
+func NewAdminHTTPHandler(httpAuth *proxy.HTTPAuth, faultInjector *proxy.FaultInjector, maintenance *proxy.Maintenance, upstreamSwitch *proxy.UpstreamSwitch, revocations *proxy.Revocations, listenersAdmin *proxy.ListenersAdmin, topTalkers *proxy.TopTalkers, slo *proxy.SLO) http.Handler {
+	m := http.NewServeMux()
+	handleAdmin(m, httpAuth, faultInjector, maintenance, upstreamSwitch, revocations, listenersAdmin, topTalkers, slo)
 	return m
 }
 
+func handleAdmin(m *http.ServeMux, httpAuth *proxy.HTTPAuth, faultInjector *proxy.FaultInjector, maintenance *proxy.Maintenance, upstreamSwitch *proxy.UpstreamSwitch, revocations *proxy.Revocations, listenersAdmin *proxy.ListenersAdmin, topTalkers *proxy.TopTalkers, slo *proxy.SLO) {
+	if c.Faults.AdminEnable && faultInjector != nil {
+		m.Handle("/faults", httpAuth.Handler(faultInjector))
+	}
+	if c.Maintenance.AdminEnable && maintenance != nil {
+		m.Handle("/maintenance", httpAuth.Handler(maintenance))
+	}
+	if c.Upstream.AdminEnable && upstreamSwitch != nil {
+		m.Handle("/upstream", httpAuth.Handler(upstreamSwitch))
+	}
+	if c.Auth.Local.RevocationAdminEnable && revocations != nil {
+		m.Handle("/revocations", httpAuth.Handler(revocations))
+	}
+	if c.Proxy.ListenersAdminEnable && listenersAdmin != nil {
+		m.Handle("/listeners", httpAuth.Handler(listenersAdmin))
+	}
+	if c.TopTalkers.AdminEnable && topTalkers != nil {
+		m.Handle("/top-talkers", httpAuth.Handler(topTalkers))
+	}
+	if c.SLO.AdminEnable && slo != nil {
+		m.Handle("/slo", httpAuth.Handler(slo))
+	}
+}
+
 func SetLogger() {
 	if c.Log.Format == "json" {
 		formatter := &logrus.JSONFormatter{
@@ -462,12 +1206,41 @@ func NewPluginClient(handshakeConfig plugin.HandshakeConfig, plugins map[string]
 		TimeFormat: time.RFC3339,
 	})
 
+	types := make([]string, 0, len(plugins))
+	for name := range plugins {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	discovered, err := pluginDiscovery.Resolve(command, types)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	return plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig: handshakeConfig,
 		Plugins:         plugins,
 		Logger:          logger,
-		Cmd:             exec.Command(command, params...),
+		Cmd:             exec.Command(discovered.Path, params...),
+		SecureConfig:    discovered.SecureConfig(),
 		AllowedProtocols: []plugin.Protocol{
 			plugin.ProtocolNetRPC, plugin.ProtocolGRPC},
 	})
 }
+
+func newPluginDiscovery() (*discovery.Discovery, error) {
+	var publicKeys []ed25519.PublicKey
+	if c.Plugins.PublicKeyFile != "" {
+		var err error
+		if publicKeys, err = discovery.LoadPublicKeys(c.Plugins.PublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	d, err := discovery.New(c.Plugins.Dir, c.Plugins.ManifestFile, publicKeys, c.Plugins.Hardened)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range d.Plugins() {
+		logrus.Infof("Discovered plugin %s: %s, signed %v", p.Name, p.Path, p.Signed)
+	}
+	return d, nil
+}
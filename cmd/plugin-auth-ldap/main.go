@@ -2,8 +2,10 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/plugin/local-auth/shared"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-plugin"
@@ -12,6 +14,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -86,6 +89,89 @@ func escapeLDAPValue(input string) string {
 	}
 	return input
 }
+
+// LdapScramCredentialStore looks up the salted SCRAM credentials stored in the attributes of the user entries
+// in the format of kafka-configs 'salt=base64,stored_key=base64,server_key=base64,iterations=number'
+type LdapScramCredentialStore struct {
+	LdapAuthenticator
+	BindDN     string
+	BindPasswd string
+	// attribute names by mechanism
+	ScramAttrs map[string]string
+}
+
+func (ps LdapScramCredentialStore) GetScramCredential(request apis.ScramCredentialRequest) (apis.ScramCredentialResponse, error) {
+	attr, ok := ps.ScramAttrs[request.Mechanism]
+	if !ok {
+		logrus.Errorf("user %s mechanism %s is not supported", request.Username, request.Mechanism)
+		return apis.ScramCredentialResponse{Status: 3}, nil
+	}
+	l, err := ps.DialLDAP()
+	if err != nil {
+		logrus.Errorf("user %s ldap dial error %v", request.Username, err)
+		return apis.ScramCredentialResponse{Status: 1}, nil
+	}
+	defer l.Close()
+
+	if ps.BindDN != "" {
+		if err = l.Bind(ps.BindDN, ps.BindPasswd); err != nil {
+			logrus.Errorf("ldap bind as %s error %v", ps.BindDN, err)
+			return apis.ScramCredentialResponse{Status: 2}, nil
+		}
+	}
+	result, err := l.Search(ldap.NewSearchRequest(ps.UserDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf("(%s=%s)", ps.UserAttr, ldap.EscapeFilter(request.Username)), []string{attr}, nil))
+	if err != nil {
+		logrus.Errorf("user %s ldap search error %v", request.Username, err)
+		return apis.ScramCredentialResponse{Status: 2}, nil
+	}
+	if len(result.Entries) != 1 {
+		logrus.Errorf("user %s ldap search returned %d entries", request.Username, len(result.Entries))
+		return apis.ScramCredentialResponse{}, nil
+	}
+	value := result.Entries[0].GetAttributeValue(attr)
+	if value == "" {
+		logrus.Errorf("user %s has no %s credential", request.Username, request.Mechanism)
+		return apis.ScramCredentialResponse{}, nil
+	}
+	credential, err := parseScramCredential(value)
+	if err != nil {
+		logrus.Errorf("user %s %s credential is invalid: %v", request.Username, request.Mechanism, err)
+		return apis.ScramCredentialResponse{Status: 4}, nil
+	}
+	return credential, nil
+}
+
+func parseScramCredential(value string) (apis.ScramCredentialResponse, error) {
+	credential := apis.ScramCredentialResponse{Found: true}
+	for _, attribute := range strings.Split(value, ",") {
+		kv := strings.SplitN(attribute, "=", 2)
+		if len(kv) != 2 {
+			return credential, fmt.Errorf("attribute %q must be in form 'name=value'", attribute)
+		}
+		var err error
+		switch kv[0] {
+		case "salt":
+			credential.Salt, err = base64.StdEncoding.DecodeString(kv[1])
+		case "stored_key":
+			credential.StoredKey, err = base64.StdEncoding.DecodeString(kv[1])
+		case "server_key":
+			credential.ServerKey, err = base64.StdEncoding.DecodeString(kv[1])
+		case "iterations":
+			var iterations int64
+			iterations, err = strconv.ParseInt(kv[1], 10, 32)
+			credential.Iterations = int32(iterations)
+		}
+		if err != nil {
+			return credential, fmt.Errorf("attribute %s: %v", kv[0], err)
+		}
+	}
+	if len(credential.Salt) == 0 || len(credential.StoredKey) == 0 || len(credential.ServerKey) == 0 || credential.Iterations < 1 {
+		return credential, fmt.Errorf("salt, stored_key, server_key and iterations are required")
+	}
+	return credential, nil
+}
+
 func (pa LdapAuthenticator) DialLDAP() (*ldap.Conn, error) {
 	var retErr *multierror.Error
 	var conn *ldap.Conn
@@ -134,11 +220,15 @@ func (pa LdapAuthenticator) DialLDAP() (*ldap.Conn, error) {
 }
 
 type pluginMeta struct {
-	url       string
-	startTLS  bool
-	upnDomain string
-	userDN    string
-	userAttr  string
+	url             string
+	startTLS        bool
+	upnDomain       string
+	userDN          string
+	userAttr        string
+	bindDN          string
+	bindPasswd      string
+	scramSHA256Attr string
+	scramSHA512Attr string
 }
 
 func (f *pluginMeta) flagSet() *flag.FlagSet {
@@ -149,6 +239,10 @@ func (f *pluginMeta) flagSet() *flag.FlagSet {
 	fs.StringVar(&f.upnDomain, "upn-domain", "", "Enables userPrincipalDomain login with [username]@UPNDomain (optional)")
 	fs.StringVar(&f.userDN, "user-dn", "", "LDAP domain to use for users (eg: cn=users,dc=example,dc=org)")
 	fs.StringVar(&f.userAttr, "user-attr", "uid", " Attribute used for users")
+	fs.StringVar(&f.bindDN, "bind-dn", "", "DN used to search the SCRAM credentials of the users (optional)")
+	fs.StringVar(&f.bindPasswd, "bind-passwd", "", "Password of the bind-dn (optional)")
+	fs.StringVar(&f.scramSHA256Attr, "scram-sha-256-attr", "", "Attribute with the SCRAM-SHA-256 credential of the users in form 'salt=...,stored_key=...,server_key=...,iterations=4096' (optional)")
+	fs.StringVar(&f.scramSHA512Attr, "scram-sha-512-attr", "", "Attribute with the SCRAM-SHA-512 credential of the users in form 'salt=...,stored_key=...,server_key=...,iterations=4096' (optional)")
 	return fs
 }
 
@@ -193,15 +287,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	authenticator := LdapAuthenticator{
+		Urls:      urls,
+		StartTLS:  pluginMeta.startTLS,
+		UPNDomain: pluginMeta.upnDomain,
+		UserDN:    pluginMeta.userDN,
+		UserAttr:  pluginMeta.userAttr,
+	}
+	scramAttrs := make(map[string]string)
+	if pluginMeta.scramSHA256Attr != "" {
+		scramAttrs["SCRAM-SHA-256"] = pluginMeta.scramSHA256Attr
+	}
+	if pluginMeta.scramSHA512Attr != "" {
+		scramAttrs["SCRAM-SHA-512"] = pluginMeta.scramSHA512Attr
+	}
+	if len(scramAttrs) != 0 && (pluginMeta.userDN == "" || pluginMeta.userAttr == "") {
+		logrus.Errorf("parameters user-dn and user-attr are required to look up SCRAM credentials")
+		os.Exit(1)
+	}
+
 	plugin.Serve(&plugin.ServeConfig{
 		HandshakeConfig: shared.Handshake,
 		Plugins: map[string]plugin.Plugin{
-			"passwordAuthenticator": &shared.PasswordAuthenticatorPlugin{Impl: &LdapAuthenticator{
-				Urls:      urls,
-				StartTLS:  pluginMeta.startTLS,
-				UPNDomain: pluginMeta.upnDomain,
-				UserDN:    pluginMeta.userDN,
-				UserAttr:  pluginMeta.userAttr,
+			"passwordAuthenticator": &shared.PasswordAuthenticatorPlugin{Impl: &authenticator},
+			"scramCredentialStore": &shared.ScramCredentialStorePlugin{Impl: &LdapScramCredentialStore{
+				LdapAuthenticator: authenticator,
+				BindDN:            pluginMeta.bindDN,
+				BindPasswd:        pluginMeta.bindPasswd,
+				ScramAttrs:        scramAttrs,
 			}},
 		},
 		// A non-nil value here enables gRPC serving for this plugin...
@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/plugin/local-auth/shared"
 	"github.com/hashicorp/go-plugin"
 	"github.com/sirupsen/logrus"
@@ -9,8 +11,11 @@ import (
 )
 
 type PasswordAuthenticator struct {
-	Username string
-	Password string
+	Username   string
+	Password   string
+	Attributes apis.SessionAttributes
+
+	maxConnections int
 }
 
 func (pa PasswordAuthenticator) Authenticate(username, password string) (bool, int32, error) {
@@ -18,10 +23,30 @@ func (pa PasswordAuthenticator) Authenticate(username, password string) (bool, i
 	return username == pa.Username && password == pa.Password, 0, nil
 }
 
+func (pa PasswordAuthenticator) AuthenticateWithAttributes(username, password string) (bool, int32, *apis.SessionAttributes, error) {
+	ok, status, err := pa.Authenticate(username, password)
+	return ok, status, &pa.Attributes, err
+}
+
+type stringsValue []string
+
+func (v *stringsValue) String() string {
+	return fmt.Sprint(*v)
+}
+
+func (v *stringsValue) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
 func (f *PasswordAuthenticator) flagSet() *flag.FlagSet {
 	fs := flag.NewFlagSet("auth plugin settings", flag.ContinueOnError)
 	fs.StringVar(&f.Username, "username", "", "Expected SASL username")
 	fs.StringVar(&f.Password, "password", "", "Expected SASL password")
+	fs.IntVar(&f.maxConnections, "max-connections", 0, "Maximal number of concurrent connections of the user (optional)")
+	fs.Float64Var(&f.Attributes.RequestsPerSecond, "requests-per-second", 0, "Maximal number of requests per second of each connection (optional)")
+	fs.Float64Var(&f.Attributes.RequestBytesPerSecond, "request-bytes-per-second", 0, "Maximal number of request bytes per second of each connection (optional)")
+	fs.Var((*stringsValue)(&f.Attributes.AllowedTopics), "allowed-topic", "Regular expression of the topics the user may access. If not set, all topics are allowed (optional)")
 	return fs
 }
 
@@ -33,6 +58,7 @@ func main() {
 		logrus.Errorf("parameters username and password are required")
 		os.Exit(1)
 	}
+	passwordAuthenticator.Attributes.MaxConnections = int32(passwordAuthenticator.maxConnections)
 
 	plugin.Serve(&plugin.ServeConfig{
 		HandshakeConfig: shared.Handshake,
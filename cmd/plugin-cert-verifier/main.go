@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/cert-verifier/shared"
+	"github.com/hashicorp/go-plugin"
+	"github.com/sirupsen/logrus"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	StatusOK               = 0
+	StatusInvalidCert      = 1
+	StatusMissingExtension = 2
+	StatusRevokedCert      = 3
+	StatusUnsupportedSide  = 4
+)
+
+// CertificateVerifier checks the custom extensions of the leaf certificates and rejects the serial numbers of an internal revocation list
+type CertificateVerifier struct {
+	requiredExtensions []asn1.ObjectIdentifier
+	revokedSerials     map[string]bool
+	sides              map[string]bool
+}
+
+func (v *CertificateVerifier) VerifyCertificate(ctx context.Context, request apis.CertificateVerifyRequest) (apis.CertificateVerifyResponse, error) {
+	if len(v.sides) != 0 && !v.sides[request.Side] {
+		return apis.CertificateVerifyResponse{Success: true, Status: StatusOK}, nil
+	}
+	if len(request.RawCerts) == 0 {
+		return apis.CertificateVerifyResponse{Success: false, Status: StatusInvalidCert}, nil
+	}
+	cert, err := x509.ParseCertificate(request.RawCerts[0])
+	if err != nil {
+		logrus.Warnf("invalid %s certificate: %v", request.Side, err)
+		return apis.CertificateVerifyResponse{Success: false, Status: StatusInvalidCert}, nil
+	}
+	for _, oid := range v.requiredExtensions {
+		if !hasExtension(cert, oid) {
+			logrus.Infof("%s certificate %s has no extension %v", request.Side, cert.Subject, oid)
+			return apis.CertificateVerifyResponse{Success: false, Status: StatusMissingExtension}, nil
+		}
+	}
+	if v.revokedSerials[cert.SerialNumber.Text(16)] {
+		logrus.Infof("%s certificate %s with serial %x is revoked", request.Side, cert.Subject, cert.SerialNumber)
+		return apis.CertificateVerifyResponse{Success: false, Status: StatusRevokedCert}, nil
+	}
+	return apis.CertificateVerifyResponse{Success: true, Status: StatusOK}, nil
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, extension := range cert.Extensions {
+		if extension.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOID(value string) (asn1.ObjectIdentifier, error) {
+	oid := make(asn1.ObjectIdentifier, 0)
+	for _, part := range strings.Split(value, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object identifier %s", value)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
+
+// readSerials reads the hex encoded serial numbers, one per line
+func readSerials(filename string) (map[string]bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	serials := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.Replace(scanner.Text(), ":", "", -1))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(line, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %s in %s", line, filename)
+		}
+		serials[serial.Text(16)] = true
+	}
+	return serials, scanner.Err()
+}
+
+type stringsValue []string
+
+func (v *stringsValue) String() string {
+	return fmt.Sprint(*v)
+}
+
+func (v *stringsValue) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+type pluginMeta struct {
+	requiredExtensions []string
+	revokedSerialsFile string
+	sides              []string
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("cert verifier plugin settings", flag.ContinueOnError)
+	fs.Var((*stringsValue)(&f.requiredExtensions), "required-extension", "Object identifier of an extension the leaf certificates must have e.g. 1.3.6.1.4.1.99999.1 (optional)")
+	fs.StringVar(&f.revokedSerialsFile, "revoked-serials-file", "", "File with the hex encoded serial numbers of the revoked certificates, one per line (optional)")
+	fs.Var((*stringsValue)(&f.sides), "side", "Verified side: listener or broker. If not set, both sides are verified (optional)")
+	return fs
+}
+
+func main() {
+	pluginMeta := &pluginMeta{}
+	flags := pluginMeta.flagSet()
+	flags.Parse(os.Args[1:])
+
+	certificateVerifier := &CertificateVerifier{revokedSerials: make(map[string]bool), sides: make(map[string]bool)}
+	for _, value := range pluginMeta.requiredExtensions {
+		oid, err := parseOID(value)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(1)
+		}
+		certificateVerifier.requiredExtensions = append(certificateVerifier.requiredExtensions, oid)
+	}
+	if pluginMeta.revokedSerialsFile != "" {
+		serials, err := readSerials(pluginMeta.revokedSerialsFile)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(1)
+		}
+		certificateVerifier.revokedSerials = serials
+	}
+	for _, side := range pluginMeta.sides {
+		certificateVerifier.sides[side] = true
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: shared.Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"certificateVerifier": &shared.CertificateVerifierPlugin{Impl: certificateVerifier},
+		},
+		// A non-nil value here enables gRPC serving for this plugin...
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/key-signer/shared"
+	"github.com/hashicorp/go-plugin"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK               = 0
+	StatusKMSError         = 1
+	StatusUnsupportedHash  = 2
+	StatusInvalidPublicKey = 3
+
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// KeySigner signs with an asymmetric key version of Google Cloud KMS. The padding of the RSA signatures is defined by the algorithm
+// of the key version, RSA_SIGN_PSS_* keys are required by TLS 1.3.
+type KeySigner struct {
+	endpoint        string
+	keyVersionName  string
+	accessTokenFile string
+	httpClient      *http.Client
+
+	lock        sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+var digestNames = map[crypto.Hash]string{
+	crypto.SHA256: "sha256",
+	crypto.SHA384: "sha384",
+	crypto.SHA512: "sha512",
+}
+
+func (s *KeySigner) PublicKey(ctx context.Context, request apis.KeyPublicKeyRequest) (apis.KeyPublicKeyResponse, error) {
+	var response struct {
+		Pem string `json:"pem"`
+	}
+	if err := s.call(ctx, http.MethodGet, s.keyVersionName+"/publicKey", nil, &response); err != nil {
+		logrus.Errorf("cannot get public key of %s: %v", s.keyVersionName, err)
+		return apis.KeyPublicKeyResponse{Success: false, Status: StatusKMSError}, nil
+	}
+	block, _ := pem.Decode([]byte(response.Pem))
+	if block == nil {
+		logrus.Errorf("public key of %s is not PEM encoded", s.keyVersionName)
+		return apis.KeyPublicKeyResponse{Success: false, Status: StatusInvalidPublicKey}, nil
+	}
+	return apis.KeyPublicKeyResponse{Success: true, Status: StatusOK, PublicKey: block.Bytes}, nil
+}
+
+func (s *KeySigner) Sign(ctx context.Context, request apis.KeySignRequest) (apis.KeySignResponse, error) {
+	digestName, ok := digestNames[crypto.Hash(request.Hash)]
+	if !ok {
+		logrus.Warnf("unsupported digest hash %d", request.Hash)
+		return apis.KeySignResponse{Success: false, Status: StatusUnsupportedHash}, nil
+	}
+	body := map[string]interface{}{"digest": map[string]string{digestName: base64.StdEncoding.EncodeToString(request.Digest)}}
+	var response struct {
+		Signature string `json:"signature"`
+	}
+	if err := s.call(ctx, http.MethodPost, s.keyVersionName+":asymmetricSign", body, &response); err != nil {
+		logrus.Errorf("cannot sign with %s: %v", s.keyVersionName, err)
+		return apis.KeySignResponse{Success: false, Status: StatusKMSError}, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return apis.KeySignResponse{Success: false, Status: StatusKMSError}, nil
+	}
+	return apis.KeySignResponse{Success: true, Status: StatusOK, Signature: signature}, nil
+}
+
+func (s *KeySigner) call(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	token, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if body != nil {
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, s.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, result)
+}
+
+// token returns the access token of the file or of the service account of the instance
+func (s *KeySigner) token(ctx context.Context) (string, error) {
+	if s.accessTokenFile != "" {
+		data, err := ioutil.ReadFile(s.accessTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.accessToken != "" && time.Now().Before(s.expires) {
+		return s.accessToken, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("metadata server returned no access token")
+	}
+	// refresh the token a minute before it expires
+	s.accessToken, s.expires = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second-time.Minute)
+	return s.accessToken, nil
+}
+
+type pluginMeta struct {
+	endpoint        string
+	keyVersionName  string
+	accessTokenFile string
+	timeout         time.Duration
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("key signer plugin settings", flag.ContinueOnError)
+	fs.StringVar(&f.endpoint, "endpoint", "https://cloudkms.googleapis.com/v1/", "Cloud KMS API endpoint")
+	fs.StringVar(&f.keyVersionName, "key-version-name", "", "Resource name of the key version: projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*")
+	fs.StringVar(&f.accessTokenFile, "access-token-file", "", "File with the OAuth2 access token, re-read on every request. If not set, the token of the instance service account is used (optional)")
+	fs.DurationVar(&f.timeout, "timeout", 5*time.Second, "Timeout of the Cloud KMS requests")
+	return fs
+}
+
+func main() {
+	pluginMeta := &pluginMeta{}
+	flags := pluginMeta.flagSet()
+	flags.Parse(os.Args[1:])
+
+	if pluginMeta.keyVersionName == "" {
+		logrus.Errorf("parameter key-version-name is required")
+		os.Exit(1)
+	}
+	keySigner := &KeySigner{
+		endpoint:        pluginMeta.endpoint,
+		keyVersionName:  pluginMeta.keyVersionName,
+		accessTokenFile: pluginMeta.accessTokenFile,
+		httpClient:      &http.Client{Timeout: pluginMeta.timeout},
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: shared.Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"keySigner": &shared.KeySignerPlugin{Impl: keySigner},
+		},
+		// A non-nil value here enables gRPC serving for this plugin...
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	apiKeySaslHandshake    = 17
+	apiKeySaslAuthenticate = 36
+)
+
+var fuzzCorpus = &cobra.Command{
+	Use:   "fuzz-corpus",
+	Short: "Write the raw frames of a request capture as seeds of the protocol fuzz targets",
+	RunE:  writeFuzzCorpus,
+}
+
+func init() {
+	Tools.AddCommand(fuzzCorpus)
+
+	fuzzCorpus.Flags().String("capture-file", "", "capture file written with --capture-raw")
+	fuzzCorpus.Flags().String("output-dir", "proxy/protocol/testdata/fuzz", "fuzz corpus directory with a sub directory per fuzz target")
+}
+
+// capturedFrame are the fields of the capture records used for the seeds
+type capturedFrame struct {
+	Direction  string `json:"direction"`
+	ApiKey     int16  `json:"api_key"`
+	ApiVersion int16  `json:"api_version"`
+	Frame      string `json:"frame"`
+}
+
+func writeFuzzCorpus(cmd *cobra.Command, _ []string) error {
+	captureFile, _ := cmd.Flags().GetString("capture-file")
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	if captureFile == "" {
+		return fmt.Errorf("capture-file is required")
+	}
+	file, err := os.Open(captureFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	seeds := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 128*1024*1024)
+	for scanner.Scan() {
+		var record capturedFrame
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return err
+		}
+		if record.Frame == "" {
+			continue
+		}
+		frame, err := base64.StdEncoding.DecodeString(record.Frame)
+		if err != nil {
+			return err
+		}
+		var written int
+		switch record.Direction {
+		case "request":
+			// ApiKey, ApiVersion, request header and body
+			written, err = writeFuzzSeed(outputDir, "FuzzRequest", fmt.Sprintf("[]byte(%q)\n", frame))
+			if err == nil && (record.ApiKey == apiKeySaslHandshake || record.ApiKey == apiKeySaslAuthenticate) {
+				var n int
+				n, err = writeFuzzSeed(outputDir, "FuzzSaslRequest", fmt.Sprintf("[]byte(%q)\n", frame))
+				written += n
+			}
+		case "response":
+			// CorrelationId and body
+			if len(frame) < 4 {
+				continue
+			}
+			written, err = writeFuzzSeed(outputDir, "FuzzResponse", fmt.Sprintf("int16(%d)\nint16(%d)\n[]byte(%q)\n", record.ApiKey, record.ApiVersion, frame[4:]))
+		}
+		if err != nil {
+			return err
+		}
+		seeds += written
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	logrus.Infof("Wrote %d fuzz seeds to %s", seeds, outputDir)
+	return nil
+}
+
+// writeFuzzSeed writes the values in the format of the go test corpus files, the same frames are written once
+func writeFuzzSeed(outputDir string, target string, values string) (int, error) {
+	content := []byte("go test fuzz v1\n" + values)
+	dir := filepath.Join(outputDir, target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("capture-%x", sha256.Sum256(content))[:len("capture-")+16])
+	if existing, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(existing, content) {
+		return 0, nil
+	}
+	return 1, ioutil.WriteFile(filename, content, 0644)
+}
@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/plugin/discovery"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var pluginManifest = &cobra.Command{
+	Use:   "plugin-manifest",
+	Short: "Write the manifest with the checksums and signatures of the plugin binaries of a plugin directory",
+	RunE:  writePluginManifest,
+}
+
+func init() {
+	Tools.AddCommand(pluginManifest)
+
+	pluginManifest.Flags().String("dir", "build", "plugin directory")
+	pluginManifest.Flags().StringArray("plugin", []string{}, "plugin file of the directory and its dispensed plugin types as 'file=type,...' e.g. auth-user=passwordAuthenticator. If not set, all files of the directory are listed without types")
+	pluginManifest.Flags().String("private-key-file", "", "PEM encoded PKCS #8 ed25519 private key signing the plugins. If empty, the plugins are not signed")
+	pluginManifest.Flags().String("output", "", "manifest file. If empty, manifest.json of the plugin directory is written")
+}
+
+func writePluginManifest(cmd *cobra.Command, _ []string) error {
+	dir, _ := cmd.Flags().GetString("dir")
+	plugins, _ := cmd.Flags().GetStringArray("plugin")
+	privateKeyFile, _ := cmd.Flags().GetString("private-key-file")
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		output = filepath.Join(dir, discovery.DefaultManifestFile)
+	}
+	var privateKey ed25519.PrivateKey
+	if privateKeyFile != "" {
+		var err error
+		if privateKey, err = discovery.LoadPrivateKey(privateKeyFile); err != nil {
+			return err
+		}
+	}
+	if len(plugins) == 0 {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() && filepath.Join(dir, info.Name()) != filepath.Clean(output) {
+				plugins = append(plugins, info.Name())
+			}
+		}
+	}
+	manifest := discovery.Manifest{}
+	for _, plugin := range plugins {
+		file, types := plugin, []string(nil)
+		if i := strings.Index(plugin, "="); i != -1 {
+			file, types = plugin[:i], strings.Split(plugin[i+1:], ",")
+		}
+		if file == "" {
+			return fmt.Errorf("plugin file of '%s' is required", plugin)
+		}
+		// the plugins are found by the file names without the .exe suffix of the Windows binaries
+		entry, err := discovery.NewEntry(dir, strings.TrimSuffix(file, ".exe"), file, types, privateKey)
+		if err != nil {
+			return err
+		}
+		manifest.Plugins = append(manifest.Plugins, entry)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	logrus.Infof("Manifest of %d plugins was written to %s, signed %v", len(manifest.Plugins), output, privateKey != nil)
+	return nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// Cluster is an additional upstream cluster served by the same process with its own listeners, TLS and SASL settings.
+// Other settings are inherited from the base configuration.
+type Cluster struct {
+	Name                    string   `yaml:"name"`
+	BootstrapServerMappings []string `yaml:"bootstrap-server-mapping"`
+	ExternalServerMappings  []string `yaml:"external-server-mapping"`
+	// base client id is used when empty
+	ClientID string `yaml:"kafka-client-id"`
+
+	TLS struct {
+		Enable             bool   `yaml:"enable"`
+		InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+		ClientCertFile     string `yaml:"client-cert-file"`
+		ClientKeyFile      string `yaml:"client-key-file"`
+		ClientKeyPassword  string `yaml:"client-key-password"`
+		CAChainCertFile    string `yaml:"ca-chain-cert-file"`
+	} `yaml:"tls"`
+
+	SASL struct {
+		Enable         bool   `yaml:"enable"`
+		Username       string `yaml:"username"`
+		Password       string `yaml:"password"`
+		JaasConfigFile string `yaml:"jaas-config-file"`
+	} `yaml:"sasl"`
+}
+
+// ResolveSecrets replaces the passwords of the cluster with the secrets returned by resolve
+func (c *Cluster) ResolveSecrets(resolve func(value string) (string, error)) error {
+	for _, secret := range []*string{&c.TLS.ClientKeyPassword, &c.SASL.Username, &c.SASL.Password} {
+		if *secret == "" {
+			continue
+		}
+		value, err := resolve(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = value
+	}
+	return nil
+}
+
+type clustersFile struct {
+	Clusters []Cluster `yaml:"clusters"`
+}
+
+// LoadClusters reads the clusters from the YAML file
+func LoadClusters(filename string) ([]Cluster, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file clustersFile
+	if err = yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "invalid clusters file %s", filename)
+	}
+	names := make(map[string]bool)
+	for _, cluster := range file.Clusters {
+		if cluster.Name == "" {
+			return nil, errors.Errorf("cluster in %s must have a name", filename)
+		}
+		if names[cluster.Name] {
+			return nil, errors.Errorf("cluster %s is defined more than once in %s", cluster.Name, filename)
+		}
+		names[cluster.Name] = true
+	}
+	return file.Clusters, nil
+}
+
+// ForCluster returns a copy of the configuration with the listeners, TLS and SASL settings of the cluster.
+// Blue/green switching, mirroring, capture, the listeners admin API and the SASL plugin are configured for the base cluster only and are disabled in the copy.
+func (c *Config) ForCluster(cluster Cluster) (*Config, error) {
+	result := *c
+	if cluster.ClientID != "" {
+		result.Kafka.ClientID = cluster.ClientID
+	}
+	result.Kafka.TLS.Enable = cluster.TLS.Enable
+	result.Kafka.TLS.InsecureSkipVerify = cluster.TLS.InsecureSkipVerify
+	result.Kafka.TLS.ClientCertFile = cluster.TLS.ClientCertFile
+	result.Kafka.TLS.ClientKeyFile = cluster.TLS.ClientKeyFile
+	result.Kafka.TLS.ClientKeyPassword = cluster.TLS.ClientKeyPassword
+	result.Kafka.TLS.CAChainCertFile = cluster.TLS.CAChainCertFile
+
+	result.Kafka.SASL.Enable = cluster.SASL.Enable
+	result.Kafka.SASL.Username = cluster.SASL.Username
+	result.Kafka.SASL.Password = cluster.SASL.Password
+	result.Kafka.SASL.JaasConfigFile = cluster.SASL.JaasConfigFile
+	result.Kafka.SASL.Plugin.Enable = false
+	if err := result.InitSASLCredentials(); err != nil {
+		return nil, err
+	}
+
+	if err := result.InitBootstrapServers(cluster.BootstrapServerMappings); err != nil {
+		return nil, err
+	}
+	if err := result.InitExternalServers(cluster.ExternalServerMappings); err != nil {
+		return nil, err
+	}
+	result.Upstream.SecondaryMapping = nil
+	result.Mirror.BootstrapServers = nil
+	result.Capture.Enable = false
+	result.Proxy.ListenersAdminEnable = false
+	result.Proxy.ListenersStateFile = ""
+
+	if err := result.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "cluster %s", cluster.Name)
+	}
+	return &result, nil
+}
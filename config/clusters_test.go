@@ -0,0 +1,93 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeClustersFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "clusters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadClusters(t *testing.T) {
+	a := assert.New(t)
+
+	filename := writeClustersFile(t, `
+clusters:
+  - name: staging
+    bootstrap-server-mapping:
+      - "staging-kafka-0:9092,0.0.0.0:33400"
+    kafka-client-id: kafka-proxy-staging
+    tls:
+      enable: true
+      insecure-skip-verify: true
+    sasl:
+      enable: true
+      username: alice
+      password: secret
+  - name: prod
+    bootstrap-server-mapping:
+      - "prod-kafka-0:9092,0.0.0.0:34400"
+`)
+	defer os.Remove(filename)
+
+	clusters, err := LoadClusters(filename)
+	a.Nil(err)
+	a.Len(clusters, 2)
+	a.Equal("staging", clusters[0].Name)
+	a.True(clusters[0].TLS.InsecureSkipVerify)
+	a.Equal("alice", clusters[0].SASL.Username)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.ClientID = "kafka-proxy"
+	c.Upstream.SecondaryMapping = []string{"192.168.99.100:32400,192.168.99.200:32400"}
+
+	staging, err := c.ForCluster(clusters[0])
+	a.Nil(err)
+	a.Equal([]ListenerConfig{{BrokerAddress: "staging-kafka-0:9092", ListenerAddress: "0.0.0.0:33400", AdvertisedAddress: "0.0.0.0:33400"}}, staging.Proxy.BootstrapServers)
+	a.Equal("kafka-proxy-staging", staging.Kafka.ClientID)
+	a.True(staging.Kafka.TLS.Enable)
+	a.True(staging.Kafka.SASL.Enable)
+	a.Empty(staging.Upstream.SecondaryMapping)
+
+	prod, err := c.ForCluster(clusters[1])
+	a.Nil(err)
+	a.Equal("kafka-proxy", prod.Kafka.ClientID)
+	a.False(prod.Kafka.TLS.Enable)
+	// base configuration is not changed
+	a.Equal("192.168.99.100:32400", c.Proxy.BootstrapServers[0].BrokerAddress)
+	a.Len(c.Upstream.SecondaryMapping, 1)
+}
+
+func TestLoadClustersRejectsInvalidFiles(t *testing.T) {
+	a := assert.New(t)
+
+	filename := writeClustersFile(t, `
+clusters:
+  - name: staging
+  - name: staging
+`)
+	defer os.Remove(filename)
+	_, err := LoadClusters(filename)
+	a.EqualError(err, "cluster staging is defined more than once in "+filename)
+
+	unknown := writeClustersFile(t, `
+clusters:
+  - name: staging
+    bootstrap-servers: ["kafka:9092"]
+`)
+	defer os.Remove(unknown)
+	_, err = LoadClusters(unknown)
+	a.NotNil(err)
+}
@@ -0,0 +1,1062 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetResolverHosts(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Resolver.Hosts = []string{"Kafka-0.example.com.=10.0.0.1, 10.0.0.2", "kafka-1.example.com=fd00::1"}
+	hosts, err := c.GetResolverHosts()
+	a.Nil(err)
+	a.Equal(map[string][]net.IP{
+		"kafka-0.example.com": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+		"kafka-1.example.com": {net.ParseIP("fd00::1")},
+	}, hosts)
+}
+
+func TestGetResolverHostsValidation(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Resolver.Hosts = []string{"kafka-0.example.com"}
+	_, err := c.GetResolverHosts()
+	a.NotNil(err)
+
+	c.Resolver.Hosts = []string{"kafka-0.example.com=not-an-ip"}
+	_, err = c.GetResolverHosts()
+	a.NotNil(err)
+}
+
+func TestParseListenerCIDRs(t *testing.T) {
+	a := assert.New(t)
+
+	global, perListener, err := ParseListenerCIDRs([]string{"10.0.0.0/8", "0.0.0.0:32400=192.168.0.0/16", "[::]:32401=fd00::/8"})
+	a.Nil(err)
+	a.Equal(1, len(global))
+	a.Equal("10.0.0.0/8", global[0].String())
+	a.Equal(2, len(perListener))
+	a.Equal("192.168.0.0/16", perListener["0.0.0.0:32400"][0].String())
+	a.Equal("fd00::/8", perListener["[::]:32401"][0].String())
+
+	_, _, err = ParseListenerCIDRs([]string{"10.0.0.1"})
+	a.NotNil(err)
+	_, _, err = ParseListenerCIDRs([]string{"32400=10.0.0.0/8"})
+	a.NotNil(err)
+}
+
+func TestParseForbiddenApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		value      string
+		apiKey     int16
+		minVersion int16
+		maxVersion int16
+	}{
+		{"1=0-3", 1, 0, 3},
+		{"1=3", 1, 3, 3},
+		{"44=1-", 44, 1, math.MaxInt16},
+		{" 0 = 2 - 4", 0, 2, 4},
+	}
+	for _, tt := range tests {
+		apiKey, minVersion, maxVersion, err := ParseForbiddenApiVersions(tt.value)
+		a.Nil(err, tt.value)
+		a.Equal(tt.apiKey, apiKey, tt.value)
+		a.Equal(tt.minVersion, minVersion, tt.value)
+		a.Equal(tt.maxVersion, maxVersion, tt.value)
+	}
+	for _, value := range []string{"1", "x=1", "-1=0", "1=", "1=3-2", "1=-3", "1=0-x"} {
+		_, _, _, err := ParseForbiddenApiVersions(value)
+		a.NotNil(err, value)
+	}
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.ForbiddenApiVersions = []string{"1=0-3"}
+	a.Nil(c.Validate())
+	c.Kafka.ForbiddenApiVersions = []string{"18=3-"}
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "ForbiddenApiVersions")
+}
+
+func TestParseParseErrorApiKeyAction(t *testing.T) {
+	a := assert.New(t)
+
+	apiKey, action, err := ParseParseErrorApiKeyAction("3=forward")
+	a.Nil(err)
+	a.Equal(int16(3), apiKey)
+	a.Equal(ParseErrorActionForward, action)
+
+	apiKey, action, err = ParseParseErrorApiKeyAction(" 0 = reject ")
+	a.Nil(err)
+	a.Equal(int16(0), apiKey)
+	a.Equal(ParseErrorActionReject, action)
+
+	for _, value := range []string{"3", "x=close", "-1=close", "3=", "3=drop"} {
+		_, _, err := ParseParseErrorApiKeyAction(value)
+		a.NotNil(err, value)
+	}
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	a.Equal(ParseErrorActionClose, c.ParseErrors.Action)
+	c.ParseErrors.ApiKeyActions = []string{"3=forward"}
+	a.Nil(c.Validate())
+	c.ParseErrors.Action = "drop"
+	err = c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "ParseErrors.Action")
+}
+
+func TestParseRejectionErrorCode(t *testing.T) {
+	a := assert.New(t)
+
+	reason, code, err := ParseRejectionErrorCode("acl=29")
+	a.Nil(err)
+	a.Equal(RejectionACL, reason)
+	a.Equal(int16(29), code)
+
+	for _, value := range []string{"acl", "quota=29", "acl=x", "acl=0", "auth=70000"} {
+		_, _, err := ParseRejectionErrorCode(value)
+		a.NotNil(err, value)
+	}
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Rejection.ErrorCodes = []string{"limits=44"}
+	a.Nil(c.Validate())
+	c.Rejection.ErrorCodes = []string{"limits"}
+	a.EqualError(c.Validate(), "rejection error code 'limits' must be in form 'reason=code'")
+}
+
+func TestParseSchemaValidationTopic(t *testing.T) {
+	a := assert.New(t)
+
+	pattern, subject, err := ParseSchemaValidationTopic("^orders$")
+	a.Nil(err)
+	a.Equal("^orders$", pattern.String())
+	a.Equal("", subject)
+
+	pattern, subject, err = ParseSchemaValidationTopic("^payments-.*=payments-value")
+	a.Nil(err)
+	a.Equal("^payments-.*", pattern.String())
+	a.Equal("payments-value", subject)
+
+	for _, value := range []string{"orders=", "(orders"} {
+		_, _, err = ParseSchemaValidationTopic(value)
+		a.NotNil(err, value)
+	}
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.SchemaValidation.Topics = []string{"^orders$"}
+	err = c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "SchemaValidation.Registry.URL")
+	c.SchemaValidation.Registry.URL = "http://schema-registry:8081"
+	a.Nil(c.Validate())
+}
+
+func TestParseCompressionCodec(t *testing.T) {
+	a := assert.New(t)
+
+	codec, err := ParseCompressionCodec("ZSTD")
+	a.Nil(err)
+	a.Equal(int8(4), codec)
+	codec, err = ParseCompressionCodec("none")
+	a.Nil(err)
+	a.Equal(int8(0), codec)
+	_, err = ParseCompressionCodec("brotli")
+	a.EqualError(err, "compression codec 'brotli' must be one of none, gzip, snappy, lz4 or zstd")
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Compression.AllowedCodecs = []string{"lz4", "zstd"}
+	a.Nil(c.Validate())
+	c.Compression.MaxDecompressedSize = 0
+	err = c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "Compression.MaxDecompressedSize")
+}
+
+func TestValidateRecompression(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Recompression.ProduceCodec = "gzip"
+	c.Recompression.FetchCodec = "none"
+	a.Nil(c.Validate())
+	c.Recompression.FetchCodec = "zstd"
+	a.EqualError(c.Validate(), "record batches can be recompressed only with none or gzip codec, but got 'zstd'")
+	c.Recompression.FetchCodec = ""
+	c.Recompression.ClientCodecs = []string{"10.0.1.0/24=gzip", "10.0.2.0/24=none"}
+	a.Nil(c.Validate())
+	c.Recompression.ClientCodecs = []string{"10.0.1.0/24=lz4"}
+	a.EqualError(c.Validate(), "record batches can be recompressed only with none or gzip codec, but got '10.0.1.0/24=lz4'")
+	c.Recompression.ClientCodecs = nil
+	c.Recompression.GzipLevel = 10
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "Recompression.GzipLevel")
+}
+
+func TestParseUpstreamMapping(t *testing.T) {
+	a := assert.New(t)
+
+	primary, secondary, err := ParseUpstreamMapping("kafka-0:9092, kafka-0.green:9092")
+	a.Nil(err)
+	a.Equal("kafka-0:9092", primary)
+	a.Equal("kafka-0.green:9092", secondary)
+	_, _, err = ParseUpstreamMapping("kafka-0:9092")
+	a.EqualError(err, "upstream mapping 'kafka-0:9092' must be in form 'primary broker address,secondary broker address'")
+	_, _, err = ParseUpstreamMapping("kafka-0:9092,kafka-0")
+	a.NotNil(err)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Upstream.SecondaryMapping = []string{"192.168.99.100:32400,192.168.99.200:32400"}
+	a.Nil(c.Validate())
+	c.Upstream.Active = "blue"
+	a.EqualError(c.Validate(), "Upstream.Active must be primary or secondary, got 'blue'")
+}
+
+func TestParseUpstreamRoute(t *testing.T) {
+	a := assert.New(t)
+
+	cluster, attribute, network, _, err := ParseUpstreamRoute("secondary:cidr=10.1.0.0/16")
+	a.Nil(err)
+	a.Equal("secondary", cluster)
+	a.Equal(UpstreamRouteCIDR, attribute)
+	a.Equal("10.1.0.0/16", network.String())
+	_, attribute, _, pattern, err := ParseUpstreamRoute("primary:cert-subject=OU=QA")
+	a.Nil(err)
+	a.Equal(UpstreamRouteCertSubject, attribute)
+	a.Equal("OU=QA", pattern.String())
+	_, _, _, _, err = ParseUpstreamRoute("principal=^qa-")
+	a.EqualError(err, "upstream route 'principal=^qa-' must be in form 'cluster:attribute=pattern'")
+	_, _, _, _, err = ParseUpstreamRoute("qa:principal=^qa-")
+	a.EqualError(err, "upstream route 'qa:principal=^qa-' cluster must be primary or secondary")
+	_, _, _, _, err = ParseUpstreamRoute("secondary:group=^qa-")
+	a.EqualError(err, "upstream route 'secondary:group=^qa-' attribute must be cidr, sni, cert-subject or principal")
+	_, _, _, _, err = ParseUpstreamRoute("secondary:cidr=10.1.0.0")
+	a.NotNil(err)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Upstream.Routes = []string{"secondary:principal=^qa-"}
+	a.EqualError(c.Validate(), "Upstream.Routes require Upstream.SecondaryMapping")
+	c.Upstream.SecondaryMapping = []string{"192.168.99.100:32400,192.168.99.200:32400"}
+	a.EqualError(c.Validate(), "upstream route 'secondary:principal=^qa-' requires Auth.UnixPeer.Enable or a TLS listener verifying the client certificates, the principals of the local SASL are not known when the cluster is selected")
+	c.Upstream.Routes = []string{"secondary:cidr=10.1.0.0/16"}
+	a.Nil(c.Validate())
+
+	// the principal of the verified client certificate
+	c.Upstream.Routes = []string{"secondary:principal=^qa-"}
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = "server-cert.pem"
+	c.Proxy.TLS.ListenerKeyFile = "server-key.pem"
+	c.Proxy.TLS.CAChainCertFile = "ca.pem"
+	a.Nil(c.Validate())
+	c.Proxy.TLS.ListenerClientAuth = TLSClientAuthRequestAny
+	a.NotNil(c.Validate())
+}
+
+func TestValidateUpstreamCanary(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Upstream.CanaryPercent = 10
+	a.EqualError(c.Validate(), "Upstream.CanaryPercent requires Upstream.SecondaryMapping")
+	c.Upstream.SecondaryMapping = []string{"192.168.99.100:32400,192.168.99.200:32400"}
+	a.Nil(c.Validate())
+	c.Upstream.CanaryPercent = 101
+	a.EqualError(c.Validate(), "Upstream.CanaryPercent must be between 0 and 100")
+}
+
+func TestParseBootstrapEndpoint(t *testing.T) {
+	a := assert.New(t)
+
+	broker, endpoint, weight, err := ParseBootstrapEndpoint("kafka:9092, lb-a:9092, 3")
+	a.Nil(err)
+	a.Equal("kafka:9092", broker)
+	a.Equal("lb-a:9092", endpoint)
+	a.Equal(3, weight)
+	_, _, weight, err = ParseBootstrapEndpoint("kafka:9092,lb-a:9092")
+	a.Nil(err)
+	a.Equal(1, weight)
+	_, _, _, err = ParseBootstrapEndpoint("kafka:9092")
+	a.EqualError(err, "bootstrap endpoint 'kafka:9092' must be in form 'broker address,endpoint address(,weight)'")
+	_, _, _, err = ParseBootstrapEndpoint("kafka:9092,lb-a:9092,0")
+	a.EqualError(err, "bootstrap endpoint 'kafka:9092,lb-a:9092,0' weight must be a positive integer")
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"kafka:9092,0.0.0.0:32400"}))
+	c.Bootstrap.Endpoints = []string{"kafka:9092,lb-a:9092", "kafka:9092,lb-a:9092,2"}
+	a.EqualError(c.Validate(), "Bootstrap.Endpoints contains duplicate endpoint lb-a:9092 of broker address kafka:9092")
+}
+
+func TestValidateUnmappedBrokers(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	a.Nil(c.Validate())
+	a.Equal(UnmappedBrokersAutoMap, c.UnmappedBrokersStrategy())
+	c.Proxy.DisableDynamicListeners = true
+	a.Equal(UnmappedBrokersError, c.UnmappedBrokersStrategy())
+	c.Proxy.UnmappedBrokers = UnmappedBrokersPassthrough
+	a.Nil(c.Validate())
+	a.Equal(UnmappedBrokersPassthrough, c.UnmappedBrokersStrategy())
+	c.Proxy.UnmappedBrokers = UnmappedBrokersAutoMap
+	a.EqualError(c.Validate(), "UnmappedBrokers auto-map requires dynamic listeners")
+	c.Proxy.UnmappedBrokers = "ignore"
+	a.EqualError(c.Validate(), "UnmappedBrokers must be error, passthrough or auto-map, got 'ignore'")
+}
+
+func TestValidateConnectionEngine(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	a.Nil(c.Validate())
+	c.Proxy.ConnectionEngine = ConnectionEngineEventLoop
+	a.Nil(c.Validate())
+	c.Proxy.EventLoopIdleTime = 0
+	a.EqualError(c.Validate(), "EventLoopIdleTime must be greater than 0")
+	c.Proxy.ConnectionEngine = "epoll"
+	a.EqualError(c.Validate(), "ConnectionEngine must be goroutines or eventloop, got 'epoll'")
+}
+
+func TestValidateDynamicPorts(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Proxy.DynamicPorts.Coordination = "random"
+	a.EqualError(c.Validate(), "DynamicPorts.Coordination must be file, etcd, hash or broker-index, got 'random'")
+	c.Proxy.DynamicPorts.Coordination = DynamicPortsHash
+	a.EqualError(c.Validate(), "DynamicPorts range 0-0 is invalid")
+	c.Proxy.DynamicPorts.MinPort = 32500
+	c.Proxy.DynamicPorts.MaxPort = 32400
+	a.EqualError(c.Validate(), "DynamicPorts range 32500-32400 is invalid")
+	c.Proxy.DynamicPorts.MaxPort = 32599
+	a.Nil(c.Validate())
+	c.Proxy.DynamicPorts.Coordination = DynamicPortsFile
+	a.EqualError(c.Validate(), "DynamicPorts.File must not be empty")
+	c.Proxy.DynamicPorts.Coordination = DynamicPortsEtcd
+	a.EqualError(c.Validate(), "DynamicPorts.EtcdEndpoints must not be empty")
+	c.Proxy.DynamicPorts.EtcdEndpoints = []string{"http://etcd:2379"}
+	a.Nil(c.Validate())
+}
+
+func TestApplyKafkaPresetConfluentCloud(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"pkc-4r087.us-west2.gcp.confluent.cloud:9092,0.0.0.0:32400"}))
+	c.Kafka.Preset = KafkaPresetConfluentCloud
+	a.EqualError(c.Validate(), "Kafka.Preset confluent-cloud requires Kafka.TLS.Enable and Kafka.SASL.Enable")
+	c.Proxy.DynamicPorts.MinPort = 32500
+	c.Proxy.DynamicPorts.MaxPort = 32599
+	a.Nil(c.ApplyKafkaPreset())
+	a.True(c.Kafka.TLS.Enable)
+	a.True(c.Kafka.SASL.Enable)
+	a.Equal(DynamicPortsBrokerIndex, c.Proxy.DynamicPorts.Coordination)
+	a.EqualError(c.Validate(), "SASL.Username and SASL.Password are required when SASL is enabled and plugin is not used")
+	c.Kafka.SASL.Username = "API_KEY"
+	c.Kafka.SASL.Password = "API_SECRET"
+	a.Nil(c.Validate())
+
+	c.Kafka.TLS.InsecureSkipVerify = true
+	a.EqualError(c.Validate(), "Kafka.TLS.InsecureSkipVerify must be disabled with Kafka.Preset confluent-cloud")
+	c.Kafka.TLS.InsecureSkipVerify = false
+	c.Proxy.DisableDynamicListeners = true
+	a.EqualError(c.Validate(), "Kafka.Preset confluent-cloud requires dynamic listeners")
+	c.Proxy.DisableDynamicListeners = false
+	a.Nil(c.InitBootstrapServers([]string{"kafka-1.grepplabs.com:9092,0.0.0.0:32400"}))
+	a.EqualError(c.Validate(), "Kafka.Preset confluent-cloud requires the bootstrap servers *.confluent.cloud, got 'kafka-1.grepplabs.com:9092'")
+
+	c.Kafka.Preset = "msk"
+	a.EqualError(c.ApplyKafkaPreset(), "Kafka.Preset must be confluent-cloud, aiven or redpanda, got 'msk'")
+	a.EqualError(c.Validate(), "Kafka.Preset must be confluent-cloud, aiven or redpanda, got 'msk'")
+}
+
+func TestApplyKafkaPresetAiven(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"kafka-1a2b3c.aivencloud.com:12345,0.0.0.0:32400"}))
+	c.Kafka.Preset = KafkaPresetAiven
+	a.EqualError(c.Validate(), "Kafka.Preset aiven requires Kafka.TLS.Enable")
+	a.Nil(c.ApplyKafkaPreset())
+	a.True(c.Kafka.TLS.Enable)
+	a.EqualError(c.Validate(), "Kafka.Preset aiven requires Kafka.TLS.CAChainCertFile")
+	c.Kafka.TLS.CAChainCertFile = "ca.pem"
+	a.EqualError(c.Validate(), "Kafka.Preset aiven requires Kafka.TLS.ClientCertFile and Kafka.TLS.ClientKeyFile or Kafka.SASL.Enable")
+	c.Kafka.TLS.ClientCertFile = "service.cert"
+	c.Kafka.TLS.ClientKeyFile = "service.key"
+	a.Nil(c.Validate())
+	c.Kafka.TLS.ClientCertFile = ""
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.Username = "avnadmin"
+	c.Kafka.SASL.Password = "secret"
+	a.Nil(c.Validate())
+	c.Kafka.TLS.InsecureSkipVerify = true
+	a.EqualError(c.Validate(), "Kafka.TLS.InsecureSkipVerify must be disabled with Kafka.Preset aiven")
+}
+
+func TestApplyKafkaPresetRedpanda(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"redpanda-0:9092,0.0.0.0:32400"}))
+	c.Kafka.Preset = KafkaPresetRedpanda
+	a.Nil(c.ApplyKafkaPreset())
+	a.False(c.Kafka.TLS.Enable)
+	a.Equal(SASLVersionV1, c.Kafka.SASL.Version)
+	a.Nil(c.Validate())
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "secret"
+	c.Kafka.SASL.Version = SASLVersionV0
+	a.EqualError(c.Validate(), "Kafka.SASL.Version v0 is not supported by Kafka.Preset redpanda")
+}
+
+func TestParseSecurityProtocol(t *testing.T) {
+	a := assert.New(t)
+
+	brokerAddress, tls, sasl, err := ParseSecurityProtocol("kafka-1:9093=SASL_SSL")
+	a.Nil(err)
+	a.Equal("kafka-1:9093", brokerAddress)
+	a.True(tls)
+	a.True(sasl)
+	brokerAddress, tls, sasl, err = ParseSecurityProtocol("*:9092=plaintext")
+	a.Nil(err)
+	a.Equal("*:9092", brokerAddress)
+	a.False(tls)
+	a.False(sasl)
+	_, _, _, err = ParseSecurityProtocol("SSL")
+	a.EqualError(err, "security protocol 'SSL' must be in form 'broker address=protocol'")
+	_, _, _, err = ParseSecurityProtocol("kafka-1=SSL")
+	a.NotNil(err)
+	_, _, _, err = ParseSecurityProtocol("kafka-1:9093=TLS")
+	a.EqualError(err, "security protocol 'kafka-1:9093=TLS' must be PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL")
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.SecurityProtocols = []string{"*:9094=SSL"}
+	a.Nil(c.Validate())
+	c.Kafka.SecurityProtocols = []string{"*:9094=SASL_PLAINTEXT"}
+	a.EqualError(c.Validate(), "Kafka.SecurityProtocols with SASL require Kafka.SASL.Username and Kafka.SASL.Password")
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "secret"
+	a.Nil(c.Validate())
+}
+
+func TestParseBrokerCAChainCertFile(t *testing.T) {
+	a := assert.New(t)
+
+	brokerAddress, caChainCertFile, err := ParseBrokerCAChainCertFile("*:9093=/etc/kafka-proxy/cluster-b/ca.pem")
+	a.Nil(err)
+	a.Equal("*:9093", brokerAddress)
+	a.Equal("/etc/kafka-proxy/cluster-b/ca.pem", caChainCertFile)
+	_, _, err = ParseBrokerCAChainCertFile("kafka-1:9093=")
+	a.EqualError(err, "broker CA chain cert file 'kafka-1:9093=' must be in form 'broker address=file'")
+	_, _, err = ParseBrokerCAChainCertFile("kafka-1=ca.pem")
+	a.NotNil(err)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.TLS.BrokerCAChainCertFiles = []string{"ca.pem"}
+	a.EqualError(c.Validate(), "broker CA chain cert file 'ca.pem' must be in form 'broker address=file'")
+	c.Kafka.TLS.BrokerCAChainCertFiles = []string{"kafka-1:9093=ca.pem"}
+	a.Nil(c.Validate())
+}
+
+func TestParseDialOptions(t *testing.T) {
+	a := assert.New(t)
+
+	defaults := DialOptions{DialTimeout: 15 * time.Second, KeepAlive: time.Minute, ForwardProxy: true}
+	brokerAddress, options, err := ParseDialOptions("kafka-1:9092=dial-timeout=2s, forward-proxy=false", defaults)
+	a.Nil(err)
+	a.Equal("kafka-1:9092", brokerAddress)
+	a.Equal(DialOptions{DialTimeout: 2 * time.Second, KeepAlive: time.Minute, ForwardProxy: false}, options)
+	brokerAddress, options, err = ParseDialOptions("*:9093=keep-alive=30s,local-address=eth1", defaults)
+	a.Nil(err)
+	a.Equal("*:9093", brokerAddress)
+	a.Equal(DialOptions{DialTimeout: 15 * time.Second, KeepAlive: 30 * time.Second, ForwardProxy: true, LocalAddress: "eth1"}, options)
+	_, _, err = ParseDialOptions("kafka-1:9092", defaults)
+	a.EqualError(err, "dial options 'kafka-1:9092' must be in form 'broker address=option=value,...'")
+	_, _, err = ParseDialOptions("kafka-1:9092=retries=3", defaults)
+	a.EqualError(err, "dial option 'retries=3' must be dial-timeout, keep-alive, forward-proxy or local-address")
+	_, _, err = ParseDialOptions("kafka-1:9092=dial-timeout=-1s", defaults)
+	a.EqualError(err, "dial option 'dial-timeout=-1s' is invalid: must be greater or equal 0")
+	_, _, err = ParseDialOptions("kafka-1:9092=forward-proxy", defaults)
+	a.EqualError(err, "dial option 'forward-proxy' must be in form 'option=value'")
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.DialOptions = []string{"*:9093=dial-timeout=30s,forward-proxy=true"}
+	a.EqualError(c.Validate(), "dial options '*:9093=dial-timeout=30s,forward-proxy=true' with forward-proxy require ForwardProxy.Url")
+	c.ForwardProxy.Url = "socks5://127.0.0.1:1080"
+	a.Nil(c.Validate())
+}
+
+func TestValidateSASLVersion(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "secret"
+	a.Nil(c.Validate())
+	c.Kafka.SASL.Version = SASLVersionV0
+	a.Nil(c.Validate())
+	c.Kafka.SASL.Version = "v2"
+	a.EqualError(c.Validate(), "Kafka.SASL.Version must be auto, v0 or v1, got 'v2'")
+	c.Kafka.SASL.Version = SASLVersionV1
+	c.Kafka.SASL.HandshakeTimeout = 0
+	a.EqualError(c.Validate(), "Kafka.SASL.HandshakeTimeout and Kafka.SASL.AuthenticateTimeout must be greater than 0")
+}
+
+func TestParseRackAdvertisedHost(t *testing.T) {
+	a := assert.New(t)
+
+	rack, network, host, err := ParseRackAdvertisedHost("az1, 10.0.1.0/24, proxy-az1.grepplabs.com")
+	a.Nil(err)
+	a.Equal("az1", rack)
+	a.Equal("10.0.1.0/24", network.String())
+	a.Equal("proxy-az1.grepplabs.com", host)
+	_, _, _, err = ParseRackAdvertisedHost("az1,10.0.1.0/24")
+	a.EqualError(err, "rack advertised host 'az1,10.0.1.0/24' must be in form 'rack,cidr,advertised host'")
+	_, _, _, err = ParseRackAdvertisedHost("az1,10.0.1.0,proxy-az1.grepplabs.com")
+	a.NotNil(err)
+	_, _, _, err = ParseRackAdvertisedHost(",10.0.1.0/24,proxy-az1.grepplabs.com")
+	a.EqualError(err, "rack advertised host ',10.0.1.0/24,proxy-az1.grepplabs.com' must have rack and advertised host")
+}
+
+func TestParseClientCodec(t *testing.T) {
+	a := assert.New(t)
+
+	network, codec, err := ParseClientCodec(" 10.0.1.0/24 = gzip")
+	a.Nil(err)
+	a.Equal("10.0.1.0/24", network.String())
+	a.Equal(compressionCodecs["gzip"], codec)
+	_, _, err = ParseClientCodec("10.0.1.0/24")
+	a.EqualError(err, "client codec '10.0.1.0/24' must be in form 'cidr=codec'")
+	_, _, err = ParseClientCodec("10.0.1.0=gzip")
+	a.NotNil(err)
+	_, _, err = ParseClientCodec("10.0.1.0/24=brotli")
+	a.EqualError(err, "compression codec 'brotli' must be one of none, gzip, snappy, lz4 or zstd")
+}
+
+func TestParsePriorityClass(t *testing.T) {
+	a := assert.New(t)
+
+	class, attribute, pattern, err := ParsePriorityClass("low:client-id=^mirror-maker")
+	a.Nil(err)
+	a.Equal(PriorityLow, class)
+	a.Equal(PriorityAttributeClientID, attribute)
+	a.Equal("^mirror-maker", pattern.String())
+	_, _, _, err = ParsePriorityClass("client-id=^mirror-maker")
+	a.EqualError(err, "priority class 'client-id=^mirror-maker' must be in form 'class:attribute=pattern'")
+	_, _, _, err = ParsePriorityClass("normal:client-id=^mirror-maker")
+	a.EqualError(err, "priority class 'normal:client-id=^mirror-maker' class must be high or low")
+	_, _, _, err = ParsePriorityClass("high:cidr=10.1.0.0/16")
+	a.EqualError(err, "priority class 'high:cidr=10.1.0.0/16' attribute must be listener, principal or client-id")
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Priority.Classes = []string{"high:principal=^payments-"}
+	a.EqualError(c.Validate(), "Priority.Classes require Priority.MaxConcurrency")
+	c.Priority.MaxConcurrency = 16
+	a.Nil(c.Validate())
+}
+
+func TestParseEgressLimit(t *testing.T) {
+	a := assert.New(t)
+
+	pattern, rate, burst, err := ParseEgressLimit("^mirror-maker=1048576")
+	a.Nil(err)
+	a.Equal("^mirror-maker", pattern.String())
+	a.Equal(float64(1048576), rate)
+	a.Equal(1048576, burst)
+	_, rate, burst, err = ParseEgressLimit("^mirror-maker=1000.5, 64")
+	a.Nil(err)
+	a.Equal(1000.5, rate)
+	a.Equal(64, burst)
+	_, _, _, err = ParseEgressLimit("^mirror-maker")
+	a.EqualError(err, "egress limit '^mirror-maker' must be in form 'regexp=bytes per second(,burst bytes)'")
+	_, _, _, err = ParseEgressLimit("^mirror-maker=0")
+	a.EqualError(err, "egress limit '^mirror-maker=0' rate must be a number greater than 0")
+	_, _, _, err = ParseEgressLimit("^mirror-maker=100,0")
+	a.EqualError(err, "egress limit '^mirror-maker=100,0' burst must be a positive integer")
+}
+
+func TestResolveSecrets(t *testing.T) {
+	a := assert.New(t)
+
+	resolve := func(value string) (string, error) {
+		if strings.HasPrefix(value, "env:") {
+			return "resolved-" + strings.TrimPrefix(value, "env:"), nil
+		}
+		if value == "fail:" {
+			return "", errors.New("cannot resolve")
+		}
+		return value, nil
+	}
+	c := NewConfig()
+	c.Kafka.SASL.Username = "bob"
+	c.Kafka.SASL.Password = "env:SASL_PASSWORD"
+	c.Proxy.TLS.ListenerKeyPassword = "env:KEY_PASSWORD"
+	c.Http.Auth.BearerTokens = []string{"static", "env:TOKEN"}
+	a.Nil(c.ResolveSecrets(resolve))
+	a.Equal("bob", c.Kafka.SASL.Username)
+	a.Equal("resolved-SASL_PASSWORD", c.Kafka.SASL.Password)
+	a.Equal("resolved-KEY_PASSWORD", c.Proxy.TLS.ListenerKeyPassword)
+	a.Equal([]string{"static", "resolved-TOKEN"}, c.Http.Auth.BearerTokens)
+	a.Equal("", c.Kafka.TLS.ClientKeyPassword)
+
+	c.SchemaValidation.Registry.Password = "fail:"
+	a.EqualError(c.ResolveSecrets(resolve), "cannot resolve")
+
+	cluster := Cluster{}
+	cluster.SASL.Password = "env:CLUSTER_PASSWORD"
+	a.Nil(cluster.ResolveSecrets(resolve))
+	a.Equal("resolved-CLUSTER_PASSWORD", cluster.SASL.Password)
+}
+
+func TestParseUnixPeerPrincipal(t *testing.T) {
+	a := assert.New(t)
+
+	kind, id, principal, err := ParseUnixPeerPrincipal("uid:1000=alice")
+	a.Nil(err)
+	a.Equal("uid", kind)
+	a.Equal(uint32(1000), id)
+	a.Equal("alice", principal)
+	kind, id, principal, err = ParseUnixPeerPrincipal("gid:2000 = team-a")
+	a.Nil(err)
+	a.Equal("gid", kind)
+	a.Equal(uint32(2000), id)
+	a.Equal("team-a", principal)
+	_, _, _, err = ParseUnixPeerPrincipal("user:1000=alice")
+	a.EqualError(err, "unix peer principal 'user:1000=alice' must be in form 'uid:<uid>=principal' or 'gid:<gid>=principal'")
+	_, _, _, err = ParseUnixPeerPrincipal("uid:alice=alice")
+	a.NotNil(err)
+
+	listenerAddress, path, err := ParseListenerUnixSocket("127.0.0.1:32400=/var/run/kafka-proxy/broker-0.sock")
+	a.Nil(err)
+	a.Equal("127.0.0.1:32400", listenerAddress)
+	a.Equal("/var/run/kafka-proxy/broker-0.sock", path)
+	_, _, err = ParseListenerUnixSocket("/var/run/kafka-proxy/broker-0.sock")
+	a.EqualError(err, "listener unix socket '/var/run/kafka-proxy/broker-0.sock' must be in form 'listenerAddress=socket path'")
+
+	c := NewConfig()
+	c.Proxy.BootstrapServers = []ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:32400", AdvertisedAddress: "127.0.0.1:32400"}}
+	c.Auth.UnixPeer.Enable = true
+	a.EqualError(c.Validate(), "ListenerUnixSockets are required when Auth.UnixPeer.Enable is enabled")
+}
+
+func TestValidateTunnel(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Tunnel.Relay.ListenAddress = "0.0.0.0:7000"
+	a.EqualError(c.Validate(), "Tunnel.Token or Tunnel.Relay.CA.CertFile is required when Tunnel.Relay.ListenAddress is set")
+	c.Tunnel.Token = "secret"
+	a.Nil(c.Validate())
+	c.Tunnel.Relay.TLS.Enable = true
+	a.EqualError(c.Validate(), "Tunnel.Relay.TLS.CertFile and Tunnel.Relay.TLS.KeyFile are required when Tunnel.Relay.TLS.Enable is enabled")
+	c.Tunnel.Relay.CA.CertFile = "ca.pem"
+	a.EqualError(c.Validate(), "Both Tunnel.Relay.CA.CertFile and Tunnel.Relay.CA.KeyFile must be provided")
+	c.Tunnel.Relay.CA.KeyFile = "ca-key.pem"
+	a.Nil(c.Validate())
+	c.Tunnel.Relay.CA.CertFile = ""
+	c.Tunnel.Relay.CA.KeyFile = ""
+	c.Tunnel.Relay.TLS.Enable = false
+	c.Tunnel.JoinToken = "join-secret"
+	a.EqualError(c.Validate(), "Tunnel.Relay.CA.CertFile is required when Tunnel.JoinToken is set")
+
+	c = NewConfig()
+	a.EqualError(c.ValidateTunnelAgent(), "Tunnel.Agent.RelayAddress must not be empty")
+	c.Tunnel.Agent.RelayAddress = "relay.example.com:7000"
+	a.EqualError(c.ValidateTunnelAgent(), "Tunnel.Token or Tunnel.Agent.CertDir must not be empty")
+	c.Tunnel.Token = "secret"
+	a.EqualError(c.ValidateTunnelAgent(), "Tunnel.Agent.ReconnectInterval must be greater than 0")
+	c.Tunnel.Agent.ReconnectInterval = 5 * time.Second
+	a.Nil(c.ValidateTunnelAgent())
+	c.Tunnel.JoinToken = "join-secret"
+	a.EqualError(c.ValidateTunnelAgent(), "Tunnel.Agent.CertDir and Tunnel.Agent.CAPin are required when Tunnel.JoinToken is set")
+	c.Tunnel.Agent.CertDir = "/var/lib/kafka-proxy"
+	c.Tunnel.Agent.CAPin = "sha256:0a1b"
+	a.Nil(c.ValidateTunnelAgent())
+}
+
+func TestValidateShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Shutdown.CloseBatchSize = 0
+	a.Nil(c.Validate())
+	c.Shutdown.Timeout = -time.Second
+	a.EqualError(c.Validate(), "Shutdown.Timeout must be greater or equal 0")
+	c.Shutdown.Timeout = time.Minute
+	a.EqualError(c.Validate(), "Shutdown.CloseBatchSize must be greater than 0")
+	c.Shutdown.CloseBatchSize = 10
+	c.Shutdown.CloseInterval = 0
+	a.EqualError(c.Validate(), "Shutdown.CloseInterval must be greater than 0")
+	c.Shutdown.CloseInterval = time.Second
+	a.Nil(c.Validate())
+}
+
+func TestValidateSocks5(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Proxy.Socks5.ListenAddress = "0.0.0.0"
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "Proxy.Socks5.ListenAddress is invalid")
+	c.Proxy.Socks5.ListenAddress = "0.0.0.0:1080"
+	a.Nil(c.Validate())
+	c.Proxy.Socks5.Username = "alice"
+	a.EqualError(c.Validate(), "Proxy.Socks5.Username and Proxy.Socks5.Password must be set together")
+	c.Proxy.Socks5.Password = "secret"
+	a.Nil(c.Validate())
+	c.Proxy.Socks5.HandshakeTimeout = 0
+	a.EqualError(c.Validate(), "Proxy.Socks5.HandshakeTimeout must be greater than 0")
+}
+
+func TestValidateClientCertFingerprints(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Proxy.TLS.ListenerClientCertFingerprintsFile = "enrolled-devices.txt"
+	a.Equal(30*time.Second, c.Proxy.TLS.ListenerClientCertFingerprintsReloadInterval)
+	a.Nil(c.Validate())
+	c.Proxy.TLS.ListenerClientCertFingerprintsReloadInterval = -time.Second
+	a.EqualError(c.Validate(), "ListenerClientCertFingerprintsReloadInterval must be greater or equal 0")
+}
+
+func TestValidateKeySigner(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = "server.pem"
+	a.EqualError(c.Validate(), "ListenerKeyFile and ListenerCertFile are required when Proxy TLS is enabled")
+	c.KeySigner.Enable = true
+	a.EqualError(c.Validate(), "Command is required when KeySigner.Enable is enabled")
+	c.KeySigner.Command = "build/key-signer"
+	a.EqualError(c.Validate(), "KeySigner.Timeout must be greater than 0")
+	c.KeySigner.Timeout = time.Second
+	a.Nil(c.Validate())
+}
+
+func TestValidateACME(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"kafka-1:9092,0.0.0.0:32400,kafka-1.example.com:32400", "kafka-2:9092,0.0.0.0:32401"}))
+	c.Proxy.DefaultListenerIP = "10.0.0.1"
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ACME.Enable = true
+	a.EqualError(c.Validate(), "Proxy.TLS.ACME.CacheDir is required to keep the certificate across restarts")
+	c.Proxy.TLS.ACME.CacheDir = "/var/lib/kafka-proxy/acme"
+	a.Nil(c.Validate())
+	// IP addresses are skipped
+	a.Equal([]string{"kafka-1.example.com"}, c.ACMEHosts())
+	c.Proxy.DynamicPorts.AdvertisedHost = "kafka.example.com"
+	a.Equal([]string{"kafka-1.example.com", "kafka.example.com"}, c.ACMEHosts())
+
+	c.Proxy.TLS.ACME.Hosts = []string{"*.example.com"}
+	a.EqualError(c.Validate(), "Proxy.TLS.ACME.Hosts wildcard *.example.com requires the dns-01 challenge")
+	c.Proxy.TLS.ACME.Challenge = ACMEChallengeDNS
+	a.EqualError(c.Validate(), "Proxy.TLS.ACME.DNSHookCommand is required by the dns-01 challenge")
+	c.Proxy.TLS.ACME.DNSHookCommand = "/usr/local/bin/update-txt-record"
+	a.Nil(c.Validate())
+	c.Proxy.TLS.ACME.Challenge = "tls-alpn-01"
+	a.EqualError(c.Validate(), "Proxy.TLS.ACME.Challenge must be http-01 or dns-01, got 'tls-alpn-01'")
+
+	c = NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"kafka-1:9092,0.0.0.0:32400"}))
+	c.Proxy.TLS.Enable = true
+	a.NotNil(c.Validate())
+	c.Proxy.TLS.ACME.Enable = true
+	c.Proxy.TLS.ACME.CacheDir = "/var/lib/kafka-proxy/acme"
+	a.EqualError(c.Validate(), "Proxy.TLS.ACME.Hosts must be set, the advertised addresses have no hostnames")
+	c.Proxy.TLS.ACME.Hosts = []string{"kafka.example.com"}
+	a.Nil(c.Validate())
+	// the listener with own certificate needs the key
+	c.Proxy.TLS.ListenerCertFile = "server.crt"
+	a.NotNil(c.Validate())
+}
+
+func TestValidateTopicMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.TopicMetrics.Enable = true
+	a.EqualError(c.Validate(), "TopicMetrics.Topics or TopicMetrics.HashBuckets must be set to bound the cardinality of the topic metrics")
+	c.TopicMetrics.HashBuckets = 16
+	a.Nil(c.Validate())
+	c.TopicMetrics.Topics = []string{"orders("}
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "TopicMetrics.Topics 'orders(' is not a valid regular expression")
+}
+
+func TestValidateTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	a.Nil(c.Validate())
+	c.Telemetry.Mode = "drop"
+	a.EqualError(c.Validate(), "Telemetry.Mode must be forward, strip or terminate, got 'drop'")
+	c.Telemetry.Mode = TelemetryModeTerminate
+	a.Nil(c.Validate())
+	c.Telemetry.PushInterval = 100 * time.Millisecond
+	a.EqualError(c.Validate(), "Telemetry.PushInterval must be between 1s and 2147483647ms")
+	c.Telemetry.PushInterval = time.Minute
+	c.Telemetry.MaxBytes = 0
+	a.EqualError(c.Validate(), "Telemetry.MaxBytes must be between 1 and 2147483647")
+}
+
+func TestValidateCorrelationLog(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.CorrelationLog.SamplePercent = 10
+	a.Nil(c.Validate())
+	c.CorrelationLog.SamplePercent = 101
+	a.EqualError(c.Validate(), "CorrelationLog.SamplePercent must be between 0 and 100")
+}
+
+func TestValidateMaxInFlightRequests(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Kafka.MaxInFlightRequests = 5
+	a.Nil(c.Validate())
+	c.Kafka.MaxInFlightRequests = -1
+	a.EqualError(c.Validate(), "MaxInFlightRequests must be greater or equal 0")
+}
+
+func TestValidateLoadShedding(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.LoadShedding.MaxConnections = 1000
+	c.LoadShedding.MaxCPU = 80
+	a.Nil(c.Validate())
+	c.LoadShedding.MaxCPU = 120
+	a.EqualError(c.Validate(), "LoadShedding.MaxCPU must be between 0 and 100")
+	c.LoadShedding.MaxCPU = 80
+	c.LoadShedding.CPUInterval = 0
+	a.EqualError(c.Validate(), "LoadShedding.CPUInterval must be greater than 0")
+	c.LoadShedding.MaxConnections = -1
+	a.EqualError(c.Validate(), "LoadShedding.MaxConnections must be greater or equal 0")
+}
+
+func TestValidateAckSpoofing(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.AckSpoofing.Enable = true
+	c.AckSpoofing.QueueSize = 100
+	c.AckSpoofing.Topics = []string{"^telemetry-"}
+	a.Nil(c.Validate())
+	c.AckSpoofing.Topics = []string{"["}
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "AckSpoofing.Topics")
+	c.AckSpoofing.Topics = nil
+	c.AckSpoofing.QueueSize = 0
+	a.EqualError(c.Validate(), "AckSpoofing.QueueSize must be greater than 0")
+}
+
+func TestValidatePlugins(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Plugins.Hardened = true
+	a.EqualError(c.Validate(), "Plugins.Dir is required when Plugins.ManifestFile, Plugins.PublicKeyFile or Plugins.Hardened is set")
+	c.Plugins.Dir = "/opt/kafka-proxy/plugins"
+	a.EqualError(c.Validate(), "Plugins.PublicKeyFile is required when Plugins.Hardened is enabled")
+	c.Plugins.PublicKeyFile = "/etc/kafka-proxy/plugins.pub"
+	a.Nil(c.Validate())
+}
+
+func TestValidateMaintenance(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Maintenance.Topics = []string{"("}
+	err := c.Validate()
+	a.NotNil(err)
+	a.Contains(err.Error(), "Maintenance.Topics '(' is not a valid regular expression")
+	c.Maintenance.Topics = []string{"^orders$"}
+	c.Maintenance.ApiKeys = []int{-1}
+	a.EqualError(c.Validate(), "Maintenance.ApiKeys -1 is not a valid api key")
+	c.Maintenance.ApiKeys = []int{19}
+	a.Nil(c.Validate())
+}
+
+func TestValidateLocalDowngradeProtection(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Auth.Local.DowngradeProtection = true
+	a.EqualError(c.Validate(), "Auth.Local.DowngradeProtection requires Auth.Local.Enable")
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Mechanisms = []string{SASLScramSHA512, "PLAIN"}
+	c.Auth.Local.Command = "build/auth-user"
+	c.Auth.Local.Timeout = 10 * time.Second
+	a.Nil(c.Validate())
+}
+
+func TestValidateApiVersionsCache(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.ApiVersionsCache.TTL = -time.Second
+	a.EqualError(c.Validate(), "ApiVersionsCache.TTL must be greater or equal 0")
+	c.ApiVersionsCache.TTL = time.Minute
+	a.Nil(c.Validate())
+}
+
+func TestValidateMetadataCache(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.MetadataCache.TTL = -time.Second
+	a.EqualError(c.Validate(), "MetadataCache.TTL must be greater or equal 0")
+	c.MetadataCache.TTL = time.Second
+	a.EqualError(c.Validate(), "MetadataCache.MaxEntries must be greater than 0")
+	c.MetadataCache.MaxEntries = 1000
+	a.Nil(c.Validate())
+}
+
+func TestValidateOutageBuffer(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.OutageBuffer.Dir = "/var/lib/kafka-proxy/outage"
+	c.OutageBuffer.MaxBytes = 1 << 20
+	c.OutageBuffer.Window = time.Minute
+	c.OutageBuffer.RetryInterval = time.Second
+	a.Nil(c.Validate())
+	c.OutageBuffer.MaxBytes = 0
+	a.EqualError(c.Validate(), "OutageBuffer.MaxBytes must be greater than 0")
+	c.OutageBuffer.MaxBytes = 1 << 20
+	c.OutageBuffer.Window = 0
+	a.EqualError(c.Validate(), "OutageBuffer.Window must be greater than 0")
+	c.OutageBuffer.Window = time.Minute
+	c.OutageBuffer.RetryInterval = 0
+	a.EqualError(c.Validate(), "OutageBuffer.RetryInterval must be greater than 0")
+	c.OutageBuffer.RetryInterval = time.Second
+	c.Rewrite.TopicPrefix = "tenant-a."
+	a.EqualError(c.Validate(), "OutageBuffer.Dir cannot be used with the local or gateway authentication, OPA, topic rewriting or schema validation")
+	c.Rewrite.TopicPrefix = ""
+
+	// the produce policies and record transformations are not applied to the buffered requests
+	c.Kafka.ForbiddenApiVersions = []string{"0=0-2"}
+	a.EqualError(c.Validate(), "OutageBuffer.Dir cannot be used when the produce requests are forbidden by Kafka.ReadOnly, Kafka.ForbiddenApiKeys or Kafka.ForbiddenApiVersions")
+	c.Kafka.ForbiddenApiVersions = []string{"1=0-2"}
+	a.Nil(c.Validate())
+	c.Kafka.ForbiddenApiKeys = []int{0}
+	a.NotNil(c.Validate())
+	c.Kafka.ForbiddenApiKeys = nil
+	c.Kafka.ReadOnly = true
+	a.NotNil(c.Validate())
+	c.Kafka.ReadOnly = false
+	c.RecordFields.Redact = []string{"^payments$=card.number"}
+	a.EqualError(c.Validate(), "OutageBuffer.Dir cannot be used with the redaction or encryption of the record fields or the produce recompression")
+	c.RecordFields.Redact = nil
+	c.Recompression.ProduceCodec = "gzip"
+	a.NotNil(c.Validate())
+	c.Recompression.ProduceCodec = ""
+	c.Maintenance.Writes = true
+	a.EqualError(c.Validate(), "OutageBuffer.Dir cannot be used with the maintenance rules of the produce requests")
+	c.Maintenance.Writes = false
+	c.ClientID.Deny = []string{"^legacy-"}
+	a.EqualError(c.Validate(), "OutageBuffer.Dir cannot be used with the compression policy or the denied client ids")
+	c.ClientID.Deny = nil
+	c.Compression.AllowedCodecs = []string{"zstd"}
+	a.NotNil(c.Validate())
+}
+
+func TestValidateSLO(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.SLO.AdminEnable = true
+	a.EqualError(c.Validate(), "SLO.AdminEnable requires SLO.Enable")
+	c.SLO.Enable = true
+	a.Nil(c.Validate())
+	c.SLO.Objective = 100
+	a.EqualError(c.Validate(), "SLO.Objective must be greater than 0 and less than 100")
+	c.SLO.Objective = 99.5
+	c.SLO.LatencyThreshold = 0
+	a.EqualError(c.Validate(), "SLO.LatencyThreshold must be greater than 0")
+}
+
+func TestValidateDryRun(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.DryRun.Policies = []string{DryRunApiKeys, DryRunEgress}
+	a.Nil(c.Validate())
+	c.DryRun.Policies = []string{"acl"}
+	a.EqualError(c.Validate(), "DryRun.Policies must be api-keys, client-id-deny, client-id-throttle, transactions, egress or opa, got 'acl'")
+}
+
+func TestValidateOPA(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.OPA.URL = "http://127.0.0.1:8181/v1/data/kafka/authz/allow"
+	a.Nil(c.Validate())
+	c.OPA.CacheSize = 0
+	a.EqualError(c.Validate(), "OPA.CacheSize must be greater than 0")
+	c.OPA.CacheTTL = 0
+	a.Nil(c.Validate())
+	c.OPA.ApiKeys = []int{-1}
+	a.EqualError(c.Validate(), "OPA.ApiKeys must be between 0 and 32767, got -1")
+	c.OPA.ApiKeys = nil
+	c.OPA.URL = "127.0.0.1:8181"
+	a.EqualError(c.Validate(), "OPA.URL '127.0.0.1:8181' must be a http or https URL")
+}
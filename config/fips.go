@@ -0,0 +1,44 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// cipher suites approved by FIPS 140-2 which can be enabled in the FIPS mode
+	FIPSCipherSuites = []string{
+		"ECDHE-ECDSA-AES256-GCM-SHA384",
+		"ECDHE-RSA-AES256-GCM-SHA384",
+		"ECDHE-ECDSA-AES128-GCM-SHA256",
+		"ECDHE-RSA-AES128-GCM-SHA256",
+	}
+	FIPSCurvePreferences = []string{"P256", "P384"}
+)
+
+// validateFIPS rejects the listener TLS settings which are not FIPS compliant
+func (t ListenerTLS) validateFIPS(name string) error {
+	for _, v := range t.CipherSuites {
+		if !contains(FIPSCipherSuites, v) {
+			return errors.Errorf("%s cipher suite %s is not FIPS approved", name, v)
+		}
+	}
+	for _, v := range t.CurvePreferences {
+		if !contains(FIPSCurvePreferences, v) {
+			return errors.Errorf("%s curve %s is not FIPS approved", name, v)
+		}
+	}
+	switch t.MinVersion {
+	case "1.0", "1.1":
+		return errors.Errorf("%s min version %s is not allowed in FIPS mode", name, t.MinVersion)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
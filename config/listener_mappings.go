@@ -0,0 +1,82 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ListenerMappings are the bootstrap and external server mappings changed with the listeners admin API.
+// The mappings are in the format of the bootstrap-server-mapping and external-server-mapping flags.
+type ListenerMappings struct {
+	BootstrapServerMappings []string `yaml:"bootstrap-server-mapping"`
+	ExternalServerMappings  []string `yaml:"external-server-mapping"`
+}
+
+// Mapping returns the listener config in the format of the server mapping flags
+func (c ListenerConfig) Mapping() string {
+	if c.AdvertisedAddress == "" || c.AdvertisedAddress == c.ListenerAddress {
+		return c.BrokerAddress + "," + c.ListenerAddress
+	}
+	return c.BrokerAddress + "," + c.ListenerAddress + "," + c.AdvertisedAddress
+}
+
+// ParseListenerMapping parses the mapping in form 'remotehost:remoteport,localhost:localport(,advhost:advport)'
+func ParseListenerMapping(mapping string) (ListenerConfig, error) {
+	cfgs, err := getListenerConfigs([]string{strings.TrimSpace(mapping)})
+	if err != nil {
+		return ListenerConfig{}, err
+	}
+	return cfgs[0], nil
+}
+
+// LoadListenerMappings reads the mappings from the YAML file, nil is returned if the file does not exist
+func LoadListenerMappings(filename string) (*ListenerMappings, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mappings ListenerMappings
+	if err = yaml.UnmarshalStrict(data, &mappings); err != nil {
+		return nil, errors.Wrapf(err, "invalid listener mappings file %s", filename)
+	}
+	if _, err = getListenerConfigs(mappings.BootstrapServerMappings); err != nil {
+		return nil, errors.Wrapf(err, "invalid listener mappings file %s", filename)
+	}
+	if _, err = getListenerConfigs(mappings.ExternalServerMappings); err != nil {
+		return nil, errors.Wrapf(err, "invalid listener mappings file %s", filename)
+	}
+	return &mappings, nil
+}
+
+// SaveListenerMappings writes the mappings to the YAML file, the file is replaced atomically
+func SaveListenerMappings(filename string, mappings ListenerMappings) error {
+	data, err := yaml.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "cannot save listener mappings file %s", filename)
+	}
+	return nil
+}
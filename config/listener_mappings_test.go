@@ -0,0 +1,45 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenerMappingsFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "listener-mappings")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "listeners.yaml")
+
+	mappings, err := LoadListenerMappings(filename)
+	a.Nil(err)
+	a.Nil(mappings)
+
+	saved := ListenerMappings{
+		BootstrapServerMappings: []string{"kafka-0:9092,0.0.0.0:32400,proxy:32400"},
+		ExternalServerMappings:  []string{"kafka-1:9092,proxy-1:32401"},
+	}
+	a.Nil(SaveListenerMappings(filename, saved))
+	mappings, err = LoadListenerMappings(filename)
+	a.Nil(err)
+	a.Equal(&saved, mappings)
+
+	a.Nil(ioutil.WriteFile(filename, []byte("bootstrap-server-mapping: [\"kafka-0:9092\"]\n"), 0644))
+	_, err = LoadListenerMappings(filename)
+	a.EqualError(err, "invalid listener mappings file "+filename+": server-mapping must be in form 'remotehost:remoteport,localhost:localport(,advhost:advport)'")
+}
+
+func TestListenerConfigMapping(t *testing.T) {
+	a := assert.New(t)
+
+	for _, mapping := range []string{"kafka-0:9092,0.0.0.0:32400", "kafka-0:9092,0.0.0.0:32400,proxy:32400"} {
+		cfg, err := ParseListenerMapping(mapping)
+		a.Nil(err)
+		a.Equal(mapping, cfg.Mapping())
+	}
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+)
+
+const (
+	TLSClientAuthNone    = "none"
+	TLSClientAuthRequest = "request" // client certificates are verified if given
+	TLSClientAuthRequire = "require"
+	// client certificates are requested but not verified at handshake, invalid certificates are only logged
+	TLSClientAuthRequestAny = "request-any"
+	// client certificates are verified if given, clients without certificate are logged
+	TLSClientAuthWarn = "warn"
+)
+
+// ListenerTLS overrides the TLS settings of the proxy listener with the listener address.
+// Settings which are not set are inherited from the global proxy listener TLS settings.
+type ListenerTLS struct {
+	ListenerAddress string `yaml:"listener-address"`
+	// TLS is disabled on the listener when false, e.g. for a plaintext localhost listener
+	Enable           *bool    `yaml:"enable"`
+	CertFile         string   `yaml:"cert-file"`
+	KeyFile          string   `yaml:"key-file"`
+	KeyPassword      string   `yaml:"key-password"`
+	CAChainCertFile  string   `yaml:"ca-chain-cert-file"`
+	ClientAuth       string   `yaml:"client-auth"` // none, request-any, warn, request or require
+	CipherSuites     []string `yaml:"cipher-suites"`
+	CurvePreferences []string `yaml:"curve-preferences"`
+	MinVersion       string   `yaml:"min-version"` // 1.0, 1.1, 1.2 or 1.3
+	ALPNProtocols    []string `yaml:"alpn-protocols"`
+	// clients which don't offer one of the ALPN protocols are rejected at handshake
+	ALPNRequired *bool `yaml:"alpn-required"`
+	// session resumption with tickets is disabled on the listener when false
+	SessionTickets *bool `yaml:"session-tickets"`
+	// only the FIPS approved settings are allowed, set from the global FIPS mode
+	FIPS bool `yaml:"-"`
+	// the certificate is obtained with ACME if the listener has no cert file, set from the global ACME settings
+	ACME bool `yaml:"-"`
+	// the certificate is used without key file with the key of the key signer plugin, set from the global key signer settings
+	KeySigner bool `yaml:"-"`
+}
+
+// SignsWithKeySigner reports whether the private key of the listener certificate is used through the key signer plugin
+func (t ListenerTLS) SignsWithKeySigner() bool {
+	return t.KeySigner && t.CertFile != "" && t.KeyFile == ""
+}
+
+// ACMECertificate reports whether the certificate of the listener is obtained with ACME
+func (t ListenerTLS) ACMECertificate() bool {
+	return t.ACME && t.CertFile == "" && t.KeyFile == ""
+}
+
+// SessionTicketsEnabled reports whether the clients can resume the sessions with tickets
+func (t ListenerTLS) SessionTicketsEnabled() bool {
+	return t.SessionTickets == nil || *t.SessionTickets
+}
+
+// ALPNEnforced reports whether the clients must offer one of the ALPN protocols
+func (t ListenerTLS) ALPNEnforced() bool {
+	return t.ALPNRequired != nil && *t.ALPNRequired
+}
+
+// Enabled reports whether TLS is enabled on the listener
+func (t ListenerTLS) Enabled() bool {
+	return t.Enable != nil && *t.Enable
+}
+
+// VerifiesClientCerts reports whether the client certificates given at the handshake are verified by the CA chain
+func (t ListenerTLS) VerifiesClientCerts() bool {
+	return t.Enabled() && t.CAChainCertFile != "" && t.ClientAuth != TLSClientAuthNone && t.ClientAuth != TLSClientAuthRequestAny
+}
+
+type listenerTLSFile struct {
+	Listeners []ListenerTLS `yaml:"listeners"`
+}
+
+// LoadListenerTLS reads the listener TLS overrides from the YAML file
+func LoadListenerTLS(filename string) ([]ListenerTLS, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file listenerTLSFile
+	if err = yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "invalid listener TLS file %s", filename)
+	}
+	addresses := make(map[string]bool)
+	for _, listener := range file.Listeners {
+		if _, _, err := net.SplitHostPort(listener.ListenerAddress); err != nil {
+			return nil, errors.Wrapf(err, "listener address '%s' in %s must be in form 'host:port'", listener.ListenerAddress, filename)
+		}
+		if addresses[listener.ListenerAddress] {
+			return nil, errors.Errorf("listener %s is defined more than once in %s", listener.ListenerAddress, filename)
+		}
+		addresses[listener.ListenerAddress] = true
+	}
+	return file.Listeners, nil
+}
+
+// ListenerTLSOf returns the TLS settings of the listener address, the global settings are overridden by the listener specific ones
+func (c *Config) ListenerTLSOf(listenerAddress string) ListenerTLS {
+	enable := c.Proxy.TLS.Enable
+	alpnRequired := c.Proxy.TLS.ListenerALPNRequired
+	sessionTickets := !c.Proxy.TLS.ListenerSessionTicketsDisabled
+	result := ListenerTLS{
+		ListenerAddress:  listenerAddress,
+		Enable:           &enable,
+		CertFile:         c.Proxy.TLS.ListenerCertFile,
+		KeyFile:          c.Proxy.TLS.ListenerKeyFile,
+		KeyPassword:      c.Proxy.TLS.ListenerKeyPassword,
+		CAChainCertFile:  c.Proxy.TLS.CAChainCertFile,
+		ClientAuth:       c.Proxy.TLS.ListenerClientAuth,
+		CipherSuites:     c.Proxy.TLS.ListenerCipherSuites,
+		CurvePreferences: c.Proxy.TLS.ListenerCurvePreferences,
+		MinVersion:       c.Proxy.TLS.ListenerMinVersion,
+		ALPNProtocols:    c.Proxy.TLS.ListenerALPNProtocols,
+		ALPNRequired:     &alpnRequired,
+		SessionTickets:   &sessionTickets,
+		FIPS:             c.FIPS.Enable,
+		ACME:             c.Proxy.TLS.ACME.Enable,
+		KeySigner:        c.KeySigner.Enable,
+	}
+	for _, override := range c.Proxy.ListenerTLS {
+		if override.ListenerAddress != listenerAddress {
+			continue
+		}
+		if override.Enable != nil {
+			enable = *override.Enable
+		}
+		if override.CertFile != "" {
+			result.CertFile = override.CertFile
+			result.KeyFile = override.KeyFile
+			result.KeyPassword = override.KeyPassword
+		}
+		if override.CAChainCertFile != "" {
+			result.CAChainCertFile = override.CAChainCertFile
+		}
+		if override.ClientAuth != "" {
+			result.ClientAuth = override.ClientAuth
+		}
+		if len(override.CipherSuites) != 0 {
+			result.CipherSuites = override.CipherSuites
+		}
+		if len(override.CurvePreferences) != 0 {
+			result.CurvePreferences = override.CurvePreferences
+		}
+		if override.MinVersion != "" {
+			result.MinVersion = override.MinVersion
+		}
+		if len(override.ALPNProtocols) != 0 {
+			result.ALPNProtocols = override.ALPNProtocols
+		}
+		if override.ALPNRequired != nil {
+			alpnRequired = *override.ALPNRequired
+		}
+		if override.SessionTickets != nil {
+			sessionTickets = *override.SessionTickets
+		}
+	}
+	return result
+}
+
+func (t ListenerTLS) validate() error {
+	name := "Proxy TLS"
+	if t.ListenerAddress != "" {
+		name = "TLS of listener " + t.ListenerAddress
+	}
+	if !t.Enabled() {
+		return nil
+	}
+	if (t.KeyFile == "" || t.CertFile == "") && !t.ACMECertificate() && !t.SignsWithKeySigner() {
+		return errors.Errorf("ListenerKeyFile and ListenerCertFile are required when %s is enabled", name)
+	}
+	switch t.ClientAuth {
+	case "", TLSClientAuthNone:
+	case TLSClientAuthRequestAny:
+	case TLSClientAuthWarn, TLSClientAuthRequest, TLSClientAuthRequire:
+		if t.CAChainCertFile == "" {
+			return errors.Errorf("CAChainCertFile is required when %s client auth is %s", name, t.ClientAuth)
+		}
+	default:
+		return errors.Errorf("%s client auth must be none, request-any, warn, request or require, got '%s'", name, t.ClientAuth)
+	}
+	switch t.MinVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		return errors.Errorf("%s min version must be 1.0, 1.1, 1.2 or 1.3, got '%s'", name, t.MinVersion)
+	}
+	if t.ALPNEnforced() && len(t.ALPNProtocols) == 0 {
+		return errors.Errorf("ALPN protocols are required when %s ALPN is required", name)
+	}
+	if t.FIPS {
+		return t.validateFIPS(name)
+	}
+	return nil
+}
+
+// verifiesClientCerts reports whether a TLS listener of the proxy verifies the client certificates
+func (c *Config) verifiesClientCerts() bool {
+	if c.ListenerTLSOf("").VerifiesClientCerts() {
+		return true
+	}
+	for _, override := range c.Proxy.ListenerTLS {
+		if c.ListenerTLSOf(override.ListenerAddress).VerifiesClientCerts() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestLoadListenerTLS(t *testing.T) {
+	a := assert.New(t)
+
+	filename := writeClustersFile(t, `
+listeners:
+  - listener-address: "0.0.0.0:32400"
+    ca-chain-cert-file: /etc/kafka-proxy/clients-ca.pem
+    client-auth: require
+    min-version: "1.3"
+  - listener-address: "127.0.0.1:32500"
+    enable: false
+`)
+	defer os.Remove(filename)
+
+	listeners, err := LoadListenerTLS(filename)
+	a.Nil(err)
+	a.Len(listeners, 2)
+
+	c := NewConfig()
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = "/etc/kafka-proxy/server.pem"
+	c.Proxy.TLS.ListenerKeyFile = "/etc/kafka-proxy/server-key.pem"
+	c.Proxy.TLS.ListenerCipherSuites = []string{"ECDHE-RSA-AES256-GCM-SHA384"}
+	c.Proxy.ListenerTLS = listeners
+
+	external := c.ListenerTLSOf("0.0.0.0:32400")
+	a.True(external.Enabled())
+	a.Equal("/etc/kafka-proxy/server.pem", external.CertFile)
+	a.Equal("/etc/kafka-proxy/clients-ca.pem", external.CAChainCertFile)
+	a.Equal(TLSClientAuthRequire, external.ClientAuth)
+	a.Equal("1.3", external.MinVersion)
+	a.Equal([]string{"ECDHE-RSA-AES256-GCM-SHA384"}, external.CipherSuites)
+
+	a.False(c.ListenerTLSOf("127.0.0.1:32500").Enabled())
+
+	global := c.ListenerTLSOf("0.0.0.0:32600")
+	a.True(global.Enabled())
+	a.Equal("", global.CAChainCertFile)
+	a.Equal("", global.ClientAuth)
+}
+
+func TestLoadListenerTLSRejectsInvalidFiles(t *testing.T) {
+	a := assert.New(t)
+
+	filename := writeClustersFile(t, `
+listeners:
+  - listener-address: "0.0.0.0:32400"
+  - listener-address: "0.0.0.0:32400"
+`)
+	defer os.Remove(filename)
+	_, err := LoadListenerTLS(filename)
+	a.EqualError(err, "listener 0.0.0.0:32400 is defined more than once in "+filename)
+
+	filename = writeClustersFile(t, `
+listeners:
+  - listener-address: "32400"
+`)
+	defer os.Remove(filename)
+	_, err = LoadListenerTLS(filename)
+	a.NotNil(err)
+}
+
+func TestValidateListenerTLS(t *testing.T) {
+	a := assert.New(t)
+
+	enable := true
+	c := NewConfig()
+	c.Proxy.BootstrapServers = []ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "0.0.0.0:32400", AdvertisedAddress: "0.0.0.0:32400"}}
+	c.Proxy.ListenerTLS = []ListenerTLS{{ListenerAddress: "0.0.0.0:32400", Enable: &enable}}
+	a.EqualError(c.Validate(), "ListenerKeyFile and ListenerCertFile are required when TLS of listener 0.0.0.0:32400 is enabled")
+
+	c.Proxy.ListenerTLS[0].CertFile = "server.pem"
+	c.Proxy.ListenerTLS[0].KeyFile = "server-key.pem"
+	c.Proxy.ListenerTLS[0].ClientAuth = TLSClientAuthRequire
+	a.EqualError(c.Validate(), "CAChainCertFile is required when TLS of listener 0.0.0.0:32400 client auth is require")
+	c.Proxy.ListenerTLS[0].ClientAuth = "optional"
+	a.EqualError(c.Validate(), "TLS of listener 0.0.0.0:32400 client auth must be none, request-any, warn, request or require, got 'optional'")
+	c.Proxy.ListenerTLS[0].ClientAuth = TLSClientAuthWarn
+	a.EqualError(c.Validate(), "CAChainCertFile is required when TLS of listener 0.0.0.0:32400 client auth is warn")
+	c.Proxy.ListenerTLS[0].ClientAuth = TLSClientAuthRequestAny
+	a.Nil(c.Validate())
+	c.Proxy.ListenerTLS[0].ClientAuth = TLSClientAuthNone
+	c.Proxy.ListenerTLS[0].MinVersion = "1.4"
+	a.EqualError(c.Validate(), "TLS of listener 0.0.0.0:32400 min version must be 1.0, 1.1, 1.2 or 1.3, got '1.4'")
+	c.Proxy.ListenerTLS[0].MinVersion = "1.2"
+	a.Nil(c.Validate())
+}
+
+func TestValidateALPN(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Proxy.BootstrapServers = []ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "0.0.0.0:32400", AdvertisedAddress: "0.0.0.0:32400"}}
+	c.Kafka.TLS.ALPNRequired = true
+	a.EqualError(c.Validate(), "Kafka.TLS.ALPNProtocols are required when Kafka.TLS.ALPNRequired is enabled")
+	c.Kafka.TLS.ALPNProtocols = []string{"kafka"}
+	a.Nil(c.Validate())
+
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = "server.pem"
+	c.Proxy.TLS.ListenerKeyFile = "server-key.pem"
+	c.Proxy.TLS.ListenerALPNRequired = true
+	a.EqualError(c.Validate(), "ALPN protocols are required when Proxy TLS ALPN is required")
+
+	// the listener inherits the global protocols
+	required := false
+	c.Proxy.TLS.ListenerALPNProtocols = []string{"kafka"}
+	c.Proxy.ListenerTLS = []ListenerTLS{{ListenerAddress: "127.0.0.1:32500", ALPNRequired: &required}}
+	a.Nil(c.Validate())
+	a.Equal([]string{"kafka"}, c.ListenerTLSOf("127.0.0.1:32500").ALPNProtocols)
+	a.False(c.ListenerTLSOf("127.0.0.1:32500").ALPNEnforced())
+	a.True(c.ListenerTLSOf("0.0.0.0:32400").ALPNEnforced())
+}
+
+func TestListenerSessionTickets(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Proxy.BootstrapServers = []ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "0.0.0.0:32400", AdvertisedAddress: "0.0.0.0:32400"}}
+	c.Proxy.TLS.ListenerSessionTicketKeyRotation = -1
+	a.EqualError(c.Validate(), "ListenerSessionTicketKeyRotation must be greater or equal 0")
+	c.Proxy.TLS.ListenerSessionTicketKeyRotation = 0
+	c.Kafka.TLS.SessionCacheSize = -1
+	a.EqualError(c.Validate(), "Kafka.TLS.SessionCacheSize must be greater or equal 0")
+	c.Kafka.TLS.SessionCacheSize = 0
+	a.Nil(c.Validate())
+
+	// the tickets are enabled unless disabled globally or on the listener
+	a.True(c.ListenerTLSOf("0.0.0.0:32400").SessionTicketsEnabled())
+	disabled := false
+	c.Proxy.ListenerTLS = []ListenerTLS{{ListenerAddress: "127.0.0.1:32500", SessionTickets: &disabled}}
+	a.False(c.ListenerTLSOf("127.0.0.1:32500").SessionTicketsEnabled())
+	c.Proxy.TLS.ListenerSessionTicketsDisabled = true
+	a.False(c.ListenerTLSOf("0.0.0.0:32400").SessionTicketsEnabled())
+}
+
+func TestValidateFIPS(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Proxy.BootstrapServers = []ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "0.0.0.0:32400", AdvertisedAddress: "0.0.0.0:32400"}}
+	c.FIPS.RequireBoringCrypto = true
+	a.EqualError(c.Validate(), "FIPS.Enable is required when FIPS.RequireBoringCrypto is enabled")
+	c.FIPS.Enable = true
+	a.Nil(c.Validate())
+
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = "server.pem"
+	c.Proxy.TLS.ListenerKeyFile = "server-key.pem"
+	c.Proxy.TLS.ListenerCipherSuites = []string{"ECDHE-RSA-AES128-GCM-SHA256", "ECDHE-RSA-WITH-CHACHA20-POLY1305"}
+	a.EqualError(c.Validate(), "Proxy TLS cipher suite ECDHE-RSA-WITH-CHACHA20-POLY1305 is not FIPS approved")
+	c.Proxy.TLS.ListenerCipherSuites = []string{"ECDHE-RSA-AES128-GCM-SHA256"}
+	c.Proxy.TLS.ListenerCurvePreferences = []string{"X25519"}
+	a.EqualError(c.Validate(), "Proxy TLS curve X25519 is not FIPS approved")
+	c.Proxy.TLS.ListenerCurvePreferences = []string{"P384"}
+	a.Nil(c.Validate())
+	c.Kafka.TLS.CurvePreferences = []string{"X25519MLKEM768", "P256"}
+	a.EqualError(c.Validate(), "Kafka TLS curve X25519MLKEM768 is not FIPS approved")
+	c.Kafka.TLS.CurvePreferences = nil
+
+	c.Proxy.ListenerTLS = []ListenerTLS{{ListenerAddress: "0.0.0.0:32400", MinVersion: "1.1"}}
+	a.EqualError(c.Validate(), "TLS of listener 0.0.0.0:32400 min version 1.1 is not allowed in FIPS mode")
+}
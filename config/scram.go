@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+
+	defaultScramIterations = 4096
+)
+
+// ScramCredential is the SCRAM credential of the user used by the local authentication.
+// Either the salted keys or the password, which is salted when the credentials are loaded, are set.
+type ScramCredential struct {
+	Username   string
+	Mechanism  string
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+	Password   string
+}
+
+// LoadScramCredentials reads the credentials file, one credential per line. Empty lines and lines starting with # are skipped.
+func LoadScramCredentials(filename string) ([]ScramCredential, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	credentials := make([]ScramCredential, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		credential, err := ParseScramCredential(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid SCRAM credential in %s line %d", filename, line)
+		}
+		credentials = append(credentials, credential)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// ParseScramCredential parses the credential in the format of kafka-configs
+// 'username mechanism=[salt=base64,stored_key=base64,server_key=base64,iterations=number]' or 'username mechanism=[password=secret(,iterations=number)]'
+func ParseScramCredential(v string) (ScramCredential, error) {
+	var credential ScramCredential
+	fields := strings.Fields(v)
+	if len(fields) != 2 {
+		return credential, errors.Errorf("SCRAM credential '%s' must be in form 'username mechanism=[attributes]'", v)
+	}
+	credential.Username = fields[0]
+	pair := strings.SplitN(fields[1], "=", 2)
+	if len(pair) != 2 || !strings.HasPrefix(pair[1], "[") || !strings.HasSuffix(pair[1], "]") {
+		return credential, errors.Errorf("SCRAM credential '%s' must be in form 'username mechanism=[attributes]'", v)
+	}
+	credential.Mechanism = pair[0]
+	if credential.Mechanism != SASLScramSHA256 && credential.Mechanism != SASLScramSHA512 {
+		return credential, errors.Errorf("SCRAM credential '%s' mechanism must be %s or %s", v, SASLScramSHA256, SASLScramSHA512)
+	}
+	credential.Iterations = defaultScramIterations
+	for _, attribute := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(pair[1], "["), "]"), ",") {
+		kv := strings.SplitN(attribute, "=", 2)
+		if len(kv) != 2 {
+			return credential, errors.Errorf("SCRAM credential '%s' attribute '%s' must be in form 'name=value'", v, attribute)
+		}
+		var err error
+		switch kv[0] {
+		case "salt":
+			credential.Salt, err = base64.StdEncoding.DecodeString(kv[1])
+		case "stored_key":
+			credential.StoredKey, err = base64.StdEncoding.DecodeString(kv[1])
+		case "server_key":
+			credential.ServerKey, err = base64.StdEncoding.DecodeString(kv[1])
+		case "iterations":
+			credential.Iterations, err = strconv.Atoi(kv[1])
+			if err == nil && credential.Iterations < 1 {
+				err = errors.New("must be greater than 0")
+			}
+		case "password":
+			credential.Password = kv[1]
+		default:
+			err = errors.New("is unknown")
+		}
+		if err != nil {
+			return credential, errors.Errorf("SCRAM credential '%s' attribute %s %v", v, kv[0], err)
+		}
+	}
+	salted := len(credential.Salt) != 0 && len(credential.StoredKey) != 0 && len(credential.ServerKey) != 0
+	if salted == (credential.Password != "") {
+		return credential, errors.Errorf("SCRAM credential '%s' must have either salt, stored_key and server_key or password", v)
+	}
+	return credential, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseScramCredential(t *testing.T) {
+	a := assert.New(t)
+
+	credential, err := ParseScramCredential("alice SCRAM-SHA-256=[salt=c2FsdA==,stored_key=c3RvcmVk,server_key=c2VydmVy,iterations=8192]")
+	a.Nil(err)
+	a.Equal(ScramCredential{Username: "alice", Mechanism: SASLScramSHA256, Iterations: 8192, Salt: []byte("salt"), StoredKey: []byte("stored"), ServerKey: []byte("server")}, credential)
+
+	credential, err = ParseScramCredential("bob SCRAM-SHA-512=[password=secret]")
+	a.Nil(err)
+	a.Equal(ScramCredential{Username: "bob", Mechanism: SASLScramSHA512, Iterations: 4096, Password: "secret"}, credential)
+
+	_, err = ParseScramCredential("bob")
+	a.EqualError(err, "SCRAM credential 'bob' must be in form 'username mechanism=[attributes]'")
+	_, err = ParseScramCredential("bob PLAIN=[password=secret]")
+	a.EqualError(err, "SCRAM credential 'bob PLAIN=[password=secret]' mechanism must be SCRAM-SHA-256 or SCRAM-SHA-512")
+	_, err = ParseScramCredential("bob SCRAM-SHA-512=[password=secret,iterations=0]")
+	a.EqualError(err, "SCRAM credential 'bob SCRAM-SHA-512=[password=secret,iterations=0]' attribute iterations must be greater than 0")
+	_, err = ParseScramCredential("bob SCRAM-SHA-512=[salt=c2FsdA==]")
+	a.EqualError(err, "SCRAM credential 'bob SCRAM-SHA-512=[salt=c2FsdA==]' must have either salt, stored_key and server_key or password")
+}
+
+func TestValidateLocalSASLMechanisms(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"192.168.99.100:32400,0.0.0.0:32400"}))
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Mechanism = "PLAIN"
+	c.Auth.Local.Timeout = 1
+	a.EqualError(c.Validate(), "Command is required when Auth.Local.Enable is enabled")
+	a.Equal([]string{"PLAIN"}, c.LocalSASLMechanisms())
+
+	c.Auth.Local.Mechanisms = []string{SASLScramSHA256, SASLScramSHA512}
+	a.EqualError(c.Validate(), "ScramCredentialsFile or Command is required when Auth.Local mechanism SCRAM-SHA-256 is enabled")
+	c.Auth.Local.ScramCredentialsFile = "users.txt"
+	a.Nil(c.Validate())
+	c.Auth.Local.ScramCredentialsFile = ""
+	c.Auth.Local.Command = "scram-store"
+	a.Nil(c.Validate())
+
+	c.Auth.Local.Mechanisms = []string{"GSSAPI"}
+	a.EqualError(c.Validate(), "Mechanism PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER is required when Auth.Local.Enable is enabled, got 'GSSAPI'")
+}
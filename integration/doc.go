@@ -0,0 +1,5 @@
+// Package integration contains the tests of the proxy against real Kafka brokers started with docker.
+// The tests are built with the integration tag and skipped if docker is not available:
+//
+//	go test -tags integration -v ./integration
+package integration
@@ -0,0 +1,185 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	username = "alice"
+	password = "alice-secret"
+)
+
+// certificates are the PEM files of the CA and the broker certificate valid for 127.0.0.1
+type certificates struct {
+	dir      string
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func newCertificates(a *assert.Assertions) *certificates {
+	dir, err := ioutil.TempDir("", "integration-certs-")
+	a.Nil(err)
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-proxy integration CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	a.Nil(err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	a.Nil(err)
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, caCert, &key.PublicKey, caKey)
+	a.Nil(err)
+
+	c := &certificates{dir: dir, caFile: filepath.Join(dir, "ca.pem"), certFile: filepath.Join(dir, "server.pem"), keyFile: filepath.Join(dir, "server-key.pem")}
+	a.Nil(ioutil.WriteFile(c.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	a.Nil(ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	a.Nil(ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	return c
+}
+
+func (c *certificates) Close() {
+	os.RemoveAll(c.dir)
+}
+
+// roundTrip sends the request and returns the response body
+func roundTrip(conn net.Conn, apiKey, apiVersion int16, correlationID int32, body []byte) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(kafkatest.EncodeRequest(apiKey, apiVersion, correlationID, "integration", body)); err != nil {
+		return nil, err
+	}
+	_, response, err := kafkatest.ReadResponse(conn)
+	return response, err
+}
+
+// produceAndFetch produces the values to the auto created topic through the proxy and fetches them back
+func produceAndFetch(a *assert.Assertions, proxyAddress string, topic string, values [][]byte) {
+	conn, err := net.Dial("tcp", proxyAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	correlationID := int32(0)
+	// the topic is created by the Metadata request, the produce requests fail until the partition has a leader
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		correlationID++
+		_, err = roundTrip(conn, kafkatest.ApiKeyMetadata, 4, correlationID, kafkatest.MetadataRequestBody(4, []string{topic}))
+		a.Nil(err)
+		correlationID++
+		body, err := roundTrip(conn, kafkatest.ApiKeyProduce, 3, correlationID, kafkatest.ProduceRequestBody(topic, values))
+		a.Nil(err)
+		errorCode, err := kafkatest.DecodeProduceErrorCode(body)
+		a.Nil(err)
+		if errorCode == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			a.FailNow("produce failed", "error code %d", errorCode)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	correlationID++
+	body, err := roundTrip(conn, kafkatest.ApiKeyFetch, 4, correlationID, kafkatest.FetchRequestBody(topic, 0, 0))
+	a.Nil(err)
+	errorCode, fetched, err := kafkatest.DecodeFetchValues(body)
+	a.Nil(err)
+	a.Equal(int16(0), errorCode)
+	a.Equal(values, fetched)
+}
+
+func startProxy(a *assert.Assertions, c *config.Config, opts ...proxy.Option) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	listenerAddress := l.Addr().String()
+	l.Close()
+
+	c.Proxy.BootstrapServers[0].ListenerAddress = listenerAddress
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = listenerAddress
+	a.Nil(c.Validate())
+	p, err := proxy.New(c, opts...)
+	a.Nil(err)
+	go p.Run(context.Background())
+	return listenerAddress, p.Close
+}
+
+func TestProduceFetchThroughProxy(t *testing.T) {
+	if !kafkatest.DockerAvailable() {
+		t.Skip("docker is not available")
+	}
+	a := assert.New(t)
+
+	certs := newCertificates(a)
+	defer certs.Close()
+	broker, err := kafkatest.StartKafkaContainer(kafkatest.KafkaContainerConfig{
+		Users:    map[string]string{username: password},
+		CertFile: certs.certFile,
+		KeyFile:  certs.keyFile,
+	})
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	defer broker.Close()
+
+	for _, protocol := range []string{kafkatest.SecurityProtocolPlaintext, kafkatest.SecurityProtocolSSL, kafkatest.SecurityProtocolSASLPlaintext, kafkatest.SecurityProtocolSASLSSL} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			a := assert.New(t)
+
+			c := config.NewConfig()
+			c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: broker.Addr(protocol)}}
+			c.Proxy.DisableDynamicListeners = true
+			if strings.HasSuffix(protocol, "SSL") {
+				c.Kafka.TLS.Enable = true
+				c.Kafka.TLS.CAChainCertFile = certs.caFile
+			}
+			if strings.HasPrefix(protocol, "SASL") {
+				c.Kafka.SASL.Enable = true
+				c.Kafka.SASL.Username = username
+				c.Kafka.SASL.Password = password
+			}
+			proxyAddress, stop := startProxy(a, c)
+			defer stop()
+
+			produceAndFetch(a, proxyAddress, "integration-"+strings.ToLower(strings.Replace(protocol, "_", "-", -1)), [][]byte{[]byte("hello"), []byte("kafka-proxy")})
+		})
+	}
+}
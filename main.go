@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/cmd/bench"
 	"github.com/grepplabs/kafka-proxy/cmd/kafka-proxy"
 	"github.com/grepplabs/kafka-proxy/cmd/tools"
 	"github.com/spf13/cobra"
@@ -20,8 +21,10 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(server.Server)
+	RootCmd.AddCommand(server.Agent)
 	RootCmd.AddCommand(server.Version)
 	RootCmd.AddCommand(tools.Tools)
+	RootCmd.AddCommand(bench.Bench)
 }
 
 func main() {
@@ -0,0 +1,34 @@
+package apis
+
+import (
+	"context"
+)
+
+// sides of the TLS connections of which the peer certificates are verified
+const (
+	CertificateSideListener = "listener" // client certificates presented to the proxy listeners
+	CertificateSideBroker   = "broker"   // certificates presented by the Kafka brokers
+)
+
+type CertificateVerifyRequest struct {
+	Side string
+	// DER encoded certificates presented by the peer, the leaf certificate first
+	RawCerts [][]byte
+	// DER encoded certificates of the first verified chain, empty if the chain is not verified e.g. with InsecureSkipVerify
+	VerifiedChain [][]byte
+}
+
+type CertificateVerifyResponse struct {
+	Success bool
+	Status  int32
+}
+
+// CertificateVerifier verifies the peer certificates after the standard chain verification e.g. to check custom extensions or to query an internal revocation service
+type CertificateVerifier interface {
+	// VerifyCertificate returns Success false to abort the handshake. The returned error is only used by the underlying rpc protocol
+	VerifyCertificate(ctx context.Context, request CertificateVerifyRequest) (CertificateVerifyResponse, error)
+}
+
+type CertificateVerifierFactory interface {
+	New(params []string) (CertificateVerifier, error)
+}
@@ -0,0 +1,44 @@
+package apis
+
+import (
+	"context"
+)
+
+type KeyPublicKeyRequest struct {
+}
+
+type KeyPublicKeyResponse struct {
+	Success bool
+	Status  int32
+	// DER encoded PKIX public key of the private key
+	PublicKey []byte
+}
+
+type KeySignRequest struct {
+	// digest of the signed message
+	Digest []byte
+	// crypto.Hash of the digest
+	Hash uint32
+	// RSA keys sign with PSS when true and with PKCS #1 v1.5 otherwise
+	PSS bool
+	// rsa.PSSOptions.SaltLength of the PSS signatures
+	PSSSaltLength int32
+}
+
+type KeySignResponse struct {
+	Success   bool
+	Status    int32
+	Signature []byte
+}
+
+// KeySigner signs with a private key which never leaves the PKCS#11 token, HSM or cloud KMS
+type KeySigner interface {
+	// PublicKey returns the public key of the private key. The returned error is only used by the underlying rpc protocol
+	PublicKey(ctx context.Context, request KeyPublicKeyRequest) (KeyPublicKeyResponse, error)
+	// Sign returns Success false if the digest could not be signed. The returned error is only used by the underlying rpc protocol
+	Sign(ctx context.Context, request KeySignRequest) (KeySignResponse, error)
+}
+
+type KeySignerFactory interface {
+	New(params []string) (KeySigner, error)
+}
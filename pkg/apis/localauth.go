@@ -7,3 +7,22 @@ type PasswordAuthenticator interface {
 type PasswordAuthenticatorFactory interface {
 	New(params []string) (PasswordAuthenticator, error)
 }
+
+// SessionAttributes are the limits of the authenticated user enforced by the proxy for the connection. Zero values are not limited.
+type SessionAttributes struct {
+	// maximal number of concurrent connections of the user
+	MaxConnections int32
+	// maximal number of requests per second of the connection
+	RequestsPerSecond float64
+	// maximal number of request bytes per second of the connection
+	RequestBytesPerSecond float64
+	// regular expressions of the topics the user may access, empty allows all topics
+	AllowedTopics []string
+}
+
+// AttributesPasswordAuthenticator is a PasswordAuthenticator which returns the session attributes of the authenticated users
+type AttributesPasswordAuthenticator interface {
+	PasswordAuthenticator
+	// AuthenticateWithAttributes returns the session attributes of the user, nil if the user is not limited
+	AuthenticateWithAttributes(username, password string) (bool, int32, *SessionAttributes, error)
+}
@@ -0,0 +1,10 @@
+package apis
+
+// RecordTransformer transforms the values of the records produced to and fetched from the Kafka brokers.
+// Topic names are the names used on the brokers. Nil value is a tombstone.
+type RecordTransformer interface {
+	// TransformProduced returns the value sent to the brokers
+	TransformProduced(topic string, value []byte) ([]byte, error)
+	// TransformFetched returns the value returned to the clients
+	TransformFetched(topic string, value []byte) ([]byte, error)
+}
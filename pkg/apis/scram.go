@@ -0,0 +1,26 @@
+package apis
+
+type ScramCredentialRequest struct {
+	// SCRAM-SHA-256 or SCRAM-SHA-512
+	Mechanism string
+	Username  string
+}
+
+type ScramCredentialResponse struct {
+	// false if the user has no credential for the mechanism
+	Found      bool
+	Status     int32
+	Iterations int32
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+type ScramCredentialStore interface {
+	// GetScramCredential returns the salted SCRAM credential of the user. The returned error is only used by the underlying rpc protocol
+	GetScramCredential(request ScramCredentialRequest) (ScramCredentialResponse, error)
+}
+
+type ScramCredentialStoreFactory interface {
+	New(params []string) (ScramCredentialStore, error)
+}
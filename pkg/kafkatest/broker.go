@@ -0,0 +1,560 @@
+// Package kafkatest provides an in-process fake Kafka broker for integration tests and local development.
+//
+// The broker speaks enough of the Kafka protocol to let clients pass through the proxy: ApiVersions, Metadata,
+// SaslHandshake / SaslAuthenticate (PLAIN) are answered properly, Produce and Fetch requests are echoed back
+// unless the Produce requests are answered with a configured error.
+// Only non flexible request versions are supported.
+package kafkatest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+const (
+	ApiKeyProduce          = int16(0)
+	ApiKeyFetch            = int16(1)
+	ApiKeyMetadata         = int16(3)
+	ApiKeyFindCoordinator  = int16(10)
+	ApiKeySaslHandshake    = int16(17)
+	ApiKeyApiVersions      = int16(18)
+	ApiKeySaslAuthenticate = int16(36)
+
+	errNone                      = int16(0)
+	errUnsupportedVersion        = int16(35)
+	errUnsupportedSaslMechanism  = int16(33)
+	errIllegalSaslState          = int16(34)
+	errSaslAuthenticationFailed  = int16(58)
+	maxRequestSize               = 100 * 1024 * 1024
+	saslPlain                    = "PLAIN"
+	defaultTopicPartitionsNumber = 1
+)
+
+// ApiVersion is a supported version range of an api key.
+type ApiVersion struct {
+	ApiKey     int16
+	MinVersion int16
+	MaxVersion int16
+}
+
+// DefaultApiVersions are the versions advertised in the ApiVersions response
+var DefaultApiVersions = []ApiVersion{
+	{ApiKey: ApiKeyProduce, MinVersion: 0, MaxVersion: 7},
+	{ApiKey: ApiKeyFetch, MinVersion: 0, MaxVersion: 10},
+	{ApiKey: ApiKeyMetadata, MinVersion: 0, MaxVersion: 7},
+	{ApiKey: ApiKeyFindCoordinator, MinVersion: 0, MaxVersion: 2},
+	{ApiKey: ApiKeySaslHandshake, MinVersion: 0, MaxVersion: 1},
+	{ApiKey: ApiKeyApiVersions, MinVersion: 0, MaxVersion: 2},
+	{ApiKey: ApiKeySaslAuthenticate, MinVersion: 0, MaxVersion: 0},
+}
+
+// requestHeader is the header of a request received by the broker
+type requestHeader struct {
+	ApiKey        int16
+	ApiVersion    int16
+	CorrelationID int32
+	ClientID      *string
+}
+
+// Config configures the fake broker. The zero value is a usable broker without SASL.
+type Config struct {
+	// Address to listen on, defaults to 127.0.0.1:0
+	ListenAddress string
+	// NodeID returned in Metadata responses
+	NodeID int32
+	// AdvertisedAddress returned in Metadata and FindCoordinator responses, defaults to the listener address
+	AdvertisedAddress string
+	// Topics returned in Metadata responses together with the number of partitions
+	Topics map[string]int32
+	// ApiVersions advertised in the ApiVersions response, defaults to DefaultApiVersions
+	ApiVersions []ApiVersion
+	// Users enables SASL/PLAIN authentication with the given username to password map
+	Users map[string]string
+	// Acls restricts the topics of the Metadata responses to the listed topics of the authenticated user, unlisted users see all topics
+	Acls map[string][]string
+	// ResponseSize is the size of Produce and Fetch response bodies. If zero, the request body is echoed.
+	ResponseSize int
+	// ProduceErrorCode answers the Produce requests (versions 3 - 7) with the error code for every partition instead of the echo
+	ProduceErrorCode int16
+}
+
+// Broker is a fake Kafka broker.
+type Broker struct {
+	cfg      Config
+	listener net.Listener
+	host     string
+	port     int32
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	requests map[int16]int
+}
+
+// NewBroker starts a fake broker listening on cfg.ListenAddress
+func NewBroker(cfg Config) (*Broker, error) {
+	listenAddress := cfg.ListenAddress
+	if listenAddress == "" {
+		listenAddress = "127.0.0.1:0"
+	}
+	if cfg.ApiVersions == nil {
+		cfg.ApiVersions = DefaultApiVersions
+	}
+	l, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	advertisedAddress := cfg.AdvertisedAddress
+	if advertisedAddress == "" {
+		advertisedAddress = l.Addr().String()
+	}
+	host, portStr, err := net.SplitHostPort(advertisedAddress)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	b := &Broker{
+		cfg:      cfg,
+		listener: l,
+		host:     host,
+		port:     int32(port),
+		conns:    make(map[net.Conn]struct{}),
+		requests: make(map[int16]int),
+	}
+	b.wg.Add(1)
+	go b.serve()
+	return b, nil
+}
+
+// Addr returns the listener address
+func (b *Broker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// RequestCount returns the number of received requests for the api key
+func (b *Broker) RequestCount(apiKey int16) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.requests[apiKey]
+}
+
+// Close stops the listener and closes all open connections
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.mu.Lock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go b.handle(conn)
+	}
+}
+
+type session struct {
+	conn          net.Conn
+	authenticated bool
+	// user authenticated by SASL/PLAIN
+	user string
+	// SaslHandshake v0 is followed by the raw (not framed) authentication bytes
+	rawSaslAuth bool
+}
+
+func (b *Broker) handle(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	s := &session{conn: conn, authenticated: b.cfg.Users == nil}
+	for {
+		var err error
+		if s.rawSaslAuth {
+			err = b.handleRawSaslAuth(s)
+		} else {
+			err = b.handleRequest(s)
+		}
+		if err != nil {
+			if err != io.EOF {
+				logrus.Debugf("kafkatest: broker connection from %v closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+func (b *Broker) handleRequest(s *session) error {
+	sizeBuf := make([]byte, 4)
+	if _, err := io.ReadFull(s.conn, sizeBuf); err != nil {
+		return err
+	}
+	size := int32(binary.BigEndian.Uint32(sizeBuf))
+	if size < 8 || size > maxRequestSize {
+		return fmt.Errorf("invalid request size %d", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s.conn, payload); err != nil {
+		return err
+	}
+	d := &decoder{raw: payload}
+	header, err := decodeRequestHeader(d)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.requests[header.ApiKey]++
+	b.mu.Unlock()
+
+	if !s.authenticated && header.ApiKey != ApiKeyApiVersions && header.ApiKey != ApiKeySaslHandshake && header.ApiKey != ApiKeySaslAuthenticate {
+		return fmt.Errorf("unauthenticated request with api key %d", header.ApiKey)
+	}
+
+	e := &encoder{}
+	switch header.ApiKey {
+	case ApiKeyApiVersions:
+		b.apiVersionsResponse(e, header.ApiVersion)
+	case ApiKeyMetadata:
+		if err = b.metadataResponse(s, e, header.ApiVersion, d); err != nil {
+			return err
+		}
+	case ApiKeyFindCoordinator:
+		b.findCoordinatorResponse(e, header.ApiVersion)
+	case ApiKeySaslHandshake:
+		if err = b.saslHandshakeResponse(s, e, header.ApiVersion, d); err != nil {
+			return err
+		}
+	case ApiKeySaslAuthenticate:
+		if err = b.saslAuthenticateResponse(s, e, d); err != nil {
+			return err
+		}
+	case ApiKeyProduce, ApiKeyFetch:
+		if header.ApiKey == ApiKeyProduce && produceAcks(header.ApiVersion, payload[d.off:]) == 0 {
+			// produce requests with acks 0 are not answered
+			return nil
+		}
+		if header.ApiKey == ApiKeyProduce && b.cfg.ProduceErrorCode != errNone {
+			if err = produceErrorResponse(e, header.ApiVersion, d, b.cfg.ProduceErrorCode); err != nil {
+				return err
+			}
+		} else if b.cfg.ResponseSize > 0 {
+			e.buf = make([]byte, b.cfg.ResponseSize)
+		} else {
+			e.buf = append(e.buf, payload[d.off:]...)
+		}
+	default:
+		return fmt.Errorf("unsupported api key %d", header.ApiKey)
+	}
+	return writeResponse(s.conn, header.CorrelationID, e.buf)
+}
+
+func decodeRequestHeader(d *decoder) (header requestHeader, err error) {
+	if header.ApiKey, err = d.getInt16(); err != nil {
+		return
+	}
+	if header.ApiVersion, err = d.getInt16(); err != nil {
+		return
+	}
+	if header.CorrelationID, err = d.getInt32(); err != nil {
+		return
+	}
+	header.ClientID, err = d.getNullableString()
+	return
+}
+
+// produceAcks returns acks of the Produce request body, -1 if it cannot be decoded
+func produceAcks(version int16, body []byte) int16 {
+	d := &decoder{raw: body}
+	if version >= 3 {
+		if _, err := d.getNullableString(); err != nil {
+			return -1
+		}
+	}
+	acks, err := d.getInt16()
+	if err != nil {
+		return -1
+	}
+	return acks
+}
+
+// produceErrorResponse answers every partition of the Produce request (versions 3 - 7) with the error code
+func produceErrorResponse(e *encoder, version int16, d *decoder, errorCode int16) error {
+	if _, err := d.getNullableString(); err != nil { // transactional id
+		return err
+	}
+	if _, err := d.getRaw(6); err != nil { // acks, timeout
+		return err
+	}
+	topics, err := d.getInt32()
+	if err != nil {
+		return err
+	}
+	e.putInt32(topics)
+	for i := int32(0); i < topics; i++ {
+		topic, err := d.getString()
+		if err != nil {
+			return err
+		}
+		partitions, err := d.getInt32()
+		if err != nil {
+			return err
+		}
+		e.putString(topic)
+		e.putInt32(partitions)
+		for j := int32(0); j < partitions; j++ {
+			partition, err := d.getInt32()
+			if err != nil {
+				return err
+			}
+			if _, err = d.getBytes(); err != nil {
+				return err
+			}
+			e.putInt32(partition)
+			e.putInt16(errorCode)
+			e.putInt64(-1) // base offset
+			e.putInt64(-1) // log append time
+			if version >= 5 {
+				e.putInt64(-1) // log start offset
+			}
+		}
+	}
+	e.putInt32(0) // throttle time
+	return nil
+}
+
+func writeResponse(w io.Writer, correlationID int32, body []byte) error {
+	buf := make([]byte, 8+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(body)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(correlationID))
+	copy(buf[8:], body)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (b *Broker) apiVersionsResponse(e *encoder, version int16) {
+	errorCode := errNone
+	if version > 2 {
+		// the client retries with version 0
+		errorCode = errUnsupportedVersion
+		version = 0
+	}
+	e.putInt16(errorCode)
+	e.putInt32(int32(len(b.cfg.ApiVersions)))
+	for _, v := range b.cfg.ApiVersions {
+		e.putInt16(v.ApiKey)
+		e.putInt16(v.MinVersion)
+		e.putInt16(v.MaxVersion)
+	}
+	if version >= 1 {
+		e.putInt32(0) // throttle_time_ms
+	}
+}
+
+func (b *Broker) metadataResponse(s *session, e *encoder, version int16, d *decoder) error {
+	if version < 0 || version > 7 {
+		return fmt.Errorf("unsupported metadata version %d", version)
+	}
+	requested, err := d.getNullableStringArray()
+	if err != nil {
+		return err
+	}
+	topics := make([]string, 0)
+	if requested == nil || (version == 0 && len(requested) == 0) {
+		for topic := range b.cfg.Topics {
+			topics = append(topics, topic)
+		}
+	} else {
+		topics = requested
+	}
+	if allowed, ok := b.cfg.Acls[s.user]; ok {
+		topics = filterTopics(topics, allowed)
+	}
+
+	if version >= 3 {
+		e.putInt32(0) // throttle_time_ms
+	}
+	// brokers
+	e.putInt32(1)
+	e.putInt32(b.cfg.NodeID)
+	e.putString(b.host)
+	e.putInt32(b.port)
+	if version >= 1 {
+		e.putNullableString(nil) // rack
+	}
+	if version >= 2 {
+		clusterID := "kafkatest"
+		e.putNullableString(&clusterID)
+	}
+	if version >= 1 {
+		e.putInt32(b.cfg.NodeID) // controller_id
+	}
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		partitions, ok := b.cfg.Topics[topic]
+		if ok {
+			e.putInt16(errNone)
+		} else {
+			e.putInt16(3) // UNKNOWN_TOPIC_OR_PARTITION
+		}
+		e.putString(topic)
+		if version >= 1 {
+			e.putBool(false) // is_internal
+		}
+		if partitions <= 0 && ok {
+			partitions = defaultTopicPartitionsNumber
+		}
+		e.putInt32(partitions)
+		for p := int32(0); p < partitions; p++ {
+			e.putInt16(errNone)
+			e.putInt32(p)
+			e.putInt32(b.cfg.NodeID) // leader
+			if version >= 7 {
+				e.putInt32(0) // leader_epoch
+			}
+			e.putInt32Array([]int32{b.cfg.NodeID}) // replicas
+			e.putInt32Array([]int32{b.cfg.NodeID}) // isr
+			if version >= 5 {
+				e.putInt32Array([]int32{}) // offline_replicas
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Broker) findCoordinatorResponse(e *encoder, version int16) {
+	if version >= 1 {
+		e.putInt32(0) // throttle_time_ms
+	}
+	e.putInt16(errNone)
+	if version >= 1 {
+		e.putNullableString(nil) // error_message
+	}
+	e.putInt32(b.cfg.NodeID)
+	e.putString(b.host)
+	e.putInt32(b.port)
+}
+
+func (b *Broker) saslHandshakeResponse(s *session, e *encoder, version int16, d *decoder) error {
+	mechanism, err := d.getString()
+	if err != nil {
+		return err
+	}
+	errorCode := errNone
+	if b.cfg.Users == nil || mechanism != saslPlain {
+		errorCode = errUnsupportedSaslMechanism
+	}
+	if s.authenticated && b.cfg.Users != nil {
+		errorCode = errIllegalSaslState
+	}
+	e.putInt16(errorCode)
+	if b.cfg.Users != nil {
+		e.putStringArray([]string{saslPlain})
+	} else {
+		e.putStringArray([]string{})
+	}
+	s.rawSaslAuth = errorCode == errNone && version == 0
+	return nil
+}
+
+func (b *Broker) saslAuthenticateResponse(s *session, e *encoder, d *decoder) error {
+	authBytes, err := d.getBytes()
+	if err != nil {
+		return err
+	}
+	if user, authErr := b.authenticate(authBytes); authErr != nil {
+		msg := authErr.Error()
+		e.putInt16(errSaslAuthenticationFailed)
+		e.putNullableString(&msg)
+	} else {
+		s.authenticated, s.user = true, user
+		e.putInt16(errNone)
+		e.putNullableString(nil)
+	}
+	e.putBytes([]byte{})
+	return nil
+}
+
+func (b *Broker) handleRawSaslAuth(s *session) error {
+	sizeBuf := make([]byte, 4)
+	if _, err := io.ReadFull(s.conn, sizeBuf); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(sizeBuf)
+	if size > maxRequestSize {
+		return fmt.Errorf("invalid sasl auth size %d", size)
+	}
+	authBytes := make([]byte, size)
+	if _, err := io.ReadFull(s.conn, authBytes); err != nil {
+		return err
+	}
+	// on failure the broker closes the connection
+	user, err := b.authenticate(authBytes)
+	if err != nil {
+		return err
+	}
+	s.rawSaslAuth = false
+	s.authenticated, s.user = true, user
+	_, err = s.conn.Write(make([]byte, 4))
+	return err
+}
+
+func (b *Broker) authenticate(authBytes []byte) (string, error) {
+	// [authzid] UTF8NUL authcid UTF8NUL passwd
+	tokens := make([]string, 0, 3)
+	start := 0
+	for i, c := range authBytes {
+		if c == 0 {
+			tokens = append(tokens, string(authBytes[start:i]))
+			start = i + 1
+		}
+	}
+	tokens = append(tokens, string(authBytes[start:]))
+	if len(tokens) != 3 {
+		return "", fmt.Errorf("invalid SASL/PLAIN request: expected 3 tokens, got %d", len(tokens))
+	}
+	if password, ok := b.cfg.Users[tokens[1]]; !ok || password != tokens[2] {
+		return "", fmt.Errorf("authentication failed for user %s", tokens[1])
+	}
+	return tokens[1], nil
+}
+
+// filterTopics returns the topics which are allowed
+func filterTopics(topics []string, allowed []string) []string {
+	filtered := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		for _, a := range allowed {
+			if topic == a {
+				filtered = append(filtered, topic)
+				break
+			}
+		}
+	}
+	return filtered
+}
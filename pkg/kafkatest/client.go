@@ -0,0 +1,373 @@
+package kafkatest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// EncodeRequest returns a size delimited request frame with the request header v1 and the encoded body
+func EncodeRequest(apiKey, apiVersion int16, correlationID int32, clientID string, body []byte) []byte {
+	e := &encoder{}
+	e.putInt32(0) // size placeholder
+	e.putInt16(apiKey)
+	e.putInt16(apiVersion)
+	e.putInt32(correlationID)
+	e.putString(clientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	return e.buf
+}
+
+// MetadataRequestBody encodes the topics list of the Metadata request (versions 0 - 7). Nil means all topics for version 1+.
+func MetadataRequestBody(apiVersion int16, topics []string) []byte {
+	e := &encoder{}
+	if topics == nil {
+		if apiVersion == 0 {
+			e.putInt32(0)
+		} else {
+			e.putInt32(-1)
+		}
+	} else {
+		e.putStringArray(topics)
+	}
+	if apiVersion >= 4 {
+		e.putBool(true) // allow_auto_topic_creation
+	}
+	return e.buf
+}
+
+// ProduceRequestBody encodes the Produce request (versions 3 - 7) with the values in one uncompressed record batch of partition 0
+func ProduceRequestBody(topic string, values [][]byte) []byte {
+	records := &encoder{}
+	for _, value := range values {
+		record := &encoder{}
+		record.putInt8(0)   // attributes
+		record.putVarint(0) // timestamp delta
+		record.putVarint(0) // offset delta
+		record.putVarintBytes(nil)
+		record.putVarintBytes(value)
+		record.putVarint(0) // headers
+		records.putVarintBytes(record.buf)
+	}
+	batch := &encoder{}
+	batch.putInt32(0) // partition leader epoch
+	batch.putInt8(2)  // magic
+	batch.putInt32(0) // crc of the attributes and the rest of the batch
+	batch.putInt16(0) // attributes
+	batch.putInt32(int32(len(values) - 1))
+	batch.putInt64(0)  // first timestamp
+	batch.putInt64(0)  // max timestamp
+	batch.putInt64(-1) // producer id
+	batch.putInt16(-1) // producer epoch
+	batch.putInt32(-1) // base sequence
+	batch.putInt32(int32(len(values)))
+	batch.buf = append(batch.buf, records.buf...)
+	binary.BigEndian.PutUint32(batch.buf[5:], crc32.Checksum(batch.buf[9:], crc32c))
+
+	recordSet := &encoder{}
+	recordSet.putInt64(0) // base offset
+	recordSet.putBytes(batch.buf)
+
+	e := &encoder{}
+	e.putNullableString(nil) // transactional id
+	e.putInt16(1)            // acks
+	e.putInt32(1000)         // timeout
+	e.putInt32(1)
+	e.putString(topic)
+	e.putInt32(1)
+	e.putInt32(0) // partition
+	e.putBytes(recordSet.buf)
+	return e.buf
+}
+
+// DecodeProduceErrorCode returns the first error code of the partitions in the Produce response body (versions 3 - 7)
+func DecodeProduceErrorCode(body []byte) (int16, error) {
+	d := &decoder{raw: body}
+	topics, err := d.getInt32()
+	if err != nil {
+		return 0, err
+	}
+	for i := int32(0); i < topics; i++ {
+		if _, err = d.getString(); err != nil {
+			return 0, err
+		}
+		partitions, err := d.getInt32()
+		if err != nil {
+			return 0, err
+		}
+		if partitions > 0 {
+			if _, err = d.getInt32(); err != nil { // partition
+				return 0, err
+			}
+			return d.getInt16()
+		}
+	}
+	return 0, fmt.Errorf("no partitions in produce response")
+}
+
+// FetchRequestBody encodes the Fetch request (version 4) of the partition from the offset
+func FetchRequestBody(topic string, partition int32, offset int64) []byte {
+	e := &encoder{}
+	e.putInt32(-1)      // replica id
+	e.putInt32(500)     // max wait ms
+	e.putInt32(1)       // min bytes
+	e.putInt32(1 << 20) // max bytes
+	e.putInt8(0)        // isolation level
+	e.putInt32(1)
+	e.putString(topic)
+	e.putInt32(1)
+	e.putInt32(partition)
+	e.putInt64(offset)
+	e.putInt32(1 << 20) // partition max bytes
+	return e.buf
+}
+
+// DecodeFetchValues returns the error code and the record values of the first partition in the Fetch response body (version 4)
+func DecodeFetchValues(body []byte) (int16, [][]byte, error) {
+	d := &decoder{raw: body}
+	if _, err := d.getInt32(); err != nil { // throttle time
+		return 0, nil, err
+	}
+	topics, err := d.getInt32()
+	if err != nil {
+		return 0, nil, err
+	}
+	if topics < 1 {
+		return 0, nil, fmt.Errorf("no topics in fetch response")
+	}
+	if _, err = d.getString(); err != nil {
+		return 0, nil, err
+	}
+	if _, err = d.getInt32(); err != nil { // partitions
+		return 0, nil, err
+	}
+	// partition, error_code, high_watermark, last_stable_offset
+	if _, err = d.getInt32(); err != nil {
+		return 0, nil, err
+	}
+	errorCode, err := d.getInt16()
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err = d.getRaw(16); err != nil {
+		return 0, nil, err
+	}
+	aborted, err := d.getInt32()
+	if err != nil {
+		return 0, nil, err
+	}
+	if aborted > 0 {
+		if _, err = d.getRaw(16 * int(aborted)); err != nil {
+			return 0, nil, err
+		}
+	}
+	records, err := d.getBytes()
+	if err != nil {
+		return 0, nil, err
+	}
+	values, err := decodeRecordBatches(records)
+	return errorCode, values, err
+}
+
+// decodeRecordBatches returns the values of the uncompressed record batches, a partial batch at the end is skipped
+func decodeRecordBatches(raw []byte) ([][]byte, error) {
+	values := make([][]byte, 0)
+	d := &decoder{raw: raw}
+	for d.remaining() >= 12 {
+		if _, err := d.getInt64(); err != nil { // base offset
+			return nil, err
+		}
+		length, err := d.getInt32()
+		if err != nil {
+			return nil, err
+		}
+		if d.remaining() < int(length) {
+			break
+		}
+		batch, _ := d.getRaw(int(length))
+		b := &decoder{raw: batch}
+		// partition leader epoch, magic, crc
+		if _, err = b.getRaw(9); err != nil {
+			return nil, err
+		}
+		attributes, err := b.getInt16()
+		if err != nil {
+			return nil, err
+		}
+		if attributes&0x7 != 0 {
+			return nil, fmt.Errorf("compressed record batches are not supported")
+		}
+		// last offset delta, timestamps, producer id and epoch, base sequence
+		if _, err = b.getRaw(4 + 8 + 8 + 8 + 2 + 4); err != nil {
+			return nil, err
+		}
+		count, err := b.getInt32()
+		if err != nil {
+			return nil, err
+		}
+		for i := int32(0); i < count; i++ {
+			if _, err = b.getVarint(); err != nil { // length
+				return nil, err
+			}
+			if _, err = b.getInt8(); err != nil { // attributes
+				return nil, err
+			}
+			// timestamp delta, offset delta
+			for j := 0; j < 2; j++ {
+				if _, err = b.getVarint(); err != nil {
+					return nil, err
+				}
+			}
+			if _, err = b.getVarintBytes(); err != nil { // key
+				return nil, err
+			}
+			value, err := b.getVarintBytes()
+			if err != nil {
+				return nil, err
+			}
+			headers, err := b.getVarint()
+			if err != nil {
+				return nil, err
+			}
+			for j := int64(0); j < headers; j++ {
+				if _, err = b.getVarintBytes(); err != nil {
+					return nil, err
+				}
+				if _, err = b.getVarintBytes(); err != nil {
+					return nil, err
+				}
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// ReadResponse reads a size delimited response frame and returns the correlation id and response body
+func ReadResponse(r io.Reader) (correlationID int32, body []byte, err error) {
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 || size > maxRequestSize {
+		return 0, nil, fmt.Errorf("invalid response size %d", size)
+	}
+	correlationID = int32(binary.BigEndian.Uint32(header[4:]))
+	body = make([]byte, size-4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return correlationID, body, nil
+}
+
+// BrokerAddress is a broker entry in the Metadata response
+type BrokerAddress struct {
+	NodeID int32
+	Host   string
+	Port   int32
+}
+
+// DecodeMetadataBrokers returns the brokers from the Metadata response body (versions 0 - 7)
+func DecodeMetadataBrokers(apiVersion int16, body []byte) ([]BrokerAddress, error) {
+	return decodeMetadataBrokers(apiVersion, &decoder{raw: body})
+}
+
+// DecodeMetadataTopics returns the topic names from the Metadata response body (versions 0 - 7)
+func DecodeMetadataTopics(apiVersion int16, body []byte) ([]string, error) {
+	d := &decoder{raw: body}
+	if _, err := decodeMetadataBrokers(apiVersion, d); err != nil {
+		return nil, err
+	}
+	if apiVersion >= 2 {
+		if _, err := d.getNullableString(); err != nil { // cluster_id
+			return nil, err
+		}
+	}
+	if apiVersion >= 1 {
+		if _, err := d.getInt32(); err != nil { // controller_id
+			return nil, err
+		}
+	}
+	n, err := d.getInt32()
+	if err != nil {
+		return nil, err
+	}
+	topics := make([]string, 0)
+	for i := int32(0); i < n; i++ {
+		if _, err = d.getInt16(); err != nil {
+			return nil, err
+		}
+		topic, err := d.getString()
+		if err != nil {
+			return nil, err
+		}
+		if apiVersion >= 1 {
+			if _, err = d.getRaw(1); err != nil { // is_internal
+				return nil, err
+			}
+		}
+		partitions, err := d.getInt32()
+		if err != nil {
+			return nil, err
+		}
+		for p := int32(0); p < partitions; p++ {
+			// error_code, partition, leader
+			if _, err = d.getRaw(10); err != nil {
+				return nil, err
+			}
+			if apiVersion >= 7 {
+				if _, err = d.getInt32(); err != nil { // leader_epoch
+					return nil, err
+				}
+			}
+			arrays := 2 // replicas, isr
+			if apiVersion >= 5 {
+				arrays++ // offline_replicas
+			}
+			for j := 0; j < arrays; j++ {
+				if err = d.skipInt32Array(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+func decodeMetadataBrokers(apiVersion int16, d *decoder) ([]BrokerAddress, error) {
+	if apiVersion >= 3 {
+		if _, err := d.getInt32(); err != nil {
+			return nil, err
+		}
+	}
+	n, err := d.getInt32()
+	if err != nil {
+		return nil, err
+	}
+	brokers := make([]BrokerAddress, 0)
+	for i := int32(0); i < n; i++ {
+		var broker BrokerAddress
+		if broker.NodeID, err = d.getInt32(); err != nil {
+			return nil, err
+		}
+		if broker.Host, err = d.getString(); err != nil {
+			return nil, err
+		}
+		if broker.Port, err = d.getInt32(); err != nil {
+			return nil, err
+		}
+		if apiVersion >= 1 {
+			if _, err = d.getNullableString(); err != nil {
+				return nil, err
+			}
+		}
+		brokers = append(brokers, broker)
+	}
+	return brokers, nil
+}
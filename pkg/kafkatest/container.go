@@ -0,0 +1,271 @@
+package kafkatest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultKafkaImage is the image of the KRaft broker started by StartKafkaContainer
+	DefaultKafkaImage = "apache/kafka:3.9.0"
+
+	SecurityProtocolPlaintext     = "PLAINTEXT"
+	SecurityProtocolSSL           = "SSL"
+	SecurityProtocolSASLPlaintext = "SASL_PLAINTEXT"
+	SecurityProtocolSASLSSL       = "SASL_SSL"
+
+	containerConfigDir = "/mnt/kafkatest"
+	controllerPort     = 9093
+)
+
+// KafkaContainerConfig configures the single node KRaft broker started with docker. The zero value starts a broker with a PLAINTEXT listener.
+type KafkaContainerConfig struct {
+	// Image of the broker, defaults to DefaultKafkaImage
+	Image string
+	// Users enables the SASL_PLAINTEXT and the SASL_SSL listener with SASL/PLAIN authentication of the given username to password map
+	Users map[string]string
+	// PEM encoded certificate chain and private key of the SSL and SASL_SSL listeners, the TLS listeners are disabled when empty.
+	// The certificate must be valid for 127.0.0.1, the advertised host of all listeners.
+	CertFile string
+	KeyFile  string
+	// How long to wait until the broker answers Metadata requests, defaults to 2 minutes
+	StartTimeout time.Duration
+}
+
+// KafkaContainer is a real Kafka broker running in a docker container, e.g. to verify the proxy and its plugins against real brokers
+type KafkaContainer struct {
+	id  string
+	dir string
+	// listener addresses by security protocol
+	addrs map[string]string
+}
+
+// DockerAvailable reports whether the docker CLI can reach a docker daemon
+func DockerAvailable() bool {
+	return exec.Command("docker", "info").Run() == nil
+}
+
+// StartKafkaContainer starts the broker and waits until it answers Metadata requests on the PLAINTEXT listener
+func StartKafkaContainer(cfg KafkaContainerConfig) (*KafkaContainer, error) {
+	if cfg.Image == "" {
+		cfg.Image = DefaultKafkaImage
+	}
+	if cfg.StartTimeout == 0 {
+		cfg.StartTimeout = 2 * time.Minute
+	}
+	protocols := []string{SecurityProtocolPlaintext}
+	tlsEnabled := cfg.CertFile != "" || cfg.KeyFile != ""
+	if tlsEnabled {
+		protocols = append(protocols, SecurityProtocolSSL)
+	}
+	if len(cfg.Users) != 0 {
+		protocols = append(protocols, SecurityProtocolSASLPlaintext)
+		if tlsEnabled {
+			protocols = append(protocols, SecurityProtocolSASLSSL)
+		}
+	}
+	dir, err := ioutil.TempDir("", "kafkatest-")
+	if err != nil {
+		return nil, err
+	}
+	k := &KafkaContainer{dir: dir, addrs: make(map[string]string)}
+	// the broker runs as an unprivileged user of the image
+	if err = os.Chmod(dir, 0755); err != nil {
+		k.Close()
+		return nil, err
+	}
+	args := []string{"run", "-d", "--rm"}
+	for _, protocol := range protocols {
+		port, err := freePort()
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+		k.addrs[protocol] = net.JoinHostPort("127.0.0.1", port)
+		args = append(args, "-p", fmt.Sprintf("127.0.0.1:%s:%s", port, port))
+	}
+	if tlsEnabled {
+		if err = writeKeystore(filepath.Join(dir, "keystore.pem"), cfg.CertFile, cfg.KeyFile); err != nil {
+			k.Close()
+			return nil, err
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "server.properties"), []byte(k.serverProperties(cfg.Users)), 0644); err != nil {
+		k.Close()
+		return nil, err
+	}
+	clusterID := make([]byte, 16)
+	if _, err = rand.Read(clusterID); err != nil {
+		k.Close()
+		return nil, err
+	}
+	properties := containerConfigDir + "/server.properties"
+	script := fmt.Sprintf("/opt/kafka/bin/kafka-storage.sh format --ignore-formatted -t %s -c %s && exec /opt/kafka/bin/kafka-server-start.sh %s",
+		base64.RawURLEncoding.EncodeToString(clusterID), properties, properties)
+	args = append(args, "-v", dir+":"+containerConfigDir+":ro", "--entrypoint", "/bin/bash", cfg.Image, "-c", script)
+	output, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		k.Close()
+		return nil, fmt.Errorf("docker run failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	k.id = strings.TrimSpace(string(output))
+	if err = k.waitReady(cfg.StartTimeout); err != nil {
+		logs, _ := exec.Command("docker", "logs", "--tail", "50", k.id).CombinedOutput()
+		k.Close()
+		return nil, fmt.Errorf("%v, broker logs:\n%s", err, logs)
+	}
+	return k, nil
+}
+
+// Addr returns the listener address of the security protocol, empty if the listener is not enabled
+func (k *KafkaContainer) Addr(securityProtocol string) string {
+	return k.addrs[securityProtocol]
+}
+
+// Close removes the container and its configuration
+func (k *KafkaContainer) Close() error {
+	var err error
+	if k.id != "" {
+		if output, rmErr := exec.Command("docker", "rm", "-f", k.id).CombinedOutput(); rmErr != nil {
+			err = fmt.Errorf("docker rm failed: %v: %s", rmErr, strings.TrimSpace(string(output)))
+		}
+	}
+	os.RemoveAll(k.dir)
+	return err
+}
+
+func (k *KafkaContainer) serverProperties(users map[string]string) string {
+	protocols := make([]string, 0)
+	for protocol := range k.addrs {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	listeners := []string{fmt.Sprintf("CONTROLLER://:%d", controllerPort)}
+	advertised := make([]string, 0)
+	protocolMap := []string{"CONTROLLER:PLAINTEXT"}
+	for _, protocol := range protocols {
+		_, port, _ := net.SplitHostPort(k.addrs[protocol])
+		listeners = append(listeners, fmt.Sprintf("%s://:%s", protocol, port))
+		advertised = append(advertised, fmt.Sprintf("%s://%s", protocol, k.addrs[protocol]))
+		protocolMap = append(protocolMap, protocol+":"+protocol)
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "node.id=1")
+	fmt.Fprintln(b, "process.roles=broker,controller")
+	fmt.Fprintf(b, "controller.quorum.voters=1@localhost:%d\n", controllerPort)
+	fmt.Fprintln(b, "controller.listener.names=CONTROLLER")
+	fmt.Fprintf(b, "listeners=%s\n", strings.Join(listeners, ","))
+	fmt.Fprintf(b, "advertised.listeners=%s\n", strings.Join(advertised, ","))
+	fmt.Fprintf(b, "listener.security.protocol.map=%s\n", strings.Join(protocolMap, ","))
+	fmt.Fprintln(b, "inter.broker.listener.name=PLAINTEXT")
+	fmt.Fprintln(b, "log.dirs=/tmp/kafkatest-logs")
+	fmt.Fprintln(b, "num.partitions=1")
+	fmt.Fprintln(b, "auto.create.topics.enable=true")
+	fmt.Fprintln(b, "offsets.topic.replication.factor=1")
+	fmt.Fprintln(b, "transaction.state.log.replication.factor=1")
+	fmt.Fprintln(b, "transaction.state.log.min.isr=1")
+	fmt.Fprintln(b, "group.initial.rebalance.delay.ms=0")
+	if _, ok := k.addrs[SecurityProtocolSSL]; ok {
+		fmt.Fprintln(b, "ssl.keystore.type=PEM")
+		fmt.Fprintf(b, "ssl.keystore.location=%s/keystore.pem\n", containerConfigDir)
+	}
+	if len(users) != 0 {
+		jaas := "org.apache.kafka.common.security.plain.PlainLoginModule required"
+		for username, password := range users {
+			jaas += fmt.Sprintf(" user_%s=%q", username, password)
+		}
+		fmt.Fprintln(b, "sasl.enabled.mechanisms=PLAIN")
+		for _, protocol := range []string{SecurityProtocolSASLPlaintext, SecurityProtocolSASLSSL} {
+			if _, ok := k.addrs[protocol]; ok {
+				fmt.Fprintf(b, "listener.name.%s.plain.sasl.jaas.config=%s;\n", strings.ToLower(protocol), jaas)
+			}
+		}
+	}
+	return b.String()
+}
+
+// waitReady sends Metadata requests to the PLAINTEXT listener until the broker answers with itself
+func (k *KafkaContainer) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	addr := k.addrs[SecurityProtocolPlaintext]
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err = conn.Write(EncodeRequest(ApiKeyMetadata, 1, 1, "kafkatest", MetadataRequestBody(1, []string{}))); err == nil {
+				var body []byte
+				if _, body, err = ReadResponse(conn); err == nil {
+					var brokers []BrokerAddress
+					if brokers, err = DecodeMetadataBrokers(1, body); err == nil && len(brokers) == 0 {
+						err = fmt.Errorf("no brokers in metadata")
+					}
+				}
+			}
+			conn.Close()
+			if err == nil {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("broker %s is not ready after %v: %v", addr, timeout, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// writeKeystore writes the PEM keystore of the broker with the PKCS#8 private key required by Kafka and the certificate chain
+func writeKeystore(filename, certFile, keyFile string) error {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("no PEM key in %s", keyFile)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keystore := append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), certPEM...)
+	return ioutil.WriteFile(filename, keystore, 0644)
+}
+
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
@@ -0,0 +1,235 @@
+package kafkatest
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInsufficientData = errors.New("kafkatest: insufficient data to decode packet")
+
+// encoder builds Kafka wire format messages (non flexible versions only)
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) putInt8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) putBool(v bool) {
+	if v {
+		e.putInt8(1)
+	} else {
+		e.putInt8(0)
+	}
+}
+
+func (e *encoder) putInt16(v int16) {
+	e.buf = append(e.buf, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], uint16(v))
+}
+
+func (e *encoder) putInt32(v int32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], uint32(v))
+}
+
+func (e *encoder) putString(v string) {
+	e.putInt16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) putInt64(v int64) {
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], uint64(v))
+}
+
+func (e *encoder) putVarint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(b, v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) putVarintBytes(v []byte) {
+	if v == nil {
+		e.putVarint(-1)
+		return
+	}
+	e.putVarint(int64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) putNullableString(v *string) {
+	if v == nil {
+		e.putInt16(-1)
+		return
+	}
+	e.putString(*v)
+}
+
+func (e *encoder) putBytes(v []byte) {
+	if v == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) putInt32Array(vs []int32) {
+	e.putInt32(int32(len(vs)))
+	for _, v := range vs {
+		e.putInt32(v)
+	}
+}
+
+func (e *encoder) putStringArray(vs []string) {
+	e.putInt32(int32(len(vs)))
+	for _, v := range vs {
+		e.putString(v)
+	}
+}
+
+// decoder reads Kafka wire format messages (non flexible versions only)
+type decoder struct {
+	raw []byte
+	off int
+}
+
+func (d *decoder) remaining() int {
+	return len(d.raw) - d.off
+}
+
+func (d *decoder) getInt16() (int16, error) {
+	if d.remaining() < 2 {
+		return 0, errInsufficientData
+	}
+	v := int16(binary.BigEndian.Uint16(d.raw[d.off:]))
+	d.off += 2
+	return v, nil
+}
+
+func (d *decoder) getInt8() (int8, error) {
+	if d.remaining() < 1 {
+		return 0, errInsufficientData
+	}
+	v := int8(d.raw[d.off])
+	d.off++
+	return v, nil
+}
+
+func (d *decoder) getInt32() (int32, error) {
+	if d.remaining() < 4 {
+		return 0, errInsufficientData
+	}
+	v := int32(binary.BigEndian.Uint32(d.raw[d.off:]))
+	d.off += 4
+	return v, nil
+}
+
+func (d *decoder) getInt64() (int64, error) {
+	if d.remaining() < 8 {
+		return 0, errInsufficientData
+	}
+	v := int64(binary.BigEndian.Uint64(d.raw[d.off:]))
+	d.off += 8
+	return v, nil
+}
+
+func (d *decoder) getVarint() (int64, error) {
+	v, n := binary.Varint(d.raw[d.off:])
+	if n <= 0 {
+		return 0, errInsufficientData
+	}
+	d.off += n
+	return v, nil
+}
+
+// getVarintBytes returns nil for the null bytes
+func (d *decoder) getVarintBytes() ([]byte, error) {
+	n, err := d.getVarint()
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	return d.getRaw(int(n))
+}
+
+func (d *decoder) getNullableString() (*string, error) {
+	n, err := d.getInt16()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	if d.remaining() < int(n) {
+		return nil, errInsufficientData
+	}
+	v := string(d.raw[d.off : d.off+int(n)])
+	d.off += int(n)
+	return &v, nil
+}
+
+func (d *decoder) getString() (string, error) {
+	v, err := d.getNullableString()
+	if err != nil || v == nil {
+		return "", err
+	}
+	return *v, nil
+}
+
+func (d *decoder) getBytes() ([]byte, error) {
+	n, err := d.getInt32()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	if d.remaining() < int(n) {
+		return nil, errInsufficientData
+	}
+	v := d.raw[d.off : d.off+int(n)]
+	d.off += int(n)
+	return v, nil
+}
+
+func (d *decoder) getRaw(n int) ([]byte, error) {
+	if d.remaining() < n {
+		return nil, errInsufficientData
+	}
+	v := d.raw[d.off : d.off+n]
+	d.off += n
+	return v, nil
+}
+
+func (d *decoder) skipInt32Array() error {
+	n, err := d.getInt32()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		_, err = d.getRaw(4 * int(n))
+	}
+	return err
+}
+
+// getNullableStringArray returns nil for the null array
+func (d *decoder) getNullableStringArray() ([]string, error) {
+	n, err := d.getInt32()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	result := make([]string, 0)
+	for i := 0; i < int(n); i++ {
+		v, err := d.getString()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
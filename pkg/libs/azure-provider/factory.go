@@ -0,0 +1,65 @@
+package azureprovider
+
+import (
+	"flag"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.TokenProviderFactory))
+	registry.Register(new(Factory), "azure-ad-provider")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("azure-ad provider settings", flag.ContinueOnError)
+	return fs
+}
+
+type pluginMeta struct {
+	timeout int
+
+	tenantID           string
+	clientID           string
+	clientSecret       string
+	federatedTokenFile string
+	managedIdentity    bool
+	authorityHost      string
+
+	scope              string
+	eventHubsNamespace string
+}
+
+type Factory struct {
+}
+
+// New implements apis.TokenProviderFactory
+func (t *Factory) New(params []string) (apis.TokenProvider, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	fs.IntVar(&pluginMeta.timeout, "timeout", 10, "Request timeout in seconds")
+	fs.StringVar(&pluginMeta.tenantID, "tenant-id", "", "Azure AD tenant id. If empty, AZURE_TENANT_ID is used")
+	fs.StringVar(&pluginMeta.clientID, "client-id", "", "Client id of the application or of the user-assigned managed identity. If empty, AZURE_CLIENT_ID is used")
+	fs.StringVar(&pluginMeta.clientSecret, "client-secret", "", "Client secret of the application. If empty, AZURE_CLIENT_SECRET is used")
+	fs.StringVar(&pluginMeta.federatedTokenFile, "federated-token-file", "", "File with the federated token of the workload identity used instead of the client secret. If empty, AZURE_FEDERATED_TOKEN_FILE is used")
+	fs.BoolVar(&pluginMeta.managedIdentity, "managed-identity", false, "Get the tokens from the managed identity endpoint instead of the client credentials")
+	fs.StringVar(&pluginMeta.authorityHost, "authority-host", "", "Azure AD authority host. If empty, AZURE_AUTHORITY_HOST or https://login.microsoftonline.com is used")
+	fs.StringVar(&pluginMeta.scope, "scope", "", "Scope of the requested tokens e.g. https://my-namespace.servicebus.windows.net/.default")
+	fs.StringVar(&pluginMeta.eventHubsNamespace, "event-hubs-namespace", "", "Event Hubs namespace, the scope https://<namespace>.servicebus.windows.net/.default is requested if scope is empty")
+
+	fs.Parse(params)
+
+	options := TokenProviderOptions{
+		Timeout:            pluginMeta.timeout,
+		TenantID:           pluginMeta.tenantID,
+		ClientID:           pluginMeta.clientID,
+		ClientSecret:       pluginMeta.clientSecret,
+		FederatedTokenFile: pluginMeta.federatedTokenFile,
+		ManagedIdentity:    pluginMeta.managedIdentity,
+		AuthorityHost:      pluginMeta.authorityHost,
+		Scope:              pluginMeta.scope,
+		EventHubsNamespace: pluginMeta.eventHubsNamespace,
+	}
+
+	return NewTokenProvider(options)
+}
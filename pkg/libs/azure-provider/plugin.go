@@ -0,0 +1,241 @@
+package azureprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cenkalti/backoff"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context/ctxhttp"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK             = 0
+	StatusGetTokenFailed = 1
+
+	defaultAuthorityHost = "https://login.microsoftonline.com"
+	// Azure Instance Metadata Service endpoint of the managed identities of the virtual machines and AKS nodes
+	imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+var (
+	// tokens are renewed before they expire
+	renewBefore = 5 * time.Minute
+	nowFn       = time.Now
+)
+
+type TokenProviderOptions struct {
+	Timeout int
+
+	TenantID           string
+	ClientID           string
+	ClientSecret       string
+	FederatedTokenFile string
+	ManagedIdentity    bool
+	AuthorityHost      string
+
+	Scope              string
+	EventHubsNamespace string
+}
+
+// TokenProvider returns the Azure AD access tokens e.g. for the OAUTHBEARER authentication to the Kafka endpoint of Azure Event Hubs
+type TokenProvider struct {
+	timeout time.Duration
+	client  *http.Client
+	// requests a new token
+	newRequest func(ctx context.Context) (*http.Request, error)
+
+	token   string
+	expires time.Time
+	l       sync.Mutex
+}
+
+func NewTokenProvider(options TokenProviderOptions) (*TokenProvider, error) {
+	options = withEnvDefaults(options)
+	scope := options.Scope
+	if scope == "" {
+		if options.EventHubsNamespace == "" {
+			return nil, errors.New("parameter scope or event-hubs-namespace is required")
+		}
+		scope = fmt.Sprintf("https://%s.servicebus.windows.net/.default", strings.TrimSuffix(options.EventHubsNamespace, ".servicebus.windows.net"))
+	}
+	tokenProvider := &TokenProvider{
+		timeout: time.Duration(options.Timeout) * time.Second,
+		client:  &http.Client{Timeout: time.Duration(options.Timeout) * time.Second},
+	}
+	if options.ManagedIdentity {
+		tokenProvider.newRequest = managedIdentityRequest(options.ClientID, strings.TrimSuffix(scope, "/.default"))
+		logrus.Infof("Azure AD tokens of the managed identity for %s", scope)
+	} else {
+		if options.TenantID == "" || options.ClientID == "" {
+			return nil, errors.New("parameters tenant-id and client-id are required")
+		}
+		if options.ClientSecret == "" && options.FederatedTokenFile == "" {
+			return nil, errors.New("parameter client-secret or federated-token-file is required")
+		}
+		tokenProvider.newRequest = clientCredentialsRequest(options, scope)
+		logrus.Infof("Azure AD tokens of the client %s for %s", options.ClientID, scope)
+	}
+
+	op := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenProvider.timeout)
+		defer cancel()
+		_, err := tokenProvider.currentToken(ctx)
+		return err
+	}
+	err := backoff.Retry(op, backoff.WithMaxTries(backoff.NewConstantBackOff(1*time.Second), 3))
+	if err != nil {
+		return nil, errors.Wrap(err, "getting of initial azure-ad token failed")
+	}
+	return tokenProvider, nil
+}
+
+func withEnvDefaults(options TokenProviderOptions) TokenProviderOptions {
+	getenv := func(value *string, name string) {
+		if *value == "" {
+			*value = os.Getenv(name)
+		}
+	}
+	getenv(&options.TenantID, "AZURE_TENANT_ID")
+	getenv(&options.ClientID, "AZURE_CLIENT_ID")
+	getenv(&options.ClientSecret, "AZURE_CLIENT_SECRET")
+	getenv(&options.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE")
+	getenv(&options.AuthorityHost, "AZURE_AUTHORITY_HOST")
+	if options.AuthorityHost == "" {
+		options.AuthorityHost = defaultAuthorityHost
+	}
+	return options
+}
+
+// clientCredentialsRequest returns the token request of the client credentials flow with a client secret or a federated token assertion
+func clientCredentialsRequest(options TokenProviderOptions, scope string) func(ctx context.Context) (*http.Request, error) {
+	endpoint := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(options.AuthorityHost, "/"), options.TenantID)
+	return func(ctx context.Context) (*http.Request, error) {
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", options.ClientID)
+		form.Set("scope", scope)
+		if options.ClientSecret != "" {
+			form.Set("client_secret", options.ClientSecret)
+		} else {
+			// the federated token is rotated by the platform, it is read for every request
+			assertion, err := ioutil.ReadFile(options.FederatedTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+			form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+}
+
+// managedIdentityRequest returns the token request of the App Service identity endpoint if IDENTITY_ENDPOINT is set, otherwise of the instance metadata service
+func managedIdentityRequest(clientID string, resource string) func(ctx context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		query := url.Values{}
+		query.Set("resource", resource)
+		if clientID != "" {
+			query.Set("client_id", clientID)
+		}
+		endpoint := imdsEndpoint
+		header, value := "Metadata", "true"
+		if identityEndpoint := os.Getenv("IDENTITY_ENDPOINT"); identityEndpoint != "" {
+			endpoint = identityEndpoint
+			header, value = "X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER")
+			query.Set("api-version", "2019-08-01")
+		} else {
+			query.Set("api-version", "2018-02-01")
+		}
+		req, err := http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(header, value)
+		return req, nil
+	}
+}
+
+// GetToken implements apis.TokenProvider
+func (p *TokenProvider) GetToken(parent context.Context, request apis.TokenRequest) (apis.TokenResponse, error) {
+	ctx, cancel := context.WithTimeout(parent, p.timeout)
+	defer cancel()
+
+	token, err := p.currentToken(ctx)
+	if err != nil {
+		logrus.Errorf("getting of azure-ad token failed: %v", err)
+		return apis.TokenResponse{Success: false, Status: StatusGetTokenFailed}, nil
+	}
+	return apis.TokenResponse{Success: true, Status: StatusOK, Token: token}, nil
+}
+
+// currentToken returns the cached token, a new token is requested if the cached one expires soon
+func (p *TokenProvider) currentToken(ctx context.Context) (string, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.token != "" && nowFn().Add(renewBefore).Before(p.expires) {
+		return p.token, nil
+	}
+	token, expires, err := p.requestToken(ctx)
+	if err != nil {
+		if p.token != "" && nowFn().Before(p.expires) {
+			logrus.Warnf("Refresh of azure-ad token failed, the token valid until %v is used: %v", p.expires, err)
+			return p.token, nil
+		}
+		return "", err
+	}
+	logrus.Infof("Refreshed azure-ad token expiry %v", expires)
+	p.token, p.expires = token, expires
+	return token, nil
+}
+
+func (p *TokenProvider) requestToken(ctx context.Context) (string, time.Time, error) {
+	req, err := p.newRequest(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := ctxhttp.Do(ctx, p.client, req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if c := resp.StatusCode; c < 200 || c > 299 {
+		return "", time.Time{}, fmt.Errorf("cannot fetch token: %v\nResponse: %s", resp.Status, body)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		// number in the client credentials responses, string in the managed identity responses
+		ExpiresIn json.Number `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return "", time.Time{}, err
+	}
+	if result.AccessToken == "" {
+		return "", time.Time{}, errors.New("access_token is missing in the token response")
+	}
+	expiresIn, err := strconv.ParseInt(result.ExpiresIn.String(), 10, 64)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "invalid expires_in in the token response")
+	}
+	return result.AccessToken, nowFn().Add(time.Duration(expiresIn) * time.Second), nil
+}
@@ -0,0 +1,162 @@
+package azureprovider
+
+import (
+	"context"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClientCredentials(t *testing.T) {
+	a := assert.New(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		a.Equal("/my-tenant/oauth2/v2.0/token", r.URL.Path)
+		a.Nil(r.ParseForm())
+		a.Equal("client_credentials", r.PostForm.Get("grant_type"))
+		a.Equal("my-client", r.PostForm.Get("client_id"))
+		a.Equal("my-secret", r.PostForm.Get("client_secret"))
+		a.Equal("https://my-namespace.servicebus.windows.net/.default", r.PostForm.Get("scope"))
+		fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":3599,"access_token":"token-%d"}`, requests)
+	}))
+	defer server.Close()
+
+	tokenProvider, err := NewTokenProvider(TokenProviderOptions{
+		Timeout:            5,
+		TenantID:           "my-tenant",
+		ClientID:           "my-client",
+		ClientSecret:       "my-secret",
+		AuthorityHost:      server.URL,
+		EventHubsNamespace: "my-namespace.servicebus.windows.net",
+	})
+	a.Nil(err)
+
+	response, err := tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal(apis.TokenResponse{Success: true, Status: StatusOK, Token: "token-1"}, response)
+	a.Equal(1, requests)
+
+	// the token is renewed before it expires
+	defer func() { nowFn = time.Now }()
+	nowFn = func() time.Time { return time.Now().Add(56 * time.Minute) }
+	response, err = tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal("token-2", response.Token)
+}
+
+func TestFederatedToken(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "federated-token")
+	a.Nil(err)
+	defer os.Remove(file.Name())
+	file.WriteString("federated-token\n")
+	file.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Nil(r.ParseForm())
+		a.Equal("", r.PostForm.Get("client_secret"))
+		a.Equal("urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.PostForm.Get("client_assertion_type"))
+		a.Equal("federated-token", r.PostForm.Get("client_assertion"))
+		a.Equal("https://eventhubs.azure.net/.default", r.PostForm.Get("scope"))
+		w.Write([]byte(`{"expires_in":3599,"access_token":"token"}`))
+	}))
+	defer server.Close()
+
+	tokenProvider, err := NewTokenProvider(TokenProviderOptions{
+		Timeout:            5,
+		TenantID:           "my-tenant",
+		ClientID:           "my-client",
+		FederatedTokenFile: file.Name(),
+		AuthorityHost:      server.URL,
+		Scope:              "https://eventhubs.azure.net/.default",
+	})
+	a.Nil(err)
+	response, err := tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal("token", response.Token)
+}
+
+func TestManagedIdentity(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("identity-header", r.Header.Get("X-IDENTITY-HEADER"))
+		a.Equal("2019-08-01", r.URL.Query().Get("api-version"))
+		a.Equal("https://my-namespace.servicebus.windows.net", r.URL.Query().Get("resource"))
+		a.Equal("my-identity", r.URL.Query().Get("client_id"))
+		w.Write([]byte(`{"expires_in":"86399","access_token":"mi-token"}`))
+	}))
+	defer server.Close()
+
+	os.Setenv("IDENTITY_ENDPOINT", server.URL)
+	os.Setenv("IDENTITY_HEADER", "identity-header")
+	defer os.Unsetenv("IDENTITY_ENDPOINT")
+	defer os.Unsetenv("IDENTITY_HEADER")
+
+	tokenProvider, err := NewTokenProvider(TokenProviderOptions{
+		Timeout:            5,
+		ClientID:           "my-identity",
+		ManagedIdentity:    true,
+		EventHubsNamespace: "my-namespace",
+	})
+	a.Nil(err)
+	response, err := tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal(apis.TokenResponse{Success: true, Status: StatusOK, Token: "mi-token"}, response)
+}
+
+func TestGetTokenFailed(t *testing.T) {
+	a := assert.New(t)
+
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"expires_in":600,"access_token":"token"}`))
+	}))
+	defer server.Close()
+
+	tokenProvider, err := NewTokenProvider(TokenProviderOptions{
+		Timeout:            5,
+		TenantID:           "my-tenant",
+		ClientID:           "my-client",
+		ClientSecret:       "my-secret",
+		AuthorityHost:      server.URL,
+		EventHubsNamespace: "my-namespace",
+	})
+	a.Nil(err)
+
+	// the cached token is used until it expires
+	fail = true
+	response, err := tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal(apis.TokenResponse{Success: true, Status: StatusOK, Token: "token"}, response)
+
+	defer func() { nowFn = time.Now }()
+	nowFn = func() time.Time { return time.Now().Add(11 * time.Minute) }
+	response, err = tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.Equal(apis.TokenResponse{Success: false, Status: StatusGetTokenFailed}, response)
+}
+
+func TestOptionsValidation(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewTokenProvider(TokenProviderOptions{TenantID: "my-tenant", ClientID: "my-client", ClientSecret: "my-secret"})
+	a.EqualError(err, "parameter scope or event-hubs-namespace is required")
+	_, err = NewTokenProvider(TokenProviderOptions{TenantID: "my-tenant", ClientSecret: "my-secret", Scope: "scope"})
+	a.EqualError(err, "parameters tenant-id and client-id are required")
+	_, err = NewTokenProvider(TokenProviderOptions{TenantID: "my-tenant", ClientID: "my-client", Scope: "scope"})
+	a.EqualError(err, "parameter client-secret or federated-token-file is required")
+}
@@ -4,11 +4,14 @@ import (
 	"flag"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/pkg/registry"
+	"io/ioutil"
+	"strings"
 )
 
 func init() {
 	registry.NewComponentInterface(new(apis.TokenProviderFactory))
 	registry.Register(new(Factory), "google-id-provider")
+	registry.Register(new(Factory), "oidc-provider")
 }
 
 func (f *pluginMeta) flagSet() *flag.FlagSet {
@@ -23,6 +26,16 @@ type pluginMeta struct {
 	credentialsWatch bool
 	credentialsFile  string
 	targetAudience   string
+
+	tokenURL         string
+	clientID         string
+	clientSecret     string
+	clientSecretFile string
+	scopes           string
+	tlsCertFile      string
+	tlsKeyFile       string
+	tlsCAFile        string
+	tlsSkipVerify    bool
 }
 
 type Factory struct {
@@ -37,15 +50,49 @@ func (t *Factory) New(params []string) (apis.TokenProvider, error) {
 	fs.StringVar(&pluginMeta.credentialsFile, "credentials-file", "", "Location of the JSON file with the application credentials")
 	fs.BoolVar(&pluginMeta.credentialsWatch, "credentials-watch", true, "Watch credential for reload")
 	fs.StringVar(&pluginMeta.targetAudience, "target-audience", "", "URI of audience claim")
+	fs.StringVar(&pluginMeta.tokenURL, "token-url", "", "OIDC token endpoint. If set, access tokens are requested with the client credentials grant instead of the Google credentials")
+	fs.StringVar(&pluginMeta.clientID, "client-id", "", "Client id of the client credentials grant")
+	fs.StringVar(&pluginMeta.clientSecret, "client-secret", "", "Client secret of the client credentials grant")
+	fs.StringVar(&pluginMeta.clientSecretFile, "client-secret-file", "", "Location of the file with the client secret of the client credentials grant")
+	fs.StringVar(&pluginMeta.scopes, "scopes", "", "Comma separated list of the requested scopes")
+	fs.StringVar(&pluginMeta.tlsCertFile, "tls-cert-file", "", "PEM encoded client certificate for the mutual TLS authentication to the token endpoint")
+	fs.StringVar(&pluginMeta.tlsKeyFile, "tls-key-file", "", "PEM encoded client key for the mutual TLS authentication to the token endpoint")
+	fs.StringVar(&pluginMeta.tlsCAFile, "tls-ca-file", "", "PEM encoded CA certificates of the token endpoint")
+	fs.BoolVar(&pluginMeta.tlsSkipVerify, "tls-insecure-skip-verify", false, "Skip verification of the token endpoint certificate")
 
 	fs.Parse(params)
 
+	clientSecret := pluginMeta.clientSecret
+	if clientSecret == "" && pluginMeta.clientSecretFile != "" {
+		data, err := ioutil.ReadFile(pluginMeta.clientSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		clientSecret = strings.TrimSpace(string(data))
+	}
+	var scopes []string
+	for _, scope := range strings.Split(pluginMeta.scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
 	options := TokenProviderOptions{
 		Timeout:          pluginMeta.timeout,
 		Adc:              pluginMeta.adc,
 		CredentialsWatch: pluginMeta.credentialsWatch,
 		CredentialsFile:  pluginMeta.credentialsFile,
 		TargetAudience:   pluginMeta.targetAudience,
+		ClientCredentials: ClientCredentialsOptions{
+			TokenURL:      pluginMeta.tokenURL,
+			ClientID:      pluginMeta.clientID,
+			ClientSecret:  clientSecret,
+			Scopes:        scopes,
+			TLSCertFile:   pluginMeta.tlsCertFile,
+			TLSKeyFile:    pluginMeta.tlsKeyFile,
+			TLSCAFile:     pluginMeta.tlsCAFile,
+			TLSSkipVerify: pluginMeta.tlsSkipVerify,
+		},
 	}
 
 	return NewTokenProvider(options)
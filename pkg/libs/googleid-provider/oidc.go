@@ -0,0 +1,115 @@
+package googleidprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context/ctxhttp"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ClientCredentialsOptions struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Audience     string
+
+	// client certificate for the mutual TLS client authentication (RFC 8705)
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSCAFile     string
+	TLSSkipVerify bool
+}
+
+// clientCredentialsSource requests JWT access tokens with the OAuth 2.0 client credentials grant from any OIDC identity provider
+type clientCredentialsSource struct {
+	options ClientCredentialsOptions
+	client  *http.Client
+}
+
+func newClientCredentialsSource(options ClientCredentialsOptions, timeout time.Duration) (*clientCredentialsSource, error) {
+	if options.ClientID == "" {
+		return nil, errors.New("parameter client-id is required")
+	}
+	if options.ClientSecret == "" && options.TLSCertFile == "" {
+		return nil, errors.New("parameter client-secret or tls-cert-file is required")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.TLSSkipVerify}
+	if options.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if options.TLSCAFile != "" {
+		caCert, err := ioutil.ReadFile(options.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if ok := rootCAs.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("no certificates found in %s", options.TLSCAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return &clientCredentialsSource{
+		options: options,
+		client:  &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+func (s *clientCredentialsSource) GetIDToken(ctx context.Context) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", s.options.ClientID)
+	if s.options.ClientSecret != "" {
+		form.Set("client_secret", s.options.ClientSecret)
+	}
+	if len(s.options.Scopes) != 0 {
+		form.Set("scope", strings.Join(s.options.Scopes, " "))
+	}
+	if s.options.Audience != "" {
+		form.Set("audience", s.options.Audience)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.options.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ctxhttp.Do(ctx, s.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if c := resp.StatusCode; c < 200 || c > 299 {
+		return "", fmt.Errorf("cannot fetch token: %v\nResponse: %s", resp.Status, body)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("access_token is missing in the token response")
+	}
+	return result.AccessToken, nil
+}
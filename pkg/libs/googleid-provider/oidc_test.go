@@ -0,0 +1,81 @@
+package googleidprovider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/googleid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testJWT(sub string, iat, exp int64) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"%s","iat":%d,"exp":%d}`, sub, iat, exp)))
+	return header + "." + payload + ".c2ln"
+}
+
+func TestClientCredentialsSource(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Now().Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Nil(r.ParseForm())
+		a.Equal("client_credentials", r.PostForm.Get("grant_type"))
+		a.Equal("kafka-proxy", r.PostForm.Get("client_id"))
+		a.Equal("my-secret", r.PostForm.Get("client_secret"))
+		a.Equal("kafka openid", r.PostForm.Get("scope"))
+		a.Equal("kafka-cluster", r.PostForm.Get("audience"))
+		fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":3600,"access_token":"%s"}`, testJWT("kafka-proxy", now, now+3600))
+	}))
+	defer server.Close()
+
+	tokenProvider, err := NewTokenProvider(TokenProviderOptions{
+		Timeout:        5,
+		TargetAudience: "kafka-cluster",
+		ClientCredentials: ClientCredentialsOptions{
+			TokenURL:     server.URL,
+			ClientID:     "kafka-proxy",
+			ClientSecret: "my-secret",
+			Scopes:       []string{"kafka", "openid"},
+		},
+	})
+	a.Nil(err)
+
+	response, err := tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+	a.Nil(err)
+	a.True(response.Success)
+	a.Equal(testJWT("kafka-proxy", now, now+3600), response.Token)
+}
+
+func TestClientCredentialsSourceErrors(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+	}))
+	defer server.Close()
+
+	source, err := newClientCredentialsSource(ClientCredentialsOptions{TokenURL: server.URL, ClientID: "kafka-proxy", ClientSecret: "wrong"}, 5*time.Second)
+	a.Nil(err)
+	_, err = source.GetIDToken(context.Background())
+	a.EqualError(err, "cannot fetch token: 401 Unauthorized\nResponse: {\"error\":\"invalid_client\"}")
+
+	_, err = newClientCredentialsSource(ClientCredentialsOptions{TokenURL: server.URL}, 5*time.Second)
+	a.EqualError(err, "parameter client-id is required")
+	_, err = newClientCredentialsSource(ClientCredentialsOptions{TokenURL: server.URL, ClientID: "kafka-proxy"}, 5*time.Second)
+	a.EqualError(err, "parameter client-secret or tls-cert-file is required")
+}
+
+func TestRenewEarliestWithoutIssueTime(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Now().Unix()
+	a.False(renewEarliest(&googleid.ClaimSet{Exp: now + 3600}))
+	a.True(renewEarliest(&googleid.ClaimSet{Exp: now + 30}))
+}
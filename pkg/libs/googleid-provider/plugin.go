@@ -40,12 +40,15 @@ type TokenProviderOptions struct {
 	CredentialsWatch bool
 	CredentialsFile  string
 	TargetAudience   string
+
+	// generic OIDC client credentials are used instead of the Google credentials if the token URL is set
+	ClientCredentials ClientCredentialsOptions
 }
 
 func NewTokenProvider(options TokenProviderOptions) (*TokenProvider, error) {
 	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", options.CredentialsFile)
 
-	if !options.Adc {
+	if !options.Adc && options.ClientCredentials.TokenURL == "" {
 		if options.TargetAudience == "" {
 			return nil, errors.New("parameter target-audience is required")
 		}
@@ -53,7 +56,17 @@ func NewTokenProvider(options TokenProviderOptions) (*TokenProvider, error) {
 	stopChannel := make(chan bool, 1)
 
 	var idTokenSource idTokenSource
-	if options.Adc {
+	if options.ClientCredentials.TokenURL != "" {
+		clientCredentials := options.ClientCredentials
+		if clientCredentials.Audience == "" {
+			clientCredentials.Audience = options.TargetAudience
+		}
+		source, err := newClientCredentialsSource(clientCredentials, time.Duration(options.Timeout)*time.Second)
+		if err != nil {
+			return nil, errors.Wrap(err, "creation of client credentials source failed")
+		}
+		idTokenSource = source
+	} else if options.Adc {
 		idTokenSource = newAuthorizedUserSource()
 	} else {
 		serviceAccountSource, err := NewServiceAccountSource(options.CredentialsFile, options.TargetAudience)
@@ -79,6 +79,10 @@ func renewEarliest(claimSet *googleid.ClaimSet) bool {
 	if claimSet == nil {
 		return true
 	}
+	if claimSet.Iat == 0 {
+		// without the issue time the validity is unknown, renew before expiry
+		return nowFn().Unix() > claimSet.Exp-int64(clockSkew.Seconds())
+	}
 	validity := claimSet.Exp - claimSet.Iat
 	if validity <= 0 {
 		// should would be invalid claim
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCPSecretManager resolves the references 'gcp-sm:projects/<project>/secrets/<secret>[/versions/<version>][#key]', the latest version is used if the version is not given.
+// The requests are authorized with the Google Application Default Credentials.
+type GCPSecretManager struct {
+	client      *http.Client
+	endpoint    string
+	tokenSource func(ctx context.Context) (oauth2.TokenSource, error)
+}
+
+func NewGCPSecretManager() *GCPSecretManager {
+	return &GCPSecretManager{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: "https://secretmanager.googleapis.com",
+		tokenSource: func(ctx context.Context) (oauth2.TokenSource, error) {
+			return google.DefaultTokenSource(ctx, gcpCloudPlatformScope)
+		},
+	}
+}
+
+func (p *GCPSecretManager) GetSecret(ctx context.Context, reference string) (string, error) {
+	name, key := splitKey(reference)
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return "", fmt.Errorf("secret name %s must have the form projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name = name + "/versions/latest"
+	}
+	tokenSource, err := p.tokenSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, p.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	token.SetAuthHeader(req)
+
+	resp, err := ctxhttp.Do(ctx, p.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if c := resp.StatusCode; c < 200 || c > 299 {
+		return "", fmt.Errorf("cannot access secret: %v\nResponse: %s", resp.Status, data)
+	}
+	var result struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return "", err
+	}
+	secret, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return selectKey(string(secret), key)
+}
@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider returns the secret identified by the reference without the scheme prefix
+type Provider interface {
+	GetSecret(ctx context.Context, reference string) (string, error)
+}
+
+// Resolver resolves the secret references of the form 'scheme:reference', values without a known scheme are returned unchanged
+type Resolver struct {
+	timeout   time.Duration
+	providers map[string]Provider
+}
+
+// NewResolver returns the resolver of the env, file, aws-sm, gcp-sm and vault references
+func NewResolver() *Resolver {
+	return &Resolver{
+		timeout: 30 * time.Second,
+		providers: map[string]Provider{
+			"env":    envProvider{},
+			"file":   fileProvider{},
+			"aws-sm": NewAWSSecretsManager(),
+			"gcp-sm": NewGCPSecretManager(),
+			"vault":  NewVault(),
+		},
+	}
+}
+
+// Register adds or replaces the provider of the scheme
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.providers[scheme] = provider
+}
+
+// IsReference reports whether the value is a reference of a registered scheme
+func (r *Resolver) IsReference(value string) bool {
+	_, _, ok := r.provider(value)
+	return ok
+}
+
+// Resolve returns the secret of the reference or the value itself if it is not a reference
+func (r *Resolver) Resolve(value string) (string, error) {
+	provider, reference, ok := r.provider(value)
+	if !ok {
+		return value, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	secret, err := provider.GetSecret(ctx, reference)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot resolve secret %s", value)
+	}
+	return secret, nil
+}
+
+func (r *Resolver) provider(value string) (Provider, string, bool) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return nil, "", false
+	}
+	provider, ok := r.providers[value[:i]]
+	return provider, value[i+1:], ok
+}
+
+// splitKey splits the optional JSON key from the reference e.g. 'my-secret#password'
+func splitKey(reference string) (string, string) {
+	if i := strings.LastIndex(reference, "#"); i >= 0 {
+		return reference[:i], reference[i+1:]
+	}
+	return reference, ""
+}
+
+// selectKey returns the value of the key of the JSON object secret, the secret itself if the key is empty
+func selectKey(secret string, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", errors.Wrapf(err, "secret is not a JSON object, key %s cannot be selected", key)
+	}
+	return stringValue(values, key)
+}
+
+func stringValue(values map[string]interface{}, key string) (string, error) {
+	value, ok := values[key]
+	if !ok {
+		return "", errors.Errorf("key %s is not found in the secret", key)
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// env:NAME
+type envProvider struct{}
+
+func (envProvider) GetSecret(_ context.Context, reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", errors.Errorf("environment variable %s is not set", reference)
+	}
+	return value, nil
+}
+
+// file:/path/to/secret, the trailing line break is removed
+type fileProvider struct{}
+
+func (fileProvider) GetSecret(_ context.Context, reference string) (string, error) {
+	data, err := ioutil.ReadFile(reference)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Refresher keeps the secret of a reference up to date
+type Refresher struct {
+	resolver  *Resolver
+	reference string
+
+	lock  sync.RWMutex
+	value string
+}
+
+// NewRefresher returns the refresher of the reference with the already resolved value
+func (r *Resolver) NewRefresher(reference string, value string) *Refresher {
+	return &Refresher{resolver: r, reference: reference, value: value}
+}
+
+// Value returns the last resolved secret
+func (r *Refresher) Value() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.value
+}
+
+// Refresh resolves the reference again, the previous secret is kept on error
+func (r *Refresher) Refresh() error {
+	value, err := r.resolver.Resolve(r.reference)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	changed := r.value != value
+	r.value = value
+	r.lock.Unlock()
+	if changed {
+		logrus.Infof("Secret %s was changed", r.reference)
+	}
+	return nil
+}
+
+// Run refreshes the secret in the interval until the context is done
+func (r *Refresher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil {
+				logrus.Warnf("Refresh of secret %s failed: %v", r.reference, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveEnvAndFile(t *testing.T) {
+	a := assert.New(t)
+
+	os.Setenv("KAFKA_PROXY_TEST_SECRET", "env-secret")
+	defer os.Unsetenv("KAFKA_PROXY_TEST_SECRET")
+	file, err := ioutil.TempFile("", "secret")
+	a.Nil(err)
+	defer os.Remove(file.Name())
+	file.WriteString("file-secret\n")
+	file.Close()
+
+	resolver := NewResolver()
+	value, err := resolver.Resolve("env:KAFKA_PROXY_TEST_SECRET")
+	a.Nil(err)
+	a.Equal("env-secret", value)
+	value, err = resolver.Resolve("file:" + file.Name())
+	a.Nil(err)
+	a.Equal("file-secret", value)
+
+	// plain values are not references
+	value, err = resolver.Resolve("my:password")
+	a.Nil(err)
+	a.Equal("my:password", value)
+	a.False(resolver.IsReference("my:password"))
+	a.True(resolver.IsReference("vault:secret/data/kafka#password"))
+
+	_, err = resolver.Resolve("env:KAFKA_PROXY_TEST_MISSING")
+	a.EqualError(err, "cannot resolve secret env:KAFKA_PROXY_TEST_MISSING: environment variable KAFKA_PROXY_TEST_MISSING is not set")
+}
+
+func TestSelectKey(t *testing.T) {
+	a := assert.New(t)
+
+	value, err := selectKey(`{"username":"bob","password":"secret","port":9092}`, "password")
+	a.Nil(err)
+	a.Equal("secret", value)
+	value, err = selectKey(`{"port":9092}`, "port")
+	a.Nil(err)
+	a.Equal("9092", value)
+	_, err = selectKey(`{"username":"bob"}`, "password")
+	a.EqualError(err, "key password is not found in the secret")
+	_, err = selectKey("plain", "password")
+	a.NotNil(err)
+}
+
+func TestSignAWSv4(t *testing.T) {
+	a := assert.New(t)
+
+	// example of the AWS General Reference "Signature Version 4 signing process"
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	a.Nil(err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	credentials := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSv4(req, nil, "iam", "us-east-1", credentials, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	a.Equal("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		a.True(strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		a.Equal("session", r.Header.Get("X-Amz-Security-Token"))
+		var body map[string]string
+		a.Nil(json.NewDecoder(r.Body).Decode(&body))
+		a.Equal("arn:aws:secretsmanager:eu-west-1:123456789012:secret:kafka", body["SecretId"])
+		w.Write([]byte(`{"Name":"kafka","SecretString":"{\"password\":\"aws-secret\"}"}`))
+	}))
+	defer server.Close()
+
+	p := NewAWSSecretsManager()
+	p.endpoint = func(region string) string {
+		a.Equal("eu-west-1", region)
+		return server.URL
+	}
+	p.credentials = func() (AWSCredentials, error) {
+		return AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}, nil
+	}
+	value, err := p.GetSecret(context.Background(), "arn:aws:secretsmanager:eu-west-1:123456789012:secret:kafka#password")
+	a.Nil(err)
+	a.Equal("aws-secret", value)
+}
+
+func TestGCPSecretManager(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("/v1/projects/my-project/secrets/kafka/versions/latest:access", r.URL.Path)
+		a.Equal("Bearer gcp-token", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(map[string]interface{}{"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte("gcp-secret"))}})
+	}))
+	defer server.Close()
+
+	p := NewGCPSecretManager()
+	p.endpoint = server.URL
+	p.tokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "gcp-token", TokenType: "Bearer"}), nil
+	}
+	value, err := p.GetSecret(context.Background(), "projects/my-project/secrets/kafka")
+	a.Nil(err)
+	a.Equal("gcp-secret", value)
+
+	_, err = p.GetSecret(context.Background(), "kafka")
+	a.NotNil(err)
+}
+
+func TestVault(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("vault-token", r.Header.Get("X-Vault-Token"))
+		switch r.URL.Path {
+		case "/v1/secret/data/kafka":
+			w.Write([]byte(`{"data":{"data":{"password":"kv2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/kafka":
+			w.Write([]byte(`{"data":{"password":"kv1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := NewVault()
+	env := map[string]string{"VAULT_ADDR": server.URL + "/", "VAULT_TOKEN": "vault-token"}
+	p.getenv = func(name string) string { return env[name] }
+
+	value, err := p.GetSecret(context.Background(), "secret/data/kafka#password")
+	a.Nil(err)
+	a.Equal("kv2-secret", value)
+	value, err = p.GetSecret(context.Background(), "kv/kafka#password")
+	a.Nil(err)
+	a.Equal("kv1-secret", value)
+	_, err = p.GetSecret(context.Background(), "kv/missing#password")
+	a.NotNil(err)
+	_, err = p.GetSecret(context.Background(), "kv/kafka")
+	a.NotNil(err)
+}
+
+type testProvider struct {
+	values []string
+}
+
+func (p *testProvider) GetSecret(_ context.Context, _ string) (string, error) {
+	value := p.values[0]
+	p.values = p.values[1:]
+	return value, nil
+}
+
+func TestRefresher(t *testing.T) {
+	a := assert.New(t)
+
+	resolver := NewResolver()
+	resolver.Register("test", &testProvider{values: []string{"second"}})
+	refresher := resolver.NewRefresher("test:password", "first")
+	a.Equal("first", refresher.Value())
+	a.Nil(refresher.Refresh())
+	a.Equal("second", refresher.Value())
+}
@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context/ctxhttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Vault resolves the references 'vault:<path>#<key>' of the KV secrets engine, e.g. 'vault:secret/data/kafka#password' for KV version 2.
+// The address is taken from VAULT_ADDR, the token from VAULT_TOKEN or the file VAULT_TOKEN_FILE and the namespace from VAULT_NAMESPACE.
+type Vault struct {
+	client *http.Client
+	getenv func(string) string
+}
+
+func NewVault() *Vault {
+	return &Vault{
+		client: &http.Client{Timeout: 10 * time.Second},
+		getenv: os.Getenv,
+	}
+}
+
+func (p *Vault) GetSecret(ctx context.Context, reference string) (string, error) {
+	path, key := splitKey(reference)
+	if key == "" {
+		return "", errors.New("vault secret key is required e.g. vault:secret/data/kafka#password")
+	}
+	address := strings.TrimSuffix(p.getenv("VAULT_ADDR"), "/")
+	if address == "" {
+		return "", errors.New("VAULT_ADDR must be set")
+	}
+	token, err := p.token()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := p.getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := ctxhttp.Do(ctx, p.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if c := resp.StatusCode; c < 200 || c > 299 {
+		return "", fmt.Errorf("cannot read secret: %v\nResponse: %s", resp.Status, data)
+	}
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return "", err
+	}
+	// KV version 2 nests the secret in data.data
+	if nested, ok := result.Data["data"].(map[string]interface{}); ok {
+		if _, isMetadata := result.Data["metadata"]; isMetadata {
+			return stringValue(nested, key)
+		}
+	}
+	return stringValue(result.Data, key)
+}
+
+func (p *Vault) token() (string, error) {
+	if token := p.getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if filename := p.getenv("VAULT_TOKEN_FILE"); filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE must be set")
+}
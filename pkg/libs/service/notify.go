@@ -0,0 +1,68 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// states sent to systemd with the sd_notify protocol
+const (
+	NotifyReady    = "READY=1"
+	NotifyStopping = "STOPPING=1"
+	NotifyWatchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state to systemd, e.g. READY=1 when the service is started with Type=notify.
+// It returns false if the process was not started by systemd with a notify socket.
+func Notify(state string) (bool, error) {
+	socketAddr := &net.UnixAddr{Name: os.Getenv("NOTIFY_SOCKET"), Net: "unixgram"}
+	if socketAddr.Name == "" {
+		return false, nil
+	}
+	conn, err := net.DialUnix(socketAddr.Net, nil, socketAddr)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot connect to systemd notify socket")
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, errors.Wrap(err, "cannot notify systemd")
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the systemd watchdog timeout of the process, 0 if the watchdog is not enabled with WatchdogSec
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	// the watchdog is meant for another process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("WATCHDOG_USEC must be a positive number, got '%s'", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// RunWatchdog keeps the systemd watchdog alive notifying it at half of the timeout until done is closed
+func RunWatchdog(timeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := Notify(NotifyWatchdog); err != nil {
+				logrus.Warn(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
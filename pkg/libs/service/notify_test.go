@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	a := assert.New(t)
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := Notify(NotifyReady)
+	a.Nil(err)
+	a.False(sent)
+
+	dir, err := ioutil.TempDir("", "notify")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	a.Nil(err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sent, err = Notify(NotifyReady)
+	a.Nil(err)
+	a.True(sent)
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	a.Nil(err)
+	a.Equal(NotifyReady, string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	a := assert.New(t)
+
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	interval, err := WatchdogInterval()
+	a.Nil(err)
+	a.Equal(time.Duration(0), interval)
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	interval, err = WatchdogInterval()
+	a.Nil(err)
+	a.Equal(30*time.Second, interval)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, err = WatchdogInterval()
+	a.Nil(err)
+	a.Equal(30*time.Second, interval)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	interval, err = WatchdogInterval()
+	a.Nil(err)
+	a.Equal(time.Duration(0), interval)
+
+	os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "abc")
+	_, err = WatchdogInterval()
+	a.EqualError(err, "WATCHDOG_USEC must be a positive number, got 'abc'")
+}
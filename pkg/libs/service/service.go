@@ -0,0 +1,7 @@
+package service
+
+// Controller is paused and continued by the Windows Service Control Manager
+type Controller interface {
+	Pause()
+	Resume()
+}
@@ -0,0 +1,171 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	errorCallNotImplemented = 120
+	// the service stopped with an error
+	serviceExitCodeFailure = 1
+	stopWaitHint           = 60000 // milliseconds
+)
+
+var (
+	advapi32                          = windows.NewLazySystemDLL("advapi32.dll")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+
+	// the control dispatcher runs one service per process
+	currentLock sync.Mutex
+	current     *WindowsService
+)
+
+// WindowsService reports the state of the process to the Windows Service Control Manager and forwards its stop, pause and continue controls
+type WindowsService struct {
+	name string
+	ctrl Controller
+
+	handle windows.Handle
+	state  uint32
+
+	stopOnce      sync.Once
+	stopRequested chan struct{}
+	started       chan error
+	exitCode      chan uint32
+	// result of the control dispatcher which returns after the service stopped
+	done chan error
+}
+
+// StartWindowsService connects to the Service Control Manager and reports the service running.
+// It fails if the process was not started by the Service Control Manager.
+func StartWindowsService(name string, ctrl Controller) (*WindowsService, error) {
+	s := &WindowsService{
+		name:          name,
+		ctrl:          ctrl,
+		stopRequested: make(chan struct{}),
+		started:       make(chan error, 1),
+		exitCode:      make(chan uint32, 1),
+		done:          make(chan error, 1),
+	}
+	currentLock.Lock()
+	if current != nil {
+		currentLock.Unlock()
+		return nil, errors.New("Windows service is already started")
+	}
+	current = s
+	currentLock.Unlock()
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		// the dispatcher thread is blocked until all services of the process stopped
+		runtime.LockOSThread()
+		table := []windows.SERVICE_TABLE_ENTRY{{ServiceName: namePtr, ServiceProc: syscall.NewCallback(serviceMain)}, {}}
+		s.done <- windows.StartServiceCtrlDispatcher(&table[0])
+	}()
+	select {
+	case err = <-s.started:
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Running as Windows service %s", name)
+		return s, nil
+	case err = <-s.done:
+		return nil, errors.Wrap(err, "cannot connect to the Windows Service Control Manager")
+	}
+}
+
+// StopRequested is closed when the Service Control Manager stops the service or the system shuts down
+func (s *WindowsService) StopRequested() <-chan struct{} {
+	return s.stopRequested
+}
+
+// Stopped reports the service stopped with the error of the process and waits for the control dispatcher
+func (s *WindowsService) Stopped(err error) {
+	var exitCode uint32
+	if err != nil {
+		exitCode = serviceExitCodeFailure
+	}
+	s.exitCode <- exitCode
+	if err = <-s.done; err != nil {
+		logrus.Warnf("Windows service control dispatcher failed: %v", err)
+	}
+}
+
+func (s *WindowsService) setStatus(state uint32, exitCode uint32) {
+	atomic.StoreUint32(&s.state, state)
+	status := windows.SERVICE_STATUS{
+		ServiceType:   windows.SERVICE_WIN32_OWN_PROCESS,
+		CurrentState:  state,
+		Win32ExitCode: exitCode,
+	}
+	switch state {
+	case windows.SERVICE_RUNNING, windows.SERVICE_PAUSED:
+		status.ControlsAccepted = windows.SERVICE_ACCEPT_STOP | windows.SERVICE_ACCEPT_SHUTDOWN | windows.SERVICE_ACCEPT_PAUSE_CONTINUE
+	case windows.SERVICE_STOP_PENDING:
+		status.WaitHint = stopWaitHint
+	}
+	if err := windows.SetServiceStatus(s.handle, &status); err != nil {
+		logrus.Warnf("Cannot set Windows service status %d: %v", state, err)
+	}
+}
+
+func serviceMain(argc uint32, argv **uint16) uintptr {
+	currentLock.Lock()
+	s := current
+	currentLock.Unlock()
+
+	namePtr, err := windows.UTF16PtrFromString(s.name)
+	if err != nil {
+		s.started <- err
+		return 0
+	}
+	handle, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(namePtr)), syscall.NewCallback(controlHandler), 0)
+	if handle == 0 {
+		s.started <- errors.Wrap(err, "cannot register Windows service control handler")
+		return 0
+	}
+	s.handle = windows.Handle(handle)
+	s.setStatus(windows.SERVICE_RUNNING, 0)
+	s.started <- nil
+
+	s.setStatus(windows.SERVICE_STOPPED, <-s.exitCode)
+	return 0
+}
+
+func controlHandler(control uint32, eventType uint32, eventData uintptr, context uintptr) uintptr {
+	currentLock.Lock()
+	s := current
+	currentLock.Unlock()
+
+	switch control {
+	case windows.SERVICE_CONTROL_STOP, windows.SERVICE_CONTROL_SHUTDOWN:
+		s.setStatus(windows.SERVICE_STOP_PENDING, 0)
+		s.stopOnce.Do(func() { close(s.stopRequested) })
+	case windows.SERVICE_CONTROL_PAUSE:
+		s.setStatus(windows.SERVICE_PAUSE_PENDING, 0)
+		s.ctrl.Pause()
+		s.setStatus(windows.SERVICE_PAUSED, 0)
+	case windows.SERVICE_CONTROL_CONTINUE:
+		s.setStatus(windows.SERVICE_CONTINUE_PENDING, 0)
+		s.ctrl.Resume()
+		s.setStatus(windows.SERVICE_RUNNING, 0)
+	case windows.SERVICE_CONTROL_INTERROGATE:
+		s.setStatus(atomic.LoadUint32(&s.state), 0)
+	default:
+		return errorCallNotImplemented
+	}
+	return windows.NO_ERROR
+}
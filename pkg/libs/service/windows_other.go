@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"github.com/pkg/errors"
+)
+
+// WindowsService is supported on Windows only
+type WindowsService struct{}
+
+// StartWindowsService is supported on Windows only
+func StartWindowsService(name string, ctrl Controller) (*WindowsService, error) {
+	return nil, errors.New("Windows service is supported on windows only")
+}
+
+// StopRequested is never closed
+func (s *WindowsService) StopRequested() <-chan struct{} {
+	return nil
+}
+
+// Stopped does nothing
+func (s *WindowsService) Stopped(err error) {}
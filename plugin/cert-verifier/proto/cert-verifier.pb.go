@@ -0,0 +1,133 @@
+// source: cert-verifier.proto
+
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+type CertificateVerifyRequest struct {
+	Side          string   `protobuf:"bytes,1,opt,name=side" json:"side,omitempty"`
+	RawCerts      [][]byte `protobuf:"bytes,2,rep,name=raw_certs,json=rawCerts,proto3" json:"raw_certs,omitempty"`
+	VerifiedChain [][]byte `protobuf:"bytes,3,rep,name=verified_chain,json=verifiedChain,proto3" json:"verified_chain,omitempty"`
+}
+
+func (m *CertificateVerifyRequest) Reset()         { *m = CertificateVerifyRequest{} }
+func (m *CertificateVerifyRequest) String() string { return proto1.CompactTextString(m) }
+func (*CertificateVerifyRequest) ProtoMessage()    {}
+
+func (m *CertificateVerifyRequest) GetSide() string {
+	if m != nil {
+		return m.Side
+	}
+	return ""
+}
+
+func (m *CertificateVerifyRequest) GetRawCerts() [][]byte {
+	if m != nil {
+		return m.RawCerts
+	}
+	return nil
+}
+
+func (m *CertificateVerifyRequest) GetVerifiedChain() [][]byte {
+	if m != nil {
+		return m.VerifiedChain
+	}
+	return nil
+}
+
+type CertificateVerifyResponse struct {
+	Success bool  `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
+	Status  int32 `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *CertificateVerifyResponse) Reset()         { *m = CertificateVerifyResponse{} }
+func (m *CertificateVerifyResponse) String() string { return proto1.CompactTextString(m) }
+func (*CertificateVerifyResponse) ProtoMessage()    {}
+
+func (m *CertificateVerifyResponse) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *CertificateVerifyResponse) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func init() {
+	proto1.RegisterType((*CertificateVerifyRequest)(nil), "proto.CertificateVerifyRequest")
+	proto1.RegisterType((*CertificateVerifyResponse)(nil), "proto.CertificateVerifyResponse")
+}
+
+// Client API for CertificateVerifier service
+
+type CertificateVerifierClient interface {
+	VerifyCertificate(ctx context.Context, in *CertificateVerifyRequest, opts ...grpc.CallOption) (*CertificateVerifyResponse, error)
+}
+
+type certificateVerifierClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCertificateVerifierClient(cc *grpc.ClientConn) CertificateVerifierClient {
+	return &certificateVerifierClient{cc}
+}
+
+func (c *certificateVerifierClient) VerifyCertificate(ctx context.Context, in *CertificateVerifyRequest, opts ...grpc.CallOption) (*CertificateVerifyResponse, error) {
+	out := new(CertificateVerifyResponse)
+	err := grpc.Invoke(ctx, "/proto.CertificateVerifier/VerifyCertificate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for CertificateVerifier service
+
+type CertificateVerifierServer interface {
+	VerifyCertificate(context.Context, *CertificateVerifyRequest) (*CertificateVerifyResponse, error)
+}
+
+func RegisterCertificateVerifierServer(s *grpc.Server, srv CertificateVerifierServer) {
+	s.RegisterService(&_CertificateVerifier_serviceDesc, srv)
+}
+
+func _CertificateVerifier_VerifyCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CertificateVerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateVerifierServer).VerifyCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.CertificateVerifier/VerifyCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateVerifierServer).VerifyCertificate(ctx, req.(*CertificateVerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _CertificateVerifier_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.CertificateVerifier",
+	HandlerType: (*CertificateVerifierServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "VerifyCertificate",
+			Handler:    _CertificateVerifier_VerifyCertificate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cert-verifier.proto",
+}
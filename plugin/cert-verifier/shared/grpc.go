@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/cert-verifier/proto"
+	"github.com/hashicorp/go-plugin"
+	"golang.org/x/net/context"
+)
+
+// GRPCClient is an implementation of CertificateVerifier that talks over gRPC.
+type GRPCClient struct {
+	broker *plugin.GRPCBroker
+	client proto.CertificateVerifierClient
+}
+
+func (m *GRPCClient) VerifyCertificate(ctx context.Context, request apis.CertificateVerifyRequest) (apis.CertificateVerifyResponse, error) {
+	resp, err := m.client.VerifyCertificate(ctx, &proto.CertificateVerifyRequest{
+		Side:          request.Side,
+		RawCerts:      request.RawCerts,
+		VerifiedChain: request.VerifiedChain,
+	})
+	if err != nil {
+		return apis.CertificateVerifyResponse{}, err
+	}
+	return apis.CertificateVerifyResponse{Success: resp.Success, Status: resp.Status}, nil
+}
+
+// Here is the gRPC server that GRPCClient talks to.
+type GRPCServer struct {
+	broker *plugin.GRPCBroker
+	Impl   apis.CertificateVerifier
+}
+
+func (m *GRPCServer) VerifyCertificate(
+	ctx context.Context,
+	req *proto.CertificateVerifyRequest) (*proto.CertificateVerifyResponse, error) {
+	resp, err := m.Impl.VerifyCertificate(ctx, apis.CertificateVerifyRequest{Side: req.Side, RawCerts: req.RawCerts, VerifiedChain: req.VerifiedChain})
+	return &proto.CertificateVerifyResponse{Success: resp.Success, Status: resp.Status}, err
+}
@@ -0,0 +1,25 @@
+package shared
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"net/rpc"
+)
+
+type RPCClient struct{ client *rpc.Client }
+
+func (m *RPCClient) VerifyCertificate(ctx context.Context, request apis.CertificateVerifyRequest) (apis.CertificateVerifyResponse, error) {
+	var resp apis.CertificateVerifyResponse
+	err := m.client.Call("Plugin.VerifyCertificate", request, &resp)
+	return resp, err
+}
+
+type RPCServer struct {
+	Impl apis.CertificateVerifier
+}
+
+func (m *RPCServer) VerifyCertificate(request apis.CertificateVerifyRequest, resp *apis.CertificateVerifyResponse) error {
+	var err error
+	*resp, err = m.Impl.VerifyCertificate(context.Background(), request)
+	return err
+}
@@ -0,0 +1,336 @@
+// Package discovery finds the plugin binaries in a plugin directory and verifies them against the checksums and signatures of its manifest.
+package discovery
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/go-plugin"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultManifestFile is the manifest of the plugin directory if no other manifest file is configured
+const DefaultManifestFile = "manifest.json"
+
+// Manifest lists the plugin binaries of a plugin directory
+type Manifest struct {
+	Plugins []Entry `json:"plugins"`
+}
+
+// Entry is a plugin binary of the manifest
+type Entry struct {
+	Name string `json:"name"`
+	// relative to the plugin directory, Name if empty
+	File string `json:"file,omitempty"`
+	// names of the dispensed plugins e.g. passwordAuthenticator or tokenInfo, the plugin is not restricted if empty
+	Types []string `json:"types,omitempty"`
+	// hex encoded SHA-256 checksum of the file
+	SHA256 string `json:"sha256"`
+	// base64 encoded ed25519 signature of the signed message of the entry, the plugin is unsigned if empty
+	Signature string `json:"signature,omitempty"`
+}
+
+// signedMessage returns 'name|file|types|sha256' of the entry, so the name, file and plugin types are protected by the signature
+// as well as the checksum. The types are sorted and separated by commas.
+func (e Entry) signedMessage() []byte {
+	file := e.File
+	if file == "" {
+		file = e.Name
+	}
+	types := append([]string(nil), e.Types...)
+	sort.Strings(types)
+	return []byte(strings.Join([]string{e.Name, file, strings.Join(types, ","), strings.ToLower(e.SHA256)}, "|"))
+}
+
+// Plugin is a plugin binary found in the plugin directory
+type Plugin struct {
+	Name  string
+	Path  string
+	Types []string
+	// nil if the binary is not listed in the manifest
+	Checksum []byte
+	Signed   bool
+}
+
+// SecureConfig verifies the checksum of the binary again when the plugin is launched, nil if the binary has no checksum
+func (p *Plugin) SecureConfig() *plugin.SecureConfig {
+	if p.Checksum == nil {
+		return nil
+	}
+	return &plugin.SecureConfig{Checksum: p.Checksum, Hash: sha256.New()}
+}
+
+func (p *Plugin) provides(types []string) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		for _, pt := range p.Types {
+			if t == pt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Discovery resolves the plugin commands to the binaries of the plugin directory. The binaries of the manifest are verified
+// when the directory is scanned, the checksum or signature mismatches are errors. In the hardened mode only the plugins with
+// a valid signature are launched.
+type Discovery struct {
+	dir      string
+	hardened bool
+	byName   map[string]*Plugin
+	byPath   map[string]*Plugin
+}
+
+// New scans the plugin directory and verifies the binaries of the manifest with the public keys
+func New(dir string, manifestFile string, publicKeys []ed25519.PublicKey, hardened bool) (*Discovery, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if manifestFile == "" {
+		manifestFile = filepath.Join(dir, DefaultManifestFile)
+	} else if manifestFile, err = filepath.Abs(manifestFile); err != nil {
+		return nil, err
+	}
+	d := &Discovery{
+		dir:      dir,
+		hardened: hardened,
+		byName:   make(map[string]*Plugin),
+		byPath:   make(map[string]*Plugin),
+	}
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) || hardened {
+			return nil, err
+		}
+		logrus.Warnf("Plugin manifest %s does not exist, the plugins of %s are not verified", manifestFile, dir)
+		manifest = &Manifest{}
+	}
+	for _, entry := range manifest.Plugins {
+		p, err := d.verify(entry, publicKeys)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := d.byName[p.Name]; ok {
+			return nil, fmt.Errorf("plugin %s is listed more than once in the manifest %s", p.Name, manifestFile)
+		}
+		d.byName[p.Name] = p
+		d.byPath[p.Path] = p
+	}
+	if err = d.scan(manifestFile); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Discovery) verify(entry Entry, publicKeys []ed25519.PublicKey) (*Plugin, error) {
+	if entry.Name == "" {
+		return nil, errors.New("plugin name of the manifest entry is required")
+	}
+	file := entry.File
+	if file == "" {
+		file = entry.Name
+	}
+	// the binaries outside of the plugin directory are not discovered
+	if filepath.IsAbs(file) || strings.HasPrefix(filepath.Clean(file), "..") {
+		return nil, fmt.Errorf("file %s of plugin %s is not in the plugin directory", file, entry.Name)
+	}
+	expected, err := hex.DecodeString(entry.SHA256)
+	if err != nil || len(expected) != sha256.Size {
+		return nil, fmt.Errorf("sha256 of plugin %s is not a hex encoded SHA-256 checksum", entry.Name)
+	}
+	p := &Plugin{Name: entry.Name, Path: filepath.Join(d.dir, file), Types: entry.Types}
+	if p.Checksum, err = Checksum(p.Path); err != nil {
+		return nil, errors.Wrapf(err, "checksum of plugin %s", entry.Name)
+	}
+	if !bytes.Equal(p.Checksum, expected) {
+		return nil, fmt.Errorf("checksum of plugin %s does not match the manifest", entry.Name)
+	}
+	if entry.Signature == "" {
+		logrus.Warnf("Plugin %s is not signed", entry.Name)
+		return p, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+	if err != nil {
+		return nil, errors.Wrapf(err, "signature of plugin %s", entry.Name)
+	}
+	for _, publicKey := range publicKeys {
+		if ed25519.Verify(publicKey, entry.signedMessage(), signature) {
+			p.Signed = true
+			logrus.Infof("Plugin %s is verified: %s", entry.Name, p.Path)
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("signature of plugin %s is not valid", entry.Name)
+}
+
+// scan adds the binaries of the plugin directory which are not listed in the manifest
+func (d *Discovery) scan(manifestFile string) error {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		path := filepath.Join(d.dir, info.Name())
+		if !info.Mode().IsRegular() || path == manifestFile || d.byPath[path] != nil {
+			continue
+		}
+		if _, ok := d.byName[info.Name()]; ok {
+			continue
+		}
+		logrus.Debugf("Plugin %s is not listed in the manifest", path)
+		p := &Plugin{Name: info.Name(), Path: path}
+		d.byName[p.Name] = p
+		d.byPath[p.Path] = p
+	}
+	return nil
+}
+
+// Resolve returns the plugin binary of the command which provides one of the plugin types. The command is the plugin name of the
+// manifest or a path. The commands which are not found in the plugin directory are returned unchanged unless the mode is hardened.
+func (d *Discovery) Resolve(command string, types []string) (*Plugin, error) {
+	if d == nil {
+		return &Plugin{Name: command, Path: command}, nil
+	}
+	var p *Plugin
+	if filepath.Base(command) == command {
+		p = d.byName[command]
+	} else if path, err := filepath.Abs(command); err == nil {
+		p = d.byPath[path]
+	}
+	if p == nil {
+		if d.hardened {
+			return nil, fmt.Errorf("plugin %s is not found in %s, the plugins outside of the plugin directory are refused by the hardened mode", command, d.dir)
+		}
+		return &Plugin{Name: command, Path: command}, nil
+	}
+	if !p.provides(types) {
+		return nil, fmt.Errorf("plugin %s provides %v, required is one of %v", p.Name, p.Types, types)
+	}
+	if d.hardened && !p.Signed {
+		return nil, fmt.Errorf("plugin %s is not signed, the unsigned plugins are refused by the hardened mode", p.Name)
+	}
+	return p, nil
+}
+
+// Plugins returns the discovered plugins sorted by name
+func (d *Discovery) Plugins() []*Plugin {
+	result := make([]*Plugin, 0, len(d.byName))
+	for _, p := range d.byName {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// ReadManifest reads the JSON manifest file
+func ReadManifest(manifestFile string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "plugin manifest")
+	}
+	manifest := &Manifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrapf(err, "plugin manifest %s", manifestFile)
+	}
+	return manifest, nil
+}
+
+// NewEntry returns the manifest entry of the plugin file in the plugin directory, the entry is not signed if the private key is nil
+func NewEntry(dir string, name string, file string, types []string, privateKey ed25519.PrivateKey) (Entry, error) {
+	entry := Entry{Name: name, Types: types}
+	if file != name {
+		entry.File = file
+	}
+	checksum, err := Checksum(filepath.Join(dir, file))
+	if err != nil {
+		return entry, err
+	}
+	entry.SHA256 = hex.EncodeToString(checksum)
+	if privateKey != nil {
+		entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, entry.signedMessage()))
+	}
+	return entry, nil
+}
+
+// Checksum returns the SHA-256 checksum of the file
+func Checksum(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// LoadPublicKeys reads the PEM encoded ed25519 public keys of the plugin signers
+func LoadPublicKeys(file string) ([]ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var publicKeys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "public key of %s", file)
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key of %s is not an ed25519 key", file)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	if len(publicKeys) == 0 {
+		return nil, fmt.Errorf("no PEM encoded public key found in %s", file)
+	}
+	return publicKeys, nil
+}
+
+// LoadPrivateKey reads the PEM encoded PKCS #8 ed25519 private key of the plugin signer
+func LoadPrivateKey(file string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM encoded private key found in %s", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "private key of %s", file)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key of %s is not an ed25519 key", file)
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePluginDir(a *assert.Assertions, privateKey ed25519.PrivateKey) string {
+	dir, err := ioutil.TempDir("", "plugins")
+	a.Nil(err)
+	for _, name := range []string{"auth-user", "google-id-info", "unlisted"} {
+		a.Nil(ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\necho "+name+"\n"), 0755))
+	}
+	signed, err := NewEntry(dir, "auth-user", "auth-user", []string{"passwordAuthenticator"}, privateKey)
+	a.Nil(err)
+	unsigned, err := NewEntry(dir, "google-id-info", "google-id-info", []string{"tokenInfo"}, nil)
+	a.Nil(err)
+	writeManifest(a, dir, signed, unsigned)
+	return dir
+}
+
+func writeManifest(a *assert.Assertions, dir string, entries ...Entry) {
+	data, err := json.Marshal(Manifest{Plugins: entries})
+	a.Nil(err)
+	a.Nil(ioutil.WriteFile(filepath.Join(dir, DefaultManifestFile), data, 0644))
+}
+
+func TestDiscoveryResolve(t *testing.T) {
+	a := assert.New(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	a.Nil(err)
+	dir := writePluginDir(a, privateKey)
+	defer os.RemoveAll(dir)
+
+	var disabled *Discovery
+	p, err := disabled.Resolve("/usr/local/bin/auth-user", []string{"passwordAuthenticator"})
+	a.Nil(err)
+	a.Equal("/usr/local/bin/auth-user", p.Path)
+	a.Nil(p.SecureConfig())
+
+	d, err := New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.Nil(err)
+	p, err = d.Resolve("auth-user", []string{"passwordAuthenticator", "scramCredentialStore"})
+	a.Nil(err)
+	a.Equal(filepath.Join(dir, "auth-user"), p.Path)
+	a.True(p.Signed)
+	a.NotNil(p.SecureConfig())
+	p, err = d.Resolve(filepath.Join(dir, "auth-user"), []string{"passwordAuthenticator"})
+	a.Nil(err)
+	a.True(p.Signed)
+	_, err = d.Resolve("auth-user", []string{"tokenInfo"})
+	a.NotNil(err)
+
+	p, err = d.Resolve("unlisted", []string{"tokenInfo"})
+	a.Nil(err)
+	a.Nil(p.Checksum)
+	p, err = d.Resolve("/usr/local/bin/auth-ldap", []string{"passwordAuthenticator"})
+	a.Nil(err)
+	a.Equal("/usr/local/bin/auth-ldap", p.Path)
+	a.Len(d.Plugins(), 3)
+
+	hardened, err := New(dir, "", []ed25519.PublicKey{publicKey}, true)
+	a.Nil(err)
+	_, err = hardened.Resolve("auth-user", []string{"passwordAuthenticator"})
+	a.Nil(err)
+	_, err = hardened.Resolve("google-id-info", []string{"tokenInfo"})
+	a.EqualError(err, "plugin google-id-info is not signed, the unsigned plugins are refused by the hardened mode")
+	_, err = hardened.Resolve("unlisted", []string{"tokenInfo"})
+	a.NotNil(err)
+	_, err = hardened.Resolve("/usr/local/bin/auth-ldap", []string{"passwordAuthenticator"})
+	a.NotNil(err)
+}
+
+func TestDiscoveryVerify(t *testing.T) {
+	a := assert.New(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	a.Nil(err)
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	a.Nil(err)
+	dir := writePluginDir(a, privateKey)
+	defer os.RemoveAll(dir)
+
+	_, err = New(dir, "", []ed25519.PublicKey{otherKey}, false)
+	a.EqualError(err, "signature of plugin auth-user is not valid")
+
+	// the name, file and types are signed with the checksum
+	signed, err := NewEntry(dir, "auth-user", "auth-user", []string{"passwordAuthenticator"}, privateKey)
+	a.Nil(err)
+	renamed := signed
+	renamed.Name, renamed.File = "auth-ldap", "auth-user"
+	writeManifest(a, dir, renamed)
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.EqualError(err, "signature of plugin auth-ldap is not valid")
+	widened := signed
+	widened.Types = []string{"passwordAuthenticator", "tokenInfo"}
+	writeManifest(a, dir, widened)
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.EqualError(err, "signature of plugin auth-user is not valid")
+	writeManifest(a, dir, signed)
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.Nil(err)
+
+	a.Nil(ioutil.WriteFile(filepath.Join(dir, "auth-user"), []byte("#!/bin/sh\necho tampered\n"), 0755))
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.EqualError(err, "checksum of plugin auth-user does not match the manifest")
+
+	writeManifest(a, dir, Entry{Name: "passwd", File: "../../etc/passwd", SHA256: "00"})
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, false)
+	a.EqualError(err, "file ../../etc/passwd of plugin passwd is not in the plugin directory")
+
+	// the manifest is required by the hardened mode
+	a.Nil(os.Remove(filepath.Join(dir, DefaultManifestFile)))
+	d, err := New(dir, "", nil, false)
+	a.Nil(err)
+	a.Len(d.Plugins(), 3)
+	_, err = New(dir, "", []ed25519.PublicKey{publicKey}, true)
+	a.NotNil(err)
+}
+
+func TestLoadKeys(t *testing.T) {
+	a := assert.New(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	a.Nil(err)
+	dir, err := ioutil.TempDir("", "keys")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	a.Nil(err)
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	a.Nil(err)
+	publicKeyFile, privateKeyFile := filepath.Join(dir, "plugins.pub"), filepath.Join(dir, "plugins.key")
+	a.Nil(ioutil.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644))
+	a.Nil(ioutil.WriteFile(privateKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+
+	publicKeys, err := LoadPublicKeys(publicKeyFile)
+	a.Nil(err)
+	a.Equal([]ed25519.PublicKey{publicKey}, publicKeys)
+	loaded, err := LoadPrivateKey(privateKeyFile)
+	a.Nil(err)
+	a.Equal(privateKey, loaded)
+
+	_, err = LoadPublicKeys(privateKeyFile)
+	a.NotNil(err)
+}
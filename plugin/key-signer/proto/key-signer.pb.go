@@ -0,0 +1,222 @@
+// source: key-signer.proto
+
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+type KeyPublicKeyRequest struct {
+}
+
+func (m *KeyPublicKeyRequest) Reset()         { *m = KeyPublicKeyRequest{} }
+func (m *KeyPublicKeyRequest) String() string { return proto1.CompactTextString(m) }
+func (*KeyPublicKeyRequest) ProtoMessage()    {}
+
+type KeyPublicKeyResponse struct {
+	Success   bool   `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
+	Status    int32  `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	PublicKey []byte `protobuf:"bytes,3,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+}
+
+func (m *KeyPublicKeyResponse) Reset()         { *m = KeyPublicKeyResponse{} }
+func (m *KeyPublicKeyResponse) String() string { return proto1.CompactTextString(m) }
+func (*KeyPublicKeyResponse) ProtoMessage()    {}
+
+func (m *KeyPublicKeyResponse) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *KeyPublicKeyResponse) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *KeyPublicKeyResponse) GetPublicKey() []byte {
+	if m != nil {
+		return m.PublicKey
+	}
+	return nil
+}
+
+type KeySignRequest struct {
+	Digest        []byte `protobuf:"bytes,1,opt,name=digest,proto3" json:"digest,omitempty"`
+	Hash          uint32 `protobuf:"varint,2,opt,name=hash" json:"hash,omitempty"`
+	Pss           bool   `protobuf:"varint,3,opt,name=pss" json:"pss,omitempty"`
+	PssSaltLength int32  `protobuf:"varint,4,opt,name=pss_salt_length,json=pssSaltLength" json:"pss_salt_length,omitempty"`
+}
+
+func (m *KeySignRequest) Reset()         { *m = KeySignRequest{} }
+func (m *KeySignRequest) String() string { return proto1.CompactTextString(m) }
+func (*KeySignRequest) ProtoMessage()    {}
+
+func (m *KeySignRequest) GetDigest() []byte {
+	if m != nil {
+		return m.Digest
+	}
+	return nil
+}
+
+func (m *KeySignRequest) GetHash() uint32 {
+	if m != nil {
+		return m.Hash
+	}
+	return 0
+}
+
+func (m *KeySignRequest) GetPss() bool {
+	if m != nil {
+		return m.Pss
+	}
+	return false
+}
+
+func (m *KeySignRequest) GetPssSaltLength() int32 {
+	if m != nil {
+		return m.PssSaltLength
+	}
+	return 0
+}
+
+type KeySignResponse struct {
+	Success   bool   `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
+	Status    int32  `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	Signature []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *KeySignResponse) Reset()         { *m = KeySignResponse{} }
+func (m *KeySignResponse) String() string { return proto1.CompactTextString(m) }
+func (*KeySignResponse) ProtoMessage()    {}
+
+func (m *KeySignResponse) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *KeySignResponse) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *KeySignResponse) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto1.RegisterType((*KeyPublicKeyRequest)(nil), "proto.KeyPublicKeyRequest")
+	proto1.RegisterType((*KeyPublicKeyResponse)(nil), "proto.KeyPublicKeyResponse")
+	proto1.RegisterType((*KeySignRequest)(nil), "proto.KeySignRequest")
+	proto1.RegisterType((*KeySignResponse)(nil), "proto.KeySignResponse")
+}
+
+// Client API for KeySigner service
+
+type KeySignerClient interface {
+	PublicKey(ctx context.Context, in *KeyPublicKeyRequest, opts ...grpc.CallOption) (*KeyPublicKeyResponse, error)
+	Sign(ctx context.Context, in *KeySignRequest, opts ...grpc.CallOption) (*KeySignResponse, error)
+}
+
+type keySignerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewKeySignerClient(cc *grpc.ClientConn) KeySignerClient {
+	return &keySignerClient{cc}
+}
+
+func (c *keySignerClient) PublicKey(ctx context.Context, in *KeyPublicKeyRequest, opts ...grpc.CallOption) (*KeyPublicKeyResponse, error) {
+	out := new(KeyPublicKeyResponse)
+	err := grpc.Invoke(ctx, "/proto.KeySigner/PublicKey", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keySignerClient) Sign(ctx context.Context, in *KeySignRequest, opts ...grpc.CallOption) (*KeySignResponse, error) {
+	out := new(KeySignResponse)
+	err := grpc.Invoke(ctx, "/proto.KeySigner/Sign", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for KeySigner service
+
+type KeySignerServer interface {
+	PublicKey(context.Context, *KeyPublicKeyRequest) (*KeyPublicKeyResponse, error)
+	Sign(context.Context, *KeySignRequest) (*KeySignResponse, error)
+}
+
+func RegisterKeySignerServer(s *grpc.Server, srv KeySignerServer) {
+	s.RegisterService(&_KeySigner_serviceDesc, srv)
+}
+
+func _KeySigner_PublicKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyPublicKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeySignerServer).PublicKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.KeySigner/PublicKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeySignerServer).PublicKey(ctx, req.(*KeyPublicKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeySigner_Sign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeySignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeySignerServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.KeySigner/Sign",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeySignerServer).Sign(ctx, req.(*KeySignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KeySigner_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.KeySigner",
+	HandlerType: (*KeySignerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PublicKey",
+			Handler:    _KeySigner_PublicKey_Handler,
+		},
+		{
+			MethodName: "Sign",
+			Handler:    _KeySigner_Sign_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "key-signer.proto",
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/key-signer/proto"
+	"github.com/hashicorp/go-plugin"
+	"golang.org/x/net/context"
+)
+
+// GRPCClient is an implementation of KeySigner that talks over gRPC.
+type GRPCClient struct {
+	broker *plugin.GRPCBroker
+	client proto.KeySignerClient
+}
+
+func (m *GRPCClient) PublicKey(ctx context.Context, request apis.KeyPublicKeyRequest) (apis.KeyPublicKeyResponse, error) {
+	resp, err := m.client.PublicKey(ctx, &proto.KeyPublicKeyRequest{})
+	if err != nil {
+		return apis.KeyPublicKeyResponse{}, err
+	}
+	return apis.KeyPublicKeyResponse{Success: resp.Success, Status: resp.Status, PublicKey: resp.PublicKey}, nil
+}
+
+func (m *GRPCClient) Sign(ctx context.Context, request apis.KeySignRequest) (apis.KeySignResponse, error) {
+	resp, err := m.client.Sign(ctx, &proto.KeySignRequest{
+		Digest:        request.Digest,
+		Hash:          request.Hash,
+		Pss:           request.PSS,
+		PssSaltLength: request.PSSSaltLength,
+	})
+	if err != nil {
+		return apis.KeySignResponse{}, err
+	}
+	return apis.KeySignResponse{Success: resp.Success, Status: resp.Status, Signature: resp.Signature}, nil
+}
+
+// Here is the gRPC server that GRPCClient talks to.
+type GRPCServer struct {
+	broker *plugin.GRPCBroker
+	Impl   apis.KeySigner
+}
+
+func (m *GRPCServer) PublicKey(
+	ctx context.Context,
+	req *proto.KeyPublicKeyRequest) (*proto.KeyPublicKeyResponse, error) {
+	resp, err := m.Impl.PublicKey(ctx, apis.KeyPublicKeyRequest{})
+	return &proto.KeyPublicKeyResponse{Success: resp.Success, Status: resp.Status, PublicKey: resp.PublicKey}, err
+}
+
+func (m *GRPCServer) Sign(
+	ctx context.Context,
+	req *proto.KeySignRequest) (*proto.KeySignResponse, error) {
+	resp, err := m.Impl.Sign(ctx, apis.KeySignRequest{Digest: req.Digest, Hash: req.Hash, PSS: req.Pss, PSSSaltLength: req.PssSaltLength})
+	return &proto.KeySignResponse{Success: resp.Success, Status: resp.Status, Signature: resp.Signature}, err
+}
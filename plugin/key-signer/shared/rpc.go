@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"net/rpc"
+)
+
+type RPCClient struct{ client *rpc.Client }
+
+func (m *RPCClient) PublicKey(ctx context.Context, request apis.KeyPublicKeyRequest) (apis.KeyPublicKeyResponse, error) {
+	var resp apis.KeyPublicKeyResponse
+	err := m.client.Call("Plugin.PublicKey", request, &resp)
+	return resp, err
+}
+
+func (m *RPCClient) Sign(ctx context.Context, request apis.KeySignRequest) (apis.KeySignResponse, error) {
+	var resp apis.KeySignResponse
+	err := m.client.Call("Plugin.Sign", request, &resp)
+	return resp, err
+}
+
+type RPCServer struct {
+	Impl apis.KeySigner
+}
+
+func (m *RPCServer) PublicKey(request apis.KeyPublicKeyRequest, resp *apis.KeyPublicKeyResponse) error {
+	var err error
+	*resp, err = m.Impl.PublicKey(context.Background(), request)
+	return err
+}
+
+func (m *RPCServer) Sign(request apis.KeySignRequest, resp *apis.KeySignResponse) error {
+	var err error
+	*resp, err = m.Impl.Sign(context.Background(), request)
+	return err
+}
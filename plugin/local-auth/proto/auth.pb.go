@@ -60,6 +60,11 @@ func (m *CredentialsRequest) GetPassword() string {
 type AuthenticateResponse struct {
 	Authenticated bool  `protobuf:"varint,1,opt,name=authenticated" json:"authenticated,omitempty"`
 	Status        int32 `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	// session attributes of the authenticated user, zero values are not limited
+	MaxConnections        int32    `protobuf:"varint,3,opt,name=max_connections,json=maxConnections" json:"max_connections,omitempty"`
+	RequestsPerSecond     float64  `protobuf:"fixed64,4,opt,name=requests_per_second,json=requestsPerSecond" json:"requests_per_second,omitempty"`
+	RequestBytesPerSecond float64  `protobuf:"fixed64,5,opt,name=request_bytes_per_second,json=requestBytesPerSecond" json:"request_bytes_per_second,omitempty"`
+	AllowedTopics         []string `protobuf:"bytes,6,rep,name=allowed_topics,json=allowedTopics" json:"allowed_topics,omitempty"`
 }
 
 func (m *AuthenticateResponse) Reset()                    { *m = AuthenticateResponse{} }
@@ -81,6 +86,34 @@ func (m *AuthenticateResponse) GetStatus() int32 {
 	return 0
 }
 
+func (m *AuthenticateResponse) GetMaxConnections() int32 {
+	if m != nil {
+		return m.MaxConnections
+	}
+	return 0
+}
+
+func (m *AuthenticateResponse) GetRequestsPerSecond() float64 {
+	if m != nil {
+		return m.RequestsPerSecond
+	}
+	return 0
+}
+
+func (m *AuthenticateResponse) GetRequestBytesPerSecond() float64 {
+	if m != nil {
+		return m.RequestBytesPerSecond
+	}
+	return 0
+}
+
+func (m *AuthenticateResponse) GetAllowedTopics() []string {
+	if m != nil {
+		return m.AllowedTopics
+	}
+	return nil
+}
+
 func init() {
 	proto1.RegisterType((*CredentialsRequest)(nil), "proto.CredentialsRequest")
 	proto1.RegisterType((*AuthenticateResponse)(nil), "proto.AuthenticateResponse")
@@ -161,17 +194,24 @@ var _PasswordAuthenticator_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("auth.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 187 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4a, 0x2c, 0x2d, 0xc9,
-	0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05, 0x53, 0x4a, 0x3e, 0x5c, 0x42, 0xce, 0x45,
-	0xa9, 0x29, 0xa9, 0x79, 0x25, 0x99, 0x89, 0x39, 0xc5, 0x41, 0xa9, 0x85, 0xa5, 0xa9, 0xc5, 0x25,
-	0x42, 0x52, 0x5c, 0x1c, 0xa5, 0xc5, 0xa9, 0x45, 0x79, 0x89, 0xb9, 0xa9, 0x12, 0x8c, 0x0a, 0x8c,
-	0x1a, 0x9c, 0x41, 0x70, 0x3e, 0x48, 0xae, 0x20, 0xb1, 0xb8, 0xb8, 0x3c, 0xbf, 0x28, 0x45, 0x82,
-	0x09, 0x22, 0x07, 0xe3, 0x2b, 0x85, 0x70, 0x89, 0x38, 0x96, 0x96, 0x64, 0x80, 0x4c, 0x4b, 0x4e,
-	0x2c, 0x49, 0x0d, 0x4a, 0x2d, 0x2e, 0xc8, 0xcf, 0x2b, 0x4e, 0x15, 0x52, 0xe1, 0xe2, 0x4d, 0x44,
-	0x12, 0x4f, 0x01, 0x1b, 0xca, 0x11, 0x84, 0x2a, 0x28, 0x24, 0xc6, 0xc5, 0x56, 0x5c, 0x92, 0x58,
-	0x52, 0x5a, 0x0c, 0x36, 0x97, 0x35, 0x08, 0xca, 0x33, 0x8a, 0xe7, 0x12, 0x0d, 0x80, 0xda, 0x80,
-	0x64, 0x7a, 0x7e, 0x91, 0x90, 0x1b, 0x17, 0x0f, 0xb2, 0x75, 0x42, 0x92, 0x10, 0xbf, 0xe9, 0x61,
-	0xfa, 0x48, 0x4a, 0x1a, 0x2a, 0x85, 0xcd, 0x79, 0x49, 0x6c, 0x60, 0x39, 0x63, 0x40, 0x00, 0x00,
-	0x00, 0xff, 0xff, 0x87, 0xdc, 0x40, 0x61, 0x20, 0x01, 0x00, 0x00,
+	// 291 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0x41, 0x4f, 0xc2, 0x30,
+	0x14, 0xc7, 0x33, 0x10, 0x02, 0x2f, 0x82, 0xb1, 0x8a, 0xa9, 0x78, 0x21, 0x44, 0x23, 0xa7, 0x1d,
+	0xf4, 0xe0, 0x59, 0x49, 0x3c, 0x79, 0x20, 0xd5, 0x7b, 0x53, 0xb6, 0x97, 0xb0, 0x64, 0x6b, 0x67,
+	0x5f, 0x17, 0xf0, 0x43, 0xf8, 0x9d, 0xcd, 0xba, 0x22, 0x23, 0x7a, 0x5a, 0xde, 0xef, 0xb7, 0xf7,
+	0x4f, 0xfa, 0x7f, 0x00, 0xaa, 0x72, 0x9b, 0xb8, 0xb4, 0xc6, 0x19, 0xd6, 0xf3, 0x9f, 0xf9, 0x1b,
+	0xb0, 0xa5, 0xc5, 0x14, 0xb5, 0xcb, 0x54, 0x4e, 0x02, 0x3f, 0x2b, 0x24, 0xc7, 0xa6, 0x30, 0xa8,
+	0x08, 0xad, 0x56, 0x05, 0xf2, 0x68, 0x16, 0x2d, 0x86, 0xe2, 0x77, 0xae, 0x5d, 0xa9, 0x88, 0xb6,
+	0xc6, 0xa6, 0xbc, 0xd3, 0xb8, 0xfd, 0x3c, 0xff, 0xee, 0xc0, 0xe5, 0x73, 0xe5, 0x36, 0x75, 0x5c,
+	0xa2, 0x1c, 0x0a, 0xa4, 0xd2, 0x68, 0x42, 0x76, 0x0b, 0x23, 0xd5, 0xe2, 0xa9, 0x4f, 0x1d, 0x88,
+	0x63, 0xc8, 0xae, 0xa0, 0x4f, 0x4e, 0xb9, 0x8a, 0x7c, 0x70, 0x4f, 0x84, 0x89, 0xdd, 0xc3, 0x59,
+	0xa1, 0x76, 0x32, 0x31, 0x5a, 0x63, 0xe2, 0x32, 0xa3, 0x89, 0x77, 0xfd, 0x0f, 0xe3, 0x42, 0xed,
+	0x96, 0x07, 0xca, 0x62, 0xb8, 0xb0, 0xcd, 0x13, 0x48, 0x96, 0x68, 0x25, 0x61, 0x62, 0x74, 0xca,
+	0x4f, 0x66, 0xd1, 0x22, 0x12, 0xe7, 0x7b, 0xb5, 0x42, 0xfb, 0xee, 0x05, 0x7b, 0x02, 0x1e, 0xa0,
+	0x5c, 0x7f, 0x39, 0x3c, 0x5a, 0xea, 0xf9, 0xa5, 0x49, 0xf0, 0x2f, 0xb5, 0x3e, 0x2c, 0xde, 0xc1,
+	0x58, 0xe5, 0xb9, 0xd9, 0x62, 0x2a, 0x9d, 0x29, 0xb3, 0x84, 0x78, 0x7f, 0xd6, 0x5d, 0x0c, 0xc5,
+	0x28, 0xd0, 0x0f, 0x0f, 0x1f, 0x24, 0x4c, 0x56, 0xa1, 0x9b, 0x56, 0x2d, 0xc6, 0xb2, 0x57, 0x38,
+	0x6d, 0x01, 0x64, 0xd7, 0xcd, 0x55, 0xe2, 0xbf, 0xb7, 0x98, 0xde, 0x04, 0xf5, 0x5f, 0xaf, 0xeb,
+	0xbe, 0x77, 0x8f, 0x3f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x03, 0x00, 0x40, 0x85, 0x63, 0xf3, 0xda,
+	0x01, 0x00, 0x00,
 }
@@ -0,0 +1,157 @@
+// source: scram.proto
+
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+type ScramCredentialRequest struct {
+	Mechanism string `protobuf:"bytes,1,opt,name=mechanism" json:"mechanism,omitempty"`
+	Username  string `protobuf:"bytes,2,opt,name=username" json:"username,omitempty"`
+}
+
+func (m *ScramCredentialRequest) Reset()         { *m = ScramCredentialRequest{} }
+func (m *ScramCredentialRequest) String() string { return proto1.CompactTextString(m) }
+func (*ScramCredentialRequest) ProtoMessage()    {}
+
+func (m *ScramCredentialRequest) GetMechanism() string {
+	if m != nil {
+		return m.Mechanism
+	}
+	return ""
+}
+
+func (m *ScramCredentialRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type ScramCredentialResponse struct {
+	Found      bool   `protobuf:"varint,1,opt,name=found" json:"found,omitempty"`
+	Status     int32  `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	Iterations int32  `protobuf:"varint,3,opt,name=iterations" json:"iterations,omitempty"`
+	Salt       []byte `protobuf:"bytes,4,opt,name=salt,proto3" json:"salt,omitempty"`
+	StoredKey  []byte `protobuf:"bytes,5,opt,name=stored_key,json=storedKey,proto3" json:"stored_key,omitempty"`
+	ServerKey  []byte `protobuf:"bytes,6,opt,name=server_key,json=serverKey,proto3" json:"server_key,omitempty"`
+}
+
+func (m *ScramCredentialResponse) Reset()         { *m = ScramCredentialResponse{} }
+func (m *ScramCredentialResponse) String() string { return proto1.CompactTextString(m) }
+func (*ScramCredentialResponse) ProtoMessage()    {}
+
+func (m *ScramCredentialResponse) GetFound() bool {
+	if m != nil {
+		return m.Found
+	}
+	return false
+}
+
+func (m *ScramCredentialResponse) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *ScramCredentialResponse) GetIterations() int32 {
+	if m != nil {
+		return m.Iterations
+	}
+	return 0
+}
+
+func (m *ScramCredentialResponse) GetSalt() []byte {
+	if m != nil {
+		return m.Salt
+	}
+	return nil
+}
+
+func (m *ScramCredentialResponse) GetStoredKey() []byte {
+	if m != nil {
+		return m.StoredKey
+	}
+	return nil
+}
+
+func (m *ScramCredentialResponse) GetServerKey() []byte {
+	if m != nil {
+		return m.ServerKey
+	}
+	return nil
+}
+
+func init() {
+	proto1.RegisterType((*ScramCredentialRequest)(nil), "proto.ScramCredentialRequest")
+	proto1.RegisterType((*ScramCredentialResponse)(nil), "proto.ScramCredentialResponse")
+}
+
+// Client API for ScramCredentialStore service
+
+type ScramCredentialStoreClient interface {
+	GetScramCredential(ctx context.Context, in *ScramCredentialRequest, opts ...grpc.CallOption) (*ScramCredentialResponse, error)
+}
+
+type scramCredentialStoreClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewScramCredentialStoreClient(cc *grpc.ClientConn) ScramCredentialStoreClient {
+	return &scramCredentialStoreClient{cc}
+}
+
+func (c *scramCredentialStoreClient) GetScramCredential(ctx context.Context, in *ScramCredentialRequest, opts ...grpc.CallOption) (*ScramCredentialResponse, error) {
+	out := new(ScramCredentialResponse)
+	err := grpc.Invoke(ctx, "/proto.ScramCredentialStore/GetScramCredential", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for ScramCredentialStore service
+
+type ScramCredentialStoreServer interface {
+	GetScramCredential(context.Context, *ScramCredentialRequest) (*ScramCredentialResponse, error)
+}
+
+func RegisterScramCredentialStoreServer(s *grpc.Server, srv ScramCredentialStoreServer) {
+	s.RegisterService(&_ScramCredentialStore_serviceDesc, srv)
+}
+
+func _ScramCredentialStore_GetScramCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScramCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScramCredentialStoreServer).GetScramCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.ScramCredentialStore/GetScramCredential",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScramCredentialStoreServer).GetScramCredential(ctx, req.(*ScramCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ScramCredentialStore_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.ScramCredentialStore",
+	HandlerType: (*ScramCredentialStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetScramCredential",
+			Handler:    _ScramCredentialStore_GetScramCredential_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "scram.proto",
+}
@@ -14,14 +14,25 @@ type GRPCClient struct {
 }
 
 func (m *GRPCClient) Authenticate(username, password string) (bool, int32, error) {
+	a, s, _, err := m.AuthenticateWithAttributes(username, password)
+	return a, s, err
+}
+
+func (m *GRPCClient) AuthenticateWithAttributes(username, password string) (bool, int32, *apis.SessionAttributes, error) {
 	resp, err := m.client.Authenticate(context.Background(), &proto.CredentialsRequest{
 		Username: username,
 		Password: password,
 	})
 	if err != nil {
-		return false, 0, err
+		return false, 0, nil, err
+	}
+	attributes := &apis.SessionAttributes{
+		MaxConnections:        resp.MaxConnections,
+		RequestsPerSecond:     resp.RequestsPerSecond,
+		RequestBytesPerSecond: resp.RequestBytesPerSecond,
+		AllowedTopics:         resp.AllowedTopics,
 	}
-	return resp.Authenticated, resp.Status, nil
+	return resp.Authenticated, resp.Status, attributes, nil
 }
 
 // Here is the gRPC server that GRPCClient talks to.
@@ -33,6 +44,62 @@ type GRPCServer struct {
 func (m *GRPCServer) Authenticate(
 	ctx context.Context,
 	req *proto.CredentialsRequest) (*proto.AuthenticateResponse, error) {
-	a, s, err := m.Impl.Authenticate(req.Username, req.Password)
-	return &proto.AuthenticateResponse{Authenticated: a, Status: s}, err
+	impl, ok := m.Impl.(apis.AttributesPasswordAuthenticator)
+	if !ok {
+		a, s, err := m.Impl.Authenticate(req.Username, req.Password)
+		return &proto.AuthenticateResponse{Authenticated: a, Status: s}, err
+	}
+	a, s, attributes, err := impl.AuthenticateWithAttributes(req.Username, req.Password)
+	resp := &proto.AuthenticateResponse{Authenticated: a, Status: s}
+	if attributes != nil {
+		resp.MaxConnections = attributes.MaxConnections
+		resp.RequestsPerSecond = attributes.RequestsPerSecond
+		resp.RequestBytesPerSecond = attributes.RequestBytesPerSecond
+		resp.AllowedTopics = attributes.AllowedTopics
+	}
+	return resp, err
+}
+
+// ScramCredentialStoreGRPCClient is an implementation of ScramCredentialStore that talks over gRPC.
+type ScramCredentialStoreGRPCClient struct {
+	broker *plugin.GRPCBroker
+	client proto.ScramCredentialStoreClient
+}
+
+func (m *ScramCredentialStoreGRPCClient) GetScramCredential(request apis.ScramCredentialRequest) (apis.ScramCredentialResponse, error) {
+	resp, err := m.client.GetScramCredential(context.Background(), &proto.ScramCredentialRequest{
+		Mechanism: request.Mechanism,
+		Username:  request.Username,
+	})
+	if err != nil {
+		return apis.ScramCredentialResponse{}, err
+	}
+	return apis.ScramCredentialResponse{
+		Found:      resp.Found,
+		Status:     resp.Status,
+		Iterations: resp.Iterations,
+		Salt:       resp.Salt,
+		StoredKey:  resp.StoredKey,
+		ServerKey:  resp.ServerKey,
+	}, nil
+}
+
+// Here is the gRPC server that ScramCredentialStoreGRPCClient talks to.
+type ScramCredentialStoreGRPCServer struct {
+	broker *plugin.GRPCBroker
+	Impl   apis.ScramCredentialStore
+}
+
+func (m *ScramCredentialStoreGRPCServer) GetScramCredential(
+	ctx context.Context,
+	req *proto.ScramCredentialRequest) (*proto.ScramCredentialResponse, error) {
+	resp, err := m.Impl.GetScramCredential(apis.ScramCredentialRequest{Mechanism: req.Mechanism, Username: req.Username})
+	return &proto.ScramCredentialResponse{
+		Found:      resp.Found,
+		Status:     resp.Status,
+		Iterations: resp.Iterations,
+		Salt:       resp.Salt,
+		StoredKey:  resp.StoredKey,
+		ServerKey:  resp.ServerKey,
+	}, err
 }
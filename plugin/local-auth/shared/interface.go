@@ -20,6 +20,7 @@ var Handshake = plugin.HandshakeConfig{
 
 var PluginMap = map[string]plugin.Plugin{
 	"passwordAuthenticator": &PasswordAuthenticatorPlugin{},
+	"scramCredentialStore":  &ScramCredentialStorePlugin{},
 }
 
 type PasswordAuthenticatorPlugin struct {
@@ -48,3 +49,30 @@ func (p *PasswordAuthenticatorPlugin) Server(*plugin.MuxBroker) (interface{}, er
 func (*PasswordAuthenticatorPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
 	return &RPCClient{client: c}, nil
 }
+
+type ScramCredentialStorePlugin struct {
+	Impl apis.ScramCredentialStore
+}
+
+func (p *ScramCredentialStorePlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	proto.RegisterScramCredentialStoreServer(s, &ScramCredentialStoreGRPCServer{
+		Impl:   p.Impl,
+		broker: broker,
+	})
+	return nil
+}
+
+func (p *ScramCredentialStorePlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &ScramCredentialStoreGRPCClient{
+		client: proto.NewScramCredentialStoreClient(c),
+		broker: broker,
+	}, nil
+}
+
+func (p *ScramCredentialStorePlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &ScramCredentialStoreRPCServer{Impl: p.Impl}, nil
+}
+
+func (*ScramCredentialStorePlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &ScramCredentialStoreRPCClient{client: c}, nil
+}
@@ -8,12 +8,23 @@ import (
 type RPCClient struct{ client *rpc.Client }
 
 func (m *RPCClient) Authenticate(username, password string) (bool, int32, error) {
+	a, s, _, err := m.AuthenticateWithAttributes(username, password)
+	return a, s, err
+}
+
+// AuthenticateWithAttributes returns the session attributes of the user, they are empty if the plugin does not return them
+func (m *RPCClient) AuthenticateWithAttributes(username, password string) (bool, int32, *apis.SessionAttributes, error) {
 	var resp map[string]interface{}
 	err := m.client.Call("Plugin.Authenticate", map[string]interface{}{
 		"username": username,
 		"password": password,
 	}, &resp)
-	return resp["authenticated"].(bool), resp["status"].(int32), err
+	attributes := &apis.SessionAttributes{}
+	attributes.MaxConnections, _ = resp["max_connections"].(int32)
+	attributes.RequestsPerSecond, _ = resp["requests_per_second"].(float64)
+	attributes.RequestBytesPerSecond, _ = resp["request_bytes_per_second"].(float64)
+	attributes.AllowedTopics, _ = resp["allowed_topics"].([]string)
+	return resp["authenticated"].(bool), resp["status"].(int32), attributes, err
 }
 
 type RPCServer struct {
@@ -21,10 +32,43 @@ type RPCServer struct {
 }
 
 func (m *RPCServer) Authenticate(args map[string]interface{}, resp *map[string]interface{}) error {
-	a, s, err := m.Impl.Authenticate(args["username"].(string), args["password"].(string))
+	impl, ok := m.Impl.(apis.AttributesPasswordAuthenticator)
+	if !ok {
+		a, s, err := m.Impl.Authenticate(args["username"].(string), args["password"].(string))
+		*resp = map[string]interface{}{
+			"authenticated": a,
+			"status":        s,
+		}
+		return err
+	}
+	a, s, attributes, err := impl.AuthenticateWithAttributes(args["username"].(string), args["password"].(string))
 	*resp = map[string]interface{}{
 		"authenticated": a,
 		"status":        s,
 	}
+	if attributes != nil {
+		(*resp)["max_connections"] = attributes.MaxConnections
+		(*resp)["requests_per_second"] = attributes.RequestsPerSecond
+		(*resp)["request_bytes_per_second"] = attributes.RequestBytesPerSecond
+		(*resp)["allowed_topics"] = attributes.AllowedTopics
+	}
+	return err
+}
+
+type ScramCredentialStoreRPCClient struct{ client *rpc.Client }
+
+func (m *ScramCredentialStoreRPCClient) GetScramCredential(request apis.ScramCredentialRequest) (apis.ScramCredentialResponse, error) {
+	var resp apis.ScramCredentialResponse
+	err := m.client.Call("Plugin.GetScramCredential", request, &resp)
+	return resp, err
+}
+
+type ScramCredentialStoreRPCServer struct {
+	Impl apis.ScramCredentialStore
+}
+
+func (m *ScramCredentialStoreRPCServer) GetScramCredential(request apis.ScramCredentialRequest, resp *apis.ScramCredentialResponse) error {
+	var err error
+	*resp, err = m.Impl.GetScramCredential(request)
 	return err
 }
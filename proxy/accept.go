@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// reasons of rejected connections
+const (
+	rejectReasonDenied          = "denied"
+	rejectReasonNotAllowed      = "not_allowed"
+	rejectReasonHandshakeFailed = "handshake_failed"
+	rejectReasonALPN            = "alpn_mismatch"
+	rejectReasonPaused          = "paused"
+	rejectReasonNotEnrolled     = "not_enrolled"
+	rejectReasonOverloaded      = "overloaded"
+)
+
+// acceptOptions are applied by the accept loop of a listener instance
+type acceptOptions struct {
+	sourceFilter *sourceFilter
+	// connections per second, zero disables the limit
+	acceptRate  float64
+	acceptBurst int
+	// zero disables the limit, TLS handshake is then performed on the first read unless the client auth is audited
+	maxConcurrentHandshakes int
+	handshakeTimeout        time.Duration
+	// audit of the warn-only client auth policy, nil if the clients without valid certificate are not logged
+	clientAuthAudit *clientAuthAudit
+	// allowlist of the client certificates checked again for the resumed sessions, nil if all certificates are allowed
+	clientCertFingerprints *clientCertFingerprints
+	// rejects new connections while the proxy is overloaded, nil if the load is not limited
+	loadShedder *loadShedder
+	// principals of the Unix socket peers, nil if the Unix peer authentication is disabled
+	unixPeers *unixPeerPrincipals
+	// new connections are rejected while set to 1
+	paused *int32
+}
+
+// rateLimiter is a token bucket used by a single accept loop
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newRateLimiter returns nil when the rate is not limited
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+// take consumes a token and returns how long the caller has to wait for it
+func (r *rateLimiter) take() time.Duration {
+	return r.takeN(1)
+}
+
+// takeN consumes n tokens and returns how long the caller has to wait for them
+func (r *rateLimiter) takeN(n float64) time.Duration {
+	if r == nil {
+		return 0
+	}
+	now := r.now()
+	if !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+	r.tokens -= n
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// exceedsN returns how long the caller would have to wait for n tokens, the tokens are consumed only if they are available
+func (r *rateLimiter) exceedsN(n float64) time.Duration {
+	delay := r.takeN(n)
+	if delay > 0 {
+		r.tokens += n
+	}
+	return delay
+}
+
+// tlsHandshake performs the server handshake when one of the handshake slots is free, the handshakes are not limited when slots is nil.
+// The timeout includes the wait for the slot, the connection is closed if no slot is free in time.
+func tlsHandshake(conn *tls.Conn, slots chan struct{}, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if slots != nil {
+		if timeout > 0 {
+			timer := time.NewTimer(time.Until(deadline))
+			select {
+			case slots <- struct{}{}:
+				timer.Stop()
+			case <-timer.C:
+				conn.Close()
+				return errors.New("no TLS handshake slot is free within the handshake timeout")
+			}
+		} else {
+			slots <- struct{}{}
+		}
+		defer func() { <-slots }()
+	}
+
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(newRateLimiter(0, 10))
+	a.Equal(time.Duration(0), newRateLimiter(0, 10).take())
+
+	limiter := newRateLimiter(10, 2)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// burst
+	a.Equal(time.Duration(0), limiter.take())
+	a.Equal(time.Duration(0), limiter.take())
+	// 100ms per token
+	a.Equal(100*time.Millisecond, limiter.take())
+	a.Equal(200*time.Millisecond, limiter.take())
+
+	// waited tokens are paid back
+	now = now.Add(300 * time.Millisecond)
+	a.Equal(time.Duration(0), limiter.take())
+	a.Equal(100*time.Millisecond, limiter.take())
+
+	// tokens do not exceed the burst
+	now = now.Add(time.Minute)
+	a.Equal(time.Duration(0), limiter.take())
+	a.Equal(time.Duration(0), limiter.take())
+	a.Equal(100*time.Millisecond, limiter.take())
+}
+
+func TestTLSHandshakeTimeoutReleasesSlot(t *testing.T) {
+	a := assert.New(t)
+
+	slots := make(chan struct{}, 1)
+	for i := 0; i < 2; i++ {
+		serverConn, clientConn := net.Pipe()
+		// client never sends ClientHello
+		err := tlsHandshake(tls.Server(serverConn, &tls.Config{}), slots, 50*time.Millisecond)
+		a.NotNil(err)
+		a.Equal(0, len(slots))
+		serverConn.Close()
+		clientConn.Close()
+	}
+}
+
+func TestTLSHandshakeTimeoutIncludesSlotWait(t *testing.T) {
+	a := assert.New(t)
+
+	// all slots are taken
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	start := time.Now()
+	err := tlsHandshake(tls.Server(serverConn, &tls.Config{}), slots, 50*time.Millisecond)
+	a.EqualError(err, "no TLS handshake slot is free within the handshake timeout")
+	a.True(time.Since(start) < time.Second)
+	a.Equal(1, len(slots))
+
+	// the connection is closed
+	_, err = clientConn.Read(make([]byte, 1))
+	a.NotNil(err)
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	ackSpoofingForwarded = "forwarded"
+	ackSpoofingDropped   = "dropped"
+	ackSpoofingFailed    = "failed"
+)
+
+// ackSpoofing acknowledges the Produce requests with acks 1 by the proxy and forwards them to the broker with acks 0 asynchronously,
+// so the producers do not wait for the brokers. DANGEROUS: the records of an acknowledged request are lost when the queue of the connection
+// is full, the write to the broker fails or the broker rejects them. The losses are not reported to the producers, they are only counted.
+type ackSpoofing struct {
+	topics    []*regexp.Regexp
+	queueSize int
+}
+
+// newAckSpoofing returns nil if the Produce requests are acknowledged by the brokers
+func newAckSpoofing(c *config.Config) (*ackSpoofing, error) {
+	if !c.AckSpoofing.Enable {
+		return nil, nil
+	}
+	s := &ackSpoofing{queueSize: c.AckSpoofing.QueueSize}
+	for _, v := range c.AckSpoofing.Topics {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		s.topics = append(s.topics, re)
+	}
+	logrus.Warnf("Produce requests with acks 1 will be acknowledged by the proxy, the records can be lost without notice to the producers")
+	return s, nil
+}
+
+// inspects reports whether the request body must be read to be acknowledged by the proxy
+func (s *ackSpoofing) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s != nil && requestKeyVersion.ApiKey == apiKeyProduce
+}
+
+// spoof returns the request body forwarded to the broker and the response acknowledging it, false if the request is acknowledged by the broker
+func (s *ackSpoofing) spoof(brokerAddress string, apiVersion int16, req []byte) ([]byte, []byte, bool) {
+	forward, resp, ok, err := protocol.SpoofProduceAcks(apiVersion, req, s.accepts)
+	if err != nil {
+		// the request is forwarded as it is, the broker answers it
+		logrus.Debugf("Produce request to %s cannot be acknowledged by the proxy: %v", brokerAddress, err)
+		return nil, nil, false
+	}
+	return forward, resp, ok
+}
+
+func (s *ackSpoofing) accepts(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	for _, re := range s.topics {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// newForwarder returns the forwarder of the requests acknowledged on the connection to the broker, nil if the spoofing is disabled
+func (s *ackSpoofing) newForwarder(dst DeadlineWriter, brokerAddress string, timeout time.Duration) *ackForwarder {
+	if s == nil {
+		return nil
+	}
+	f := &ackForwarder{
+		dst:           dst,
+		brokerAddress: brokerAddress,
+		timeout:       timeout,
+		queue:         make(chan []byte, s.queueSize),
+		stop:          make(chan struct{}),
+	}
+	go withRecover(f.run)
+	return f
+}
+
+// ackForwarder writes the acknowledged requests of the connection to the broker. The requests loop writes to the broker itself
+// only when the queued requests were written, so the requests are sent in their order and never by two goroutines at once.
+type ackForwarder struct {
+	dst           DeadlineWriter
+	brokerAddress string
+	timeout       time.Duration
+	queue         chan []byte
+	stop          chan struct{}
+
+	lock    sync.Mutex
+	pending int
+	// closed when the pending frames were written or lost
+	drained chan struct{}
+	// the write deadline of the requests loop is applied after the write of the forwarder
+	writing  bool
+	deadline time.Time
+
+	// used by the run goroutine only
+	failed bool
+}
+
+// enqueue queues the request frame, the frame is dropped if the queue is full
+func (f *ackForwarder) enqueue(frame []byte) {
+	f.lock.Lock()
+	if f.pending == 0 {
+		f.drained = make(chan struct{})
+	}
+	f.pending++
+	f.lock.Unlock()
+	select {
+	case f.queue <- frame:
+	default:
+		f.finished()
+		proxyAckSpoofingRequestsTotal.WithLabelValues(f.brokerAddress, ackSpoofingDropped).Inc()
+	}
+}
+
+func (f *ackForwarder) finished() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.pending--; f.pending == 0 {
+		close(f.drained)
+	}
+}
+
+// flush waits until the queued frames were written or lost. The connection to the broker is closed if they are not written
+// within the write timeout, the requests loop must not write to it while the forwarder is writing.
+func (f *ackForwarder) flush(done <-chan struct{}) error {
+	if f == nil {
+		return nil
+	}
+	f.lock.Lock()
+	pending, drained := f.pending, f.drained
+	f.lock.Unlock()
+	if pending == 0 {
+		return nil
+	}
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		return nil
+	case <-done:
+		return errProcessorStopped
+	case <-timer.C:
+		f.closeDst()
+		return fmt.Errorf("acknowledged produce requests were not forwarded to %s within %v", f.brokerAddress, f.timeout)
+	}
+}
+
+// writer returns the writer of the requests loop, its write deadline does not override the deadline of a write of the forwarder
+func (f *ackForwarder) writer(dst DeadlineWriter) DeadlineWriter {
+	if f == nil {
+		return dst
+	}
+	return &ackForwarderWriter{DeadlineWriter: dst, forwarder: f}
+}
+
+func (f *ackForwarder) close() {
+	if f == nil {
+		return
+	}
+	close(f.stop)
+}
+
+func (f *ackForwarder) closeDst() {
+	if closer, ok := f.dst.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func (f *ackForwarder) run() {
+	for {
+		select {
+		case frame := <-f.queue:
+			f.write(frame)
+		case <-f.stop:
+			for {
+				select {
+				case <-f.queue:
+					proxyAckSpoofingRequestsTotal.WithLabelValues(f.brokerAddress, ackSpoofingFailed).Inc()
+					f.finished()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (f *ackForwarder) write(frame []byte) {
+	defer f.finished()
+	if f.failed {
+		proxyAckSpoofingRequestsTotal.WithLabelValues(f.brokerAddress, ackSpoofingFailed).Inc()
+		return
+	}
+	if err := f.writeFrame(frame); err != nil {
+		f.failed = true
+		proxyAckSpoofingRequestsTotal.WithLabelValues(f.brokerAddress, ackSpoofingFailed).Inc()
+		logrus.Infof("Acknowledged produce request cannot be forwarded to %s: %v", f.brokerAddress, err)
+		// the producer reconnects and learns about the failure at least by the closed connection
+		f.closeDst()
+		return
+	}
+	proxyAckSpoofingRequestsTotal.WithLabelValues(f.brokerAddress, ackSpoofingForwarded).Inc()
+}
+
+func (f *ackForwarder) writeFrame(frame []byte) error {
+	f.lock.Lock()
+	f.writing = true
+	err := f.dst.SetWriteDeadline(time.Now().Add(f.timeout))
+	f.lock.Unlock()
+	if err == nil {
+		err = writeFrame(f.dst, frame)
+	}
+	f.lock.Lock()
+	f.writing = false
+	f.dst.SetWriteDeadline(f.deadline)
+	f.lock.Unlock()
+	return err
+}
+
+// ackForwarderWriter is the connection to the broker as written by the requests loop
+type ackForwarderWriter struct {
+	DeadlineWriter
+	forwarder *ackForwarder
+}
+
+func (w *ackForwarderWriter) SetWriteDeadline(t time.Time) error {
+	f := w.forwarder
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.deadline = t
+	if f.writing {
+		return nil
+	}
+	return w.DeadlineWriter.SetWriteDeadline(t)
+}
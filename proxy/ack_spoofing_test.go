@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitForBrokerRequests(broker *kafkatest.Broker, apiKey int16, count int) bool {
+	for i := 0; i < 100; i++ {
+		if broker.RequestCount(apiKey) == count {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestAckSpoofing(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	s, err := newAckSpoofing(c)
+	a.Nil(err)
+	a.Nil(s)
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}))
+	a.Nil(s.newForwarder(nil, "broker:9092", time.Second))
+
+	c.AckSpoofing.Enable = true
+	c.AckSpoofing.QueueSize = 10
+	c.AckSpoofing.Topics = []string{"^telemetry-"}
+	s, err = newAckSpoofing(c)
+	a.Nil(err)
+	a.True(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}))
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4}))
+
+	_, _, ok := s.spoof("broker:9092", 3, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")}))
+	a.False(ok)
+	forward, resp, ok := s.spoof("broker:9092", 3, kafkatest.ProduceRequestBody("telemetry-cpu", [][]byte{[]byte("v1")}))
+	a.True(ok)
+	a.NotNil(forward)
+	errorCode, err := kafkatest.DecodeProduceErrorCode(resp)
+	a.Nil(err)
+	a.Equal(int16(0), errorCode)
+	_, _, ok = s.spoof("broker:9092", 3, []byte{1, 2, 3})
+	a.False(ok)
+}
+
+// blockingWriter is a connection to the broker which ignores the write deadlines, the writes block until released or closed
+type blockingWriter struct {
+	release chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+
+	lock      sync.Mutex
+	deadlines []time.Time
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{release: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	select {
+	case <-w.release:
+		return len(b), nil
+	case <-w.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (w *blockingWriter) SetWriteDeadline(t time.Time) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.deadlines = append(w.deadlines, t)
+	return nil
+}
+
+func (w *blockingWriter) lastDeadline() time.Time {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.deadlines) == 0 {
+		return time.Time{}
+	}
+	return w.deadlines[len(w.deadlines)-1]
+}
+
+func (w *blockingWriter) Close() error {
+	w.once.Do(func() { close(w.closed) })
+	return nil
+}
+
+func TestAckForwarder(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.AckSpoofing.Enable = true
+	c.AckSpoofing.QueueSize = 1
+	s, err := newAckSpoofing(c)
+	a.Nil(err)
+
+	client, server := net.Pipe()
+	f := s.newForwarder(client, "ack-forwarder:9092", 100*time.Millisecond)
+	defer f.close()
+
+	forwarded := counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingForwarded)
+	dropped := counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingDropped)
+	failed := counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingFailed)
+
+	f.enqueue([]byte{1})
+	buf := make([]byte, 1)
+	_, err = server.Read(buf)
+	a.Nil(err)
+	a.Nil(f.flush(nil))
+	a.Equal(forwarded+1, counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingForwarded))
+
+	// the writes block as the pipe is not read
+	for i := 0; i < 3; i++ {
+		f.enqueue([]byte{2})
+	}
+	a.True(counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingDropped) >= dropped+1)
+
+	// the write times out and the connection is closed
+	for i := 0; i < 100 && counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingFailed) == failed; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.True(counterOf(a, proxyAckSpoofingRequestsTotal, "ack-forwarder:9092", ackSpoofingFailed) >= failed+1)
+	_, err = client.Write([]byte{3})
+	a.NotNil(err)
+	server.Close()
+}
+
+func TestAckForwarderFlushTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.AckSpoofing.Enable = true
+	c.AckSpoofing.QueueSize = 10
+	s, err := newAckSpoofing(c)
+	a.Nil(err)
+
+	dst := newBlockingWriter()
+	f := s.newForwarder(dst, "ack-forwarder:9092", 50*time.Millisecond)
+	defer f.close()
+
+	// the deadline of the requests loop is applied when the write of the forwarder is finished
+	f.enqueue([]byte{1})
+	for i := 0; i < 100 && dst.lastDeadline().IsZero(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	a.False(dst.lastDeadline().IsZero())
+	a.Nil(f.writer(dst).SetWriteDeadline(time.Time{}))
+	a.False(dst.lastDeadline().IsZero())
+	dst.release <- struct{}{}
+	a.Nil(f.flush(nil))
+	a.True(dst.lastDeadline().IsZero())
+
+	// the proxy is stopped while the write blocks
+	f.enqueue([]byte{2})
+	done := make(chan struct{})
+	close(done)
+	a.Equal(errProcessorStopped, f.flush(done))
+
+	// the write ignoring the deadline blocks longer than the timeout, the connection is closed
+	a.EqualError(f.flush(nil), "acknowledged produce requests were not forwarded to ack-forwarder:9092 within 50ms")
+	select {
+	case <-dst.closed:
+	default:
+		a.Fail("connection to the broker is not closed")
+	}
+	a.Nil(f.flush(nil))
+}
+
+func TestProxyWithAckSpoofing(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.AckSpoofing.Enable = true
+	c.AckSpoofing.QueueSize = 10
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	before := counterOf(a, proxyAckSpoofingRequestsTotal, broker.Addr(), ackSpoofingForwarded)
+	for i := int32(1); i <= 3; i++ {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, i, "test", kafkatest.ProduceRequestBody("test", [][]byte{[]byte("v1")})))
+		a.Nil(err)
+	}
+	for i := int32(1); i <= 3; i++ {
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(i, correlationID)
+		errorCode, err := kafkatest.DecodeProduceErrorCode(body)
+		a.Nil(err)
+		a.Equal(int16(0), errorCode)
+	}
+	// the metadata request is written after the acknowledged requests and answered by the broker
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 4, "test", kafkatest.MetadataRequestBody(1, []string{"test"})))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(4), correlationID)
+	a.True(waitForBrokerRequests(broker, kafkatest.ApiKeyProduce, 3))
+	a.Equal(before+3, counterOf(a, proxyAckSpoofingRequestsTotal, broker.Addr(), ackSpoofingForwarded))
+}
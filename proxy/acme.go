@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	acmeAccountKeyFile  = "acme-account.key"
+	acmeCertificateFile = "acme-certificate.pem"
+	acmeKeyFile         = "acme-certificate.key"
+
+	acmeChallengePath = "/.well-known/acme-challenge/"
+	// how often the expiry of the certificate is checked and how long to wait after a failed order
+	acmeCheckInterval = 12 * time.Hour
+	acmeRetryInterval = time.Minute
+)
+
+// acmeManager obtains the certificate of the listeners without cert file from an ACME CA and renews it before it expires.
+// The account key and the certificate are kept in the cache directory, so the certificate is not ordered again on restart.
+type acmeManager struct {
+	client            *acmeClient
+	hosts             []string
+	challenge         string
+	httpListenAddress string
+	dnsHookCommand    string
+	cacheDir          string
+	renewBefore       time.Duration
+	timeout           time.Duration
+	now               func() time.Time
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+
+	// key authorizations of the pending http-01 challenges by token
+	tokensLock sync.Mutex
+	tokens     map[string]string
+}
+
+// newACMEManager returns nil if ACME is disabled, the cached certificate is loaded if it covers the hosts
+func newACMEManager(c *config.Config) (*acmeManager, error) {
+	opts := c.Proxy.TLS.ACME
+	if !opts.Enable {
+		return nil, nil
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "cannot create ACME cache directory")
+	}
+	key, err := loadOrCreateECKey(filepath.Join(opts.CacheDir, acmeAccountKeyFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load ACME account key")
+	}
+	m := &acmeManager{
+		client: &acmeClient{
+			directoryURL: opts.DirectoryURL,
+			email:        opts.Email,
+			key:          key,
+			httpClient:   &http.Client{Timeout: 30 * time.Second},
+			pollInterval: 2 * time.Second,
+		},
+		hosts:             c.ACMEHosts(),
+		challenge:         opts.Challenge,
+		httpListenAddress: opts.HTTPListenAddress,
+		dnsHookCommand:    opts.DNSHookCommand,
+		cacheDir:          opts.CacheDir,
+		renewBefore:       opts.RenewBefore,
+		timeout:           opts.Timeout,
+		now:               time.Now,
+		tokens:            make(map[string]string),
+	}
+	if cert, err := tls.LoadX509KeyPair(filepath.Join(opts.CacheDir, acmeCertificateFile), filepath.Join(opts.CacheDir, acmeKeyFile)); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && coversHosts(leaf, m.hosts) {
+			cert.Leaf = leaf
+			m.setCertificate(&cert)
+			logrus.Infof("Loaded ACME certificate of %v valid until %v", m.hosts, leaf.NotAfter)
+		}
+	}
+	logrus.Infof("Certificate of the listeners for %v will be obtained from %s with the %s challenge", m.hosts, opts.DirectoryURL, opts.Challenge)
+	return m, nil
+}
+
+// setGetCertificate serves the ACME certificate on the listeners without own certificate
+func (m *acmeManager) setGetCertificate(tlsConfigs []*tls.Config) {
+	if m == nil {
+		return
+	}
+	for _, tlsConfig := range tlsConfigs {
+		if len(tlsConfig.Certificates) == 0 {
+			tlsConfig.GetCertificate = m.getCertificate
+		}
+	}
+}
+
+func (m *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil {
+		return nil, errors.Errorf("ACME certificate of %v is not obtained yet", m.hosts)
+	}
+	return m.cert, nil
+}
+
+func (m *acmeManager) setCertificate(cert *tls.Certificate) {
+	m.lock.Lock()
+	m.cert = cert
+	m.lock.Unlock()
+	proxyACMECertificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+}
+
+// run serves the http-01 challenges and renews the certificate until done is closed
+func (m *acmeManager) run(done <-chan struct{}) {
+	if m == nil {
+		return
+	}
+	if m.challenge == config.ACMEChallengeHTTP {
+		l, err := net.Listen("tcp", m.httpListenAddress)
+		if err != nil {
+			logrus.Errorf("ACME http-01 challenges cannot be served on %s: %v", m.httpListenAddress, err)
+		} else {
+			defer l.Close()
+			go withRecover(func() { http.Serve(l, http.HandlerFunc(m.serveHTTPChallenge)) })
+		}
+	}
+	for {
+		wait := m.renew()
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return
+		}
+	}
+}
+
+// renew orders a new certificate if there is none or it expires soon, it returns the duration until the next check
+func (m *acmeManager) renew() time.Duration {
+	if wait := m.untilRenewal(); wait > 0 {
+		return wait
+	}
+	logrus.Infof("Ordering ACME certificate of %v", m.hosts)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	cert, err := m.obtain(ctx)
+	if err != nil {
+		logrus.Errorf("Ordering ACME certificate of %v failed: %v", m.hosts, err)
+		proxyACMEErrorsTotal.Inc()
+		return acmeRetryInterval
+	}
+	m.setCertificate(cert)
+	logrus.Infof("Obtained ACME certificate of %v valid until %v", m.hosts, cert.Leaf.NotAfter)
+	return m.untilRenewal()
+}
+
+// untilRenewal returns the time until the certificate must be renewed, at most the check interval
+func (m *acmeManager) untilRenewal() time.Duration {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil {
+		return 0
+	}
+	wait := m.cert.Leaf.NotAfter.Add(-m.renewBefore).Sub(m.now())
+	if wait > acmeCheckInterval {
+		return acmeCheckInterval
+	}
+	return wait
+}
+
+// obtain orders the certificate, completes the challenges of the hosts and saves the issued certificate in the cache
+func (m *acmeManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	if err := m.client.register(ctx); err != nil {
+		return nil, err
+	}
+	order, err := m.client.newOrder(ctx, m.hosts)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range order.Authorizations {
+		if err = m.authorize(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: m.hosts[0]}, DNSNames: m.hosts}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := m.client.finalize(ctx, order, csr)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ACME certificate")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(filepath.Join(m.cacheDir, acmeKeyFile), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(filepath.Join(m.cacheDir, acmeCertificateFile), chain, 0600); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// authorize completes the challenge of the authorization
+func (m *acmeManager) authorize(ctx context.Context, url string) error {
+	authorization, err := m.client.authorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authorization.Status == acmeStatusValid {
+		return nil
+	}
+	var challenge *acmeChallenge
+	for i := range authorization.Challenges {
+		if authorization.Challenges[i].Type == m.challenge {
+			challenge = &authorization.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return errors.Errorf("ACME CA offers no %s challenge for %s", m.challenge, authorization.Identifier.Value)
+	}
+	keyAuthorization := m.client.keyAuthorization(challenge.Token)
+	if m.challenge == config.ACMEChallengeDNS {
+		// the TXT record of a wildcard is the record of its base domain
+		name := "_acme-challenge." + strings.TrimPrefix(authorization.Identifier.Value, "*.")
+		digest := sha256.Sum256([]byte(keyAuthorization))
+		value := acmeEncode(digest[:])
+		if err = m.runDNSHook(ctx, "present", name, value); err != nil {
+			return err
+		}
+		defer func() {
+			if err := m.runDNSHook(context.Background(), "cleanup", name, value); err != nil {
+				logrus.Warnf("Cleanup of ACME TXT record %s failed: %v", name, err)
+			}
+		}()
+	} else {
+		m.tokensLock.Lock()
+		m.tokens[challenge.Token] = keyAuthorization
+		m.tokensLock.Unlock()
+		defer func() {
+			m.tokensLock.Lock()
+			delete(m.tokens, challenge.Token)
+			m.tokensLock.Unlock()
+		}()
+	}
+	if err = m.client.accept(ctx, *challenge); err != nil {
+		return err
+	}
+	return m.client.waitAuthorization(ctx, url)
+}
+
+func (m *acmeManager) runDNSHook(ctx context.Context, action, name, value string) error {
+	output, err := exec.CommandContext(ctx, m.dnsHookCommand, action, name, value).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "ACME DNS hook %s %s failed: %s", action, name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// serveHTTPChallenge responds to the http-01 challenges with the key authorization of the token
+func (m *acmeManager) serveHTTPChallenge(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		http.NotFound(w, r)
+		return
+	}
+	m.tokensLock.Lock()
+	keyAuthorization, ok := m.tokens[strings.TrimPrefix(r.URL.Path, acmeChallengePath)]
+	m.tokensLock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuthorization))
+}
+
+// coversHosts reports whether the certificate is issued for all hosts
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		found := false
+		for _, name := range cert.DNSNames {
+			found = found || name == host
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// loadOrCreateECKey loads the PEM encoded P-256 key or creates the key file
+func loadOrCreateECKey(filename string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("no PEM key in %s", filename)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,359 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// status of the ACME orders, authorizations and challenges
+	acmeStatusPending = "pending"
+	acmeStatusValid   = "valid"
+	acmeStatusInvalid = "invalid"
+
+	acmeBadNonce = "urn:ietf:params:acme:error:badNonce"
+)
+
+// acmeClient implements the subset of the ACME protocol (RFC 8555) needed to order the certificate of the listeners.
+// The requests are signed with the ECDSA P-256 account key.
+type acmeClient struct {
+	directoryURL string
+	email        string
+	key          *ecdsa.PrivateKey
+	httpClient   *http.Client
+	pollInterval time.Duration
+
+	directory *acmeDirectory
+	// account URL, the key id of the signed requests
+	kid    string
+	nonces []string
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	URL            string           `json:"-"`
+	Status         string           `json:"status"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate"`
+	Error          *acmeProblem     `json:"error"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Wildcard   bool            `json:"wildcard"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type   string       `json:"type"`
+	URL    string       `json:"url"`
+	Token  string       `json:"token"`
+	Status string       `json:"status"`
+	Error  *acmeProblem `json:"error"`
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// acmeJWK is the public account key with the members in the order of the JWK thumbprint (RFC 7638)
+type acmeJWK struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newACMEJWK(key *ecdsa.PublicKey) acmeJWK {
+	return acmeJWK{Crv: "P-256", Kty: "EC", X: acmeEncode(paddedBytes(key.X.Bytes(), 32)), Y: acmeEncode(paddedBytes(key.Y.Bytes(), 32))}
+}
+
+func acmeEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func paddedBytes(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
+
+// keyAuthorization returns the key authorization of the challenge token
+func (c *acmeClient) keyAuthorization(token string) string {
+	jwk, _ := json.Marshal(newACMEJWK(&c.key.PublicKey))
+	thumbprint := sha256.Sum256(jwk)
+	return token + "." + acmeEncode(thumbprint[:])
+}
+
+// register creates the account of the key or looks up the existing one
+func (c *acmeClient) register(ctx context.Context) error {
+	if c.directory == nil {
+		directory := &acmeDirectory{}
+		if err := c.get(ctx, c.directoryURL, directory); err != nil {
+			return errors.Wrap(err, "cannot get ACME directory")
+		}
+		c.directory = directory
+	}
+	if c.kid != "" {
+		return nil
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if c.email != "" {
+		account["contact"] = []string{"mailto:" + c.email}
+	}
+	resp, err := c.post(ctx, c.directory.NewAccount, account, nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot register ACME account")
+	}
+	c.kid = resp.header.Get("Location")
+	if c.kid == "" {
+		return errors.New("ACME account URL is missing")
+	}
+	return nil
+}
+
+// newOrder orders the certificate of the hosts
+func (c *acmeClient) newOrder(ctx context.Context, hosts []string) (*acmeOrder, error) {
+	identifiers := make([]acmeIdentifier, 0, len(hosts))
+	for _, host := range hosts {
+		identifiers = append(identifiers, acmeIdentifier{Type: "dns", Value: host})
+	}
+	order := &acmeOrder{}
+	resp, err := c.post(ctx, c.directory.NewOrder, map[string]interface{}{"identifiers": identifiers}, order)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create ACME order")
+	}
+	order.URL = resp.header.Get("Location")
+	return order, nil
+}
+
+func (c *acmeClient) authorization(ctx context.Context, url string) (*acmeAuthorization, error) {
+	authorization := &acmeAuthorization{}
+	if _, err := c.post(ctx, url, nil, authorization); err != nil {
+		return nil, err
+	}
+	return authorization, nil
+}
+
+// accept tells the CA that the response of the challenge is ready to be validated
+func (c *acmeClient) accept(ctx context.Context, challenge acmeChallenge) error {
+	_, err := c.post(ctx, challenge.URL, struct{}{}, nil)
+	return err
+}
+
+// waitAuthorization polls the authorization until it is valid or invalid
+func (c *acmeClient) waitAuthorization(ctx context.Context, url string) error {
+	for {
+		authorization, err := c.authorization(ctx, url)
+		if err != nil {
+			return err
+		}
+		switch authorization.Status {
+		case acmeStatusValid:
+			return nil
+		case acmeStatusPending, "processing":
+		default:
+			for _, challenge := range authorization.Challenges {
+				if challenge.Error != nil {
+					return errors.Errorf("authorization of %s is %s: %v", authorization.Identifier.Value, authorization.Status, challenge.Error)
+				}
+			}
+			return errors.Errorf("authorization of %s is %s", authorization.Identifier.Value, authorization.Status)
+		}
+		if err = c.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// finalize submits the CSR and returns the PEM encoded certificate chain
+func (c *acmeClient) finalize(ctx context.Context, order *acmeOrder, csr []byte) ([]byte, error) {
+	if _, err := c.post(ctx, order.Finalize, map[string]string{"csr": acmeEncode(csr)}, order); err != nil {
+		return nil, errors.Wrap(err, "cannot finalize ACME order")
+	}
+	for order.Status != acmeStatusValid {
+		if order.Status == acmeStatusInvalid {
+			if order.Error != nil {
+				return nil, errors.Wrap(order.Error, "ACME order is invalid")
+			}
+			return nil, errors.New("ACME order is invalid")
+		}
+		if err := c.sleep(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := c.post(ctx, order.URL, nil, order); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.post(ctx, order.Certificate, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot download ACME certificate")
+	}
+	return resp.body, nil
+}
+
+func (c *acmeClient) sleep(ctx context.Context) error {
+	select {
+	case <-time.After(c.pollInterval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type acmeResponse struct {
+	header http.Header
+	body   []byte
+}
+
+func (c *acmeClient) get(ctx context.Context, url string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.body, result)
+}
+
+// post sends the signed payload, a nil payload is a POST-as-GET request. A rejected nonce is retried once.
+func (c *acmeClient) post(ctx context.Context, url string, payload interface{}, result interface{}) (*acmeResponse, error) {
+	resp, err := c.postOnce(ctx, url, payload)
+	if problem, ok := err.(*acmeProblem); ok && problem.Type == acmeBadNonce {
+		resp, err = c.postOnce(ctx, url, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		if err = json.Unmarshal(resp.body, result); err != nil {
+			return nil, errors.Wrapf(err, "invalid ACME response of %s", url)
+		}
+	}
+	return resp, nil
+}
+
+func (c *acmeClient) postOnce(ctx context.Context, url string, payload interface{}) (*acmeResponse, error) {
+	body, err := c.sign(ctx, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	return c.do(ctx, req)
+}
+
+// sign returns the JWS of the payload, the account key is embedded until the account is registered
+func (c *acmeClient) sign(ctx context.Context, url string, payload interface{}) ([]byte, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = newACMEJWK(&c.key.PublicKey)
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	encodedPayload := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = acmeEncode(data)
+	}
+	signingInput := acmeEncode(header) + "." + encodedPayload
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := append(paddedBytes(r.Bytes(), 32), paddedBytes(s.Bytes(), 32)...)
+	return json.Marshal(map[string]string{"protected": acmeEncode(header), "payload": encodedPayload, "signature": acmeEncode(signature)})
+}
+
+func (c *acmeClient) nonce(ctx context.Context) (string, error) {
+	if n := len(c.nonces); n != 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		return nonce, nil
+	}
+	req, err := http.NewRequest(http.MethodHead, c.directory.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot get ACME nonce")
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("ACME nonce is missing")
+	}
+	return nonce, nil
+}
+
+// do sends the request and keeps the returned nonce, the problem documents of the CA are returned as *acmeProblem
+func (c *acmeClient) do(ctx context.Context, req *http.Request) (*acmeResponse, error) {
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.nonces = append(c.nonces, nonce)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		problem := &acmeProblem{}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") && json.Unmarshal(body, problem) == nil && problem.Type != "" {
+			return nil, problem
+		}
+		return nil, errors.Errorf("%s %s returned status %d", req.Method, req.URL, resp.StatusCode)
+	}
+	return &acmeResponse{header: resp.Header, body: body}, nil
+}
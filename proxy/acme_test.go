@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACMEServer issues the certificates after it has validated the challenges, the http-01 responses are fetched
+// from challengeURL and the dns-01 TXT records are read from the file written by the DNS hook
+type fakeACMEServer struct {
+	a            *assert.Assertions
+	server       *httptest.Server
+	challengeURL string
+	dnsRecords   string
+	validity     time.Duration
+
+	lock        sync.Mutex
+	nonce       int
+	nonces      map[string]bool
+	accountKey  *ecdsa.PublicKey
+	identifiers []acmeIdentifier
+	valid       map[int]bool
+	chain       []byte
+	orders      int
+}
+
+func newFakeACMEServer(a *assert.Assertions) *fakeACMEServer {
+	s := &fakeACMEServer{a: a, validity: 90 * 24 * time.Hour, nonces: make(map[string]bool), valid: make(map[int]bool)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeACMEServer) newNonce(w http.ResponseWriter) {
+	s.nonce++
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+	s.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (s *fakeACMEServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.newNonce(w)
+	switch {
+	case r.URL.Path == "/directory":
+		json.NewEncoder(w).Encode(acmeDirectory{NewNonce: s.server.URL + "/nonce", NewAccount: s.server.URL + "/account", NewOrder: s.server.URL + "/order"})
+		return
+	case r.URL.Path == "/nonce":
+		return
+	}
+	payload, ok := s.verify(r)
+	if !ok {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"urn:ietf:params:acme:error:malformed","detail":"invalid JWS"}`))
+		return
+	}
+	switch {
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", s.server.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case r.URL.Path == "/order":
+		var request struct {
+			Identifiers []acmeIdentifier `json:"identifiers"`
+		}
+		s.a.Nil(json.Unmarshal(payload, &request))
+		s.identifiers = request.Identifiers
+		s.valid = make(map[int]bool)
+		s.chain = nil
+		s.orders++
+		w.Header().Set("Location", s.server.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s.order())
+	case r.URL.Path == "/order/1":
+		json.NewEncoder(w).Encode(s.order())
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		var i int
+		fmt.Sscanf(r.URL.Path, "/authz/%d", &i)
+		json.NewEncoder(w).Encode(s.authorization(i))
+	case strings.HasPrefix(r.URL.Path, "/challenge/"):
+		var i int
+		var challengeType string
+		fmt.Sscanf(strings.Replace(r.URL.Path, "/", " ", -1), " challenge %d %s", &i, &challengeType)
+		s.valid[i] = s.validate(i, challengeType)
+		json.NewEncoder(w).Encode(acmeChallenge{Type: challengeType, Status: acmeStatusPending})
+	case r.URL.Path == "/finalize":
+		var request map[string]string
+		s.a.Nil(json.Unmarshal(payload, &request))
+		der, err := base64.RawURLEncoding.DecodeString(request["csr"])
+		s.a.Nil(err)
+		s.chain = s.issue(der)
+		json.NewEncoder(w).Encode(s.order())
+	case r.URL.Path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verify checks the nonce and the signature of the JWS and returns its payload
+func (s *fakeACMEServer) verify(r *http.Request) ([]byte, bool) {
+	var jws map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, false
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws["protected"])
+	var protected struct {
+		Alg   string   `json:"alg"`
+		Nonce string   `json:"nonce"`
+		URL   string   `json:"url"`
+		JWK   *acmeJWK `json:"jwk"`
+		Kid   string   `json:"kid"`
+	}
+	if json.Unmarshal(header, &protected) != nil || protected.Alg != "ES256" || !s.nonces[protected.Nonce] || protected.URL != s.server.URL+r.URL.Path {
+		return nil, false
+	}
+	delete(s.nonces, protected.Nonce)
+	key := s.accountKey
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		s.accountKey = key
+	} else if protected.Kid != s.server.URL+"/account/1" {
+		return nil, false
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(jws["signature"])
+	digest := sha256.Sum256([]byte(jws["protected"] + "." + jws["payload"]))
+	if key == nil || len(signature) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws["payload"])
+	return payload, true
+}
+
+func (s *fakeACMEServer) order() acmeOrder {
+	order := acmeOrder{Status: acmeStatusPending, Identifiers: s.identifiers, Finalize: s.server.URL + "/finalize"}
+	for i := range s.identifiers {
+		order.Authorizations = append(order.Authorizations, fmt.Sprintf("%s/authz/%d", s.server.URL, i))
+	}
+	if len(s.valid) == len(s.identifiers) {
+		order.Status = "ready"
+	}
+	if s.chain != nil {
+		order.Status = acmeStatusValid
+		order.Certificate = s.server.URL + "/cert"
+	}
+	return order
+}
+
+func (s *fakeACMEServer) authorization(i int) acmeAuthorization {
+	status := acmeStatusPending
+	if valid, ok := s.valid[i]; ok {
+		status = acmeStatusInvalid
+		if valid {
+			status = acmeStatusValid
+		}
+	}
+	challenges := make([]acmeChallenge, 0)
+	for _, challengeType := range []string{config.ACMEChallengeHTTP, config.ACMEChallengeDNS} {
+		challenges = append(challenges, acmeChallenge{Type: challengeType, URL: fmt.Sprintf("%s/challenge/%d/%s", s.server.URL, i, challengeType), Token: fmt.Sprintf("token-%d", i)})
+	}
+	// the identifier of a wildcard is its base domain
+	identifier := acmeIdentifier{Type: "dns", Value: strings.TrimPrefix(s.identifiers[i].Value, "*.")}
+	return acmeAuthorization{Status: status, Identifier: identifier, Wildcard: identifier.Value != s.identifiers[i].Value, Challenges: challenges}
+}
+
+func (s *fakeACMEServer) validate(i int, challengeType string) bool {
+	jwk, _ := json.Marshal(newACMEJWK(s.accountKey))
+	thumbprint := sha256.Sum256(jwk)
+	keyAuthorization := fmt.Sprintf("token-%d.%s", i, base64.RawURLEncoding.EncodeToString(thumbprint[:]))
+	if challengeType == config.ACMEChallengeDNS {
+		records, _ := ioutil.ReadFile(s.dnsRecords)
+		digest := sha256.Sum256([]byte(keyAuthorization))
+		return strings.Contains(string(records), fmt.Sprintf("present _acme-challenge.%s %s\n", strings.TrimPrefix(s.identifiers[i].Value, "*."), base64.RawURLEncoding.EncodeToString(digest[:])))
+	}
+	resp, err := http.Get(s.challengeURL + acmeChallengePath + fmt.Sprintf("token-%d", i))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body) == keyAuthorization
+}
+
+func (s *fakeACMEServer) issue(der []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(der)
+	s.a.Nil(err)
+	s.a.Nil(csr.CheckSignature())
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.a.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(s.validity),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+	s.a.Nil(err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+func newTestACMEConfig(a *assert.Assertions, directoryURL string) *config.Config {
+	cacheDir, err := ioutil.TempDir("", "acme")
+	a.Nil(err)
+	c := config.NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"kafka-1.internal:9092,127.0.0.1:0,kafka-1.example.com:32400"}))
+	c.Proxy.DisableDynamicListeners = true
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ACME.Enable = true
+	c.Proxy.TLS.ACME.DirectoryURL = directoryURL
+	c.Proxy.TLS.ACME.CacheDir = cacheDir
+	a.Nil(c.Validate())
+	return c
+}
+
+func TestACMEManagerObtainsCertificateWithHTTPChallenge(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newFakeACMEServer(a)
+	defer ca.server.Close()
+	c := newTestACMEConfig(a, ca.server.URL+"/directory")
+	defer os.RemoveAll(c.Proxy.TLS.ACME.CacheDir)
+
+	m, err := newACMEManager(c)
+	a.Nil(err)
+	m.client.pollInterval = 10 * time.Millisecond
+	challenges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { m.serveHTTPChallenge(w, r) }))
+	defer challenges.Close()
+	ca.challengeURL = challenges.URL
+
+	tlsConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	m.setGetCertificate([]*tls.Config{tlsConfig})
+	_, err = tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "kafka-1.example.com"})
+	a.NotNil(err)
+
+	wait := m.renew()
+	a.True(wait > 0 && wait <= acmeCheckInterval)
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "kafka-1.example.com"})
+	a.Nil(err)
+	a.Equal([]string{"kafka-1.example.com"}, cert.Leaf.DNSNames)
+	a.Equal(1, ca.orders)
+	// the certificate is not ordered again before it has to be renewed
+	m.renew()
+	a.Equal(1, ca.orders)
+
+	// the cached account key and certificate are loaded on restart
+	m, err = newACMEManager(c)
+	a.Nil(err)
+	cached, err := m.getCertificate(&tls.ClientHelloInfo{})
+	a.Nil(err)
+	a.Equal(cert.Certificate, cached.Certificate)
+	a.Equal(ca.accountKey, &m.client.key.PublicKey)
+
+	// the certificate is renewed when it expires within RenewBefore
+	m.now = func() time.Time { return time.Now().Add(80 * 24 * time.Hour) }
+	m.client.pollInterval = 10 * time.Millisecond
+	m.renew()
+	a.Equal(2, ca.orders)
+	renewed, err := m.getCertificate(&tls.ClientHelloInfo{})
+	a.Nil(err)
+	a.NotEqual(cert.Certificate, renewed.Certificate)
+}
+
+func TestACMEManagerObtainsCertificateWithDNSChallenge(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newFakeACMEServer(a)
+	defer ca.server.Close()
+	c := newTestACMEConfig(a, ca.server.URL+"/directory")
+	defer os.RemoveAll(c.Proxy.TLS.ACME.CacheDir)
+	c.Proxy.TLS.ACME.Challenge = config.ACMEChallengeDNS
+	c.Proxy.TLS.ACME.Hosts = []string{"kafka.example.com", "*.kafka.example.com"}
+	ca.dnsRecords = filepath.Join(c.Proxy.TLS.ACME.CacheDir, "records")
+	c.Proxy.TLS.ACME.DNSHookCommand = filepath.Join(c.Proxy.TLS.ACME.CacheDir, "hook.sh")
+	a.Nil(ioutil.WriteFile(c.Proxy.TLS.ACME.DNSHookCommand, []byte("#!/bin/sh\necho \"$@\" >> "+ca.dnsRecords+"\n"), 0700))
+	a.Nil(c.Validate())
+
+	m, err := newACMEManager(c)
+	a.Nil(err)
+	m.client.pollInterval = 10 * time.Millisecond
+	m.renew()
+	cert, err := m.getCertificate(&tls.ClientHelloInfo{})
+	a.Nil(err)
+	a.Equal([]string{"kafka.example.com", "*.kafka.example.com"}, cert.Leaf.DNSNames)
+
+	records, err := ioutil.ReadFile(ca.dnsRecords)
+	a.Nil(err)
+	// both records of the base domain are cleaned up
+	a.Equal(2, strings.Count(string(records), "cleanup _acme-challenge.kafka.example.com "))
+}
+
+func TestACMEManagerRetriesFailedOrders(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newFakeACMEServer(a)
+	defer ca.server.Close()
+	c := newTestACMEConfig(a, ca.server.URL+"/directory")
+	defer os.RemoveAll(c.Proxy.TLS.ACME.CacheDir)
+
+	m, err := newACMEManager(c)
+	a.Nil(err)
+	m.client.pollInterval = 10 * time.Millisecond
+	// the challenge response is not reachable
+	ca.challengeURL = "http://127.0.0.1:1"
+	a.Equal(acmeRetryInterval, m.renew())
+	_, err = m.getCertificate(&tls.ClientHelloInfo{})
+	a.NotNil(err)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+type apiVersionRange struct {
+	minVersion int16
+	maxVersion int16
+}
+
+// forbiddenApiVersions maps the api key to the forbidden version ranges
+type forbiddenApiVersions map[int16][]apiVersionRange
+
+func newForbiddenApiVersions(values []string) (forbiddenApiVersions, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	result := make(forbiddenApiVersions)
+	for _, v := range values {
+		apiKey, minVersion, maxVersion, err := config.ParseForbiddenApiVersions(v)
+		if err != nil {
+			return nil, err
+		}
+		result[apiKey] = append(result[apiKey], apiVersionRange{minVersion: minVersion, maxVersion: maxVersion})
+	}
+	return result, nil
+}
+
+func (f forbiddenApiVersions) isForbidden(apiKey int16, apiVersion int16) bool {
+	for _, r := range f[apiKey] {
+		if apiVersion >= r.minVersion && apiVersion <= r.maxVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// newApiVersionFilter returns the filter of the versions which are forbidden and not advertised to the clients,
+// nil if all versions are allowed
+func newApiVersionFilter(forbidden forbiddenApiVersions, rewriter *rewriter, schemaValidator *schemaValidator, recordTransform *recordTransform, compressionPolicy *compressionPolicy, transactionPolicy *transactionPolicy, opa *opaAuthorizer, telemetry *clientTelemetry) protocol.ApiVersionFilterFunc {
+	if len(forbidden) == 0 && rewriter == nil && schemaValidator == nil && recordTransform == nil && compressionPolicy == nil && transactionPolicy == nil && opa == nil && telemetry == nil {
+		return nil
+	}
+	return func(apiKey int16, apiVersion int16) bool {
+		return forbidden.isForbidden(apiKey, apiVersion) || rewriter.isForbidden(apiKey, apiVersion) ||
+			schemaValidator.isForbidden(apiKey, apiVersion) || recordTransform.isForbidden(apiKey, apiVersion) ||
+			compressionPolicy.isForbidden(apiKey, apiVersion) || transactionPolicy.isForbidden(apiKey, apiVersion) ||
+			opa.isForbidden(apiKey, apiVersion) || telemetry.isForbidden(apiKey, apiVersion)
+	}
+}
+
+// answerForbiddenVersion answers the request of a forbidden version with UNSUPPORTED_VERSION, the request is not sent to the broker
+// and the connection stays open. The connection is closed if the response of the version has no error code known to the proxy.
+// With the rejection policy, the message of the policy is sent and the request is counted as rejected.
+func (ctx *RequestsLoopContext) answerForbiddenVersion(src DeadlineReaderWriter, requestKeyVersion *protocol.RequestKeyVersion, forbiddenErr error) (readErr bool, err error) {
+	if ctx.rejection != nil {
+		defer func() {
+			action := rejectionActionAnswer
+			if err != nil {
+				action = rejectionActionClose
+			}
+			proxyRejectionsTotal.WithLabelValues(config.RejectionPolicy, action).Inc()
+		}()
+	}
+	message := ctx.rejection.messageOf(forbiddenErr)
+	if err = src.SetReadDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return true, err
+	}
+	headerBuf, err := ctx.readRequestHeader(src, requestKeyVersion)
+	if err != nil {
+		return true, err
+	}
+	// request header v0 has no correlation id
+	if len(headerBuf) < 4 {
+		return true, forbiddenErr
+	}
+	bodyLength := int64(requestKeyVersion.Length-4) - int64(len(headerBuf))
+	var resp []byte
+	if requestKeyVersion.ApiKey == apiKeyProduce {
+		// the errors of the partitions
+		if requestKeyVersion.Length > protocol.MaxRequestSize {
+			return true, forbiddenErr
+		}
+		req := make([]byte, bodyLength)
+		if _, err = io.ReadFull(src, req); err != nil {
+			return true, err
+		}
+		// acks 0 is not answered
+		if resp, err = protocol.RejectProduce(requestKeyVersion.ApiVersion, req, protocol.ErrUnsupportedVersion, message); err != nil || resp == nil {
+			return true, forbiddenErr
+		}
+	} else {
+		var ok bool
+		if resp, ok = protocol.EncodeErrorResponseWithMessage(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, protocol.ErrUnsupportedVersion, message); !ok {
+			return true, forbiddenErr
+		}
+		if _, err = io.CopyN(ioutil.Discard, src, bodyLength); err != nil {
+			return true, err
+		}
+	}
+	ctx.inFlight.add(1)
+	if err = ctx.writeLocalResponse(src, headerBuf, resp, 1); err != nil {
+		return false, err
+	}
+	ctx.inFlight.add(-1)
+	logrus.Debugf("Request of %v answered with UNSUPPORTED_VERSION: %v", ctx.brokerAddress, forbiddenErr)
+	return false, ctx.putNextRequestHandler(defaultRequestHandler)
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// apiVersionsCache answers the ApiVersions requests of the new connections with the response of the upstream cluster sent to the
+// clients before, so the clients do not wait for a broker round trip over a high latency link. The responses are cached for the TTL
+// by the cluster and the api version.
+type apiVersionsCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	lock    sync.Mutex
+	entries map[apiVersionsCacheKey]*cachedResponse
+}
+
+type apiVersionsCacheKey struct {
+	cluster    string
+	apiVersion int16
+}
+
+// newApiVersionsCache returns nil if the ApiVersions responses are not cached
+func newApiVersionsCache(c *config.Config) *apiVersionsCache {
+	if c.ApiVersionsCache.TTL == 0 {
+		return nil
+	}
+	logrus.Infof("ApiVersions responses will be cached for %v", c.ApiVersionsCache.TTL)
+	return &apiVersionsCache{
+		ttl:     c.ApiVersionsCache.TTL,
+		now:     time.Now,
+		entries: make(map[apiVersionsCacheKey]*cachedResponse),
+	}
+}
+
+// newSession returns the session of a client connection to the cluster
+func (a *apiVersionsCache) newSession(cluster string) *apiVersionsCacheSession {
+	if a == nil {
+		return nil
+	}
+	return &apiVersionsCacheSession{cache: a, cluster: cluster}
+}
+
+// apiVersionsCacheSession looks up and stores the ApiVersions responses of the cluster of a client connection
+type apiVersionsCacheSession struct {
+	cache   *apiVersionsCache
+	cluster string
+}
+
+// inspects reports whether the request body must be read to be answered from the cache
+func (s *apiVersionsCacheSession) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s != nil && requestKeyVersion.ApiKey == apiKeyApiApiVersions
+}
+
+// lookup returns the cached response, nil if the response of the broker is awaited
+func (s *apiVersionsCacheSession) lookup(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte) []byte {
+	// request header v0 has no correlation id
+	if len(headerBuf) < 4 {
+		return nil
+	}
+	s.cache.lock.Lock()
+	entry, ok := s.cache.entries[apiVersionsCacheKey{cluster: s.cluster, apiVersion: requestKeyVersion.ApiVersion}]
+	s.cache.lock.Unlock()
+	if !ok || !s.cache.now().Before(entry.expires) {
+		proxyApiVersionsCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil
+	}
+	proxyApiVersionsCacheRequestsTotal.WithLabelValues("hit").Inc()
+	return entry.resp
+}
+
+// inspectsResponse reports whether the response body must be read to be cached
+func (s *apiVersionsCacheSession) inspectsResponse(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s.inspects(requestKeyVersion)
+}
+
+// store caches the response as sent to the client, the responses with an error e.g. of an unsupported version are not cached
+func (s *apiVersionsCacheSession) store(apiVersion int16, resp []byte) {
+	// ErrorCode is the first field of all versions
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != 0 {
+		return
+	}
+	entry := &cachedResponse{resp: append([]byte(nil), resp...), expires: s.cache.now().Add(s.cache.ttl)}
+	s.cache.lock.Lock()
+	s.cache.entries[apiVersionsCacheKey{cluster: s.cluster, apiVersion: apiVersion}] = entry
+	s.cache.lock.Unlock()
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestApiVersionsCache(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newApiVersionsCache(c))
+	var disabled *apiVersionsCache
+	s := disabled.newSession("primary")
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions}))
+
+	c.ApiVersionsCache.TTL = time.Minute
+	cache := newApiVersionsCache(c)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	requestKeyVersion := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions, ApiVersion: 1}
+	// no error, one api key and throttle time
+	resp := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0}
+	s = cache.newSession("primary")
+	a.True(s.inspects(requestKeyVersion))
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata}))
+	a.Nil(s.lookup(requestKeyVersion, correlationHeader(1)))
+	s.store(1, resp)
+	a.Equal(resp, s.lookup(requestKeyVersion, correlationHeader(2)))
+	a.Nil(s.lookup(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions, ApiVersion: 0}, correlationHeader(3)))
+	a.Nil(cache.newSession("secondary").lookup(requestKeyVersion, correlationHeader(4)))
+
+	// unsupported version
+	s.store(3, []byte{0, 35, 0, 0, 0, 0})
+	a.Nil(s.lookup(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions, ApiVersion: 3}, correlationHeader(5)))
+
+	now = now.Add(time.Minute)
+	a.Nil(s.lookup(requestKeyVersion, correlationHeader(6)))
+}
+
+func TestProxyWithApiVersionsCache(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1},
+		ApiVersions: []kafkatest.ApiVersion{{ApiKey: kafkatest.ApiKeyProduce, MinVersion: 0, MaxVersion: 8}}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.ApiVersionsCache.TTL = time.Minute
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	hits := counterOf(a, proxyApiVersionsCacheRequestsTotal, "hit")
+	var expected []byte
+	for i := int32(1); i <= 3; i++ {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 1, i, "test", nil))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(i, correlationID)
+		if expected == nil {
+			expected = body
+		}
+		a.Equal(expected, body)
+		conn.Close()
+	}
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyApiVersions))
+	a.Equal(hits+2, counterOf(a, proxyApiVersionsCacheRequestsTotal, "hit"))
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// decodeTestApiVersions returns the version ranges of the ApiVersions v1-v2 response body
+func decodeTestApiVersions(a *assert.Assertions, body []byte) map[int16][2]int16 {
+	// error_code, api_versions, throttle_time_ms
+	a.Equal(uint16(0), binary.BigEndian.Uint16(body))
+	n := int(binary.BigEndian.Uint32(body[2:]))
+	a.Equal(len(body), 6+n*6+4)
+	advertised := make(map[int16][2]int16)
+	for i := 0; i < n; i++ {
+		off := 6 + i*6
+		advertised[int16(binary.BigEndian.Uint16(body[off:]))] = [2]int16{int16(binary.BigEndian.Uint16(body[off+2:])), int16(binary.BigEndian.Uint16(body[off+4:]))}
+	}
+	return advertised
+}
+
+func TestForbiddenApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	f, err := newForbiddenApiVersions([]string{"1=0-3", "1=7", "44=1-"})
+	a.Nil(err)
+	a.True(f.isForbidden(1, 0))
+	a.True(f.isForbidden(1, 3))
+	a.False(f.isForbidden(1, 4))
+	a.True(f.isForbidden(1, 7))
+	a.False(f.isForbidden(44, 0))
+	a.True(f.isForbidden(44, 5))
+	a.False(f.isForbidden(0, 3))
+
+	f, err = newForbiddenApiVersions(nil)
+	a.Nil(err)
+	a.False(f.isForbidden(1, 0))
+}
+
+func TestProxyNarrowsAdvertisedApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ForbiddenApiVersions = []string{"1=0-3", "0=0-"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 2, 1, "app-1", nil))
+	a.Nil(err)
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	advertised := decodeTestApiVersions(a, body)
+	a.Equal(len(kafkatest.DefaultApiVersions)-1, len(advertised))
+	_, ok := advertised[kafkatest.ApiKeyProduce]
+	a.False(ok)
+	a.Equal([2]int16{4, 10}, advertised[kafkatest.ApiKeyFetch])
+	a.Equal([2]int16{0, 7}, advertised[kafkatest.ApiKeyMetadata])
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 3, 2, "app-1", []byte{1}))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyFetch))
+}
+
+func TestProxyAnswersForbiddenApiVersions(t *testing.T) {
+	// the rejection policy does not close the connections of the forbidden versions
+	for _, rejection := range []bool{false, true} {
+		testProxyAnswersForbiddenApiVersions(t, rejection)
+	}
+}
+
+func testProxyAnswersForbiddenApiVersions(t *testing.T, rejection bool) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ForbiddenApiVersions = []string{"0=0-3", "10=1"}
+	c.Rejection.Enable = rejection
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "app-1", kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")})))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+	code, err := kafkatest.DecodeProduceErrorCode(body)
+	a.Nil(err)
+	a.Equal(int16(protocol.ErrUnsupportedVersion), code)
+
+	// key "group-1" and key type 0
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFindCoordinator, 1, 2, "app-1", []byte{0, 7, 'g', 'r', 'o', 'u', 'p', '-', '1', 0}))
+	a.Nil(err)
+	correlationID, body, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(2), correlationID)
+	errs, err := protocol.DecodeResponseErrors(kafkatest.ApiKeyFindCoordinator, 1, body)
+	a.Nil(err)
+	a.Equal([]protocol.KError{protocol.ErrUnsupportedVersion}, errs)
+
+	// the connection stays open
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 2, 3, "app-1", nil))
+	a.Nil(err)
+	correlationID, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(3), correlationID)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyFindCoordinator))
+}
+
+func TestProxyCompatibleWithKafkaPresets(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tt := range []struct {
+		preset string
+		// version ranges of the service, the oldest versions are not supported by Redpanda
+		apiVersions []kafkatest.ApiVersion
+		// ApiVersions requests of the client and of the SASL negotiation
+		apiVersionsCount int
+	}{
+		{
+			preset: config.KafkaPresetAiven,
+			apiVersions: []kafkatest.ApiVersion{
+				{ApiKey: kafkatest.ApiKeyProduce, MinVersion: 0, MaxVersion: 9},
+				{ApiKey: kafkatest.ApiKeyFetch, MinVersion: 0, MaxVersion: 13},
+				{ApiKey: kafkatest.ApiKeyMetadata, MinVersion: 0, MaxVersion: 12},
+				{ApiKey: kafkatest.ApiKeySaslHandshake, MinVersion: 0, MaxVersion: 1},
+				{ApiKey: kafkatest.ApiKeyApiVersions, MinVersion: 0, MaxVersion: 3},
+				{ApiKey: kafkatest.ApiKeySaslAuthenticate, MinVersion: 0, MaxVersion: 2},
+			},
+			apiVersionsCount: 2,
+		},
+		{
+			preset: config.KafkaPresetRedpanda,
+			apiVersions: []kafkatest.ApiVersion{
+				{ApiKey: kafkatest.ApiKeyProduce, MinVersion: 3, MaxVersion: 9},
+				{ApiKey: kafkatest.ApiKeyFetch, MinVersion: 4, MaxVersion: 11},
+				{ApiKey: kafkatest.ApiKeyMetadata, MinVersion: 0, MaxVersion: 8},
+				{ApiKey: kafkatest.ApiKeySaslHandshake, MinVersion: 1, MaxVersion: 1},
+				{ApiKey: kafkatest.ApiKeyApiVersions, MinVersion: 0, MaxVersion: 3},
+				{ApiKey: kafkatest.ApiKeySaslAuthenticate, MinVersion: 0, MaxVersion: 2},
+			},
+			apiVersionsCount: 1,
+		},
+	} {
+		broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, ApiVersions: tt.apiVersions, Users: map[string]string{"alice": "secret"}})
+		a.Nil(err)
+
+		c := newTestProxyConfig(broker.Addr())
+		c.Kafka.Preset = tt.preset
+		c.Kafka.SASL.Enable = true
+		c.Kafka.SASL.Username = "alice"
+		c.Kafka.SASL.Password = "secret"
+		a.Nil(c.ApplyKafkaPreset())
+		// the fake broker does not terminate TLS, the TLS settings of the presets are checked by the config tests
+		c.Kafka.TLS.Enable = false
+		c.Kafka.Preset = ""
+		listenerAddress, stop := startTestProxy(a, c)
+
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 2, 1, "app-1", nil))
+		a.Nil(err)
+		_, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		advertised := decodeTestApiVersions(a, body)
+		a.Len(advertised, len(tt.apiVersions), tt.preset)
+		for _, v := range tt.apiVersions {
+			a.Equal([2]int16{v.MinVersion, v.MaxVersion}, advertised[v.ApiKey], tt.preset)
+		}
+
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 7, 2, "app-1", kafkatest.MetadataRequestBody(7, nil)))
+		a.Nil(err)
+		_, body, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		brokers, err := kafkatest.DecodeMetadataBrokers(7, body)
+		a.Nil(err)
+		a.Equal([]kafkatest.BrokerAddress{{NodeID: 1, Host: "127.0.0.1", Port: brokers[0].Port}}, brokers, tt.preset)
+		a.NotEqual(broker.Addr(), net.JoinHostPort(brokers[0].Host, strconv.Itoa(int(brokers[0].Port))), tt.preset)
+
+		a.Equal(tt.apiVersionsCount, broker.RequestCount(kafkatest.ApiKeyApiVersions), tt.preset)
+		a.Equal(1, broker.RequestCount(kafkatest.ApiKeySaslAuthenticate), tt.preset)
+		conn.Close()
+		stop()
+		broker.Close()
+	}
+}
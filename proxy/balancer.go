@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+type bootstrapEndpoint struct {
+	address string
+	weight  int
+	// smooth weighted round-robin state
+	currentWeight int
+	// the endpoint is skipped until the time after a failed connect
+	downUntil time.Time
+}
+
+// bootstrapEndpointPool selects the endpoints of a broker address with smooth weighted round-robin, skipping the endpoints which are down
+type bootstrapEndpointPool struct {
+	lock      sync.Mutex
+	endpoints []*bootstrapEndpoint
+}
+
+// next returns the endpoint which is not in tried. Endpoints which are up are preferred, nil is returned if all endpoints were tried.
+func (p *bootstrapEndpointPool) next(now time.Time, tried map[string]bool) *bootstrapEndpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	candidates := make([]*bootstrapEndpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if !tried[e.address] && !now.Before(e.downUntil) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		// all remaining endpoints are down, try them anyway
+		for _, e := range p.endpoints {
+			if !tried[e.address] {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var selected *bootstrapEndpoint
+	total := 0
+	for _, e := range candidates {
+		e.currentWeight += e.weight
+		total += e.weight
+		if selected == nil || e.currentWeight > selected.currentWeight {
+			selected = e
+		}
+	}
+	selected.currentWeight -= total
+	return selected
+}
+
+func (p *bootstrapEndpointPool) markDown(e *bootstrapEndpoint, until time.Time) {
+	p.lock.Lock()
+	e.downUntil = until
+	p.lock.Unlock()
+}
+
+func (p *bootstrapEndpointPool) markUp(e *bootstrapEndpoint) {
+	p.lock.Lock()
+	e.downUntil = time.Time{}
+	p.lock.Unlock()
+}
+
+// bootstrapBalancer dials one of the endpoints configured for a broker address, e.g. the load balancers in front of the same cluster
+type bootstrapBalancer struct {
+	pools       map[string]*bootstrapEndpointPool
+	downTimeout time.Duration
+}
+
+// newBootstrapBalancer returns nil if no bootstrap endpoints are configured
+func newBootstrapBalancer(c *config.Config) (*bootstrapBalancer, error) {
+	if len(c.Bootstrap.Endpoints) == 0 {
+		return nil, nil
+	}
+	b := &bootstrapBalancer{
+		pools:       make(map[string]*bootstrapEndpointPool),
+		downTimeout: c.Bootstrap.DownTimeout,
+	}
+	for _, v := range c.Bootstrap.Endpoints {
+		broker, endpoint, weight, err := config.ParseBootstrapEndpoint(v)
+		if err != nil {
+			return nil, err
+		}
+		pool, ok := b.pools[broker]
+		if !ok {
+			pool = &bootstrapEndpointPool{}
+			b.pools[broker] = pool
+		}
+		pool.endpoints = append(pool.endpoints, &bootstrapEndpoint{address: endpoint, weight: weight})
+		logrus.Infof("Connections to %s will be balanced to endpoint %s with weight %d", broker, endpoint, weight)
+	}
+	return b, nil
+}
+
+// dial connects to the broker address or, if endpoints are configured for it, to the endpoints until one succeeds.
+// The dialed address is returned.
+func (b *bootstrapBalancer) dial(ctx context.Context, brokerAddress string, dial func(address string) (net.Conn, error)) (net.Conn, string, error) {
+	if b == nil {
+		conn, err := dial(brokerAddress)
+		return conn, brokerAddress, err
+	}
+	pool, ok := b.pools[brokerAddress]
+	if !ok {
+		conn, err := dial(brokerAddress)
+		return conn, brokerAddress, err
+	}
+	tried := make(map[string]bool)
+	var lastErr error
+	for {
+		endpoint := pool.next(time.Now(), tried)
+		if endpoint == nil {
+			return nil, brokerAddress, lastErr
+		}
+		tried[endpoint.address] = true
+		conn, err := dial(endpoint.address)
+		if err == nil {
+			pool.markUp(endpoint)
+			return conn, endpoint.address, nil
+		}
+		if ctx.Err() != nil {
+			// the proxy is closing, the endpoint is not down
+			return nil, endpoint.address, err
+		}
+		lastErr = err
+		proxyBootstrapEndpointFailuresTotal.WithLabelValues(brokerAddress, endpoint.address).Inc()
+		logrus.Infof("couldn't connect to endpoint %s of %s, skipping it for %v: %v", endpoint.address, brokerAddress, b.downTimeout, err)
+		pool.markDown(endpoint, time.Now().Add(b.downTimeout))
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestBootstrapBalancerWeightedRoundRobin(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("kafka:9092")
+	disabled, err := newBootstrapBalancer(c)
+	a.Nil(err)
+	a.Nil(disabled)
+
+	c.Bootstrap.Endpoints = []string{"kafka:9092,lb-a:9092,3", "kafka:9092,lb-b:9092"}
+	balancer, err := newBootstrapBalancer(c)
+	a.Nil(err)
+
+	down := make(map[string]bool)
+	dial := func(address string) (net.Conn, error) {
+		if down[address] {
+			return nil, errors.New("connection refused")
+		}
+		local, remote := net.Pipe()
+		remote.Close()
+		return local, nil
+	}
+	dialed := func(brokerAddress string) string {
+		conn, address, err := balancer.dial(context.Background(), brokerAddress, dial)
+		a.Nil(err)
+		conn.Close()
+		return address
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[dialed("kafka:9092")]++
+	}
+	a.Equal(map[string]int{"lb-a:9092": 6, "lb-b:9092": 2}, counts)
+	// broker address without endpoints is dialed directly
+	a.Equal("other:9092", dialed("other:9092"))
+
+	// the endpoint which failed is skipped
+	down["lb-a:9092"] = true
+	for i := 0; i < 4; i++ {
+		a.Equal("lb-b:9092", dialed("kafka:9092"))
+	}
+	// all endpoints down
+	down["lb-b:9092"] = true
+	_, _, err = balancer.dial(context.Background(), "kafka:9092", dial)
+	a.EqualError(err, "connection refused")
+	// the endpoints which are down are tried when no endpoint is up
+	delete(down, "lb-a:9092")
+	a.Equal("lb-a:9092", dialed("kafka:9092"))
+}
+
+func TestProxyDialsNextBootstrapEndpoint(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer broker.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	closedAddress := l.Addr().String()
+	l.Close()
+
+	c := newTestProxyConfig("kafka.grepplabs.com:9092")
+	c.Bootstrap.Endpoints = []string{"kafka.grepplabs.com:9092," + closedAddress + ",10", "kafka.grepplabs.com:9092," + broker.Addr()}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 0, 1, "app-1", nil))
+		a.Nil(err)
+		_, _, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		conn.Close()
+	}
+	// the connections fall over from the closed endpoint despite its higher weight
+	a.Equal(3, broker.RequestCount(kafkatest.ApiKeyApiVersions))
+}
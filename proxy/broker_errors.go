@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// brokerErrors counts and logs the Kafka error codes of the broker responses which the proxy decodes anyway,
+// e.g. to map the broker addresses, so the proxy passively monitors the health of the upstream cluster
+type brokerErrors struct {
+	logInterval time.Duration
+	now         func() time.Time
+
+	lock   sync.Mutex
+	logged map[brokerErrorKey]*brokerErrorLog
+}
+
+type brokerErrorKey struct {
+	brokerAddress string
+	apiKey        int16
+	kafkaErr      protocol.KError
+}
+
+type brokerErrorLog struct {
+	last time.Time
+	// errors since the last log
+	suppressed int
+}
+
+// newBrokerErrors returns nil if the error codes are not counted
+func newBrokerErrors(c *config.Config) *brokerErrors {
+	if !c.BrokerErrors.Enable {
+		return nil
+	}
+	return &brokerErrors{
+		logInterval: c.BrokerErrors.LogInterval,
+		now:         time.Now,
+		logged:      make(map[brokerErrorKey]*brokerErrorLog),
+	}
+}
+
+// observe records the error codes of the response as returned by the broker
+func (b *brokerErrors) observe(brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, resp []byte) {
+	if b == nil {
+		return
+	}
+	kafkaErrs, err := protocol.DecodeResponseErrors(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, resp)
+	if err != nil {
+		logrus.Debugf("Error codes of response key %d, version %d from %s cannot be decoded: %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, brokerAddress, err)
+		return
+	}
+	counts := make(map[protocol.KError]int)
+	for _, kafkaErr := range kafkaErrs {
+		counts[kafkaErr]++
+	}
+	for kafkaErr, n := range counts {
+		b.record(brokerAddress, requestKeyVersion.ApiKey, kafkaErr, n)
+	}
+}
+
+// record counts n errors of the broker and logs them unless the same error was logged within the log interval.
+// It returns the number of the logged errors including the suppressed ones.
+func (b *brokerErrors) record(brokerAddress string, apiKey int16, kafkaErr protocol.KError, n int) int {
+	if b == nil {
+		return 0
+	}
+	proxyBrokerErrorsTotal.WithLabelValues(brokerAddress, strconv.Itoa(int(apiKey)), kafkaErr.Name()).Add(float64(n))
+	if b.logInterval == 0 {
+		return 0
+	}
+	key := brokerErrorKey{brokerAddress: brokerAddress, apiKey: apiKey, kafkaErr: kafkaErr}
+	now := b.now()
+
+	b.lock.Lock()
+	l, ok := b.logged[key]
+	if !ok {
+		l = &brokerErrorLog{}
+		b.logged[key] = l
+	}
+	if !l.last.IsZero() && now.Sub(l.last) < b.logInterval {
+		l.suppressed += n
+		b.lock.Unlock()
+		return 0
+	}
+	n += l.suppressed
+	l.last = now
+	l.suppressed = 0
+	b.lock.Unlock()
+
+	logrus.WithFields(logrus.Fields{"broker": brokerAddress, "api_key": apiKey, "error": kafkaErr.Name()}).Warnf("Broker returned error %s, %d times since the last log", kafkaErr.Name(), n)
+	return n
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBrokerErrors(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newBrokerErrors(c))
+	c.BrokerErrors.Enable = true
+	b := newBrokerErrors(c)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	findCoordinatorResponse := []byte{
+		// error_code NOT_COORDINATOR
+		0x00, 0x10,
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff,
+	}
+	// the first error is logged, the next ones are suppressed within the log interval
+	b.observe("kafka-errors:9092", &protocol.RequestKeyVersion{ApiKey: 10, ApiVersion: 0}, findCoordinatorResponse)
+	b.observe("kafka-errors:9092", &protocol.RequestKeyVersion{ApiKey: 10, ApiVersion: 0}, findCoordinatorResponse)
+	a.Equal(0, b.record("kafka-errors:9092", 10, protocol.ErrNotCoordinatorForConsumer, 1))
+	now = now.Add(time.Minute)
+	a.Equal(3, b.record("kafka-errors:9092", 10, protocol.ErrNotCoordinatorForConsumer, 1))
+	a.Equal(1, b.record("kafka-errors:9092", 36, protocol.ErrSASLAuthenticationFailed, 1))
+
+	// nil safe
+	var disabled *brokerErrors
+	disabled.observe("kafka-errors:9092", &protocol.RequestKeyVersion{ApiKey: 10, ApiVersion: 0}, findCoordinatorResponse)
+	a.Equal(0, disabled.record("kafka-errors:9092", 10, protocol.ErrNotCoordinatorForConsumer, 1))
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+)
+
+// brokerRootCAs verifies some brokers with own CA certificates instead of the Kafka TLS CA chain,
+// e.g. when the brokers of the mapped clusters are issued by different CAs. The CA certificates of the listeners verify only the clients.
+type brokerRootCAs struct {
+	byAddress map[string]*x509.CertPool
+	// brokers with the address *:port
+	byPort map[string]*x509.CertPool
+}
+
+// newBrokerRootCAs returns nil if all brokers are verified with the Kafka TLS CA chain
+func newBrokerRootCAs(c *config.Config) (*brokerRootCAs, error) {
+	if len(c.Kafka.TLS.BrokerCAChainCertFiles) == 0 {
+		return nil, nil
+	}
+	rootCAs := &brokerRootCAs{byAddress: make(map[string]*x509.CertPool), byPort: make(map[string]*x509.CertPool)}
+	for _, v := range c.Kafka.TLS.BrokerCAChainCertFiles {
+		brokerAddress, caChainCertFile, err := config.ParseBrokerCAChainCertFile(v)
+		if err != nil {
+			return nil, err
+		}
+		caCertPEMBlock, err := ioutil.ReadFile(caChainCertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(caCertPEMBlock); !ok {
+			return nil, errors.Errorf("Failed to parse root certificate of broker %s", brokerAddress)
+		}
+		host, port, _ := net.SplitHostPort(brokerAddress)
+		if host == "*" {
+			rootCAs.byPort[port] = pool
+		} else {
+			rootCAs.byAddress[brokerAddress] = pool
+		}
+	}
+	logrus.Infof("Brokers %v are verified with own CA certificates", c.Kafka.TLS.BrokerCAChainCertFiles)
+	return rootCAs, nil
+}
+
+// lookup returns the CA certificates of the broker, false if the broker is verified with the Kafka TLS CA chain
+func (r *brokerRootCAs) lookup(brokerAddress string) (*x509.CertPool, bool) {
+	if r == nil {
+		return nil, false
+	}
+	if pool, ok := r.byAddress[brokerAddress]; ok {
+		return pool, true
+	}
+	if _, port, err := net.SplitHostPort(brokerAddress); err == nil {
+		if pool, ok := r.byPort[port]; ok {
+			return pool, true
+		}
+	}
+	return nil, false
+}
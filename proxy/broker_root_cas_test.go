@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBrokerRootCAsVerifyBrokersOfDifferentCAs(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	otherBundle := NewCertsBundle()
+	defer otherBundle.Close()
+
+	listen := func(bundle *CertsBundle) net.Listener {
+		cert, err := tls.LoadX509KeyPair(bundle.ServerCert.Name(), bundle.ServerKey.Name())
+		a.Nil(err)
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		a.Nil(err)
+		go acceptHandshakes(ln)
+		return ln
+	}
+	ln := listen(bundle)
+	defer ln.Close()
+	otherLn := listen(otherBundle)
+	defer otherLn.Close()
+	_, otherPort, _ := net.SplitHostPort(otherLn.Addr().String())
+
+	c := config.NewConfig()
+	c.Kafka.TLS.Enable = true
+	c.Kafka.TLS.CAChainCertFile = bundle.CACert.Name()
+	tlsConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	rootCAs := tlsConfig.RootCAs
+	dialer, err := newTLSDialerIfEnabled(c, tlsConfig, directDialer{dialTimeout: time.Second})
+	a.Nil(err)
+	conn, err := dialer.Dial("tcp", ln.Addr().String())
+	a.Nil(err)
+	conn.Close()
+	_, err = dialer.Dial("tcp", otherLn.Addr().String())
+	a.NotNil(err)
+
+	for _, brokerAddress := range []string{otherLn.Addr().String(), "*:" + otherPort} {
+		c.Kafka.TLS.BrokerCAChainCertFiles = []string{brokerAddress + "=" + otherBundle.CACert.Name()}
+		dialer, err = newTLSDialerIfEnabled(c, tlsConfig, directDialer{dialTimeout: time.Second})
+		a.Nil(err)
+		conn, err = dialer.Dial("tcp", otherLn.Addr().String())
+		a.Nil(err)
+		conn.Close()
+		// the other brokers are verified with the Kafka TLS CA chain
+		conn, err = dialer.Dial("tcp", ln.Addr().String())
+		a.Nil(err)
+		conn.Close()
+		// the shared config is not modified
+		a.True(rootCAs == tlsConfig.RootCAs)
+	}
+}
+
+func TestBrokerRootCAsInvalid(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	rootCAs, err := newBrokerRootCAs(c)
+	a.Nil(err)
+	a.Nil(rootCAs)
+	_, ok := rootCAs.lookup("kafka-1:9093")
+	a.False(ok)
+
+	c.Kafka.TLS.BrokerCAChainCertFiles = []string{"kafka-1:9093=/nonexistent/ca.pem"}
+	_, err = newBrokerRootCAs(c)
+	a.NotNil(err)
+}
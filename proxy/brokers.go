@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	BrokerStateListening = "listening"
+	BrokerStateRetired   = "retired"
+	BrokerStateClosed    = "closed"
+	// the broker address is mapped to the advertised address without a listener of this proxy
+	BrokerStateExternal = "external"
+)
+
+// BrokerInfo is the mapping of the upstream broker address to the address advertised to the clients
+type BrokerInfo struct {
+	// nil if unknown, the node ids are learned by the topology refresh
+	NodeID            *int32 `json:"node_id,omitempty"`
+	BrokerAddress     string `json:"broker_address"`
+	ListenerAddress   string `json:"listener_address"`
+	AdvertisedAddress string `json:"advertised_address"`
+	Dynamic           bool   `json:"dynamic"`
+	State             string `json:"state"`
+	// last time the broker address was mapped in a response, nil if never
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// Brokers returns the broker mappings sorted by the broker address
+func (p *Listeners) Brokers() []BrokerInfo {
+	p.lock.RLock()
+	brokers := make([]BrokerInfo, 0, len(p.brokerToListenerConfig)+len(p.retired))
+	for brokerAddress, lc := range p.brokerToListenerConfig {
+		_, dynamic := p.dynamicListeners[brokerAddress]
+		state := BrokerStateExternal
+		switch {
+		case p.closed && p.listening[brokerAddress]:
+			state = BrokerStateClosed
+		case p.listening[brokerAddress]:
+			state = BrokerStateListening
+		}
+		brokers = append(brokers, BrokerInfo{BrokerAddress: brokerAddress, ListenerAddress: lc.ListenerAddress, AdvertisedAddress: lc.AdvertisedAddress, Dynamic: dynamic, State: state})
+	}
+	for brokerAddress, lc := range p.retired {
+		brokers = append(brokers, BrokerInfo{BrokerAddress: brokerAddress, ListenerAddress: lc.ListenerAddress, AdvertisedAddress: lc.AdvertisedAddress, Dynamic: true, State: BrokerStateRetired})
+	}
+	p.lock.RUnlock()
+
+	p.seenLock.Lock()
+	for i := range brokers {
+		if nodeID, ok := p.nodeIDs[brokers[i].AdvertisedAddress]; ok {
+			brokers[i].NodeID = &nodeID
+		}
+		if lastSeen, ok := p.lastSeen[brokers[i].BrokerAddress]; ok {
+			brokers[i].LastSeen = &lastSeen
+		}
+	}
+	p.seenLock.Unlock()
+
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].BrokerAddress < brokers[j].BrokerAddress })
+	return brokers
+}
+
+// Brokers returns the broker mappings of the proxy
+func (p *Proxy) Brokers() []BrokerInfo {
+	return p.listeners.Brokers()
+}
+
+var (
+	proxyBrokerInfo = prometheus.NewDesc(
+		"proxy_broker_info",
+		"Mapping of the upstream broker address to the advertised address, the value is 1",
+		[]string{"node_id", "broker", "listener", "advertised", "state"}, nil,
+	)
+	proxyBrokerLastSeenSeconds = prometheus.NewDesc(
+		"proxy_broker_last_seen_timestamp_seconds",
+		"Last time the broker address was mapped in a response",
+		[]string{"broker"}, nil,
+	)
+)
+
+// BrokerTable lists the broker mappings of the proxies with the HTTP admin API and as metrics
+type BrokerTable struct {
+	proxies []*Proxy
+}
+
+func NewBrokerTable(proxies ...*Proxy) *BrokerTable {
+	return &BrokerTable{proxies: proxies}
+}
+
+// Brokers returns the broker mappings of all proxies
+func (t *BrokerTable) Brokers() []BrokerInfo {
+	brokers := make([]BrokerInfo, 0)
+	for _, p := range t.proxies {
+		brokers = append(brokers, p.Brokers()...)
+	}
+	return brokers
+}
+
+func (t *BrokerTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Brokers())
+}
+
+func (t *BrokerTable) Describe(ch chan<- *prometheus.Desc) {
+	ch <- proxyBrokerInfo
+	ch <- proxyBrokerLastSeenSeconds
+}
+
+func (t *BrokerTable) Collect(ch chan<- prometheus.Metric) {
+	for _, b := range t.Brokers() {
+		nodeID := ""
+		if b.NodeID != nil {
+			nodeID = fmt.Sprint(*b.NodeID)
+		}
+		ch <- prometheus.MustNewConstMetric(proxyBrokerInfo, prometheus.GaugeValue, 1, nodeID, b.BrokerAddress, b.ListenerAddress, b.AdvertisedAddress, b.State)
+		if b.LastSeen != nil {
+			ch <- prometheus.MustNewConstMetric(proxyBrokerLastSeenSeconds, prometheus.GaugeValue, float64(b.LastSeen.UnixNano())/1e9, b.BrokerAddress)
+		}
+	}
+}
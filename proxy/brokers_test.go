@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListenersBrokers(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(c.InitExternalServers([]string{"kafka-0.grepplabs.com:9092,kafka-proxy-0.grepplabs.com:32400"}))
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+
+	host, port, err := listeners.GetNetAddressMapping("kafka-1.grepplabs.com", 9092)
+	a.Nil(err)
+	advertised := net.JoinHostPort(host, fmt.Sprint(port))
+	listeners.SetNodeID(advertised, 1)
+	_, _, err = listeners.GetNetAddressMapping("kafka-2.grepplabs.com", 9092)
+	a.Nil(err)
+	listeners.RetireDynamicListeners(map[string]bool{advertised: true})
+
+	brokers := listeners.Brokers()
+	a.Len(brokers, 3)
+
+	a.Equal("kafka-0.grepplabs.com:9092", brokers[0].BrokerAddress)
+	a.Equal("kafka-proxy-0.grepplabs.com:32400", brokers[0].AdvertisedAddress)
+	a.Equal(BrokerStateExternal, brokers[0].State)
+	a.False(brokers[0].Dynamic)
+	a.Nil(brokers[0].NodeID)
+	a.Nil(brokers[0].LastSeen)
+
+	a.Equal("kafka-1.grepplabs.com:9092", brokers[1].BrokerAddress)
+	a.Equal(advertised, brokers[1].AdvertisedAddress)
+	a.Equal(BrokerStateListening, brokers[1].State)
+	a.True(brokers[1].Dynamic)
+	a.Equal(int32(1), *brokers[1].NodeID)
+	a.NotNil(brokers[1].LastSeen)
+
+	a.Equal("kafka-2.grepplabs.com:9092", brokers[2].BrokerAddress)
+	a.Equal(BrokerStateRetired, brokers[2].State)
+	a.True(brokers[2].Dynamic)
+	a.NotNil(brokers[2].LastSeen)
+}
+
+func TestBrokerTableAdminAPI(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("kafka-0.grepplabs.com:9092")
+	c.Proxy.BootstrapServers[0].ListenerAddress = "127.0.0.1:0"
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = "kafka-proxy-0.grepplabs.com:32400"
+	p, err := New(c)
+	a.Nil(err)
+	defer p.Close()
+
+	table := NewBrokerTable(p)
+	rec := httptest.NewRecorder()
+	table.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/brokers", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	var brokers []BrokerInfo
+	a.Nil(json.Unmarshal(rec.Body.Bytes(), &brokers))
+	a.Equal([]BrokerInfo{{BrokerAddress: "kafka-0.grepplabs.com:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "kafka-proxy-0.grepplabs.com:32400", State: BrokerStateListening}}, brokers)
+
+	rec = httptest.NewRecorder()
+	table.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/brokers", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
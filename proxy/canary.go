@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// wait for the first request of the client when its connection is routed by the client id
+	canaryClientIDTimeout = 10 * time.Second
+	// resolution of the canary percentage
+	canaryBuckets = 10000
+)
+
+// replayConn returns the peeked bytes before the bytes read from the connection
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// peekClientID reads the header of the first request up to the client id. The returned connection replays the read bytes,
+// so the request is processed as sent by the client.
+func peekClientID(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, err
+	}
+	// Size, ApiKey, ApiVersion, CorrelationId and the length of the ClientId
+	buf := make([]byte, 14)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", nil, err
+	}
+	clientID := ""
+	// -1 is the null client id
+	if n := int16(binary.BigEndian.Uint16(buf[12:])); n > 0 {
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(conn, buf[14:]); err != nil {
+			return "", nil, err
+		}
+		clientID = string(buf[14:])
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, err
+	}
+	return clientID, &replayConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(buf), conn)}, nil
+}
+
+// isCanary reports whether the connections of the client are routed to the canary cluster. The same clients are selected
+// by all connections and proxy replicas and the selected clients stay selected when the percentage is increased.
+func isCanary(key string, percent float64) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%canaryBuckets) < percent*canaryBuckets/100
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeekClientIDReplaysRequest(t *testing.T) {
+	a := assert.New(t)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	request := kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 7, "orders-app", kafkatest.MetadataRequestBody(1, nil))
+	go remote.Write(request)
+
+	clientID, conn, err := peekClientID(local, time.Second)
+	a.Nil(err)
+	a.Equal("orders-app", clientID)
+	replayed := make([]byte, len(request))
+	_, err = io.ReadFull(conn, replayed)
+	a.Nil(err)
+	a.Equal(request, replayed)
+
+	go remote.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 8, "", kafkatest.MetadataRequestBody(1, nil)))
+	clientID, _, err = peekClientID(local, time.Second)
+	a.Nil(err)
+	a.Equal("", clientID)
+
+	_, _, err = peekClientID(local, 10*time.Millisecond)
+	a.NotNil(err)
+}
+
+func TestIsCanary(t *testing.T) {
+	a := assert.New(t)
+
+	canaries := 0
+	for i := 0; i < 1000; i++ {
+		clientID := fmt.Sprintf("app-%d", i)
+		a.False(isCanary(clientID, 0))
+		a.True(isCanary(clientID, 100))
+		if isCanary(clientID, 10) {
+			// stays selected when the percentage is increased
+			a.True(isCanary(clientID, 50))
+			canaries++
+		}
+	}
+	a.InDelta(100, canaries, 40)
+}
+
+func TestProxyRoutesCanaryClientIDs(t *testing.T) {
+	a := assert.New(t)
+
+	primary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer primary.Close()
+	secondary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 2})
+	a.Nil(err)
+	defer secondary.Close()
+
+	c := newTestProxyConfig(primary.Addr())
+	c.Upstream.SecondaryMapping = []string{primary.Addr() + "," + secondary.Addr()}
+	c.Upstream.CanaryPercent = 50
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	listenerAddress, stop := startTestProxy(a, c, WithUpstreamSwitch(upstream))
+	defer stop()
+	host, port, err := util.SplitHostPort(listenerAddress)
+	a.Nil(err)
+
+	var canary, other string
+	for i := 0; canary == "" || other == ""; i++ {
+		clientID := fmt.Sprintf("app-%d", i)
+		if isCanary(clientID, 50) {
+			canary = clientID
+		} else {
+			other = clientID
+		}
+	}
+	brokers := func(clientID string) []kafkatest.BrokerAddress {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		defer conn.Close()
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 1, clientID, kafkatest.MetadataRequestBody(1, nil)))
+		a.Nil(err)
+		_, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		brokers, err := kafkatest.DecodeMetadataBrokers(1, body)
+		a.Nil(err)
+		return brokers
+	}
+	for i := 0; i < 2; i++ {
+		a.Equal([]kafkatest.BrokerAddress{{NodeID: 2, Host: host, Port: port}}, brokers(canary))
+		a.Equal([]kafkatest.BrokerAddress{{NodeID: 1, Host: host, Port: port}}, brokers(other))
+	}
+	a.Equal(2, primary.RequestCount(kafkatest.ApiKeyMetadata))
+	a.Equal(2, secondary.RequestCount(kafkatest.ApiKeyMetadata))
+
+	zero := float64(0)
+	a.Nil(upstream.Switch(UpstreamSettings{Active: UpstreamPrimary, CanaryPercent: &zero}))
+	a.Equal([]kafkatest.BrokerAddress{{NodeID: 1, Host: host, Port: port}}, brokers(canary))
+}
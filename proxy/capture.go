@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// capture writes the summaries of sampled requests and their responses as JSON lines to rotating files
+type capture struct {
+	writer        *rotatingFile
+	samplePercent float64
+	// all api keys are captured when empty
+	apiKeys map[int16]struct{}
+	// all client ids are captured when empty
+	clientIDs []*regexp.Regexp
+	// frames are written base64 encoded
+	raw bool
+
+	connections uint64
+}
+
+// captureRecord is a captured request or response
+type captureRecord struct {
+	Time          time.Time `json:"time"`
+	Connection    uint64    `json:"connection"`
+	Broker        string    `json:"broker"`
+	Direction     string    `json:"direction"`
+	ApiKey        int16     `json:"api_key"`
+	ApiVersion    int16     `json:"api_version"`
+	CorrelationID int32     `json:"correlation_id"`
+	ClientID      string    `json:"client_id"`
+	Length        int32     `json:"length"`
+	LatencyMs     *float64  `json:"latency_ms,omitempty"`
+	Frame         string    `json:"frame,omitempty"`
+}
+
+// newCapture returns nil if the capture is disabled
+func newCapture(c *config.Config) (*capture, error) {
+	if !c.Capture.Enable {
+		return nil, nil
+	}
+	writer, err := newRotatingFile(c.Capture.File, int64(c.Capture.MaxFileSize), c.Capture.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	result := &capture{writer: writer, samplePercent: c.Capture.SamplePercent, raw: c.Capture.Raw}
+	for _, v := range c.Capture.ApiKeys {
+		if result.apiKeys == nil {
+			result.apiKeys = make(map[int16]struct{})
+		}
+		result.apiKeys[int16(v)] = struct{}{}
+	}
+	for _, v := range c.Capture.ClientIDs {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		result.clientIDs = append(result.clientIDs, pattern)
+	}
+	logrus.Warnf("%v%% of requests with api keys %v and client ids matching %v will be captured to %s", c.Capture.SamplePercent, c.Capture.ApiKeys, c.Capture.ClientIDs, c.Capture.File)
+	return result, nil
+}
+
+func (c *capture) newSession(brokerAddress string) *captureSession {
+	if c == nil {
+		return nil
+	}
+	return &captureSession{
+		capture:       c,
+		connection:    atomic.AddUint64(&c.connections, 1),
+		brokerAddress: brokerAddress,
+		pending:       make(map[int32]*capturedRequest),
+	}
+}
+
+func (c *capture) write(record *captureRecord, frame []byte) {
+	if c.raw && frame != nil {
+		record.Frame = base64.StdEncoding.EncodeToString(frame)
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		_, err = c.writer.Write(append(data, '\n'))
+	}
+	if err != nil {
+		proxyCaptureErrorsTotal.Inc()
+		logrus.Debugf("Capture of %s %d failed: %v", record.Direction, record.CorrelationID, err)
+	}
+}
+
+type capturedRequest struct {
+	apiKey     int16
+	apiVersion int16
+	clientID   string
+	sent       time.Time
+}
+
+// captureSession captures the requests of a connection and matches the responses by the correlation id
+type captureSession struct {
+	capture       *capture
+	connection    uint64
+	brokerAddress string
+
+	lock    sync.Mutex
+	pending map[int32]*capturedRequest
+}
+
+// raw reports whether the frames are captured
+func (s *captureSession) raw() bool {
+	return s != nil && s.capture.raw
+}
+
+// samples reports whether the request is captured
+func (s *captureSession) samples(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, clientID string) bool {
+	// request header v0 has no correlation id
+	if s == nil || len(headerBuf) < 4 {
+		return false
+	}
+	if len(s.capture.apiKeys) != 0 {
+		if _, ok := s.capture.apiKeys[requestKeyVersion.ApiKey]; !ok {
+			return false
+		}
+	}
+	if len(s.capture.clientIDs) != 0 {
+		matches := false
+		for _, pattern := range s.capture.clientIDs {
+			if pattern.MatchString(clientID) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false
+		}
+	}
+	return s.capture.samplePercent >= 100 || rand.Float64()*100 < s.capture.samplePercent
+}
+
+// request writes the sampled request, body is the request body sent by the client or nil if it was not read
+func (s *captureSession) request(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, clientID string, body []byte) {
+	correlationID := int32(binary.BigEndian.Uint32(headerBuf))
+	now := time.Now()
+	s.lock.Lock()
+	s.pending[correlationID] = &capturedRequest{apiKey: requestKeyVersion.ApiKey, apiVersion: requestKeyVersion.ApiVersion, clientID: clientID, sent: now}
+	s.lock.Unlock()
+
+	var frame []byte
+	if body != nil {
+		// frame without the size: ApiKey, ApiVersion, request header and body
+		frame = make([]byte, 4, 4+len(headerBuf)+len(body))
+		binary.BigEndian.PutUint16(frame, uint16(requestKeyVersion.ApiKey))
+		binary.BigEndian.PutUint16(frame[2:], uint16(requestKeyVersion.ApiVersion))
+		frame = append(append(frame, headerBuf...), body...)
+	}
+	s.capture.write(&captureRecord{
+		Time:          now,
+		Connection:    s.connection,
+		Broker:        s.brokerAddress,
+		Direction:     "request",
+		ApiKey:        requestKeyVersion.ApiKey,
+		ApiVersion:    requestKeyVersion.ApiVersion,
+		CorrelationID: correlationID,
+		ClientID:      clientID,
+		Length:        requestKeyVersion.Length,
+	}, frame)
+}
+
+// captured returns the sampled request of the response, nil if the request was not captured
+func (s *captureSession) captured(correlationID int32) *capturedRequest {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, ok := s.pending[correlationID]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, correlationID)
+	return request
+}
+
+// response writes the response of the sampled request, frame is the correlation id and the body sent to the client or nil if the body was not read
+func (s *captureSession) response(request *capturedRequest, correlationID int32, length int32, frame []byte) {
+	now := time.Now()
+	latency := float64(now.Sub(request.sent)) / float64(time.Millisecond)
+	s.capture.write(&captureRecord{
+		Time:          now,
+		Connection:    s.connection,
+		Broker:        s.brokerAddress,
+		Direction:     "response",
+		ApiKey:        request.apiKey,
+		ApiVersion:    request.apiVersion,
+		CorrelationID: correlationID,
+		ClientID:      request.clientID,
+		Length:        length,
+		LatencyMs:     &latency,
+	}, frame)
+}
+
+// rotatingFile renames the file to path.1, path.1 to path.2 and so on when the size limit would be exceeded
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", f.path, i)
+			if _, err := os.Stat(from); err == nil {
+				if err = os.Rename(from, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readCaptureRecords(t *testing.T, path string) []captureRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []captureRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record captureRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestCaptureSession(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := newCapture(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	a.Nil(disabled.newSession("127.0.0.1:9092"))
+
+	c.Capture.Enable = true
+	c.Capture.File = filepath.Join(dir, "capture.jsonl")
+	c.Capture.MaxFileSize = 1024 * 1024
+	c.Capture.SamplePercent = 100
+	c.Capture.ApiKeys = []int{int(kafkatest.ApiKeyMetadata)}
+	c.Capture.ClientIDs = []string{"^app-"}
+	c.Capture.Raw = true
+	capture, err := newCapture(c)
+	a.Nil(err)
+
+	session := capture.newSession("127.0.0.1:9092")
+	metadata := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata, ApiVersion: 1, Length: 13}
+	headerBuf := []byte{0, 0, 0, 7, 0, 3, 'a', 'p', 'p'}
+	a.False(session.samples(metadata, nil, "app-1"))
+	a.False(session.samples(metadata, headerBuf, "other"))
+	a.False(session.samples(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce}, headerBuf, "app-1"))
+	a.True(session.samples(metadata, headerBuf, "app-1"))
+
+	session.request(metadata, headerBuf, "app-1", []byte{0, 0, 0, 0})
+	a.Nil(session.captured(8))
+	request := session.captured(7)
+	a.NotNil(request)
+	session.response(request, 7, 8, []byte{0, 0, 0, 7, 1, 2, 3, 4})
+	a.Nil(session.captured(7))
+
+	records := readCaptureRecords(t, c.Capture.File)
+	a.Len(records, 2)
+	a.Equal("request", records[0].Direction)
+	a.Equal(int32(7), records[0].CorrelationID)
+	a.Equal("app-1", records[0].ClientID)
+	a.Equal(int32(13), records[0].Length)
+	a.Nil(records[0].LatencyMs)
+	frame, err := base64.StdEncoding.DecodeString(records[0].Frame)
+	a.Nil(err)
+	a.Equal([]byte{0, 3, 0, 1, 0, 0, 0, 7, 0, 3, 'a', 'p', 'p', 0, 0, 0, 0}, frame)
+
+	a.Equal("response", records[1].Direction)
+	a.Equal(kafkatest.ApiKeyMetadata, records[1].ApiKey)
+	a.Equal(int16(1), records[1].ApiVersion)
+	a.Equal("app-1", records[1].ClientID)
+	a.NotNil(records[1].LatencyMs)
+	a.Equal(records[0].Connection, records[1].Connection)
+}
+
+func TestCaptureRotatingFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.jsonl")
+	file, err := newRotatingFile(path, 10, 2)
+	a.Nil(err)
+	for _, v := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"} {
+		_, err = file.Write([]byte(v))
+		a.Nil(err)
+	}
+	for name, expected := range map[string]string{path: "dddddd", path + ".1": "cccccc", path + ".2": "bbbbbb"} {
+		data, err := ioutil.ReadFile(name)
+		a.Nil(err)
+		a.Equal(expected, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	a.True(os.IsNotExist(err))
+}
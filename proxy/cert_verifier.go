@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const defaultCertVerifierTimeout = 10 * time.Second
+
+// certVerifier verifies the peer certificates of the TLS handshakes with the certificate verifier plugin
+type certVerifier struct {
+	verifier apis.CertificateVerifier
+	timeout  time.Duration
+	listener bool
+	broker   bool
+}
+
+// newCertVerifier returns nil if there is no verifier
+func newCertVerifier(c *config.Config, verifier apis.CertificateVerifier) *certVerifier {
+	if verifier == nil {
+		return nil
+	}
+	timeout := c.CertVerifier.Timeout
+	if timeout <= 0 {
+		timeout = defaultCertVerifierTimeout
+	}
+	return &certVerifier{verifier: verifier, timeout: timeout, listener: c.CertVerifier.Listener, broker: c.CertVerifier.Broker}
+}
+
+// applyListener sets the verification of the client certificates on the listener TLS configuration
+func (v *certVerifier) applyListener(tlsConfig *tls.Config) {
+	if v == nil || !v.listener || tlsConfig == nil {
+		return
+	}
+	tlsConfig.VerifyPeerCertificate = chainVerifyPeerCertificate(tlsConfig.VerifyPeerCertificate, v.verifyPeerCertificate(apis.CertificateSideListener))
+}
+
+// applyBroker returns the copy of the broker TLS configuration verifying the broker certificates
+func (v *certVerifier) applyBroker(tlsConfig *tls.Config) *tls.Config {
+	if v == nil || !v.broker || tlsConfig == nil {
+		return tlsConfig
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.VerifyPeerCertificate = v.verifyPeerCertificate(apis.CertificateSideBroker)
+	return tlsConfig
+}
+
+func (v *certVerifier) verifyPeerCertificate(side string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		// clients without certificates are handled by the client auth policy
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		request := apis.CertificateVerifyRequest{Side: side, RawCerts: rawCerts}
+		if len(verifiedChains) != 0 {
+			for _, cert := range verifiedChains[0] {
+				request.VerifiedChain = append(request.VerifiedChain, cert.Raw)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+		defer cancel()
+
+		response, err := v.verifier.VerifyCertificate(ctx, request)
+		if err != nil {
+			proxyCertificateVerificationsTotal.WithLabelValues(side, "error").Inc()
+			return errors.Wrapf(err, "verification of %s certificate %s failed", side, certificateSubject(rawCerts[0]))
+		}
+		if !response.Success {
+			proxyCertificateVerificationsTotal.WithLabelValues(side, "rejected").Inc()
+			logrus.Warnf("The certificate verifier rejected %s certificate %s with status %d", side, certificateSubject(rawCerts[0]), response.Status)
+			return errors.Errorf("%s certificate %s is rejected by the certificate verifier", side, certificateSubject(rawCerts[0]))
+		}
+		proxyCertificateVerificationsTotal.WithLabelValues(side, "accepted").Inc()
+		return nil
+	}
+}
+
+func certificateSubject(raw []byte) string {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return "<invalid>"
+	}
+	return cert.Subject.String()
+}
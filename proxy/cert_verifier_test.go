@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testCertificateVerifier struct {
+	requests []apis.CertificateVerifyRequest
+	success  bool
+}
+
+func (v *testCertificateVerifier) VerifyCertificate(ctx context.Context, request apis.CertificateVerifyRequest) (apis.CertificateVerifyResponse, error) {
+	v.requests = append(v.requests, request)
+	return apis.CertificateVerifyResponse{Success: v.success, Status: 7}, nil
+}
+
+func TestCertVerifierListener(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.CAChainCertFile = bundle.CACert.Name()
+	c.Proxy.TLS.ListenerClientAuth = config.TLSClientAuthRequest
+	c.CertVerifier.Listener = true
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+
+	verifier := &testCertificateVerifier{}
+	newCertVerifier(c, verifier).applyListener(serverConfig)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	a.Nil(err)
+	defer ln.Close()
+	go acceptHandshakes(ln)
+
+	clientCert, err := tls.LoadX509KeyPair(bundle.ClientCert.Name(), bundle.ClientKey.Name())
+	a.Nil(err)
+	clientConfig := &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}, MaxVersion: tls.VersionTLS12}
+
+	// the certificate is rejected by the verifier
+	_, err = tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	a.NotNil(err)
+	a.Len(verifier.requests, 1)
+	a.Equal(apis.CertificateSideListener, verifier.requests[0].Side)
+	a.Equal(clientCert.Certificate[0], verifier.requests[0].RawCerts[0])
+	a.NotEmpty(verifier.requests[0].VerifiedChain)
+
+	verifier.success = true
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	a.Nil(err)
+	conn.Close()
+
+	// clients without certificates are not verified
+	conn, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	a.Nil(err)
+	conn.Close()
+	a.Len(verifier.requests, 2)
+}
+
+func TestCertVerifierBroker(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	a.Nil(err)
+	defer ln.Close()
+	go acceptHandshakes(ln)
+
+	c.Kafka.TLS.InsecureSkipVerify = true
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+
+	verifier := &testCertificateVerifier{}
+	a.Nil(newCertVerifier(c, nil))
+	a.True(clientConfig == newCertVerifier(c, verifier).applyBroker(clientConfig))
+
+	c.CertVerifier.Broker = true
+	verifiedConfig := newCertVerifier(c, verifier).applyBroker(clientConfig)
+	a.Nil(clientConfig.VerifyPeerCertificate)
+
+	_, err = tls.Dial("tcp", ln.Addr().String(), verifiedConfig)
+	a.NotNil(err)
+	a.Len(verifier.requests, 1)
+	a.Equal(apis.CertificateSideBroker, verifier.requests[0].Side)
+	// the chain is not verified with InsecureSkipVerify
+	a.Empty(verifier.requests[0].VerifiedChain)
+	cert, err := x509.ParseCertificate(verifier.requests[0].RawCerts[0])
+	a.Nil(err)
+	a.Equal(certificateSubject(verifier.requests[0].RawCerts[0]), cert.Subject.String())
+
+	verifier.success = true
+	conn, err := tls.Dial("tcp", ln.Addr().String(), verifiedConfig)
+	a.Nil(err)
+	conn.Close()
+}
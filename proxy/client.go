@@ -1,12 +1,15 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -15,6 +18,10 @@ import (
 type Conn struct {
 	BrokerAddress   string
 	LocalConnection net.Conn
+	// principal derived from the peer credentials of a Unix socket connection, empty otherwise
+	PeerPrincipal string
+	// the client connected with TLS, the local connection can be a wrapper of the TLS connection e.g. replaying the peeked bytes
+	TLS bool
 }
 
 // Client is a type to handle connecting to a Server. All fields are required
@@ -34,11 +41,28 @@ type Client struct {
 	stopRun  chan struct{}
 	stopOnce sync.Once
 
+	// cancelled on Close to abort in-flight dials and requests
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	saslAuthByProxy SASLAuthByProxy
 	authClient      *AuthClient
+	// nil if all brokers use the TLS and SASL settings
+	securityProtocols *securityProtocols
+
+	// nil if the upstream cluster is not switched
+	upstream *UpstreamSwitch
+	// nil if no bootstrap endpoints are configured
+	bootstrap *bootstrapBalancer
+	// nil if the advertised host does not depend on the client network
+	racks *rackAdvertisedHosts
+	// nil if the client IPs are not resolved to the Kubernetes pods
+	pods *podResolver
+	// nil if the load is not limited
+	loadShedder *loadShedder
 }
 
-func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.NetAddressMappingFunc, localPasswordAuthenticator apis.PasswordAuthenticator, localTokenAuthenticator apis.TokenInfo, saslTokenProvider apis.TokenProvider, gatewayTokenProvider apis.TokenProvider, gatewayTokenInfo apis.TokenInfo) (*Client, error) {
+func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.NetAddressMappingFunc, localPasswordAuthenticator apis.PasswordAuthenticator, localTokenAuthenticator apis.TokenInfo, localScramCredentialStore apis.ScramCredentialStore, saslTokenProvider apis.TokenProvider, gatewayTokenProvider apis.TokenProvider, gatewayTokenInfo apis.TokenInfo) (*Client, error) {
 	tlsConfig, err := newTLSClientConfig(c)
 	if err != nil {
 		return nil, err
@@ -47,6 +71,10 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 	if err != nil {
 		return nil, err
 	}
+	securityProtocols, err := newSecurityProtocols(c)
+	if err != nil {
+		return nil, err
+	}
 	tcpConnOptions := TCPConnOptions{
 		KeepAlive:       c.Kafka.KeepAlive,
 		WriteBufferSize: c.Kafka.ConnectionWriteBufferSize,
@@ -60,8 +88,157 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 			forbiddenApiKeys[int16(apiKey)] = struct{}{}
 		}
 	}
-	if c.Auth.Local.Enable && (localPasswordAuthenticator == nil && localTokenAuthenticator == nil) {
-		return nil, errors.New("Auth.Local.Enable is enabled but passwordAuthenticator and localTokenAuthenticator are nil")
+	if c.Kafka.ReadOnly {
+		logrus.Warnf("Read-only mode: Kafka operations for Api Keys %v will be forbidden.", readOnlyForbiddenApiKeys)
+		for _, apiKey := range readOnlyForbiddenApiKeys {
+			forbiddenApiKeys[apiKey] = struct{}{}
+		}
+	}
+	forbiddenApiVersions, err := newForbiddenApiVersions(c.Kafka.ForbiddenApiVersions)
+	if err != nil {
+		return nil, err
+	}
+	if len(forbiddenApiVersions) != 0 {
+		logrus.Warnf("Kafka operations for Api Versions %v will be forbidden.", c.Kafka.ForbiddenApiVersions)
+	}
+	clientIDPolicy, err := NewClientIDPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+	schemaValidator, err := newSchemaValidator(c)
+	if err != nil {
+		return nil, err
+	}
+	compressionPolicy, err := newCompressionPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+	recompression, err := newRecompression(c)
+	if err != nil {
+		return nil, err
+	}
+	recordStats, err := newRecordStats(c)
+	if err != nil {
+		return nil, err
+	}
+	topicMetrics, err := newTopicMetrics(c)
+	if err != nil {
+		return nil, err
+	}
+	transactionPolicy, err := newTransactionPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+	faultInjector, err := NewFaultInjector(c)
+	if err != nil {
+		return nil, err
+	}
+	maintenance, err := NewMaintenance(c)
+	if err != nil {
+		return nil, err
+	}
+	capture, err := newCapture(c)
+	if err != nil {
+		return nil, err
+	}
+	mirror, err := newMirror(c)
+	if err != nil {
+		return nil, err
+	}
+	ackSpoofing, err := newAckSpoofing(c)
+	if err != nil {
+		return nil, err
+	}
+	outageBuffer, err := newOutageBuffer(c)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := NewUpstreamSwitch(c)
+	if err != nil {
+		return nil, err
+	}
+	bootstrap, err := newBootstrapBalancer(c)
+	if err != nil {
+		return nil, err
+	}
+	egress, err := newEgressShaper(c)
+	if err != nil {
+		return nil, err
+	}
+	scheduler, err := newScheduler(c)
+	if err != nil {
+		return nil, err
+	}
+	eventLoop, err := newEventLoop(c)
+	if err != nil {
+		return nil, err
+	}
+	opa := newOPAAuthorizer(c)
+	dryRun := newPolicyDryRun(c)
+	if transactionPolicy != nil {
+		transactionPolicy.dryRun = dryRun
+	}
+	if opa != nil {
+		opa.dryRun = dryRun
+	}
+	if egress != nil {
+		egress.dryRun = dryRun
+	}
+	racks, err := newRackAdvertisedHosts(c)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := newPodResolver(c)
+	if err != nil {
+		return nil, err
+	}
+	recordFields, err := newRecordFieldsTransformer(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Auth.Local.Enable && c.Auth.Local.ScramCredentialsFile != "" {
+		credentials, err := config.LoadScramCredentials(c.Auth.Local.ScramCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		if localScramCredentialStore, err = NewScramFileStore(credentials); err != nil {
+			return nil, err
+		}
+	}
+	if c.Auth.Local.Enable && (localPasswordAuthenticator == nil && localTokenAuthenticator == nil && localScramCredentialStore == nil) {
+		return nil, errors.New("Auth.Local.Enable is enabled but passwordAuthenticator, localTokenAuthenticator and localScramCredentialStore are nil")
+	}
+	var localScramAuthenticators []*LocalSaslScram
+	if c.Auth.Local.Enable && localScramCredentialStore != nil {
+		for _, mechanism := range []string{config.SASLScramSHA256, config.SASLScramSHA512} {
+			scram, err := NewLocalSaslScram(mechanism, localScramCredentialStore)
+			if err != nil {
+				return nil, err
+			}
+			localScramAuthenticators = append(localScramAuthenticators, scram)
+		}
+	}
+	revocations, err := NewRevocations(c)
+	if err != nil {
+		return nil, err
+	}
+	rejection := newRejectionPolicy(c)
+	localSasl := NewLocalSasl(LocalSaslParams{
+		enabled:               c.Auth.Local.Enable,
+		timeout:               c.Auth.Local.Timeout,
+		mechanisms:            c.LocalSASLMechanisms(),
+		passwordAuthenticator: localPasswordAuthenticator,
+		tokenAuthenticator:    localTokenAuthenticator,
+		scramAuthenticators:   localScramAuthenticators,
+		revocations:           revocations,
+		downgradeProtection:   c.Auth.Local.DowngradeProtection,
+		rejection:             rejection,
+	})
+	if c.Auth.Local.Enable {
+		if len(localSasl.mechanisms) != len(c.LocalSASLMechanisms()) {
+			return nil, errors.Errorf("Auth.Local mechanisms %v are enabled, but only %v have a verifier", c.LocalSASLMechanisms(), localSasl.mechanisms)
+		}
+		logrus.Infof("Local SASL authentication with mechanisms %v", localSasl.mechanisms)
 	}
 
 	if c.Auth.Gateway.Client.Enable && gatewayTokenProvider == nil {
@@ -70,13 +247,17 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 	if c.Auth.Gateway.Server.Enable && gatewayTokenInfo == nil {
 		return nil, errors.New("Auth.Gateway.Server.Enable is enabled but tokenInfo is nil")
 	}
+	saslExchange := &saslExchange{
+		clientID:            c.Kafka.ClientID,
+		version:             c.Kafka.SASL.Version,
+		handshakeTimeout:    c.Kafka.SASL.HandshakeTimeout,
+		authenticateTimeout: c.Kafka.SASL.AuthenticateTimeout,
+	}
 	var saslAuthByProxy SASLAuthByProxy
 	if c.Kafka.SASL.Plugin.Enable {
 		if c.Kafka.SASL.Plugin.Mechanism == SASLOAuthBearer && saslTokenProvider != nil {
 			saslAuthByProxy = &SASLOAuthBearerAuth{
-				clientID:      c.Kafka.ClientID,
-				writeTimeout:  c.Kafka.WriteTimeout,
-				readTimeout:   c.Kafka.ReadTimeout,
+				exchange:      saslExchange,
 				tokenProvider: saslTokenProvider,
 			}
 		} else {
@@ -85,16 +266,21 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 
 	} else {
 		saslAuthByProxy = &SASLPlainAuth{
-			clientID:     c.Kafka.ClientID,
-			writeTimeout: c.Kafka.WriteTimeout,
-			readTimeout:  c.Kafka.ReadTimeout,
-			username:     c.Kafka.SASL.Username,
-			password:     c.Kafka.SASL.Password,
+			exchange: saslExchange,
+			username: c.Kafka.SASL.Username,
+			password: c.Kafka.SASL.Password,
 		}
 	}
 
-	return &Client{conns: conns, config: c, dialer: dialer, tcpConnOptions: tcpConnOptions, stopRun: make(chan struct{}, 1),
-		saslAuthByProxy: saslAuthByProxy,
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Client{conns: conns, config: c, dialer: dialer, tcpConnOptions: tcpConnOptions, stopRun: make(chan struct{}, 1), ctx: ctx, cancel: cancel,
+		saslAuthByProxy:   saslAuthByProxy,
+		securityProtocols: securityProtocols,
+		upstream:          upstream,
+		bootstrap:         bootstrap,
+		racks:             racks,
+		pods:              pods,
 		authClient: &AuthClient{
 			enabled:       c.Auth.Gateway.Client.Enable,
 			magic:         c.Auth.Gateway.Client.Magic,
@@ -104,17 +290,13 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 		},
 		processorConfig: ProcessorConfig{
 			MaxOpenRequests:       c.Kafka.MaxOpenRequests,
-			NetAddressMappingFunc: netAddressMappingFunc,
+			MaxInFlightRequests:   c.Kafka.MaxInFlightRequests,
+			NetAddressMappingFunc: upstream.netAddressMappingFunc(netAddressMappingFunc),
 			RequestBufferSize:     c.Proxy.RequestBufferSize,
 			ResponseBufferSize:    c.Proxy.ResponseBufferSize,
 			ReadTimeout:           c.Kafka.ReadTimeout,
 			WriteTimeout:          c.Kafka.WriteTimeout,
-			LocalSasl: NewLocalSasl(LocalSaslParams{
-				enabled:               c.Auth.Local.Enable,
-				timeout:               c.Auth.Local.Timeout,
-				passwordAuthenticator: localPasswordAuthenticator,
-				tokenAuthenticator:    localTokenAuthenticator,
-			}),
+			LocalSasl:             localSasl,
 			AuthServer: &AuthServer{
 				enabled:   c.Auth.Gateway.Server.Enable,
 				magic:     c.Auth.Gateway.Server.Magic,
@@ -122,52 +304,140 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 				timeout:   c.Auth.Gateway.Server.Timeout,
 				tokenInfo: gatewayTokenInfo,
 			},
-			ForbiddenApiKeys: forbiddenApiKeys,
+			ForbiddenApiKeys:     forbiddenApiKeys,
+			ForbiddenApiVersions: forbiddenApiVersions,
+			Rewriter:             newRewriter(c),
+			SchemaValidator:      schemaValidator,
+			RecordTransform:      newRecordTransform(c.Compression.MaxDecompressedSize, recordFields),
+			CompressionPolicy:    compressionPolicy,
+			Recompression:        recompression,
+			RecordStats:          recordStats,
+			TopicMetrics:         topicMetrics,
+			TransactionPolicy:    transactionPolicy,
+			OPA:                  opa,
+			FaultInjector:        faultInjector,
+			Maintenance:          maintenance,
+			Capture:              capture,
+			Egress:               egress,
+			Mirror:               mirror,
+			AckSpoofing:          ackSpoofing,
+			OutageBuffer:         outageBuffer,
+			ClientIDPolicy:       clientIDPolicy,
+			Shutdown:             newGracefulShutdown(c),
+			BrokerErrors:         newBrokerErrors(c),
+			SlowRequests:         newSlowRequests(c),
+			CorrelationLog:       newCorrelationLog(c),
+			MetadataCache:        newMetadataCache(c),
+			ApiVersionsCache:     newApiVersionsCache(c),
+			DryRun:               dryRun,
+			TopTalkers:           NewTopTalkers(c),
+			SLO:                  NewSLO(c),
+			ClientSoftware:       newClientSoftware(c),
+			Telemetry:            newClientTelemetry(c),
+			ParseErrors:          newParseErrorPolicy(c),
+			Rejection:            rejection,
+			Scheduler:            scheduler,
+			ResponseSpill:        newResponseSpill(c),
+			EventLoop:            eventLoop,
 		}}, nil
 }
 
 func newDialer(c *config.Config, tlsConfig *tls.Config) (Dialer, error) {
+	rawDialer, err := newRawDialer(c)
+	if err != nil {
+		return nil, err
+	}
+	if rawDialer, err = newDialOptionsDialer(c, rawDialer); err != nil {
+		return nil, err
+	}
+	return newTLSDialerIfEnabled(c, tlsConfig, rawDialer)
+}
+
+func newRawDialer(c *config.Config) (Dialer, error) {
+	resolver, err := newResolverIfEnabled(c)
+	if err != nil {
+		return nil, err
+	}
+	localAddr, err := newLocalAddr(c.Kafka.LocalAddress)
+	if err != nil {
+		return nil, err
+	}
 	directDialer := directDialer{
 		dialTimeout: c.Kafka.DialTimeout,
 		keepAlive:   c.Kafka.KeepAlive,
+		resolver:    resolver,
+		localAddr:   localAddr,
 	}
 
-	var rawDialer Dialer
 	if c.ForwardProxy.Url != "" {
 		switch c.ForwardProxy.Scheme {
-		case "socks5":
-			logrus.Infof("Kafka clients will connect through the SOCKS5 proxy %s", c.ForwardProxy.Address)
-			rawDialer = &socks5Dialer{
-				directDialer: directDialer,
-				proxyNetwork: "tcp",
-				proxyAddr:    c.ForwardProxy.Address,
-				username:     c.ForwardProxy.Username,
-				password:     c.ForwardProxy.Password,
+		case "socks5", "socks5h":
+			if c.ForwardProxy.Socks5Bind {
+				logrus.Infof("Kafka clients will connect through the SOCKS5 proxy %s using BIND", c.ForwardProxy.Address)
+				return &socks5BindDialer{
+					directDialer: directDialer,
+					proxyNetwork: "tcp",
+					proxyAddr:    c.ForwardProxy.Address,
+					username:     c.ForwardProxy.Username,
+					password:     c.ForwardProxy.Password,
+					bindTimeout:  c.ForwardProxy.Socks5BindTimeout,
+					notifyUrl:    c.ForwardProxy.Socks5BindNotifyUrl,
+					httpClient:   &http.Client{Timeout: c.Kafka.DialTimeout},
+				}, nil
+			}
+			remoteResolve := c.ForwardProxy.Scheme == "socks5h"
+			if remoteResolve {
+				logrus.Infof("Kafka clients will connect through the SOCKS5 proxy %s, broker names are resolved by the proxy", c.ForwardProxy.Address)
+			} else {
+				logrus.Infof("Kafka clients will connect through the SOCKS5 proxy %s", c.ForwardProxy.Address)
 			}
+			return &socks5Dialer{
+				directDialer:  directDialer,
+				proxyNetwork:  "tcp",
+				proxyAddr:     c.ForwardProxy.Address,
+				username:      c.ForwardProxy.Username,
+				password:      c.ForwardProxy.Password,
+				remoteResolve: remoteResolve,
+			}, nil
 		case "http":
 			logrus.Infof("Kafka clients will connect through the HTTP proxy %s using CONNECT", c.ForwardProxy.Address)
 
-			rawDialer = &httpProxy{
-				forwardDialer: directDialer,
+			return &httpProxy{
+				forwardDialer: forwardProxyDialer{dialer: directDialer},
 				network:       "tcp",
 				hostPort:      c.ForwardProxy.Address,
 				username:      c.ForwardProxy.Username,
 				password:      c.ForwardProxy.Password,
-			}
+			}, nil
 		default:
-			return nil, errors.New("Only http or socks5 proxy is supported")
+			return nil, errors.New("Only http, socks5 or socks5h proxy is supported")
 		}
-	} else {
-		rawDialer = directDialer
 	}
-	if c.Kafka.TLS.Enable {
+	return directDialer, nil
+}
+
+func newTLSDialerIfEnabled(c *config.Config, tlsConfig *tls.Config, rawDialer Dialer) (Dialer, error) {
+	protocols, err := newSecurityProtocols(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Kafka.TLS.Enable || protocols != nil {
 		if tlsConfig == nil {
 			return nil, errors.New("tlsConfig must not be nil")
 		}
+		rootCAs, err := newBrokerRootCAs(c)
+		if err != nil {
+			return nil, err
+		}
 		tlsDialer := tlsDialer{
-			timeout:   c.Kafka.DialTimeout,
-			rawDialer: rawDialer,
-			config:    tlsConfig,
+			timeout:      c.Kafka.DialTimeout,
+			rawDialer:    rawDialer,
+			config:       tlsConfig,
+			alpnRequired: c.Kafka.TLS.ALPNRequired,
+			rootCAs:      rootCAs,
+		}
+		if protocols != nil {
+			return securityProtocolDialer{protocols: protocols, tlsEnabled: c.Kafka.TLS.Enable, rawDialer: rawDialer, tlsDialer: tlsDialer}, nil
 		}
 		return tlsDialer, nil
 	}
@@ -177,11 +447,24 @@ func newDialer(c *config.Config, tlsConfig *tls.Config) (Dialer, error) {
 // Run causes the client to start waiting for new connections to connSrc and
 // proxy them to the destination instance. It blocks until connSrc is closed.
 func (c *Client) Run(connSrc <-chan Conn) error {
+	if mirror := c.processorConfig.Mirror; mirror != nil {
+		go withRecover(func() { mirror.run(c.ctx.Done()) })
+	}
+	if buffer := c.processorConfig.OutageBuffer; buffer != nil {
+		go withRecover(func() {
+			buffer.run(c.ctx.Done(), func(address string) (net.Conn, error) {
+				conn, _, err := c.bootstrap.dial(c.ctx, address, func(address string) (net.Conn, error) {
+					return c.DialAndAuth(c.ctx, address)
+				})
+				return conn, err
+			})
+		})
+	}
 STOP:
 	for {
 		select {
 		case conn := <-connSrc:
-			go withRecover(func() { c.handleConn(conn) })
+			go withConnectionRecover(panicGoroutineConnection, conn.BrokerAddress, func() { c.handleConn(conn) }, conn.LocalConnection)
 		case <-c.stopRun:
 			break STOP
 		}
@@ -200,15 +483,35 @@ STOP:
 func (c *Client) Close() {
 	c.stopOnce.Do(func() {
 		close(c.stopRun)
+		c.cancel()
 	})
 }
 
 func (c *Client) handleConn(conn Conn) {
 	proxyConnectionsTotal.WithLabelValues(conn.BrokerAddress).Inc()
 
-	server, err := c.DialAndAuth(conn.BrokerAddress)
+	cluster, brokerAddress, err := c.upstream.routeConn(&conn)
+	if err != nil {
+		logrus.Infof("couldn't route connection to %s: %v", conn.BrokerAddress, err)
+		_ = conn.LocalConnection.Close()
+		return
+	}
+	routedAddress := brokerAddress
+	server, brokerAddress, err := c.bootstrap.dial(c.ctx, brokerAddress, func(address string) (net.Conn, error) {
+		return c.DialAndAuth(c.ctx, address)
+	})
+	if c.ctx.Err() == nil {
+		c.processorConfig.OutageBuffer.observeDial(routedAddress, err)
+	}
 	if err != nil {
-		logrus.Infof("couldn't connect to %s: %v", conn.BrokerAddress, err)
+		if isForwardProxyDialError(err) {
+			logrus.Infof("couldn't connect to %s, the forward proxy is unreachable: %v", brokerAddress, err)
+		} else {
+			logrus.Infof("couldn't connect to %s: %v", brokerAddress, err)
+		}
+		if c.processorConfig.OutageBuffer.serve(c.ctx, conn.LocalConnection, routedAddress) {
+			return
+		}
 		_ = conn.LocalConnection.Close()
 		return
 	}
@@ -217,31 +520,76 @@ func (c *Client) handleConn(conn Conn) {
 			logrus.Infof("WARNING: Error while setting TCP options for kafka connection %s on %v: %v", conn.BrokerAddress, server.LocalAddr(), err)
 		}
 	}
+	// all closes of the connections go through the parkable ones
+	server = c.processorConfig.EventLoop.wrap(server)
+	conn.LocalConnection = c.processorConfig.EventLoop.wrap(conn.LocalConnection)
 	c.conns.Add(conn.BrokerAddress, conn.LocalConnection)
+	c.upstream.add(cluster, conn.BrokerAddress, conn.LocalConnection)
 	localDesc := "local connection on " + conn.LocalConnection.LocalAddr().String() + " from " + conn.LocalConnection.RemoteAddr().String() + " (" + conn.BrokerAddress + ")"
-	copyThenClose(c.processorConfig, server, conn.LocalConnection, conn.BrokerAddress, conn.BrokerAddress, localDesc)
-	if err := c.conns.Remove(conn.BrokerAddress, conn.LocalConnection); err != nil {
-		logrus.Info(err)
+	if pod := c.pods.lookup(conn.LocalConnection.RemoteAddr()); pod != nil {
+		localDesc += " of pod " + pod.String()
+		proxyPodConnectionsTotal.WithLabelValues(conn.BrokerAddress, pod.namespace, pod.app).Inc()
+		logrus.WithFields(logrus.Fields{"broker": conn.BrokerAddress, "client": conn.LocalConnection.RemoteAddr().String(), "pod": pod.name, "namespace": pod.namespace, "app": pod.app}).Infof("Pod %s connected to %s", pod, conn.BrokerAddress)
 	}
+	processorConfig := c.processorConfig
+	processorConfig.NetAddressMappingFunc = c.racks.netAddressMappingFunc(conn.LocalConnection.RemoteAddr(), processorConfig.NetAddressMappingFunc)
+	processorConfig.NetAddressMappingFunc = processorConfig.Shutdown.netAddressMappingFunc(processorConfig.NetAddressMappingFunc)
+	processorConfig.NetAddressMappingFunc = c.loadShedder.netAddressMappingFunc(processorConfig.NetAddressMappingFunc)
+	processorConfig.PeerPrincipal = conn.PeerPrincipal
+	processorConfig.LocalSasl = processorConfig.LocalSasl.forConnection(conn.TLS)
+	processorConfig.Recompression = processorConfig.Recompression.forClient(conn.LocalConnection.RemoteAddr())
+	processorConfig.Priority = processorConfig.Scheduler.newSession(conn.LocalConnection.LocalAddr().String())
+	processorConfig.Talker = processorConfig.TopTalkers.register(conn.LocalConnection.RemoteAddr().String(), conn.BrokerAddress)
+	processorConfig.Fingerprint = processorConfig.ClientSoftware.register(conn.BrokerAddress)
+	processorConfig.SLOSession = processorConfig.SLO.register()
+	processorConfig.Correlations = processorConfig.CorrelationLog.newSession(conn.LocalConnection.RemoteAddr().String(), conn.BrokerAddress)
+	shutdown, loadShedder := processorConfig.Shutdown, c.loadShedder
+	processorConfig.MetadataCacheSession = processorConfig.MetadataCache.newSession(cluster+"/"+c.racks.rackName(conn.LocalConnection.RemoteAddr()), func() bool {
+		return shutdown.redirects() || loadShedder.redirects()
+	})
+	processorConfig.ApiVersionsCacheSession = processorConfig.ApiVersionsCache.newSession(cluster)
+	// returns when the loops are parked by the event loop before the connection is closed
+	copyThenClose(c.ctx, processorConfig, server, conn.LocalConnection, conn.BrokerAddress, brokerAddress, localDesc, func() {
+		processorConfig.TopTalkers.unregister(processorConfig.Talker)
+		processorConfig.ClientSoftware.unregister(processorConfig.Fingerprint)
+		processorConfig.CorrelationLog.close(processorConfig.Correlations)
+		processorConfig.SLO.unregister(processorConfig.SLOSession)
+		c.upstream.remove(cluster, conn.BrokerAddress, conn.LocalConnection)
+		if err := c.conns.Remove(conn.BrokerAddress, conn.LocalConnection); err != nil {
+			logrus.Info(err)
+		}
+	})
 }
 
-func (c *Client) DialAndAuth(brokerAddress string) (net.Conn, error) {
-	conn, err := c.dialer.Dial("tcp", brokerAddress)
+func (c *Client) DialAndAuth(ctx context.Context, brokerAddress string) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(ctx, "tcp", brokerAddress)
 	if err != nil {
+		if ctx.Err() == nil {
+			proxyDialErrorsTotal.WithLabelValues(dialErrorTarget(err)).Inc()
+		}
 		return nil, err
 	}
 	if err := conn.SetDeadline(time.Time{}); err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
-	err = c.auth(conn)
+	stop := closeOnDone(ctx, conn)
+	err = c.auth(conn, brokerAddress)
+	stop()
 	if err != nil {
+		if saslErr, ok := err.(*upstreamSASLError); ok && saslErr.kafkaErr != protocol.ErrNoError {
+			c.processorConfig.BrokerErrors.record(brokerAddress, saslErr.apiKey, saslErr.kafkaErr, 1)
+		}
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
 	return conn, nil
 }
 
-func (c *Client) auth(conn net.Conn) error {
+func (c *Client) auth(conn net.Conn, brokerAddress string) error {
 	if c.config.Auth.Gateway.Client.Enable {
 		if err := c.authClient.sendAndReceiveGatewayAuth(conn); err != nil {
 			_ = conn.Close()
@@ -252,10 +600,13 @@ func (c *Client) auth(conn net.Conn) error {
 			return err
 		}
 	}
-	if c.config.Kafka.SASL.Enable {
+	if c.securityProtocols.sasl(brokerAddress, c.config.Kafka.SASL.Enable) {
 		err := c.saslAuthByProxy.sendAndReceiveSASLAuth(conn)
 		if err != nil {
 			_ = conn.Close()
+			if saslErr, ok := err.(*upstreamSASLError); ok {
+				logrus.WithFields(logrus.Fields{"broker": conn.RemoteAddr().String(), "mechanism": saslErr.mechanism, "reason": saslErr.reason}).Warnf("Upstream SASL authentication failed: %v", saslErr.err)
+			}
 			return err
 		}
 		if err := conn.SetDeadline(time.Time{}); err != nil {
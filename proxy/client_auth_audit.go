@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	unauthenticatedReasonNoCertificate      = "no_certificate"
+	unauthenticatedReasonInvalidCertificate = "invalid_certificate"
+)
+
+// clientAuthAudit logs the clients accepted without a valid certificate by the warn-only client auth policies,
+// so the clients which would be rejected by the require policy are known before mTLS is enforced
+type clientAuthAudit struct {
+	clientAuth string
+	clientCAs  *x509.CertPool
+}
+
+// newClientAuthAudit returns nil if the client auth policy of the listener rejects or ignores the clients without valid certificate
+func newClientAuthAudit(opts config.ListenerTLS, tlsConfig *tls.Config) *clientAuthAudit {
+	if tlsConfig == nil {
+		return nil
+	}
+	switch opts.ClientAuth {
+	case config.TLSClientAuthRequestAny, config.TLSClientAuthWarn:
+		return &clientAuthAudit{clientAuth: opts.ClientAuth, clientCAs: tlsConfig.ClientCAs}
+	default:
+		return nil
+	}
+}
+
+// check logs the client of the established connection if it has no certificate or the certificate is not signed by the client CAs
+func (a *clientAuthAudit) check(conn *tls.Conn, listenerAddress string) {
+	if a == nil {
+		return
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		logrus.Warnf("Client %v on %v presented no certificate, it would be rejected if client auth was required", conn.RemoteAddr(), listenerAddress)
+		proxyUnauthenticatedClientsTotal.WithLabelValues(listenerAddress, unauthenticatedReasonNoCertificate).Inc()
+		return
+	}
+	// certificates given with the warn policy are verified at handshake
+	if a.clientAuth != config.TLSClientAuthRequestAny || a.clientCAs == nil {
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: a.clientCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		logrus.Warnf("Client %v on %v presented invalid certificate %s, it would be rejected if client auth was required: %v", conn.RemoteAddr(), listenerAddress, certs[0].Subject, err)
+		proxyUnauthenticatedClientsTotal.WithLabelValues(listenerAddress, unauthenticatedReasonInvalidCertificate).Inc()
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestClientAuthAuditPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	otherBundle := NewCertsBundle()
+	defer otherBundle.Close()
+
+	clientCert, err := tls.LoadX509KeyPair(bundle.ClientCert.Name(), bundle.ClientKey.Name())
+	a.Nil(err)
+	otherClientCert, err := tls.LoadX509KeyPair(otherBundle.ClientCert.Name(), otherBundle.ClientKey.Name())
+	a.Nil(err)
+
+	for _, clientAuth := range []string{config.TLSClientAuthRequestAny, config.TLSClientAuthWarn} {
+		opts := config.ListenerTLS{CertFile: bundle.ServerCert.Name(), KeyFile: bundle.ServerKey.Name(), CAChainCertFile: bundle.CACert.Name(), ClientAuth: clientAuth}
+		tlsConfig, err := newListenerTLSConfig(opts)
+		a.Nil(err)
+		audit := newClientAuthAudit(opts, tlsConfig)
+		a.NotNil(audit)
+
+		dst := make(chan Conn, 1)
+		listenFunc := func(cfg config.ListenerConfig) (net.Listener, error) {
+			return tls.Listen("tcp", cfg.ListenerAddress, tlsConfig)
+		}
+		l, err := listenInstance(dst, config.ListenerConfig{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:0"}, TCPConnOptions{}, listenFunc, acceptOptions{clientAuthAudit: audit})
+		a.Nil(err)
+		listener := l.Addr().String()
+
+		dial := func(certs ...tls.Certificate) error {
+			// TLS 1.3 clients would learn about a rejected certificate only on the first read
+			conn, err := tls.Dial("tcp", listener, &tls.Config{InsecureSkipVerify: true, Certificates: certs, MaxVersion: tls.VersionTLS12})
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			(<-dst).LocalConnection.Close()
+			return nil
+		}
+		// the clients without certificate are accepted and logged
+		a.Nil(dial())
+		a.Equal(float64(1), counterOf(a, proxyUnauthenticatedClientsTotal, listener, unauthenticatedReasonNoCertificate))
+		a.Nil(dial(clientCert))
+		a.Equal(float64(1), counterOf(a, proxyUnauthenticatedClientsTotal, listener, unauthenticatedReasonNoCertificate))
+		a.Equal(float64(0), counterOf(a, proxyUnauthenticatedClientsTotal, listener, unauthenticatedReasonInvalidCertificate))
+
+		err = dial(otherClientCert)
+		if clientAuth == config.TLSClientAuthRequestAny {
+			// the certificate of an unknown CA is accepted and logged
+			a.Nil(err)
+			a.Equal(float64(1), counterOf(a, proxyUnauthenticatedClientsTotal, listener, unauthenticatedReasonInvalidCertificate))
+		} else {
+			// the given certificates are verified at handshake
+			a.NotNil(err)
+		}
+		l.Close()
+	}
+}
+
+func TestClientAuthAuditDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	tlsConfig := &tls.Config{}
+	a.Nil(newClientAuthAudit(config.ListenerTLS{ClientAuth: config.TLSClientAuthRequest}, tlsConfig))
+	a.Nil(newClientAuthAudit(config.ListenerTLS{ClientAuth: config.TLSClientAuthWarn}, nil))
+	a.NotNil(newClientAuthAudit(config.ListenerTLS{ClientAuth: config.TLSClientAuthRequestAny}, tlsConfig))
+}
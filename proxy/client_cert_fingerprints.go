@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientCertFingerprints allows only the client certificates with the enrolled SHA-256 fingerprints in addition to the CA verification,
+// e.g. when the devices hold certificates of a shared corporate CA. The fingerprints file is reloaded in the interval.
+type clientCertFingerprints struct {
+	path     string
+	interval time.Duration
+
+	lock         sync.RWMutex
+	fingerprints map[[sha256.Size]byte]struct{}
+	content      string
+}
+
+// newClientCertFingerprints returns nil if there is no fingerprints file, the file must be readable at start
+func newClientCertFingerprints(c *config.Config) (*clientCertFingerprints, error) {
+	path := c.Proxy.TLS.ListenerClientCertFingerprintsFile
+	if path == "" {
+		return nil, nil
+	}
+	f := &clientCertFingerprints{path: path, interval: c.Proxy.TLS.ListenerClientCertFingerprintsReloadInterval}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// apply requires a client certificate with an allowed fingerprint on the listener, the certificates are still verified by the client CAs if given
+func (f *clientCertFingerprints) apply(tlsConfig *tls.Config) {
+	if f == nil || tlsConfig == nil {
+		return
+	}
+	switch tlsConfig.ClientAuth {
+	case tls.NoClientCert, tls.RequestClientCert:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case tls.VerifyClientCertIfGiven:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig.VerifyPeerCertificate = chainVerifyPeerCertificate(f.verifyPeerCertificate, tlsConfig.VerifyPeerCertificate)
+}
+
+func (f *clientCertFingerprints) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("client certificate is required by the fingerprint allowlist")
+	}
+	if !f.allowed(rawCerts[0]) {
+		proxyClientCertNotAllowedTotal.Inc()
+		logrus.Warnf("Client certificate %s with fingerprint %x is not in the fingerprint allowlist", certificateSubject(rawCerts[0]), sha256.Sum256(rawCerts[0]))
+		return errors.Errorf("client certificate %s is not in the fingerprint allowlist", certificateSubject(rawCerts[0]))
+	}
+	return nil
+}
+
+// check rejects the resumed sessions of the certificates removed from the allowlist, the resumed handshakes don't verify the certificates
+func (f *clientCertFingerprints) check(conn *tls.Conn) error {
+	if f == nil {
+		return nil
+	}
+	state := conn.ConnectionState()
+	if !state.DidResume {
+		return nil
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("client certificate is required by the fingerprint allowlist")
+	}
+	if !f.allowed(state.PeerCertificates[0].Raw) {
+		proxyClientCertNotAllowedTotal.Inc()
+		return errors.Errorf("client certificate %s of the resumed session is not in the fingerprint allowlist", state.PeerCertificates[0].Subject)
+	}
+	return nil
+}
+
+func (f *clientCertFingerprints) allowed(rawCert []byte) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	_, ok := f.fingerprints[sha256.Sum256(rawCert)]
+	return ok
+}
+
+// reload reads the fingerprints file, the fingerprints are replaced only if the file is valid
+func (f *clientCertFingerprints) reload() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return errors.Wrap(err, "cannot read client certificate fingerprints")
+	}
+	content := string(data)
+	f.lock.RLock()
+	unchanged := f.fingerprints != nil && content == f.content
+	f.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	fingerprints, err := parseCertFingerprints(content)
+	if err != nil {
+		return errors.Wrapf(err, "invalid client certificate fingerprints file %s", f.path)
+	}
+	f.lock.Lock()
+	f.fingerprints, f.content = fingerprints, content
+	f.lock.Unlock()
+	logrus.Infof("Loaded %d client certificate fingerprints from %s", len(fingerprints), f.path)
+	return nil
+}
+
+// run reloads the fingerprints file in the interval until done is closed
+func (f *clientCertFingerprints) run(done <-chan struct{}) {
+	if f == nil || f.interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				logrus.Warnf("Reload of client certificate fingerprints failed, the previous fingerprints are used: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// parseCertFingerprints parses the hex encoded SHA-256 fingerprints one per line, optionally with colons as printed by
+// openssl x509 -fingerprint -sha256 and followed by a name of the device
+func parseCertFingerprints(value string) (map[[sha256.Size]byte]struct{}, error) {
+	fingerprints := make(map[[sha256.Size]byte]struct{})
+	for i, line := range strings.Split(value, "\n") {
+		if j := strings.Index(line, "Fingerprint="); j != -1 {
+			line = line[j+len("Fingerprint="):]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		data, err := hex.DecodeString(strings.Replace(fields[0], ":", "", -1))
+		if err != nil || len(data) != sha256.Size {
+			return nil, errors.Errorf("line %d must be a hex encoded SHA-256 fingerprint", i+1)
+		}
+		var fingerprint [sha256.Size]byte
+		copy(fingerprint[:], data)
+		fingerprints[fingerprint] = struct{}{}
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCertFingerprints(t *testing.T) {
+	a := assert.New(t)
+
+	fingerprint := sha256.Sum256([]byte("device"))
+	colons := strings.ToUpper(hex.EncodeToString(fingerprint[:1]))
+	for _, b := range fingerprint[1:] {
+		colons += fmt.Sprintf(":%02X", b)
+	}
+	fingerprints, err := parseCertFingerprints("# enrolled devices\n\n" + hex.EncodeToString(fingerprint[:]) + " device-1\nsha256 Fingerprint=" + colons + "\n")
+	a.Nil(err)
+	a.Len(fingerprints, 1)
+	a.Contains(fingerprints, fingerprint)
+
+	_, err = parseCertFingerprints("# enrolled devices\nabcd\n")
+	a.EqualError(err, "line 2 must be a hex encoded SHA-256 fingerprint")
+}
+
+func TestClientCertFingerprintsListener(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	clientCert, err := tls.LoadX509KeyPair(bundle.ClientCert.Name(), bundle.ClientKey.Name())
+	a.Nil(err)
+
+	// a certificate of the same CA which is not enrolled
+	caCert, err := tls.LoadX509KeyPair(bundle.CACert.Name(), bundle.CAKey.Name())
+	a.Nil(err)
+	otherCertFile, err := ioutil.TempFile("", "other-client-cert-")
+	a.Nil(err)
+	defer os.Remove(otherCertFile.Name())
+	otherKeyFile, err := ioutil.TempFile("", "other-client-key-")
+	a.Nil(err)
+	defer os.Remove(otherKeyFile.Name())
+	a.Nil(generateCert(&caCert, otherCertFile, otherKeyFile))
+	otherClientCert, err := tls.LoadX509KeyPair(otherCertFile.Name(), otherKeyFile.Name())
+	a.Nil(err)
+
+	fingerprintsFile, err := ioutil.TempFile("", "fingerprints-")
+	a.Nil(err)
+	defer os.Remove(fingerprintsFile.Name())
+	fingerprint := sha256.Sum256(clientCert.Certificate[0])
+	a.Nil(ioutil.WriteFile(fingerprintsFile.Name(), []byte(hex.EncodeToString(fingerprint[:])+" device-1\n"), 0600))
+
+	c := config.NewConfig()
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.CAChainCertFile = bundle.CACert.Name()
+	c.Proxy.TLS.ListenerClientCertFingerprintsFile = fingerprintsFile.Name()
+	tlsConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	fingerprints, err := newClientCertFingerprints(c)
+	a.Nil(err)
+	fingerprints.apply(tlsConfig)
+
+	dst := make(chan Conn, 1)
+	listenFunc := func(cfg config.ListenerConfig) (net.Listener, error) {
+		return tls.Listen("tcp", cfg.ListenerAddress, tlsConfig)
+	}
+	l, err := listenInstance(dst, config.ListenerConfig{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:0"}, TCPConnOptions{}, listenFunc, acceptOptions{clientCertFingerprints: fingerprints})
+	a.Nil(err)
+	defer l.Close()
+
+	dial := func(cert tls.Certificate, sessionCache tls.ClientSessionCache) (*tls.Conn, error) {
+		// TLS 1.3 clients would learn about a rejected certificate only on the first read
+		return tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12, ClientSessionCache: sessionCache})
+	}
+	sessionCache := tls.NewLRUClientSessionCache(1)
+	conn, err := dial(clientCert, sessionCache)
+	a.Nil(err)
+	(<-dst).LocalConnection.Close()
+	conn.Close()
+
+	_, err = dial(otherClientCert, nil)
+	a.NotNil(err)
+
+	// the resumed session of the removed certificate is rejected after the handshake
+	a.Nil(ioutil.WriteFile(fingerprintsFile.Name(), []byte("# no enrolled devices\n"), 0600))
+	a.Nil(fingerprints.reload())
+	conn, err = dial(clientCert, sessionCache)
+	a.Nil(err)
+	a.True(conn.ConnectionState().DidResume)
+	_, err = conn.Read(make([]byte, 1))
+	a.NotNil(err)
+	conn.Close()
+	a.Equal(float64(1), counterOf(a, proxyRejectedConnectionsTotal, l.Addr().String(), rejectReasonNotEnrolled))
+}
+
+func TestClientCertFingerprintsRequireCertificate(t *testing.T) {
+	a := assert.New(t)
+
+	f := &clientCertFingerprints{}
+	for clientAuth, expected := range map[tls.ClientAuthType]tls.ClientAuthType{
+		tls.NoClientCert:               tls.RequireAnyClientCert,
+		tls.RequestClientCert:          tls.RequireAnyClientCert,
+		tls.VerifyClientCertIfGiven:    tls.RequireAndVerifyClientCert,
+		tls.RequireAndVerifyClientCert: tls.RequireAndVerifyClientCert,
+	} {
+		tlsConfig := &tls.Config{ClientAuth: clientAuth}
+		f.apply(tlsConfig)
+		a.Equal(expected, tlsConfig.ClientAuth)
+		a.EqualError(tlsConfig.VerifyPeerCertificate(nil, nil), "client certificate is required by the fingerprint allowlist")
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const clientIDOtherLabel = "other"
+
+// ClientIDPolicy rejects or throttles requests based on the client id of the request header
+type ClientIDPolicy struct {
+	deny      []*regexp.Regexp
+	throttles []*clientIDThrottle
+	labels    *labelLimiter
+}
+
+// clientIDThrottle limits the requests of all connections with matching client ids
+type clientIDThrottle struct {
+	pattern *regexp.Regexp
+	lock    sync.Mutex
+	limiter *rateLimiter
+}
+
+// take consumes a token and returns how long the request has to wait, in a dry run the delay is not owed by the next requests
+func (t *clientIDThrottle) take(dryRun bool) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if dryRun {
+		return t.limiter.exceedsN(1)
+	}
+	return t.limiter.take()
+}
+
+// clientIDDecision is evaluated when the client id of the connection is set or changed
+type clientIDDecision struct {
+	denied   bool
+	throttle *clientIDThrottle
+	label    string
+}
+
+func NewClientIDPolicy(c *config.Config) (*ClientIDPolicy, error) {
+	policy := &ClientIDPolicy{labels: &labelLimiter{limit: c.ClientID.MetricsLabelLimit, values: make(map[string]struct{})}}
+	for _, v := range c.ClientID.Deny {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		policy.deny = append(policy.deny, pattern)
+	}
+	for _, v := range c.ClientID.Throttle {
+		pattern, rate, err := config.ParseClientIDThrottle(v)
+		if err != nil {
+			return nil, err
+		}
+		// allow one second of requests at once
+		burst := int(math.Ceil(rate))
+		policy.throttles = append(policy.throttles, &clientIDThrottle{pattern: pattern, limiter: newRateLimiter(rate, burst)})
+	}
+	if len(policy.deny) != 0 {
+		logrus.Warnf("Requests of client ids matching %v will be rejected.", c.ClientID.Deny)
+	}
+	if len(policy.throttles) != 0 {
+		logrus.Warnf("Requests of client ids matching %v will be throttled.", c.ClientID.Throttle)
+	}
+	return policy, nil
+}
+
+func (p *ClientIDPolicy) decide(clientID string) clientIDDecision {
+	if p == nil {
+		return clientIDDecision{label: clientIDOtherLabel}
+	}
+	decision := clientIDDecision{label: p.labels.get(clientID)}
+	for _, pattern := range p.deny {
+		if pattern.MatchString(clientID) {
+			// the throttle is evaluated too if the denial is a dry run
+			decision.denied = true
+			break
+		}
+	}
+	for _, throttle := range p.throttles {
+		if throttle.pattern.MatchString(clientID) {
+			decision.throttle = throttle
+			break
+		}
+	}
+	return decision
+}
+
+// labelLimiter guards the cardinality of the metrics labels
+type labelLimiter struct {
+	lock   sync.Mutex
+	limit  int
+	values map[string]struct{}
+}
+
+func (l *labelLimiter) get(value string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.values[value]; ok {
+		return value
+	}
+	if len(l.values) < l.limit {
+		l.values[value] = struct{}{}
+		return value
+	}
+	return clientIDOtherLabel
+}
+
+// checkClientID returns an error if the client id of the connection is denied
+func (ctx *RequestsLoopContext) checkClientID() error {
+	if !ctx.clientIDDecision.denied {
+		return nil
+	}
+	if ctx.dryRun.enabled(config.DryRunClientIDDeny) {
+		ctx.dryRun.deny(config.DryRunClientIDDeny, fmt.Sprintf("client id %q of principal %q", ctx.clientID, ctx.principal))
+		return nil
+	}
+	return fmt.Errorf("client id %q is denied", ctx.clientID)
+}
+
+// readRequestHeader reads CorrelationId => int32 and ClientId => nullable string following ApiKey and ApiVersion.
+// The returned bytes must be forwarded before the rest of the request.
+func (ctx *RequestsLoopContext) readRequestHeader(src io.Reader, requestKeyVersion *protocol.RequestKeyVersion) ([]byte, error) {
+	// request header v0 has no client id
+	if requestKeyVersion.ApiKey == apiKeyControlledShutdown && requestKeyVersion.ApiVersion == 0 {
+		return nil, nil
+	}
+	bodyLength := int(requestKeyVersion.Length - 4)
+	if bodyLength < 6 {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("request of length %d is too short", requestKeyVersion.Length)}
+	}
+	buf := ctx.headerBuf[:6]
+	if _, err := io.ReadFull(src, buf); err != nil {
+		return nil, err
+	}
+	clientIDLength := int(int16(binary.BigEndian.Uint16(buf[4:])))
+	if clientIDLength > 0 {
+		if 6+clientIDLength > bodyLength {
+			return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("client id of length %d exceeds the request", clientIDLength)}
+		}
+		if cap(buf) < 6+clientIDLength {
+			buf = append(buf, make([]byte, clientIDLength)...)
+			ctx.headerBuf = buf
+		}
+		buf = buf[:6+clientIDLength]
+		if _, err := io.ReadFull(src, buf[6:]); err != nil {
+			return nil, err
+		}
+	}
+	clientID := buf[6:]
+	if !ctx.clientIDSet || string(clientID) != ctx.clientID {
+		ctx.setClientID(string(clientID))
+	}
+	return buf, nil
+}
+
+func (ctx *RequestsLoopContext) setClientID(clientID string) {
+	if ctx.clientIDSet {
+		logrus.Infof("Client id of connection to %s changed from %q to %q", ctx.brokerAddress, ctx.clientID, clientID)
+	} else {
+		logrus.Infof("Client id of connection to %s is %q", ctx.brokerAddress, clientID)
+	}
+	ctx.clientID = clientID
+	ctx.clientIDSet = true
+	ctx.clientIDDecision = ctx.clientIDPolicy.decide(clientID)
+	ctx.egress.update(ctx.principal, clientID)
+	ctx.talker.update(ctx.principal, clientID)
+	ctx.priority.update(ctx.principal, clientID)
+}
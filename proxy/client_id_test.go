@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabelLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	l := &labelLimiter{limit: 2, values: make(map[string]struct{})}
+	a.Equal("a", l.get("a"))
+	a.Equal("b", l.get("b"))
+	a.Equal(clientIDOtherLabel, l.get("c"))
+	a.Equal("a", l.get("a"))
+}
+
+func TestProxyForwardsClientID(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	listenerAddress, stop := startTestProxy(a, newTestProxyConfig(broker.Addr()))
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	// client id longer than the initial header buffer
+	for i, clientID := range []string{"", "app-1", strings.Repeat("x", 300)} {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, int32(i), clientID, []byte{1, 2, 3}))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(int32(i), correlationID)
+		a.Equal([]byte{1, 2, 3}, body)
+	}
+}
+
+func TestProxyRejectsDeniedClientID(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.ClientID.Deny = []string{"^legacy-"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "app-1", []byte{1}))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "legacy-app", []byte{1}))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
+
+func TestProxyThrottlesClientID(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.ClientID.Throttle = []string{"^legacy-=20"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	// burst of 20 requests and 5 requests delayed by 50ms each
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, int32(i), "legacy-app", []byte{1}))
+		a.Nil(err)
+		_, _, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+	}
+	a.True(time.Since(start) >= 200*time.Millisecond)
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+)
+
+// unknownClientSoftware labels the clients which do not send the client software with ApiVersions before version 3
+const unknownClientSoftware = "unknown"
+
+// clientSoftware fingerprints the connections by the ApiVersions requests and the client software name and version
+// sent since ApiVersions version 3 (KIP-511), so the outdated clients can be found before the api versions are restricted
+type clientSoftware struct {
+	labels *labelLimiter
+}
+
+// newClientSoftware returns nil if the client software is not counted
+func newClientSoftware(c *config.Config) *clientSoftware {
+	if !c.ClientSoftware.MetricsEnable {
+		return nil
+	}
+	return &clientSoftware{labels: &labelLimiter{limit: c.ClientSoftware.MetricsLabelLimit, values: make(map[string]struct{})}}
+}
+
+// register returns the fingerprint of the client connection
+func (s *clientSoftware) register(brokerAddress string) *clientFingerprint {
+	if s == nil {
+		return nil
+	}
+	return &clientFingerprint{software: s, brokerAddress: brokerAddress}
+}
+
+// unregister removes the closed connection from the connections gauge
+func (s *clientSoftware) unregister(fingerprint *clientFingerprint) {
+	if s == nil || fingerprint == nil {
+		return
+	}
+	fingerprint.lock.Lock()
+	defer fingerprint.lock.Unlock()
+	if fingerprint.labels != nil {
+		proxyClientSoftwareConnections.WithLabelValues(fingerprint.labels...).Dec()
+	}
+	fingerprint.closed = true
+}
+
+// clientFingerprint is the last ApiVersions request of a client connection
+type clientFingerprint struct {
+	software      *clientSoftware
+	brokerAddress string
+
+	lock sync.Mutex
+	// software name, software version and ApiVersions version, nil before the first ApiVersions request
+	labels []string
+	closed bool
+}
+
+// inspects reports whether the request body must be decoded
+func (f *clientFingerprint) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return f != nil && requestKeyVersion.ApiKey == apiKeyApiApiVersions
+}
+
+// observe counts the ApiVersions request and moves the connection to its client software in the connections gauge
+func (f *clientFingerprint) observe(requestKeyVersion *protocol.RequestKeyVersion, req []byte) {
+	if f == nil {
+		return
+	}
+	name, version := unknownClientSoftware, unknownClientSoftware
+	software, err := protocol.DecodeApiVersionsRequestClientSoftware(requestKeyVersion.ApiVersion, req)
+	if err != nil {
+		logrus.Debugf("Client software of ApiVersions request version %d to %s cannot be decoded: %v", requestKeyVersion.ApiVersion, f.brokerAddress, err)
+	} else if software != nil {
+		name, version = software.Name, software.Version
+		// the name and the version are limited together
+		if f.software.labels.get(name+"/"+version) == clientIDOtherLabel {
+			name, version = clientIDOtherLabel, clientIDOtherLabel
+		}
+	}
+	labels := []string{name, version, strconv.Itoa(int(requestKeyVersion.ApiVersion))}
+	proxyApiVersionsRequestsTotal.WithLabelValues(labels...).Inc()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed || equalLabels(f.labels, labels) {
+		return
+	}
+	if f.labels != nil {
+		proxyClientSoftwareConnections.WithLabelValues(f.labels...).Dec()
+	}
+	proxyClientSoftwareConnections.WithLabelValues(labels...).Inc()
+	f.labels = labels
+	if software != nil {
+		logrus.Infof("Client software of connection to %s is %s %s, ApiVersions version %d", f.brokerAddress, software.Name, software.Version, requestKeyVersion.ApiVersion)
+	} else {
+		logrus.Infof("Client of connection to %s sent ApiVersions version %d without the client software", f.brokerAddress, requestKeyVersion.ApiVersion)
+	}
+}
+
+func equalLabels(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
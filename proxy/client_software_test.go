@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func gaugeOf(a *assert.Assertions, gauge *prometheus.GaugeVec, labels ...string) float64 {
+	m := &dto.Metric{}
+	a.Nil(gauge.WithLabelValues(labels...).(prometheus.Metric).Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func apiVersionsRequestBody(name string, version string) []byte {
+	body := []byte{0}
+	body = append(append(body, byte(len(name)+1)), name...)
+	body = append(append(body, byte(len(version)+1)), version...)
+	return append(body, 0)
+}
+
+func TestClientSoftware(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newClientSoftware(c))
+	c.ClientSoftware.MetricsEnable = true
+	c.ClientSoftware.MetricsLabelLimit = 1
+	software := newClientSoftware(c)
+
+	v3 := &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions, ApiVersion: 3}
+	v0 := &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions, ApiVersion: 0}
+	connection1 := software.register("kafka-1:9092")
+	connection2 := software.register("kafka-1:9092")
+	a.True(connection1.inspects(v3))
+	a.False(connection1.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyProduce, ApiVersion: 3}))
+
+	connection1.observe(v3, apiVersionsRequestBody("test-java", "3.6.1"))
+	connection1.observe(v3, apiVersionsRequestBody("test-java", "3.6.1"))
+	a.Equal(2.0, counterOf(a, proxyApiVersionsRequestsTotal, "test-java", "3.6.1", "3"))
+	a.Equal(1.0, gaugeOf(a, proxyClientSoftwareConnections, "test-java", "3.6.1", "3"))
+
+	// label limit
+	connection2.observe(v3, apiVersionsRequestBody("test-go", "1.0.0"))
+	a.Equal([]string{clientIDOtherLabel, clientIDOtherLabel, "3"}, connection2.labels)
+
+	// old clients and the connection moves to the new labels
+	connection1.observe(v0, nil)
+	a.Equal(0.0, gaugeOf(a, proxyClientSoftwareConnections, "test-java", "3.6.1", "3"))
+	a.Equal([]string{unknownClientSoftware, unknownClientSoftware, "0"}, connection1.labels)
+
+	before := gaugeOf(a, proxyClientSoftwareConnections, unknownClientSoftware, unknownClientSoftware, "0")
+	software.unregister(connection1)
+	a.Equal(before-1, gaugeOf(a, proxyClientSoftwareConnections, unknownClientSoftware, unknownClientSoftware, "0"))
+	// requests after the connection was closed
+	connection1.observe(v3, apiVersionsRequestBody("test-java", "3.6.1"))
+	a.Equal(0.0, gaugeOf(a, proxyClientSoftwareConnections, "test-java", "3.6.1", "3"))
+
+	// nil safe
+	var disabled *clientSoftware
+	a.Nil(disabled.register("kafka-1:9092"))
+	disabled.unregister(nil)
+	var disabledFingerprint *clientFingerprint
+	a.False(disabledFingerprint.inspects(v3))
+	disabledFingerprint.observe(v3, nil)
+}
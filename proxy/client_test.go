@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func startTestProxy(a *assert.Assertions, c *config.Config, opts ...Option) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	listenerAddress := l.Addr().String()
+	l.Close()
+
+	c.Proxy.BootstrapServers[0].ListenerAddress = listenerAddress
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = listenerAddress
+
+	p, err := New(c, opts...)
+	a.Nil(err)
+	go p.Run(context.Background())
+	return listenerAddress, p.Close
+}
+
+func newTestProxyConfig(brokerAddress string) *config.Config {
+	c := config.NewConfig()
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: brokerAddress}}
+	c.Proxy.DisableDynamicListeners = true
+	return c
+}
+
+func TestProxyRewritesMetadataAndForwardsProduce(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 3}})
+	a.Nil(err)
+	defer broker.Close()
+
+	listenerAddress, stop := startTestProxy(a, newTestProxyConfig(broker.Addr()))
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	for version := int16(0); version <= 7; version++ {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, version, int32(version), "test", kafkatest.MetadataRequestBody(version, []string{"test"})))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(int32(version), correlationID)
+
+		brokers, err := kafkatest.DecodeMetadataBrokers(version, body)
+		a.Nil(err)
+		a.Len(brokers, 1)
+		host, port, err := util.SplitHostPort(listenerAddress)
+		a.Nil(err)
+		a.Equal(kafkatest.BrokerAddress{NodeID: 1, Host: host, Port: port}, brokers[0])
+	}
+
+	payload := bytes.Repeat([]byte{1, 2, 3}, 10000)
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 100, "test", payload))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(100), correlationID)
+	a.Equal(payload, body)
+	a.Equal(8, broker.RequestCount(kafkatest.ApiKeyMetadata))
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
+
+func TestProxySASLPlainAuthentication(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Users: map[string]string{"alice": "secret"}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "secret"
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 7, "test", []byte("fetch")))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(7), correlationID)
+	a.Equal([]byte("fetch"), body)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeySaslHandshake))
+}
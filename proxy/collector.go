@@ -31,10 +31,314 @@ var (
 		[]string{"broker"}, nil,
 	)
 
+	proxySLORequests = prometheus.NewDesc(
+		"proxy_slo_requests",
+		"Number of requests within the rolling window",
+		[]string{"window"}, nil,
+	)
+	proxySLORatio = prometheus.NewDesc(
+		"proxy_slo_ratio",
+		"Ratio of the good requests within the rolling window, answered requests for the success SLI and requests answered within the latency threshold for the latency SLI",
+		[]string{"sli", "window"}, nil,
+	)
+	proxySLOBurnRate = prometheus.NewDesc(
+		"proxy_slo_burn_rate",
+		"Rate at which the error budget is consumed within the rolling window, 1 consumes the budget exactly within the SLO period",
+		[]string{"sli", "window"}, nil,
+	)
+	proxySLOObjective = prometheus.NewDesc(
+		"proxy_slo_objective_ratio",
+		"Objective of the SLIs",
+		nil, nil,
+	)
+
 	proxyLocalAuthTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{Name: "proxy_local_auth_total",
 			Help: "Total number of local auth requests sent"},
 		[]string{"success", "status"})
+
+	proxyRejectedConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_rejected_connections_total",
+			Help: "Total number of connections rejected by the listeners"},
+		[]string{"listener", "reason"})
+
+	proxyUnauthenticatedClientsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_unauthenticated_clients_total",
+			Help: "Total number of clients accepted without valid certificate by the warn-only client auth policies"},
+		[]string{"listener", "reason"})
+
+	proxyClientCertNotAllowedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_client_certificates_not_allowed_total",
+			Help: "Total number of client certificates rejected by the fingerprint allowlist"})
+
+	proxyPanicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_panics_total",
+			Help: "Total number of recovered panics by the goroutine, the connections of a panicked goroutine are closed"},
+		[]string{"goroutine"})
+
+	proxyAcceptThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_accept_throttled_total",
+			Help: "Total number of connections delayed by the accept rate limit"},
+		[]string{"listener"})
+
+	proxyClientIDRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_id_requests_total",
+			Help: "Total number of requests sent pro client id"},
+		[]string{"client_id"})
+
+	proxyClientIDThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_id_throttled_total",
+			Help: "Total number of requests delayed by the client id throttle"},
+		[]string{"client_id"})
+
+	proxySchemaValidationRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_schema_validation_rejected_total",
+			Help: "Total number of Produce requests rejected by the schema validation"},
+		[]string{"subject"})
+
+	proxySchemaRegistryErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_schema_registry_errors_total",
+			Help: "Total number of failed schema registry lookups"})
+
+	proxyCompressionPolicyRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_compression_policy_rejected_total",
+			Help: "Total number of Produce requests rejected by the compression policy"},
+		[]string{"reason"})
+
+	proxyRecompressionBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_recompression_bytes_total",
+			Help: "Total size of the recompressed Produce requests and Fetch responses"},
+		[]string{"direction", "size"})
+
+	proxyTransactionsRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_transactions_rejected_total",
+			Help: "Total number of transactional requests rejected by the transaction policy"},
+		[]string{"api_key"})
+
+	proxyFaultsInjectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_faults_injected_total",
+			Help: "Total number of injected faults"},
+		[]string{"fault"})
+	proxyMirrorRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_mirror_requests_total",
+			Help: "Total number of mirrored produce requests by the result"},
+		[]string{"result"})
+	proxyAckSpoofingRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_ack_spoofing_requests_total",
+			Help: "Total number of produce requests acknowledged by the proxy by the result of the forwarding, dropped and failed requests are lost"},
+		[]string{"broker", "result"})
+	proxyOutageBufferRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_outage_buffer_requests_total",
+			Help: "Total number of produce requests buffered by the proxy during the broker outages by the result, full requests were rejected, retried requests stay in the spool, failed requests are lost"},
+		[]string{"broker", "result"})
+	proxyOutageBufferBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_outage_buffer_bytes",
+			Help: "Size of the produce requests buffered by the proxy waiting to be replayed"})
+	proxyMetadataCacheRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_metadata_cache_requests_total",
+			Help: "Total number of metadata requests looked up in the cache of the proxy by the result, hits are answered by the proxy"},
+		[]string{"result"})
+	proxyApiVersionsCacheRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_api_versions_cache_requests_total",
+			Help: "Total number of api versions requests looked up in the cache of the proxy by the result, hits are answered by the proxy"},
+		[]string{"result"})
+	proxyUpstreamSwitchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_switches_total",
+			Help: "Total number of switches to the upstream cluster"},
+		[]string{"cluster"})
+	proxyUpstreamRoutedConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_routed_connections_total",
+			Help: "Total number of connections routed to the upstream cluster by a route of the client attribute"},
+		[]string{"cluster", "attribute"})
+	proxyUpstreamCanaryConnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_upstream_canary_connections_total",
+			Help: "Total number of connections of the canary client ids routed to the secondary cluster"})
+	proxyBootstrapEndpointFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_bootstrap_endpoint_failures_total",
+			Help: "Total number of failed connects to the bootstrap endpoints"},
+		[]string{"broker", "endpoint"})
+	proxyTopologyChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_topology_changes_total",
+			Help: "Total number of upstream brokers which were added, removed or moved and of retired dynamic listeners"},
+		[]string{"change"})
+	proxyTopologyRefreshErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_topology_refresh_errors_total",
+			Help: "Total number of failed background upstream metadata refreshes"})
+	proxyTopologyBrokers = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_topology_brokers",
+			Help: "Number of upstream brokers in the last refreshed metadata"})
+	proxyUnmappedBrokersTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_unmapped_brokers_total",
+			Help: "Total number of brokers in the responses without a mapping by the strategy"},
+		[]string{"broker", "strategy"})
+	proxyRackConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_rack_connections_total",
+			Help: "Total number of connections advertised the host of the client rack"},
+		[]string{"rack"})
+	proxyEgressShapedSecondsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_egress_shaped_seconds_total",
+			Help: "Total time the responses were delayed by the egress limit"},
+		[]string{"limit"})
+	proxyUpstreamSASLFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_sasl_failures_total",
+			Help: "Total number of failed SASL authentications to the brokers by mechanism and reason"},
+		[]string{"mechanism", "reason"})
+	proxyLocalSaslDowngradesRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_local_sasl_downgrades_rejected_total",
+			Help: "Total number of SASL handshakes without TLS refused by the downgrade protection by mechanism"},
+		[]string{"mechanism"})
+	proxySessionLimitsRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_session_limits_rejected_total",
+			Help: "Total number of connections and requests rejected by the session attributes of the authenticated users"},
+		[]string{"reason"})
+	proxySessionLimitsThrottledSecondsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_session_limits_throttled_seconds_total",
+			Help: "Total time the requests were delayed by the rate limits of the session attributes"})
+	proxyRevokedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_revoked_total",
+			Help: "Total number of connections closed and authentications rejected because the principal or token was revoked"},
+		[]string{"reason"})
+	proxyHTTPAuthRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_http_auth_rejected_total",
+			Help: "Total number of HTTP requests rejected because the bearer token was missing or invalid"},
+		[]string{"reason"})
+	proxyCertificateVerificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_certificate_verifications_total",
+			Help: "Total number of peer certificates verified by the certificate verifier plugin"},
+		[]string{"side", "result"})
+	proxyDialErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_dial_errors_total",
+			Help: "Total number of failed connections to the brokers by the unreachable target, forward_proxy or broker"},
+		[]string{"target"})
+	proxyForwardProxyUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_forward_proxy_up",
+			Help: "Whether the forward proxy was reachable by the last probe"})
+	proxyForwardProxyProbeSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_forward_proxy_probe_seconds",
+			Help: "Time taken to connect to the forward proxy by the last successful probe"})
+	proxyTunnelAgents = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_tunnel_agents",
+			Help: "Number of tunnel agents connected to the relay"})
+	proxySchedulerWaitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_scheduler_waits_total",
+			Help: "Total number of requests and responses which waited for a slot of the scheduler by priority class"},
+		[]string{"class"})
+	proxySchedulerWaitSecondsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_scheduler_wait_seconds_total",
+			Help: "Total time the requests and responses waited for a slot of the scheduler by priority class"},
+		[]string{"class"})
+	proxySpilledResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_spilled_responses_total",
+			Help: "Total number of responses read into memory-mapped files"},
+		[]string{"broker"})
+	proxySpilledBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_spilled_bytes_total",
+			Help: "Total size of the responses read into memory-mapped files"},
+		[]string{"broker"})
+	proxyParkedLoops = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_parked_loops",
+			Help: "Number of the requests and responses loops of the idle connections parked by the event loop"})
+	proxyLoadShedding = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_load_shedding",
+			Help: "1 while new connections are rejected because the proxy is overloaded"})
+	proxyProcessCPUPercent = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_process_cpu_percent",
+			Help: "CPU usage of the proxy process in percent of all cores sampled by the load shedding"})
+	proxyShutdownRemainingConnections = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_shutdown_remaining_connections",
+			Help: "Number of connections which are not closed yet by the graceful shutdown"})
+	proxyBrokerErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_broker_errors_total",
+			Help: "Total number of Kafka error codes in the broker responses decoded by the proxy"},
+		[]string{"broker", "api_key", "error"})
+	proxySlowRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_slow_requests_total",
+			Help: "Total number of requests which took longer than the slow request threshold"},
+		[]string{"broker", "api_key"})
+	proxyPodConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_pod_connections_total",
+			Help: "Total number of client connections from the Kubernetes pods by namespace and app label"},
+		[]string{"broker", "namespace", "app"})
+	proxyOTLPExportErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_otlp_export_errors_total",
+			Help: "Total number of failed metric exports to the OpenTelemetry collector"})
+	proxyRecordBatchSizeBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "proxy_record_batch_size_bytes",
+			Help:    "Sizes of the produced and fetched record batches",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 9)},
+		[]string{"direction", "topic"})
+	proxyRecordsPerRequest = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "proxy_records_per_request",
+			Help:    "Number of records per topic of the Produce requests and Fetch responses",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10)},
+		[]string{"direction", "topic"})
+	proxyTopicRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_topic_requests_total",
+			Help: "Total number of Produce, Fetch and Metadata requests per topic"},
+		[]string{"broker", "api_key", "topic"})
+	proxyTopicBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_topic_bytes_total",
+			Help: "Total size of the produced and fetched record batches per topic"},
+		[]string{"broker", "direction", "topic"})
+	proxyApiVersionsRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_api_versions_requests_total",
+			Help: "Total number of ApiVersions requests by client software name and version and ApiVersions version"},
+		[]string{"software_name", "software_version", "api_version"})
+	proxyClientSoftwareConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_client_software_connections",
+			Help: "Number of open client connections by client software name and version and ApiVersions version of the last ApiVersions request"},
+		[]string{"software_name", "software_version", "api_version"})
+	proxyClientTelemetryPushesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_telemetry_pushes_total",
+			Help: "Total number of client telemetry pushes terminated at the proxy by the returned error code"},
+		[]string{"error"})
+	proxyClientTelemetryBytesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_client_telemetry_bytes_total",
+			Help: "Total size of the decompressed client telemetry metrics terminated at the proxy"})
+	proxyPolicyDryRunTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_policy_dry_run_total",
+			Help: "Total number of requests which would be denied or throttled by the policies evaluated in a dry run"},
+		[]string{"policy", "action"})
+	proxyMaintenanceRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_maintenance_rejected_total",
+			Help: "Total number of requests rejected by the maintenance rules by api key and action, the requests are answered with an error response or the connection is closed"},
+		[]string{"api_key", "action"})
+	proxyRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_rejections_total",
+			Help: "Total number of rejected requests by reason and action, the requests are answered with an error response or the connection is closed"},
+		[]string{"reason", "action"})
+	proxyParseErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_parse_errors_total",
+			Help: "Total number of requests and responses which could not be parsed for the rewriting by the direction, api key and the action close, forward or reject"},
+		[]string{"direction", "api_key", "action"})
+	proxyProtocolDesyncsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_protocol_desyncs_total",
+			Help: "Total number of connections closed as the correlation ids of the requests and responses did not match by the reason duplicate, mismatch or unexpected"},
+		[]string{"broker", "reason"})
+	proxyInFlightLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_in_flight_limited_total",
+			Help: "Total number of requests which were not read from the client until a response was written as the client connection reached the maximal number of in-flight requests"},
+		[]string{"broker"})
+	proxyOPADecisionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_opa_decisions_total",
+			Help: "Total number of requests authorized by OPA by the decision and whether it was cached"},
+		[]string{"decision", "cached"})
+	proxyOPAErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_opa_errors_total",
+			Help: "Total number of authorization decisions which could not be evaluated by OPA"})
+	proxyACMECertificateExpiry = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_acme_certificate_expiry_timestamp_seconds",
+			Help: "Expiry of the ACME certificate of the listeners as Unix time"})
+	proxyACMEErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_acme_errors_total",
+			Help: "Total number of failed ACME certificate orders"})
+	proxyKeySignaturesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_key_signatures_total",
+			Help: "Total number of TLS handshake signatures of the key signer plugin by the result signed, rejected or error"},
+		[]string{"result"})
+	proxyCaptureErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_capture_errors_total",
+			Help: "Total number of captured requests and responses which could not be written"})
 )
 
 func init() {
@@ -43,6 +347,78 @@ func init() {
 	prometheus.MustRegister(proxyRequestsBytes)
 	prometheus.MustRegister(proxyResponsesBytes)
 	prometheus.MustRegister(proxyLocalAuthTotal)
+	prometheus.MustRegister(proxyRejectedConnectionsTotal)
+	prometheus.MustRegister(proxyUnauthenticatedClientsTotal)
+	prometheus.MustRegister(proxyClientCertNotAllowedTotal)
+	prometheus.MustRegister(proxyPanicsTotal)
+	prometheus.MustRegister(proxyAcceptThrottledTotal)
+	prometheus.MustRegister(proxyClientIDRequestsTotal)
+	prometheus.MustRegister(proxyClientIDThrottledTotal)
+	prometheus.MustRegister(proxySchemaValidationRejectedTotal)
+	prometheus.MustRegister(proxySchemaRegistryErrorsTotal)
+	prometheus.MustRegister(proxyCompressionPolicyRejectedTotal)
+	prometheus.MustRegister(proxyRecompressionBytesTotal)
+	prometheus.MustRegister(proxyTransactionsRejectedTotal)
+	prometheus.MustRegister(proxyFaultsInjectedTotal)
+	prometheus.MustRegister(proxyACMECertificateExpiry)
+	prometheus.MustRegister(proxyACMEErrorsTotal)
+	prometheus.MustRegister(proxyKeySignaturesTotal)
+	prometheus.MustRegister(proxyCaptureErrorsTotal)
+	prometheus.MustRegister(proxyMirrorRequestsTotal)
+	prometheus.MustRegister(proxyAckSpoofingRequestsTotal)
+	prometheus.MustRegister(proxyOutageBufferRequestsTotal)
+	prometheus.MustRegister(proxyOutageBufferBytes)
+	prometheus.MustRegister(proxyMetadataCacheRequestsTotal)
+	prometheus.MustRegister(proxyApiVersionsCacheRequestsTotal)
+	prometheus.MustRegister(proxyUpstreamSwitchesTotal)
+	prometheus.MustRegister(proxyUpstreamRoutedConnectionsTotal)
+	prometheus.MustRegister(proxyUpstreamCanaryConnectionsTotal)
+	prometheus.MustRegister(proxyBootstrapEndpointFailuresTotal)
+	prometheus.MustRegister(proxyTopologyChangesTotal)
+	prometheus.MustRegister(proxyTopologyRefreshErrorsTotal)
+	prometheus.MustRegister(proxyTopologyBrokers)
+	prometheus.MustRegister(proxyUnmappedBrokersTotal)
+	prometheus.MustRegister(proxyRackConnectionsTotal)
+	prometheus.MustRegister(proxyEgressShapedSecondsTotal)
+	prometheus.MustRegister(proxyUpstreamSASLFailuresTotal)
+	prometheus.MustRegister(proxyLocalSaslDowngradesRejectedTotal)
+	prometheus.MustRegister(proxySessionLimitsRejectedTotal)
+	prometheus.MustRegister(proxySessionLimitsThrottledSecondsTotal)
+	prometheus.MustRegister(proxyRevokedTotal)
+	prometheus.MustRegister(proxyHTTPAuthRejectedTotal)
+	prometheus.MustRegister(proxyCertificateVerificationsTotal)
+	prometheus.MustRegister(proxyDialErrorsTotal)
+	prometheus.MustRegister(proxyForwardProxyUp)
+	prometheus.MustRegister(proxyForwardProxyProbeSeconds)
+	prometheus.MustRegister(proxyTunnelAgents)
+	prometheus.MustRegister(proxyShutdownRemainingConnections)
+	prometheus.MustRegister(proxyBrokerErrorsTotal)
+	prometheus.MustRegister(proxySlowRequestsTotal)
+	prometheus.MustRegister(proxyPodConnectionsTotal)
+	prometheus.MustRegister(proxyOTLPExportErrorsTotal)
+	prometheus.MustRegister(proxyRecordBatchSizeBytes)
+	prometheus.MustRegister(proxyRecordsPerRequest)
+	prometheus.MustRegister(proxyTopicRequestsTotal)
+	prometheus.MustRegister(proxyTopicBytesTotal)
+	prometheus.MustRegister(proxyApiVersionsRequestsTotal)
+	prometheus.MustRegister(proxyClientSoftwareConnections)
+	prometheus.MustRegister(proxyClientTelemetryPushesTotal)
+	prometheus.MustRegister(proxyClientTelemetryBytesTotal)
+	prometheus.MustRegister(proxyPolicyDryRunTotal)
+	prometheus.MustRegister(proxyParseErrorsTotal)
+	prometheus.MustRegister(proxyRejectionsTotal)
+	prometheus.MustRegister(proxyMaintenanceRejectedTotal)
+	prometheus.MustRegister(proxyProtocolDesyncsTotal)
+	prometheus.MustRegister(proxyInFlightLimitedTotal)
+	prometheus.MustRegister(proxyOPADecisionsTotal)
+	prometheus.MustRegister(proxyOPAErrorsTotal)
+	prometheus.MustRegister(proxyLoadShedding)
+	prometheus.MustRegister(proxySchedulerWaitsTotal)
+	prometheus.MustRegister(proxySchedulerWaitSecondsTotal)
+	prometheus.MustRegister(proxySpilledResponsesTotal)
+	prometheus.MustRegister(proxySpilledBytesTotal)
+	prometheus.MustRegister(proxyParkedLoops)
+	prometheus.MustRegister(proxyProcessCPUPercent)
 }
 
 type proxyCollector struct {
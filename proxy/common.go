@@ -1,12 +1,15 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -101,6 +104,115 @@ func myCopyN(dst io.Writer, src io.Reader, size int64, buf []byte) (readErr bool
 	return
 }
 
+// maxCoalescedFrameSize is the size up to which the parts of a frame are copied into a single write when the connection
+// does not support vectored writes, e.g. a TLS connection seals a single record instead of one per part
+const maxCoalescedFrameSize = 16 * 1024
+
+// writeFrame writes the parts of a frame e.g. the size, header and body with a single writev on TCP and Unix connections.
+// The small frames on the other connections are written at once, the bigger ones part by part.
+func writeFrame(dst io.Writer, parts ...[]byte) error {
+	switch dst.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		bufs := net.Buffers(parts)
+		_, err := bufs.WriteTo(dst)
+		return err
+	}
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	if size <= maxCoalescedFrameSize {
+		frame := make([]byte, 0, size)
+		for _, part := range parts {
+			frame = append(frame, part...)
+		}
+		_, err := dst.Write(frame)
+		return err
+	}
+	for _, part := range parts {
+		if _, err := dst.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFrame writes the header parts of a frame together with the first bytes of its body read into buf, the rest of the body
+// is copied as by myCopyN. A frame whose body fits into buf is written with a single write.
+func copyFrame(dst io.Writer, src io.Reader, size int64, buf []byte, header ...[]byte) (readErr bool, err error) {
+	first := buf
+	if int64(len(first)) > size {
+		first = first[:size]
+	}
+	if _, err = io.ReadFull(src, first); err != nil {
+		return true, err
+	}
+	if err = writeFrame(dst, append(header, first)...); err != nil {
+		return false, err
+	}
+	if rest := size - int64(len(first)); rest > 0 {
+		return myCopyN(dst, src, rest, buf)
+	}
+	return false, nil
+}
+
+// bufferedConn reads the frames of a connection through a buffer, so the size, header and body of a small frame are read
+// with a single read. Reads bigger than the buffer bypass it.
+type bufferedConn struct {
+	DeadlineReaderWriter
+	reader *bufio.Reader
+}
+
+func newBufferedConn(conn DeadlineReaderWriter, size int) *bufferedConn {
+	return &bufferedConn{DeadlineReaderWriter: conn, reader: bufio.NewReaderSize(conn, size)}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Close closes the connection, so it can be closed by the revocations
+func (c *bufferedConn) Close() error {
+	if closer, ok := c.DeadlineReaderWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// bufferedReader reads the frames of a connection through a buffer as bufferedConn
+type bufferedReader struct {
+	DeadlineReader
+	reader *bufio.Reader
+}
+
+func newBufferedReader(conn DeadlineReader, size int) *bufferedReader {
+	return &bufferedReader{DeadlineReader: conn, reader: bufio.NewReaderSize(conn, size)}
+}
+
+func (r *bufferedReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// closeOnDone closes the connection when the context is done before stop is called.
+// It unblocks reads and writes which do not accept a context.
+func closeOnDone(ctx context.Context, conn io.Closer) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
+
 func copyError(readDesc, writeDesc string, readErr bool, err error) {
 	var desc string
 	if readErr {
@@ -111,28 +223,63 @@ func copyError(readDesc, writeDesc string, readErr bool, err error) {
 	logrus.Infof("%v had error: %s", desc, err.Error())
 }
 
-func copyThenClose(cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, brokerAddress string, remoteDesc, localDesc string) {
+// copyThenClose runs the requests and responses loops of the connection, closed is called when both are finished
+func copyThenClose(ctx context.Context, cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, brokerAddress string, remoteDesc, localDesc string, closed func()) {
 
-	processor := newProcessor(cfg, brokerAddress)
+	processor := newProcessor(ctx, cfg, brokerAddress)
+	// the connection is closed by the graceful shutdown when no request is in flight
+	processor.inFlight = cfg.Shutdown.register(local)
+	if processor.inFlight == nil && (cfg.Telemetry.respondsLocally() || cfg.AckSpoofing != nil || cfg.ParseErrors != nil || cfg.Rejection != nil || cfg.Maintenance != nil || cfg.MetadataCache != nil || cfg.ApiVersionsCache != nil || processor.apiVersionFilter != nil) {
+		// the responses of the proxy wait for the responses of the broker
+		processor.inFlight = &inFlightRequests{}
+	}
+	if cfg.EventLoop != nil {
+		cfg.EventLoop.copyThenClose(ctx, processor, remote, local, remoteDesc, localDesc, func() {
+			cfg.Shutdown.unregister(local)
+			closed()
+		})
+		return
+	}
+	defer closed()
+	defer cfg.Shutdown.unregister(local)
+
+	// blocked reads and writes are interrupted when the proxy is stopped
+	stopRemote := closeOnDone(ctx, remote)
+	defer stopRemote()
+	stopLocal := closeOnDone(ctx, local)
+	defer stopLocal()
 
 	firstErr := make(chan error, 1)
 
-	go withRecover(func() {
+	go withConnectionRecover(panicGoroutineRequests, localDesc, func() {
 		readErr, err := processor.RequestsLoop(remote, local)
-		select {
-		case firstErr <- err:
-			if readErr && err == io.EOF {
-				logrus.Infof("Client closed %v", localDesc)
-			} else {
-				copyError(localDesc, remoteDesc, readErr, err)
-			}
-			remote.Close()
-			local.Close()
-		default:
+		requestsLoopFinished(firstErr, readErr, err, remote, local, remoteDesc, localDesc)
+	}, remote, local)
+
+	// the connection is released by the caller after a panic of the responses loop too
+	withConnectionRecover(panicGoroutineResponses, localDesc, func() {
+		readErr, err := processor.ResponsesLoop(local, remote)
+		responsesLoopFinished(firstErr, readErr, err, remote, local, remoteDesc, localDesc)
+	}, remote, local)
+}
+
+// requestsLoopFinished logs the error and closes the connection if the requests loop is finished first
+func requestsLoopFinished(firstErr chan error, readErr bool, err error, remote, local io.Closer, remoteDesc, localDesc string) {
+	select {
+	case firstErr <- err:
+		if readErr && err == io.EOF {
+			logrus.Infof("Client closed %v", localDesc)
+		} else {
+			copyError(localDesc, remoteDesc, readErr, err)
 		}
-	})
+		remote.Close()
+		local.Close()
+	default:
+	}
+}
 
-	readErr, err := processor.ResponsesLoop(local, remote)
+// responsesLoopFinished logs the error and closes the connection if the responses loop is finished first
+func responsesLoopFinished(firstErr chan error, readErr bool, err error, remote, local io.Closer, remoteDesc, localDesc string) {
 	select {
 	case firstErr <- err:
 		if readErr && err == io.EOF {
@@ -222,6 +369,19 @@ func (c *ConnSet) Count() map[string]int {
 	return ret
 }
 
+// Len returns number of all active connections
+func (c *ConnSet) Len() int {
+	ret := 0
+
+	c.RLock()
+	for _, v := range c.m {
+		ret += len(v)
+	}
+	c.RUnlock()
+
+	return ret
+}
+
 // brokerToCount := make(map[string]int)
 
 // Remove undoes an Add operation to have the set forget about a conn. Do not
@@ -273,11 +433,47 @@ func (c *ConnSet) Close() error {
 	return errors.New(errs.String())
 }
 
+const (
+	panicGoroutineBackground = "background"
+	panicGoroutineConnection = "connection"
+	panicGoroutineHandshake  = "handshake"
+	panicGoroutineRequests   = "requests"
+	panicGoroutineResponses  = "responses"
+)
+
 func withRecover(fn func()) {
 	defer func() {
 		if err := recover(); err != nil {
-			logrus.Errorf("Recovered from %v", err)
+			proxyPanicsTotal.WithLabelValues(panicGoroutineBackground).Inc()
+			logrus.WithField("stack", string(debug.Stack())).Errorf("Recovered from %v", err)
 		}
 	}()
 	fn()
 }
+
+// withConnectionRecover runs fn of a single connection, a panic e.g. by a decoding bug closes only the connections of fn
+// and the proxy keeps serving the other clients
+func withConnectionRecover(goroutine string, desc string, fn func(), conns ...io.Closer) {
+	defer func() {
+		if err := recover(); err != nil {
+			proxyPanicsTotal.WithLabelValues(goroutine).Inc()
+			logrus.WithField("stack", string(debug.Stack())).Errorf("Recovered from panic in %s goroutine of %s, closing the connection: %v", goroutine, desc, err)
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}
+	}()
+	fn()
+}
+
+// addrIP returns the IP of the address or nil if the address has no IP
+func addrIP(addr net.Addr) net.IP {
+	if v, ok := addr.(*net.TCPAddr); ok {
+		return v.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
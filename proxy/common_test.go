@@ -2,10 +2,13 @@ package proxy
 
 import (
 	"bytes"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"math/rand"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestMyCopyN(t *testing.T) {
@@ -93,3 +96,136 @@ func randomString(n int) string {
 	}
 	return string(b)
 }
+
+// countingWriter counts the writes, as the syscalls of a connection
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestCopyFrame(t *testing.T) {
+	a := assert.New(t)
+
+	header := []byte{0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x07}
+	for _, size := range []int{1, 16, 4096, 4097, 40000} {
+		body := randomString(size)
+		w := &countingWriter{}
+		readErr, err := copyFrame(w, bytes.NewBufferString(body+"next frame"), int64(size), make([]byte, 4096), header[:4], header[4:])
+		a.False(readErr)
+		a.Nil(err)
+		a.Equal(string(header)+body, w.String())
+		if size <= 4096 {
+			// size, header and body at once
+			a.Equal(1, w.writes, size)
+		}
+
+		readErr, err = copyFrame(&countingWriter{}, bytes.NewBufferString(body), int64(size+1), make([]byte, 4096), header)
+		a.True(readErr)
+		a.NotNil(err)
+	}
+
+	// the parts of a frame bigger than the coalesced ones are written one by one
+	w := &countingWriter{}
+	a.Nil(writeFrame(w, header, make([]byte, maxCoalescedFrameSize)))
+	a.Equal(2, w.writes)
+	a.Equal(8+maxCoalescedFrameSize, w.Len())
+}
+
+func TestWriteFrameTCP(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	a.Nil(err)
+	defer client.Close()
+	server := <-accepted
+	a.NotNil(server)
+	defer server.Close()
+
+	body := []byte(randomString(100000))
+	go func() {
+		a.Nil(writeFrame(client, []byte{0x00, 0x01}, []byte{0x02}, body))
+	}()
+	reader := newBufferedConn(server.(*net.TCPConn), 4096)
+	frame := make([]byte, 3+len(body))
+	_, err = io.ReadFull(reader, frame)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x01, 0x02}, frame[:3])
+	a.Equal(body, frame[3:])
+
+	// closed as the connection by the revocations
+	a.Nil(reader.Close())
+	_, err = server.Write([]byte{0x00})
+	a.NotNil(err)
+}
+
+// panickingTransformer panics on the produced records as a decoding bug would
+type panickingTransformer struct{}
+
+func (panickingTransformer) TransformProduced(topic string, value []byte) ([]byte, error) {
+	panic("bug in " + topic)
+}
+
+func (panickingTransformer) TransformFetched(topic string, value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func TestConnectionPanicClosesOnlyTheConnection(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	listenerAddress, stop := startTestProxy(a, newTestProxyConfig(broker.Addr()), WithRecordTransformer(panickingTransformer{}))
+	defer stop()
+	panics := counterOf(a, proxyPanicsTotal, panicGoroutineRequests)
+
+	healthy, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer healthy.Close()
+	faulty, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer faulty.Close()
+
+	_, err = faulty.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", kafkatest.ProduceRequestBody("test", [][]byte{[]byte("value")})))
+	a.Nil(err)
+	faulty.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(faulty)
+	a.Equal(io.EOF, err)
+	a.Equal(panics+1, counterOf(a, proxyPanicsTotal, panicGoroutineRequests))
+
+	// the other connections are served
+	_, err = healthy.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 2, "test", kafkatest.MetadataRequestBody(1, []string{"test"})))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(healthy)
+	a.Nil(err)
+	a.Equal(int32(2), correlationID)
+}
+
+func TestWithConnectionRecover(t *testing.T) {
+	a := assert.New(t)
+
+	local, remote := net.Pipe()
+	panics := counterOf(a, proxyPanicsTotal, panicGoroutineConnection)
+	withConnectionRecover(panicGoroutineConnection, "test", func() { panic("test") }, local)
+	a.Equal(panics+1, counterOf(a, proxyPanicsTotal, panicGoroutineConnection))
+
+	// the connection is closed
+	_, err := remote.Read(make([]byte, 1))
+	a.Equal(io.EOF, err)
+	_, err = local.Write([]byte{1})
+	a.NotNil(err)
+}
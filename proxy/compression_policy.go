@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const compressionNone = int8(0)
+
+// compressionPolicy rejects Produce requests with record batches compressed with a not allowed codec
+// or uncompressed record batches larger than the limit
+type compressionPolicy struct {
+	// empty means all codecs are allowed
+	allowedCodecs            map[int8]bool
+	maxUncompressedBatchSize int
+}
+
+// newCompressionPolicy returns nil if neither the codecs nor the uncompressed batch size are restricted
+func newCompressionPolicy(c *config.Config) (*compressionPolicy, error) {
+	if len(c.Compression.AllowedCodecs) == 0 && c.Compression.MaxUncompressedBatchSize == 0 {
+		return nil, nil
+	}
+	policy := &compressionPolicy{maxUncompressedBatchSize: c.Compression.MaxUncompressedBatchSize}
+	for _, v := range c.Compression.AllowedCodecs {
+		codec, err := config.ParseCompressionCodec(v)
+		if err != nil {
+			return nil, err
+		}
+		if policy.allowedCodecs == nil {
+			policy.allowedCodecs = make(map[int8]bool)
+		}
+		policy.allowedCodecs[codec] = true
+	}
+	logrus.Infof("Produced record batches will be checked against compression codecs %v and uncompressed batch size limit %d", c.Compression.AllowedCodecs, c.Compression.MaxUncompressedBatchSize)
+	return policy, nil
+}
+
+// isForbidden reports the Produce versions which record batches cannot be checked
+func (p *compressionPolicy) isForbidden(apiKey int16, apiVersion int16) bool {
+	if p == nil {
+		return false
+	}
+	_, maxVersion, _ := protocol.RecordBatchVersions(apiKeyProduce)
+	return apiKey == apiKeyProduce && apiVersion > maxVersion
+}
+
+// inspects reports whether the request body must be checked
+func (p *compressionPolicy) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return p != nil && requestKeyVersion.ApiKey == apiKeyProduce
+}
+
+// check returns an error if a record batch of the Produce request violates the policy
+func (p *compressionPolicy) check(apiVersion int16, body []byte) error {
+	return protocol.DecodeProduceRecordBatches(apiVersion, body, func(topic string, codec int8, size int) error {
+		if len(p.allowedCodecs) != 0 && !p.allowedCodecs[codec] {
+			proxyCompressionPolicyRejectedTotal.WithLabelValues("codec").Inc()
+			return fmt.Errorf("record batch produced to topic %s is compressed with not allowed codec %d", topic, codec)
+		}
+		if codec == compressionNone && p.maxUncompressedBatchSize > 0 && size > p.maxUncompressedBatchSize {
+			proxyCompressionPolicyRejectedTotal.WithLabelValues("uncompressed_size").Inc()
+			return fmt.Errorf("uncompressed record batch of %d bytes produced to topic %s is larger than %d bytes", size, topic, p.maxUncompressedBatchSize)
+		}
+		return nil
+	})
+}
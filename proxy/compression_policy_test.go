@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompressionPolicyChecksRecordBatches(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	policy, err := newCompressionPolicy(c)
+	a.Nil(err)
+	a.Nil(policy)
+
+	c.Compression.MaxUncompressedBatchSize = 100
+	policy, err = newCompressionPolicy(c)
+	a.Nil(err)
+	a.Nil(policy.check(3, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")})))
+	err = policy.check(3, kafkatest.ProduceRequestBody("orders", [][]byte{make([]byte, 100)}))
+	a.NotNil(err)
+	a.Contains(err.Error(), "uncompressed record batch of")
+
+	c.Compression.AllowedCodecs = []string{"zstd", "lz4"}
+	policy, err = newCompressionPolicy(c)
+	a.Nil(err)
+	err = policy.check(3, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")}))
+	a.EqualError(err, "record batch produced to topic orders is compressed with not allowed codec 0")
+}
+
+func TestCompressionPolicyForbidsNewerProduceVersions(t *testing.T) {
+	a := assert.New(t)
+
+	policy := &compressionPolicy{}
+	a.False(policy.isForbidden(kafkatest.ApiKeyProduce, 8))
+	a.True(policy.isForbidden(kafkatest.ApiKeyProduce, 9))
+	a.False(policy.isForbidden(kafkatest.ApiKeyFetch, 12))
+
+	var disabled *compressionPolicy
+	a.False(disabled.isForbidden(kafkatest.ApiKeyProduce, 9))
+}
+
+func TestProxyRejectsProduceViolatingCompressionPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Compression.MaxUncompressedBatchSize = 200
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "app-1", kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")})))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "app-1", kafkatest.ProduceRequestBody("orders", [][]byte{make([]byte, 1024)})))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
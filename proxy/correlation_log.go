@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// correlationLog logs the sampled requests with the correlation id, client id and api key when they are received from the client
+// and when the response is written back, so the timeouts reported by the clients can be matched to the proxy logs
+type correlationLog struct {
+	samplePercent float64
+	// all api keys are sampled when empty
+	apiKeys map[int16]struct{}
+	now     func() time.Time
+}
+
+// newCorrelationLog returns nil if the correlation ids are not logged
+func newCorrelationLog(c *config.Config) *correlationLog {
+	if c.CorrelationLog.SamplePercent == 0 {
+		return nil
+	}
+	result := &correlationLog{samplePercent: c.CorrelationLog.SamplePercent, now: time.Now}
+	for _, v := range c.CorrelationLog.ApiKeys {
+		if result.apiKeys == nil {
+			result.apiKeys = make(map[int16]struct{})
+		}
+		result.apiKeys[int16(v)] = struct{}{}
+	}
+	logrus.Infof("%v%% of requests with api keys %v will be logged with the correlation id", c.CorrelationLog.SamplePercent, c.CorrelationLog.ApiKeys)
+	return result
+}
+
+// newSession returns the correlation log of the client connection
+func (l *correlationLog) newSession(clientAddress string, brokerAddress string) *correlationLogSession {
+	if l == nil {
+		return nil
+	}
+	return &correlationLogSession{
+		correlationLog: l,
+		clientAddress:  clientAddress,
+		brokerAddress:  brokerAddress,
+		pending:        make(map[int32]*correlatedRequest),
+	}
+}
+
+// close logs the sampled requests of the closed connection which were not answered
+func (l *correlationLog) close(session *correlationLogSession) {
+	if l == nil || session == nil {
+		return
+	}
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	now := l.now()
+	for correlationID, request := range session.pending {
+		session.fields(correlationID, request).WithField("duration", now.Sub(request.received)).
+			Infof("Connection closed before the response to correlation id %d was written", correlationID)
+	}
+	session.pending = make(map[int32]*correlatedRequest)
+}
+
+type correlatedRequest struct {
+	apiKey     int16
+	apiVersion int16
+	clientID   string
+	received   time.Time
+}
+
+// correlationLogSession logs the sampled requests of a connection and matches the responses by the correlation id
+type correlationLogSession struct {
+	correlationLog *correlationLog
+	clientAddress  string
+	brokerAddress  string
+
+	lock    sync.Mutex
+	pending map[int32]*correlatedRequest
+}
+
+// request logs the sampled request received from the client, headerBuf is the request header starting with the correlation id
+func (s *correlationLogSession) request(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, clientID string) {
+	// request header v0 has no correlation id
+	if s == nil || len(headerBuf) < 4 {
+		return
+	}
+	if len(s.correlationLog.apiKeys) != 0 {
+		if _, ok := s.correlationLog.apiKeys[requestKeyVersion.ApiKey]; !ok {
+			return
+		}
+	}
+	if s.correlationLog.samplePercent < 100 && rand.Float64()*100 >= s.correlationLog.samplePercent {
+		return
+	}
+	correlationID := int32(binary.BigEndian.Uint32(headerBuf))
+	request := &correlatedRequest{
+		apiKey:     requestKeyVersion.ApiKey,
+		apiVersion: requestKeyVersion.ApiVersion,
+		clientID:   clientID,
+		received:   s.correlationLog.now(),
+	}
+	s.lock.Lock()
+	s.pending[correlationID] = request
+	s.lock.Unlock()
+	s.fields(correlationID, request).Infof("Request with correlation id %d received from %s", correlationID, s.clientAddress)
+}
+
+// response logs the response of the sampled request written to the client
+func (s *correlationLogSession) response(correlationID int32, responseSize int32) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	request, ok := s.pending[correlationID]
+	delete(s.pending, correlationID)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	s.fields(correlationID, request).WithFields(logrus.Fields{
+		"duration":      s.correlationLog.now().Sub(request.received),
+		"response_size": responseSize,
+	}).Infof("Response with correlation id %d written to %s", correlationID, s.clientAddress)
+}
+
+func (s *correlationLogSession) fields(correlationID int32, request *correlatedRequest) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"client":         s.clientAddress,
+		"broker":         s.brokerAddress,
+		"correlation_id": correlationID,
+		"client_id":      request.clientID,
+		"api_key":        request.apiKey,
+		"api_version":    request.apiVersion,
+	})
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCorrelationLog(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newCorrelationLog(c))
+	c.CorrelationLog.SamplePercent = 100
+	c.CorrelationLog.ApiKeys = []int{0, 1}
+	l := newCorrelationLog(c)
+	session := l.newSession("10.0.0.1:50000", "kafka-1:9092")
+
+	produce := &protocol.RequestKeyVersion{Length: 100, ApiKey: 0, ApiVersion: 7}
+	// correlation id 1, client id "c"
+	session.request(produce, []byte{0, 0, 0, 1, 0, 1, 'c'}, "c")
+	session.request(produce, []byte{0, 0, 0, 2, 0, 1, 'c'}, "c")
+	// not sampled api key
+	session.request(&protocol.RequestKeyVersion{Length: 10, ApiKey: 3, ApiVersion: 1}, []byte{0, 0, 0, 3, 0, 1, 'c'}, "c")
+	// request header v0 has no correlation id
+	session.request(&protocol.RequestKeyVersion{Length: 10, ApiKey: 1, ApiVersion: 0}, []byte{}, "")
+	a.Len(session.pending, 2)
+	a.Equal("c", session.pending[1].clientID)
+
+	session.response(1, 20)
+	session.response(3, 20)
+	a.Len(session.pending, 1)
+
+	l.close(session)
+	a.Len(session.pending, 0)
+
+	c.CorrelationLog.SamplePercent = 0.0001
+	c.CorrelationLog.ApiKeys = nil
+	session = newCorrelationLog(c).newSession("10.0.0.1:50000", "kafka-1:9092")
+	for i := byte(0); i < 100; i++ {
+		session.request(produce, []byte{0, 0, 0, i, 0, 1, 'c'}, "c")
+	}
+	a.True(len(session.pending) < 10)
+
+	// nil safe
+	var disabled *correlationLog
+	a.Nil(disabled.newSession("10.0.0.1:50000", "kafka-1:9092"))
+	disabled.close(nil)
+	var disabledSession *correlationLogSession
+	disabledSession.request(produce, []byte{0, 0, 0, 1, 0, 1, 'c'}, "c")
+	disabledSession.response(1, 20)
+}
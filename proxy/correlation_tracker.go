@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"sync"
+)
+
+const (
+	desyncDuplicate  = "duplicate"
+	desyncMismatch   = "mismatch"
+	desyncUnexpected = "unexpected"
+)
+
+type trackedRequest struct {
+	correlationID int32
+	// false for the request header v0 which has no correlation id
+	known      bool
+	apiKey     int16
+	apiVersion int16
+}
+
+// correlationTracker matches the correlation ids of the responses to the requests of a connection. The broker answers the requests
+// in their order, so a response with another correlation id than the oldest pending request means the proxy lost the framing
+// e.g. after a partial write and the response would be delivered to the wrong request. The connection is closed instead.
+type correlationTracker struct {
+	brokerAddress string
+
+	lock sync.Mutex
+	// pending requests in the order they were sent to the broker
+	pending []trackedRequest
+	// correlation ids of the pending requests
+	ids map[int32]struct{}
+	// correlation id of the last response, -1 before the first one
+	last int32
+}
+
+func newCorrelationTracker(brokerAddress string) *correlationTracker {
+	return &correlationTracker{
+		brokerAddress: brokerAddress,
+		ids:           make(map[int32]struct{}),
+		last:          -1,
+	}
+}
+
+// request records the request sent to the broker, headerBuf is the request header starting with the correlation id.
+// An error is returned if a pending request has the same correlation id.
+func (t *correlationTracker) request(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte) error {
+	if t == nil {
+		return nil
+	}
+	request := trackedRequest{apiKey: requestKeyVersion.ApiKey, apiVersion: requestKeyVersion.ApiVersion}
+	// request header v0 has no correlation id
+	if len(headerBuf) >= 4 {
+		request.correlationID = int32(binary.BigEndian.Uint32(headerBuf))
+		request.known = true
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if request.known {
+		if _, ok := t.ids[request.correlationID]; ok {
+			proxyProtocolDesyncsTotal.WithLabelValues(t.brokerAddress, desyncDuplicate).Inc()
+			return fmt.Errorf("duplicate correlation id %d of api key %d version %d, %d requests are pending", request.correlationID, request.apiKey, request.apiVersion, len(t.pending))
+		}
+		t.ids[request.correlationID] = struct{}{}
+	}
+	t.pending = append(t.pending, request)
+	return nil
+}
+
+// response matches the response of the broker to the oldest pending request. An error with the expected and the received
+// correlation ids is returned if they differ or no request is pending.
+func (t *correlationTracker) response(correlationID int32) error {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.pending) == 0 {
+		proxyProtocolDesyncsTotal.WithLabelValues(t.brokerAddress, desyncUnexpected).Inc()
+		return fmt.Errorf("response with correlation id %d received without a pending request, last response had correlation id %d", correlationID, t.last)
+	}
+	request := t.pending[0]
+	if request.known && request.correlationID != correlationID {
+		proxyProtocolDesyncsTotal.WithLabelValues(t.brokerAddress, desyncMismatch).Inc()
+		_, pending := t.ids[correlationID]
+		return fmt.Errorf("response with correlation id %d does not match the request with correlation id %d of api key %d version %d, %d requests are pending (response of a pending request: %v), last response had correlation id %d",
+			correlationID, request.correlationID, request.apiKey, request.apiVersion, len(t.pending), pending, t.last)
+	}
+	if request.known {
+		delete(t.ids, request.correlationID)
+	}
+	t.pending[0] = trackedRequest{}
+	t.pending = t.pending[1:]
+	t.last = correlationID
+	return nil
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCorrelationTracker(t *testing.T) {
+	a := assert.New(t)
+
+	header := func(correlationID byte) []byte {
+		// correlation id and the empty client id
+		return []byte{0x00, 0x00, 0x00, correlationID, 0x00, 0x00}
+	}
+	metadata := &protocol.RequestKeyVersion{ApiKey: apiKeyMetadata, ApiVersion: 1}
+	controlledShutdown := &protocol.RequestKeyVersion{ApiKey: apiKeyControlledShutdown, ApiVersion: 0}
+
+	tracker := newCorrelationTracker("broker:9092")
+	a.Nil(tracker.request(metadata, header(1)))
+	a.Nil(tracker.request(metadata, header(2)))
+	// request header v0 has no correlation id
+	a.Nil(tracker.request(controlledShutdown, nil))
+	a.Nil(tracker.request(metadata, header(0)))
+
+	before := counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncDuplicate)
+	err := tracker.request(metadata, header(2))
+	a.NotNil(err)
+	a.Contains(err.Error(), "duplicate correlation id 2")
+	a.Equal(before+1, counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncDuplicate))
+
+	a.Nil(tracker.response(1))
+	before = counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncMismatch)
+	err = tracker.response(0)
+	a.NotNil(err)
+	a.Contains(err.Error(), "correlation id 0 does not match the request with correlation id 2 of api key 3 version 1")
+	a.Contains(err.Error(), "last response had correlation id 1")
+	a.Equal(before+1, counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncMismatch))
+
+	a.Nil(tracker.response(2))
+	// any correlation id of the request without it
+	a.Nil(tracker.response(5))
+	a.Nil(tracker.response(0))
+	// the answered correlation ids can be reused
+	a.Nil(tracker.request(metadata, header(1)))
+	a.Nil(tracker.response(1))
+
+	before = counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncUnexpected)
+	err = tracker.response(3)
+	a.NotNil(err)
+	a.Contains(err.Error(), "without a pending request")
+	a.Equal(before+1, counterOf(a, proxyProtocolDesyncsTotal, "broker:9092", desyncUnexpected))
+
+	// nil safe
+	var disabled *correlationTracker
+	a.Nil(disabled.request(metadata, header(1)))
+	a.Nil(disabled.response(1))
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -16,19 +17,30 @@ import (
 
 type Dialer interface {
 	Dial(network, addr string) (c net.Conn, err error)
+	// DialContext aborts the dial and the handshakes when the context is done
+	DialContext(ctx context.Context, network, addr string) (c net.Conn, err error)
 }
 
 type directDialer struct {
 	dialTimeout time.Duration
 	keepAlive   time.Duration
+	// optional, net.Dialer resolves the host names when nil
+	resolver Resolver
+	// optional source address of the connections e.g. allowlisted by the firewalls of the brokers
+	localAddr net.Addr
 }
 
 func (d directDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d directDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	dialer := net.Dialer{
 		Timeout:   d.dialTimeout,
 		KeepAlive: d.keepAlive,
+		LocalAddr: d.localAddr,
 	}
-	conn, err := dialer.Dial(network, addr)
+	conn, err := d.dialResolved(ctx, dialer, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -40,13 +52,114 @@ func (d directDialer) Dial(network, addr string) (net.Conn, error) {
 	return conn, err
 }
 
+// newLocalAddr returns the source address of the IP or of the network interface, the first IPv4 address of the interface is preferred.
+// It returns nil if the source address is chosen by the operating system.
+func newLocalAddr(v string) (net.Addr, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(v); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	iface, err := net.InterfaceByName(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "local address %s is neither an IP nor a network interface", v)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var localAddr net.Addr
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+		if localAddr == nil {
+			localAddr = &net.TCPAddr{IP: ipNet.IP}
+		}
+	}
+	if localAddr == nil {
+		return nil, errors.Errorf("network interface %s has no IP address", v)
+	}
+	return localAddr, nil
+}
+
+// dialResolved tries the resolved addresses in order until a connection succeeds. As net.Dialer does, the dial timeout is split
+// across the remaining addresses, so an unreachable address does not use up the time of the others.
+func (d directDialer) dialResolved(ctx context.Context, dialer net.Dialer, network, addr string) (net.Conn, error) {
+	if d.resolver == nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if dialer.Timeout > 0 {
+		deadline = time.Now().Add(dialer.Timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	var firstErr error
+	for i, ip := range ips {
+		if !deadline.IsZero() {
+			dialer.Timeout = partialDialTimeout(time.Until(deadline), len(ips)-i)
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// minPartialDialTimeout is the lower bound of the timeout of one address unless the whole remaining time is shorter
+const minPartialDialTimeout = 2 * time.Second
+
+// partialDialTimeout returns the share of the remaining time of one of the remaining addresses
+func partialDialTimeout(remaining time.Duration, addresses int) time.Duration {
+	if remaining <= 0 {
+		// the dial fails at once
+		return time.Nanosecond
+	}
+	timeout := remaining / time.Duration(addresses)
+	if timeout < minPartialDialTimeout {
+		if remaining < minPartialDialTimeout {
+			return remaining
+		}
+		return minPartialDialTimeout
+	}
+	return timeout
+}
+
 type socks5Dialer struct {
 	directDialer            directDialer
 	proxyNetwork, proxyAddr string
 	username, password      string
+	// the broker host names are sent to the proxy instead of being resolved locally
+	remoteResolve bool
 }
 
 func (d socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	if d.proxyNetwork == "" || d.proxyAddr == "" {
 		return nil, errors.New("socks5 proxy network and addr must be not empty")
 	}
@@ -57,25 +170,66 @@ func (d socks5Dialer) Dial(network, addr string) (net.Conn, error) {
 			Password: d.password,
 		}
 	}
-	socks5Dialer, err := proxy.SOCKS5(d.proxyNetwork, d.proxyAddr, auth, d.directDialer)
+	if !d.remoteResolve {
+		var err error
+		if addr, err = d.resolve(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	socks5Dialer, err := proxy.SOCKS5(d.proxyNetwork, d.proxyAddr, auth, contextDialer{ctx: ctx, dialer: forwardProxyDialer{dialer: d.directDialer}})
 	if err != nil {
 		return nil, err
 	}
-	conn, err := socks5Dialer.Dial(network, addr)
+	// SOCKS5 negotiation is not context aware
+	return dialWithContext(ctx, func() (net.Conn, error) {
+		return socks5Dialer.Dial(network, addr)
+	})
+}
+
+// resolve replaces the host name of the address by its first IP address
+func (d socks5Dialer) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return conn, nil
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	var ips []net.IP
+	if d.directDialer.resolver != nil {
+		ips, err = d.directDialer.resolver.LookupIP(ctx, host)
+	} else {
+		var ipAddrs []net.IPAddr
+		ipAddrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.IP)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", errors.Errorf("no addresses found for %s", host)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
 }
 
 type tlsDialer struct {
 	timeout   time.Duration
 	rawDialer Dialer
 	config    *tls.Config
+	// the broker must select one of the ALPN protocols of the config
+	alpnRequired bool
+	// CA certificates of the brokers verified with own CAs, nil if all brokers are verified with the config
+	rootCAs *brokerRootCAs
 }
 
-// see tls.DialWithDialer
 func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// see tls.DialWithDialer
+func (d tlsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	if d.config == nil {
 		return nil, errors.New("tlsConfig must not be nil")
 	}
@@ -84,18 +238,16 @@ func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
 	}
 
 	timeout := d.timeout
-
-	var errChannel chan error
-
+	if options, ok := d.rawDialer.(dialOptionsDialer); ok {
+		timeout = options.dialTimeout(addr, timeout)
+	}
 	if timeout != 0 {
-		errChannel = make(chan error, 2)
-		timer := time.AfterFunc(timeout, func() {
-			errChannel <- errors.Errorf("Handshake timeout to %s after %v", addr, timeout)
-		})
-		defer timer.Stop()
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	rawConn, err := d.rawDialer.Dial(network, addr)
+	rawConn, err := d.rawDialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -116,23 +268,36 @@ func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
 		c.ServerName = hostname
 		config = c
 	}
+	if rootCAs, ok := d.rootCAs.lookup(addr); ok {
+		if config == d.config {
+			config = config.Clone()
+		}
+		config.RootCAs = rootCAs
+	}
 
 	conn := tls.Client(rawConn, config)
 
-	if timeout == 0 {
-		err = conn.Handshake()
-	} else {
-		go func() {
-			errChannel <- conn.Handshake()
-		}()
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- conn.Handshake()
+	}()
 
-		err = <-errChannel
+	select {
+	case err = <-errChannel:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = errors.Errorf("Handshake timeout to %s after %v", addr, timeout)
+		}
 	}
-
 	if err != nil {
 		rawConn.Close()
 		return nil, err
 	}
+	if d.alpnRequired && conn.ConnectionState().NegotiatedProtocol == "" {
+		rawConn.Close()
+		return nil, errors.Errorf("broker %s selected none of the ALPN protocols %v", addr, config.NextProtos)
+	}
 
 	return conn, nil
 }
@@ -145,6 +310,10 @@ type httpProxy struct {
 }
 
 func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
+	return s.DialContext(context.Background(), network, addr)
+}
+
+func (s *httpProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	reqURL, err := url.Parse("http://" + addr)
 	if err != nil {
 		return nil, err
@@ -159,10 +328,13 @@ func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
 		req.Header.Set("Proxy-Authorization", basic)
 	}
 
-	c, err := s.forwardDialer.Dial(s.network, s.hostPort)
+	c, err := s.forwardDialer.DialContext(ctx, s.network, s.hostPort)
 	if err != nil {
 		return nil, err
 	}
+	stop := closeOnDone(ctx, c)
+	defer stop()
+
 	err = req.Write(c)
 	if err != nil {
 		c.Close()
@@ -172,6 +344,9 @@ func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
 	resp, err := http.ReadResponse(bufio.NewReader(c), req)
 	if err != nil {
 		c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 	resp.Body.Close()
@@ -182,3 +357,71 @@ func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
 
 	return c, nil
 }
+
+// forwardProxyDialError is returned if the forward proxy itself is unreachable,
+// the errors of the proxy connecting to the broker are returned as they are
+type forwardProxyDialError struct {
+	proxyAddr string
+	err       error
+}
+
+func (e *forwardProxyDialError) Error() string {
+	return fmt.Sprintf("forward proxy %s dial failed: %v", e.proxyAddr, e.err)
+}
+
+func isForwardProxyDialError(err error) bool {
+	_, ok := err.(*forwardProxyDialError)
+	return ok
+}
+
+// forwardProxyDialer connects to the forward proxy and marks the failures with forwardProxyDialError
+type forwardProxyDialer struct {
+	dialer Dialer
+}
+
+func (d forwardProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d forwardProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &forwardProxyDialError{proxyAddr: addr, err: err}
+	}
+	return conn, nil
+}
+
+// contextDialer binds the context to dialers which accept only Dial e.g. proxy.SOCKS5
+type contextDialer struct {
+	ctx    context.Context
+	dialer Dialer
+}
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.dialer.DialContext(d.ctx, network, addr)
+}
+
+// dialWithContext returns when the context is done without waiting for the dial function.
+// The connection established after the cancellation is closed.
+func dialWithContext(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := dial()
+		results <- result{conn: conn, err: err}
+	}()
+	select {
+	case r := <-results:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+type brokerDialer struct {
+	dialer      Dialer
+	dialTimeout time.Duration
+}
+
+// dialOptionsDialer dials the brokers with their own dial options, e.g. the local brokers directly and the remote ones
+// through the forward proxy with a longer timeout or from another source address
+type dialOptionsDialer struct {
+	byAddress map[string]brokerDialer
+	// brokers with the address *:port
+	byPort        map[string]brokerDialer
+	defaultDialer Dialer
+}
+
+// newDialOptionsDialer returns the default dialer if no broker has own dial options
+func newDialOptionsDialer(c *config.Config, defaultDialer Dialer) (Dialer, error) {
+	if len(c.Kafka.DialOptions) == 0 {
+		return defaultDialer, nil
+	}
+	d := dialOptionsDialer{byAddress: make(map[string]brokerDialer), byPort: make(map[string]brokerDialer), defaultDialer: defaultDialer}
+	for _, v := range c.Kafka.DialOptions {
+		brokerAddress, options, err := config.ParseDialOptions(v, c.KafkaDialOptions())
+		if err != nil {
+			return nil, err
+		}
+		brokerConfig := *c
+		brokerConfig.Kafka.DialTimeout = options.DialTimeout
+		brokerConfig.Kafka.KeepAlive = options.KeepAlive
+		brokerConfig.Kafka.LocalAddress = options.LocalAddress
+		if !options.ForwardProxy {
+			brokerConfig.ForwardProxy.Url = ""
+		}
+		dialer, err := newRawDialer(&brokerConfig)
+		if err != nil {
+			return nil, err
+		}
+		host, port, _ := net.SplitHostPort(brokerAddress)
+		if host == "*" {
+			d.byPort[port] = brokerDialer{dialer: dialer, dialTimeout: options.DialTimeout}
+		} else {
+			d.byAddress[brokerAddress] = brokerDialer{dialer: dialer, dialTimeout: options.DialTimeout}
+		}
+	}
+	logrus.Infof("Brokers %v are dialed with own dial options", c.Kafka.DialOptions)
+	return d, nil
+}
+
+func (d dialOptionsDialer) lookup(addr string) (brokerDialer, bool) {
+	if dialer, ok := d.byAddress[addr]; ok {
+		return dialer, true
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		if dialer, ok := d.byPort[port]; ok {
+			return dialer, true
+		}
+	}
+	return brokerDialer{}, false
+}
+
+// dialTimeout returns the dial timeout of the broker, it also limits the TLS handshake
+func (d dialOptionsDialer) dialTimeout(addr string, defaultTimeout time.Duration) time.Duration {
+	if dialer, ok := d.lookup(addr); ok {
+		return dialer.dialTimeout
+	}
+	return defaultTimeout
+}
+
+func (d dialOptionsDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d dialOptionsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer, ok := d.lookup(addr); ok {
+		return dialer.dialer.DialContext(ctx, network, addr)
+	}
+	return d.defaultDialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialOptionsDialerSelectsBrokerDialer(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.ForwardProxy.Url = "socks5://127.0.0.1:1080"
+	c.ForwardProxy.Scheme = "socks5"
+	c.ForwardProxy.Address = "127.0.0.1:1080"
+	defaultDialer, err := newRawDialer(c)
+	a.Nil(err)
+	dialer, err := newDialOptionsDialer(c, defaultDialer)
+	a.Nil(err)
+	a.Equal(defaultDialer, dialer)
+
+	c.Kafka.DialOptions = []string{"kafka-local:9092=forward-proxy=false,dial-timeout=1s", "*:9093=dial-timeout=30s,keep-alive=1m"}
+	dialer, err = newDialOptionsDialer(c, defaultDialer)
+	a.Nil(err)
+	options := dialer.(dialOptionsDialer)
+
+	local, ok := options.lookup("kafka-local:9092")
+	a.True(ok)
+	a.IsType(directDialer{}, local.dialer)
+	a.Equal(time.Second, local.dialer.(directDialer).dialTimeout)
+	a.Equal(c.Kafka.KeepAlive, local.dialer.(directDialer).keepAlive)
+	remote, ok := options.lookup("kafka-remote:9093")
+	a.True(ok)
+	a.IsType(&socks5Dialer{}, remote.dialer)
+	a.Equal(30*time.Second, remote.dialer.(*socks5Dialer).directDialer.dialTimeout)
+	a.Equal(time.Minute, remote.dialer.(*socks5Dialer).directDialer.keepAlive)
+	_, ok = options.lookup("kafka-remote:9092")
+	a.False(ok)
+
+	a.Equal(time.Second, options.dialTimeout("kafka-local:9092", c.Kafka.DialTimeout))
+	a.Equal(30*time.Second, options.dialTimeout("kafka-remote:9093", c.Kafka.DialTimeout))
+	a.Equal(c.Kafka.DialTimeout, options.dialTimeout("kafka-remote:9092", c.Kafka.DialTimeout))
+}
+
+func TestDialOptionsDialerBypassesForwardProxy(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+	// nothing listens on the forward proxy address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	forwardProxyAddress := l.Addr().String()
+	l.Close()
+
+	c := config.NewConfig()
+	c.ForwardProxy.Url = "socks5://" + forwardProxyAddress
+	c.ForwardProxy.Scheme = "socks5"
+	c.ForwardProxy.Address = forwardProxyAddress
+	c.Kafka.DialOptions = []string{broker.Addr() + "=forward-proxy=false"}
+	dialer, err := newDialer(c, nil)
+	a.Nil(err)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", broker.Addr())
+	a.Nil(err)
+	conn.Close()
+	_, err = dialer.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	a.NotNil(err)
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/armon/go-socks5"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentListener accepts connections but never answers
+func silentListener(a *assert.Assertions) (net.Listener, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return ln, func() {
+		ln.Close()
+		close(conns)
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestHttpProxyDialContextCancel(t *testing.T) {
+	a := assert.New(t)
+
+	ln, stop := silentListener(a)
+	defer stop()
+
+	dialer := &httpProxy{
+		forwardDialer: directDialer{dialTimeout: time.Minute},
+		network:       "tcp",
+		hostPort:      ln.Addr().String(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", "kafka-0:9092")
+	a.Nil(conn)
+	a.Equal(context.Canceled, err)
+	a.True(time.Since(start) < 10*time.Second)
+}
+
+func TestTLSDialContextCancel(t *testing.T) {
+	a := assert.New(t)
+
+	ln, stop := silentListener(a)
+	defer stop()
+
+	dialer := tlsDialer{
+		timeout:   time.Minute,
+		rawDialer: directDialer{dialTimeout: time.Minute},
+		config:    &tls.Config{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", ln.Addr().String())
+	a.Nil(conn)
+	a.Equal(context.Canceled, err)
+	a.True(time.Since(start) < 10*time.Second)
+}
+
+func TestTLSDialHandshakeTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	ln, stop := silentListener(a)
+	defer stop()
+
+	dialer := tlsDialer{
+		timeout:   100 * time.Millisecond,
+		rawDialer: directDialer{dialTimeout: time.Minute},
+		config:    &tls.Config{},
+	}
+	conn, err := dialer.Dial("tcp", ln.Addr().String())
+	a.Nil(conn)
+	a.NotNil(err)
+	a.Contains(err.Error(), "Handshake timeout")
+}
+
+// recordingResolver resolves every name to the loopback address and records the names other than IP addresses
+type recordingResolver struct {
+	names chan string
+}
+
+func (r recordingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if net.ParseIP(host) == nil {
+		r.names <- host
+	}
+	return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+}
+
+func (r recordingResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	ips, err := r.LookupIP(ctx, name)
+	return ctx, ips[0], err
+}
+
+func TestSocks5HostNameResolution(t *testing.T) {
+	a := assert.New(t)
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+
+	remoteResolver := recordingResolver{names: make(chan string, 10)}
+	server, err := socks5.New(&socks5.Config{Resolver: remoteResolver})
+	a.Nil(err)
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer proxyListener.Close()
+	go server.Serve(proxyListener)
+
+	localResolver := recordingResolver{names: make(chan string, 10)}
+	dialer := socks5Dialer{
+		directDialer: directDialer{dialTimeout: 2 * time.Second, resolver: localResolver},
+		proxyNetwork: "tcp",
+		proxyAddr:    proxyListener.Addr().String(),
+	}
+
+	// socks5 resolves the broker name locally
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("kafka-1.internal", port))
+	a.Nil(err)
+	conn.Close()
+	a.Equal("kafka-1.internal", <-localResolver.names)
+	a.Len(remoteResolver.names, 0)
+
+	// socks5h sends the broker name to the proxy
+	dialer.remoteResolve = true
+	conn, err = dialer.Dial("tcp", net.JoinHostPort("kafka-2.internal", port))
+	a.Nil(err)
+	conn.Close()
+	a.Equal("kafka-2.internal", <-remoteResolver.names)
+	a.Len(localResolver.names, 0)
+}
+
+func TestDirectDialBindsLocalAddress(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	accepted := make(chan net.Addr, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn.RemoteAddr()
+			conn.Close()
+		}
+	}()
+
+	localAddr, err := newLocalAddr("")
+	a.Nil(err)
+	a.Nil(localAddr)
+	for _, tt := range []struct {
+		localAddress string
+		ip           string
+	}{
+		{localAddress: "127.0.0.2", ip: "127.0.0.2"},
+		{localAddress: "lo", ip: "127.0.0.1"},
+	} {
+		localAddr, err := newLocalAddr(tt.localAddress)
+		a.Nil(err)
+		conn, err := directDialer{dialTimeout: time.Second, localAddr: localAddr}.Dial("tcp", ln.Addr().String())
+		a.Nil(err)
+		conn.Close()
+		a.Equal(tt.ip, (<-accepted).(*net.TCPAddr).IP.String(), tt.localAddress)
+	}
+	_, err = newLocalAddr("no-such-interface0")
+	a.NotNil(err)
+}
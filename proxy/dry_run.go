@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	dryRunDeny     = "deny"
+	dryRunThrottle = "throttle"
+)
+
+// policyDryRun evaluates the policies without rejecting or throttling the requests, so new policies can be validated
+// against the production traffic before they are enforced. The violations are counted and logged at most once per policy and log interval.
+type policyDryRun struct {
+	policies    map[string]struct{}
+	logInterval time.Duration
+	now         func() time.Time
+
+	lock    sync.Mutex
+	lastLog map[string]time.Time
+	// violations of the policy since its last log
+	suppressed map[string]int
+}
+
+// newPolicyDryRun returns nil if all policies are enforced
+func newPolicyDryRun(c *config.Config) *policyDryRun {
+	if len(c.DryRun.Policies) == 0 {
+		return nil
+	}
+	d := &policyDryRun{
+		policies:    make(map[string]struct{}),
+		logInterval: c.DryRun.LogInterval,
+		now:         time.Now,
+		lastLog:     make(map[string]time.Time),
+		suppressed:  make(map[string]int),
+	}
+	for _, policy := range c.DryRun.Policies {
+		d.policies[policy] = struct{}{}
+	}
+	logrus.Warnf("Policies %v are evaluated in a dry run, the requests will not be rejected or throttled by them", c.DryRun.Policies)
+	return d
+}
+
+// enabled reports whether the policy is evaluated in a dry run
+func (d *policyDryRun) enabled(policy string) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.policies[policy]
+	return ok
+}
+
+// deny counts and logs the request which would be rejected by the policy
+func (d *policyDryRun) deny(policy string, reason string) {
+	d.report(policy, dryRunDeny, reason)
+}
+
+// throttle counts and logs the request which would be delayed by the policy
+func (d *policyDryRun) throttle(policy string, delay time.Duration, reason string) {
+	d.report(policy, dryRunThrottle, reason+" for "+delay.String())
+}
+
+// report returns the number of the violations of the policy which were not logged before this one or -1 if this one is not logged
+func (d *policyDryRun) report(policy string, action string, reason string) int {
+	proxyPolicyDryRunTotal.WithLabelValues(policy, action).Inc()
+	if d.logInterval == 0 {
+		return -1
+	}
+	now := d.now()
+	d.lock.Lock()
+	if last, ok := d.lastLog[policy]; ok && now.Sub(last) < d.logInterval {
+		d.suppressed[policy]++
+		d.lock.Unlock()
+		return -1
+	}
+	suppressed := d.suppressed[policy]
+	d.lastLog[policy] = now
+	d.suppressed[policy] = 0
+	d.lock.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"policy":     policy,
+		"action":     action,
+		"suppressed": suppressed,
+	}).Warnf("Dry run of policy %s would %s: %s, %d violations were not logged since the last log", policy, action, reason, suppressed)
+	return suppressed
+}
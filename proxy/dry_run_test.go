@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPolicyDryRun(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newPolicyDryRun(c))
+	c.DryRun.Policies = []string{config.DryRunTransactions, config.DryRunEgress}
+	d := newPolicyDryRun(c)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	a.True(d.enabled(config.DryRunTransactions))
+	a.False(d.enabled(config.DryRunApiKeys))
+
+	before := counterOf(a, proxyPolicyDryRunTotal, config.DryRunTransactions, dryRunDeny)
+	a.Equal(0, d.report(config.DryRunTransactions, dryRunDeny, "test"))
+	a.Equal(-1, d.report(config.DryRunTransactions, dryRunDeny, "test"))
+	// logged per policy
+	a.Equal(0, d.report(config.DryRunEgress, dryRunThrottle, "test"))
+	now = now.Add(time.Minute)
+	a.Equal(1, d.report(config.DryRunTransactions, dryRunDeny, "test"))
+	a.Equal(before+3, counterOf(a, proxyPolicyDryRunTotal, config.DryRunTransactions, dryRunDeny))
+
+	// transactional request of a not allowed principal
+	c.Transactions.AllowPrincipals = []string{"^payments-"}
+	policy, err := newTransactionPolicy(c)
+	a.Nil(err)
+	policy.dryRun = d
+	a.Nil(policy.check("orders-app", &protocol.RequestKeyVersion{ApiKey: 26, ApiVersion: 1}, nil))
+	a.Equal(before+4, counterOf(a, proxyPolicyDryRunTotal, config.DryRunTransactions, dryRunDeny))
+
+	// the responses exceeding the limit are not delayed and not owed
+	c.Egress.ClientIDLimits = []string{"^mirror-maker=100,10"}
+	shaper, err := newEgressShaper(c)
+	a.Nil(err)
+	shaper.dryRun = d
+	session := shaper.newSession()
+	session.update("", "mirror-maker-1")
+	a.Equal(time.Duration(0), session.take(10))
+	a.Equal(time.Duration(0), session.take(50))
+	a.True(session.limiter.tokens >= 0)
+
+	// nil safe
+	var disabled *policyDryRun
+	a.False(disabled.enabled(config.DryRunEgress))
+}
+
+func TestRateLimiterExceeds(t *testing.T) {
+	a := assert.New(t)
+
+	limiter := newRateLimiter(10, 2)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	a.Equal(time.Duration(0), limiter.exceedsN(1))
+	a.Equal(time.Duration(0), limiter.exceedsN(1))
+	a.Equal(100*time.Millisecond, limiter.exceedsN(1))
+	// the tokens were not consumed
+	a.Equal(100*time.Millisecond, limiter.exceedsN(1))
+	now = now.Add(100 * time.Millisecond)
+	a.Equal(time.Duration(0), limiter.exceedsN(1))
+}
+
+func TestProxyDryRunForwardsForbiddenRequests(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ReadOnly = true
+	c.ClientID.Deny = []string{"^legacy-"}
+	c.ClientID.Throttle = []string{"^legacy-=1"}
+	c.DryRun.Policies = []string{config.DryRunApiKeys, config.DryRunClientIDDeny, config.DryRunClientIDThrottle}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	before := counterOf(a, proxyPolicyDryRunTotal, config.DryRunApiKeys, dryRunDeny)
+	start := time.Now()
+	for i := int32(1); i <= 3; i++ {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, i, "legacy-app", []byte{1}))
+		a.Nil(err)
+		_, _, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+	}
+	// not throttled
+	a.True(time.Since(start) < time.Second)
+	a.Equal(3, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(before+3, counterOf(a, proxyPolicyDryRunTotal, config.DryRunApiKeys, dryRunDeny))
+	a.True(counterOf(a, proxyPolicyDryRunTotal, config.DryRunClientIDDeny, dryRunDeny) >= 3)
+	a.True(counterOf(a, proxyPolicyDryRunTotal, config.DryRunClientIDThrottle, dryRunThrottle) >= 2)
+}
@@ -0,0 +1,361 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dynamicPorts assigns the ports of the dynamic listeners, all replicas assign the same port to the broker address
+type dynamicPorts interface {
+	assign(brokerAddress string) (int, error)
+}
+
+func newDynamicPorts(c *config.Config) dynamicPorts {
+	cfg := c.Proxy.DynamicPorts
+	switch cfg.Coordination {
+	case config.DynamicPortsHash:
+		return &hashPorts{minPort: cfg.MinPort, maxPort: cfg.MaxPort, ports: make(map[string]int), brokers: make(map[int]string)}
+	case config.DynamicPortsBrokerIndex:
+		return &hashPorts{minPort: cfg.MinPort, maxPort: cfg.MaxPort, ports: make(map[string]int), brokers: make(map[int]string), byBrokerIndex: true}
+	case config.DynamicPortsFile:
+		return &filePorts{path: cfg.File, minPort: cfg.MinPort, maxPort: cfg.MaxPort, lockTimeout: cfg.Timeout}
+	case config.DynamicPortsEtcd:
+		return &etcdPorts{
+			endpoints:  cfg.EtcdEndpoints,
+			prefix:     strings.TrimSuffix(cfg.EtcdPrefix, "/"),
+			minPort:    cfg.MinPort,
+			maxPort:    cfg.MaxPort,
+			httpClient: &http.Client{Timeout: cfg.Timeout},
+		}
+	default:
+		return nil
+	}
+}
+
+// firstFreePort returns the lowest port of the range which is not used
+func firstFreePort(used map[int]bool, minPort int, maxPort int) (int, error) {
+	for port := minPort; port <= maxPort; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, errors.Errorf("no free dynamic port in range %d-%d", minPort, maxPort)
+}
+
+// hashPorts derives the port from the hash of the broker address without any shared state.
+// Colliding brokers get the next free port which is the same on the replicas only if they see the brokers in the same order.
+type hashPorts struct {
+	minPort int
+	maxPort int
+	// the port of the b<N>- brokers is the min port plus N
+	byBrokerIndex bool
+
+	lock    sync.Mutex
+	ports   map[string]int
+	brokers map[int]string
+}
+
+func (h *hashPorts) assign(brokerAddress string) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if port, ok := h.ports[brokerAddress]; ok {
+		return port, nil
+	}
+	if index, ok := brokerIndex(brokerAddress); ok && h.byBrokerIndex {
+		port := h.minPort + index
+		if port > h.maxPort {
+			return 0, errors.Errorf("port of broker %s is above the dynamic port range %d-%d", brokerAddress, h.minPort, h.maxPort)
+		}
+		if other, ok := h.brokers[port]; ok {
+			return 0, errors.Errorf("port %d of broker %s is assigned to broker %s", port, brokerAddress, other)
+		}
+		h.ports[brokerAddress] = port
+		h.brokers[port] = brokerAddress
+		return port, nil
+	}
+	size := h.maxPort - h.minPort + 1
+	hash := fnv.New32a()
+	hash.Write([]byte(brokerAddress))
+	start := int(hash.Sum32() % uint32(size))
+	for i := 0; i < size; i++ {
+		port := h.minPort + (start+i)%size
+		if _, ok := h.brokers[port]; ok {
+			continue
+		}
+		if i > 0 {
+			logrus.Warnf("Dynamic port of broker %s collides with broker %s, the port %d may differ between the replicas", brokerAddress, h.brokers[h.minPort+start], port)
+		}
+		h.ports[brokerAddress] = port
+		h.brokers[port] = brokerAddress
+		return port, nil
+	}
+	return 0, errors.Errorf("no free dynamic port in range %d-%d", h.minPort, h.maxPort)
+}
+
+// brokerIndex returns N of the broker host names b<N>-... e.g. b3-pkc-4r087.us-west2.gcp.confluent.cloud
+func brokerIndex(brokerAddress string) (int, bool) {
+	host := brokerAddress
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	i := strings.Index(host, "-")
+	if i < 2 || host[0] != 'b' {
+		return 0, false
+	}
+	index, err := strconv.Atoi(host[1:i])
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// filePorts keeps the assigned ports in a YAML file shared by the replicas, it is changed while holding a lock file
+type filePorts struct {
+	path        string
+	minPort     int
+	maxPort     int
+	lockTimeout time.Duration
+}
+
+func (f *filePorts) assign(brokerAddress string) (int, error) {
+	unlock, err := f.lockFile()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	ports := make(map[string]int)
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrapf(err, "cannot read dynamic ports file %s", f.path)
+	}
+	if err = yaml.Unmarshal(data, &ports); err != nil {
+		return 0, errors.Wrapf(err, "invalid dynamic ports file %s", f.path)
+	}
+	if port, ok := ports[brokerAddress]; ok {
+		return port, nil
+	}
+	used := make(map[int]bool)
+	for _, port := range ports {
+		used[port] = true
+	}
+	port, err := firstFreePort(used, f.minPort, f.maxPort)
+	if err != nil {
+		return 0, err
+	}
+	ports[brokerAddress] = port
+	if data, err = yaml.Marshal(ports); err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path))
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot write dynamic ports file %s", f.path)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot write dynamic ports file %s", f.path)
+	}
+	return port, nil
+}
+
+// lockFile creates the lock file next to the ports file. The lock of a replica which crashed is removed after the timeout.
+func (f *filePorts) lockFile() (func(), error) {
+	lockPath := f.path + ".lock"
+	deadline := time.Now().Add(f.lockTimeout)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "cannot lock dynamic ports file %s", f.path)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > f.lockTimeout {
+			logrus.Warnf("Removing stale lock file %s", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("dynamic ports file %s is locked for more than %v", f.path, f.lockTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// etcdPorts keeps the assigned ports in etcd using its v3 JSON gateway. The broker and the port keys are created
+// in one transaction, so neither the broker nor the port is assigned twice.
+type etcdPorts struct {
+	endpoints  []string
+	prefix     string
+	minPort    int
+	maxPort    int
+	httpClient *http.Client
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdCompare struct {
+	Key            []byte `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	CreateRevision string `json:"create_revision"`
+}
+
+type etcdRequestOp struct {
+	RequestPut *etcdPutRequest `json:"request_put,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+func (e *etcdPorts) brokerKey(brokerAddress string) []byte {
+	return []byte(e.prefix + "/brokers/" + brokerAddress)
+}
+
+func (e *etcdPorts) portKey(port int) []byte {
+	return []byte(e.prefix + "/ports/" + strconv.Itoa(port))
+}
+
+func (e *etcdPorts) assign(brokerAddress string) (int, error) {
+	brokerKey := e.brokerKey(brokerAddress)
+	for i := 0; i <= e.maxPort-e.minPort; i++ {
+		// assigned brokers and ports
+		prefix := []byte(e.prefix + "/")
+		var assigned etcdRangeResponse
+		if err := e.post("/v3/kv/range", &etcdRangeRequest{Key: prefix, RangeEnd: etcdPrefixEnd(prefix)}, &assigned); err != nil {
+			return 0, err
+		}
+		portsPrefix := e.prefix + "/ports/"
+		used := make(map[int]bool)
+		for _, kv := range assigned.Kvs {
+			if bytes.Equal(kv.Key, brokerKey) {
+				port, err := strconv.Atoi(string(kv.Value))
+				if err != nil {
+					return 0, errors.Errorf("invalid dynamic port %s of etcd key %s", kv.Value, kv.Key)
+				}
+				return port, nil
+			}
+			if strings.HasPrefix(string(kv.Key), portsPrefix) {
+				port, err := strconv.Atoi(strings.TrimPrefix(string(kv.Key), portsPrefix))
+				if err != nil {
+					return 0, errors.Errorf("invalid dynamic port etcd key %s", kv.Key)
+				}
+				used[port] = true
+			}
+		}
+		port, err := firstFreePort(used, e.minPort, e.maxPort)
+		if err != nil {
+			return 0, err
+		}
+		portKey := e.portKey(port)
+		txn := &etcdTxnRequest{
+			Compare: []etcdCompare{
+				{Key: brokerKey, Target: "CREATE", Result: "EQUAL", CreateRevision: "0"},
+				{Key: portKey, Target: "CREATE", Result: "EQUAL", CreateRevision: "0"},
+			},
+			Success: []etcdRequestOp{
+				{RequestPut: &etcdPutRequest{Key: brokerKey, Value: []byte(strconv.Itoa(port))}},
+				{RequestPut: &etcdPutRequest{Key: portKey, Value: []byte(brokerAddress)}},
+			},
+		}
+		var resp etcdTxnResponse
+		if err := e.post("/v3/kv/txn", txn, &resp); err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return port, nil
+		}
+		// another replica assigned the broker or the port in the meantime
+		logrus.Debugf("Dynamic port %d of broker %s was assigned concurrently, retrying", port, brokerAddress)
+	}
+	return 0, errors.Errorf("no free dynamic port in range %d-%d", e.minPort, e.maxPort)
+}
+
+// post sends the request to the endpoints in turn until one of them responds
+func (e *etcdPorts) post(path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, endpoint := range e.endpoints {
+		resp, err := e.httpClient.Post(strings.TrimSuffix(endpoint, "/")+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = errors.Wrapf(err, "etcd request to %s failed", endpoint)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			lastErr = errors.Errorf("etcd %s returned status %d", endpoint, resp.StatusCode)
+			continue
+		}
+		err = json.NewDecoder(resp.Body).Decode(response)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrapf(err, "invalid etcd response from %s", endpoint)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// etcdPrefixEnd returns the end of the range of all keys with the prefix
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys
+	return []byte{0}
+}
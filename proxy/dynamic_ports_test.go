@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDynamicPorts(coordination string, configure func(c *config.Config)) dynamicPorts {
+	c := config.NewConfig()
+	c.Proxy.DynamicPorts.Coordination = coordination
+	c.Proxy.DynamicPorts.MinPort = 32500
+	c.Proxy.DynamicPorts.MaxPort = 32502
+	if configure != nil {
+		configure(c)
+	}
+	return newDynamicPorts(c)
+}
+
+func TestHashDynamicPorts(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(newDynamicPorts(config.NewConfig()))
+
+	replica1 := newTestDynamicPorts(config.DynamicPortsHash, nil)
+	replica2 := newTestDynamicPorts(config.DynamicPortsHash, nil)
+	ports := make(map[int]bool)
+	for _, brokerAddress := range []string{"kafka-1:9092", "kafka-2:9092", "kafka-3:9092"} {
+		port, err := replica1.assign(brokerAddress)
+		a.Nil(err)
+		a.True(port >= 32500 && port <= 32502)
+		ports[port] = true
+		port2, err := replica2.assign(brokerAddress)
+		a.Nil(err)
+		a.Equal(port, port2)
+		// stable
+		port2, err = replica1.assign(brokerAddress)
+		a.Nil(err)
+		a.Equal(port, port2)
+	}
+	a.Len(ports, 3)
+	_, err := replica1.assign("kafka-4:9092")
+	a.EqualError(err, "no free dynamic port in range 32500-32502")
+}
+
+func TestFileDynamicPorts(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "dynamic-ports")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ports.yaml")
+	configure := func(c *config.Config) {
+		c.Proxy.DynamicPorts.File = file
+		c.Proxy.DynamicPorts.Timeout = 200 * time.Millisecond
+	}
+	replica1 := newTestDynamicPorts(config.DynamicPortsFile, configure)
+	replica2 := newTestDynamicPorts(config.DynamicPortsFile, configure)
+
+	port, err := replica1.assign("kafka-1:9092")
+	a.Nil(err)
+	a.Equal(32500, port)
+	port, err = replica2.assign("kafka-2:9092")
+	a.Nil(err)
+	a.Equal(32501, port)
+	port, err = replica2.assign("kafka-1:9092")
+	a.Nil(err)
+	a.Equal(32500, port)
+
+	data, err := ioutil.ReadFile(file)
+	a.Nil(err)
+	a.Equal("kafka-1:9092: 32500\nkafka-2:9092: 32501\n", string(data))
+
+	// the lock of a crashed replica is removed after the timeout
+	a.Nil(ioutil.WriteFile(file+".lock", nil, 0644))
+	start := time.Now()
+	port, err = replica1.assign("kafka-3:9092")
+	a.Nil(err)
+	a.Equal(32502, port)
+	a.True(time.Since(start) >= 200*time.Millisecond)
+	_, err = os.Stat(file + ".lock")
+	a.True(os.IsNotExist(err))
+}
+
+// fakeEtcd implements the range and txn requests of the etcd v3 JSON gateway used by the dynamic ports
+type fakeEtcd struct {
+	lock sync.Mutex
+	kvs  map[string]string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req etcdRangeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := etcdRangeResponse{}
+		for k, v := range f.kvs {
+			if k >= string(req.Key) && k < string(req.RangeEnd) {
+				resp.Kvs = append(resp.Kvs, etcdKeyValue{Key: []byte(k), Value: []byte(v)})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/kv/txn":
+		var req etcdTxnRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := etcdTxnResponse{Succeeded: true}
+		for _, cmp := range req.Compare {
+			if _, ok := f.kvs[string(cmp.Key)]; ok {
+				resp.Succeeded = false
+			}
+		}
+		if resp.Succeeded {
+			for _, op := range req.Success {
+				f.kvs[string(op.RequestPut.Key)] = string(op.RequestPut.Value)
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdDynamicPorts(t *testing.T) {
+	a := assert.New(t)
+
+	etcd := &fakeEtcd{kvs: map[string]string{
+		// the port was claimed by another replica which did not finish the assignment
+		"/kafka-proxy/dynamic-ports/ports/32500": "kafka-0:9092",
+	}}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	configure := func(c *config.Config) {
+		// the unavailable endpoint is skipped
+		c.Proxy.DynamicPorts.EtcdEndpoints = []string{"http://127.0.0.1:1", server.URL}
+	}
+	replica1 := newTestDynamicPorts(config.DynamicPortsEtcd, configure)
+	replica2 := newTestDynamicPorts(config.DynamicPortsEtcd, configure)
+
+	port, err := replica1.assign("kafka-1:9092")
+	a.Nil(err)
+	a.Equal(32501, port)
+	port, err = replica2.assign("kafka-1:9092")
+	a.Nil(err)
+	a.Equal(32501, port)
+	port, err = replica2.assign("kafka-2:9092")
+	a.Nil(err)
+	a.Equal(32502, port)
+	a.Equal("kafka-2:9092", etcd.kvs["/kafka-proxy/dynamic-ports/ports/32502"])
+	a.Equal("32502", etcd.kvs["/kafka-proxy/dynamic-ports/brokers/kafka-2:9092"])
+
+	_, err = replica1.assign("kafka-3:9092")
+	a.EqualError(err, "no free dynamic port in range 32500-32502")
+
+	server.Close()
+	_, err = replica1.assign("kafka-3:9092")
+	a.NotNil(err)
+	a.True(strings.HasPrefix(err.Error(), "etcd request to "+server.URL+" failed"))
+}
+
+func TestBrokerIndexDynamicPorts(t *testing.T) {
+	a := assert.New(t)
+
+	ports := newTestDynamicPorts(config.DynamicPortsBrokerIndex, func(c *config.Config) { c.Proxy.DynamicPorts.MaxPort = 32599 })
+	for address, expected := range map[string]int{
+		"b0-pkc-4r087.us-west2.gcp.confluent.cloud:9092":  32500,
+		"b12-pkc-4r087.us-west2.gcp.confluent.cloud:9092": 32512,
+	} {
+		port, err := ports.assign(address)
+		a.Nil(err)
+		a.Equal(expected, port)
+	}
+	port, err := ports.assign("kafka-1:9092")
+	a.Nil(err)
+	a.True(port >= 32500 && port <= 32599)
+	_, err = ports.assign("b100-pkc-4r087.us-west2.gcp.confluent.cloud:9092")
+	a.EqualError(err, "port of broker b100-pkc-4r087.us-west2.gcp.confluent.cloud:9092 is above the dynamic port range 32500-32599")
+
+	for address, expected := range map[string]int{"b7-pkc-1:9092": 7, "b0-x": 0} {
+		index, ok := brokerIndex(address)
+		a.True(ok)
+		a.Equal(expected, index)
+	}
+	for _, address := range []string{"pkc-4r087.us-west2.gcp.confluent.cloud:9092", "broker-1:9092", "b-1:9092", "kafka-1:9092"} {
+		_, ok := brokerIndex(address)
+		a.False(ok, address)
+	}
+}
+
+func TestListenersCoordinatedDynamicPort(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.DynamicPorts.Coordination = config.DynamicPortsHash
+	c.Proxy.DynamicPorts.MinPort = 32500
+	c.Proxy.DynamicPorts.MaxPort = 32599
+	c.Proxy.DynamicPorts.AdvertisedHost = "kafka-proxy"
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+
+	expected, err := newDynamicPorts(c).assign("kafka-1:9092")
+	a.Nil(err)
+	host, port, err := listeners.ListenDynamicInstance("kafka-1:9092")
+	a.Nil(err)
+	a.Equal("kafka-proxy", host)
+	a.Equal(int32(expected), port)
+	a.Equal(listeners.dynamicListeners["kafka-1:9092"].Addr().String(), listeners.brokerToListenerConfig["kafka-1:9092"].ListenerAddress)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// egressLimit is the response bandwidth of each connection with matching principal or client id
+type egressLimit struct {
+	pattern *regexp.Regexp
+	rate    float64
+	burst   int
+	// metrics
+	label string
+}
+
+// egressShaper limits the bandwidth of the responses sent to the clients, e.g. replication consumers pulling full topics,
+// without throttling other clients. Principal limits take precedence over client id limits.
+type egressShaper struct {
+	principals []*egressLimit
+	clientIDs  []*egressLimit
+	// the responses are not delayed if the limits are evaluated in a dry run
+	dryRun *policyDryRun
+}
+
+// newEgressShaper returns nil if no egress limits are configured
+func newEgressShaper(c *config.Config) (*egressShaper, error) {
+	if len(c.Egress.PrincipalLimits) == 0 && len(c.Egress.ClientIDLimits) == 0 {
+		return nil, nil
+	}
+	s := &egressShaper{}
+	for _, v := range c.Egress.PrincipalLimits {
+		pattern, rate, burst, err := config.ParseEgressLimit(v)
+		if err != nil {
+			return nil, err
+		}
+		s.principals = append(s.principals, &egressLimit{pattern: pattern, rate: rate, burst: burst, label: "principal:" + pattern.String()})
+	}
+	for _, v := range c.Egress.ClientIDLimits {
+		pattern, rate, burst, err := config.ParseEgressLimit(v)
+		if err != nil {
+			return nil, err
+		}
+		s.clientIDs = append(s.clientIDs, &egressLimit{pattern: pattern, rate: rate, burst: burst, label: "client-id:" + pattern.String()})
+	}
+	logrus.Warnf("Responses to principals matching %v and client ids matching %v will be shaped.", c.Egress.PrincipalLimits, c.Egress.ClientIDLimits)
+	return s, nil
+}
+
+func (s *egressShaper) match(principal string, clientID string) *egressLimit {
+	if principal != "" {
+		for _, limit := range s.principals {
+			if limit.pattern.MatchString(principal) {
+				return limit
+			}
+		}
+	}
+	for _, limit := range s.clientIDs {
+		if limit.pattern.MatchString(clientID) {
+			return limit
+		}
+	}
+	return nil
+}
+
+func (s *egressShaper) newSession() *egressSession {
+	if s == nil {
+		return nil
+	}
+	return &egressSession{shaper: s}
+}
+
+// egressSession is the token bucket of a connection. The limit is selected by the requests loop and consumed by the responses loop.
+type egressSession struct {
+	shaper *egressShaper
+
+	lock      sync.Mutex
+	matched   bool
+	principal string
+	clientID  string
+	limit     *egressLimit
+	limiter   *rateLimiter
+}
+
+// update selects the limit when the principal or the client id of the connection is changed
+func (s *egressSession) update(principal string, clientID string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.matched && principal == s.principal && clientID == s.clientID {
+		return
+	}
+	s.matched = true
+	s.principal, s.clientID = principal, clientID
+	if limit := s.shaper.match(principal, clientID); limit != s.limit {
+		s.limit = limit
+		s.limiter = nil
+		if limit != nil {
+			s.limiter = newRateLimiter(limit.rate, limit.burst)
+		}
+	}
+}
+
+// take consumes the response size and returns how long the response has to be delayed
+func (s *egressSession) take(size int32) time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.shaper.dryRun.enabled(config.DryRunEgress) {
+		if delay := s.limiter.exceedsN(float64(size)); delay > 0 {
+			s.shaper.dryRun.throttle(config.DryRunEgress, delay, fmt.Sprintf("response of %d bytes to principal %q and client id %q", size, s.principal, s.clientID))
+		}
+		return 0
+	}
+	delay := s.limiter.takeN(float64(size))
+	if delay > 0 {
+		proxyEgressShapedSecondsTotal.WithLabelValues(s.limit.label).Add(delay.Seconds())
+	}
+	return delay
+}
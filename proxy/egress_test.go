@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEgressSessionSelectsLimit(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := newEgressShaper(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	a.Equal(time.Duration(0), disabled.newSession().take(1000))
+
+	c.Egress.PrincipalLimits = []string{"^replicator$=1000"}
+	c.Egress.ClientIDLimits = []string{"^mirror-maker=100,10"}
+	shaper, err := newEgressShaper(c)
+	a.Nil(err)
+
+	session := shaper.newSession()
+	session.update("", "app-1")
+	a.Equal(time.Duration(0), session.take(1000000))
+
+	session.update("", "mirror-maker-1")
+	a.Equal("client-id:^mirror-maker", session.limit.label)
+	a.Equal(time.Duration(0), session.take(10))
+	a.True(session.take(50) >= 490*time.Millisecond)
+
+	// principal limit takes precedence
+	session.update("replicator", "mirror-maker-1")
+	a.Equal("principal:^replicator$", session.limit.label)
+	a.Equal(time.Duration(0), session.take(1000))
+	a.True(session.take(1000) >= 990*time.Millisecond)
+}
+
+func TestProxyShapesResponsesOfClientID(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, ResponseSize: 20000})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Egress.ClientIDLimits = []string{"^mirror-maker=100000,1000"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	fetch := func(clientID string) time.Duration {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		defer conn.Close()
+		start := time.Now()
+		for i := int32(0); i < 2; i++ {
+			_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, i, clientID, []byte("fetch")))
+			a.Nil(err)
+			_, body, err := kafkatest.ReadResponse(conn)
+			a.Nil(err)
+			a.Len(body, 20000)
+		}
+		return time.Since(start)
+	}
+	a.True(fetch("app-1") < 300*time.Millisecond)
+	// about 40 KB above the burst at 100 KB/s
+	a.True(fetch("mirror-maker-1") >= 300*time.Millisecond)
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errIdle is returned by a parked requests or responses loop, it is not an error of the connection
+var errIdle = errors.New("connection is idle")
+
+// eventLoop is the connection engine of the deployments with very high counts of mostly idle connections. The requests loop
+// of a connection is parked when no byte of the next request is received from the client within the idle time, the responses
+// loop when no byte is received from the broker: its goroutine returns and the connection is watched by the readiness events
+// of the operating system until it becomes readable or is closed, then the loop is resumed by a new goroutine. A parked
+// connection holds its buffers and state but no goroutine. Only the plaintext TCP and Unix socket connections are parked,
+// the loops of the TLS connections run in their goroutines as with the goroutines engine.
+type eventLoop struct {
+	idleTime time.Duration
+	poller   *poller
+}
+
+// newEventLoop returns nil if the connections are served by the goroutines engine
+func newEventLoop(c *config.Config) (*eventLoop, error) {
+	if c.Proxy.ConnectionEngine != config.ConnectionEngineEventLoop {
+		return nil, nil
+	}
+	poller, err := sharedPoller()
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Connection loops idle for %v will be parked by the event loop", c.Proxy.EventLoopIdleTime)
+	return &eventLoop{idleTime: c.Proxy.EventLoopIdleTime, poller: poller}, nil
+}
+
+// wrap returns the connection which loop can be parked, the connections without a file descriptor e.g. TLS are returned unchanged.
+// All closes of the connection must go through the returned one, as a parked loop is resumed by them.
+func (l *eventLoop) wrap(conn net.Conn) net.Conn {
+	if l == nil {
+		return conn
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return conn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return conn
+	}
+	return &parkableConn{Conn: conn, raw: raw, poller: l.poller}
+}
+
+// parks reports whether no byte is received from the source within the idle time, the received bytes stay buffered
+func (l *eventLoop) parks(src DeadlineReader) bool {
+	if l == nil {
+		return false
+	}
+	var reader *bufio.Reader
+	switch v := src.(type) {
+	case *bufferedConn:
+		reader = v.reader
+	case *bufferedReader:
+		reader = v.reader
+	default:
+		return false
+	}
+	if reader.Buffered() > 0 {
+		return false
+	}
+	if err := src.SetReadDeadline(time.Now().Add(l.idleTime)); err != nil {
+		return false
+	}
+	_, err := reader.Peek(1)
+	if err := src.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// copyThenClose runs the loops of the connection like copyThenClose of the goroutines engine, but returns when a loop is parked.
+// closed is called when both loops are finished.
+func (l *eventLoop) copyThenClose(ctx context.Context, processor *processor, remote, local DeadlineReadWriteCloser, remoteDesc, localDesc string, closed func()) {
+	// blocked reads and writes are interrupted when the proxy is stopped, no goroutine waits for it
+	stop := context.AfterFunc(ctx, func() {
+		remote.Close()
+		local.Close()
+	})
+	running := int32(2)
+	finish := func() {
+		if atomic.AddInt32(&running, -1) == 0 {
+			stop()
+			closed()
+		}
+	}
+	firstErr := make(chan error, 1)
+
+	var (
+		requestsCtx *RequestsLoopContext
+		requestsSrc DeadlineReaderWriter
+		requests    func()
+	)
+	requests = func() {
+		parked := false
+		withConnectionRecover(panicGoroutineRequests, localDesc, func() {
+			if requestsCtx == nil {
+				var err error
+				if requestsCtx, err = processor.newRequestsLoopContext(remote, local); err != nil {
+					requestsLoopFinished(firstErr, true, err, remote, local, remoteDesc, localDesc)
+					return
+				}
+				if _, ok := local.(*parkableConn); ok {
+					requestsCtx.idle = l
+				}
+				// the size, header and body of a small request are read at once
+				requestsSrc = newBufferedConn(local, processor.requestBufferSize)
+			}
+			defer func() {
+				if !parked {
+					requestsCtx.close()
+				}
+			}()
+			readErr, err := requestsCtx.requestsLoop(remote, requestsSrc)
+			if err == errIdle {
+				parked = true
+				return
+			}
+			requestsLoopFinished(firstErr, readErr, err, remote, local, remoteDesc, localDesc)
+		}, remote, local)
+		if parked {
+			local.(*parkableConn).park(func() { go requests() })
+			return
+		}
+		finish()
+	}
+
+	responsesCtx := processor.newResponsesLoopContext()
+	if _, ok := remote.(*parkableConn); ok {
+		responsesCtx.idle = l
+	}
+	// the size, correlation id and body of a small response are read at once
+	responsesSrc := newBufferedReader(remote, processor.responseBufferSize)
+	var responses func()
+	responses = func() {
+		parked := false
+		withConnectionRecover(panicGoroutineResponses, localDesc, func() {
+			defer func() {
+				if !parked {
+					// the request waiting for the response of the broker is not blocked when the responses are not processed
+					processor.inFlightLimit.close()
+				}
+			}()
+			readErr, err := responsesCtx.responsesLoop(local, responsesSrc)
+			if err == errIdle {
+				parked = true
+				return
+			}
+			responsesLoopFinished(firstErr, readErr, err, remote, local, remoteDesc, localDesc)
+		}, remote, local)
+		if parked {
+			remote.(*parkableConn).park(func() { go responses() })
+			return
+		}
+		finish()
+	}
+
+	go requests()
+	responses()
+}
+
+// parkableConn is a connection of the event loop which loop can be parked until the connection is readable or closed
+type parkableConn struct {
+	net.Conn
+	raw    syscall.RawConn
+	poller *poller
+
+	lock   sync.Mutex
+	closed bool
+	// resumes the parked loop, nil if the loop is not parked
+	resume func()
+}
+
+// park calls resume when the connection is readable or closed, at once if the readiness cannot be awaited
+func (c *parkableConn) park(resume func()) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		resume()
+		return
+	}
+	c.resume = resume
+	if err := c.poller.wait(c.raw, c.ready); err != nil {
+		c.resume = nil
+		c.lock.Unlock()
+		logrus.Debugf("Connection %v cannot be parked: %v", c.RemoteAddr(), err)
+		resume()
+		return
+	}
+	proxyParkedLoops.Inc()
+	c.lock.Unlock()
+}
+
+func (c *parkableConn) ready() {
+	c.lock.Lock()
+	resume := c.resume
+	c.resume = nil
+	c.lock.Unlock()
+	if resume != nil {
+		proxyParkedLoops.Dec()
+		resume()
+	}
+}
+
+// Close resumes the parked loop, which reads from the closed connection and finishes
+func (c *parkableConn) Close() error {
+	c.lock.Lock()
+	c.closed = true
+	resume := c.resume
+	c.resume = nil
+	if resume != nil {
+		c.poller.cancel(c.raw)
+	}
+	c.lock.Unlock()
+	err := c.Conn.Close()
+	if resume != nil {
+		proxyParkedLoops.Dec()
+		resume()
+	}
+	return err
+}
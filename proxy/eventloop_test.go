@@ -0,0 +1,159 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func parkedLoops(a *assert.Assertions) float64 {
+	m := &dto.Metric{}
+	a.Nil(proxyParkedLoops.Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func waitForParkedLoops(a *assert.Assertions, count float64) bool {
+	for i := 0; i < 200; i++ {
+		if parkedLoops(a) == count {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func newTestEventLoopConfig(brokerAddress string) *config.Config {
+	c := newTestProxyConfig(brokerAddress)
+	c.Proxy.ConnectionEngine = config.ConnectionEngineEventLoop
+	c.Proxy.EventLoopIdleTime = 50 * time.Millisecond
+	return c
+}
+
+func metadataRoundTrip(a *assert.Assertions, conn net.Conn, correlationID int32) {
+	_, err := conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, correlationID, "test", kafkatest.MetadataRequestBody(1, []string{"test"})))
+	a.Nil(err)
+	responseID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(correlationID, responseID)
+	topics, err := kafkatest.DecodeMetadataTopics(1, body)
+	a.Nil(err)
+	a.Equal([]string{"test"}, topics)
+}
+
+func TestProxyEventLoopParksIdleConnections(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	listenerAddress, stop := startTestProxy(a, newTestEventLoopConfig(broker.Addr()))
+	defer stop()
+
+	parked := parkedLoops(a)
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	metadataRoundTrip(a, conn, 1)
+
+	// the requests and responses loops are parked and resumed by the next request
+	a.True(waitForParkedLoops(a, parked+2))
+	metadataRoundTrip(a, conn, 2)
+	a.True(waitForParkedLoops(a, parked+2))
+	metadataRoundTrip(a, conn, 3)
+	a.Equal(3, broker.RequestCount(kafkatest.ApiKeyMetadata))
+
+	// the parked loops are resumed and finished when the client closes the connection
+	conn.Close()
+	a.True(waitForParkedLoops(a, parked))
+}
+
+func TestProxyEventLoopStopsParkedConnections(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	listenerAddress, stop := startTestProxy(a, newTestEventLoopConfig(broker.Addr()))
+
+	parked := parkedLoops(a)
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	metadataRoundTrip(a, conn, 1)
+	a.True(waitForParkedLoops(a, parked+2))
+
+	stop()
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.True(waitForParkedLoops(a, parked))
+}
+
+// BenchmarkIdleConnections opens a proxied connection per iteration, sends a Metadata request and keeps it idle. The goroutines
+// and the stack and heap bytes per idle connection are reported, they include the goroutine of the fake broker connection.
+// Every connection takes four file descriptors of the process.
+//
+//	go test -run NONE -bench IdleConnections -benchtime 4000x ./proxy
+func BenchmarkIdleConnections(b *testing.B) {
+	for _, engine := range []string{config.ConnectionEngineGoroutines, config.ConnectionEngineEventLoop} {
+		b.Run(engine, func(b *testing.B) {
+			benchmarkIdleConnections(b, engine)
+		})
+	}
+}
+
+func benchmarkIdleConnections(b *testing.B, engine string) {
+	a := assert.New(b)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestEventLoopConfig(broker.Addr())
+	c.Proxy.ConnectionEngine = engine
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+	parked := parkedLoops(a)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+
+	b.ResetTimer()
+	conns := make([]net.Conn, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		conn, err := net.Dial("tcp", listenerAddress)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns = append(conns, conn)
+		metadataRoundTrip(a, conn, int32(i))
+	}
+	b.StopTimer()
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	if engine == config.ConnectionEngineEventLoop {
+		for i := 0; i < 1000 && parkedLoops(a) < parked+float64(2*b.N); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/conn")
+	b.ReportMetric(float64(int64(after.StackInuse+after.HeapInuse)-int64(before.StackInuse+before.HeapInuse))/float64(b.N), "bytes/conn")
+}
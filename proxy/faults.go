@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FaultSettings describe the faults injected into the requests of the selected api keys
+type FaultSettings struct {
+	Enabled bool
+	// all api keys are affected when empty
+	ApiKeys []int16
+	// delay of the requests, a random jitter up to LatencyJitter is added
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// percentage of the requests which close the client connection
+	DropPercent float64
+	// percentage of the responses which bodies are corrupted
+	CorruptPercent float64
+}
+
+type faultSettingsJSON struct {
+	Enabled        bool    `json:"enabled"`
+	ApiKeys        []int16 `json:"api_keys"`
+	Latency        string  `json:"latency"`
+	LatencyJitter  string  `json:"latency_jitter"`
+	DropPercent    float64 `json:"drop_percent"`
+	CorruptPercent float64 `json:"corrupt_percent"`
+}
+
+func (s FaultSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(faultSettingsJSON{
+		Enabled:        s.Enabled,
+		ApiKeys:        s.ApiKeys,
+		Latency:        s.Latency.String(),
+		LatencyJitter:  s.LatencyJitter.String(),
+		DropPercent:    s.DropPercent,
+		CorruptPercent: s.CorruptPercent,
+	})
+}
+
+func (s *FaultSettings) UnmarshalJSON(data []byte) error {
+	var v faultSettingsJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	result := FaultSettings{Enabled: v.Enabled, ApiKeys: v.ApiKeys, DropPercent: v.DropPercent, CorruptPercent: v.CorruptPercent}
+	var err error
+	if v.Latency != "" {
+		if result.Latency, err = time.ParseDuration(v.Latency); err != nil {
+			return errors.Wrap(err, "invalid latency")
+		}
+	}
+	if v.LatencyJitter != "" {
+		if result.LatencyJitter, err = time.ParseDuration(v.LatencyJitter); err != nil {
+			return errors.Wrap(err, "invalid latency_jitter")
+		}
+	}
+	*s = result
+	return nil
+}
+
+func (s FaultSettings) validate() error {
+	if s.Latency < 0 || s.LatencyJitter < 0 {
+		return errors.New("latency must be greater or equal 0")
+	}
+	if s.DropPercent < 0 || s.DropPercent > 100 {
+		return errors.New("drop percent must be between 0 and 100")
+	}
+	if s.CorruptPercent < 0 || s.CorruptPercent > 100 {
+		return errors.New("corrupt percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func (s *FaultSettings) affects(apiKey int16) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.ApiKeys) == 0 {
+		return true
+	}
+	for _, v := range s.ApiKeys {
+		if v == apiKey {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultInjector adds latency, drops connections or corrupts responses to test the resiliency of the clients.
+// The settings can be changed at runtime e.g. with the HTTP admin API.
+type FaultInjector struct {
+	lock     sync.RWMutex
+	settings FaultSettings
+}
+
+// NewFaultInjector returns nil if the fault injection is neither enabled nor managed by the admin API
+func NewFaultInjector(c *config.Config) (*FaultInjector, error) {
+	if !c.Faults.Enable && !c.Faults.AdminEnable {
+		return nil, nil
+	}
+	settings := FaultSettings{
+		Enabled:        c.Faults.Enable,
+		Latency:        c.Faults.Latency,
+		LatencyJitter:  c.Faults.LatencyJitter,
+		DropPercent:    c.Faults.DropPercent,
+		CorruptPercent: c.Faults.CorruptPercent,
+	}
+	for _, v := range c.Faults.ApiKeys {
+		settings.ApiKeys = append(settings.ApiKeys, int16(v))
+	}
+	injector := &FaultInjector{}
+	if err := injector.SetSettings(settings); err != nil {
+		return nil, err
+	}
+	return injector, nil
+}
+
+// Settings returns the current settings
+func (f *FaultInjector) Settings() FaultSettings {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.settings
+}
+
+// SetSettings replaces the settings, new connections and requests of the open connections are affected
+func (f *FaultInjector) SetSettings(settings FaultSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.settings = settings
+	f.lock.Unlock()
+	if settings.Enabled {
+		logrus.Warnf("Fault injection is enabled: api keys %v, latency %v, latency jitter %v, drop %v%%, corrupt %v%%",
+			settings.ApiKeys, settings.Latency, settings.LatencyJitter, settings.DropPercent, settings.CorruptPercent)
+	} else {
+		logrus.Infof("Fault injection is disabled")
+	}
+	return nil
+}
+
+// ServeHTTP returns the settings on GET, replaces them with the JSON body on PUT and disables the faults on DELETE
+func (f *FaultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings FaultSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := f.SetSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		settings := f.Settings()
+		settings.Enabled = false
+		if err := f.SetSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.Settings())
+}
+
+// drops reports whether the client connection must be closed instead of forwarding the request
+func (f *FaultInjector) drops(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if f == nil {
+		return false
+	}
+	settings := f.Settings()
+	if !settings.affects(requestKeyVersion.ApiKey) || settings.DropPercent <= 0 || rand.Float64()*100 >= settings.DropPercent {
+		return false
+	}
+	proxyFaultsInjectedTotal.WithLabelValues("drop").Inc()
+	return true
+}
+
+// latency returns the delay of the request
+func (f *FaultInjector) latency(requestKeyVersion *protocol.RequestKeyVersion) time.Duration {
+	if f == nil {
+		return 0
+	}
+	settings := f.Settings()
+	if !settings.affects(requestKeyVersion.ApiKey) || (settings.Latency <= 0 && settings.LatencyJitter <= 0) {
+		return 0
+	}
+	delay := settings.Latency
+	if settings.LatencyJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(settings.LatencyJitter)))
+	}
+	proxyFaultsInjectedTotal.WithLabelValues("latency").Inc()
+	return delay
+}
+
+// responseModifier returns the modifier corrupting the response, nil if the response is not corrupted
+func (f *FaultInjector) responseModifier(requestKeyVersion *protocol.RequestKeyVersion) protocol.ResponseModifier {
+	if f == nil {
+		return nil
+	}
+	settings := f.Settings()
+	if !settings.affects(requestKeyVersion.ApiKey) || settings.CorruptPercent <= 0 || rand.Float64()*100 >= settings.CorruptPercent {
+		return nil
+	}
+	proxyFaultsInjectedTotal.WithLabelValues("corrupt").Inc()
+	return corruptModifier{}
+}
+
+// corruptModifier inverts a random byte of the response body
+type corruptModifier struct{}
+
+func (corruptModifier) Apply(resp []byte) ([]byte, error) {
+	if len(resp) == 0 {
+		return resp, nil
+	}
+	result := append([]byte{}, resp...)
+	i := rand.Intn(len(result))
+	result[i] = ^result[i]
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectorSelectsApiKeys(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := NewFaultInjector(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	a.False(disabled.drops(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata}))
+	a.Equal(time.Duration(0), disabled.latency(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata}))
+	a.Nil(disabled.responseModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata}))
+
+	c.Faults.Enable = true
+	c.Faults.ApiKeys = []int{int(kafkatest.ApiKeyProduce)}
+	c.Faults.Latency = 100 * time.Millisecond
+	c.Faults.LatencyJitter = 50 * time.Millisecond
+	c.Faults.DropPercent = 100
+	c.Faults.CorruptPercent = 100
+	injector, err := NewFaultInjector(c)
+	a.Nil(err)
+
+	produce := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce}
+	metadata := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata}
+	a.True(injector.drops(produce))
+	a.False(injector.drops(metadata))
+	delay := injector.latency(produce)
+	a.True(delay >= 100*time.Millisecond && delay < 150*time.Millisecond, delay)
+	a.Equal(time.Duration(0), injector.latency(metadata))
+	a.Nil(injector.responseModifier(metadata))
+
+	modifier := injector.responseModifier(produce)
+	a.NotNil(modifier)
+	resp := []byte{1, 2, 3, 4}
+	result, err := modifier.Apply(resp)
+	a.Nil(err)
+	a.NotEqual(resp, result)
+	a.Equal([]byte{1, 2, 3, 4}, resp)
+}
+
+func TestFaultInjectorAdminAPI(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Faults.AdminEnable = true
+	injector, err := NewFaultInjector(c)
+	a.Nil(err)
+	server := httptest.NewServer(injector)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"enabled":true,"api_keys":[1],"latency":"250ms","drop_percent":5}`))
+	a.Nil(err)
+	resp, err := http.DefaultClient.Do(req)
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(FaultSettings{Enabled: true, ApiKeys: []int16{1}, Latency: 250 * time.Millisecond, DropPercent: 5}, injector.Settings())
+
+	req, err = http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"enabled":true,"corrupt_percent":101}`))
+	a.Nil(err)
+	resp, err = http.DefaultClient.Do(req)
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete, server.URL, nil)
+	a.Nil(err)
+	resp, err = http.DefaultClient.Do(req)
+	a.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Nil(err)
+	a.JSONEq(`{"enabled":false,"api_keys":[1],"latency":"250ms","latency_jitter":"0s","drop_percent":5,"corrupt_percent":0}`, string(body))
+}
+
+func TestProxyDropsConnectionsByFaultInjection(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Faults.AdminEnable = true
+	injector, err := NewFaultInjector(c)
+	a.Nil(err)
+	listenerAddress, stop := startTestProxy(a, c, WithFaultInjector(injector))
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 1, "app-1", kafkatest.MetadataRequestBody(1, nil)))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+
+	a.Nil(injector.SetSettings(FaultSettings{Enabled: true, ApiKeys: []int16{kafkatest.ApiKeyMetadata}, DropPercent: 100}))
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 2, "app-1", kafkatest.MetadataRequestBody(1, nil)))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyMetadata))
+}
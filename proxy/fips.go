@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"crypto/tls"
+)
+
+var (
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+	fipsCurvePreferences = []tls.CurveID{
+		tls.CurveP256,
+		tls.CurveP384,
+	}
+)
+
+// restrictToFIPS removes the cipher suites and curves which are not FIPS approved and requires at least TLS 1.2
+func restrictToFIPS(cfg *tls.Config) {
+	cipherSuites := make([]uint16, 0)
+	for _, v := range cfg.CipherSuites {
+		if containsCipherSuite(fipsCipherSuites, v) {
+			cipherSuites = append(cipherSuites, v)
+		}
+	}
+	if len(cipherSuites) == 0 {
+		cipherSuites = fipsCipherSuites
+	}
+	cfg.CipherSuites = cipherSuites
+
+	curvePreferences := make([]tls.CurveID, 0)
+	for _, v := range cfg.CurvePreferences {
+		if containsCurve(fipsCurvePreferences, v) {
+			curvePreferences = append(curvePreferences, v)
+		}
+	}
+	if len(curvePreferences) == 0 {
+		curvePreferences = fipsCurvePreferences
+	}
+	cfg.CurvePreferences = curvePreferences
+
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+}
+
+func containsCipherSuite(values []uint16, value uint16) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCurve(values []tls.CurveID, value tls.CurveID) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package proxy
+
+import (
+	// all TLS configurations are restricted to the FIPS approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+// BoringCrypto reports whether the binary is built with the FIPS validated BoringCrypto module
+const BoringCrypto = true
@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+// +build !boringcrypto
+
+package proxy
+
+// BoringCrypto reports whether the binary is built with the FIPS validated BoringCrypto module
+const BoringCrypto = false
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	dialTargetForwardProxy = "forward_proxy"
+	dialTargetBroker       = "broker"
+)
+
+// dialErrorTarget returns whether the forward proxy or the broker could not be reached
+func dialErrorTarget(err error) string {
+	if isForwardProxyDialError(err) {
+		return dialTargetForwardProxy
+	}
+	return dialTargetBroker
+}
+
+// forwardProxyProbe periodically connects to the forward proxy and exports its reachability and latency
+type forwardProxyProbe struct {
+	address  string
+	interval time.Duration
+	dialer   Dialer
+
+	// reachability of the previous probe, logged on change only
+	up *bool
+}
+
+// newForwardProxyProbe returns nil if no forward proxy is configured or the probe is disabled
+func newForwardProxyProbe(c *config.Config) (*forwardProxyProbe, error) {
+	if c.ForwardProxy.Url == "" || c.ForwardProxy.ProbeInterval == 0 {
+		return nil, nil
+	}
+	resolver, err := newResolverIfEnabled(c)
+	if err != nil {
+		return nil, err
+	}
+	return &forwardProxyProbe{
+		address:  c.ForwardProxy.Address,
+		interval: c.ForwardProxy.ProbeInterval,
+		dialer: directDialer{
+			dialTimeout: c.Kafka.DialTimeout,
+			resolver:    resolver,
+		},
+	}, nil
+}
+
+// probe connects to the forward proxy and closes the connection
+func (p *forwardProxyProbe) probe(ctx context.Context) error {
+	start := time.Now()
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		proxyForwardProxyUp.Set(0)
+		p.setUp(false, err)
+		return err
+	}
+	conn.Close()
+	proxyForwardProxyUp.Set(1)
+	proxyForwardProxyProbeSeconds.Set(time.Since(start).Seconds())
+	p.setUp(true, nil)
+	return nil
+}
+
+func (p *forwardProxyProbe) setUp(up bool, err error) {
+	if p.up != nil && *p.up == up {
+		return
+	}
+	if up {
+		logrus.Infof("Forward proxy %s is reachable", p.address)
+	} else {
+		logrus.Warnf("Forward proxy %s is unreachable: %v", p.address, err)
+	}
+	p.up = &up
+}
+
+// run probes the forward proxy in the interval until done is closed
+func (p *forwardProxyProbe) run(done <-chan struct{}) {
+	if p == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		_ = p.probe(ctx)
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
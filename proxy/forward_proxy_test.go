@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func unusedAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestForwardProxyDialErrors(t *testing.T) {
+	a := assert.New(t)
+
+	proxyAddr := unusedAddress(t)
+	direct := directDialer{dialTimeout: 2 * time.Second}
+
+	socks := socks5Dialer{directDialer: direct, proxyNetwork: "tcp", proxyAddr: proxyAddr}
+	_, err := socks.Dial("tcp", "127.0.0.1:9092")
+	a.NotNil(err)
+	a.True(isForwardProxyDialError(err))
+	a.Equal(dialTargetForwardProxy, dialErrorTarget(err))
+	a.True(strings.HasPrefix(err.Error(), "forward proxy "+proxyAddr+" dial failed: "))
+
+	httpDialer := &httpProxy{forwardDialer: forwardProxyDialer{dialer: direct}, network: "tcp", hostPort: proxyAddr}
+	_, err = httpDialer.Dial("tcp", "127.0.0.1:9092")
+	a.NotNil(err)
+	a.Equal(dialTargetForwardProxy, dialErrorTarget(err))
+
+	// the errors of the direct connections are broker errors
+	_, err = direct.Dial("tcp", proxyAddr)
+	a.NotNil(err)
+	a.Equal(dialTargetBroker, dialErrorTarget(err))
+}
+
+func TestForwardProxyProbe(t *testing.T) {
+	a := assert.New(t)
+
+	c := new(config.Config)
+	probe, err := newForwardProxyProbe(c)
+	a.Nil(err)
+	a.Nil(probe)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+
+	c.ForwardProxy.Url = "socks5://" + ln.Addr().String()
+	c.ForwardProxy.Address = ln.Addr().String()
+	c.ForwardProxy.ProbeInterval = time.Second
+	c.Kafka.DialTimeout = 2 * time.Second
+	probe, err = newForwardProxyProbe(c)
+	a.Nil(err)
+	a.NotNil(probe)
+
+	a.Nil(probe.probe(context.Background()))
+	a.True(*probe.up)
+
+	probe.address = unusedAddress(t)
+	a.NotNil(probe.probe(context.Background()))
+	a.False(*probe.up)
+}
@@ -0,0 +1,97 @@
+//go:build go1.18
+// +build go1.18
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"testing"
+	"time"
+)
+
+// fuzzConn reads the fuzzed client stream and discards the responses
+type fuzzConn struct {
+	*bytes.Reader
+}
+
+func (c *fuzzConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *fuzzConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fuzzConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fuzzConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// sizedFrame returns the frame with the int32 size
+func sizedFrame(frame []byte) []byte {
+	buf := make([]byte, 4, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	return append(buf, frame...)
+}
+
+func fuzzRequestFrame(apiKey, apiVersion int16, body []byte) []byte {
+	frame := []byte{byte(apiKey >> 8), byte(apiKey), byte(apiVersion >> 8), byte(apiVersion), 0x00, 0x00, 0x00, 0x01, 0x00, 0x04, 'f', 'u', 'z', 'z'}
+	return sizedFrame(append(frame, body...))
+}
+
+// FuzzReadRequestHeader reads the correlation id and the client id of the request stream
+func FuzzReadRequestHeader(f *testing.F) {
+	f.Add(fuzzRequestFrame(3, 1, []byte{0x00, 0x00, 0x00, 0x00}))
+	f.Add(fuzzRequestFrame(7, 0, nil))
+	f.Add(sizedFrame([]byte{0x00, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00}))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		if len(stream) < 8 {
+			return
+		}
+		requestKeyVersion := &protocol.RequestKeyVersion{}
+		if err := protocol.Decode(stream[:8], requestKeyVersion); err != nil {
+			return
+		}
+		ctx := &RequestsLoopContext{headerBuf: make([]byte, 6, 64)}
+		ctx.readRequestHeader(bytes.NewReader(stream[8:]), requestKeyVersion)
+	})
+}
+
+// FuzzLocalSasl runs the SASL authentication of the proxy with the client stream of SaslHandshake and SaslAuthenticate requests
+// or of the raw authentication bytes
+func FuzzLocalSasl(f *testing.F) {
+	handshake := func(version int16, mechanism string) []byte {
+		return fuzzRequestFrame(17, version, append([]byte{0x00, byte(len(mechanism))}, mechanism...))
+	}
+	authenticate := func(authBytes string) []byte {
+		body := make([]byte, 4, 4+len(authBytes))
+		binary.BigEndian.PutUint32(body, uint32(len(authBytes)))
+		return fuzzRequestFrame(36, 0, append(body, authBytes...))
+	}
+	f.Add(append(handshake(1, SASLPlain), authenticate("\x00alice\x00secret")...))
+	f.Add(append(handshake(1, config.SASLScramSHA256), authenticate("n,,n=alice,r=client-nonce")...))
+	f.Add(append(handshake(0, SASLPlain), sizedFrame([]byte("\x00alice\x00secret"))...))
+	f.Add(append(handshake(0, SASLPlain), 0xff, 0xff, 0xff, 0xff))
+
+	scram, err := NewLocalSaslScram(config.SASLScramSHA256, testScramCredentialStore{
+		config.SASLScramSHA256 + " alice": {Found: true, Salt: []byte("salt"), Iterations: 4096, StoredKey: make([]byte, 32), ServerKey: make([]byte, 32)},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	localSasl := NewLocalSasl(LocalSaslParams{
+		enabled:               true,
+		timeout:               time.Second,
+		passwordAuthenticator: testPasswordAuthenticator{"alice": "secret"},
+		scramAuthenticators:   []*LocalSaslScram{scram},
+	})
+	// the requests of the fuzzed lengths are not allocated up to the default limit of 100 MB
+	defaultMaxRequestSize := protocol.MaxRequestSize
+	protocol.MaxRequestSize = 64 * 1024
+	defer func() { protocol.MaxRequestSize = defaultMaxRequestSize }()
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		if len(stream) < 8 {
+			return
+		}
+		keyVersionBuf, rest := stream[:8], stream[8:]
+		localSasl.receiveAndSendSASLAuthV1(&fuzzConn{bytes.NewReader(rest)}, keyVersionBuf)
+		localSasl.receiveAndSendSASLAuthV0(&fuzzConn{bytes.NewReader(rest)}, keyVersionBuf)
+	})
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/googleid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/jws"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcClockSkew = 1 * time.Minute
+	// signing keys are not fetched more often when tokens with unknown key ids are presented
+	oidcMinRefreshInterval = 1 * time.Minute
+)
+
+// HTTPAuth requires a static or an OIDC bearer token in the requests of the HTTP endpoints
+type HTTPAuth struct {
+	tokens [][]byte
+	oidc   *oidcVerifier
+}
+
+// NewHTTPAuth returns nil if neither static tokens nor an OIDC issuer are configured
+func NewHTTPAuth(c *config.Config) (*HTTPAuth, error) {
+	auth := &HTTPAuth{}
+	for _, token := range c.Http.Auth.BearerTokens {
+		auth.tokens = append(auth.tokens, []byte(token))
+	}
+	if c.Http.Auth.BearerTokenFile != "" {
+		tokens, err := loadBearerTokens(c.Http.Auth.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.tokens = append(auth.tokens, tokens...)
+	}
+	if c.Http.Auth.OIDCIssuerURL != "" {
+		auth.oidc = newOIDCVerifier(c.Http.Auth.OIDCIssuerURL, c.Http.Auth.OIDCAudience, c.Http.Auth.OIDCRefreshInterval)
+		if err := auth.oidc.refresh(); err != nil {
+			return nil, errors.Wrapf(err, "cannot fetch signing keys of OIDC issuer %s", c.Http.Auth.OIDCIssuerURL)
+		}
+	}
+	if len(auth.tokens) == 0 && auth.oidc == nil {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+func loadBearerTokens(filename string) ([][]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, []byte(line))
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.Errorf("bearer token file %s is empty", filename)
+	}
+	return tokens, nil
+}
+
+// Handler returns the handler which rejects the requests without a valid bearer token, next is returned if the auth is nil
+func (a *HTTPAuth) Handler(next http.Handler) http.Handler {
+	if a == nil || next == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+			proxyHTTPAuthRejectedTotal.WithLabelValues("missing").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		if err := a.authenticate(strings.TrimSpace(header[7:])); err != nil {
+			proxyHTTPAuthRejectedTotal.WithLabelValues("invalid").Inc()
+			logrus.Debugf("HTTP request %s %s from %s rejected: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "bearer token is invalid", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *HTTPAuth) authenticate(token string) error {
+	for _, expected := range a.tokens {
+		if subtle.ConstantTimeCompare(expected, []byte(token)) == 1 {
+			return nil
+		}
+	}
+	if a.oidc != nil {
+		return a.oidc.verify(token)
+	}
+	return errors.New("unknown static token")
+}
+
+type oidcVerifier struct {
+	issuer          string
+	audience        map[string]struct{}
+	refreshInterval time.Duration
+	client          *http.Client
+	now             func() time.Time
+
+	lock      sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	refreshed time.Time
+}
+
+func newOIDCVerifier(issuer string, audience []string, refreshInterval time.Duration) *oidcVerifier {
+	v := &oidcVerifier{
+		issuer:          strings.TrimSuffix(issuer, "/"),
+		audience:        make(map[string]struct{}),
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		now:             time.Now,
+	}
+	for _, aud := range audience {
+		v.audience[aud] = struct{}{}
+	}
+	return v
+}
+
+type oidcHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type oidcClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+// verify checks the signature, the issuer, the audience and the validity period of the JWT token
+func (v *oidcVerifier) verify(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token is not a JWT")
+	}
+	var header oidcHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return errors.Wrap(err, "invalid JWT header")
+	}
+	if header.Algorithm != "RS256" {
+		return errors.Errorf("JWT algorithm %s is not supported", header.Algorithm)
+	}
+	var claims oidcClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return errors.Wrap(err, "invalid JWT claims")
+	}
+	key, err := v.key(header.KeyID)
+	if err != nil {
+		return err
+	}
+	if err = jws.Verify(token, key); err != nil {
+		return errors.Wrap(err, "invalid JWT signature")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != v.issuer {
+		return errors.Errorf("JWT issuer %s is not accepted", claims.Issuer)
+	}
+	now := v.now()
+	if claims.Expires == 0 || now.Add(-oidcClockSkew).Unix() > claims.Expires {
+		return errors.New("JWT is expired")
+	}
+	if claims.NotBefore != 0 && now.Add(oidcClockSkew).Unix() < claims.NotBefore {
+		return errors.New("JWT is not valid yet")
+	}
+	if len(v.audience) != 0 {
+		var audience []string
+		if err = json.Unmarshal(claims.Audience, &audience); err != nil {
+			var single string
+			if err = json.Unmarshal(claims.Audience, &single); err != nil {
+				return errors.New("JWT audience is missing")
+			}
+			audience = []string{single}
+		}
+		for _, aud := range audience {
+			if _, ok := v.audience[aud]; ok {
+				return nil
+			}
+		}
+		return errors.Errorf("JWT audience %v is not accepted", audience)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// key returns the signing key, the keys are refreshed after the refresh interval or when the key id is unknown
+func (v *oidcVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.lock.Lock()
+	key, ok := v.keys[kid]
+	stale := v.now().Sub(v.refreshed) > v.refreshInterval
+	retry := !ok && v.now().Sub(v.refreshed) > oidcMinRefreshInterval
+	v.lock.Unlock()
+
+	if stale || retry {
+		if err := v.refresh(); err != nil {
+			logrus.Warnf("Refresh of the OIDC signing keys failed: %v", err)
+		}
+		v.lock.Lock()
+		key, ok = v.keys[kid]
+		v.lock.Unlock()
+	}
+	if !ok {
+		return nil, errors.Errorf("JWT signing key %s is unknown", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the signing keys from the jwks_uri of the openid-configuration of the issuer
+func (v *oidcVerifier) refresh() error {
+	var discovery struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+	if discovery.JwksURI == "" {
+		return errors.New("jwks_uri is missing in the openid-configuration")
+	}
+	var certs googleid.Certs
+	if err := v.getJSON(discovery.JwksURI, &certs); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range certs.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.GetPublicKey()
+		if err != nil {
+			return fmt.Errorf("cannot parse public key: %v", jwk.Kid)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("RSA signing keys must not be empty")
+	}
+	v.lock.Lock()
+	v.keys = keys
+	v.refreshed = v.now()
+	v.lock.Unlock()
+	return nil
+}
+
+func (v *oidcVerifier) getJSON(url string, result interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if c := resp.StatusCode; c < 200 || c > 299 {
+		return fmt.Errorf("cannot fetch %s: %v", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2/jws"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestOIDCIssuer(key *rsa.PrivateKey) *httptest.Server {
+	m := http.NewServeMux()
+	server := httptest.NewServer(m)
+	m.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	m.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	return server
+}
+
+func authorizedStatus(handler http.Handler, header string) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if header != "" {
+		r.Header.Set("Authorization", header)
+	}
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestHTTPAuthDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	auth, err := NewHTTPAuth(&config.Config{})
+	a.Nil(err)
+	a.Nil(auth)
+	// requests are passed through
+	a.Equal(http.StatusNotFound, authorizedStatus(auth.Handler(http.NotFoundHandler()), ""))
+}
+
+func TestHTTPAuthStaticTokens(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "tokens")
+	a.Nil(err)
+	defer os.Remove(file.Name())
+	file.WriteString("# admin tokens\nfile-token\n\n")
+	file.Close()
+
+	c := &config.Config{}
+	c.Http.Auth.BearerTokens = []string{"flag-token"}
+	c.Http.Auth.BearerTokenFile = file.Name()
+	auth, err := NewHTTPAuth(c)
+	a.Nil(err)
+
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	a.Equal(http.StatusOK, authorizedStatus(handler, "Bearer flag-token"))
+	a.Equal(http.StatusOK, authorizedStatus(handler, "bearer file-token"))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, "Bearer # admin tokens"))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, "Basic ZmxhZy10b2tlbg=="))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, ""))
+
+	c.Http.Auth.BearerTokenFile = file.Name() + ".missing"
+	_, err = NewHTTPAuth(c)
+	a.NotNil(err)
+}
+
+func TestHTTPAuthOIDC(t *testing.T) {
+	a := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	issuer := newTestOIDCIssuer(key)
+	defer issuer.Close()
+
+	c := config.NewConfig()
+	c.Http.Auth.OIDCIssuerURL = issuer.URL
+	c.Http.Auth.OIDCAudience = []string{"kafka-proxy"}
+	auth, err := NewHTTPAuth(c)
+	a.Nil(err)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	sign := func(kid string, claims *jws.ClaimSet, signingKey *rsa.PrivateKey) string {
+		token, err := jws.Encode(&jws.Header{Algorithm: "RS256", Typ: "JWT", KeyID: kid}, claims, signingKey)
+		a.Nil(err)
+		return "Bearer " + token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	a.Equal(http.StatusOK, authorizedStatus(handler, sign("key-1", &jws.ClaimSet{Iss: issuer.URL, Aud: "kafka-proxy", Exp: exp}, key)))
+	// wrong audience, issuer, expiry and key
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, sign("key-1", &jws.ClaimSet{Iss: issuer.URL, Aud: "other", Exp: exp}, key)))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, sign("key-1", &jws.ClaimSet{Iss: "https://other", Aud: "kafka-proxy", Exp: exp}, key)))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, sign("key-1", &jws.ClaimSet{Iss: issuer.URL, Aud: "kafka-proxy", Iat: time.Now().Add(-2 * time.Hour).Unix(), Exp: time.Now().Add(-time.Hour).Unix()}, key)))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, sign("key-2", &jws.ClaimSet{Iss: issuer.URL, Aud: "kafka-proxy", Exp: exp}, key)))
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, sign("key-1", &jws.ClaimSet{Iss: issuer.URL, Aud: "kafka-proxy", Exp: exp}, otherKey)))
+	a.Equal(http.StatusUnauthorized, authorizedStatus(handler, "Bearer not-a-jwt"))
+}
+
+func TestHTTPAuthOIDCIssuerUnavailable(t *testing.T) {
+	a := assert.New(t)
+
+	issuer := httptest.NewServer(http.NotFoundHandler())
+	defer issuer.Close()
+
+	c := config.NewConfig()
+	c.Http.Auth.OIDCIssuerURL = issuer.URL
+	_, err := NewHTTPAuth(c)
+	a.NotNil(err)
+}
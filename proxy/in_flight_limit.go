@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+)
+
+var errInFlightLimitClosed = errors.New("responses of the connection are not processed anymore")
+
+// inFlightLimit bounds the requests of a client connection sent to the broker and not answered yet, as max.in.flight.requests.per.connection
+// of the clients. The next request is not read from the client until a response is written, so a deep pipeline of the client is held back
+// by the TCP flow control instead of being buffered by the proxy and the broker.
+type inFlightLimit struct {
+	brokerAddress string
+	slots         chan struct{}
+	// closed when the responses loop ends, no slot is released afterwards
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newInFlightLimit returns nil if the in-flight requests are not limited
+func newInFlightLimit(maxInFlightRequests int, brokerAddress string) *inFlightLimit {
+	if maxInFlightRequests <= 0 {
+		return nil
+	}
+	return &inFlightLimit{
+		brokerAddress: brokerAddress,
+		slots:         make(chan struct{}, maxInFlightRequests),
+		closed:        make(chan struct{}),
+	}
+}
+
+// acquire waits until the number of the in-flight requests is below the limit
+func (l *inFlightLimit) acquire(done <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	proxyInFlightLimitedTotal.WithLabelValues(l.brokerAddress).Inc()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-l.closed:
+		return errInFlightLimitClosed
+	case <-done:
+		return errProcessorStopped
+	}
+}
+
+// release frees the slot of the request which response was written to the client
+func (l *inFlightLimit) release() {
+	if l == nil {
+		return
+	}
+	select {
+	case <-l.slots:
+	default:
+	}
+}
+
+// close wakes up the request waiting for a slot when the responses loop ends
+func (l *inFlightLimit) close() {
+	if l == nil {
+		return
+	}
+	l.closeOnce.Do(func() { close(l.closed) })
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimit(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(newInFlightLimit(0, "broker:9092"))
+	var disabled *inFlightLimit
+	a.Nil(disabled.acquire(nil))
+	disabled.release()
+	disabled.close()
+
+	done := make(chan struct{})
+	limit := newInFlightLimit(2, "broker:9092")
+	a.Nil(limit.acquire(done))
+	a.Nil(limit.acquire(done))
+
+	before := counterOf(a, proxyInFlightLimitedTotal, "broker:9092")
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- limit.acquire(done)
+	}()
+	select {
+	case <-acquired:
+		a.Fail("request acquired above the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// response of the first request was written
+	limit.release()
+	a.Nil(<-acquired)
+	a.Equal(before+1, counterOf(a, proxyInFlightLimitedTotal, "broker:9092"))
+
+	go func() {
+		acquired <- limit.acquire(done)
+	}()
+	limit.close()
+	a.Equal(errInFlightLimitClosed, <-acquired)
+	limit.close()
+
+	limit = newInFlightLimit(1, "broker:9092")
+	a.Nil(limit.acquire(done))
+	close(done)
+	a.Equal(errProcessorStopped, limit.acquire(done))
+}
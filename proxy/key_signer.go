@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+const defaultKeySignerTimeout = 5 * time.Second
+
+// keySigner is the crypto.Signer of the private key kept in a PKCS#11 token, HSM or cloud KMS, the digests of the TLS handshakes
+// are signed by the key signer plugin so the private key never exists on disk or in the memory of the proxy
+type keySigner struct {
+	signer    apis.KeySigner
+	publicKey crypto.PublicKey
+	// DER encoded PKIX public key compared with the certificates
+	publicKeyDER []byte
+	timeout      time.Duration
+}
+
+// newKeySigner returns nil if there is no signer, the public key is fetched from the plugin once
+func newKeySigner(c *config.Config, signer apis.KeySigner) (*keySigner, error) {
+	if signer == nil {
+		if c.KeySigner.Enable {
+			return nil, errors.New("KeySigner is enabled but no key signer is set")
+		}
+		return nil, nil
+	}
+	timeout := c.KeySigner.Timeout
+	if timeout <= 0 {
+		timeout = defaultKeySignerTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := signer.PublicKey(ctx, apis.KeyPublicKeyRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get public key of the key signer")
+	}
+	if !response.Success {
+		return nil, errors.Errorf("key signer returned no public key, status %d", response.Status)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(response.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key of the key signer")
+	}
+	return &keySigner{signer: signer, publicKey: publicKey, publicKeyDER: response.PublicKey, timeout: timeout}, nil
+}
+
+func (s *keySigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *keySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	request := apis.KeySignRequest{Digest: digest, Hash: uint32(opts.HashFunc())}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		request.PSS = true
+		request.PSSSaltLength = int32(pss.SaltLength)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	response, err := s.signer.Sign(ctx, request)
+	if err != nil {
+		proxyKeySignaturesTotal.WithLabelValues("error").Inc()
+		return nil, errors.Wrap(err, "key signer failed")
+	}
+	if !response.Success {
+		proxyKeySignaturesTotal.WithLabelValues("rejected").Inc()
+		logrus.Warnf("The key signer rejected the signature with status %d", response.Status)
+		return nil, errors.Errorf("key signer rejected the signature with status %d", response.Status)
+	}
+	proxyKeySignaturesTotal.WithLabelValues("signed").Inc()
+	return response.Signature, nil
+}
+
+// apply sets the signer as the private key of the certificates without key, the certificates must match the public key of the signer
+func (s *keySigner) apply(tlsConfig *tls.Config) error {
+	if tlsConfig == nil {
+		return nil
+	}
+	for i := range tlsConfig.Certificates {
+		cert := &tlsConfig.Certificates[i]
+		if cert.PrivateKey != nil || cert.Leaf == nil {
+			continue
+		}
+		if s == nil {
+			return errors.Errorf("certificate %s has no private key", cert.Leaf.Subject)
+		}
+		if !bytes.Equal(cert.Leaf.RawSubjectPublicKeyInfo, s.publicKeyDER) {
+			return errors.Errorf("certificate %s does not match the public key of the key signer", cert.Leaf.Subject)
+		}
+		cert.PrivateKey = s
+	}
+	return nil
+}
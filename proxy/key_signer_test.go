@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testKeySigner signs with the key as the HSM or KMS would
+type testKeySigner struct {
+	key      crypto.Signer
+	requests []apis.KeySignRequest
+	success  bool
+}
+
+func (s *testKeySigner) PublicKey(ctx context.Context, request apis.KeyPublicKeyRequest) (apis.KeyPublicKeyResponse, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	return apis.KeyPublicKeyResponse{Success: true, PublicKey: der}, err
+}
+
+func (s *testKeySigner) Sign(ctx context.Context, request apis.KeySignRequest) (apis.KeySignResponse, error) {
+	s.requests = append(s.requests, request)
+	if !s.success {
+		return apis.KeySignResponse{Success: false, Status: 3}, nil
+	}
+	var opts crypto.SignerOpts = crypto.Hash(request.Hash)
+	if request.PSS {
+		opts = &rsa.PSSOptions{Hash: crypto.Hash(request.Hash), SaltLength: int(request.PSSSaltLength)}
+	}
+	signature, err := s.key.Sign(rand.Reader, request.Digest, opts)
+	return apis.KeySignResponse{Success: true, Signature: signature}, err
+}
+
+func TestKeySignerListener(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	serverCert, err := tls.LoadX509KeyPair(bundle.ServerCert.Name(), bundle.ServerKey.Name())
+	a.Nil(err)
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.KeySigner.Enable = true
+	opts := c.ListenerTLSOf("")
+	a.True(opts.SignsWithKeySigner())
+	serverConfig, err := newListenerTLSConfig(opts)
+	a.Nil(err)
+	a.Nil(serverConfig.Certificates[0].PrivateKey)
+
+	signer := &testKeySigner{key: serverCert.PrivateKey.(crypto.Signer)}
+	keySigner, err := newKeySigner(c, signer)
+	a.Nil(err)
+	a.Nil(keySigner.apply(serverConfig))
+	a.Equal(keySigner, serverConfig.Certificates[0].PrivateKey)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	a.Nil(err)
+	defer ln.Close()
+	go acceptHandshakes(ln)
+
+	// the signature is rejected by the signer
+	_, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	a.NotNil(err)
+	a.Len(signer.requests, 1)
+
+	signer.success = true
+	for _, version := range []uint16{tls.VersionTLS12, 0x0304} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: version})
+		a.Nil(err)
+		a.Equal(serverCert.Certificate[0], conn.ConnectionState().PeerCertificates[0].Raw)
+		conn.Close()
+	}
+	a.Len(signer.requests, 3)
+	// RSA keys sign with PSS in TLS 1.3
+	a.True(signer.requests[2].PSS)
+}
+
+func TestKeySignerRequiresMatchingCertificate(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	clientCert, err := tls.LoadX509KeyPair(bundle.ClientCert.Name(), bundle.ClientKey.Name())
+	a.Nil(err)
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.KeySigner.Enable = true
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+
+	_, err = newKeySigner(c, nil)
+	a.EqualError(err, "KeySigner is enabled but no key signer is set")
+	keySigner, err := newKeySigner(c, &testKeySigner{key: clientCert.PrivateKey.(crypto.Signer)})
+	a.Nil(err)
+	err = keySigner.apply(serverConfig)
+	a.NotNil(err)
+	a.Contains(err.Error(), "does not match the public key of the key signer")
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// podMetadata identifies the Kubernetes pod of a client
+type podMetadata struct {
+	name      string
+	namespace string
+	// value of the app label, empty if the pod has no such label
+	app string
+}
+
+// String returns namespace/name of the pod
+func (p *podMetadata) String() string {
+	return p.namespace + "/" + p.name
+}
+
+type podCacheEntry struct {
+	// nil if the IP is not the IP of a pod
+	pod     *podMetadata
+	expires time.Time
+}
+
+// podResolver looks up the pods of the client IPs with the Kubernetes API server and caches the results including the misses
+type podResolver struct {
+	apiServerURL string
+	tokenFile    string
+	appLabel     string
+	cacheTTL     time.Duration
+	httpClient   *http.Client
+
+	lock  sync.Mutex
+	cache map[string]podCacheEntry
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+// newPodResolver returns nil if the pod metadata is not resolved
+func newPodResolver(c *config.Config) (*podResolver, error) {
+	opts := c.Kubernetes
+	if !opts.PodMetadataEnable {
+		return nil, nil
+	}
+	apiServerURL := opts.APIServerURL
+	if apiServerURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("Kubernetes.APIServerURL is empty and the proxy does not run in a Kubernetes pod")
+		}
+		apiServerURL = "https://" + net.JoinHostPort(host, port)
+	}
+	tlsConfig := &tls.Config{}
+	if opts.CAChainCertFile != "" {
+		caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if ok := rootCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+			return nil, errors.New("Failed to parse Kubernetes API server root certificate")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if c.FIPS.Enable {
+		restrictToFIPS(tlsConfig)
+	}
+	logrus.Infof("Client IPs are resolved to the pods with the Kubernetes API server %s", apiServerURL)
+	return &podResolver{
+		apiServerURL: strings.TrimSuffix(apiServerURL, "/"),
+		tokenFile:    opts.TokenFile,
+		appLabel:     opts.AppLabel,
+		cacheTTL:     opts.CacheTTL,
+		httpClient:   &http.Client{Timeout: opts.Timeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		cache:        make(map[string]podCacheEntry),
+	}, nil
+}
+
+// lookup returns the pod of the client address, nil if the address is not the IP of a pod or the pod cannot be looked up
+func (r *podResolver) lookup(clientAddress net.Addr) *podMetadata {
+	if r == nil || clientAddress == nil {
+		return nil
+	}
+	ip := clientAddress.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	now := time.Now()
+	r.lock.Lock()
+	entry, ok := r.cache[ip]
+	r.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.pod
+	}
+	pod, err := r.query(ip)
+	if err != nil {
+		// cached as well, so the API server is not queried for every connection
+		logrus.Warnf("Pod of client %s cannot be looked up: %v", ip, err)
+	}
+	r.lock.Lock()
+	r.cache[ip] = podCacheEntry{pod: pod, expires: now.Add(r.cacheTTL)}
+	for cachedIP, cached := range r.cache {
+		if now.After(cached.expires) {
+			delete(r.cache, cachedIP)
+		}
+	}
+	r.lock.Unlock()
+	return pod
+}
+
+// query lists the pods with the IP, pods of the host network sharing the IP of the node are not resolved
+func (r *podResolver) query(ip string) (*podMetadata, error) {
+	req, err := http.NewRequest(http.MethodGet, r.apiServerURL+"/api/v1/pods?fieldSelector="+url.QueryEscape("status.podIP="+ip), nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.tokenFile != "" {
+		// the projected service account token is rotated by the kubelet
+		token, err := ioutil.ReadFile(r.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("Kubernetes API server returned status %d", resp.StatusCode)
+	}
+	var pods podList
+	if err = json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, errors.Wrap(err, "invalid Kubernetes API server response")
+	}
+	if len(pods.Items) != 1 {
+		return nil, nil
+	}
+	metadata := pods.Items[0].Metadata
+	return &podMetadata{name: metadata.Name, namespace: metadata.Namespace, app: metadata.Labels[r.appLabel]}, nil
+}
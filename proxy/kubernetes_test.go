@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPodResolver(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kubernetes")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	a.Nil(ioutil.WriteFile(tokenFile, []byte("pod-reader-token\n"), 0600))
+
+	var queries int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		if r.URL.Path != "/api/v1/pods" || r.Header.Get("Authorization") != "Bearer pod-reader-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Query().Get("fieldSelector") {
+		case "status.podIP=10.1.0.5":
+			fmt.Fprint(w, `{"items":[{"metadata":{"name":"orders-7d9f","namespace":"shop","labels":{"app.kubernetes.io/name":"orders"}}}]}`)
+		case "status.podIP=10.0.0.1":
+			// host network pods
+			fmt.Fprint(w, `{"items":[{"metadata":{"name":"node-exporter-1","namespace":"monitoring"}},{"metadata":{"name":"kube-proxy-1","namespace":"kube-system"}}]}`)
+		default:
+			fmt.Fprint(w, `{"items":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	c := config.NewConfig()
+	resolver, err := newPodResolver(c)
+	a.Nil(err)
+	a.Nil(resolver)
+
+	c.Kubernetes.PodMetadataEnable = true
+	c.Kubernetes.APIServerURL = server.URL
+	c.Kubernetes.TokenFile = tokenFile
+	c.Kubernetes.CAChainCertFile = ""
+	resolver, err = newPodResolver(c)
+	a.Nil(err)
+
+	pod := resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.5"), Port: 50000})
+	a.Equal(&podMetadata{name: "orders-7d9f", namespace: "shop", app: "orders"}, pod)
+	a.Equal("shop/orders-7d9f", pod.String())
+	// cached
+	a.Equal(pod, resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.5"), Port: 50001}))
+	a.Equal(int32(1), atomic.LoadInt32(&queries))
+
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}))
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.6"), Port: 50000}))
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.6"), Port: 50001}))
+	a.Equal(int32(3), atomic.LoadInt32(&queries))
+
+	// failures are cached as well
+	resolver.tokenFile = filepath.Join(dir, "missing")
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.7"), Port: 50000}))
+	resolver.tokenFile = ""
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.8"), Port: 50000}))
+	a.Nil(resolver.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.8"), Port: 50000}))
+	a.Equal(int32(4), atomic.LoadInt32(&queries))
+
+	// nil safe
+	var disabled *podResolver
+	a.Nil(disabled.lookup(&net.TCPAddr{IP: net.ParseIP("10.1.0.5"), Port: 50000}))
+}
+
+func TestPodResolverOutsideKubernetes(t *testing.T) {
+	a := assert.New(t)
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		t.Skip("running in a Kubernetes pod")
+	}
+	c := config.NewConfig()
+	c.Kubernetes.PodMetadataEnable = true
+	_, err := newPodResolver(c)
+	a.EqualError(err, "Kubernetes.APIServerURL is empty and the proxy does not run in a Kubernetes pod")
+}
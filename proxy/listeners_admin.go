@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ListenerMapping is the broker mapping added with the listeners admin API
+type ListenerMapping struct {
+	// bootstrap, external or dynamic
+	Kind string `json:"kind"`
+	// remotehost:remoteport,localhost:localport(,advhost:advport) or the broker address of a dynamic mapping
+	Mapping string `json:"mapping"`
+}
+
+// ListenersAdmin adds and removes the broker mappings and their listeners at runtime without a restart.
+// The bootstrap and external server mappings are saved to the state file if it is configured.
+type ListenersAdmin struct {
+	listeners *Listeners
+	stateFile string
+	// serializes the changes with the saves of the state file
+	lock sync.Mutex
+}
+
+// NewListenersAdmin returns nil if the listeners admin API is disabled
+func NewListenersAdmin(c *config.Config, p *Proxy) *ListenersAdmin {
+	if !c.Proxy.ListenersAdminEnable || p == nil {
+		return nil
+	}
+	return &ListenersAdmin{listeners: p.listeners, stateFile: c.Proxy.ListenersStateFile}
+}
+
+// Add maps the broker and starts its listener, the mapping is saved to the state file
+func (a *ListenersAdmin) Add(mapping ListenerMapping) (config.ListenerConfig, error) {
+	var cfg config.ListenerConfig
+	if mapping.Kind == MappingDynamic {
+		host, port, err := util.SplitHostPort(strings.TrimSpace(mapping.Mapping))
+		if err != nil {
+			return cfg, err
+		}
+		cfg.BrokerAddress = net.JoinHostPort(host, fmt.Sprint(port))
+	} else {
+		var err error
+		if cfg, err = config.ParseListenerMapping(mapping.Mapping); err != nil {
+			return cfg, err
+		}
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	cfg, err := a.listeners.AddMapping(mapping.Kind, cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if err = a.save(); err != nil {
+		// the state file must not miss the running mappings on restart
+		if removeErr := a.listeners.RemoveMapping(cfg.BrokerAddress); removeErr != nil {
+			logrus.Warnf("Mapping of broker %s could not be reverted: %v", cfg.BrokerAddress, removeErr)
+		}
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Remove removes the mapping of the broker and closes its listeners, the change is saved to the state file.
+// Already accepted connections are not closed.
+func (a *ListenersAdmin) Remove(brokerAddress string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.listeners.RemoveMapping(brokerAddress); err != nil {
+		return err
+	}
+	return a.save()
+}
+
+func (a *ListenersAdmin) save() error {
+	if a.stateFile == "" {
+		return nil
+	}
+	if err := config.SaveListenerMappings(a.stateFile, a.listeners.Mappings()); err != nil {
+		return errors.Wrap(err, "listener mappings could not be saved")
+	}
+	return nil
+}
+
+// ServeHTTP returns the broker mappings on GET, adds the JSON mapping on POST and removes the mapping of the broker query parameter on DELETE
+func (a *ListenersAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var mapping ListenerMapping
+		if err := json.NewDecoder(r.Body).Decode(&mapping); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := a.Add(mapping); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		brokerAddress := r.URL.Query().Get("broker")
+		if brokerAddress == "" {
+			http.Error(w, "broker query parameter is required", http.StatusBadRequest)
+			return
+		}
+		if err := a.Remove(brokerAddress); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.listeners.Brokers())
+}
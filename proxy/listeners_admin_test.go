@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenersAddAndRemoveMapping(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+
+	cfg, err := listeners.AddMapping(MappingBootstrap, config.ListenerConfig{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy:32401"})
+	a.Nil(err)
+	a.Equal("proxy:32401", cfg.AdvertisedAddress)
+	host, port, err := listeners.GetNetAddressMapping("kafka-1", 9092)
+	a.Nil(err)
+	a.Equal("proxy", host)
+	a.Equal(int32(32401), port)
+
+	// the listener accepts the connections of the broker
+	address := listeners.staticListeners["kafka-1:9092"][0].Addr().String()
+	client, err := net.Dial("tcp", address)
+	a.Nil(err)
+	defer client.Close()
+	conn := <-listeners.connSrc
+	a.Equal("kafka-1:9092", conn.BrokerAddress)
+	defer conn.LocalConnection.Close()
+
+	_, err = listeners.AddMapping(MappingBootstrap, config.ListenerConfig{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy:32402"})
+	a.EqualError(err, "broker kafka-1:9092 is already mapped to proxy:32401")
+	_, err = listeners.AddMapping(MappingExternal, config.ListenerConfig{BrokerAddress: "kafka-2:9092", ListenerAddress: "proxy-2:32402", AdvertisedAddress: "proxy-2:32402"})
+	a.Nil(err)
+	cfg, err = listeners.AddMapping(MappingDynamic, config.ListenerConfig{BrokerAddress: "kafka-3:9092"})
+	a.Nil(err)
+	a.True(strings.HasPrefix(cfg.AdvertisedAddress, "127.0.0.1:"))
+	_, err = listeners.AddMapping("static", config.ListenerConfig{BrokerAddress: "kafka-4:9092"})
+	a.EqualError(err, "mapping kind must be bootstrap, external or dynamic, got 'static'")
+
+	a.Equal(config.ListenerMappings{
+		BootstrapServerMappings: []string{"kafka-1:9092,127.0.0.1:0,proxy:32401"},
+		ExternalServerMappings:  []string{"kafka-2:9092,proxy-2:32402"},
+	}, listeners.Mappings())
+
+	// the listener is closed, the accepted connection is kept
+	a.Nil(listeners.RemoveMapping("kafka-1:9092"))
+	_, err = net.Dial("tcp", address)
+	a.NotNil(err)
+	_, err = conn.LocalConnection.Write([]byte("ping"))
+	a.Nil(err)
+	a.EqualError(listeners.RemoveMapping("kafka-1:9092"), "broker kafka-1:9092 is not mapped")
+
+	a.Nil(listeners.RemoveMapping("kafka-3:9092"))
+	a.Len(listeners.dynamicListeners, 0)
+	a.Len(listeners.Brokers(), 1)
+}
+
+func TestListenersAdminServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "listeners-admin")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := config.NewConfig()
+	c.Proxy.ListenersAdminEnable = true
+	c.Proxy.ListenersStateFile = filepath.Join(dir, "listeners.yaml")
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+	admin := NewListenersAdmin(c, &Proxy{listeners: listeners})
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/listeners", strings.NewReader(`{"kind":"bootstrap","mapping":"kafka-1:9092,127.0.0.1:0,proxy:32401"}`)))
+	a.Equal(http.StatusOK, rec.Code)
+	var brokers []BrokerInfo
+	a.Nil(json.NewDecoder(rec.Body).Decode(&brokers))
+	a.Len(brokers, 1)
+	a.Equal(BrokerStateListening, brokers[0].State)
+
+	mappings, err := config.LoadListenerMappings(c.Proxy.ListenersStateFile)
+	a.Nil(err)
+	a.Equal([]string{"kafka-1:9092,127.0.0.1:0,proxy:32401"}, mappings.BootstrapServerMappings)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/listeners", strings.NewReader(`{"kind":"bootstrap","mapping":"kafka-2:9092"}`)))
+	a.Equal(http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/listeners?broker=kafka-1:9092", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	mappings, err = config.LoadListenerMappings(c.Proxy.ListenersStateFile)
+	a.Nil(err)
+	a.Empty(mappings.BootstrapServerMappings)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/listeners", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+
+	c.Proxy.ListenersAdminEnable = false
+	a.Nil(NewListenersAdmin(c, &Proxy{listeners: listeners}))
+}
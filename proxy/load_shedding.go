@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	overloadConnections = "connections"
+	overloadCPU         = "cpu"
+)
+
+// loadShedder protects the proxied connections when the proxy is overloaded. While the number of the proxied connections or the CPU usage
+// of the process exceeds its threshold, new connections are closed right after accept instead of adding latency to the existing ones;
+// the Metadata and FindCoordinator responses optionally advertise another host than this instance, e.g. the other proxy replicas.
+type loadShedder struct {
+	maxConnections int
+	// percent of all cores
+	maxCPU         float64
+	cpuInterval    time.Duration
+	advertisedHost string
+	conns          *ConnSet
+
+	// bits of the last sampled CPU usage
+	cpu uint64
+	// 1 while the last check was overloaded
+	shedding int32
+}
+
+// newLoadShedder returns nil if new connections are accepted regardless of the load
+func newLoadShedder(c *config.Config, conns *ConnSet) *loadShedder {
+	if c.LoadShedding.MaxConnections <= 0 && c.LoadShedding.MaxCPU <= 0 {
+		return nil
+	}
+	return &loadShedder{
+		maxConnections: c.LoadShedding.MaxConnections,
+		maxCPU:         c.LoadShedding.MaxCPU,
+		cpuInterval:    c.LoadShedding.CPUInterval,
+		advertisedHost: c.LoadShedding.AdvertisedHost,
+		conns:          conns,
+	}
+}
+
+// overloaded returns the exceeded threshold if a new connection should be rejected
+func (s *loadShedder) overloaded() (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	reason, overloaded := "", false
+	if s.maxCPU > 0 && s.cpuUsage() > s.maxCPU {
+		reason, overloaded = overloadCPU, true
+	} else if s.maxConnections > 0 && s.conns.Len() >= s.maxConnections {
+		reason, overloaded = overloadConnections, true
+	}
+	if overloaded {
+		if atomic.CompareAndSwapInt32(&s.shedding, 0, 1) {
+			logrus.Warnf("Proxy is overloaded (%s), new connections are rejected", reason)
+			proxyLoadShedding.Set(1)
+		}
+	} else if atomic.CompareAndSwapInt32(&s.shedding, 1, 0) {
+		logrus.Info("Proxy is not overloaded anymore, new connections are accepted")
+		proxyLoadShedding.Set(0)
+	}
+	return reason, overloaded
+}
+
+func (s *loadShedder) isShedding() bool {
+	return atomic.LoadInt32(&s.shedding) == 1
+}
+
+// redirects reports whether the metadata responses advertise the overflow host instead of this instance
+func (s *loadShedder) redirects() bool {
+	return s != nil && s.advertisedHost != "" && s.isShedding()
+}
+
+func (s *loadShedder) cpuUsage() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.cpu))
+}
+
+// netAddressMappingFunc returns the mapping which advertises the configured host while new connections are rejected
+func (s *loadShedder) netAddressMappingFunc(fn config.NetAddressMappingFunc) config.NetAddressMappingFunc {
+	if s == nil || s.advertisedHost == "" {
+		return fn
+	}
+	return func(brokerHost string, brokerPort int32) (string, int32, error) {
+		listenerHost, listenerPort, err := fn(brokerHost, brokerPort)
+		if err != nil || !s.isShedding() {
+			return listenerHost, listenerPort, err
+		}
+		if listenerHost == brokerHost && listenerPort == brokerPort {
+			// passed through unmapped broker
+			return listenerHost, listenerPort, nil
+		}
+		return s.advertisedHost, listenerPort, nil
+	}
+}
+
+// run samples the CPU usage of the process until done is closed
+func (s *loadShedder) run(done <-chan struct{}) {
+	if s == nil || s.maxCPU <= 0 {
+		return
+	}
+	lastCPU, err := processCPUTime()
+	if err != nil {
+		logrus.Warnf("CPU usage is not available, connections are not rejected on CPU usage: %v", err)
+		return
+	}
+	lastTime := time.Now()
+
+	ticker := time.NewTicker(s.cpuInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		cpu, err := processCPUTime()
+		if err != nil {
+			logrus.Warnf("Sampling CPU usage failed: %v", err)
+			continue
+		}
+		now := time.Now()
+		usage := cpuPercent(cpu-lastCPU, now.Sub(lastTime), runtime.NumCPU())
+		atomic.StoreUint64(&s.cpu, math.Float64bits(usage))
+		proxyProcessCPUPercent.Set(usage)
+		lastCPU, lastTime = cpu, now
+		// leave the shedding state without a new connection
+		s.overloaded()
+	}
+}
+
+// cpuPercent returns the CPU time used in the elapsed time in percent of all cores
+func cpuPercent(cpu time.Duration, elapsed time.Duration, cores int) float64 {
+	if elapsed <= 0 || cores <= 0 {
+		return 0
+	}
+	return 100 * float64(cpu) / (float64(elapsed) * float64(cores))
+}
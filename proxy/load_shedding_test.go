@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderConnections(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newLoadShedder(c, NewConnSet()))
+	var disabled *loadShedder
+	_, overloaded := disabled.overloaded()
+	a.False(overloaded)
+
+	c.LoadShedding.MaxConnections = 2
+	c.LoadShedding.AdvertisedHost = "kafka-proxy-peers"
+	conns := NewConnSet()
+	s := newLoadShedder(c, conns)
+	fn := s.netAddressMappingFunc(func(brokerHost string, brokerPort int32) (string, int32, error) {
+		if brokerHost == "unmapped" {
+			return brokerHost, brokerPort, nil
+		}
+		return "kafka-proxy-0", 32400, nil
+	})
+
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	defer conn2.Close()
+	conns.Add("kafka-0:9092", conn1)
+	_, overloaded = s.overloaded()
+	a.False(overloaded)
+	host, _, err := fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("kafka-proxy-0", host)
+
+	conns.Add("kafka-1:9092", conn2)
+	reason, overloaded := s.overloaded()
+	a.True(overloaded)
+	a.Equal(overloadConnections, reason)
+	host, port, err := fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("kafka-proxy-peers", host)
+	a.Equal(int32(32400), port)
+	host, _, err = fn("unmapped", 9092)
+	a.Nil(err)
+	a.Equal("unmapped", host)
+
+	a.Nil(conns.Remove("kafka-1:9092", conn2))
+	_, overloaded = s.overloaded()
+	a.False(overloaded)
+	host, _, err = fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("kafka-proxy-0", host)
+}
+
+func TestLoadShedderCPU(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(50.0, cpuPercent(time.Second, time.Second, 2))
+	a.Equal(0.0, cpuPercent(time.Second, 0, 2))
+
+	cpu, err := processCPUTime()
+	a.Nil(err)
+	a.True(cpu >= 0)
+
+	c := config.NewConfig()
+	c.LoadShedding.MaxCPU = 80
+	s := newLoadShedder(c, NewConnSet())
+	atomic.StoreUint64(&s.cpu, math.Float64bits(90))
+	reason, overloaded := s.overloaded()
+	a.True(overloaded)
+	a.Equal(overloadCPU, reason)
+
+	// the sampled usage of the idle test process is below the limit
+	c.LoadShedding.CPUInterval = 10 * time.Millisecond
+	s = newLoadShedder(c, NewConnSet())
+	done := make(chan struct{})
+	go s.run(done)
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	_, overloaded = s.overloaded()
+	a.False(overloaded)
+}
+
+func TestListenersLoadSheddingRejectsNewConnections(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.LoadShedding.MaxConnections = 1
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+	conns := NewConnSet()
+	listeners.loadShedder = newLoadShedder(c, conns)
+
+	_, err = listeners.AddMapping(MappingBootstrap, config.ListenerConfig{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy:32401"})
+	a.Nil(err)
+	address := listeners.staticListeners["kafka-1:9092"][0].Addr().String()
+
+	proxied, _ := net.Pipe()
+	defer proxied.Close()
+	conns.Add("kafka-1:9092", proxied)
+	before := counterOf(a, proxyRejectedConnectionsTotal, address, rejectReasonOverloaded)
+	client, err := net.Dial("tcp", address)
+	a.Nil(err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.Equal(before+1, counterOf(a, proxyRejectedConnectionsTotal, address, rejectReasonOverloaded))
+
+	a.Nil(conns.Remove("kafka-1:9092", proxied))
+	client, err = net.Dial("tcp", address)
+	a.Nil(err)
+	defer client.Close()
+	conn := <-listeners.connSrc
+	a.Equal("kafka-1:9092", conn.BrokerAddress)
+	conn.LocalConnection.Close()
+}
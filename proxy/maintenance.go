@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const (
+	maintenanceActionAnswer = "answer"
+	maintenanceActionClose  = "close"
+)
+
+// MaintenanceRule rejects the Produce requests to the topics matching the regular expression, the requests of the api keys
+// or all writes forbidden by the read-only mode
+type MaintenanceRule struct {
+	Topic   string  `json:"topic,omitempty"`
+	ApiKeys []int16 `json:"api_keys,omitempty"`
+	Writes  bool    `json:"writes,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+type maintenanceRule struct {
+	MaintenanceRule
+	topic   *regexp.Regexp
+	apiKeys map[int16]bool
+}
+
+func newMaintenanceRule(rule MaintenanceRule, defaultMessage string) (*maintenanceRule, error) {
+	if rule.Topic == "" && len(rule.ApiKeys) == 0 && !rule.Writes {
+		return nil, errors.New("topic, api_keys or writes is required")
+	}
+	if rule.Message == "" {
+		rule.Message = defaultMessage
+	}
+	result := &maintenanceRule{MaintenanceRule: rule, apiKeys: make(map[int16]bool)}
+	if rule.Topic != "" {
+		var err error
+		if result.topic, err = regexp.Compile(rule.Topic); err != nil {
+			return nil, errors.Wrapf(err, "invalid topic %s", rule.Topic)
+		}
+	}
+	for _, apiKey := range rule.ApiKeys {
+		result.apiKeys[apiKey] = true
+	}
+	if rule.Writes {
+		for _, apiKey := range readOnlyForbiddenApiKeys {
+			result.apiKeys[apiKey] = true
+		}
+	}
+	return result, nil
+}
+
+// Maintenance rejects the writes with POLICY_VIOLATION and the message of the rule while the reads continue, e.g. to freeze the topics
+// during a migration. The connections stay open, only the requests which responses cannot carry the error close the connection.
+// The rules can be changed at runtime e.g. with the HTTP admin API.
+type Maintenance struct {
+	message string
+
+	lock  sync.RWMutex
+	rules []*maintenanceRule
+}
+
+// NewMaintenance returns nil if there are no maintenance rules and they are not managed by the admin API
+func NewMaintenance(c *config.Config) (*Maintenance, error) {
+	if !c.Maintenance.AdminEnable && len(c.Maintenance.Topics) == 0 && len(c.Maintenance.ApiKeys) == 0 && !c.Maintenance.Writes {
+		return nil, nil
+	}
+	m := &Maintenance{message: c.Maintenance.Message}
+	for _, topic := range c.Maintenance.Topics {
+		if err := m.Add(MaintenanceRule{Topic: topic}); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Maintenance.ApiKeys) != 0 || c.Maintenance.Writes {
+		rule := MaintenanceRule{Writes: c.Maintenance.Writes}
+		for _, apiKey := range c.Maintenance.ApiKeys {
+			rule.ApiKeys = append(rule.ApiKeys, int16(apiKey))
+		}
+		if err := m.Add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Add starts rejecting the requests matching the rule, the requests of the open connections are affected
+func (m *Maintenance) Add(rule MaintenanceRule) error {
+	compiled, err := newMaintenanceRule(rule, m.message)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.rules = append(m.rules, compiled)
+	m.lock.Unlock()
+	logrus.Warnf("Maintenance rule is added: topic '%s', api keys %v, writes %v, message '%s'", rule.Topic, rule.ApiKeys, rule.Writes, compiled.Message)
+	return nil
+}
+
+// Clear removes all rules
+func (m *Maintenance) Clear() {
+	m.lock.Lock()
+	m.rules = nil
+	m.lock.Unlock()
+	logrus.Infof("Maintenance rules are cleared")
+}
+
+// Rules returns the current rules
+func (m *Maintenance) Rules() []MaintenanceRule {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	result := make([]MaintenanceRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		result = append(result, rule.MaintenanceRule)
+	}
+	return result
+}
+
+// ServeHTTP returns the rules on GET, adds the rule of the JSON body on POST and clears the rules on DELETE
+func (m *Maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var rule MaintenanceRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.Add(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		m.Clear()
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Rules())
+}
+
+// inspects reports whether the request body must be read to be checked by the rules
+func (m *Maintenance) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if m == nil {
+		return false
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, rule := range m.rules {
+		if rule.apiKeys[requestKeyVersion.ApiKey] || (rule.topic != nil && requestKeyVersion.ApiKey == apiKeyProduce) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the message of the first rule rejecting the request, false if the request is forwarded
+func (m *Maintenance) check(requestKeyVersion *protocol.RequestKeyVersion, req []byte) (string, bool) {
+	m.lock.RLock()
+	rules := m.rules
+	m.lock.RUnlock()
+
+	var topics []string
+	var topicsErr error
+	decoded := false
+	for _, rule := range rules {
+		if rule.apiKeys[requestKeyVersion.ApiKey] {
+			return rule.Message, true
+		}
+		if rule.topic == nil || requestKeyVersion.ApiKey != apiKeyProduce {
+			continue
+		}
+		if !decoded {
+			topics, topicsErr = protocol.DecodeRequestTopicNames(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, req)
+			decoded = true
+		}
+		// the writes are frozen if the topics cannot be checked
+		if topicsErr != nil {
+			return rule.Message, true
+		}
+		for _, topic := range topics {
+			if rule.topic.MatchString(topic) {
+				return rule.Message, true
+			}
+		}
+	}
+	return "", false
+}
+
+// reject returns the error response of the request rejected by a rule, false if the request is forwarded. An error is returned
+// if the rejected request cannot be answered e.g. as its response has no top level error code or a Produce request has acks 0.
+func (m *Maintenance) reject(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, req []byte) ([]byte, bool, error) {
+	message, ok := m.check(requestKeyVersion, req)
+	if !ok {
+		return nil, false, nil
+	}
+	var resp []byte
+	// request header v0 has no correlation id
+	if len(headerBuf) >= 4 {
+		if requestKeyVersion.ApiKey == apiKeyProduce {
+			resp, _ = protocol.RejectProduce(requestKeyVersion.ApiVersion, req, protocol.ErrPolicyViolation, message)
+		} else {
+			resp, _ = protocol.EncodeErrorResponseWithMessage(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, protocol.ErrPolicyViolation, message)
+		}
+	}
+	apiKey := strconv.Itoa(int(requestKeyVersion.ApiKey))
+	if resp == nil {
+		proxyMaintenanceRejectedTotal.WithLabelValues(apiKey, maintenanceActionClose).Inc()
+		return nil, true, fmt.Errorf("api key %d version %d is rejected by the maintenance: %s", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, message)
+	}
+	proxyMaintenanceRejectedTotal.WithLabelValues(apiKey, maintenanceActionAnswer).Inc()
+	return resp, true, nil
+}
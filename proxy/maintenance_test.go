@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRules(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := NewMaintenance(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	a.False(disabled.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}))
+
+	c.Maintenance.Topics = []string{"^orders$"}
+	c.Maintenance.Message = "orders are migrated"
+	m, err := NewMaintenance(c)
+	a.Nil(err)
+
+	produce := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}
+	a.True(m.inspects(produce))
+	a.False(m.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4}))
+
+	resp, rejected, err := m.reject(produce, correlationHeader(1), kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")}))
+	a.Nil(err)
+	a.True(rejected)
+	code, err := kafkatest.DecodeProduceErrorCode(resp)
+	a.Nil(err)
+	a.Equal(int16(protocol.ErrPolicyViolation), code)
+
+	_, rejected, err = m.reject(produce, correlationHeader(2), kafkatest.ProduceRequestBody("payments", [][]byte{[]byte("v1")}))
+	a.Nil(err)
+	a.False(rejected)
+
+	// all writes, the responses without a top level error code close the connection
+	a.Nil(m.Add(MaintenanceRule{Writes: true}))
+	_, rejected, err = m.reject(produce, correlationHeader(3), kafkatest.ProduceRequestBody("payments", [][]byte{[]byte("v1")}))
+	a.Nil(err)
+	a.True(rejected)
+	_, rejected, err = m.reject(&protocol.RequestKeyVersion{ApiKey: 19, ApiVersion: 0}, correlationHeader(4), nil)
+	a.NotNil(err)
+	a.True(rejected)
+	a.Equal([]MaintenanceRule{{Topic: "^orders$", Message: "orders are migrated"}, {Writes: true, Message: "orders are migrated"}}, m.Rules())
+
+	a.NotNil(m.Add(MaintenanceRule{Message: "nothing"}))
+	a.NotNil(m.Add(MaintenanceRule{Topic: "("}))
+	m.Clear()
+	a.False(m.inspects(produce))
+}
+
+func TestMaintenanceAdminAPI(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Maintenance.AdminEnable = true
+	c.Maintenance.Message = "frozen"
+	m, err := NewMaintenance(c)
+	a.Nil(err)
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"api_keys":[19,20],"message":"topics are frozen"}`))
+	a.Nil(err)
+	var rules []MaintenanceRule
+	a.Nil(json.NewDecoder(resp.Body).Decode(&rules))
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal([]MaintenanceRule{{ApiKeys: []int16{19, 20}, Message: "topics are frozen"}}, rules)
+
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(`{"message":"nothing"}`))
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL, nil)
+	a.Nil(err)
+	resp, err = http.DefaultClient.Do(req)
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Empty(m.Rules())
+}
+
+func TestProxyMaintenanceRejectsProduceAndForwardsFetch(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Maintenance.Topics = []string{"^orders$"}
+	c.Maintenance.Message = "orders are migrated"
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")})))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+	code, err := kafkatest.DecodeProduceErrorCode(body)
+	a.Nil(err)
+	a.Equal(int16(protocol.ErrPolicyViolation), code)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+
+	// the reads continue on the same connection
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 2, "test", kafkatest.FetchRequestBody("orders", 0, 0)))
+	a.Nil(err)
+	correlationID, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(2), correlationID)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyFetch))
+}
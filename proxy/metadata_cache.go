@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metadataCache answers the repeated Metadata requests with the response sent to the clients before, so a fleet of consumers
+// starting at once does not flood the brokers with the same requests. The responses are cached for the TTL by the principal,
+// the requested topics and the cluster and rack of the client connection, as the rewritten broker addresses depend on them.
+// The connections which SASL authentication is passed through to the brokers bypass the cache, their principal is not known
+// to the proxy and the responses are filtered by its ACLs.
+type metadataCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// cachedResponse is a response sent to a client before which answers the same requests until it expires
+type cachedResponse struct {
+	resp    []byte
+	expires time.Time
+}
+
+// newMetadataCache returns nil if the Metadata responses are not cached
+func newMetadataCache(c *config.Config) *metadataCache {
+	if c.MetadataCache.TTL == 0 {
+		return nil
+	}
+	logrus.Infof("Metadata responses will be cached for %v", c.MetadataCache.TTL)
+	return &metadataCache{
+		ttl:     c.MetadataCache.TTL,
+		size:    c.MetadataCache.MaxEntries,
+		now:     time.Now,
+		entries: make(map[string]*cachedResponse),
+	}
+}
+
+// newSession returns the session of a client connection, variant distinguishes the connections which responses are rewritten differently.
+// The cache is bypassed while redirecting reports that the responses advertise another host, e.g. while draining or shedding load.
+func (m *metadataCache) newSession(variant string, redirecting func() bool) *metadataCacheSession {
+	if m == nil {
+		return nil
+	}
+	return &metadataCacheSession{cache: m, variant: variant, redirecting: redirecting, pending: make(map[int32]string)}
+}
+
+func (m *metadataCache) get(key string) []byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || !m.now().Before(entry.expires) {
+		return nil
+	}
+	return entry.resp
+}
+
+func (m *metadataCache) put(key string, resp []byte) {
+	now := m.now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.entries) >= m.size {
+		m.evict(now)
+	}
+	m.entries[key] = &cachedResponse{resp: resp, expires: now.Add(m.ttl)}
+}
+
+// evict removes the expired responses or all responses if none expired
+func (m *metadataCache) evict(now time.Time) {
+	for key, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+	if len(m.entries) >= m.size {
+		m.entries = make(map[string]*cachedResponse)
+	}
+}
+
+// metadataCacheSession matches the responses of the Metadata requests which were not answered from the cache by the correlation id
+type metadataCacheSession struct {
+	cache       *metadataCache
+	variant     string
+	redirecting func() bool
+
+	lock    sync.Mutex
+	pending map[int32]string
+	// the client was authenticated by the broker
+	passedThrough bool
+}
+
+// inspects reports whether the request body must be read to be answered from the cache
+func (s *metadataCacheSession) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s != nil && requestKeyVersion.ApiKey == apiKeyMetadata
+}
+
+// lookup returns the cached response of the request body as sent by the client, nil if the response of the broker is awaited
+func (s *metadataCacheSession) lookup(principal string, requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, req []byte) []byte {
+	// request header v0 has no correlation id
+	if len(headerBuf) < 4 || s.bypassed() {
+		return nil
+	}
+	key := s.variant + "\x00" + principal + "\x00" + strconv.Itoa(int(requestKeyVersion.ApiVersion)) + "\x00" + string(req)
+	if resp := s.cache.get(key); resp != nil {
+		proxyMetadataCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return resp
+	}
+	proxyMetadataCacheRequestsTotal.WithLabelValues("miss").Inc()
+	s.lock.Lock()
+	s.pending[int32(binary.BigEndian.Uint32(headerBuf))] = key
+	s.lock.Unlock()
+	return nil
+}
+
+// inspectsResponse reports whether the response body must be read to be cached
+func (s *metadataCacheSession) inspectsResponse(correlationID int32) bool {
+	if s == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.pending[correlationID]
+	return ok
+}
+
+// bypassed reports whether the responses are neither answered from the cache nor cached
+func (s *metadataCacheSession) bypassed() bool {
+	s.lock.Lock()
+	passedThrough := s.passedThrough
+	s.lock.Unlock()
+	return passedThrough || (s.redirecting != nil && s.redirecting())
+}
+
+// authenticatedByBroker bypasses the cache for the rest of the connection, the SASL requests are forwarded to the broker
+func (s *metadataCacheSession) authenticatedByBroker() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.passedThrough = true
+	s.lock.Unlock()
+}
+
+// forget drops the request which response is not cached, e.g. as it cannot be rewritten or the request is answered by the proxy
+func (s *metadataCacheSession) forget(correlationID int32) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	delete(s.pending, correlationID)
+	s.lock.Unlock()
+}
+
+// store caches the response as sent to the client, the responses with errors e.g. of the topics being created are not cached
+func (s *metadataCacheSession) store(correlationID int32, apiVersion int16, resp []byte) {
+	s.lock.Lock()
+	key, ok := s.pending[correlationID]
+	delete(s.pending, correlationID)
+	s.lock.Unlock()
+	if !ok || s.bypassed() {
+		return
+	}
+	kerrs, err := protocol.DecodeResponseErrors(apiKeyMetadata, apiVersion, resp)
+	if err != nil || len(kerrs) != 0 {
+		return
+	}
+	s.cache.put(key, append([]byte(nil), resp...))
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func correlationHeader(correlationID int32) []byte {
+	headerBuf := make([]byte, 6)
+	binary.BigEndian.PutUint32(headerBuf, uint32(correlationID))
+	return headerBuf
+}
+
+func TestMetadataCache(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newMetadataCache(c))
+	var disabled *metadataCache
+	s := disabled.newSession("", nil)
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata, ApiVersion: 1}))
+	a.False(s.inspectsResponse(1))
+	s.forget(1)
+
+	c.MetadataCache.TTL = time.Second
+	c.MetadataCache.MaxEntries = 2
+	m := newMetadataCache(c)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	requestKeyVersion := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata, ApiVersion: 1}
+	req := kafkatest.MetadataRequestBody(1, []string{"test"})
+	// no brokers, controller id 1 and no topics
+	resp := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+	s = m.newSession("primary/", nil)
+	a.True(s.inspects(requestKeyVersion))
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}))
+	a.Nil(s.lookup("alice", requestKeyVersion, correlationHeader(1), req))
+	a.True(s.inspectsResponse(1))
+	s.store(1, 1, resp)
+	a.False(s.inspectsResponse(1))
+	a.Equal(resp, s.lookup("alice", requestKeyVersion, correlationHeader(2), req))
+
+	// other principal, topics, version or rack
+	a.Nil(s.lookup("bob", requestKeyVersion, correlationHeader(3), req))
+	a.Nil(s.lookup("alice", requestKeyVersion, correlationHeader(4), kafkatest.MetadataRequestBody(1, []string{"other"})))
+	a.Nil(s.lookup("alice", &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata, ApiVersion: 2}, correlationHeader(5), req))
+	a.Nil(m.newSession("primary/rack-a", nil).lookup("alice", requestKeyVersion, correlationHeader(1), req))
+
+	// responses which cannot be rewritten are not cached
+	s.forget(3)
+	s.store(3, 1, resp)
+	a.Nil(s.lookup("bob", requestKeyVersion, correlationHeader(6), req))
+
+	// the responses advertising another host are neither cached nor answered from the cache
+	redirecting := true
+	redirected := m.newSession("primary/", func() bool { return redirecting })
+	a.Nil(redirected.lookup("alice", requestKeyVersion, correlationHeader(7), req))
+	a.False(redirected.inspectsResponse(7))
+	a.Nil(redirected.lookup("carol", requestKeyVersion, correlationHeader(8), req))
+	redirecting = false
+	a.Nil(redirected.lookup("carol", requestKeyVersion, correlationHeader(8), req))
+	redirecting = true
+	redirected.store(8, 1, resp)
+	redirecting = false
+	a.Nil(redirected.lookup("carol", requestKeyVersion, correlationHeader(9), req))
+	a.Equal(resp, redirected.lookup("alice", requestKeyVersion, correlationHeader(10), req))
+
+	// the clients authenticated by the broker are neither answered from the cache nor cached
+	passedThrough := m.newSession("primary/", nil)
+	passedThrough.authenticatedByBroker()
+	a.Nil(passedThrough.lookup("", requestKeyVersion, correlationHeader(1), req))
+	a.False(passedThrough.inspectsResponse(1))
+
+	now = now.Add(time.Second)
+	a.Nil(s.lookup("alice", requestKeyVersion, correlationHeader(11), req))
+}
+
+func TestProxyWithMetadataCache(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.MetadataCache.TTL = time.Minute
+	c.MetadataCache.MaxEntries = 100
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	hits := counterOf(a, proxyMetadataCacheRequestsTotal, "hit")
+	var expected []byte
+	for i := int32(1); i <= 3; i++ {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, i, "test", kafkatest.MetadataRequestBody(1, []string{"test"})))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(i, correlationID)
+		if expected == nil {
+			expected = body
+		}
+		a.Equal(expected, body)
+		conn.Close()
+	}
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyMetadata))
+	a.Equal(hits+2, counterOf(a, proxyMetadataCacheRequestsTotal, "hit"))
+}
+
+// authenticatePassThrough authenticates the client with SASL/PLAIN by the broker, the proxy forwards the SASL requests
+func authenticatePassThrough(a *assert.Assertions, conn net.Conn, username string, password string) {
+	mechanism := []byte{0, 5, 'P', 'L', 'A', 'I', 'N'}
+	_, err := conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeySaslHandshake, 1, 1, "test", mechanism))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	authBytes := []byte("\x00" + username + "\x00" + password)
+	body := make([]byte, 4, 4+len(authBytes))
+	binary.BigEndian.PutUint32(body, uint32(len(authBytes)))
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeySaslAuthenticate, 0, 2, "test", append(body, authBytes...)))
+	a.Nil(err)
+	_, resp, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal([]byte{0, 0}, resp[:2])
+}
+
+func TestProxyMetadataCacheBypassedBySASLPassThrough(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{
+		NodeID: 1,
+		Topics: map[string]int32{"orders": 1, "payments": 1},
+		Users:  map[string]string{"alice": "alice-secret", "bob": "bob-secret"},
+		Acls:   map[string][]string{"alice": {"orders", "payments"}, "bob": {"orders"}},
+	})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.MetadataCache.TTL = time.Minute
+	c.MetadataCache.MaxEntries = 100
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	for _, tt := range []struct {
+		username string
+		topics   []string
+	}{
+		{username: "alice", topics: []string{"orders", "payments"}},
+		{username: "bob", topics: []string{"orders"}},
+		{username: "alice", topics: []string{"orders", "payments"}},
+	} {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		authenticatePassThrough(a, conn, tt.username, tt.username+"-secret")
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 3, "test", kafkatest.MetadataRequestBody(1, []string{"orders", "payments"})))
+		a.Nil(err)
+		_, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		topics, err := kafkatest.DecodeMetadataTopics(1, body)
+		a.Nil(err)
+		a.Equal(tt.topics, topics, tt.username)
+		conn.Close()
+	}
+	a.Equal(3, broker.RequestCount(kafkatest.ApiKeyMetadata))
+}
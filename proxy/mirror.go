@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+const (
+	// minimal interval between the metadata requests when the metadata is stale or cannot be fetched
+	mirrorMetadataBackoff = time.Second
+	// api version of the mirror metadata requests
+	mirrorMetadataVersion = int16(1)
+)
+
+type mirrorRequest struct {
+	apiVersion int16
+	body       []byte
+}
+
+// mirror duplicates the Produce requests to the secondary cluster. The requests are sent asynchronously with acks 0 by a single goroutine,
+// the requests are dropped when the queue is full.
+type mirror struct {
+	bootstrapServers []string
+	dialer           Dialer
+	clientID         string
+	writeTimeout     time.Duration
+	readTimeout      time.Duration
+	metadataRefresh  time.Duration
+	queue            chan mirrorRequest
+
+	// used by the run goroutine only
+	metadata      *protocol.MetadataLeaders
+	refreshed     time.Time
+	stale         bool
+	conns         map[int32]net.Conn
+	correlationID int32
+}
+
+// newMirror returns nil if no mirror cluster is configured
+func newMirror(c *config.Config) (*mirror, error) {
+	if len(c.Mirror.BootstrapServers) == 0 {
+		return nil, nil
+	}
+	dialer, err := newRawDialer(c)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Produce requests will be mirrored to %v", c.Mirror.BootstrapServers)
+	return &mirror{
+		bootstrapServers: c.Mirror.BootstrapServers,
+		dialer:           dialer,
+		clientID:         c.Kafka.ClientID,
+		writeTimeout:     c.Mirror.WriteTimeout,
+		readTimeout:      c.Mirror.ReadTimeout,
+		metadataRefresh:  c.Mirror.MetadataRefreshInterval,
+		queue:            make(chan mirrorRequest, c.Mirror.QueueSize),
+		conns:            make(map[int32]net.Conn),
+	}, nil
+}
+
+// inspects reports whether the request body must be read to be mirrored
+func (m *mirror) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return m != nil && requestKeyVersion.ApiKey == apiKeyProduce
+}
+
+// enqueue queues the Produce request body, the body must not be modified afterwards
+func (m *mirror) enqueue(apiVersion int16, body []byte) {
+	select {
+	case m.queue <- mirrorRequest{apiVersion: apiVersion, body: body}:
+	default:
+		proxyMirrorRequestsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run sends the queued requests until done is closed
+func (m *mirror) run(done <-chan struct{}) {
+	defer m.closeConns()
+	for {
+		select {
+		case request := <-m.queue:
+			skipped, err := m.send(request)
+			switch {
+			case err != nil:
+				proxyMirrorRequestsTotal.WithLabelValues("failed").Inc()
+				logrus.Debugf("Mirroring of produce request failed: %v", err)
+			case skipped != 0:
+				proxyMirrorRequestsTotal.WithLabelValues("partial").Inc()
+			default:
+				proxyMirrorRequestsTotal.WithLabelValues("sent").Inc()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// send returns the number of partitions which were not mirrored as their leaders are unknown
+func (m *mirror) send(request mirrorRequest) (int, error) {
+	metadata, err := m.leaders()
+	if err != nil {
+		return 0, err
+	}
+	bodies, skipped, err := protocol.SplitProduceRequest(request.apiVersion, request.body, func(topic string, partition int32) (int32, bool) {
+		nodeID, _, ok := metadata.Leader(topic, partition)
+		return nodeID, ok
+	})
+	if err != nil {
+		return 0, err
+	}
+	if skipped != 0 {
+		// topic could be created or partitions added since the last refresh
+		m.stale = true
+	}
+	for nodeID, body := range bodies {
+		if err = m.write(metadata, nodeID, request.apiVersion, body); err != nil {
+			// leader could be moved
+			m.stale = true
+			return 0, err
+		}
+	}
+	return skipped, nil
+}
+
+func (m *mirror) write(metadata *protocol.MetadataLeaders, nodeID int32, apiVersion int16, body []byte) error {
+	conn, ok := m.conns[nodeID]
+	if !ok {
+		address, ok := metadata.Brokers[nodeID]
+		if !ok {
+			return fmt.Errorf("mirror broker %d is unknown", nodeID)
+		}
+		var err error
+		if conn, err = m.dialer.Dial("tcp", address); err != nil {
+			return errors.Wrapf(err, "mirror broker %s", address)
+		}
+		m.conns[nodeID] = conn
+	}
+	// no response is sent for acks 0
+	err := conn.SetWriteDeadline(time.Now().Add(m.writeTimeout))
+	if err == nil {
+		_, err = conn.Write(m.frame(apiKeyProduce, apiVersion, body))
+	}
+	if err != nil {
+		conn.Close()
+		delete(m.conns, nodeID)
+		return err
+	}
+	return nil
+}
+
+// leaders returns the mirror cluster metadata which is refreshed when expired or stale
+func (m *mirror) leaders() (*protocol.MetadataLeaders, error) {
+	now := time.Now()
+	if m.metadata != nil && !m.stale && now.Before(m.refreshed.Add(m.metadataRefresh)) {
+		return m.metadata, nil
+	}
+	if now.Before(m.refreshed.Add(mirrorMetadataBackoff)) {
+		if m.metadata == nil {
+			return nil, errors.New("mirror cluster metadata is not available")
+		}
+		return m.metadata, nil
+	}
+	m.refreshed = now
+
+	var err error
+	for _, address := range m.bootstrapServers {
+		var metadata *protocol.MetadataLeaders
+		if metadata, err = m.fetchMetadata(address); err == nil {
+			m.metadata = metadata
+			m.stale = false
+			return metadata, nil
+		}
+		logrus.Debugf("Mirror cluster metadata request to %s failed: %v", address, err)
+	}
+	if m.metadata == nil {
+		return nil, err
+	}
+	return m.metadata, nil
+}
+
+func (m *mirror) fetchMetadata(address string) (*protocol.MetadataLeaders, error) {
+	conn, err := m.dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	body, err := protocol.Encode(&protocol.MetadataRequestV1{})
+	if err != nil {
+		return nil, err
+	}
+	return requestMetadataLeaders(conn, m.frame(apiKeyMetadata, mirrorMetadataVersion, body), m.writeTimeout, m.readTimeout)
+}
+
+func (m *mirror) frame(apiKey int16, apiVersion int16, body []byte) []byte {
+	m.correlationID++
+	return requestFrame(apiKey, apiVersion, m.correlationID, m.clientID, body)
+}
+
+func (m *mirror) closeConns() {
+	for nodeID, conn := range m.conns {
+		conn.Close()
+		delete(m.conns, nodeID)
+	}
+}
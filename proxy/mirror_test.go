@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestMirror(t *testing.T, bootstrapServer string, queueSize int) *mirror {
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Mirror.BootstrapServers = []string{bootstrapServer}
+	c.Mirror.QueueSize = queueSize
+	c.Mirror.WriteTimeout = time.Second
+	c.Mirror.ReadTimeout = time.Second
+	c.Mirror.MetadataRefreshInterval = time.Minute
+	m, err := newMirror(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMirrorSendsProduceRequests(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	m := newTestMirror(t, broker.Addr(), 10)
+	a.True(m.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyProduce}))
+	a.False(m.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyFetch}))
+
+	done := make(chan struct{})
+	defer close(done)
+	go m.run(done)
+
+	m.enqueue(3, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")}))
+	m.enqueue(3, kafkatest.ProduceRequestBody("unknown", [][]byte{[]byte("v2")}))
+	for deadline := time.Now().Add(5 * time.Second); broker.RequestCount(kafkatest.ApiKeyProduce) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyMetadata))
+}
+
+func TestMirrorDropsRequestsWhenQueueIsFull(t *testing.T) {
+	a := assert.New(t)
+
+	var nilMirror *mirror
+	a.False(nilMirror.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyProduce}))
+
+	m := newTestMirror(t, "127.0.0.1:9092", 1)
+	m.enqueue(3, []byte{1})
+	m.enqueue(3, []byte{2})
+	a.Len(m.queue, 1)
+	a.Equal([]byte{1}, (<-m.queue).body)
+}
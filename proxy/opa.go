@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// opaAuthorizer delegates the authorization of the requests to the Open Policy Agent e.g. running as a sidecar.
+// The decision endpoint is queried with the principal, client id, api key and the topic and group names of the request
+// and must return a boolean result, an undefined result denies the request. The decisions are cached for the cache TTL.
+type opaAuthorizer struct {
+	url string
+	// all api keys are authorized when empty
+	apiKeys    map[int16]struct{}
+	httpClient *http.Client
+	cacheTTL   time.Duration
+	cacheSize  int
+	failOpen   bool
+	now        func() time.Time
+	// the requests are not rejected if the policy is evaluated in a dry run
+	dryRun *policyDryRun
+
+	lock  sync.Mutex
+	cache map[string]*opaDecision
+}
+
+type opaDecision struct {
+	allow   bool
+	expires time.Time
+}
+
+// opaInput is the input document of the decision, the names are sorted and unique so the decisions can be cached
+type opaInput struct {
+	Principal  string   `json:"principal"`
+	ClientID   string   `json:"client_id"`
+	ApiKey     int16    `json:"api_key"`
+	ApiVersion int16    `json:"api_version"`
+	Topics     []string `json:"topics,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+}
+
+// newOPAAuthorizer returns nil if the requests are not authorized by OPA
+func newOPAAuthorizer(c *config.Config) *opaAuthorizer {
+	if c.OPA.URL == "" {
+		return nil
+	}
+	authorizer := &opaAuthorizer{
+		url:        c.OPA.URL,
+		httpClient: &http.Client{Timeout: c.OPA.Timeout},
+		cacheTTL:   c.OPA.CacheTTL,
+		cacheSize:  c.OPA.CacheSize,
+		failOpen:   c.OPA.FailOpen,
+		now:        time.Now,
+		cache:      make(map[string]*opaDecision),
+	}
+	for _, v := range c.OPA.ApiKeys {
+		if authorizer.apiKeys == nil {
+			authorizer.apiKeys = make(map[int16]struct{})
+		}
+		authorizer.apiKeys[int16(v)] = struct{}{}
+	}
+	logrus.Infof("Requests with api keys %v will be authorized by OPA decision %s", c.OPA.ApiKeys, c.OPA.URL)
+	return authorizer
+}
+
+// authorizes reports whether the requests of the api key are authorized
+func (a *opaAuthorizer) authorizes(apiKey int16) bool {
+	if a == nil {
+		return false
+	}
+	if len(a.apiKeys) == 0 {
+		return true
+	}
+	_, ok := a.apiKeys[apiKey]
+	return ok
+}
+
+// isForbidden reports the authorized versions which topic and group names cannot be decoded
+func (a *opaAuthorizer) isForbidden(apiKey int16, apiVersion int16) bool {
+	if !a.authorizes(apiKey) {
+		return false
+	}
+	maxVersion, ok := protocol.RequestNamesMaxVersion(apiKey)
+	return ok && apiVersion > maxVersion
+}
+
+// inspects reports whether the request body must be decoded for the topic and group names
+func (a *opaAuthorizer) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if !a.authorizes(requestKeyVersion.ApiKey) {
+		return false
+	}
+	_, ok := protocol.RequestNamesMaxVersion(requestKeyVersion.ApiKey)
+	return ok
+}
+
+// check returns an error if the request is not allowed by OPA, body is nil if the request does not carry names
+func (a *opaAuthorizer) check(principal string, clientID string, requestKeyVersion *protocol.RequestKeyVersion, body []byte) error {
+	input := &opaInput{
+		Principal:  principal,
+		ClientID:   clientID,
+		ApiKey:     requestKeyVersion.ApiKey,
+		ApiVersion: requestKeyVersion.ApiVersion,
+	}
+	if body != nil {
+		topics, groups, err := protocol.DecodeRequestNames(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, body)
+		if err != nil {
+			return err
+		}
+		input.Topics = sortedUnique(topics)
+		input.Groups = sortedUnique(groups)
+	}
+	allow, err := a.decide(input)
+	if err != nil {
+		proxyOPAErrorsTotal.Inc()
+		if !a.failOpen {
+			return errors.Wrapf(err, "authorization of api key %d of principal %q and client id %q failed", input.ApiKey, principal, clientID)
+		}
+		logrus.Warnf("Authorization of api key %d of principal %q and client id %q failed, the request is allowed: %v", input.ApiKey, principal, clientID, err)
+		return nil
+	}
+	if allow {
+		return nil
+	}
+	reason := fmt.Sprintf("api key %d of principal %q and client id %q to topics %v and groups %v", input.ApiKey, principal, clientID, input.Topics, input.Groups)
+	if a.dryRun.enabled(config.DryRunOPA) {
+		a.dryRun.deny(config.DryRunOPA, reason)
+		return nil
+	}
+	return fmt.Errorf("%s is not allowed by OPA", reason)
+}
+
+// decide returns the cached decision or queries OPA
+func (a *opaAuthorizer) decide(input *opaInput) (bool, error) {
+	key, err := json.Marshal(input)
+	if err != nil {
+		return false, err
+	}
+	now := a.now()
+	if a.cacheTTL > 0 {
+		a.lock.Lock()
+		cached, ok := a.cache[string(key)]
+		a.lock.Unlock()
+		if ok && now.Before(cached.expires) {
+			proxyOPADecisionsTotal.WithLabelValues(opaDecisionLabel(cached.allow), "true").Inc()
+			return cached.allow, nil
+		}
+	}
+	allow, err := a.query(key)
+	if err != nil {
+		return false, err
+	}
+	proxyOPADecisionsTotal.WithLabelValues(opaDecisionLabel(allow), "false").Inc()
+	if a.cacheTTL > 0 {
+		a.lock.Lock()
+		if len(a.cache) >= a.cacheSize {
+			a.evict(now)
+		}
+		a.cache[string(key)] = &opaDecision{allow: allow, expires: now.Add(a.cacheTTL)}
+		a.lock.Unlock()
+	}
+	return allow, nil
+}
+
+// evict removes the expired decisions or all decisions if none expired
+func (a *opaAuthorizer) evict(now time.Time) {
+	for key, decision := range a.cache {
+		if !now.Before(decision.expires) {
+			delete(a.cache, key)
+		}
+	}
+	if len(a.cache) >= a.cacheSize {
+		a.cache = make(map[string]*opaDecision)
+	}
+}
+
+// query evaluates the decision of the input document using the OPA data API
+func (a *opaAuthorizer) query(input []byte) (bool, error) {
+	body := make([]byte, 0, len(input)+10)
+	body = append(body, `{"input":`...)
+	body = append(append(body, input...), '}')
+	resp, err := a.httpClient.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "OPA request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, errors.Errorf("OPA returned status %d", resp.StatusCode)
+	}
+	var decision struct {
+		// nil if the decision is undefined
+		Result *bool `json:"result"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, errors.Wrap(err, "invalid OPA response")
+	}
+	return decision.Result != nil && *decision.Result, nil
+}
+
+func opaDecisionLabel(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+func sortedUnique(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	result := names[:1]
+	for _, name := range names[1:] {
+		if name != result[len(result)-1] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
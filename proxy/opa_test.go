@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestOPA allows the requests of the principal payments-app to the topics starting with payments
+func newTestOPA(queries *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(queries, 1)
+		var query struct {
+			Input opaInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Input.ClientID == "undefined" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		allow := query.Input.Principal == "payments-app"
+		for _, topic := range query.Input.Topics {
+			allow = allow && len(topic) >= 8 && topic[:8] == "payments"
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": allow})
+	}))
+}
+
+func TestOPAAuthorizerChecksRequests(t *testing.T) {
+	a := assert.New(t)
+
+	var queries int32
+	server := newTestOPA(&queries)
+	defer server.Close()
+
+	c := config.NewConfig()
+	a.Nil(newOPAAuthorizer(c))
+	a.False(newOPAAuthorizer(c).inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyMetadata}))
+
+	c.OPA.URL = server.URL
+	c.OPA.ApiKeys = []int{int(apiKeyMetadata), int(apiKeyApiApiVersions)}
+	authorizer := newOPAAuthorizer(c)
+	a.True(authorizer.authorizes(apiKeyApiApiVersions))
+	a.False(authorizer.authorizes(apiKeyProduce))
+	a.True(authorizer.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyMetadata}))
+	a.False(authorizer.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions}))
+	a.False(authorizer.isForbidden(apiKeyMetadata, 5))
+	a.True(authorizer.isForbidden(apiKeyMetadata, 100))
+	a.False(authorizer.isForbidden(apiKeyProduce, 100))
+
+	metadata := &protocol.RequestKeyVersion{ApiKey: apiKeyMetadata, ApiVersion: 1}
+	paymentsTopics := []byte{0, 0, 0, 2, 0, 8, 'p', 'a', 'y', 'm', 'e', 'n', 't', 's', 0, 8, 'p', 'a', 'y', 'm', 'e', 'n', 't', 's'}
+	ordersTopics := []byte{0, 0, 0, 1, 0, 6, 'o', 'r', 'd', 'e', 'r', 's'}
+
+	a.Nil(authorizer.check("payments-app", "app-1", metadata, paymentsTopics))
+	a.Nil(authorizer.check("payments-app", "app-1", metadata, paymentsTopics))
+	a.Equal(int32(1), atomic.LoadInt32(&queries))
+	a.EqualError(authorizer.check("payments-app", "app-1", metadata, ordersTopics),
+		`api key 3 of principal "payments-app" and client id "app-1" to topics [orders] and groups [] is not allowed by OPA`)
+	a.NotNil(authorizer.check("orders-app", "app-1", &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions}, nil))
+	a.NotNil(authorizer.check("payments-app", "undefined", &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions}, nil))
+	a.Equal(int32(4), atomic.LoadInt32(&queries))
+
+	c.DryRun.Policies = []string{config.DryRunOPA}
+	authorizer.dryRun = newPolicyDryRun(c)
+	a.Nil(authorizer.check("payments-app", "app-1", metadata, ordersTopics))
+}
+
+func TestOPAAuthorizerFailsClosed(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "policy not loaded", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := config.NewConfig()
+	c.OPA.URL = server.URL
+	authorizer := newOPAAuthorizer(c)
+	apiVersions := &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions}
+	a.EqualError(authorizer.check("payments-app", "app-1", apiVersions, nil),
+		`authorization of api key 18 of principal "payments-app" and client id "app-1" failed: OPA returned status 500`)
+
+	c.OPA.FailOpen = true
+	a.Nil(newOPAAuthorizer(c).check("payments-app", "app-1", apiVersions, nil))
+}
+
+func TestOPAAuthorizerEvictsDecisions(t *testing.T) {
+	a := assert.New(t)
+
+	var queries int32
+	server := newTestOPA(&queries)
+	defer server.Close()
+
+	c := config.NewConfig()
+	c.OPA.URL = server.URL
+	c.OPA.CacheSize = 2
+	authorizer := newOPAAuthorizer(c)
+	apiVersions := &protocol.RequestKeyVersion{ApiKey: apiKeyApiApiVersions}
+	for _, clientID := range []string{"app-1", "app-2", "app-3"} {
+		a.Nil(authorizer.check("payments-app", clientID, apiVersions, nil))
+	}
+	a.Len(authorizer.cache, 1)
+	a.Nil(authorizer.check("payments-app", "app-3", apiVersions, nil))
+	a.Equal(int32(3), atomic.LoadInt32(&queries))
+}
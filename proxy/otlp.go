@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	otlpExportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	otlpScopeName    = "github.com/grepplabs/kafka-proxy"
+
+	// AggregationTemporality CUMULATIVE
+	otlpCumulative = 2
+
+	// requests forwarded e.g. from the client telemetry which are not exported yet
+	otlpForwardQueueSize = 64
+)
+
+// OTLPExporter pushes the Prometheus metrics of the process to an OpenTelemetry collector with OTLP/gRPC.
+// The OTLP messages are encoded by the exporter, so the OpenTelemetry SDK is not required.
+type OTLPExporter struct {
+	interval time.Duration
+	timeout  time.Duration
+	headers  metadata.MD
+	gatherer prometheus.Gatherer
+	conn     *grpc.ClientConn
+	// encoded resource attributes
+	resource []byte
+	// start of the cumulative counters
+	start time.Time
+	// encoded requests exported besides the metrics of the process
+	forwarded chan []byte
+}
+
+// NewOTLPExporter returns nil if the metrics are not pushed
+func NewOTLPExporter(c *config.Config) (*OTLPExporter, error) {
+	opts := c.OTLP
+	if opts.Endpoint == "" {
+		return nil, nil
+	}
+	var dialOption grpc.DialOption
+	if opts.Insecure {
+		dialOption = grpc.WithInsecure()
+	} else {
+		tlsConfig := &tls.Config{}
+		if opts.CAChainCertFile != "" {
+			caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+			if err != nil {
+				return nil, err
+			}
+			rootCAs := x509.NewCertPool()
+			if ok := rootCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+				return nil, errors.New("Failed to parse OTLP root certificate")
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+		if c.FIPS.Enable {
+			restrictToFIPS(tlsConfig)
+		}
+		dialOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	headers := metadata.MD{}
+	for _, header := range opts.Headers {
+		kv := strings.SplitN(header, "=", 2)
+		key := strings.ToLower(kv[0])
+		headers[key] = append(headers[key], kv[1])
+	}
+	// the connection is established in the background and re-established after failures
+	conn, err := grpc.Dial(opts.Endpoint, dialOption)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to OTLP endpoint %s", opts.Endpoint)
+	}
+	resource := make(otlpWriter, 0, 128)
+	resource.stringAttribute(1, "service.name", opts.ServiceName)
+	if hostname, err := os.Hostname(); err == nil {
+		resource.stringAttribute(1, "service.instance.id", hostname)
+	}
+	logrus.Infof("Metrics are pushed to OTLP endpoint %s every %v", opts.Endpoint, opts.Interval)
+	return &OTLPExporter{
+		interval:  opts.Interval,
+		timeout:   opts.Timeout,
+		headers:   headers,
+		gatherer:  prometheus.DefaultGatherer,
+		conn:      conn,
+		resource:  resource,
+		start:     time.Now(),
+		forwarded: make(chan []byte, otlpForwardQueueSize),
+	}, nil
+}
+
+// Run pushes the metrics every interval until the context is cancelled, the last metrics are pushed before it returns
+func (e *OTLPExporter) Run(ctx context.Context) error {
+	defer e.conn.Close()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.exportOrWarn(context.Background())
+		case request := <-e.forwarded:
+			if err := e.invoke(context.Background(), request); err != nil {
+				proxyOTLPExportErrorsTotal.Inc()
+				logrus.Warnf("Forwarded metrics cannot be pushed over OTLP: %v", err)
+			}
+		case <-ctx.Done():
+			e.exportOrWarn(context.Background())
+			return nil
+		}
+	}
+}
+
+func (e *OTLPExporter) exportOrWarn(ctx context.Context) {
+	if err := e.Export(ctx); err != nil {
+		proxyOTLPExportErrorsTotal.Inc()
+		logrus.Warnf("Metrics cannot be pushed over OTLP: %v", err)
+	}
+}
+
+// Export pushes the current metrics once
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+	return e.invoke(ctx, encodeOTLPMetrics(e.resource, families, e.start, time.Now()))
+}
+
+// Forward queues the encoded ExportMetricsServiceRequest, e.g. the OTLP MetricsData of the client telemetry, which is wire compatible.
+// False is returned if the exporter is nil or the queue is full.
+func (e *OTLPExporter) Forward(request []byte) bool {
+	if e == nil {
+		return false
+	}
+	select {
+	case e.forwarded <- request:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *OTLPExporter) invoke(ctx context.Context, request []byte) error {
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, e.headers), e.timeout)
+	defer cancel()
+	var response []byte
+	return e.conn.Invoke(ctx, otlpExportMethod, request, &response, grpc.CallCustomCodec(otlpCodec{}))
+}
+
+// otlpCodec passes the encoded messages to gRPC as they are
+type otlpCodec struct{}
+
+func (otlpCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return nil, errors.Errorf("unexpected OTLP message %T", v)
+}
+
+func (otlpCodec) Unmarshal(data []byte, v interface{}) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = append((*b)[:0], data...)
+		return nil
+	}
+	return errors.Errorf("unexpected OTLP message %T", v)
+}
+
+func (otlpCodec) String() string {
+	return "proto"
+}
+
+// encodeOTLPMetrics encodes the ExportMetricsServiceRequest of the metric families, the counters are cumulative since the start
+func encodeOTLPMetrics(resource []byte, families []*dto.MetricFamily, start time.Time, now time.Time) []byte {
+	startNanos, nowNanos := uint64(start.UnixNano()), uint64(now.UnixNano())
+
+	scope := make(otlpWriter, 0, 64)
+	scope.string(1, otlpScopeName)
+	scopeMetrics := make(otlpWriter, 0, 4096)
+	scopeMetrics.message(1, scope)
+	for _, family := range families {
+		metric := make(otlpWriter, 0, 256)
+		metric.string(1, family.GetName())
+		metric.string(2, family.GetHelp())
+		data := make(otlpWriter, 0, 256)
+		for _, m := range family.GetMetric() {
+			point := make(otlpWriter, 0, 128)
+			timestamp := nowNanos
+			if m.GetTimestampMs() != 0 {
+				timestamp = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				point.attributes(7, m.GetLabel())
+				point.fixed64(2, startNanos)
+				point.fixed64(3, timestamp)
+				point.double(4, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				point.attributes(7, m.GetLabel())
+				point.fixed64(3, timestamp)
+				point.double(4, m.GetGauge().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				point.attributes(9, m.GetLabel())
+				point.fixed64(2, startNanos)
+				point.fixed64(3, timestamp)
+				point.fixed64(4, histogram.GetSampleCount())
+				point.double(5, histogram.GetSampleSum())
+				// the Prometheus buckets are cumulative, the OTLP ones are not
+				var counts []uint64
+				var bounds []float64
+				var previous uint64
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						continue
+					}
+					counts = append(counts, bucket.GetCumulativeCount()-previous)
+					bounds = append(bounds, bucket.GetUpperBound())
+					previous = bucket.GetCumulativeCount()
+				}
+				counts = append(counts, histogram.GetSampleCount()-previous)
+				point.packedFixed64(6, counts)
+				point.packedDouble(7, bounds)
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				point.attributes(7, m.GetLabel())
+				point.fixed64(2, startNanos)
+				point.fixed64(3, timestamp)
+				point.fixed64(4, summary.GetSampleCount())
+				point.double(5, summary.GetSampleSum())
+				for _, quantile := range summary.GetQuantile() {
+					value := make(otlpWriter, 0, 18)
+					value.double(1, quantile.GetQuantile())
+					value.double(2, quantile.GetValue())
+					point.message(6, value)
+				}
+			default:
+				// untyped metrics are exported as gauges
+				point.attributes(7, m.GetLabel())
+				point.fixed64(3, timestamp)
+				point.double(4, m.GetUntyped().GetValue())
+			}
+			data.message(1, point)
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			data.varint(2, otlpCumulative)
+			// is_monotonic
+			data.varint(3, 1)
+			metric.message(7, data)
+		case dto.MetricType_HISTOGRAM:
+			data.varint(2, otlpCumulative)
+			metric.message(9, data)
+		case dto.MetricType_SUMMARY:
+			metric.message(11, data)
+		default:
+			metric.message(5, data)
+		}
+		scopeMetrics.message(2, metric)
+	}
+	resourceMetrics := make(otlpWriter, 0, len(scopeMetrics)+len(resource)+16)
+	resourceMetrics.message(1, resource)
+	resourceMetrics.message(2, scopeMetrics)
+	request := make(otlpWriter, 0, len(resourceMetrics)+8)
+	request.message(1, resourceMetrics)
+	return request
+}
+
+// otlpWriter appends the fields of a protobuf message
+type otlpWriter []byte
+
+func (w *otlpWriter) tag(field int, wireType int) {
+	w.uvarint(uint64(field<<3 | wireType))
+}
+
+func (w *otlpWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	*w = append(*w, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (w *otlpWriter) varint(field int, v uint64) {
+	w.tag(field, 0)
+	w.uvarint(v)
+}
+
+func (w *otlpWriter) fixed64(field int, v uint64) {
+	w.tag(field, 1)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	*w = append(*w, buf[:]...)
+}
+
+func (w *otlpWriter) double(field int, v float64) {
+	w.fixed64(field, math.Float64bits(v))
+}
+
+func (w *otlpWriter) bytes(field int, v []byte) {
+	w.tag(field, 2)
+	w.uvarint(uint64(len(v)))
+	*w = append(*w, v...)
+}
+
+func (w *otlpWriter) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	w.bytes(field, []byte(v))
+}
+
+func (w *otlpWriter) message(field int, v otlpWriter) {
+	w.bytes(field, v)
+}
+
+func (w *otlpWriter) packedFixed64(field int, vs []uint64) {
+	if len(vs) == 0 {
+		return
+	}
+	packed := make([]byte, 8*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint64(packed[8*i:], v)
+	}
+	w.bytes(field, packed)
+}
+
+func (w *otlpWriter) packedDouble(field int, vs []float64) {
+	bits := make([]uint64, len(vs))
+	for i, v := range vs {
+		bits[i] = math.Float64bits(v)
+	}
+	w.packedFixed64(field, bits)
+}
+
+// stringAttribute appends the KeyValue with the string AnyValue
+func (w *otlpWriter) stringAttribute(field int, key string, value string) {
+	anyValue := make(otlpWriter, 0, len(value)+2)
+	anyValue.bytes(1, []byte(value))
+	keyValue := make(otlpWriter, 0, len(key)+len(anyValue)+4)
+	keyValue.string(1, key)
+	keyValue.message(2, anyValue)
+	w.message(field, keyValue)
+}
+
+// attributes appends the labels sorted by name
+func (w *otlpWriter) attributes(field int, labels []*dto.LabelPair) {
+	sorted := make([]*dto.LabelPair, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+	for _, label := range sorted {
+		w.stringAttribute(field, label.GetName(), label.GetValue())
+	}
+}
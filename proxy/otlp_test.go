@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// protoFields decodes the fields of a protobuf message, the varint and fixed64 values are returned as 8 little endian bytes
+func protoFields(a *assert.Assertions, message []byte) map[int][][]byte {
+	result := make(map[int][][]byte)
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		a.True(n > 0)
+		message = message[n:]
+		var value []byte
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(message)
+			a.True(n > 0)
+			value = make([]byte, 8)
+			binary.LittleEndian.PutUint64(value, v)
+			message = message[n:]
+		case 1:
+			value, message = message[:8], message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			a.True(n > 0)
+			value, message = message[n:n+int(length)], message[n+int(length):]
+		default:
+			a.Fail("unexpected wire type")
+			return result
+		}
+		result[int(key>>3)] = append(result[int(key>>3)], value)
+	}
+	return result
+}
+
+func protoFixed64(value []byte) uint64 {
+	return binary.LittleEndian.Uint64(value)
+}
+
+func TestEncodeOTLPMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"broker"})
+	requests.WithLabelValues("kafka-1:9092").Add(3)
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Help: "Latency", Buckets: []float64{0.1, 1}})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+	registry.MustRegister(requests, latency)
+	families, err := registry.Gather()
+	a.Nil(err)
+
+	resource := make(otlpWriter, 0)
+	resource.stringAttribute(1, "service.name", "kafka-proxy")
+	start, now := time.Unix(100, 0), time.Unix(160, 0)
+	request := protoFields(a, encodeOTLPMetrics(resource, families, start, now))
+
+	resourceMetrics := protoFields(a, request[1][0])
+	attribute := protoFields(a, protoFields(a, resourceMetrics[1][0])[1][0])
+	a.Equal("service.name", string(attribute[1][0]))
+	a.Equal("kafka-proxy", string(protoFields(a, attribute[2][0])[1][0]))
+
+	scopeMetrics := protoFields(a, resourceMetrics[2][0])
+	a.Equal(otlpScopeName, string(protoFields(a, scopeMetrics[1][0])[1][0]))
+	a.Len(scopeMetrics[2], 2)
+
+	// histogram
+	metric := protoFields(a, scopeMetrics[2][0])
+	a.Equal("test_latency_seconds", string(metric[1][0]))
+	a.Equal("Latency", string(metric[2][0]))
+	histogram := protoFields(a, metric[9][0])
+	a.Equal(uint64(otlpCumulative), protoFixed64(histogram[2][0]))
+	point := protoFields(a, histogram[1][0])
+	a.Equal(uint64(start.UnixNano()), protoFixed64(point[2][0]))
+	a.Equal(uint64(now.UnixNano()), protoFixed64(point[3][0]))
+	a.Equal(uint64(3), protoFixed64(point[4][0]))
+	a.Equal(5.55, math.Float64frombits(protoFixed64(point[5][0])))
+	counts := point[6][0]
+	a.Equal([]uint64{1, 1, 1}, []uint64{protoFixed64(counts[0:]), protoFixed64(counts[8:]), protoFixed64(counts[16:])})
+	bounds := point[7][0]
+	a.Equal([]float64{0.1, 1}, []float64{math.Float64frombits(protoFixed64(bounds[0:])), math.Float64frombits(protoFixed64(bounds[8:]))})
+
+	// counter
+	metric = protoFields(a, scopeMetrics[2][1])
+	a.Equal("test_requests_total", string(metric[1][0]))
+	sum := protoFields(a, metric[7][0])
+	a.Equal(uint64(otlpCumulative), protoFixed64(sum[2][0]))
+	a.Equal(uint64(1), protoFixed64(sum[3][0]))
+	point = protoFields(a, sum[1][0])
+	a.Equal(3.0, math.Float64frombits(protoFixed64(point[4][0])))
+	attribute = protoFields(a, point[7][0])
+	a.Equal("broker", string(attribute[1][0]))
+	a.Equal("kafka-1:9092", string(protoFields(a, attribute[2][0])[1][0]))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	a := assert.New(t)
+
+	exported := make(chan []byte, 1)
+	headers := make(chan metadata.MD, 1)
+	server := grpc.NewServer(grpc.CustomCodec(otlpCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		a.Equal(otlpExportMethod, method)
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		headers <- md
+		var request []byte
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		exported <- request
+		return stream.SendMsg([]byte{})
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c := config.NewConfig()
+	exporter, err := NewOTLPExporter(c)
+	a.Nil(err)
+	a.Nil(exporter)
+
+	c.OTLP.Endpoint = listener.Addr().String()
+	c.OTLP.Insecure = true
+	c.OTLP.Headers = []string{"Authorization=Bearer my-token"}
+	exporter, err = NewOTLPExporter(c)
+	a.Nil(err)
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_connections", Help: "Connections"})
+	gauge.Set(2)
+	registry.MustRegister(gauge)
+	exporter.gatherer = registry
+
+	// the metrics are pushed when the exporter is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Nil(exporter.Run(ctx))
+
+	a.Equal([]string{"Bearer my-token"}, (<-headers)["authorization"])
+	request := protoFields(a, <-exported)
+	scopeMetrics := protoFields(a, protoFields(a, request[1][0])[2][0])
+	metric := protoFields(a, scopeMetrics[2][0])
+	a.Equal("test_connections", string(metric[1][0]))
+	point := protoFields(a, protoFields(a, metric[5][0])[1][0])
+	a.Equal(2.0, math.Float64frombits(protoFixed64(point[4][0])))
+}
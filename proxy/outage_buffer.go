@@ -0,0 +1,555 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outageBufferBuffered  = "buffered"
+	outageBufferFull      = "full"
+	outageBufferReplayed  = "replayed"
+	outageBufferDuplicate = "duplicate"
+	outageBufferRejected  = "rejected"
+	outageBufferRetried   = "retried"
+	outageBufferFailed    = "failed"
+)
+
+var errOutageBufferFull = errors.New("outage buffer is full")
+
+// outageBuffer absorbs the Produce requests while all brokers are unreachable, e.g. during a short network partition of an edge site.
+// The requests are acknowledged by the proxy and appended to a spool file of the broker, the files are replayed to the brokers
+// when they are reachable again. Replayed records can be duplicated, as the broker could have appended them before the outage
+// was detected or before a replay was interrupted.
+type outageBuffer struct {
+	dir           string
+	maxBytes      int64
+	window        time.Duration
+	retryInterval time.Duration
+	writeTimeout  time.Duration
+	readTimeout   time.Duration
+
+	mu sync.Mutex
+	// last dial result of the broker addresses
+	reachable map[string]bool
+	// start of the outage, zero while a broker is reachable
+	since time.Time
+	// requests which were not replayed yet
+	size   int64
+	spools map[string]*outageSpool
+	// last ApiVersions response of the brokers by the version, answered by the proxy during the outages
+	apiVersions map[int16][]byte
+}
+
+// outageSpool is the file of the buffered request frames of a broker, every frame is preceded by a byte which is 1
+// if the broker answers the request. The offset of the first request which was not replayed is kept in the offset file,
+// so the replay continues after a restart of the proxy.
+type outageSpool struct {
+	file       *os.File
+	offsetFile *os.File
+	// offsets of the first request which was not replayed and of the end of the file
+	offset int64
+	end    int64
+}
+
+func openOutageSpool(name string, flag int) (*outageSpool, error) {
+	file, err := os.OpenFile(name, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	offsetFile, err := os.OpenFile(name+".offset", flag|os.O_CREATE, 0600)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &outageSpool{file: file, offsetFile: offsetFile}, nil
+}
+
+// load reads the end of the spool and the replay offset, the requests are replayed from the start if the offset is not valid
+func (s *outageSpool) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.end = info.Size()
+	buf := make([]byte, 8)
+	if _, err = s.offsetFile.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if offset := int64(binary.BigEndian.Uint64(buf)); err == nil && offset >= 0 && offset <= s.end {
+		s.offset = offset
+	}
+	return nil
+}
+
+// advance moves the replay offset past the replayed request
+func (s *outageSpool) advance(size int64) error {
+	s.offset += size
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(s.offset))
+	_, err := s.offsetFile.WriteAt(buf, 0)
+	return err
+}
+
+// remove closes and removes the spool and offset files
+func (s *outageSpool) remove() error {
+	s.file.Close()
+	s.offsetFile.Close()
+	os.Remove(s.offsetFile.Name())
+	return os.Remove(s.file.Name())
+}
+
+// newOutageBuffer returns nil if the Produce requests are not buffered during the outages
+func newOutageBuffer(c *config.Config) (*outageBuffer, error) {
+	if c.OutageBuffer.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(c.OutageBuffer.Dir, 0700); err != nil {
+		return nil, err
+	}
+	b := &outageBuffer{
+		dir:           c.OutageBuffer.Dir,
+		maxBytes:      c.OutageBuffer.MaxBytes,
+		window:        c.OutageBuffer.Window,
+		retryInterval: c.OutageBuffer.RetryInterval,
+		writeTimeout:  c.Kafka.WriteTimeout,
+		readTimeout:   c.Kafka.ReadTimeout,
+		reachable:     make(map[string]bool),
+		spools:        make(map[string]*outageSpool),
+		apiVersions:   make(map[int16][]byte),
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Produce requests will be buffered in %s for %v when all brokers are unreachable", b.dir, b.window)
+	return b, nil
+}
+
+// load opens the spool files which were not replayed before the proxy was stopped
+func (b *outageBuffer) load() error {
+	names, err := filepath.Glob(filepath.Join(b.dir, "*.spool"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		address, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(name), ".spool"))
+		if err != nil {
+			logrus.Warnf("Spool file %s is not named by a broker address, it is not replayed", name)
+			continue
+		}
+		spool, err := openOutageSpool(name, os.O_RDWR)
+		if err != nil {
+			return err
+		}
+		if err = spool.load(); err != nil {
+			spool.file.Close()
+			spool.offsetFile.Close()
+			return err
+		}
+		b.spools[string(address)] = spool
+		b.size += spool.end - spool.offset
+		logrus.Warnf("Produce requests of %d bytes buffered for %s are replayed when it is reachable", spool.end-spool.offset, address)
+	}
+	proxyOutageBufferBytes.Set(float64(b.size))
+	return nil
+}
+
+// observeDial records the result of a connection to the broker. The outage starts when the last reachable broker failed.
+func (b *outageBuffer) observeDial(brokerAddress string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reachable[brokerAddress] = err == nil
+	if err == nil {
+		if !b.since.IsZero() {
+			logrus.Infof("Broker %s is reachable again after %v, produce requests are no longer buffered", brokerAddress, time.Since(b.since))
+			b.since = time.Time{}
+		}
+		return
+	}
+	if !b.since.IsZero() {
+		return
+	}
+	for _, reachable := range b.reachable {
+		if reachable {
+			return
+		}
+	}
+	b.since = time.Now()
+	logrus.Warnf("All brokers are unreachable, produce requests are buffered by the proxy for %v", b.window)
+}
+
+// deadline returns the end of the buffering window, false if no outage is buffered
+func (b *outageBuffer) deadline() (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.since.IsZero() {
+		return time.Time{}, false
+	}
+	end := b.since.Add(b.window)
+	return end, time.Now().Before(end)
+}
+
+// inspectsResponse reports whether the response body must be read to be answered by the proxy during the outages
+func (b *outageBuffer) inspectsResponse(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return b != nil && requestKeyVersion.ApiKey == apiKeyApiApiVersions
+}
+
+// observeApiVersions caches the ApiVersions response as sent to the clients
+func (b *outageBuffer) observeApiVersions(apiVersion int16, resp []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.apiVersions[apiVersion] = append([]byte(nil), resp...)
+}
+
+func (b *outageBuffer) apiVersionsResponse(apiVersion int16) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.apiVersions[apiVersion]
+}
+
+// serve answers the client connection to the unreachable broker by the proxy and returns true, false if no outage is buffered.
+// ApiVersions requests are answered with the cached responses and Produce requests are buffered, the other requests close the connection.
+func (b *outageBuffer) serve(ctx context.Context, conn net.Conn, brokerAddress string) bool {
+	if b == nil {
+		return false
+	}
+	deadline, ok := b.deadline()
+	if !ok {
+		return false
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	defer conn.Close()
+
+	// the clients reconnect to the brokers when the window ends
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return true
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		if err := b.serveRequest(reader, conn, brokerAddress); err != nil {
+			if err != io.EOF {
+				logrus.Infof("Connection to unreachable %s is closed: %v", brokerAddress, err)
+			}
+			return true
+		}
+	}
+}
+
+func (b *outageBuffer) serveRequest(src io.Reader, dst net.Conn, brokerAddress string) error {
+	keyVersionBuf := make([]byte, 8) // Size => int32 + ApiKey => int16 + ApiVersion => int16
+	if _, err := io.ReadFull(src, keyVersionBuf); err != nil {
+		return err
+	}
+	requestKeyVersion := &protocol.RequestKeyVersion{}
+	if err := protocol.Decode(keyVersionBuf, requestKeyVersion); err != nil {
+		return err
+	}
+	// CorrelationId => int32 + ClientId length => int16
+	if requestKeyVersion.Length < 4+6 || requestKeyVersion.Length > protocol.MaxRequestSize {
+		return protocol.PacketDecodingError{Info: fmt.Sprintf("request of length %d is invalid", requestKeyVersion.Length)}
+	}
+	frame := make([]byte, 4+requestKeyVersion.Length)
+	copy(frame, keyVersionBuf)
+	if _, err := io.ReadFull(src, frame[8:]); err != nil {
+		return err
+	}
+	headerBuf := frame[8:]
+	clientIDLength := int(int16(binary.BigEndian.Uint16(headerBuf[4:])))
+	if clientIDLength < 0 {
+		clientIDLength = 0
+	}
+	if 6+clientIDLength > len(headerBuf) {
+		return protocol.PacketDecodingError{Info: fmt.Sprintf("client id of length %d exceeds the request", clientIDLength)}
+	}
+	body := headerBuf[6+clientIDLength:]
+
+	switch requestKeyVersion.ApiKey {
+	case apiKeyApiApiVersions:
+		resp := b.apiVersionsResponse(requestKeyVersion.ApiVersion)
+		if resp == nil {
+			return fmt.Errorf("no ApiVersions response of version %d is cached", requestKeyVersion.ApiVersion)
+		}
+		return b.writeResponse(dst, headerBuf, resp)
+	case apiKeyProduce:
+		if _, ok := b.deadline(); !ok {
+			return errors.New("outage is no longer buffered")
+		}
+		resp, ok, err := protocol.AcknowledgeProduce(requestKeyVersion.ApiVersion, body)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("transactional produce requests are not buffered")
+		}
+		if err = b.append(brokerAddress, frame, resp != nil); err != nil {
+			if err == errOutageBufferFull {
+				proxyOutageBufferRequestsTotal.WithLabelValues(brokerAddress, outageBufferFull).Inc()
+			}
+			return err
+		}
+		proxyOutageBufferRequestsTotal.WithLabelValues(brokerAddress, outageBufferBuffered).Inc()
+		if resp == nil {
+			// acks 0
+			return nil
+		}
+		return b.writeResponse(dst, headerBuf, resp)
+	default:
+		return fmt.Errorf("api key %d is not answered during the outage", requestKeyVersion.ApiKey)
+	}
+}
+
+func (b *outageBuffer) writeResponse(dst net.Conn, headerBuf []byte, resp []byte) error {
+	// Size, CorrelationId of the request and the body
+	frame := make([]byte, 8, 8+len(resp))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(resp)))
+	copy(frame[4:], headerBuf[:4])
+	if err := dst.SetWriteDeadline(time.Now().Add(b.writeTimeout)); err != nil {
+		return err
+	}
+	_, err := dst.Write(append(frame, resp...))
+	return err
+}
+
+// append writes the request frame to the spool file of the broker
+func (b *outageBuffer) append(brokerAddress string, frame []byte, answered bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size := int64(1 + len(frame))
+	if b.size+size > b.maxBytes {
+		return errOutageBufferFull
+	}
+	spool, ok := b.spools[brokerAddress]
+	if !ok {
+		var err error
+		if spool, err = openOutageSpool(b.spoolPath(brokerAddress), os.O_RDWR|os.O_CREATE|os.O_TRUNC); err != nil {
+			return err
+		}
+		b.spools[brokerAddress] = spool
+	}
+	flag := byte(0)
+	if answered {
+		flag = 1
+	}
+	if _, err := spool.file.WriteAt(append([]byte{flag}, frame...), spool.end); err != nil {
+		return err
+	}
+	// the request is acknowledged to the client when it is on the disk
+	if err := spool.file.Sync(); err != nil {
+		return err
+	}
+	spool.end += size
+	b.size += size
+	proxyOutageBufferBytes.Set(float64(b.size))
+	return nil
+}
+
+// spoolPath names the spool by the hex encoded broker address, the colon of host:port is not valid in the file names on Windows
+func (b *outageBuffer) spoolPath(brokerAddress string) string {
+	return filepath.Join(b.dir, hex.EncodeToString([]byte(brokerAddress))+".spool")
+}
+
+// run replays the spool files to the brokers which are reachable again until done is closed
+func (b *outageBuffer) run(done <-chan struct{}, dial func(address string) (net.Conn, error)) {
+	ticker := time.NewTicker(b.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, address := range b.spooledAddresses() {
+				conn, err := dial(address)
+				b.observeDial(address, err)
+				if err != nil {
+					logrus.Debugf("Buffered produce requests cannot be replayed to %s: %v", address, err)
+					continue
+				}
+				if err = b.replay(address, conn); err != nil {
+					logrus.Warnf("Replay of the buffered produce requests to %s was interrupted, the last request can be duplicated by the next replay: %v", address, err)
+				}
+				conn.Close()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (b *outageBuffer) spooledAddresses() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]string, 0, len(b.spools))
+	for address := range b.spools {
+		result = append(result, address)
+	}
+	return result
+}
+
+// replay writes the buffered requests to the broker one by one and removes the spool file when all of them were replayed.
+// It stops at a request answered with a retriable error, the next replay starts with it.
+func (b *outageBuffer) replay(brokerAddress string, conn net.Conn) error {
+	logrus.Warnf("Replaying the produce requests buffered for %s, records which reached the broker before the outage can be duplicated", brokerAddress)
+	replayed := 0
+	for {
+		frame, answered, err := b.next(brokerAddress)
+		if err != nil {
+			return err
+		}
+		if frame == nil {
+			logrus.Infof("%d buffered produce requests were replayed to %s", replayed, brokerAddress)
+			return nil
+		}
+		result, err := b.replayRequest(conn, brokerAddress, frame, answered)
+		if err != nil {
+			return err
+		}
+		proxyOutageBufferRequestsTotal.WithLabelValues(brokerAddress, result).Inc()
+		if result == outageBufferRetried {
+			// the request stays first in the spool, the order of the requests is kept
+			return nil
+		}
+		if err = b.advance(brokerAddress, int64(1+len(frame))); err != nil {
+			return err
+		}
+		replayed++
+	}
+}
+
+// next returns the first request of the spool which was not replayed, nil if all were replayed and the spool was removed
+func (b *outageBuffer) next(brokerAddress string) ([]byte, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	spool, ok := b.spools[brokerAddress]
+	if !ok {
+		return nil, false, nil
+	}
+	if spool.offset >= spool.end {
+		delete(b.spools, brokerAddress)
+		return nil, false, spool.remove()
+	}
+	// flag, Size
+	header := make([]byte, 5)
+	if _, err := spool.file.ReadAt(header, spool.offset); err != nil {
+		return nil, false, b.discard(brokerAddress, spool, err)
+	}
+	length := int32(binary.BigEndian.Uint32(header[1:]))
+	if length < 4 || spool.offset+5+int64(length) > spool.end {
+		return nil, false, b.discard(brokerAddress, spool, fmt.Errorf("invalid request length %d", length))
+	}
+	frame := make([]byte, 4+length)
+	if _, err := spool.file.ReadAt(frame, spool.offset+1); err != nil {
+		return nil, false, b.discard(brokerAddress, spool, err)
+	}
+	return frame, header[0] == 1, nil
+}
+
+// discard removes the corrupted spool, its requests are lost
+func (b *outageBuffer) discard(brokerAddress string, spool *outageSpool, cause error) error {
+	delete(b.spools, brokerAddress)
+	b.size -= spool.end - spool.offset
+	proxyOutageBufferBytes.Set(float64(b.size))
+	proxyOutageBufferRequestsTotal.WithLabelValues(brokerAddress, outageBufferFailed).Inc()
+	spool.remove()
+	return fmt.Errorf("spool %s is corrupted, its remaining requests are lost: %v", spool.file.Name(), cause)
+}
+
+// advance persists the replay offset of the broker, the replayed request is not replayed again after a restart
+func (b *outageBuffer) advance(brokerAddress string, size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	spool, ok := b.spools[brokerAddress]
+	if !ok {
+		return nil
+	}
+	b.size -= size
+	proxyOutageBufferBytes.Set(float64(b.size))
+	return spool.advance(size)
+}
+
+// replayRequest writes the request frame and returns the result of the broker response
+func (b *outageBuffer) replayRequest(conn net.Conn, brokerAddress string, frame []byte, answered bool) (string, error) {
+	if err := conn.SetWriteDeadline(time.Now().Add(b.writeTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(frame); err != nil {
+		return "", err
+	}
+	if !answered {
+		return outageBufferReplayed, nil
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(b.readTimeout)); err != nil {
+		return "", err
+	}
+	header := make([]byte, 8) // Size => int32, CorrelationId => int32
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	length := int32(binary.BigEndian.Uint32(header))
+	if length < 4 || length > protocol.MaxResponseSize {
+		return "", fmt.Errorf("invalid produce response length %d", length)
+	}
+	resp := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return "", err
+	}
+	apiVersion := int16(binary.BigEndian.Uint16(frame[6:]))
+	kerrs, err := protocol.DecodeResponseErrors(apiKeyProduce, apiVersion, resp)
+	if err != nil {
+		// the request was answered, it is not replayed again
+		logrus.Infof("Response of the replayed produce request to %s cannot be decoded: %v", brokerAddress, err)
+		return outageBufferReplayed, nil
+	}
+	for _, kerr := range kerrs {
+		if isRetriableReplayError(kerr) {
+			logrus.Warnf("Replayed produce request was answered by %s with %v, it stays in the spool and is replayed again", brokerAddress, kerrs)
+			return outageBufferRetried, nil
+		}
+	}
+	for _, kerr := range kerrs {
+		if kerr == protocol.ErrDuplicateSequenceNumber {
+			logrus.Warnf("Replayed produce request was a duplicate of records already appended by %s", brokerAddress)
+			return outageBufferDuplicate, nil
+		}
+	}
+	if len(kerrs) != 0 {
+		logrus.Warnf("Replayed produce request was rejected by %s, its records are lost: %v", brokerAddress, kerrs)
+		return outageBufferRejected, nil
+	}
+	return outageBufferReplayed, nil
+}
+
+// isRetriableReplayError reports whether the broker can append the records of the replayed request later, e.g. when the partition
+// leader moved to another broker during the outage and is moved back by the preferred leader election
+func isRetriableReplayError(kerr protocol.KError) bool {
+	switch kerr {
+	case protocol.ErrLeaderNotAvailable, protocol.ErrNotLeaderForPartition, protocol.ErrRequestTimedOut, protocol.ErrNotEnoughReplicas,
+		protocol.ErrNotEnoughReplicasAfterAppend, protocol.ErrKafkaStorageError:
+		return true
+	default:
+		return false
+	}
+}
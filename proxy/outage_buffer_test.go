@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestOutageBuffer(a *assert.Assertions, dir string, maxBytes int64) *outageBuffer {
+	c := config.NewConfig()
+	c.OutageBuffer.Dir = dir
+	c.OutageBuffer.MaxBytes = maxBytes
+	c.OutageBuffer.Window = time.Minute
+	c.OutageBuffer.RetryInterval = 10 * time.Millisecond
+	b, err := newOutageBuffer(c)
+	a.Nil(err)
+	return b
+}
+
+func TestOutageBuffer(t *testing.T) {
+	a := assert.New(t)
+
+	b, err := newOutageBuffer(config.NewConfig())
+	a.Nil(err)
+	a.Nil(b)
+	b.observeDial("broker-1:9092", errors.New("refused"))
+	a.False(b.serve(nil, nil, "broker-1:9092"))
+
+	dir, err := ioutil.TempDir("", "outage")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	b = newTestOutageBuffer(a, dir, 100)
+
+	// the outage starts when the last reachable broker failed
+	b.observeDial("broker-1:9092", nil)
+	b.observeDial("broker-2:9092", errors.New("refused"))
+	_, ok := b.deadline()
+	a.False(ok)
+	b.observeDial("broker-1:9092", errors.New("refused"))
+	deadline, ok := b.deadline()
+	a.True(ok)
+	a.True(deadline.After(time.Now()))
+	b.observeDial("broker-2:9092", nil)
+	_, ok = b.deadline()
+	a.False(ok)
+
+	a.Nil(b.append("broker-1:9092", make([]byte, 50), true))
+	a.Equal(errOutageBufferFull, b.append("broker-1:9092", make([]byte, 50), true))
+	// spool and replay offset files named by the hex encoded address
+	files, err := ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 2)
+	a.Equal(hex.EncodeToString([]byte("broker-1:9092"))+".spool", files[0].Name())
+	a.Equal(hex.EncodeToString([]byte("broker-1:9092"))+".spool.offset", files[1].Name())
+
+	// the spool is replayed after a restart
+	b = newTestOutageBuffer(a, dir, 100)
+	a.Equal([]string{"broker-1:9092"}, b.spooledAddresses())
+	a.Equal(int64(51), b.size)
+}
+
+func TestOutageBufferReplay(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	dir, err := ioutil.TempDir("", "outage")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	b := newTestOutageBuffer(a, dir, 1<<20)
+
+	body := kafkatest.ProduceRequestBody("test", [][]byte{[]byte("v1")})
+	a.Nil(b.append(broker.Addr(), kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", body), true))
+	a.Nil(b.append(broker.Addr(), kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "test", body), true))
+
+	before := counterOf(a, proxyOutageBufferRequestsTotal, broker.Addr(), outageBufferReplayed)
+	conn, err := net.Dial("tcp", broker.Addr())
+	a.Nil(err)
+	defer conn.Close()
+	a.Nil(b.replay(broker.Addr(), conn))
+	a.Equal(2, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(before+2, counterOf(a, proxyOutageBufferRequestsTotal, broker.Addr(), outageBufferReplayed))
+	a.Equal(int64(0), b.size)
+	a.Len(b.spooledAddresses(), 0)
+	files, err := ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 0)
+}
+
+func TestOutageBufferReplayKeepsRetriableRequests(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}, ProduceErrorCode: int16(protocol.ErrNotLeaderForPartition)})
+	a.Nil(err)
+	brokerAddress := broker.Addr()
+
+	dir, err := ioutil.TempDir("", "outage")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	b := newTestOutageBuffer(a, dir, 1<<20)
+
+	body := kafkatest.ProduceRequestBody("test", [][]byte{[]byte("v1")})
+	first := kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", body)
+	second := kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "test", body)
+	a.Nil(b.append(brokerAddress, first, true))
+	a.Nil(b.append(brokerAddress, second, true))
+
+	// the partition leader moved during the outage, the requests stay in the spool
+	retried := counterOf(a, proxyOutageBufferRequestsTotal, brokerAddress, outageBufferRetried)
+	conn, err := net.Dial("tcp", brokerAddress)
+	a.Nil(err)
+	a.Nil(b.replay(brokerAddress, conn))
+	conn.Close()
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(retried+1, counterOf(a, proxyOutageBufferRequestsTotal, brokerAddress, outageBufferRetried))
+	a.Equal(int64(2+len(first)+len(second)), b.size)
+	a.Equal([]string{brokerAddress}, b.spooledAddresses())
+	broker.Close()
+
+	// both requests are replayed in order when the broker leads the partition again
+	broker, err = kafkatest.NewBroker(kafkatest.Config{NodeID: 1, ListenAddress: brokerAddress, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+	replayed := counterOf(a, proxyOutageBufferRequestsTotal, brokerAddress, outageBufferReplayed)
+	conn, err = net.Dial("tcp", brokerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	a.Nil(b.replay(brokerAddress, conn))
+	a.Equal(2, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(replayed+2, counterOf(a, proxyOutageBufferRequestsTotal, brokerAddress, outageBufferReplayed))
+	a.Equal(int64(0), b.size)
+	a.Len(b.spooledAddresses(), 0)
+}
+
+func TestOutageBufferReplayContinuesAfterRestart(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	dir, err := ioutil.TempDir("", "outage")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	b := newTestOutageBuffer(a, dir, 1<<20)
+
+	body := kafkatest.ProduceRequestBody("test", [][]byte{[]byte("v1")})
+	first := kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", body)
+	second := kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "test", body)
+	a.Nil(b.append(broker.Addr(), first, true))
+	a.Nil(b.append(broker.Addr(), second, true))
+	// the first request was replayed before the proxy was stopped
+	a.Nil(b.advance(broker.Addr(), int64(1+len(first))))
+
+	b = newTestOutageBuffer(a, dir, 1<<20)
+	a.Equal(int64(1+len(second)), b.size)
+	conn, err := net.Dial("tcp", broker.Addr())
+	a.Nil(err)
+	defer conn.Close()
+	a.Nil(b.replay(broker.Addr(), conn))
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+	files, err := ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 0)
+}
+
+func TestProxyWithOutageBuffer(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	brokerAddress := broker.Addr()
+
+	dir, err := ioutil.TempDir("", "outage")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	c := newTestProxyConfig(brokerAddress)
+	c.OutageBuffer.Dir = dir
+	c.OutageBuffer.MaxBytes = 1 << 20
+	c.OutageBuffer.Window = time.Minute
+	c.OutageBuffer.RetryInterval = 10 * time.Millisecond
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	// the ApiVersions response is cached while the broker is reachable
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 0, 1, "test", nil))
+	a.Nil(err)
+	_, expected, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	conn.Close()
+	broker.Close()
+
+	conn, err = net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 0, 2, "test", nil))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(2), correlationID)
+	a.Equal(expected, body)
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 3, "test", kafkatest.ProduceRequestBody("test", [][]byte{[]byte("v1")})))
+	a.Nil(err)
+	correlationID, body, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(3), correlationID)
+	errorCode, err := kafkatest.DecodeProduceErrorCode(body)
+	a.Nil(err)
+	a.Equal(int16(0), errorCode)
+
+	// the buffered request is replayed when the broker is back
+	broker, err = kafkatest.NewBroker(kafkatest.Config{NodeID: 1, ListenAddress: brokerAddress, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+	a.True(waitForBrokerRequests(broker, kafkatest.ApiKeyProduce, 1))
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+const (
+	parseErrorRequest  = "request"
+	parseErrorResponse = "response"
+)
+
+// parseErrorPolicy handles the requests and responses which cannot be parsed by the modifiers rewriting them. The connection is closed,
+// the message is forwarded unmodified or it is rejected with an error response of the api key. The rejection falls back to closing
+// the connection if the response of the api key has no top level error code known to the proxy.
+type parseErrorPolicy struct {
+	action   string
+	byApiKey map[int16]string
+}
+
+// newParseErrorPolicy returns nil if the connections are closed on all parse errors
+func newParseErrorPolicy(c *config.Config) *parseErrorPolicy {
+	if c.ParseErrors.Action == config.ParseErrorActionClose && len(c.ParseErrors.ApiKeyActions) == 0 {
+		return nil
+	}
+	p := &parseErrorPolicy{
+		action:   c.ParseErrors.Action,
+		byApiKey: make(map[int16]string),
+	}
+	for _, v := range c.ParseErrors.ApiKeyActions {
+		// validated by the config
+		if apiKey, action, err := config.ParseParseErrorApiKeyAction(v); err == nil {
+			p.byApiKey[apiKey] = action
+		}
+	}
+	return p
+}
+
+// actionOf returns the configured action of the api key
+func (p *parseErrorPolicy) actionOf(apiKey int16) string {
+	if p == nil {
+		return config.ParseErrorActionClose
+	}
+	if action, ok := p.byApiKey[apiKey]; ok {
+		return action
+	}
+	return p.action
+}
+
+// request returns the action for the request which cannot be parsed and the response body if the request is rejected
+func (p *parseErrorPolicy) request(brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, err error) (string, []byte) {
+	return p.handle(parseErrorRequest, brokerAddress, requestKeyVersion, protocol.ErrInvalidRequest, err)
+}
+
+// response returns the action for the response which cannot be parsed and the response body replacing it if the response is rejected
+func (p *parseErrorPolicy) response(brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, err error) (string, []byte) {
+	return p.handle(parseErrorResponse, brokerAddress, requestKeyVersion, protocol.ErrUnknown, err)
+}
+
+func (p *parseErrorPolicy) handle(direction string, brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, kerr protocol.KError, err error) (string, []byte) {
+	action := p.actionOf(requestKeyVersion.ApiKey)
+	var resp []byte
+	if action == config.ParseErrorActionReject {
+		var ok bool
+		if resp, ok = protocol.EncodeErrorResponse(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, kerr); !ok {
+			action = config.ParseErrorActionClose
+		}
+	}
+	proxyParseErrorsTotal.WithLabelValues(direction, strconv.Itoa(int(requestKeyVersion.ApiKey)), action).Inc()
+	logrus.WithFields(logrus.Fields{
+		"broker":  brokerAddress,
+		"apiKey":  requestKeyVersion.ApiKey,
+		"version": requestKeyVersion.ApiVersion,
+		"action":  action,
+	}).Debugf("Kafka %s cannot be parsed: %v", direction, err)
+	return action, resp
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	p := newParseErrorPolicy(c)
+	a.Nil(p)
+	findCoordinator := &protocol.RequestKeyVersion{ApiKey: 10, ApiVersion: 1}
+	metadata := &protocol.RequestKeyVersion{ApiKey: apiKeyMetadata, ApiVersion: 1}
+	action, _ := p.request("broker:9092", metadata, errors.New("test"))
+	a.Equal(config.ParseErrorActionClose, action)
+
+	c.ParseErrors.Action = config.ParseErrorActionForward
+	c.ParseErrors.ApiKeyActions = []string{"10=reject", "3=reject"}
+	p = newParseErrorPolicy(c)
+	a.Equal(config.ParseErrorActionForward, p.actionOf(apiKeyProduce))
+
+	before := counterOf(a, proxyParseErrorsTotal, parseErrorRequest, "10", config.ParseErrorActionReject)
+	action, resp := p.request("broker:9092", findCoordinator, errors.New("test"))
+	a.Equal(config.ParseErrorActionReject, action)
+	errs, err := protocol.DecodeResponseErrors(10, 1, resp)
+	a.Nil(err)
+	a.Equal([]protocol.KError{protocol.ErrInvalidRequest}, errs)
+	a.Equal(before+1, counterOf(a, proxyParseErrorsTotal, parseErrorRequest, "10", config.ParseErrorActionReject))
+
+	action, resp = p.response("broker:9092", findCoordinator, errors.New("test"))
+	a.Equal(config.ParseErrorActionReject, action)
+	errs, err = protocol.DecodeResponseErrors(10, 1, resp)
+	a.Nil(err)
+	a.Equal([]protocol.KError{protocol.ErrUnknown}, errs)
+
+	// the metadata response has no top level error code
+	action, resp = p.response("broker:9092", metadata, errors.New("test"))
+	a.Equal(config.ParseErrorActionClose, action)
+	a.Nil(resp)
+}
+
+func TestAnswerRequest(t *testing.T) {
+	a := assert.New(t)
+
+	inFlight := &inFlightRequests{}
+	// the answered request and a previous one
+	inFlight.add(2)
+	ctx := &RequestsLoopContext{
+		nextRequestHandlerChannel: make(chan RequestHandler, 1),
+		timeout:                   time.Second,
+		inFlight:                  inFlight,
+		done:                      make(chan struct{}),
+	}
+	client, proxy := net.Pipe()
+	defer client.Close()
+	defer proxy.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctx.answerRequest(proxy, []byte{0x00, 0x00, 0x00, 0x07, 0x00, 0x00}, []byte{0x00, 0x2a})
+		result <- err
+	}()
+	// written after the response of the previous request
+	time.Sleep(50 * time.Millisecond)
+	a.Equal(int32(2), inFlight.count())
+	inFlight.add(-1)
+	// Size, CorrelationId and the body
+	frame := make([]byte, 10)
+	_, err := io.ReadFull(client, frame)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x07, 0x00, 0x2a}, frame)
+	a.Nil(<-result)
+	a.Equal(int32(0), inFlight.count())
+	a.Equal(defaultRequestHandler, <-ctx.nextRequestHandlerChannel)
+}
@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const pollerMaxEvents = 128
+
+var (
+	sharedPollerOnce sync.Once
+	sharedPollerInst *poller
+	sharedPollerErr  error
+)
+
+// poller waits for the readiness of the parked connections with epoll. A single goroutine waits for the events of all connections,
+// the registrations are one-shot: a connection is removed when it becomes readable or is closed.
+type poller struct {
+	fd int
+
+	lock    sync.Mutex
+	waiting map[int]func()
+}
+
+// sharedPoller returns the poller of the process, it is never closed
+func sharedPoller() (*poller, error) {
+	sharedPollerOnce.Do(func() {
+		fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			sharedPollerErr = err
+			return
+		}
+		sharedPollerInst = &poller{fd: fd, waiting: make(map[int]func())}
+		go withRecover(sharedPollerInst.run)
+	})
+	return sharedPollerInst, sharedPollerErr
+}
+
+// wait calls ready once when the connection is readable or the peer closed it
+func (p *poller) wait(raw syscall.RawConn, ready func()) error {
+	var err error
+	if cerr := raw.Control(func(s uintptr) {
+		fd := int(s)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+		if err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, event); err == syscall.EEXIST {
+			err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, event)
+		}
+		if err == nil {
+			p.waiting[fd] = ready
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// cancel removes the connection before it is closed, the file descriptor can be reused afterwards
+func (p *poller) cancel(raw syscall.RawConn) {
+	_ = raw.Control(func(s uintptr) {
+		fd := int(s)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if _, ok := p.waiting[fd]; ok {
+			delete(p.waiting, fd)
+			_ = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+		}
+	})
+}
+
+func (p *poller) run() {
+	events := make([]syscall.EpollEvent, pollerMaxEvents)
+	for {
+		n, err := syscall.EpollWait(p.fd, events, -1)
+		if err != nil {
+			if err != syscall.EINTR {
+				logrus.Errorf("Waiting for the readiness of the parked connections failed: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			p.lock.Lock()
+			ready, ok := p.waiting[fd]
+			if ok {
+				delete(p.waiting, fd)
+				_ = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+			}
+			p.lock.Unlock()
+			// an event of a closed descriptor reused by another connection resumes its loop, which parks again
+			if ok {
+				ready()
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package proxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// poller waits for the readiness of the parked connections, it is supported on Linux only
+type poller struct{}
+
+func sharedPoller() (*poller, error) {
+	return nil, errors.New("the event loop connection engine is supported on Linux only")
+}
+
+func (p *poller) wait(raw syscall.RawConn, ready func()) error {
+	return errors.New("the event loop connection engine is supported on Linux only")
+}
+
+func (p *poller) cancel(raw syscall.RawConn) {
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// priority classes in the order they are scheduled
+const (
+	priorityHigh int32 = iota
+	priorityNormal
+	priorityLow
+	priorityClasses
+)
+
+var priorityLabels = [priorityClasses]string{config.PriorityHigh, config.PriorityNormal, config.PriorityLow}
+
+func priorityOf(class string) int32 {
+	switch class {
+	case config.PriorityHigh:
+		return priorityHigh
+	case config.PriorityLow:
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
+
+// priorityRule tags the connections with the matching attribute with the class
+type priorityRule struct {
+	class     int32
+	attribute string
+	pattern   *regexp.Regexp
+}
+
+// scheduler bounds the requests and responses copied at once by all connections. When all slots are taken, a freed slot is handed
+// to the waiting connection with the highest priority class, so latency-sensitive clients are not queued behind bulk traffic
+// e.g. of the mirroring consumers on the same proxy. The connections of the same class are served in their order.
+type scheduler struct {
+	rules []*priorityRule
+	max   int
+
+	lock    sync.Mutex
+	running int
+	waiting [priorityClasses][]chan struct{}
+}
+
+// newScheduler returns nil if the requests and responses are not scheduled
+func newScheduler(c *config.Config) (*scheduler, error) {
+	if c.Priority.MaxConcurrency <= 0 {
+		return nil, nil
+	}
+	s := &scheduler{max: c.Priority.MaxConcurrency}
+	for _, v := range c.Priority.Classes {
+		class, attribute, pattern, err := config.ParsePriorityClass(v)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, &priorityRule{class: priorityOf(class), attribute: attribute, pattern: pattern})
+	}
+	logrus.Infof("Requests and responses of %d connections at once are scheduled by the priority classes %v", s.max, c.Priority.Classes)
+	return s, nil
+}
+
+// match returns the class of the first rule matching the connection
+func (s *scheduler) match(listener string, principal string, clientID string) int32 {
+	for _, rule := range s.rules {
+		var value string
+		switch rule.attribute {
+		case config.PriorityAttributeListener:
+			value = listener
+		case config.PriorityAttributePrincipal:
+			value = principal
+		case config.PriorityAttributeClientID:
+			value = clientID
+		}
+		// the principal of an unauthenticated connection does not match
+		if rule.attribute == config.PriorityAttributePrincipal && value == "" {
+			continue
+		}
+		if rule.pattern.MatchString(value) {
+			return rule.class
+		}
+	}
+	return priorityNormal
+}
+
+// acquire waits for a slot and returns whether the connection had to wait
+func (s *scheduler) acquire(class int32, done <-chan struct{}) (bool, error) {
+	s.lock.Lock()
+	if s.running < s.max {
+		s.running++
+		s.lock.Unlock()
+		return false, nil
+	}
+	granted := make(chan struct{})
+	s.waiting[class] = append(s.waiting[class], granted)
+	s.lock.Unlock()
+
+	label := priorityLabels[class]
+	proxySchedulerWaitsTotal.WithLabelValues(label).Inc()
+	start := time.Now()
+	defer func() {
+		proxySchedulerWaitSecondsTotal.WithLabelValues(label).Add(time.Since(start).Seconds())
+	}()
+	select {
+	case <-granted:
+		return true, nil
+	case <-done:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, ch := range s.waiting[class] {
+			if ch == granted {
+				s.waiting[class] = append(s.waiting[class][:i], s.waiting[class][i+1:]...)
+				return true, errProcessorStopped
+			}
+		}
+		// the slot was handed over meanwhile
+		s.releaseLocked()
+		return true, errProcessorStopped
+	}
+}
+
+func (s *scheduler) release() {
+	s.lock.Lock()
+	s.releaseLocked()
+	s.lock.Unlock()
+}
+
+// releaseLocked hands the slot over to the first waiting connection of the highest class
+func (s *scheduler) releaseLocked() {
+	for class := range s.waiting {
+		if len(s.waiting[class]) != 0 {
+			granted := s.waiting[class][0]
+			s.waiting[class][0] = nil
+			s.waiting[class] = s.waiting[class][1:]
+			close(granted)
+			return
+		}
+	}
+	s.running--
+}
+
+func (s *scheduler) newSession(listener string) *prioritySession {
+	if s == nil {
+		return nil
+	}
+	session := &prioritySession{scheduler: s, listener: listener, class: priorityNormal}
+	session.update("", "")
+	return session
+}
+
+// prioritySession is the class of a connection. The class is selected by the requests loop and used by both loops.
+type prioritySession struct {
+	scheduler *scheduler
+	listener  string
+	class     int32
+}
+
+// update selects the class when the principal or the client id of the connection is changed
+func (s *prioritySession) update(principal string, clientID string) {
+	if s == nil {
+		return
+	}
+	class := s.scheduler.match(s.listener, principal, clientID)
+	if atomic.SwapInt32(&s.class, class) != class {
+		logrus.Debugf("Connection on %s with principal %q and client id %q has priority %s", s.listener, principal, clientID, priorityLabels[class])
+	}
+}
+
+func noRelease() {}
+
+// acquire waits for a slot to copy a request or response and returns whether the connection had to wait.
+// The returned func releases the slot, it can be called more than once.
+func (s *prioritySession) acquire(done <-chan struct{}) (func(), bool, error) {
+	if s == nil {
+		return noRelease, false, nil
+	}
+	waited, err := s.scheduler.acquire(atomic.LoadInt32(&s.class), done)
+	if err != nil {
+		return noRelease, waited, err
+	}
+	released := false
+	return func() {
+		if !released {
+			released = true
+			s.scheduler.release()
+		}
+	}, waited, nil
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(a *assert.Assertions, maxConcurrency int, classes ...string) *scheduler {
+	c := config.NewConfig()
+	c.Priority.MaxConcurrency = maxConcurrency
+	c.Priority.Classes = classes
+	s, err := newScheduler(c)
+	a.Nil(err)
+	return s
+}
+
+func waitForSchedulerWaiting(s *scheduler, class int32, count int) bool {
+	for i := 0; i < 100; i++ {
+		s.lock.Lock()
+		n := len(s.waiting[class])
+		s.lock.Unlock()
+		if n == count {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSchedulerMatch(t *testing.T) {
+	a := assert.New(t)
+
+	s, err := newScheduler(config.NewConfig())
+	a.Nil(err)
+	a.Nil(s)
+
+	s = newTestScheduler(a, 1, "low:client-id=^mirror-maker", "high:principal=^payments-", "high:listener=:32501$")
+	a.Equal(priorityNormal, s.match("127.0.0.1:32500", "", "consumer-1"))
+	a.Equal(priorityLow, s.match("127.0.0.1:32500", "", "mirror-maker-1"))
+	a.Equal(priorityHigh, s.match("127.0.0.1:32500", "payments-api", "consumer-1"))
+	a.Equal(priorityHigh, s.match("127.0.0.1:32501", "", ""))
+	// the first matching rule is used
+	a.Equal(priorityLow, s.match("127.0.0.1:32501", "payments-api", "mirror-maker-1"))
+
+	session := s.newSession("127.0.0.1:32500")
+	a.Equal(priorityNormal, session.class)
+	session.update("payments-api", "consumer-1")
+	a.Equal(priorityHigh, session.class)
+	session.update("", "mirror-maker-1")
+	a.Equal(priorityLow, session.class)
+}
+
+func TestSchedulerHighPriorityFirst(t *testing.T) {
+	a := assert.New(t)
+
+	s := newTestScheduler(a, 1)
+	done := make(chan struct{})
+	waited, err := s.acquire(priorityNormal, done)
+	a.Nil(err)
+	a.False(waited)
+
+	granted := make(chan int32, 3)
+	for _, class := range []int32{priorityLow, priorityNormal, priorityHigh} {
+		class := class
+		go func() {
+			waited, err := s.acquire(class, done)
+			a.Nil(err)
+			a.True(waited)
+			granted <- class
+		}()
+		// queued in the order low, normal, high
+		a.True(waitForSchedulerWaiting(s, class, 1))
+	}
+
+	for _, expected := range []int32{priorityHigh, priorityNormal, priorityLow} {
+		s.release()
+		a.Equal(expected, <-granted)
+	}
+	s.release()
+	a.Equal(0, s.running)
+}
+
+func TestSchedulerDone(t *testing.T) {
+	a := assert.New(t)
+
+	s := newTestScheduler(a, 1)
+	session := s.newSession("127.0.0.1:32500")
+	release, _, err := session.acquire(nil)
+	a.Nil(err)
+
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := session.acquire(done)
+		result <- err
+	}()
+	a.True(waitForSchedulerWaiting(s, priorityNormal, 1))
+	close(done)
+	a.Equal(errProcessorStopped, <-result)
+	a.Len(s.waiting[priorityNormal], 0)
+
+	// released once
+	release()
+	release()
+	a.Equal(0, s.running)
+
+	// nil safe
+	var disabled *prioritySession
+	disabled.update("principal", "client")
+	release, waited, err := disabled.acquire(nil)
+	a.Nil(err)
+	a.False(waited)
+	release()
+}
@@ -1,9 +1,12 @@
 package proxy
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"io"
 	"time"
 )
 
@@ -15,15 +18,24 @@ const (
 	defaultWriteTimeout       = 30 * time.Second
 	defaultReadTimeout        = 30 * time.Second
 	minOpenRequests           = 16
+	// wait between the checks whether the responses of the broker were written before a response of the proxy
+	localResponseInFlightPollInterval = 5 * time.Millisecond
 
-	apiKeySaslHandshake  = int16(17)
-	apiKeyApiApiVersions = int16(18)
+	apiKeyProduce            = int16(0)
+	apiKeyFetch              = int16(1)
+	apiKeyMetadata           = int16(3)
+	apiKeyControlledShutdown = int16(7)
+	apiKeySaslHandshake      = int16(17)
+	apiKeyApiApiVersions     = int16(18)
+	apiKeySaslAuthenticate   = int16(36)
 
 	minRequestApiKey = int16(0)   // 0 - Produce
 	maxRequestApiKey = int16(100) // so far 42 is the last (reserve some for the feature)
 )
 
 var (
+	errProcessorStopped = errors.New("proxy is stopped")
+
 	defaultRequestHandler     = &DefaultRequestHandler{}
 	defaultResponseHandler    = &DefaultResponseHandler{}
 	saslAuthV0RequestHandler  = &SaslAuthV0RequestHandler{}
@@ -32,6 +44,7 @@ var (
 
 type ProcessorConfig struct {
 	MaxOpenRequests       int
+	MaxInFlightRequests   int
 	NetAddressMappingFunc config.NetAddressMappingFunc
 	RequestBufferSize     int
 	ResponseBufferSize    int
@@ -40,6 +53,73 @@ type ProcessorConfig struct {
 	LocalSasl             *LocalSasl
 	AuthServer            *AuthServer
 	ForbiddenApiKeys      map[int16]struct{}
+	ForbiddenApiVersions  forbiddenApiVersions
+	Rewriter              *rewriter
+	SchemaValidator       *schemaValidator
+	RecordTransform       *recordTransform
+	CompressionPolicy     *compressionPolicy
+	Recompression         *recompression
+	RecordStats           *recordStats
+	TopicMetrics          *topicMetrics
+	TransactionPolicy     *transactionPolicy
+	FaultInjector         *FaultInjector
+	Maintenance           *Maintenance
+	Capture               *capture
+	Egress                *egressShaper
+	Mirror                *mirror
+	AckSpoofing           *ackSpoofing
+	OutageBuffer          *outageBuffer
+	ClientIDPolicy        *ClientIDPolicy
+	// authorizes the requests by OPA, nil if they are not authorized
+	OPA *opaAuthorizer
+	// drains the connections on shutdown, nil if they are closed at once
+	Shutdown *gracefulShutdown
+	// counts the error codes of the decoded responses, nil if they are not counted
+	BrokerErrors *brokerErrors
+	// logs the requests slower than the threshold, nil if the requests are not timed
+	SlowRequests *slowRequests
+	// reports the connections with most traffic, nil if they are not reported
+	TopTalkers *TopTalkers
+	// traffic of the connection counted for the top talkers, set per connection
+	Talker *talker
+	// counts the client software of the ApiVersions requests, nil if it is not counted
+	ClientSoftware *clientSoftware
+	// client software of the connection, set per connection
+	Fingerprint *clientFingerprint
+	// evaluates the policies without enforcing them, nil if all policies are enforced
+	DryRun *policyDryRun
+	// computes the SLIs of the requests, nil if they are not computed
+	SLO *SLO
+	// SLIs of the connection, set per connection
+	SLOSession *sloSession
+	// logs the correlation ids of the sampled requests, nil if they are not logged
+	CorrelationLog *correlationLog
+	// correlation ids of the connection, set per connection
+	Correlations *correlationLogSession
+	// answers the repeated Metadata requests, nil if the responses are not cached
+	MetadataCache *metadataCache
+	// pending Metadata requests of the connection, set per connection
+	MetadataCacheSession *metadataCacheSession
+	// answers the ApiVersions requests of the new connections, nil if the responses are not cached
+	ApiVersionsCache *apiVersionsCache
+	// cluster of the connection, set per connection
+	ApiVersionsCacheSession *apiVersionsCacheSession
+	// strips or terminates the client telemetry requests, nil if they are forwarded
+	Telemetry *clientTelemetry
+	// handles the requests and responses which cannot be parsed for the rewriting, nil if the connection is closed
+	ParseErrors *parseErrorPolicy
+	// answers the rejected requests with an error response, nil if the connection is closed without a response
+	Rejection *rejectionPolicy
+	// principal of the Unix socket peer, set per connection
+	PeerPrincipal string
+	// schedules the requests and responses of all connections by their priority class, nil if they are not scheduled
+	Scheduler *scheduler
+	// priority class of the connection, set per connection
+	Priority *prioritySession
+	// reads the big responses into memory-mapped files, nil if the responses are read into the heap
+	ResponseSpill *responseSpill
+	// parks the loops of the idle connections, nil if the loops run in their goroutines
+	EventLoop *eventLoop
 }
 
 type processor struct {
@@ -56,12 +136,51 @@ type processor struct {
 	localSasl  *LocalSasl
 	authServer *AuthServer
 
-	forbiddenApiKeys map[int16]struct{}
+	forbiddenApiKeys  map[int16]struct{}
+	apiVersionFilter  protocol.ApiVersionFilterFunc
+	rewriter          *rewriter
+	schemaValidator   *schemaValidator
+	recordTransform   *recordTransform
+	compressionPolicy *compressionPolicy
+	recompression     *recompression
+	recordStats       *recordStats
+	topicMetrics      *topicMetrics
+	transactionPolicy *transactionPolicy
+	opa               *opaAuthorizer
+	faultInjector     *FaultInjector
+	maintenance       *Maintenance
+	capture           *captureSession
+	egress            *egressSession
+	mirror            *mirror
+	ackSpoofing       *ackSpoofing
+	outageBuffer      *outageBuffer
+	clientIDPolicy    *ClientIDPolicy
+	brokerErrors      *brokerErrors
+	slowRequests      *slowRequestSession
+	correlations      *correlationLogSession
+	metadataCache     *metadataCacheSession
+	apiVersionsCache  *apiVersionsCacheSession
+	slo               *sloSession
+	dryRun            *policyDryRun
+	talker            *talker
+	fingerprint       *clientFingerprint
+	telemetry         *clientTelemetry
+	parseErrors       *parseErrorPolicy
+	rejection         *rejectionPolicy
+	tracker           *correlationTracker
+	inFlightLimit     *inFlightLimit
+	priority          *prioritySession
+	spill             *responseSpill
+	peerPrincipal     string
+	// nil if the in-flight requests are not counted
+	inFlight *inFlightRequests
 	// metrics
 	brokerAddress string
+	// closed when the proxy is stopped
+	done <-chan struct{}
 }
 
-func newProcessor(cfg ProcessorConfig, brokerAddress string) *processor {
+func newProcessor(ctx context.Context, cfg ProcessorConfig, brokerAddress string) *processor {
 	maxOpenRequests := cfg.MaxOpenRequests
 	if maxOpenRequests < minOpenRequests {
 		maxOpenRequests = minOpenRequests
@@ -102,14 +221,60 @@ func newProcessor(cfg ProcessorConfig, brokerAddress string) *processor {
 		localSasl:                  cfg.LocalSasl,
 		authServer:                 cfg.AuthServer,
 		forbiddenApiKeys:           cfg.ForbiddenApiKeys,
+		peerPrincipal:              cfg.PeerPrincipal,
+		apiVersionFilter:           newApiVersionFilter(cfg.ForbiddenApiVersions, cfg.Rewriter, cfg.SchemaValidator, cfg.RecordTransform, cfg.CompressionPolicy, cfg.TransactionPolicy, cfg.OPA, cfg.Telemetry),
+		rewriter:                   cfg.Rewriter,
+		schemaValidator:            cfg.SchemaValidator,
+		recordTransform:            cfg.RecordTransform,
+		compressionPolicy:          cfg.CompressionPolicy,
+		recompression:              cfg.Recompression,
+		recordStats:                cfg.RecordStats,
+		topicMetrics:               cfg.TopicMetrics,
+		transactionPolicy:          cfg.TransactionPolicy,
+		opa:                        cfg.OPA,
+		faultInjector:              cfg.FaultInjector,
+		maintenance:                cfg.Maintenance,
+		capture:                    cfg.Capture.newSession(brokerAddress),
+		egress:                     cfg.Egress.newSession(),
+		mirror:                     cfg.Mirror,
+		ackSpoofing:                cfg.AckSpoofing,
+		outageBuffer:               cfg.OutageBuffer,
+		clientIDPolicy:             cfg.ClientIDPolicy,
+		brokerErrors:               cfg.BrokerErrors,
+		slowRequests:               cfg.SlowRequests.newSession(brokerAddress),
+		correlations:               cfg.Correlations,
+		metadataCache:              cfg.MetadataCacheSession,
+		apiVersionsCache:           cfg.ApiVersionsCacheSession,
+		slo:                        cfg.SLOSession,
+		dryRun:                     cfg.DryRun,
+		talker:                     cfg.Talker,
+		fingerprint:                cfg.Fingerprint,
+		telemetry:                  cfg.Telemetry,
+		parseErrors:                cfg.ParseErrors,
+		rejection:                  cfg.Rejection,
+		tracker:                    newCorrelationTracker(brokerAddress),
+		inFlightLimit:              newInFlightLimit(cfg.MaxInFlightRequests, brokerAddress),
+		priority:                   cfg.Priority,
+		spill:                      cfg.ResponseSpill,
+		done:                       ctx.Done(),
 	}
 }
 
 func (p *processor) RequestsLoop(dst DeadlineWriter, src DeadlineReaderWriter) (readErr bool, err error) {
+	ctx, err := p.newRequestsLoopContext(dst, src)
+	if err != nil {
+		return true, err
+	}
+	defer ctx.close()
+	// the size, header and body of a small request are read at once
+	return ctx.requestsLoop(dst, newBufferedConn(src, p.requestBufferSize))
+}
 
+// newRequestsLoopContext authenticates the gateway client and returns the context of the requests loop, it is closed by close
+func (p *processor) newRequestsLoopContext(dst DeadlineWriter, src DeadlineReaderWriter) (*RequestsLoopContext, error) {
 	if p.authServer.enabled {
-		if err = p.authServer.receiveAndSendGatewayAuth(src); err != nil {
-			return true, err
+		if err := p.authServer.receiveAndSendGatewayAuth(src); err != nil {
+			return nil, err
 		}
 	}
 	src.SetDeadline(time.Time{})
@@ -121,12 +286,68 @@ func (p *processor) RequestsLoop(dst DeadlineWriter, src DeadlineReaderWriter) (
 		timeout:                    p.writeTimeout,
 		brokerAddress:              p.brokerAddress,
 		forbiddenApiKeys:           p.forbiddenApiKeys,
+		apiVersionFilter:           p.apiVersionFilter,
+		rewriter:                   p.rewriter,
+		schemaValidator:            p.schemaValidator,
+		recordTransform:            p.recordTransform,
+		compressionPolicy:          p.compressionPolicy,
+		recompression:              p.recompression,
+		recordStats:                p.recordStats,
+		topicMetrics:               p.topicMetrics,
+		transactionPolicy:          p.transactionPolicy,
+		opa:                        p.opa,
+		faultInjector:              p.faultInjector,
+		maintenance:                p.maintenance,
+		capture:                    p.capture,
+		egress:                     p.egress,
+		mirror:                     p.mirror,
+		ackSpoofing:                p.ackSpoofing,
+		acks:                       p.ackSpoofing.newForwarder(dst, p.brokerAddress, p.writeTimeout),
+		slowRequests:               p.slowRequests,
+		correlations:               p.correlations,
+		metadataCache:              p.metadataCache,
+		apiVersionsCache:           p.apiVersionsCache,
+		slo:                        p.slo,
+		dryRun:                     p.dryRun,
+		talker:                     p.talker,
+		fingerprint:                p.fingerprint,
+		telemetry:                  p.telemetry,
+		parseErrors:                p.parseErrors,
+		rejection:                  p.rejection,
+		tracker:                    p.tracker,
+		inFlightLimit:              p.inFlightLimit,
+		priority:                   p.priority,
 		buf:                        make([]byte, p.requestBufferSize),
 		localSasl:                  p.localSasl,
 		localSaslDone:              false, // sequential processing - mutex is required
+		clientIDPolicy:             p.clientIDPolicy,
+		headerBuf:                  make([]byte, 6, 64),
+		inFlight:                   p.inFlight,
+		done:                       p.done,
+	}
+	if p.peerPrincipal != "" {
+		// the Unix socket peer is authenticated by its credentials, the local SASL authentication is skipped
+		if ctx.localSasl.revocations.rejects(p.peerPrincipal, "") {
+			ctx.close()
+			return nil, fmt.Errorf("principal %s is revoked", p.peerPrincipal)
+		}
+		ctx.principal = p.peerPrincipal
+		ctx.localSaslDone = true
+		if closer, ok := src.(io.Closer); ok {
+			ctx.revocable = ctx.localSasl.revocations.register(ctx.principal, "", closer)
+		}
+		ctx.egress.update(ctx.principal, ctx.clientID)
+		ctx.talker.update(ctx.principal, ctx.clientID)
+		ctx.priority.update(ctx.principal, ctx.clientID)
 	}
+	return ctx, nil
+}
 
-	return ctx.requestsLoop(dst, src)
+// close releases the state of the connection when the requests loop is finished
+func (ctx *RequestsLoopContext) close() {
+	ctx.acks.close()
+	ctx.session.close()
+	ctx.localSasl.revocations.unregister(ctx.revocable)
 }
 
 type RequestsLoopContext struct {
@@ -134,13 +355,67 @@ type RequestsLoopContext struct {
 	nextRequestHandlerChannel  chan RequestHandler
 	nextResponseHandlerChannel chan<- ResponseHandler
 
-	timeout          time.Duration
-	brokerAddress    string
-	forbiddenApiKeys map[int16]struct{}
-	buf              []byte // bufSize
+	timeout           time.Duration
+	brokerAddress     string
+	forbiddenApiKeys  map[int16]struct{}
+	apiVersionFilter  protocol.ApiVersionFilterFunc
+	rewriter          *rewriter
+	schemaValidator   *schemaValidator
+	recordTransform   *recordTransform
+	compressionPolicy *compressionPolicy
+	recompression     *recompression
+	recordStats       *recordStats
+	topicMetrics      *topicMetrics
+	transactionPolicy *transactionPolicy
+	opa               *opaAuthorizer
+	faultInjector     *FaultInjector
+	maintenance       *Maintenance
+	capture           *captureSession
+	egress            *egressSession
+	mirror            *mirror
+	ackSpoofing       *ackSpoofing
+	acks              *ackForwarder // nil if the requests are acknowledged by the broker
+	slowRequests      *slowRequestSession
+	correlations      *correlationLogSession
+	metadataCache     *metadataCacheSession
+	apiVersionsCache  *apiVersionsCacheSession
+	slo               *sloSession
+	dryRun            *policyDryRun
+	talker            *talker
+	fingerprint       *clientFingerprint
+	telemetry         *clientTelemetry
+	parseErrors       *parseErrorPolicy
+	rejection         *rejectionPolicy
+	tracker           *correlationTracker
+	inFlightLimit     *inFlightLimit
+	priority          *prioritySession
+	buf               []byte // bufSize
 
 	localSasl     *LocalSasl
 	localSaslDone bool
+	// principal authenticated by the local SASL, empty if the client is not authenticated
+	principal string
+	// limits of the session attributes of the principal, nil if the session is not limited
+	session *sessionLimits
+	// session limits exceeded by the authenticated principal, the next request is rejected
+	sessionErr error
+	// connection closed when the principal or the token is revoked, nil if the revocations are disabled
+	revocable *revocableConn
+
+	// client id of the last request
+	clientIDPolicy   *ClientIDPolicy
+	clientID         string
+	clientIDSet      bool
+	clientIDDecision clientIDDecision
+	headerBuf        []byte
+
+	inFlight *inFlightRequests
+	done     <-chan struct{}
+
+	// parks the loop while the client is idle, nil if the loop is not parked
+	idle *eventLoop
+	// handler of the next request when the parked loop is resumed
+	parkedHandler RequestHandler
 }
 
 // used by local authentication
@@ -172,6 +447,8 @@ func (ctx *RequestsLoopContext) putNextHandlers(nextRequestHandler RequestHandle
 		case ctx.nextResponseHandlerChannel <- nextResponseHandler:
 		case <-timer.C:
 			return errors.New("next response handler channel is full")
+		case <-ctx.done:
+			return errProcessorStopped
 		}
 	}
 	return nil
@@ -190,11 +467,19 @@ type RequestHandler interface {
 	handleRequest(dst DeadlineWriter, src DeadlineReaderWriter, ctx *RequestsLoopContext) (readErr bool, err error)
 }
 
+// requestsLoop returns errIdle when the loop is parked, it is resumed with the same context and source
 func (r *RequestsLoopContext) requestsLoop(dst DeadlineWriter, src DeadlineReaderWriter) (readErr bool, err error) {
+	dst = r.acks.writer(dst)
 	var nextRequestHandler RequestHandler
 	for {
-		if nextRequestHandler, err = r.getNextRequestHandler(); err != nil {
-			return false, nil
+		if nextRequestHandler, r.parkedHandler = r.parkedHandler, nil; nextRequestHandler == nil {
+			if nextRequestHandler, err = r.getNextRequestHandler(); err != nil {
+				return false, nil
+			}
+		}
+		if nextRequestHandler == defaultRequestHandler && r.idle.parks(src) {
+			r.parkedHandler = nextRequestHandler
+			return false, errIdle
 		}
 		if readErr, err = nextRequestHandler.handleRequest(dst, src, r); err != nil {
 			return readErr, err
@@ -203,35 +488,101 @@ func (r *RequestsLoopContext) requestsLoop(dst DeadlineWriter, src DeadlineReade
 }
 
 func (p *processor) ResponsesLoop(dst DeadlineWriter, src DeadlineReader) (readErr bool, err error) {
-	ctx := &ResponsesLoopContext{
+	ctx := p.newResponsesLoopContext()
+	// the request waiting for the response of the broker is not blocked when the responses are not processed
+	defer p.inFlightLimit.close()
+	// the size, correlation id and body of a small response are read at once
+	return ctx.responsesLoop(dst, newBufferedReader(src, p.responseBufferSize))
+}
+
+func (p *processor) newResponsesLoopContext() *ResponsesLoopContext {
+	return &ResponsesLoopContext{
 		openRequestsChannel:        p.openRequestsChannel,
 		nextResponseHandlerChannel: p.nextResponseHandlerChannel,
 		netAddressMappingFunc:      p.netAddressMappingFunc,
+		apiVersionFilter:           p.apiVersionFilter,
+		rewriter:                   p.rewriter,
+		recordTransform:            p.recordTransform,
+		recompression:              p.recompression,
+		recordStats:                p.recordStats,
+		topicMetrics:               p.topicMetrics,
+		outageBuffer:               p.outageBuffer,
+		faultInjector:              p.faultInjector,
+		capture:                    p.capture,
+		egress:                     p.egress,
+		brokerErrors:               p.brokerErrors,
+		slowRequests:               p.slowRequests,
+		correlations:               p.correlations,
+		metadataCache:              p.metadataCache,
+		apiVersionsCache:           p.apiVersionsCache,
+		slo:                        p.slo,
+		talker:                     p.talker,
+		parseErrors:                p.parseErrors,
+		tracker:                    p.tracker,
+		inFlightLimit:              p.inFlightLimit,
+		priority:                   p.priority,
+		spill:                      p.spill,
 		timeout:                    p.readTimeout,
 		brokerAddress:              p.brokerAddress,
 		buf:                        make([]byte, p.responseBufferSize),
+		inFlight:                   p.inFlight,
+		done:                       p.done,
 	}
-	return ctx.responsesLoop(dst, src)
 }
 
 type ResponsesLoopContext struct {
 	openRequestsChannel        <-chan protocol.RequestKeyVersion
 	nextResponseHandlerChannel <-chan ResponseHandler
 	netAddressMappingFunc      config.NetAddressMappingFunc
+	apiVersionFilter           protocol.ApiVersionFilterFunc
+	rewriter                   *rewriter
+	recordTransform            *recordTransform
+	recompression              *recompression
+	recordStats                *recordStats
+	topicMetrics               *topicMetrics
+	outageBuffer               *outageBuffer
+	faultInjector              *FaultInjector
+	capture                    *captureSession
+	egress                     *egressSession
+	brokerErrors               *brokerErrors
+	slowRequests               *slowRequestSession
+	correlations               *correlationLogSession
+	metadataCache              *metadataCacheSession
+	apiVersionsCache           *apiVersionsCacheSession
+	slo                        *sloSession
+	talker                     *talker
+	parseErrors                *parseErrorPolicy
+	tracker                    *correlationTracker
+	inFlightLimit              *inFlightLimit
+	priority                   *prioritySession
+	spill                      *responseSpill
 	timeout                    time.Duration
 	brokerAddress              string
 	buf                        []byte // bufSize
+	inFlight                   *inFlightRequests
+	done                       <-chan struct{}
+	// parks the loop while the broker is idle, nil if the loop is not parked
+	idle *eventLoop
+	// handler of the next response when the parked loop is resumed
+	parkedHandler ResponseHandler
 }
 
 type ResponseHandler interface {
 	handleResponse(dst DeadlineWriter, src DeadlineReader, ctx *ResponsesLoopContext) (readErr bool, err error)
 }
 
+// responsesLoop returns errIdle when the loop is parked, it is resumed with the same context and source
 func (r *ResponsesLoopContext) responsesLoop(dst DeadlineWriter, src DeadlineReader) (readErr bool, err error) {
 	var nextResponseHandler ResponseHandler
 	for {
-		if nextResponseHandler, err = r.getNextResponseHandler(); err != nil {
-			return false, err
+		if nextResponseHandler, r.parkedHandler = r.parkedHandler, nil; nextResponseHandler == nil {
+			if nextResponseHandler, err = r.getNextResponseHandler(); err != nil {
+				return false, err
+			}
+		}
+		if nextResponseHandler == defaultResponseHandler && r.idle.parks(src) {
+			r.parkedHandler = nextResponseHandler
+			return false, errIdle
 		}
 		if readErr, err = nextResponseHandler.handleResponse(dst, src, r); err != nil {
 			return readErr, err
@@ -252,6 +603,8 @@ func (r *ResponsesLoopContext) getNextResponseHandler() (ResponseHandler, error)
 			return handler, nil
 		case <-timer.C:
 			return nil, errors.New("next response handler is missing")
+		case <-r.done:
+			return nil, errProcessorStopped
 		}
 	}
 }
@@ -1,8 +1,10 @@
 package proxy
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"github.com/sirupsen/logrus"
 	"io"
@@ -41,32 +43,58 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 
 	proxyRequestsTotal.WithLabelValues(ctx.brokerAddress, strconv.Itoa(int(requestKeyVersion.ApiKey)), strconv.Itoa(int(requestKeyVersion.ApiVersion))).Inc()
 	proxyRequestsBytes.WithLabelValues(ctx.brokerAddress).Add(float64(requestKeyVersion.Length + 4))
+	ctx.talker.request(requestKeyVersion.Length + 4)
 
 	if _, ok := ctx.forbiddenApiKeys[requestKeyVersion.ApiKey]; ok {
-		return true, fmt.Errorf("api key %d is forbidden", requestKeyVersion.ApiKey)
+		if !ctx.dryRun.enabled(config.DryRunApiKeys) {
+			return ctx.rejectUnread(src, requestKeyVersion, config.RejectionACL, fmt.Errorf("api key %d is forbidden", requestKeyVersion.ApiKey))
+		}
+		ctx.dryRun.deny(config.DryRunApiKeys, fmt.Sprintf("api key %d of principal %q and client id %q to %s", requestKeyVersion.ApiKey, ctx.principal, ctx.clientID, ctx.brokerAddress))
+	}
+	if ctx.apiVersionFilter != nil && ctx.apiVersionFilter(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion) {
+		// answered in-band also with the rejection policy, the clients fall back to other versions
+		return ctx.answerForbiddenVersion(src, requestKeyVersion, fmt.Errorf("api key %d version %d is forbidden", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion))
 	}
 
 	if ctx.localSasl.enabled {
 		if ctx.localSaslDone {
-			if requestKeyVersion.ApiKey == apiKeySaslHandshake {
+			switch requestKeyVersion.ApiKey {
+			case apiKeySaslHandshake:
 				return false, errors.New("SASL Auth was already done")
+			case apiKeySaslAuthenticate:
+				// the credentials of the clients are never forwarded to the brokers
+				return ctx.rejectUnread(src, requestKeyVersion, config.RejectionAuth, errors.New("SASL re-authentication is not supported by the local authentication"))
 			}
 		} else {
 			switch requestKeyVersion.ApiKey {
 			case apiKeySaslHandshake:
+				var session localSaslSession
 				switch requestKeyVersion.ApiVersion {
 				case 0:
-					if err = ctx.localSasl.receiveAndSendSASLAuthV0(src, keyVersionBuf); err != nil {
+					if ctx.principal, session, err = ctx.localSasl.receiveAndSendSASLAuthV0(src, keyVersionBuf); err != nil {
 						return true, err
 					}
 				case 1:
-					if err = ctx.localSasl.receiveAndSendSASLAuthV1(src, keyVersionBuf); err != nil {
+					if ctx.principal, session, err = ctx.localSasl.receiveAndSendSASLAuthV1(src, keyVersionBuf); err != nil {
 						return true, err
 					}
 				default:
 					return true, fmt.Errorf("only saslHandshake version 0 and 1 are supported, got version %d", requestKeyVersion.ApiVersion)
 				}
+				if ctx.session, err = newSessionLimits(ctx.localSasl.connections, ctx.principal, session.sessionAttributes()); err != nil {
+					if ctx.rejection == nil {
+						return true, err
+					}
+					// the authentication was answered, the next request is rejected
+					ctx.sessionErr = err
+				}
+				if closer, ok := src.(io.Closer); ok {
+					ctx.revocable = ctx.localSasl.revocations.register(ctx.principal, session.tokenID(), closer)
+				}
 				ctx.localSaslDone = true
+				ctx.egress.update(ctx.principal, ctx.clientID)
+				ctx.talker.update(ctx.principal, ctx.clientID)
+				ctx.priority.update(ctx.principal, ctx.clientID)
 				src.SetDeadline(time.Time{})
 
 				// defaultRequestHandler was consumed but due to local handling enqueued defaultResponseHandler will not be.
@@ -74,15 +102,31 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 			case apiKeyApiApiVersions:
 				// continue processing
 			default:
-				return false, errors.New("SASL Auth is required. Only SaslHandshake or ApiVersions requests are allowed")
+				return ctx.rejectUnread(src, requestKeyVersion, config.RejectionAuth, errors.New("SASL Auth is required. Only SaslHandshake or ApiVersions requests are allowed"))
 			}
 		}
 	}
 
-	// send inFlightRequest to channel before myCopyN to prevent race condition in proxyResponses
-	if err = sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion); err != nil {
+	if ctx.sessionErr != nil {
+		return ctx.rejectUnread(src, requestKeyVersion, config.RejectionLimits, ctx.sessionErr)
+	}
+
+	if requestKeyVersion.ApiKey == apiKeySaslHandshake || requestKeyVersion.ApiKey == apiKeySaslAuthenticate {
+		// not handled by the local SASL, the Metadata responses are filtered by the ACLs of a principal unknown to the proxy
+		ctx.metadataCache.authenticatedByBroker()
+	}
+
+	if ctx.telemetry.terminates(requestKeyVersion) {
+		// answered by the proxy, the request is not sent to the broker
+		return ctx.handleTelemetryRequest(src, requestKeyVersion)
+	}
+
+	// the request is read from the client when a response of the previous requests was written
+	if err = ctx.inFlightLimit.acquire(ctx.done); err != nil {
 		return true, err
 	}
+	// in flight until its response is written, the request is sent to the responses loop when it is written to the broker
+	ctx.inFlight.add(1)
 
 	requestDeadline := time.Now().Add(ctx.timeout)
 	err = dst.SetWriteDeadline(requestDeadline)
@@ -94,14 +138,237 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 		return true, err
 	}
 
-	// write - send to broker
-	if _, err = dst.Write(keyVersionBuf); err != nil {
-		return false, err
+	headerBuf, err := ctx.readRequestHeader(src, requestKeyVersion)
+	if err != nil {
+		return true, err
+	}
+	if err = ctx.tracker.request(requestKeyVersion, headerBuf); err != nil {
+		return true, err
+	}
+	if err = ctx.checkClientID(); err != nil {
+		return ctx.reject(src, requestKeyVersion, headerBuf, nil, config.RejectionACL, err)
+	}
+	if ctx.opa.authorizes(requestKeyVersion.ApiKey) && !ctx.opa.inspects(requestKeyVersion) {
+		if err = ctx.opa.check(ctx.principal, ctx.clientID, requestKeyVersion, nil); err != nil {
+			return ctx.reject(src, requestKeyVersion, headerBuf, nil, config.RejectionACL, err)
+		}
 	}
-	// 4 bytes were written as keyVersionBuf (ApiKey, ApiVersion)
-	if readErr, err = myCopyN(dst, src, int64(requestKeyVersion.Length-4), ctx.buf); err != nil {
-		return readErr, err
+	// throttling and the other delays of the proxy are included
+	ctx.slowRequests.request(requestKeyVersion, headerBuf, ctx.principal, ctx.clientID)
+	ctx.correlations.request(requestKeyVersion, headerBuf, ctx.clientID)
+	ctx.slo.request(headerBuf)
+	throttleDryRun := ctx.dryRun.enabled(config.DryRunClientIDThrottle)
+	if delay := ctx.clientIDDecision.throttle.take(throttleDryRun); delay > 0 && throttleDryRun {
+		ctx.dryRun.throttle(config.DryRunClientIDThrottle, delay, fmt.Sprintf("client id %q", ctx.clientID))
+	} else if delay > 0 {
+		proxyClientIDThrottledTotal.WithLabelValues(ctx.clientIDDecision.label).Inc()
+		if err = waitOrDone(delay, ctx.done); err != nil {
+			return true, err
+		}
+		requestDeadline = time.Now().Add(ctx.timeout)
+		if err = dst.SetWriteDeadline(requestDeadline); err != nil {
+			return false, err
+		}
+		if err = src.SetReadDeadline(requestDeadline); err != nil {
+			return true, err
+		}
+	}
+	proxyClientIDRequestsTotal.WithLabelValues(ctx.clientIDDecision.label).Inc()
+	if delay := ctx.session.take(requestKeyVersion.Length + 4); delay > 0 {
+		if err = waitOrDone(delay, ctx.done); err != nil {
+			return true, err
+		}
+		requestDeadline = time.Now().Add(ctx.timeout)
+		if err = dst.SetWriteDeadline(requestDeadline); err != nil {
+			return false, err
+		}
+		if err = src.SetReadDeadline(requestDeadline); err != nil {
+			return true, err
+		}
+	}
+
+	if ctx.faultInjector.drops(requestKeyVersion) {
+		return true, fmt.Errorf("connection dropped by fault injection at api key %d", requestKeyVersion.ApiKey)
+	}
+	if delay := ctx.faultInjector.latency(requestKeyVersion); delay > 0 {
+		if err = waitOrDone(delay, ctx.done); err != nil {
+			return true, err
+		}
+		requestDeadline = time.Now().Add(ctx.timeout)
+		if err = dst.SetWriteDeadline(requestDeadline); err != nil {
+			return false, err
+		}
+		if err = src.SetReadDeadline(requestDeadline); err != nil {
+			return true, err
+		}
+	}
+
+	// the slot is released before the next handlers are queued, the responses loop may need one to make room
+	release, waited, err := ctx.priority.acquire(ctx.done)
+	if err != nil {
+		return true, err
+	}
+	defer release()
+	if waited {
+		requestDeadline = time.Now().Add(ctx.timeout)
+		if err = dst.SetWriteDeadline(requestDeadline); err != nil {
+			return false, err
+		}
+		if err = src.SetReadDeadline(requestDeadline); err != nil {
+			return true, err
+		}
+	}
+
+	requestModifier, err := ctx.getRequestModifier(requestKeyVersion)
+	if err != nil {
+		return true, err
+	}
+	captured := ctx.capture.samples(requestKeyVersion, headerBuf, ctx.clientID)
+	// 4 bytes were read as keyVersionBuf (ApiKey, ApiVersion)
+	bodyLength := int(requestKeyVersion.Length-4) - len(headerBuf)
+	if requestModifier != nil || ctx.schemaValidator.inspects(requestKeyVersion) || ctx.compressionPolicy.inspects(requestKeyVersion) ||
+		ctx.transactionPolicy.inspects(requestKeyVersion) || ctx.opa.inspects(requestKeyVersion) || ctx.maintenance.inspects(requestKeyVersion) || ctx.mirror.inspects(requestKeyVersion) || ctx.session.inspects(requestKeyVersion) || ctx.ackSpoofing.inspects(requestKeyVersion) ||
+		ctx.metadataCache.inspects(requestKeyVersion) || ctx.apiVersionsCache.inspects(requestKeyVersion) ||
+		ctx.recordStats.inspects(requestKeyVersion) || ctx.topicMetrics.inspects(requestKeyVersion) || ctx.fingerprint.inspects(requestKeyVersion) ||
+		(captured && ctx.capture.raw()) {
+		if requestKeyVersion.Length > protocol.MaxRequestSize {
+			return true, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", requestKeyVersion.Length)}
+		}
+		req := make([]byte, bodyLength)
+		if _, err = io.ReadFull(src, req); err != nil {
+			return true, err
+		}
+		if captured {
+			// as sent by the client
+			ctx.capture.request(requestKeyVersion, headerBuf, ctx.clientID, req)
+		}
+		if ctx.fingerprint.inspects(requestKeyVersion) {
+			// as sent by the client
+			ctx.fingerprint.observe(requestKeyVersion, req)
+		}
+		if ctx.opa.inspects(requestKeyVersion) {
+			// topic and group names as sent by the client
+			if err = ctx.opa.check(ctx.principal, ctx.clientID, requestKeyVersion, req); err != nil {
+				return ctx.reject(src, requestKeyVersion, headerBuf, req, config.RejectionACL, err)
+			}
+		}
+		if ctx.transactionPolicy.inspects(requestKeyVersion) {
+			if err = ctx.transactionPolicy.check(ctx.principal, requestKeyVersion, req); err != nil {
+				return ctx.reject(src, requestKeyVersion, headerBuf, req, config.RejectionACL, err)
+			}
+		}
+		if ctx.session.inspects(requestKeyVersion) {
+			// topic names as sent by the client
+			if err = ctx.session.check(requestKeyVersion, req); err != nil {
+				return ctx.reject(src, requestKeyVersion, headerBuf, req, config.RejectionACL, err)
+			}
+		}
+		if ctx.maintenance.inspects(requestKeyVersion) {
+			// topic names as sent by the client
+			if resp, rejected, err := ctx.maintenance.reject(requestKeyVersion, headerBuf, req); err != nil {
+				return true, err
+			} else if rejected {
+				// answered by the proxy, the request is not sent to the broker
+				release()
+				return ctx.answerRequest(src, headerBuf, resp)
+			}
+		}
+		if ctx.compressionPolicy.inspects(requestKeyVersion) {
+			// checked before the records are decompressed by the modifiers or the validation
+			if err = ctx.compressionPolicy.check(requestKeyVersion.ApiVersion, req); err != nil {
+				return ctx.reject(src, requestKeyVersion, headerBuf, req, config.RejectionPolicy, err)
+			}
+		}
+		if ctx.apiVersionsCache.inspects(requestKeyVersion) {
+			if resp := ctx.apiVersionsCache.lookup(requestKeyVersion, headerBuf); resp != nil {
+				// answered by the proxy, the request is not sent to the broker
+				release()
+				return ctx.answerRequest(src, headerBuf, resp)
+			}
+		}
+		if ctx.metadataCache.inspects(requestKeyVersion) {
+			// topic names as sent by the client
+			if resp := ctx.metadataCache.lookup(ctx.principal, requestKeyVersion, headerBuf, req); resp != nil {
+				// answered by the proxy, the request is not sent to the broker
+				release()
+				return ctx.answerRequest(src, headerBuf, resp)
+			}
+		}
+		if requestModifier != nil {
+			var modified []byte
+			if modified, err = requestModifier.Apply(req); err != nil {
+				switch action, resp := ctx.parseErrors.request(ctx.brokerAddress, requestKeyVersion, err); {
+				case action == config.ParseErrorActionForward:
+					// as sent by the client
+					modified = req
+				case action == config.ParseErrorActionReject && len(headerBuf) >= 4:
+					// answered by the proxy, the request is not sent to the broker
+					ctx.metadataCache.forget(int32(binary.BigEndian.Uint32(headerBuf)))
+					release()
+					return ctx.answerRequest(src, headerBuf, resp)
+				default:
+					return true, err
+				}
+			}
+			req = modified
+		}
+		if ctx.schemaValidator.inspects(requestKeyVersion) {
+			// topic names as seen by the brokers
+			if err = ctx.schemaValidator.validate(requestKeyVersion.ApiVersion, req); err != nil {
+				return ctx.reject(src, requestKeyVersion, headerBuf, req, config.RejectionPolicy, err)
+			}
+		}
+		if ctx.mirror.inspects(requestKeyVersion) {
+			// topic names as seen by the brokers
+			ctx.mirror.enqueue(requestKeyVersion.ApiVersion, req)
+		}
+		if ctx.recordStats.inspects(requestKeyVersion) {
+			// topic names and record batches as seen by the brokers
+			ctx.recordStats.observeRequest(requestKeyVersion, req)
+		}
+		if ctx.topicMetrics.inspects(requestKeyVersion) {
+			// topic names as seen by the brokers
+			ctx.topicMetrics.observeRequest(ctx.brokerAddress, requestKeyVersion, req)
+		}
+		if ctx.ackSpoofing.inspects(requestKeyVersion) && len(headerBuf) >= 4 {
+			// topic names as seen by the brokers
+			if forward, resp, ok := ctx.ackSpoofing.spoof(ctx.brokerAddress, requestKeyVersion.ApiVersion, req); ok {
+				// answered by the proxy, the broker does not answer the request with acks 0
+				binary.BigEndian.PutUint32(keyVersionBuf, uint32(4+len(headerBuf)+len(forward)))
+				frame := make([]byte, 0, len(keyVersionBuf)+len(headerBuf)+len(forward))
+				ctx.acks.enqueue(append(append(append(frame, keyVersionBuf...), headerBuf...), forward...))
+				release()
+				return ctx.answerRequest(src, headerBuf, resp)
+			}
+		}
+		// the acknowledged requests are written first
+		if err = ctx.acks.flush(ctx.done); err != nil {
+			return false, err
+		}
+		if err = ctx.openRequest(requestKeyVersion); err != nil {
+			return true, err
+		}
+		// ApiKey, ApiVersion, request header and the modified body
+		binary.BigEndian.PutUint32(keyVersionBuf, uint32(4+len(headerBuf)+len(req)))
+		if err = writeFrame(dst, keyVersionBuf, headerBuf, req); err != nil {
+			return false, err
+		}
+	} else {
+		if err = ctx.acks.flush(ctx.done); err != nil {
+			return false, err
+		}
+		if err = ctx.openRequest(requestKeyVersion); err != nil {
+			return true, err
+		}
+		if captured {
+			ctx.capture.request(requestKeyVersion, headerBuf, ctx.clientID, nil)
+		}
+		// write - send to broker
+		if readErr, err = copyFrame(dst, src, int64(bodyLength), ctx.buf, keyVersionBuf, headerBuf); err != nil {
+			return readErr, err
+		}
 	}
+	release()
 	if requestKeyVersion.ApiKey == apiKeySaslHandshake {
 		if requestKeyVersion.ApiVersion == 0 {
 			return false, ctx.putNextHandlers(saslAuthV0RequestHandler, saslAuthV0ResponseHandler)
@@ -128,13 +395,27 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 	}
 
 	// Read the inFlightRequests channel after header is read. Otherwise the channel would block and socket EOF from remote would not be received.
-	requestKeyVersion, err := receiveRequestKeyVersion(ctx.openRequestsChannel, openRequestReceiveTimeout)
+	requestKeyVersion, err := receiveRequestKeyVersion(ctx.openRequestsChannel, openRequestReceiveTimeout, ctx.done)
 	if err != nil {
 		return true, err
 	}
+	if err = ctx.tracker.response(responseHeader.CorrelationID); err != nil {
+		return true, err
+	}
 	proxyResponsesBytes.WithLabelValues(ctx.brokerAddress).Add(float64(responseHeader.Length + 4))
+	ctx.talker.response(responseHeader.Length + 4)
 	logrus.Debugf("Kafka response key %v, version %v, length %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, responseHeader.Length)
 
+	if delay := ctx.egress.take(responseHeader.Length + 4); delay > 0 {
+		if err = waitOrDone(delay, ctx.done); err != nil {
+			return true, err
+		}
+	}
+	release, _, err := ctx.priority.acquire(ctx.done)
+	if err != nil {
+		return true, err
+	}
+	defer release()
 	responseDeadline := time.Now().Add(ctx.timeout)
 	err = dst.SetWriteDeadline(responseDeadline)
 	if err != nil {
@@ -145,47 +426,202 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 		return true, err
 	}
 
-	responseModifier, err := protocol.GetResponseModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.netAddressMappingFunc)
+	responseModifier, err := ctx.getResponseModifier(requestKeyVersion)
 	if err != nil {
 		return true, err
 	}
-	if responseModifier != nil {
+	captured := ctx.capture.captured(responseHeader.CorrelationID)
+	if responseModifier != nil || ctx.recordStats.inspectsResponse(requestKeyVersion) || ctx.topicMetrics.inspectsResponse(requestKeyVersion) ||
+		ctx.outageBuffer.inspectsResponse(requestKeyVersion) || ctx.metadataCache.inspectsResponse(responseHeader.CorrelationID) ||
+		ctx.apiVersionsCache.inspectsResponse(requestKeyVersion) || (captured != nil && ctx.capture.raw()) {
 		if int32(responseHeader.Length) > protocol.MaxResponseSize {
 			return true, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", responseHeader.Length)}
 		}
-		resp := make([]byte, int(responseHeader.Length-4))
-		if _, err = io.ReadFull(src, resp); err != nil {
-			return true, err
+		var resp []byte
+		if size := int(responseHeader.Length - 4); ctx.spill.spills(size) {
+			var unmap func()
+			if resp, unmap, err = ctx.spill.read(src, size, ctx.brokerAddress); err != nil {
+				return true, err
+			}
+			// the modified response may refer to the mapped one until it is written
+			defer unmap()
+		} else {
+			resp = make([]byte, size)
+			if _, err = io.ReadFull(src, resp); err != nil {
+				return true, err
+			}
 		}
-		newResponseBuf, err := responseModifier.Apply(resp)
-		if err != nil {
-			return true, err
+		newResponseBuf := resp
+		cacheable := true
+		if ctx.recordStats.inspectsResponse(requestKeyVersion) {
+			// as returned by the broker
+			ctx.recordStats.observeResponse(requestKeyVersion, resp)
+		}
+		if ctx.topicMetrics.inspectsResponse(requestKeyVersion) {
+			ctx.topicMetrics.observeResponse(ctx.brokerAddress, requestKeyVersion, resp)
+		}
+		if responseModifier != nil {
+			// as returned by the broker
+			ctx.brokerErrors.observe(ctx.brokerAddress, requestKeyVersion, resp)
+			if newResponseBuf, err = responseModifier.Apply(resp); err != nil {
+				ctx.metadataCache.forget(responseHeader.CorrelationID)
+				// the response is not filtered as for the other clients
+				cacheable = false
+				switch action, errorResp := ctx.parseErrors.response(ctx.brokerAddress, requestKeyVersion, err); action {
+				case config.ParseErrorActionForward:
+					// as returned by the broker
+					newResponseBuf = resp
+				case config.ParseErrorActionReject:
+					newResponseBuf = errorResp
+				default:
+					return true, err
+				}
+			}
+		}
+		if ctx.metadataCache.inspectsResponse(responseHeader.CorrelationID) {
+			// as sent to the client
+			ctx.metadataCache.store(responseHeader.CorrelationID, requestKeyVersion.ApiVersion, newResponseBuf)
+		}
+		if ctx.apiVersionsCache.inspectsResponse(requestKeyVersion) && cacheable {
+			// as sent to the client
+			ctx.apiVersionsCache.store(requestKeyVersion.ApiVersion, newResponseBuf)
+		}
+		if ctx.outageBuffer.inspectsResponse(requestKeyVersion) {
+			// as sent to the client
+			ctx.outageBuffer.observeApiVersions(requestKeyVersion.ApiVersion, newResponseBuf)
+		}
+		if captured != nil {
+			// as sent to the client
+			ctx.capture.response(captured, responseHeader.CorrelationID, int32(len(newResponseBuf)+4), append(responseHeaderBuf[4:8:8], newResponseBuf...))
 		}
 		// add 4 bytes (CorrelationId) to the length
 		newHeaderBuf, err := protocol.Encode(&protocol.ResponseHeader{Length: int32(len(newResponseBuf) + 4), CorrelationID: responseHeader.CorrelationID})
 		if err != nil {
 			return true, err
 		}
-		if _, err := dst.Write(newHeaderBuf); err != nil {
-			return false, err
-		}
-		if _, err := dst.Write(newResponseBuf); err != nil {
+		if err := writeFrame(dst, newHeaderBuf, newResponseBuf); err != nil {
 			return false, err
 		}
 	} else {
-		// write - send to local
-		if _, err := dst.Write(responseHeaderBuf); err != nil {
-			return false, err
+		if captured != nil {
+			ctx.capture.response(captured, responseHeader.CorrelationID, responseHeader.Length, nil)
 		}
-		// 4 bytes were written as responseHeaderBuf (CorrelationId)
-		if readErr, err = myCopyN(dst, src, int64(responseHeader.Length-4), ctx.buf); err != nil {
+		// write - send to local, 4 bytes of the length were read as responseHeaderBuf (CorrelationId)
+		if readErr, err = copyFrame(dst, src, int64(responseHeader.Length-4), ctx.buf, responseHeaderBuf); err != nil {
 			return readErr, err
 		}
 	}
+	ctx.slowRequests.response(responseHeader.CorrelationID, responseHeader.Length+4)
+	ctx.correlations.response(responseHeader.CorrelationID, responseHeader.Length+4)
+	ctx.slo.response(responseHeader.CorrelationID)
+	ctx.inFlight.add(-1)
+	ctx.inFlightLimit.release()
 	return false, nil // continue nextResponse
 }
 
-func sendRequestKeyVersion(openRequestsChannel chan<- protocol.RequestKeyVersion, timeout time.Duration, request *protocol.RequestKeyVersion) error {
+func (ctx *RequestsLoopContext) getRequestModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.RequestModifier, error) {
+	topicModifier, err := ctx.rewriter.requestModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	// records are transformed after the topic names are mapped to the names used on the brokers
+	recordsModifier, err := ctx.recordTransform.requestModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	recompressModifier, err := ctx.recompression.requestModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.ChainRequestModifiers(topicModifier, recordsModifier, recompressModifier), nil
+}
+
+func (ctx *ResponsesLoopContext) getResponseModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.ResponseModifier, error) {
+	if requestKeyVersion.ApiKey == apiKeyApiApiVersions && ctx.apiVersionFilter != nil {
+		return protocol.GetApiVersionsResponseModifier(requestKeyVersion.ApiVersion, ctx.apiVersionFilter)
+	}
+	addressModifier, err := protocol.GetResponseModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.netAddressMappingFunc)
+	if err != nil {
+		return nil, err
+	}
+	// records are transformed before the topic names are mapped to the names seen by the clients
+	recordsModifier, err := ctx.recordTransform.responseModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	recompressModifier, err := ctx.recompression.responseModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	topicModifier, err := ctx.rewriter.responseModifier(requestKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	// responses are corrupted as seen by the clients
+	return protocol.ChainResponseModifiers(addressModifier, recordsModifier, recompressModifier, topicModifier, ctx.faultInjector.responseModifier(requestKeyVersion)), nil
+}
+
+func waitOrDone(delay time.Duration, done <-chan struct{}) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return errProcessorStopped
+	}
+}
+
+// openRequest sends the request to the responses loop, which reads the response of the broker. It must be called before
+// the request is written to the broker to prevent a race with the response.
+func (ctx *RequestsLoopContext) openRequest(requestKeyVersion *protocol.RequestKeyVersion) error {
+	return sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion, ctx.done)
+}
+
+// answerRequest writes the response of the request answered by the proxy instead of the broker, headerBuf is the request header
+// starting with the correlation id. The response handler queued for the request is left to the next request.
+func (ctx *RequestsLoopContext) answerRequest(src DeadlineWriter, headerBuf []byte, resp []byte) (readErr bool, err error) {
+	// the answered request itself is in flight
+	if err = ctx.writeLocalResponse(src, headerBuf, resp, 1); err != nil {
+		return false, err
+	}
+	correlationID := int32(binary.BigEndian.Uint32(headerBuf))
+	if err = ctx.tracker.response(correlationID); err != nil {
+		return true, err
+	}
+	ctx.slowRequests.response(correlationID, int32(8+len(resp)))
+	ctx.correlations.response(correlationID, int32(8+len(resp)))
+	ctx.slo.response(correlationID)
+	ctx.inFlight.add(-1)
+	ctx.inFlightLimit.release()
+	return false, ctx.putNextRequestHandler(defaultRequestHandler)
+}
+
+// writeLocalResponse writes the response of the proxy when the responses of the previous requests were written, so the client
+// receives the responses in the order of the requests. pending is the number of the in-flight requests not answered before.
+func (ctx *RequestsLoopContext) writeLocalResponse(src DeadlineWriter, headerBuf []byte, resp []byte, pending int32) error {
+	deadline := time.Now().Add(ctx.timeout)
+	for ctx.inFlight.count() > pending {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("responses of the broker were not written within %v before the response of the proxy", ctx.timeout)
+		}
+		if err := waitOrDone(localResponseInFlightPollInterval, ctx.done); err != nil {
+			return err
+		}
+	}
+	// Size, CorrelationId of the request and the body
+	frame := make([]byte, 8, 8+len(resp))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(resp)))
+	copy(frame[4:], headerBuf[:4])
+	if err := src.SetWriteDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return err
+	}
+	_, err := src.Write(append(frame, resp...))
+	return err
+}
+
+func sendRequestKeyVersion(openRequestsChannel chan<- protocol.RequestKeyVersion, timeout time.Duration, request *protocol.RequestKeyVersion, done <-chan struct{}) error {
 	select {
 	case openRequestsChannel <- *request:
 	default:
@@ -197,12 +633,14 @@ func sendRequestKeyVersion(openRequestsChannel chan<- protocol.RequestKeyVersion
 		case openRequestsChannel <- *request:
 		case <-timer.C:
 			return errors.New("open requests buffer is full")
+		case <-done:
+			return errProcessorStopped
 		}
 	}
 	return nil
 }
 
-func receiveRequestKeyVersion(openRequestsChannel <-chan protocol.RequestKeyVersion, timeout time.Duration) (*protocol.RequestKeyVersion, error) {
+func receiveRequestKeyVersion(openRequestsChannel <-chan protocol.RequestKeyVersion, timeout time.Duration, done <-chan struct{}) (*protocol.RequestKeyVersion, error) {
 	var request protocol.RequestKeyVersion
 	select {
 	case request = <-openRequestsChannel:
@@ -215,6 +653,8 @@ func receiveRequestKeyVersion(openRequestsChannel <-chan protocol.RequestKeyVers
 		case request = <-openRequestsChannel:
 		case <-timer.C:
 			return nil, errors.New("open request is missing")
+		case <-done:
+			return nil, errProcessorStopped
 		}
 	}
 	return &request, nil
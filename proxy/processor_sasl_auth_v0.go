@@ -48,7 +48,7 @@ func copySaslAuthRequest(dst DeadlineWriter, src DeadlineReader, timeout time.Du
 	}
 
 	length := binary.BigEndian.Uint32(sizeBuf)
-	if int32(length) > protocol.MaxRequestSize {
+	if length > uint32(protocol.MaxRequestSize) {
 		return true, protocol.PacketDecodingError{Info: fmt.Sprintf("auth message of length %d too large", length)}
 	}
 	//logrus.Printf("SASL auth request length %v", length)
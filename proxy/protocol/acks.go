@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ProduceAcksLeader is acks of the Produce requests acknowledged by the partition leader
+const ProduceAcksLeader = int16(1)
+
+// SpoofProduceAcks returns the Produce request body with acks 0, which is not answered by the broker, and the successful response
+// of the request with unknown offsets. False is returned if acks of the request is not 1, the request is transactional
+// or a topic is not accepted by the function.
+func SpoofProduceAcks(apiVersion int16, body []byte, fn func(topic string) bool) (forward []byte, resp []byte, ok bool, err error) {
+	requestSchema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	decodedStruct, err := DecodeSchema(body, requestSchema)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if acks, _ := decodedStruct.Get("acks").(int16); acks != ProduceAcksLeader {
+		return nil, nil, false, nil
+	}
+	if isTransactionalProduce(decodedStruct) {
+		return nil, nil, false, nil
+	}
+	if resp, ok, err = encodeProduceResponse(apiVersion, decodedStruct, fn, ErrNoError, ""); err != nil || !ok {
+		return nil, nil, false, err
+	}
+	if err = decodedStruct.Replace("acks", int16(0)); err != nil {
+		return nil, nil, false, err
+	}
+	if forward, err = EncodeSchema(decodedStruct, requestSchema); err != nil {
+		return nil, nil, false, err
+	}
+	return forward, resp, true, nil
+}
+
+// AcknowledgeProduce returns the successful response of the Produce request with unknown offsets, nil if the request has acks 0
+// and is not answered. False is returned if the request is transactional.
+func AcknowledgeProduce(apiVersion int16, body []byte) (resp []byte, ok bool, err error) {
+	requestSchema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, false, err
+	}
+	decodedStruct, err := DecodeSchema(body, requestSchema)
+	if err != nil {
+		return nil, false, err
+	}
+	if isTransactionalProduce(decodedStruct) {
+		return nil, false, nil
+	}
+	if acks, _ := decodedStruct.Get("acks").(int16); acks == 0 {
+		return nil, true, nil
+	}
+	return encodeProduceResponse(apiVersion, decodedStruct, func(string) bool { return true }, ErrNoError, "")
+}
+
+// RejectProduce returns the response of the Produce request with the error code for all partitions and the error message
+// of version 8 and later, nil if the request has acks 0 and is not answered.
+func RejectProduce(apiVersion int16, body []byte, kerr KError, message string) ([]byte, error) {
+	requestSchema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, requestSchema)
+	if err != nil {
+		return nil, err
+	}
+	if acks, _ := decodedStruct.Get("acks").(int16); acks == 0 {
+		return nil, nil
+	}
+	resp, _, err := encodeProduceResponse(apiVersion, decodedStruct, func(string) bool { return true }, kerr, message)
+	return resp, err
+}
+
+func isTransactionalProduce(decodedStruct *Struct) bool {
+	transactionalID, ok := decodedStruct.Get("transactional_id").(*string)
+	return ok && transactionalID != nil
+}
+
+// encodeProduceResponse returns the response of the decoded Produce request with the error of all partitions, false if a topic
+// is not accepted by the function
+func encodeProduceResponse(apiVersion int16, decodedStruct *Struct, fn func(topic string) bool, kerr KError, message string) ([]byte, bool, error) {
+	responseSchema, err := getResponseSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].responseSchemas)
+	if err != nil {
+		return nil, false, err
+	}
+	topics, ok := decodedStruct.Get("topic_data").([]interface{})
+	if !ok {
+		return nil, false, errors.New("topic_data array not found")
+	}
+	response, ok := zeroValue(responseSchema).(*Struct)
+	if !ok {
+		return nil, false, errors.New("produce response cannot be created")
+	}
+	topicSchema, err := arrayElementSchema(response, "responses")
+	if err != nil {
+		return nil, false, err
+	}
+	responses := make([]interface{}, 0, len(topics))
+	for _, elem := range topics {
+		topic, ok := elem.(*Struct)
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected topic_data element %T", elem)
+		}
+		name, ok := topic.Get("topic").(string)
+		if !ok {
+			return nil, false, errors.New("topic name not found")
+		}
+		if !fn(name) {
+			return nil, false, nil
+		}
+		partitions, ok := topic.Get("data").([]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("data of topic %s not found", name)
+		}
+		topicResponse := zeroValue(topicSchema).(*Struct)
+		partitionSchema, err := arrayElementSchema(topicResponse, "partition_responses")
+		if err != nil {
+			return nil, false, err
+		}
+		partitionResponses := make([]interface{}, 0, len(partitions))
+		for _, p := range partitions {
+			partition, ok := p.(*Struct)
+			if !ok {
+				return nil, false, fmt.Errorf("unexpected data element %T", p)
+			}
+			id, _ := partition.Get("partition").(int32)
+			partitionResponse := zeroValue(partitionSchema).(*Struct)
+			if err = partitionResponse.Replace("partition", id); err != nil {
+				return nil, false, err
+			}
+			if kerr != ErrNoError {
+				if err = partitionResponse.Replace(errorCodeKeyName, int16(kerr)); err != nil {
+					return nil, false, err
+				}
+				if _, ok := partitionResponse.Get(errorMessageKeyName).(*string); ok {
+					if err = partitionResponse.Replace(errorMessageKeyName, &message); err != nil {
+						return nil, false, err
+					}
+				}
+			}
+			// the offsets are not known before the broker appends the records
+			for _, offsetField := range []string{"base_offset", "log_append_time", "log_start_offset"} {
+				if partitionResponse.Get(offsetField) == nil {
+					continue
+				}
+				if err = partitionResponse.Replace(offsetField, int64(-1)); err != nil {
+					return nil, false, err
+				}
+			}
+			partitionResponses = append(partitionResponses, partitionResponse)
+		}
+		if err = topicResponse.Replace("topic", name); err != nil {
+			return nil, false, err
+		}
+		if err = topicResponse.Replace("partition_responses", partitionResponses); err != nil {
+			return nil, false, err
+		}
+		responses = append(responses, topicResponse)
+	}
+	if err = response.Replace("responses", responses); err != nil {
+		return nil, false, err
+	}
+	resp, err := EncodeSchema(response, responseSchema)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp, true, nil
+}
+
+// arrayElementSchema returns the schema of the elements of the array field of the struct
+func arrayElementSchema(s *Struct, name string) (*schema, error) {
+	bf := s.schema.fieldsByName[name]
+	if bf == nil {
+		return nil, fmt.Errorf("array %s not found in struct %s", name, s.schema.name)
+	}
+	a, ok := bf.def.(*array)
+	if !ok {
+		return nil, fmt.Errorf("field %s of struct %s is not an array", name, s.schema.name)
+	}
+	element, ok := a.ty.(*schema)
+	if !ok {
+		return nil, fmt.Errorf("elements of array %s of struct %s are not structs", name, s.schema.name)
+	}
+	return element, nil
+}
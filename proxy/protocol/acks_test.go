@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSpoofProduceAcks(t *testing.T) {
+	a := assert.New(t)
+
+	all := func(topic string) bool { return true }
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")))
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(2).
+		str("orders").int32(2).int32(0).bytes(batch).int32(1).bytes(batch).
+		str("payments").int32(1).int32(3).bytes(batch)
+
+	forward, resp, ok, err := SpoofProduceAcks(3, req, all)
+	a.Nil(err)
+	a.True(ok)
+	a.Equal([]byte(testMessage{}.int16(-1).int16(0).int32(1000).int32(2).
+		str("orders").int32(2).int32(0).bytes(batch).int32(1).bytes(batch).
+		str("payments").int32(1).int32(3).bytes(batch)), forward)
+	a.Equal([]byte(testMessage{}.int32(2).
+		str("orders").int32(2).int32(0).int16(0).int64(-1).int64(-1).int32(1).int16(0).int64(-1).int64(-1).
+		str("payments").int32(1).int32(3).int16(0).int64(-1).int64(-1).
+		int32(0)), resp)
+
+	// log start offset of version 5
+	_, resp, ok, err = SpoofProduceAcks(5, req, all)
+	a.Nil(err)
+	a.True(ok)
+	a.Equal([]byte(testMessage{}.int32(2).
+		str("orders").int32(2).int32(0).int16(0).int64(-1).int64(-1).int64(-1).int32(1).int16(0).int64(-1).int64(-1).int64(-1).
+		str("payments").int32(1).int32(3).int16(0).int64(-1).int64(-1).int64(-1).
+		int32(0)), resp)
+
+	// only the listed topics
+	_, _, ok, err = SpoofProduceAcks(3, req, func(topic string) bool { return topic == "orders" })
+	a.Nil(err)
+	a.False(ok)
+
+	acksAll := testMessage{}.int16(-1).int16(-1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(batch)
+	_, _, ok, err = SpoofProduceAcks(3, acksAll, all)
+	a.Nil(err)
+	a.False(ok)
+
+	transactional := testMessage{}.str("tx").int16(1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(batch)
+	_, _, ok, err = SpoofProduceAcks(3, transactional, all)
+	a.Nil(err)
+	a.False(ok)
+
+	_, _, _, err = SpoofProduceAcks(3, req[:10], all)
+	a.NotNil(err)
+}
+
+func TestAcknowledgeProduce(t *testing.T) {
+	a := assert.New(t)
+
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")))
+	req := testMessage{}.int16(-1).int16(-1).int32(1000).int32(1).
+		str("orders").int32(1).int32(2).bytes(batch)
+	resp, ok, err := AcknowledgeProduce(3, req)
+	a.Nil(err)
+	a.True(ok)
+	a.Equal([]byte(testMessage{}.int32(1).
+		str("orders").int32(1).int32(2).int16(0).int64(-1).int64(-1).
+		int32(0)), resp)
+
+	// not answered
+	acksNone := testMessage{}.int16(-1).int16(0).int32(1000).int32(1).
+		str("orders").int32(1).int32(2).bytes(batch)
+	resp, ok, err = AcknowledgeProduce(3, acksNone)
+	a.Nil(err)
+	a.True(ok)
+	a.Nil(resp)
+
+	transactional := testMessage{}.str("tx").int16(1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(batch)
+	_, ok, err = AcknowledgeProduce(3, transactional)
+	a.Nil(err)
+	a.False(ok)
+
+	_, _, err = AcknowledgeProduce(3, req[:10])
+	a.NotNil(err)
+}
+
+func TestRejectProduce(t *testing.T) {
+	a := assert.New(t)
+
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")))
+	req := testMessage{}.int16(-1).int16(-1).int32(1000).int32(1).
+		str("orders").int32(2).int32(0).bytes(batch).int32(1).bytes(batch)
+	resp, err := RejectProduce(3, req, ErrPolicyViolation, "frozen")
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(1).
+		str("orders").int32(2).int32(0).int16(44).int64(-1).int64(-1).int32(1).int16(44).int64(-1).int64(-1).
+		int32(0)), resp)
+
+	// error message of version 8
+	resp, err = RejectProduce(8, req, ErrPolicyViolation, "frozen")
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(1).
+		str("orders").int32(2).
+		int32(0).int16(44).int64(-1).int64(-1).int64(-1).int32(0).str("frozen").
+		int32(1).int16(44).int64(-1).int64(-1).int64(-1).int32(0).str("frozen").
+		int32(0)), resp)
+
+	// not answered
+	acksNone := testMessage{}.int16(-1).int16(0).int32(1000).int32(1).
+		str("orders").int32(1).int32(2).bytes(batch)
+	resp, err = RejectProduce(3, acksNone, ErrPolicyViolation, "frozen")
+	a.Nil(err)
+	a.Nil(resp)
+}
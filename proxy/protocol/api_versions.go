@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	apiKeyApiVersions = 18
+
+	apiVersionsKeyName = "api_versions"
+	apiKeyKeyName      = "api_key"
+	minVersionKeyName  = "min_version"
+	maxVersionKeyName  = "max_version"
+)
+
+var apiVersionsResponseSchemaVersions = createApiVersionsResponseSchemaVersions()
+
+func createApiVersionsResponseSchemaVersions() []Schema {
+	apiVersionsV0 := NewSchema("api_versions_v0",
+		&field{name: apiKeyKeyName, ty: typeInt16},
+		&field{name: minVersionKeyName, ty: typeInt16},
+		&field{name: maxVersionKeyName, ty: typeInt16},
+	)
+
+	apiVersionsResponseV0 := NewSchema("api_versions_response_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: apiVersionsKeyName, ty: apiVersionsV0},
+	)
+
+	apiVersionsResponseV1 := NewSchema("api_versions_response_v1",
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: apiVersionsKeyName, ty: apiVersionsV0},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	apiVersionsResponseV2 := apiVersionsResponseV1
+
+	apiVersionsV3 := NewSchema("api_versions_v3",
+		&field{name: apiKeyKeyName, ty: typeInt16},
+		&field{name: minVersionKeyName, ty: typeInt16},
+		&field{name: maxVersionKeyName, ty: typeInt16},
+		&taggedFields{name: "tagged_fields"},
+	)
+
+	apiVersionsResponseV3 := NewSchema("api_versions_response_v3",
+		&field{name: "error_code", ty: typeInt16},
+		&compactArray{name: apiVersionsKeyName, ty: apiVersionsV3},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&taggedFields{name: "tagged_fields"},
+	)
+
+	apiVersionsResponseV4 := apiVersionsResponseV3
+
+	return []Schema{apiVersionsResponseV0, apiVersionsResponseV1, apiVersionsResponseV2, apiVersionsResponseV3, apiVersionsResponseV4}
+}
+
+// ApiVersionFilterFunc reports whether the version of the api key should be hidden from the clients
+type ApiVersionFilterFunc func(apiKey int16, apiVersion int16) bool
+
+type apiVersionsResponseModifier struct {
+	schema Schema
+	filter ApiVersionFilterFunc
+}
+
+// GetApiVersionsResponseModifier returns the modifier which narrows the version ranges advertised in the ApiVersions response,
+// so the clients negotiate only the versions which are not filtered out
+func GetApiVersionsResponseModifier(apiVersion int16, filter ApiVersionFilterFunc) (ResponseModifier, error) {
+	if filter == nil {
+		return nil, errors.New("api version filter must not be nil")
+	}
+	schema, err := getResponseSchema(apiKeyApiVersions, apiVersion, apiVersionsResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	return &apiVersionsResponseModifier{schema: schema, filter: filter}, nil
+}
+
+func (f *apiVersionsResponseModifier) Apply(resp []byte) ([]byte, error) {
+	// error responses e.g. UNSUPPORTED_VERSION are always encoded with version 0 and are passed as they are
+	if len(resp) >= 2 && binary.BigEndian.Uint16(resp) != 0 {
+		return resp, nil
+	}
+	decodedStruct, err := DecodeSchema(resp, f.schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = modifyApiVersionsResponse(decodedStruct, f.filter); err != nil {
+		return nil, err
+	}
+	return EncodeSchema(decodedStruct, f.schema)
+}
+
+func modifyApiVersionsResponse(decodedStruct *Struct, filter ApiVersionFilterFunc) error {
+	if decodedStruct == nil {
+		return errors.New("decoded struct must not be nil")
+	}
+	apiVersionsArray, ok := decodedStruct.Get(apiVersionsKeyName).([]interface{})
+	if !ok {
+		return errors.New("api versions list not found")
+	}
+	result := make([]interface{}, 0, len(apiVersionsArray))
+	for _, apiVersionsElement := range apiVersionsArray {
+		apiVersions := apiVersionsElement.(*Struct)
+		apiKey, ok := apiVersions.Get(apiKeyKeyName).(int16)
+		if !ok {
+			return errors.New("api_versions.api_key not found")
+		}
+		minVersion, ok := apiVersions.Get(minVersionKeyName).(int16)
+		if !ok {
+			return errors.New("api_versions.min_version not found")
+		}
+		maxVersion, ok := apiVersions.Get(maxVersionKeyName).(int16)
+		if !ok {
+			return errors.New("api_versions.max_version not found")
+		}
+		newMin, newMax, ok := allowedVersionRange(apiKey, minVersion, maxVersion, filter)
+		if !ok {
+			// no version can be used, the api key is not advertised
+			continue
+		}
+		if newMin != minVersion {
+			if err := apiVersions.Replace(minVersionKeyName, newMin); err != nil {
+				return err
+			}
+		}
+		if newMax != maxVersion {
+			if err := apiVersions.Replace(maxVersionKeyName, newMax); err != nil {
+				return err
+			}
+		}
+		result = append(result, apiVersions)
+	}
+	return decodedStruct.Replace(apiVersionsKeyName, result)
+}
+
+// allowedVersionRange returns the highest contiguous range of versions which are not filtered out.
+// Ranges can be advertised only, so lower allowed versions separated by a filtered version are dropped.
+func allowedVersionRange(apiKey int16, minVersion int16, maxVersion int16, filter ApiVersionFilterFunc) (int16, int16, bool) {
+	newMax := maxVersion
+	for newMax >= minVersion && filter(apiKey, newMax) {
+		newMax--
+	}
+	if newMax < minVersion {
+		return 0, 0, false
+	}
+	newMin := newMax
+	for newMin > minVersion && !filter(apiKey, newMin-1) {
+		newMin--
+	}
+	return newMin, newMax, true
+}
+
+// ApiVersionRange is the range of versions of an api key supported by the broker
+type ApiVersionRange struct {
+	MinVersion int16
+	MaxVersion int16
+}
+
+// DecodeApiVersions returns the version ranges of the ApiVersions response body
+func DecodeApiVersions(apiVersion int16, body []byte) (map[int16]ApiVersionRange, error) {
+	if len(body) < 2 {
+		return nil, errors.New("api versions response is too short")
+	}
+	if kerr := KError(binary.BigEndian.Uint16(body)); kerr != ErrNoError {
+		return nil, kerr
+	}
+	schema, err := getResponseSchema(apiKeyApiVersions, apiVersion, apiVersionsResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	apiVersionsArray, ok := decodedStruct.Get(apiVersionsKeyName).([]interface{})
+	if !ok {
+		return nil, errors.New("api versions list not found")
+	}
+	result := make(map[int16]ApiVersionRange, len(apiVersionsArray))
+	for _, apiVersionsElement := range apiVersionsArray {
+		apiVersions, ok := apiVersionsElement.(*Struct)
+		if !ok {
+			return nil, errors.New("unexpected api versions element")
+		}
+		apiKey, _ := apiVersions.Get(apiKeyKeyName).(int16)
+		minVersion, _ := apiVersions.Get(minVersionKeyName).(int16)
+		maxVersion, _ := apiVersions.Get(maxVersionKeyName).(int16)
+		result[apiKey] = ApiVersionRange{MinVersion: minVersion, MaxVersion: maxVersion}
+	}
+	return result, nil
+}
+
+// ClientSoftware is the name and version of the client library sent with the ApiVersions request since version 3 (KIP-511)
+type ClientSoftware struct {
+	Name    string
+	Version string
+}
+
+// DecodeApiVersionsRequestClientSoftware returns the client software of the ApiVersions request body following the client id
+// of the request header. Nil is returned for the versions before 3 which do not carry the client software.
+func DecodeApiVersionsRequestClientSoftware(apiVersion int16, body []byte) (*ClientSoftware, error) {
+	if apiVersion < 3 {
+		return nil, nil
+	}
+	pd := &realDecoder{raw: body}
+	// tagged fields of the request header v2
+	if _, err := (&taggedFields{name: "header_tagged_fields"}).decode(pd); err != nil {
+		return nil, err
+	}
+	name, err := getCompactString(pd)
+	if err != nil {
+		return nil, err
+	}
+	version, err := getCompactString(pd)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientSoftware{Name: name, Version: version}, nil
+}
+
+// getCompactString decodes the string of the flexible versions, the length is encoded as unsigned varint N+1
+func getCompactString(pd packetDecoder) (string, error) {
+	n, err := pd.getUVarint()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 || n-1 > uint64(pd.remaining()) {
+		return "", errInvalidStringLength
+	}
+	buf, err := pd.getRawBytes(int(n - 1))
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
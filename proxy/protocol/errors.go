@@ -116,6 +116,10 @@ const (
 	ErrSASLAuthenticationFailed           KError = 58
 	ErrUnknownProducerID                  KError = 59
 	ErrReassignmentInProgress             KError = 60
+	ErrUnsupportedCompressionType         KError = 76
+	ErrInvalidRecord                      KError = 87
+	ErrUnknownSubscriptionID              KError = 117
+	ErrTelemetryTooLarge                  KError = 118
 )
 
 func (err KError) Error() string {
@@ -246,6 +250,14 @@ func (err KError) Error() string {
 		return "kafka server: The broker could not locate the producer metadata associated with the Producer ID."
 	case ErrReassignmentInProgress:
 		return "kafka server: A partition reassignment is in progress."
+	case ErrUnsupportedCompressionType:
+		return "kafka server: The requesting client does not support the compression type of given partition."
+	case ErrInvalidRecord:
+		return "kafka server: This record has failed the validation on broker and hence will be rejected."
+	case ErrUnknownSubscriptionID:
+		return "kafka server: Client sent a push telemetry request with an invalid or outdated subscription ID."
+	case ErrTelemetryTooLarge:
+		return "kafka server: Client sent a push telemetry request larger than the maximum size the broker will accept."
 	}
 
 	return fmt.Sprintf("Unknown error, how did this happen? Error code = %d", err)
@@ -0,0 +1,125 @@
+//go:build go1.18
+// +build go1.18
+
+package protocol
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// The fuzz targets decode the frames of untrusted clients and brokers, a decoding error is fine but a panic would stop all connections
+// of the shared proxy process. The seeds in testdata/fuzz can be extended with the raw frames of the request capture:
+//
+//	kafka-proxy tools fuzz-corpus --capture-file capture.jsonl --output-dir proxy/protocol/testdata/fuzz
+//	go test ./proxy/protocol -run '^$' -fuzz FuzzRequest -fuzztime 60s
+
+// FuzzRequest decodes the request frame of ApiKey, ApiVersion, request header and body as read after the size
+func FuzzRequest(f *testing.F) {
+	f.Add(requestFrame(18, 0, nil))
+	f.Add(requestFrame(3, 1, []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x05, 't', 'o', 'p', 'i', 'c'}))
+	f.Add(requestFrame(17, 1, []byte{0x00, 0x05, 'P', 'L', 'A', 'I', 'N'}))
+	f.Add(requestFrame(36, 0, []byte{0x00, 0x00, 0x00, 0x0d, 0x00, 'a', 'l', 'i', 'c', 'e', 0x00, 's', 'e', 'c', 'r', 'e', 't'}))
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		if len(frame) < 4 {
+			return
+		}
+		// Size => int32, ApiKey => int16 and ApiVersion => int16 are read at once
+		keyVersionBuf := make([]byte, 8)
+		binary.BigEndian.PutUint32(keyVersionBuf, uint32(len(frame)))
+		copy(keyVersionBuf[4:], frame)
+		keyVersion := &RequestKeyVersion{}
+		if err := Decode(keyVersionBuf, keyVersion); err != nil {
+			return
+		}
+		// the request header v1: CorrelationId => int32 and ClientId => nullable string
+		body := frame[4:]
+		if len(body) < 6 {
+			return
+		}
+		clientIDLength := int(int16(binary.BigEndian.Uint16(body[4:])))
+		if clientIDLength < 0 {
+			clientIDLength = 0
+		}
+		if 6+clientIDLength > len(body) {
+			return
+		}
+		body = body[6+clientIDLength:]
+
+		apiKey, apiVersion := keyVersion.ApiKey, keyVersion.ApiVersion
+		DecodeRequestNames(apiKey, apiVersion, body)
+		mapper := &PrefixNameMapper{Prefix: "tenant-"}
+		if modifier, err := GetNamesRequestModifier(apiKey, apiVersion, mapper, mapper); err == nil && modifier != nil {
+			modifier.Apply(body)
+		}
+		IsTransactionalRequest(apiKey, apiVersion, body)
+		switch apiKey {
+		case 0:
+			DecodeProduceRecordValues(apiVersion, body, 1024*1024, func(topic string, value []byte) error { return nil })
+			DecodeProduceRecordBatchStats(apiVersion, body, func(topic string, size int, records int) {})
+		case 18:
+			DecodeApiVersionsRequestClientSoftware(apiVersion, body)
+		case 71:
+			DecodeGetTelemetrySubscriptionsRequest(apiVersion, body)
+		case 72:
+			DecodePushTelemetryRequest(apiVersion, body)
+		}
+	})
+}
+
+// FuzzResponse rewrites the response body of the broker as sent to the client
+func FuzzResponse(f *testing.F) {
+	f.Add(int16(3), int16(0), emptyMetadataResponse)
+	f.Add(int16(10), int16(0), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x09, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0x00, 0x00, 0x23, 0x84})
+	f.Add(int16(18), int16(0), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x07})
+
+	mapping := func(brokerHost string, brokerPort int32) (string, int32, error) {
+		return "127.0.0.1", 32400 + brokerPort%100, nil
+	}
+	filter := func(apiKey int16, apiVersion int16) bool {
+		return apiKey != 1
+	}
+	f.Fuzz(func(t *testing.T, apiKey int16, apiVersion int16, body []byte) {
+		if modifier, err := GetResponseModifier(apiKey, apiVersion, mapping); err == nil && modifier != nil {
+			modifier.Apply(body)
+		}
+		if apiKey == 18 {
+			if modifier, err := GetApiVersionsResponseModifier(apiVersion, filter); err == nil {
+				modifier.Apply(body)
+			}
+			DecodeApiVersions(apiVersion, body)
+		}
+		mapper := &PrefixNameMapper{Prefix: "tenant-"}
+		if modifier, err := GetNamesResponseModifier(apiKey, apiVersion, mapper, mapper); err == nil && modifier != nil {
+			modifier.Apply(body)
+		}
+		if apiKey == 3 {
+			DecodeMetadataLeaders(apiVersion, body)
+		}
+		if apiKey == 1 {
+			DecodeFetchRecordBatchStats(apiVersion, body, func(topic string, size int, records int) {})
+		}
+		DecodeResponseErrors(apiKey, apiVersion, body)
+	})
+}
+
+// FuzzSaslRequest decodes the SaslHandshake and SaslAuthenticate requests read before the client is authenticated
+func FuzzSaslRequest(f *testing.F) {
+	f.Add(requestFrame(17, 0, []byte{0x00, 0x05, 'P', 'L', 'A', 'I', 'N'}))
+	f.Add(requestFrame(36, 0, []byte{0x00, 0x00, 0x00, 0x05, 'n', ',', ',', 'n', '='}))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		Decode(payload, &Request{Body: &SaslHandshakeRequestV0orV1{Version: 0}})
+		Decode(payload, &Request{Body: &SaslHandshakeRequestV0orV1{Version: 1}})
+		Decode(payload, &Request{Body: &SaslAuthenticateRequestV0{}})
+		Decode(payload, &SaslHandshakeResponseV0orV1{})
+		Decode(payload, &SaslAuthenticateResponseV0{})
+	})
+}
+
+// requestFrame returns the frame without the size with the correlation id 1 and the client id "fuzz"
+func requestFrame(apiKey, apiVersion int16, body []byte) []byte {
+	frame := []byte{byte(apiKey >> 8), byte(apiKey), byte(apiVersion >> 8), byte(apiVersion), 0x00, 0x00, 0x00, 0x01, 0x00, 0x04, 'f', 'u', 'z', 'z'}
+	return append(frame, body...)
+}
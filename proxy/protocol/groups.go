@@ -0,0 +1,259 @@
+package protocol
+
+const (
+	apiKeyJoinGroup      = 11
+	apiKeyHeartbeat      = 12
+	apiKeyLeaveGroup     = 13
+	apiKeySyncGroup      = 14
+	apiKeyDescribeGroups = 15
+	apiKeyListGroups     = 16
+	apiKeyDeleteGroups   = 42
+
+	coordinatorKeyTypeGroup = int8(0)
+)
+
+// isGroupCoordinatorKey reports whether FindCoordinator looks up a group coordinator, not a transaction coordinator
+func isGroupCoordinatorKey(s *Struct) bool {
+	keyType, ok := s.Get("key_type").(int8)
+	return !ok || keyType == coordinatorKeyTypeGroup
+}
+
+func createFindCoordinatorRequestSchemaVersions() []Schema {
+	// the group_id field of version 0 is named key to use the same name path in all versions
+	findCoordinatorRequestV0 := NewSchema("find_coordinator_request_v0",
+		&field{name: "key", ty: typeStr},
+	)
+
+	findCoordinatorRequestV1 := NewSchema("find_coordinator_request_v1",
+		&field{name: "key", ty: typeStr},
+		&field{name: "key_type", ty: typeInt8},
+	)
+
+	return []Schema{findCoordinatorRequestV0, findCoordinatorRequestV1, findCoordinatorRequestV1}
+}
+
+func createJoinGroupRequestSchemaVersions() []Schema {
+	groupProtocolV0 := NewSchema("group_protocol_v0",
+		&field{name: "name", ty: typeStr},
+		&field{name: "metadata", ty: typeBytes},
+	)
+
+	joinGroupRequestV0 := NewSchema("join_group_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "session_timeout", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "group_protocols", ty: groupProtocolV0},
+	)
+
+	joinGroupRequestV1 := NewSchema("join_group_request_v1",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "session_timeout", ty: typeInt32},
+		&field{name: "rebalance_timeout", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "group_protocols", ty: groupProtocolV0},
+	)
+
+	joinGroupRequestV5 := NewSchema("join_group_request_v5",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "session_timeout", ty: typeInt32},
+		&field{name: "rebalance_timeout", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "group_protocols", ty: groupProtocolV0},
+	)
+
+	return []Schema{joinGroupRequestV0, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV5}
+}
+
+func createHeartbeatRequestSchemaVersions() []Schema {
+	heartbeatRequestV0 := NewSchema("heartbeat_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+	)
+
+	heartbeatRequestV3 := NewSchema("heartbeat_request_v3",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+	)
+
+	return []Schema{heartbeatRequestV0, heartbeatRequestV0, heartbeatRequestV0, heartbeatRequestV3}
+}
+
+func createLeaveGroupRequestSchemaVersions() []Schema {
+	leaveGroupRequestV0 := NewSchema("leave_group_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "member_id", ty: typeStr},
+	)
+
+	memberV3 := NewSchema("leave_group_member_v3",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+	)
+
+	leaveGroupRequestV3 := NewSchema("leave_group_request_v3",
+		&field{name: "group_id", ty: typeStr},
+		&array{name: "members", ty: memberV3},
+	)
+
+	return []Schema{leaveGroupRequestV0, leaveGroupRequestV0, leaveGroupRequestV0, leaveGroupRequestV3}
+}
+
+func createSyncGroupRequestSchemaVersions() []Schema {
+	assignmentV0 := NewSchema("sync_group_assignment_v0",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "assignment", ty: typeBytes},
+	)
+
+	syncGroupRequestV0 := NewSchema("sync_group_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "assignments", ty: assignmentV0},
+	)
+
+	syncGroupRequestV3 := NewSchema("sync_group_request_v3",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&array{name: "assignments", ty: assignmentV0},
+	)
+
+	return []Schema{syncGroupRequestV0, syncGroupRequestV0, syncGroupRequestV0, syncGroupRequestV3}
+}
+
+func createDescribeGroupsRequestSchemaVersions() []Schema {
+	describeGroupsRequestV0 := NewSchema("describe_groups_request_v0",
+		&array{name: "groups", ty: typeStr},
+	)
+
+	describeGroupsRequestV3 := NewSchema("describe_groups_request_v3",
+		&array{name: "groups", ty: typeStr},
+		&field{name: "include_authorized_operations", ty: typeBool},
+	)
+
+	return []Schema{describeGroupsRequestV0, describeGroupsRequestV0, describeGroupsRequestV0, describeGroupsRequestV3, describeGroupsRequestV3}
+}
+
+func createDescribeGroupsResponseSchemaVersions() []Schema {
+	memberV0 := NewSchema("describe_groups_member_v0",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "client_id", ty: typeStr},
+		&field{name: "client_host", ty: typeStr},
+		&field{name: "member_metadata", ty: typeBytes},
+		&field{name: "member_assignment", ty: typeBytes},
+	)
+
+	memberV4 := NewSchema("describe_groups_member_v4",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: "client_id", ty: typeStr},
+		&field{name: "client_host", ty: typeStr},
+		&field{name: "member_metadata", ty: typeBytes},
+		&field{name: "member_assignment", ty: typeBytes},
+	)
+
+	groupV0 := NewSchema("describe_groups_group_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+	)
+
+	groupV3 := NewSchema("describe_groups_group_v3",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+		&field{name: "authorized_operations", ty: typeInt32},
+	)
+
+	groupV4 := NewSchema("describe_groups_group_v4",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV4},
+		&field{name: "authorized_operations", ty: typeInt32},
+	)
+
+	describeGroupsResponseV0 := NewSchema("describe_groups_response_v0",
+		&array{name: "groups", ty: groupV0},
+	)
+
+	describeGroupsResponseV1 := NewSchema("describe_groups_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "groups", ty: groupV0},
+	)
+
+	describeGroupsResponseV3 := NewSchema("describe_groups_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "groups", ty: groupV3},
+	)
+
+	describeGroupsResponseV4 := NewSchema("describe_groups_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "groups", ty: groupV4},
+	)
+
+	return []Schema{describeGroupsResponseV0, describeGroupsResponseV1, describeGroupsResponseV1, describeGroupsResponseV3, describeGroupsResponseV4}
+}
+
+func createListGroupsRequestSchemaVersions() []Schema {
+	listGroupsRequestV0 := NewSchema("list_groups_request_v0")
+
+	return []Schema{listGroupsRequestV0, listGroupsRequestV0, listGroupsRequestV0}
+}
+
+func createListGroupsResponseSchemaVersions() []Schema {
+	groupV0 := NewSchema("list_groups_group_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+	)
+
+	listGroupsResponseV0 := NewSchema("list_groups_response_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: "groups", ty: groupV0},
+	)
+
+	listGroupsResponseV1 := NewSchema("list_groups_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: "groups", ty: groupV0},
+	)
+
+	return []Schema{listGroupsResponseV0, listGroupsResponseV1, listGroupsResponseV1}
+}
+
+func createDeleteGroupsRequestSchemaVersions() []Schema {
+	deleteGroupsRequestV0 := NewSchema("delete_groups_request_v0",
+		&array{name: "groups_names", ty: typeStr},
+	)
+
+	return []Schema{deleteGroupsRequestV0, deleteGroupsRequestV0}
+}
+
+func createDeleteGroupsResponseSchemaVersions() []Schema {
+	resultV0 := NewSchema("delete_groups_result_v0",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	deleteGroupsResponseV0 := NewSchema("delete_groups_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "results", ty: resultV0},
+	)
+
+	return []Schema{deleteGroupsResponseV0, deleteGroupsResponseV0}
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJoinGroupRequestGroupIsMappedToBroker(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.str("orders-app").int32(10000).int32(30000).str("").str("consumer").int32(1).
+		str("range").bytes([]byte{1, 2, 3})
+
+	modifier, err := GetNamesRequestModifier(apiKeyJoinGroup, 2, nil, &PrefixNameMapper{Prefix: "tenant-a."})
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	expected := testMessage{}.str("tenant-a.orders-app").int32(10000).int32(30000).str("").str("consumer").int32(1).
+		str("range").bytes([]byte{1, 2, 3})
+	a.Equal([]byte(expected), result)
+}
+
+func TestOffsetCommitRequestGroupAndTopicsAreMappedToBroker(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.str("orders-app").int32(1).str("member-1").int64(-1).int32(1).
+		str("orders").int32(1).int32(0).int64(42).int16(-1)
+
+	modifier, err := GetNamesRequestModifier(apiKeyOffsetCommit, 2, &PrefixNameMapper{Prefix: "tenant-a."}, &PrefixNameMapper{Prefix: "group-a."})
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	expected := testMessage{}.str("group-a.orders-app").int32(1).str("member-1").int64(-1).int32(1).
+		str("tenant-a.orders").int32(1).int32(0).int64(42).int16(-1)
+	a.Equal([]byte(expected), result)
+}
+
+func TestFindCoordinatorRequestOnlyGroupKeyIsMapped(t *testing.T) {
+	a := assert.New(t)
+
+	modifier, err := GetNamesRequestModifier(apiKeyFindCoordinator, 1, nil, &PrefixNameMapper{Prefix: "tenant-a."})
+	a.Nil(err)
+
+	result, err := modifier.Apply(testMessage{}.str("orders-app").int8(0))
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.str("tenant-a.orders-app").int8(0)), result)
+
+	// transaction coordinator
+	result, err = modifier.Apply(testMessage{}.str("txn-1").int8(1))
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.str("txn-1").int8(1)), result)
+}
+
+func TestListGroupsResponseHidesOtherGroups(t *testing.T) {
+	a := assert.New(t)
+
+	resp := testMessage{}.int32(0).int16(0).int32(2).
+		str("tenant-a.orders-app").str("consumer").
+		str("tenant-b.orders-app").str("consumer")
+
+	modifier, err := GetNamesResponseModifier(apiKeyListGroups, 1, nil, &PrefixNameMapper{Prefix: "tenant-a."})
+	a.Nil(err)
+	result, err := modifier.Apply(resp)
+	a.Nil(err)
+
+	expected := testMessage{}.int32(0).int16(0).int32(1).
+		str("orders-app").str("consumer")
+	a.Equal([]byte(expected), result)
+}
+
+func TestGroupNamesMaxVersion(t *testing.T) {
+	a := assert.New(t)
+
+	for apiKey, maxVersion := range map[int16]int16{apiKeyOffsetCommit: 7, apiKeyOffsetFetch: 5, apiKeyFindCoordinator: 2, apiKeyJoinGroup: 5,
+		apiKeyHeartbeat: 3, apiKeyLeaveGroup: 3, apiKeySyncGroup: 3, apiKeyDescribeGroups: 4, apiKeyListGroups: 2, apiKeyDeleteGroups: 1} {
+		version, ok := GroupNamesMaxVersion(apiKey)
+		a.True(ok)
+		a.Equal(maxVersion, version)
+		names := namesByApiKey[apiKey]
+		if names.responseSchemas != nil {
+			a.Equal(len(names.requestSchemas), len(names.responseSchemas))
+		}
+	}
+	_, ok := GroupNamesMaxVersion(apiKeyProduce)
+	a.False(ok)
+}
+
+func TestDecodeRequestNames(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.str("orders-app").int32(1).str("member-1").int64(-1).int32(1).
+		str("orders").int32(1).int32(0).int64(42).int16(-1)
+	topics, groups, err := DecodeRequestNames(apiKeyOffsetCommit, 2, req)
+	a.Nil(err)
+	a.Equal([]string{"orders"}, topics)
+	a.Equal([]string{"orders-app"}, groups)
+
+	// transaction coordinator
+	topics, groups, err = DecodeRequestNames(apiKeyFindCoordinator, 1, testMessage{}.str("txn-1").int8(1))
+	a.Nil(err)
+	a.Nil(topics)
+	a.Nil(groups)
+
+	_, ok := RequestNamesMaxVersion(apiKeyListGroups)
+	a.False(ok)
+	version, ok := RequestNamesMaxVersion(apiKeyJoinGroup)
+	a.True(ok)
+	a.Equal(int16(5), version)
+
+	topics, groups, err = DecodeRequestNames(apiKeyApiVersions, 0, nil)
+	a.Nil(err)
+	a.Nil(topics)
+	a.Nil(groups)
+}
@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// MetadataRequestV1 requests the metadata of the topics, all topics when Topics is nil
+type MetadataRequestV1 struct {
+	Topics []string
+}
+
+func (r *MetadataRequestV1) encode(pe packetEncoder) error {
+	if r.Topics == nil {
+		pe.putInt32(-1)
+		return nil
+	}
+	return pe.putStringArray(r.Topics)
+}
+
+func (r *MetadataRequestV1) decode(pd packetDecoder) (err error) {
+	n, err := pd.getInt32()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		r.Topics = nil
+		return nil
+	}
+	r.Topics = make([]string, n)
+	for i := range r.Topics {
+		if r.Topics[i], err = pd.getString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MetadataRequestV1) key() int16 {
+	return apiKeyMetadata
+}
+
+func (r *MetadataRequestV1) version() int16 {
+	return 1
+}
+
+// MetadataLeaders are the addresses of the brokers and the leaders of the topic partitions
+type MetadataLeaders struct {
+	// broker addresses by node id
+	Brokers map[int32]string
+	// leader node ids by topic and partition
+	Leaders map[string]map[int32]int32
+}
+
+// Leader returns the address of the partition leader
+func (m *MetadataLeaders) Leader(topic string, partition int32) (int32, string, bool) {
+	nodeID, ok := m.Leaders[topic][partition]
+	if !ok {
+		return 0, "", false
+	}
+	address, ok := m.Brokers[nodeID]
+	return nodeID, address, ok
+}
+
+// DecodeMetadataLeaders decodes the Metadata response body (without the correlation id)
+func DecodeMetadataLeaders(apiVersion int16, body []byte) (*MetadataLeaders, error) {
+	schema, err := getRequestSchema(apiKeyMetadata, apiVersion, metadataResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	result := &MetadataLeaders{Brokers: make(map[int32]string), Leaders: make(map[string]map[int32]int32)}
+
+	brokers, ok := decodedStruct.Get(brokersKeyName).([]interface{})
+	if !ok {
+		return nil, errors.New("brokers array not found")
+	}
+	for _, elem := range brokers {
+		broker, ok := elem.(*Struct)
+		if !ok {
+			return nil, fmt.Errorf("unexpected broker element %T", elem)
+		}
+		nodeID, _ := broker.Get("node_id").(int32)
+		host, _ := broker.Get(hostKeyName).(string)
+		port, _ := broker.Get(portKeyName).(int32)
+		result.Brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	topics, ok := decodedStruct.Get("topic_metadata").([]interface{})
+	if !ok {
+		return nil, errors.New("topic_metadata array not found")
+	}
+	for _, elem := range topics {
+		topic, ok := elem.(*Struct)
+		if !ok {
+			return nil, fmt.Errorf("unexpected topic_metadata element %T", elem)
+		}
+		name, _ := topic.Get("topic").(string)
+		partitions, _ := topic.Get("partition_metadata").([]interface{})
+		leaders := make(map[int32]int32)
+		for _, p := range partitions {
+			partition, ok := p.(*Struct)
+			if !ok {
+				return nil, fmt.Errorf("unexpected partition_metadata element %T", p)
+			}
+			id, _ := partition.Get("partition").(int32)
+			leader, _ := partition.Get("leader").(int32)
+			if errorCode, _ := partition.Get("error_code").(int16); errorCode == 0 && leader >= 0 {
+				leaders[id] = leader
+			}
+		}
+		result.Leaders[name] = leaders
+	}
+	return result, nil
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMetadataRequestV1(t *testing.T) {
+	a := assert.New(t)
+
+	buf, err := Encode(&MetadataRequestV1{})
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(-1)), buf)
+
+	buf, err = Encode(&MetadataRequestV1{Topics: []string{"orders"}})
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(1).str("orders")), buf)
+
+	req := &MetadataRequestV1{}
+	a.Nil(Decode(buf, req))
+	a.Equal([]string{"orders"}, req.Topics)
+}
+
+func TestDecodeMetadataLeaders(t *testing.T) {
+	a := assert.New(t)
+
+	partition := func(m testMessage, errorCode int16, id int32, leader int32) testMessage {
+		return m.int16(errorCode).int32(id).int32(leader).int32(1).int32(leader).int32(1).int32(leader)
+	}
+	resp := testMessage{}.int32(2).
+		int32(1).str("kafka-1").int32(9092).int16(-1).
+		int32(2).str("kafka-2").int32(9093).int16(-1).
+		int32(1).
+		int32(1).
+		int16(0).str("orders").int8(0).int32(3)
+	resp = partition(resp, 0, 0, 1)
+	resp = partition(resp, 0, 1, 2)
+	resp = partition(resp, 5, 2, -1)
+
+	metadata, err := DecodeMetadataLeaders(1, resp)
+	a.Nil(err)
+	a.Equal(map[int32]string{1: "kafka-1:9092", 2: "kafka-2:9093"}, metadata.Brokers)
+
+	nodeID, address, ok := metadata.Leader("orders", 1)
+	a.True(ok)
+	a.Equal(int32(2), nodeID)
+	a.Equal("kafka-2:9093", address)
+	_, _, ok = metadata.Leader("orders", 2)
+	a.False(ok)
+	_, _, ok = metadata.Leader("payments", 0)
+	a.False(ok)
+}
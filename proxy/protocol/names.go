@@ -0,0 +1,468 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NameMapper translates the resource names used by the clients to the names used on the brokers and back
+type NameMapper interface {
+	// ToBroker returns the name used on the brokers
+	ToBroker(name string) string
+	// ToClient returns the name seen by the clients, false if the resource must not be visible to the clients
+	ToClient(name string) (string, bool)
+}
+
+// PrefixNameMapper adds the prefix to the names sent to the brokers and strips it from the names sent to the clients.
+// Names without the prefix are not visible to the clients.
+type PrefixNameMapper struct {
+	Prefix string
+}
+
+func (m *PrefixNameMapper) ToBroker(name string) string {
+	return m.Prefix + name
+}
+
+func (m *PrefixNameMapper) ToClient(name string) (string, bool) {
+	if !strings.HasPrefix(name, m.Prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, m.Prefix), true
+}
+
+// mapNameFunc returns the new name, false if the element holding the name should be removed
+type mapNameFunc func(name string) (string, bool)
+
+func toBroker(mapper NameMapper) mapNameFunc {
+	return func(name string) (string, bool) {
+		return mapper.ToBroker(name), true
+	}
+}
+
+// toClient keeps the names not visible to the clients unchanged
+func toClient(mapper NameMapper) mapNameFunc {
+	return func(name string) (string, bool) {
+		if newName, ok := mapper.ToClient(name); ok {
+			return newName, true
+		}
+		return name, true
+	}
+}
+
+// toVisibleClient removes the elements not visible to the clients
+func toVisibleClient(mapper NameMapper) mapNameFunc {
+	return mapper.ToClient
+}
+
+// namePath is the path to a string field or to an array of strings e.g. {"topics", "topic"}.
+// All but the last element are names of arrays of structs.
+type namePath []string
+
+// mapNames applies fn to the names found on the path. An array element is removed if fn returns false for its name.
+func mapNames(s *Struct, path namePath, fn mapNameFunc) (bool, error) {
+	if len(path) == 0 {
+		return false, fmt.Errorf("empty name path in struct %s", s.schema.name)
+	}
+	value := s.Get(path[0])
+	switch v := value.(type) {
+	case string:
+		if len(path) != 1 {
+			return false, fmt.Errorf("field %s in struct %s is not an array", path[0], s.schema.name)
+		}
+		newName, ok := fn(v)
+		if !ok {
+			return false, nil
+		}
+		if newName != v {
+			if err := s.Replace(path[0], newName); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case []interface{}:
+		if v == nil {
+			// null array
+			return true, nil
+		}
+		result := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			switch e := elem.(type) {
+			case string:
+				if len(path) != 1 {
+					return false, fmt.Errorf("field %s in struct %s is an array of strings", path[0], s.schema.name)
+				}
+				if newName, ok := fn(e); ok {
+					result = append(result, newName)
+				}
+			case *Struct:
+				keep, err := mapNames(e, path[1:], fn)
+				if err != nil {
+					return false, err
+				}
+				if keep {
+					result = append(result, e)
+				}
+			default:
+				return false, fmt.Errorf("unexpected element %T of array %s in struct %s", elem, path[0], s.schema.name)
+			}
+		}
+		if len(result) != len(v) || len(path) == 1 {
+			if err := s.Replace(path[0], result); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("field %s in struct %s not found", path[0], s.schema.name)
+	}
+}
+
+// apiNames describes where the topic and group names are found in the requests and responses of an api key
+type apiNames struct {
+	requestSchemas  []Schema
+	responseSchemas []Schema
+
+	topicRequestPaths  []namePath
+	topicResponsePaths []namePath
+	groupRequestPaths  []namePath
+	groupResponsePaths []namePath
+	// names not visible to the client are removed from the responses e.g. when all topics were requested
+	hideTopicsInResponse bool
+	hideGroupsInResponse bool
+	// groupRequestCondition reports whether the group paths apply to the decoded request
+	groupRequestCondition func(s *Struct) bool
+}
+
+var namesByApiKey = map[int16]apiNames{
+	apiKeyProduce: {
+		requestSchemas:     createProduceRequestSchemaVersions(),
+		responseSchemas:    createProduceResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topic_data", "topic"}},
+		topicResponsePaths: []namePath{{"responses", "topic"}},
+	},
+	apiKeyFetch: {
+		requestSchemas:     createFetchRequestSchemaVersions(),
+		responseSchemas:    createFetchResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topics", "topic"}, {"forgotten_topics_data", "topic"}},
+		topicResponsePaths: []namePath{{"responses", "topic"}},
+	},
+	apiKeyListOffsets: {
+		requestSchemas:     createListOffsetsRequestSchemaVersions(),
+		responseSchemas:    createListOffsetsResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topics", "topic"}},
+		topicResponsePaths: []namePath{{"responses", "topic"}},
+	},
+	apiKeyMetadata: {
+		requestSchemas:       createMetadataRequestSchemaVersions(),
+		responseSchemas:      metadataResponseSchemaVersions,
+		topicRequestPaths:    []namePath{{"topics"}},
+		topicResponsePaths:   []namePath{{"topic_metadata", "topic"}},
+		hideTopicsInResponse: true,
+	},
+	apiKeyOffsetCommit: {
+		requestSchemas:     createOffsetCommitRequestSchemaVersions(),
+		responseSchemas:    createOffsetCommitResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topics", "topic"}},
+		topicResponsePaths: []namePath{{"responses", "topic"}},
+		groupRequestPaths:  []namePath{{"group_id"}},
+	},
+	apiKeyOffsetFetch: {
+		requestSchemas:       createOffsetFetchRequestSchemaVersions(),
+		responseSchemas:      createOffsetFetchResponseSchemaVersions(),
+		topicRequestPaths:    []namePath{{"topics", "topic"}},
+		topicResponsePaths:   []namePath{{"responses", "topic"}},
+		hideTopicsInResponse: true,
+		groupRequestPaths:    []namePath{{"group_id"}},
+	},
+	apiKeyFindCoordinator: {
+		requestSchemas:        createFindCoordinatorRequestSchemaVersions(),
+		groupRequestPaths:     []namePath{{"key"}},
+		groupRequestCondition: isGroupCoordinatorKey,
+	},
+	apiKeyJoinGroup: {
+		requestSchemas:    createJoinGroupRequestSchemaVersions(),
+		groupRequestPaths: []namePath{{"group_id"}},
+	},
+	apiKeyHeartbeat: {
+		requestSchemas:    createHeartbeatRequestSchemaVersions(),
+		groupRequestPaths: []namePath{{"group_id"}},
+	},
+	apiKeyLeaveGroup: {
+		requestSchemas:    createLeaveGroupRequestSchemaVersions(),
+		groupRequestPaths: []namePath{{"group_id"}},
+	},
+	apiKeySyncGroup: {
+		requestSchemas:    createSyncGroupRequestSchemaVersions(),
+		groupRequestPaths: []namePath{{"group_id"}},
+	},
+	apiKeyDescribeGroups: {
+		requestSchemas:     createDescribeGroupsRequestSchemaVersions(),
+		responseSchemas:    createDescribeGroupsResponseSchemaVersions(),
+		groupRequestPaths:  []namePath{{"groups"}},
+		groupResponsePaths: []namePath{{"groups", "group_id"}},
+	},
+	apiKeyListGroups: {
+		requestSchemas:       createListGroupsRequestSchemaVersions(),
+		responseSchemas:      createListGroupsResponseSchemaVersions(),
+		groupResponsePaths:   []namePath{{"groups", "group_id"}},
+		hideGroupsInResponse: true,
+	},
+	apiKeyDeleteGroups: {
+		requestSchemas:     createDeleteGroupsRequestSchemaVersions(),
+		responseSchemas:    createDeleteGroupsResponseSchemaVersions(),
+		groupRequestPaths:  []namePath{{"groups_names"}},
+		groupResponsePaths: []namePath{{"results", "group_id"}},
+	},
+	apiKeyAddPartitionsToTxn: {
+		requestSchemas:     createAddPartitionsToTxnRequestSchemaVersions(),
+		responseSchemas:    createAddPartitionsToTxnResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topics", "name"}},
+		topicResponsePaths: []namePath{{"results", "name"}},
+	},
+	apiKeyAddOffsetsToTxn: {
+		requestSchemas:    createAddOffsetsToTxnRequestSchemaVersions(),
+		groupRequestPaths: []namePath{{"group_id"}},
+	},
+	apiKeyTxnOffsetCommit: {
+		requestSchemas:     createTxnOffsetCommitRequestSchemaVersions(),
+		responseSchemas:    createTxnOffsetCommitResponseSchemaVersions(),
+		topicRequestPaths:  []namePath{{"topics", "name"}},
+		topicResponsePaths: []namePath{{"topics", "name"}},
+		groupRequestPaths:  []namePath{{"group_id"}},
+	},
+}
+
+// TopicNamesMaxVersion returns the highest version of the api key for which the topic names can be rewritten.
+// False is returned if the api key does not carry topic names which can be rewritten.
+func TopicNamesMaxVersion(apiKey int16) (int16, bool) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok || len(names.topicRequestPaths) == 0 {
+		return 0, false
+	}
+	return int16(len(names.requestSchemas) - 1), true
+}
+
+// DecodeRequestTopicNames returns the topic names of the request. Nil is returned if the request does not carry topic names.
+func DecodeRequestTopicNames(apiKey int16, apiVersion int16, body []byte) ([]string, error) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok || len(names.topicRequestPaths) == 0 {
+		return nil, nil
+	}
+	schema, err := getRequestSchema(apiKey, apiVersion, names.requestSchemas)
+	if err != nil {
+		return nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	if decodedStruct == nil {
+		return nil, errors.New("decoded struct must not be nil")
+	}
+	topics := make([]string, 0)
+	if err = collectNames(decodedStruct, names.topicRequestPaths, &topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// RequestNamesMaxVersion returns the highest version of the api key for which the topic and group names of the request can be decoded.
+// False is returned if the request does not carry topic or group names.
+func RequestNamesMaxVersion(apiKey int16) (int16, bool) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok || (len(names.topicRequestPaths) == 0 && len(names.groupRequestPaths) == 0) {
+		return 0, false
+	}
+	return int16(len(names.requestSchemas) - 1), true
+}
+
+// DecodeRequestNames returns the topic and group names of the request. Nil is returned if the request does not carry the names.
+func DecodeRequestNames(apiKey int16, apiVersion int16, body []byte) (topics []string, groups []string, err error) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok || (len(names.topicRequestPaths) == 0 && len(names.groupRequestPaths) == 0) {
+		return nil, nil, nil
+	}
+	schema, err := getRequestSchema(apiKey, apiVersion, names.requestSchemas)
+	if err != nil {
+		return nil, nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	if decodedStruct == nil {
+		return nil, nil, errors.New("decoded struct must not be nil")
+	}
+	if err = collectNames(decodedStruct, names.topicRequestPaths, &topics); err != nil {
+		return nil, nil, err
+	}
+	if names.groupRequestCondition == nil || names.groupRequestCondition(decodedStruct) {
+		if err = collectNames(decodedStruct, names.groupRequestPaths, &groups); err != nil {
+			return nil, nil, err
+		}
+	}
+	return topics, groups, nil
+}
+
+// collectNames appends the names found on the paths of the decoded struct
+func collectNames(s *Struct, paths []namePath, names *[]string) error {
+	collect := func(name string) (string, bool) {
+		*names = append(*names, name)
+		return name, true
+	}
+	for _, path := range paths {
+		// some arrays are not present in all versions
+		if s.Get(path[0]) == nil {
+			continue
+		}
+		if _, err := mapNames(s, path, collect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupNamesMaxVersion returns the highest version of the api key for which the group names can be rewritten.
+// False is returned if the api key does not carry group names which can be rewritten.
+func GroupNamesMaxVersion(apiKey int16) (int16, bool) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok || (len(names.groupRequestPaths) == 0 && len(names.groupResponsePaths) == 0) {
+		return 0, false
+	}
+	return int16(len(names.requestSchemas) - 1), true
+}
+
+// RequestModifier modifies the request body which follows the request header
+type RequestModifier interface {
+	Apply(req []byte) ([]byte, error)
+}
+
+type requestModifiers []RequestModifier
+
+func (m requestModifiers) Apply(req []byte) ([]byte, error) {
+	var err error
+	for _, modifier := range m {
+		if req, err = modifier.Apply(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// ChainRequestModifiers returns the modifier applying the non nil modifiers in order. Nil is returned if all modifiers are nil.
+func ChainRequestModifiers(modifiers ...RequestModifier) RequestModifier {
+	result := make(requestModifiers, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		if modifier != nil {
+			result = append(result, modifier)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	default:
+		return result
+	}
+}
+
+type namesMapping struct {
+	paths     []namePath
+	fn        mapNameFunc
+	condition func(s *Struct) bool
+}
+
+type namesModifier struct {
+	schema   Schema
+	mappings []namesMapping
+}
+
+func (f *namesModifier) Apply(buf []byte) ([]byte, error) {
+	decodedStruct, err := DecodeSchema(buf, f.schema)
+	if err != nil {
+		return nil, err
+	}
+	if decodedStruct == nil {
+		return nil, errors.New("decoded struct must not be nil")
+	}
+	for _, mapping := range f.mappings {
+		if mapping.condition != nil && !mapping.condition(decodedStruct) {
+			continue
+		}
+		for _, path := range mapping.paths {
+			// some arrays are not present in all versions
+			if decodedStruct.Get(path[0]) == nil {
+				continue
+			}
+			if _, err = mapNames(decodedStruct, path, mapping.fn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return EncodeSchema(decodedStruct, f.schema)
+}
+
+// GetNamesRequestModifier returns the modifier which maps the topic and group names in the request to the names used on the brokers.
+// Nil mapper disables the mapping of the names. Nil is returned if the request does not carry names to map.
+func GetNamesRequestModifier(apiKey int16, apiVersion int16, topics NameMapper, groups NameMapper) (RequestModifier, error) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok {
+		return nil, nil
+	}
+	mappings := make([]namesMapping, 0, 2)
+	if topics != nil && len(names.topicRequestPaths) != 0 {
+		mappings = append(mappings, namesMapping{paths: names.topicRequestPaths, fn: toBroker(topics)})
+	}
+	if groups != nil && len(names.groupRequestPaths) != 0 {
+		mappings = append(mappings, namesMapping{paths: names.groupRequestPaths, fn: toBroker(groups), condition: names.groupRequestCondition})
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	schema, err := getRequestSchema(apiKey, apiVersion, names.requestSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &namesModifier{schema: schema, mappings: mappings}, nil
+}
+
+// GetNamesResponseModifier returns the modifier which maps the topic and group names in the response to the names seen by the clients.
+// Nil mapper disables the mapping of the names. Nil is returned if the response does not carry names to map.
+func GetNamesResponseModifier(apiKey int16, apiVersion int16, topics NameMapper, groups NameMapper) (ResponseModifier, error) {
+	names, ok := namesByApiKey[apiKey]
+	if !ok {
+		return nil, nil
+	}
+	mappings := make([]namesMapping, 0, 2)
+	if topics != nil && len(names.topicResponsePaths) != 0 {
+		fn := toClient(topics)
+		if names.hideTopicsInResponse {
+			fn = toVisibleClient(topics)
+		}
+		mappings = append(mappings, namesMapping{paths: names.topicResponsePaths, fn: fn})
+	}
+	if groups != nil && len(names.groupResponsePaths) != 0 {
+		fn := toClient(groups)
+		if names.hideGroupsInResponse {
+			fn = toVisibleClient(groups)
+		}
+		mappings = append(mappings, namesMapping{paths: names.groupResponsePaths, fn: fn})
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	schema, err := getResponseSchema(apiKey, apiVersion, names.responseSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &namesModifier{schema: schema, mappings: mappings}, nil
+}
+
+func getRequestSchema(apiKey, apiVersion int16, schemas []Schema) (Schema, error) {
+	if apiVersion < 0 || int(apiVersion) >= len(schemas) {
+		return nil, fmt.Errorf("Unsupported request schema version %d for key %d ", apiVersion, apiKey)
+	}
+	return schemas[apiVersion], nil
+}
@@ -10,10 +10,12 @@ type packetEncoder interface {
 	putInt32(in int32)
 	putInt64(in int64)
 	putVarint(in int64)
+	putUVarint(in uint64)
 	putArrayLength(in int) error
 	putBool(in bool)
 
 	putBytes(in []byte) error
+	putRawBytes(in []byte) error
 	putString(in string) error
 	putNullableString(in *string) error
 	putStringArray(in []string) error
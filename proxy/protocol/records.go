@@ -0,0 +1,788 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	recordBatchMagic = 2
+
+	compressionCodecMask = 0x07
+	controlBatchMask     = 0x20
+	compressionNone      = 0
+	compressionGZIP      = 1
+
+	// baseOffset, batchLength, partitionLeaderEpoch, magic, crc, attributes, lastOffsetDelta, firstTimestamp, maxTimestamp,
+	// producerId, producerEpoch, baseSequence, records count
+	recordBatchHeaderSize = 8 + 4 + 4 + 1 + 4 + 2 + 4 + 8 + 8 + 8 + 2 + 4 + 4
+	// offset, message size
+	messageSetEntryHeaderSize = 8 + 4
+	// record batches and message sets have the magic byte at the same position
+	magicOffset = 16
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RecordValueFunc is called with the value of each record, nil value is a tombstone
+type RecordValueFunc func(value []byte) error
+
+// ProduceRecordValueFunc is called with the topic name and the value of each produced record
+type ProduceRecordValueFunc func(topic string, value []byte) error
+
+// DecodeProduceRecordValues calls fn with the values of the records in the Produce request body
+func DecodeProduceRecordValues(apiVersion int16, body []byte, maxDecompressedSize int, fn ProduceRecordValueFunc) error {
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return err
+	}
+	return walkRecordSets(decodedStruct, "topic_data", "data", func(topic string, partition *Struct, recordSet []byte) error {
+		var fnErr error
+		err := DecodeRecordValues(recordSet, maxDecompressedSize, func(value []byte) error {
+			fnErr = fn(topic, value)
+			return fnErr
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		if err != nil {
+			return fmt.Errorf("records of topic %s: %v", topic, err)
+		}
+		return nil
+	})
+}
+
+// RecordBatchFunc is called with the compression codec and the size of each record batch or message set entry
+type RecordBatchFunc func(topic string, codec int8, size int) error
+
+// DecodeProduceRecordBatches calls fn for each record batch of the Produce request, the records are not decompressed
+func DecodeProduceRecordBatches(apiVersion int16, body []byte, fn RecordBatchFunc) error {
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return err
+	}
+	return walkRecordSets(decodedStruct, "topic_data", "data", func(topic string, partition *Struct, recordSet []byte) error {
+		for len(recordSet) > magicOffset {
+			var (
+				codec int8
+				size  int
+			)
+			if recordSet[magicOffset] == recordBatchMagic {
+				if size, err = recordBatchSize(recordSet); err != nil {
+					return err
+				}
+				codec = int8(binary.BigEndian.Uint16(recordSet[21:]) & compressionCodecMask)
+			} else {
+				// offset, message size, crc, magic, attributes
+				if len(recordSet) < messageSetEntryHeaderSize+6 {
+					return nil
+				}
+				messageSize := int(int32(binary.BigEndian.Uint32(recordSet[8:])))
+				if messageSize < 0 {
+					return fmt.Errorf("invalid message size %d", messageSize)
+				}
+				if size = messageSetEntryHeaderSize + messageSize; len(recordSet) < size {
+					size = 0
+				}
+				codec = int8(recordSet[messageSetEntryHeaderSize+5] & compressionCodecMask)
+			}
+			if size == 0 {
+				// partial entry at the end of the set
+				return nil
+			}
+			if err = fn(topic, codec, size); err != nil {
+				return err
+			}
+			recordSet = recordSet[size:]
+		}
+		return nil
+	})
+}
+
+// RecordBatchStatsFunc is called with the size and the number of records of each record batch or message set entry.
+// The records of compressed message sets are not counted, the entry counts as one record.
+type RecordBatchStatsFunc func(topic string, size int, records int)
+
+// DecodeProduceRecordBatchStats calls fn for each record batch of the Produce request, the records are not decompressed
+func DecodeProduceRecordBatchStats(apiVersion int16, body []byte, fn RecordBatchStatsFunc) error {
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return err
+	}
+	return walkRecordSets(decodedStruct, "topic_data", "data", func(topic string, partition *Struct, recordSet []byte) error {
+		return recordSetStats(topic, recordSet, fn)
+	})
+}
+
+// DecodeFetchRecordBatchStats calls fn for each complete record batch of the Fetch response, the records are not decompressed
+func DecodeFetchRecordBatchStats(apiVersion int16, body []byte, fn RecordBatchStatsFunc) error {
+	schema, err := getResponseSchema(apiKeyFetch, apiVersion, namesByApiKey[apiKeyFetch].responseSchemas)
+	if err != nil {
+		return err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return err
+	}
+	return walkRecordSets(decodedStruct, "responses", "partition_responses", func(topic string, partition *Struct, recordSet []byte) error {
+		return recordSetStats(topic, recordSet, fn)
+	})
+}
+
+func recordSetStats(topic string, recordSet []byte, fn RecordBatchStatsFunc) error {
+	for len(recordSet) > magicOffset {
+		var (
+			size    int
+			records int
+			err     error
+		)
+		if recordSet[magicOffset] == recordBatchMagic {
+			if size, err = recordBatchSize(recordSet); err != nil {
+				return err
+			}
+			if size > 0 {
+				records = int(int32(binary.BigEndian.Uint32(recordSet[recordBatchHeaderSize-4:])))
+			}
+		} else {
+			if len(recordSet) < messageSetEntryHeaderSize {
+				return nil
+			}
+			messageSize := int(int32(binary.BigEndian.Uint32(recordSet[8:])))
+			if messageSize < 0 {
+				return fmt.Errorf("invalid message size %d", messageSize)
+			}
+			if size = messageSetEntryHeaderSize + messageSize; len(recordSet) < size {
+				size = 0
+			}
+			records = 1
+		}
+		if size == 0 {
+			// partial entry at the end of the set
+			return nil
+		}
+		fn(topic, size, records)
+		recordSet = recordSet[size:]
+	}
+	return nil
+}
+
+type recordSetFunc func(topic string, partition *Struct, recordSet []byte) error
+
+// walkRecordSets calls fn with the record set of each partition of the Produce request or Fetch response
+func walkRecordSets(s *Struct, topicsField string, partitionsField string, fn recordSetFunc) error {
+	topics, ok := s.Get(topicsField).([]interface{})
+	if !ok {
+		return fmt.Errorf("%s array not found", topicsField)
+	}
+	for _, elem := range topics {
+		topic, ok := elem.(*Struct)
+		if !ok {
+			return fmt.Errorf("unexpected %s element %T", topicsField, elem)
+		}
+		name, ok := topic.Get("topic").(string)
+		if !ok {
+			return errors.New("topic name not found")
+		}
+		partitions, ok := topic.Get(partitionsField).([]interface{})
+		if !ok {
+			return fmt.Errorf("%s of topic %s not found", partitionsField, name)
+		}
+		for _, p := range partitions {
+			partition, ok := p.(*Struct)
+			if !ok {
+				return fmt.Errorf("unexpected %s element %T", partitionsField, p)
+			}
+			recordSet, _ := partition.Get("record_set").([]byte)
+			if err := fn(name, partition, recordSet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeRecordValues calls fn with the values of the records in the record set.
+// Record batches (magic 2) and message sets (magic 0 and 1) are supported, the only supported compression is gzip.
+func DecodeRecordValues(recordSet []byte, maxDecompressedSize int, fn RecordValueFunc) error {
+	for len(recordSet) > 0 {
+		if len(recordSet) <= magicOffset {
+			// partial entry at the end of the set
+			return nil
+		}
+		var (
+			size int
+			err  error
+		)
+		if recordSet[magicOffset] == recordBatchMagic {
+			size, err = decodeRecordBatch(recordSet, maxDecompressedSize, fn)
+		} else {
+			size, err = decodeMessageSetEntry(recordSet, maxDecompressedSize, fn)
+		}
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		recordSet = recordSet[size:]
+	}
+	return nil
+}
+
+// recordBatchSize returns the size of the record batch, 0 if the batch is not complete
+func recordBatchSize(buf []byte) (int, error) {
+	if len(buf) < recordBatchHeaderSize {
+		return 0, nil
+	}
+	batchLength := int(int32(binary.BigEndian.Uint32(buf[8:])))
+	if batchLength < recordBatchHeaderSize-12 {
+		return 0, fmt.Errorf("invalid record batch length %d", batchLength)
+	}
+	size := 8 + 4 + batchLength
+	if len(buf) < size {
+		return 0, nil
+	}
+	return size, nil
+}
+
+// recordBatchRecords returns the decompressed records of the complete batch and the number of records
+func recordBatchRecords(batch []byte, maxDecompressedSize int) ([]byte, int, error) {
+	attributes := binary.BigEndian.Uint16(batch[21:])
+	count := int(int32(binary.BigEndian.Uint32(batch[recordBatchHeaderSize-4:])))
+	records := batch[recordBatchHeaderSize:]
+
+	codec := attributes & compressionCodecMask
+	switch codec {
+	case compressionNone:
+		return records, count, nil
+	case compressionGZIP:
+		records, err := gunzip(records, maxDecompressedSize)
+		return records, count, err
+	default:
+		return nil, 0, fmt.Errorf("record batch compression codec %d is not supported", codec)
+	}
+}
+
+// decodeRecordBatch returns the size of the batch, 0 if the batch is not complete
+func decodeRecordBatch(buf []byte, maxDecompressedSize int, fn RecordValueFunc) (int, error) {
+	size, err := recordBatchSize(buf)
+	if err != nil || size == 0 {
+		return 0, err
+	}
+	records, count, err := recordBatchRecords(buf[:size], maxDecompressedSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		r, err := splitRecord(records)
+		if err != nil {
+			return 0, err
+		}
+		if err = fn(r.value); err != nil {
+			return 0, err
+		}
+		records = records[r.size:]
+	}
+	return size, nil
+}
+
+// record is a record of the record batch split around the value
+type record struct {
+	// attributes, timestampDelta, offsetDelta and key
+	prefix  []byte
+	value   []byte
+	headers []byte
+	// size of the record including the length
+	size int
+}
+
+func splitRecord(buf []byte) (record, error) {
+	length, n := binary.Varint(buf)
+	if n <= 0 || length < 0 || int64(len(buf)-n) < length {
+		return record{}, errors.New("invalid record length")
+	}
+	size := n + int(length)
+	body := buf[n:size]
+	// attributes
+	if len(body) < 1 {
+		return record{}, errors.New("invalid record")
+	}
+	rest := body[1:]
+	// timestampDelta, offsetDelta
+	for i := 0; i < 2; i++ {
+		if _, n = binary.Varint(rest); n <= 0 {
+			return record{}, errors.New("invalid record")
+		}
+		rest = rest[n:]
+	}
+	// key
+	if _, rest, n = varintBytes(rest); n <= 0 {
+		return record{}, errors.New("invalid record key")
+	}
+	prefix := body[:len(body)-len(rest)]
+	value, headers, n := varintBytes(rest)
+	if n <= 0 {
+		return record{}, errors.New("invalid record value")
+	}
+	return record{prefix: prefix, value: value, headers: headers, size: size}, nil
+}
+
+// varintBytes returns the bytes prefixed with varint length, the rest of the buffer and the number of read bytes (<= 0 on error)
+func varintBytes(buf []byte) ([]byte, []byte, int) {
+	length, n := binary.Varint(buf)
+	if n <= 0 {
+		return nil, nil, n
+	}
+	if length < 0 {
+		return nil, buf[n:], n
+	}
+	if int64(len(buf)-n) < length {
+		return nil, nil, -1
+	}
+	end := n + int(length)
+	return buf[n:end], buf[end:], end
+}
+
+// decodeMessageSetEntry returns the size of the entry, 0 if the entry is not complete
+func decodeMessageSetEntry(buf []byte, maxDecompressedSize int, fn RecordValueFunc) (int, error) {
+	messageSize := int(int32(binary.BigEndian.Uint32(buf[8:])))
+	size := messageSetEntryHeaderSize + messageSize
+	if messageSize < 0 {
+		return 0, fmt.Errorf("invalid message size %d", messageSize)
+	}
+	if len(buf) < size {
+		return 0, nil
+	}
+	message := buf[messageSetEntryHeaderSize:size]
+	// crc, magic, attributes
+	if len(message) < 6 {
+		return 0, errors.New("invalid message")
+	}
+	magic := message[4]
+	attributes := message[5]
+	message = message[6:]
+	if magic == 1 {
+		// timestamp
+		if len(message) < 8 {
+			return 0, errors.New("invalid message")
+		}
+		message = message[8:]
+	} else if magic != 0 {
+		return 0, fmt.Errorf("unsupported message magic %d", magic)
+	}
+	// key
+	_, message, ok := int32Bytes(message)
+	if !ok {
+		return 0, errors.New("invalid message key")
+	}
+	value, _, ok := int32Bytes(message)
+	if !ok {
+		return 0, errors.New("invalid message value")
+	}
+
+	codec := attributes & compressionCodecMask
+	switch codec {
+	case compressionNone:
+		return size, fn(value)
+	case compressionGZIP:
+		// the value is a message set of the inner messages
+		inner, err := gunzip(value, maxDecompressedSize)
+		if err != nil {
+			return 0, err
+		}
+		return size, DecodeRecordValues(inner, maxDecompressedSize, fn)
+	default:
+		return 0, fmt.Errorf("message compression codec %d is not supported", codec)
+	}
+}
+
+// int32Bytes returns the bytes prefixed with int32 length and the rest of the buffer
+func int32Bytes(buf []byte) ([]byte, []byte, bool) {
+	if len(buf) < 4 {
+		return nil, nil, false
+	}
+	length := int(int32(binary.BigEndian.Uint32(buf)))
+	buf = buf[4:]
+	if length < 0 {
+		return nil, buf, true
+	}
+	if len(buf) < length {
+		return nil, nil, false
+	}
+	return buf[:length], buf[length:], true
+}
+
+// gunzip limits the decompressed data to maxDecompressedSize to protect against compression bombs
+func gunzip(buf []byte, maxDecompressedSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	result, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed records are larger than %d bytes", maxDecompressedSize)
+	}
+	return result, nil
+}
+
+// RecordTransformFunc returns the new value of the record produced to or fetched from the topic, nil value is a tombstone
+type RecordTransformFunc func(topic string, value []byte) ([]byte, error)
+
+// RecordBatchVersions returns the versions of Produce or Fetch which carry record batches (magic 2) the proxy can transform.
+// False is returned for other api keys.
+func RecordBatchVersions(apiKey int16) (minVersion int16, maxVersion int16, ok bool) {
+	switch apiKey {
+	case apiKeyProduce:
+		return 3, int16(len(namesByApiKey[apiKeyProduce].requestSchemas) - 1), true
+	case apiKeyFetch:
+		return 4, int16(len(namesByApiKey[apiKeyFetch].responseSchemas) - 1), true
+	default:
+		return 0, 0, false
+	}
+}
+
+// recordSetModifyFunc returns the new record set of the topic partition
+type recordSetModifyFunc func(topic string, recordSet []byte) ([]byte, error)
+
+type recordsModifier struct {
+	schema          Schema
+	topicsField     string
+	partitionsField string
+	fn              recordSetModifyFunc
+}
+
+func (m *recordsModifier) Apply(buf []byte) ([]byte, error) {
+	decodedStruct, err := DecodeSchema(buf, m.schema)
+	if err != nil {
+		return nil, err
+	}
+	if decodedStruct == nil {
+		return nil, errors.New("decoded struct must not be nil")
+	}
+	err = walkRecordSets(decodedStruct, m.topicsField, m.partitionsField, func(topic string, partition *Struct, recordSet []byte) error {
+		if len(recordSet) == 0 {
+			return nil
+		}
+		newRecordSet, err := m.fn(topic, recordSet)
+		if err != nil {
+			return fmt.Errorf("records of topic %s: %v", topic, err)
+		}
+		return partition.Replace("record_set", newRecordSet)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSchema(decodedStruct, m.schema)
+}
+
+// GetProduceRecordsModifier returns the modifier which transforms the record values of the Produce request
+func GetProduceRecordsModifier(apiVersion int16, maxDecompressedSize int, fn RecordTransformFunc) (RequestModifier, error) {
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &recordsModifier{schema: schema, topicsField: "topic_data", partitionsField: "data", fn: func(topic string, recordSet []byte) ([]byte, error) {
+		return transformRecordValues(recordSet, false, maxDecompressedSize, func(value []byte) ([]byte, error) {
+			return fn(topic, value)
+		})
+	}}, nil
+}
+
+// GetFetchRecordsModifier returns the modifier which transforms the record values of the Fetch response.
+// Message sets e.g. of topics with an old message format are returned unchanged.
+func GetFetchRecordsModifier(apiVersion int16, maxDecompressedSize int, fn RecordTransformFunc) (ResponseModifier, error) {
+	schema, err := getResponseSchema(apiKeyFetch, apiVersion, namesByApiKey[apiKeyFetch].responseSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &recordsModifier{schema: schema, topicsField: "responses", partitionsField: "partition_responses", fn: func(topic string, recordSet []byte) ([]byte, error) {
+		return transformRecordValues(recordSet, true, maxDecompressedSize, func(value []byte) ([]byte, error) {
+			return fn(topic, value)
+		})
+	}}, nil
+}
+
+// GetProduceRecompressModifier returns the modifier which recompresses the record batches of the Produce request with the codec.
+// Message sets are copied unchanged.
+func GetProduceRecompressModifier(apiVersion int16, codec int8, gzipLevel int, maxDecompressedSize int) (RequestModifier, error) {
+	if err := checkRecompressCodec(codec); err != nil {
+		return nil, err
+	}
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &recordsModifier{schema: schema, topicsField: "topic_data", partitionsField: "data", fn: func(topic string, recordSet []byte) ([]byte, error) {
+		return recompressRecordBatches(recordSet, codec, gzipLevel, maxDecompressedSize)
+	}}, nil
+}
+
+// GetFetchRecompressModifier returns the modifier which recompresses the record batches of the Fetch response with the codec.
+// Message sets are copied unchanged.
+func GetFetchRecompressModifier(apiVersion int16, codec int8, gzipLevel int, maxDecompressedSize int) (ResponseModifier, error) {
+	if err := checkRecompressCodec(codec); err != nil {
+		return nil, err
+	}
+	schema, err := getResponseSchema(apiKeyFetch, apiVersion, namesByApiKey[apiKeyFetch].responseSchemas)
+	if err != nil {
+		return nil, err
+	}
+	return &recordsModifier{schema: schema, topicsField: "responses", partitionsField: "partition_responses", fn: func(topic string, recordSet []byte) ([]byte, error) {
+		return recompressRecordBatches(recordSet, codec, gzipLevel, maxDecompressedSize)
+	}}, nil
+}
+
+func checkRecompressCodec(codec int8) error {
+	if codec != compressionNone && codec != compressionGZIP {
+		return fmt.Errorf("record batches cannot be compressed with codec %d", codec)
+	}
+	return nil
+}
+
+// recompressRecordBatches returns the record set with the record batches compressed with the codec.
+// Control batches, batches compressed with snappy, lz4 or zstd, message sets and the partial batch at the end of the set are copied unchanged.
+func recompressRecordBatches(recordSet []byte, codec int8, gzipLevel int, maxDecompressedSize int) ([]byte, error) {
+	result := make([]byte, 0, len(recordSet))
+	for len(recordSet) > magicOffset && recordSet[magicOffset] == recordBatchMagic {
+		size, err := recordBatchSize(recordSet)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			break
+		}
+		batch, err := recompressRecordBatch(recordSet[:size], codec, gzipLevel, maxDecompressedSize)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, batch...)
+		recordSet = recordSet[size:]
+	}
+	return append(result, recordSet...), nil
+}
+
+// recompressRecordBatch returns the batch compressed with the codec. Only the gzip batches can be decompressed by the proxy,
+// the batches compressed with snappy, lz4 or zstd are copied unchanged.
+func recompressRecordBatch(batch []byte, codec int8, gzipLevel int, maxDecompressedSize int) ([]byte, error) {
+	attributes := binary.BigEndian.Uint16(batch[21:])
+	if attributes&controlBatchMask != 0 || int8(attributes&compressionCodecMask) == codec {
+		return batch, nil
+	}
+	if batchCodec := attributes & compressionCodecMask; batchCodec != compressionNone && batchCodec != compressionGZIP {
+		return batch, nil
+	}
+	records, _, err := recordBatchRecords(batch, maxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	if codec == compressionGZIP {
+		if records, err = gzipCompressLevel(records, gzipLevel); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]byte, 0, recordBatchHeaderSize+len(records))
+	result = append(result, batch[:recordBatchHeaderSize]...)
+	result = append(result, records...)
+	binary.BigEndian.PutUint16(result[21:], attributes&^compressionCodecMask|uint16(codec))
+	binary.BigEndian.PutUint32(result[8:], uint32(len(result)-12))
+	binary.BigEndian.PutUint32(result[17:], crc32.Checksum(result[21:], castagnoliTable))
+	return result, nil
+}
+
+// transformRecordValues returns the record set with the record values replaced by fn.
+// Control batches and the partial batch at the end of the set are copied unchanged.
+func transformRecordValues(recordSet []byte, copyMessageSets bool, maxDecompressedSize int, fn func(value []byte) ([]byte, error)) ([]byte, error) {
+	result := make([]byte, 0, len(recordSet))
+	for len(recordSet) > 0 {
+		if len(recordSet) <= magicOffset {
+			return append(result, recordSet...), nil
+		}
+		if magic := recordSet[magicOffset]; magic != recordBatchMagic {
+			if copyMessageSets {
+				return append(result, recordSet...), nil
+			}
+			return nil, fmt.Errorf("message set with magic %d cannot be transformed", magic)
+		}
+		size, err := recordBatchSize(recordSet)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return append(result, recordSet...), nil
+		}
+		batch, err := transformRecordBatch(recordSet[:size], maxDecompressedSize, fn)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, batch...)
+		recordSet = recordSet[size:]
+	}
+	return result, nil
+}
+
+func transformRecordBatch(batch []byte, maxDecompressedSize int, fn func(value []byte) ([]byte, error)) ([]byte, error) {
+	attributes := binary.BigEndian.Uint16(batch[21:])
+	if attributes&controlBatchMask != 0 {
+		return batch, nil
+	}
+	records, count, err := recordBatchRecords(batch, maxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	newRecords := make([]byte, 0, len(records))
+	for i := 0; i < count; i++ {
+		r, err := splitRecord(records)
+		if err != nil {
+			return nil, err
+		}
+		value, err := fn(r.value)
+		if err != nil {
+			return nil, err
+		}
+		body := make([]byte, 0, len(r.prefix)+binary.MaxVarintLen64+len(value)+len(r.headers))
+		body = append(body, r.prefix...)
+		body = appendVarintBytes(body, value)
+		body = append(body, r.headers...)
+
+		newRecords = appendVarint(newRecords, int64(len(body)))
+		newRecords = append(newRecords, body...)
+		records = records[r.size:]
+	}
+	if attributes&compressionCodecMask == compressionGZIP {
+		if newRecords, err = gzipCompress(newRecords); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]byte, 0, recordBatchHeaderSize+len(newRecords))
+	result = append(result, batch[:recordBatchHeaderSize]...)
+	result = append(result, newRecords...)
+	// batch length excludes base offset and the length, CRC-32C covers the data from attributes to the end
+	binary.BigEndian.PutUint32(result[8:], uint32(len(result)-12))
+	binary.BigEndian.PutUint32(result[17:], crc32.Checksum(result[21:], castagnoliTable))
+	return result, nil
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(tmp, v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarintBytes(buf []byte, v []byte) []byte {
+	if v == nil {
+		return appendVarint(buf, -1)
+	}
+	buf = appendVarint(buf, int64(len(v)))
+	return append(buf, v...)
+}
+
+func gzipCompress(buf []byte) ([]byte, error) {
+	return gzipCompressLevel(buf, gzip.DefaultCompression)
+}
+
+func gzipCompressLevel(buf []byte, level int) ([]byte, error) {
+	var b bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// PartitionLeaderFunc returns the node id of the partition leader, false if the leader is unknown
+type PartitionLeaderFunc func(topic string, partition int32) (int32, bool)
+
+// SplitProduceRequest splits the Produce request body by the partition leaders and sets acks to 0.
+// Partitions with unknown leaders are left out, the number of the left out partitions is returned.
+// Transactional requests are not split.
+func SplitProduceRequest(apiVersion int16, body []byte, fn PartitionLeaderFunc) (map[int32][]byte, int, error) {
+	schema, err := getRequestSchema(apiKeyProduce, apiVersion, namesByApiKey[apiKeyProduce].requestSchemas)
+	if err != nil {
+		return nil, 0, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, 0, err
+	}
+	if transactionalID, ok := decodedStruct.Get("transactional_id").(*string); ok && transactionalID != nil {
+		return nil, 0, errors.New("transactional produce request cannot be split")
+	}
+	topics, ok := decodedStruct.Get("topic_data").([]interface{})
+	if !ok {
+		return nil, 0, errors.New("topic_data array not found")
+	}
+	skipped := 0
+	topicsByLeader := make(map[int32][]interface{})
+	for _, elem := range topics {
+		topic, ok := elem.(*Struct)
+		if !ok {
+			return nil, 0, fmt.Errorf("unexpected topic_data element %T", elem)
+		}
+		name, ok := topic.Get("topic").(string)
+		if !ok {
+			return nil, 0, errors.New("topic name not found")
+		}
+		partitions, ok := topic.Get("data").([]interface{})
+		if !ok {
+			return nil, 0, fmt.Errorf("data of topic %s not found", name)
+		}
+		partitionsByLeader := make(map[int32][]interface{})
+		for _, p := range partitions {
+			partition, ok := p.(*Struct)
+			if !ok {
+				return nil, 0, fmt.Errorf("unexpected data element %T", p)
+			}
+			id, _ := partition.Get("partition").(int32)
+			leader, ok := fn(name, id)
+			if !ok {
+				skipped++
+				continue
+			}
+			partitionsByLeader[leader] = append(partitionsByLeader[leader], partition)
+		}
+		for leader, leaderPartitions := range partitionsByLeader {
+			topicsByLeader[leader] = append(topicsByLeader[leader], &Struct{schema: topic.schema, values: []interface{}{name, leaderPartitions}})
+		}
+	}
+	if err = decodedStruct.Replace("acks", int16(0)); err != nil {
+		return nil, 0, err
+	}
+	result := make(map[int32][]byte, len(topicsByLeader))
+	for leader, leaderTopics := range topicsByLeader {
+		if err = decodedStruct.Replace("topic_data", leaderTopics); err != nil {
+			return nil, 0, err
+		}
+		if result[leader], err = EncodeSchema(decodedStruct, schema); err != nil {
+			return nil, 0, err
+		}
+	}
+	return result, skipped, nil
+}
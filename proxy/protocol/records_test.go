@@ -0,0 +1,316 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"testing"
+)
+
+const testMaxDecompressedSize = 1024 * 1024
+
+func (m testMessage) varint(v int64) testMessage {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(b, v)
+	return append(m, b[:n]...)
+}
+
+func (m testMessage) varintBytes(v []byte) testMessage {
+	if v == nil {
+		return m.varint(-1)
+	}
+	return append(m.varint(int64(len(v))), v...)
+}
+
+func testRecord(key, value []byte) testMessage {
+	record := testMessage{}.int8(0).varint(0).varint(0).varintBytes(key).varintBytes(value).varint(0)
+	return testMessage{}.varintBytes(record)
+}
+
+func testRecordBatch(codec int16, records ...testMessage) testMessage {
+	var body testMessage
+	for _, r := range records {
+		body = append(body, r...)
+	}
+	if codec == compressionGZIP {
+		body = testGzip(body)
+	}
+	batch := testMessage{}.int32(0).int8(recordBatchMagic).int32(0).int16(codec).int32(int32(len(records) - 1)).
+		int64(0).int64(0).int64(-1).int16(-1).int32(-1).int32(int32(len(records)))
+	batch = append(batch, body...)
+	return append(testMessage{}.int64(0).int32(int32(len(batch))), batch...)
+}
+
+func testMessageSetEntry(codec int8, value []byte) testMessage {
+	message := testMessage{}.int32(0).int8(1).int8(codec).int64(0).int32(-1).bytes(value)
+	return append(testMessage{}.int64(0).int32(int32(len(message))), message...)
+}
+
+func testGzip(buf []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(buf)
+	w.Close()
+	return b.Bytes()
+}
+
+func collectRecordValues(a *assert.Assertions, recordSet []byte) [][]byte {
+	var values [][]byte
+	err := DecodeRecordValues(recordSet, testMaxDecompressedSize, func(value []byte) error {
+		values = append(values, value)
+		return nil
+	})
+	a.Nil(err)
+	return values
+}
+
+func TestDecodeRecordBatchValues(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(compressionNone, testRecord([]byte("k1"), []byte("v1")), testRecord(nil, nil))
+	recordSet = append(recordSet, testRecordBatch(compressionGZIP, testRecord(nil, []byte("v3")))...)
+
+	a.Equal([][]byte{[]byte("v1"), nil, []byte("v3")}, collectRecordValues(a, recordSet))
+}
+
+func TestDecodeMessageSetValues(t *testing.T) {
+	a := assert.New(t)
+
+	inner := append(testMessageSetEntry(compressionNone, []byte("v2")), testMessageSetEntry(compressionNone, []byte("v3"))...)
+	recordSet := append(testMessageSetEntry(compressionNone, []byte("v1")), testMessageSetEntry(compressionGZIP, testGzip(inner))...)
+
+	a.Equal([][]byte{[]byte("v1"), []byte("v2"), []byte("v3")}, collectRecordValues(a, recordSet))
+}
+
+func TestDecodeRecordBatchUnsupportedCompression(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(2, testRecord(nil, []byte("v1")))
+	err := DecodeRecordValues(recordSet, testMaxDecompressedSize, func(value []byte) error { return nil })
+	a.EqualError(err, "record batch compression codec 2 is not supported")
+}
+
+func TestDecodeProduceRecordValues(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(testRecordBatch(compressionNone, testRecord(nil, []byte("v1"))))
+
+	var topics []string
+	var values [][]byte
+	err := DecodeProduceRecordValues(3, req, testMaxDecompressedSize, func(topic string, value []byte) error {
+		topics = append(topics, topic)
+		values = append(values, value)
+		return nil
+	})
+	a.Nil(err)
+	a.Equal([]string{"orders"}, topics)
+	a.Equal([][]byte{[]byte("v1")}, values)
+}
+
+func TestDecodeRecordValuesLimitsDecompressedSize(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(compressionGZIP, testRecord(nil, make([]byte, 4096)))
+	err := DecodeRecordValues(recordSet, 1024, func(value []byte) error { return nil })
+	a.EqualError(err, "decompressed records are larger than 1024 bytes")
+
+	inner := testMessageSetEntry(compressionNone, make([]byte, 4096))
+	recordSet = testMessageSetEntry(compressionGZIP, testGzip(inner))
+	err = DecodeRecordValues(recordSet, 1024, func(value []byte) error { return nil })
+	a.EqualError(err, "decompressed records are larger than 1024 bytes")
+}
+
+func TestDecodeProduceRecordBatches(t *testing.T) {
+	a := assert.New(t)
+
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")))
+	compressed := testRecordBatch(compressionGZIP, testRecord(nil, []byte("v2")))
+	entry := testMessageSetEntry(compressionGZIP, testGzip(testMessageSetEntry(compressionNone, []byte("v3"))))
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(2).
+		str("orders").int32(1).int32(0).bytes(append(append(testMessage{}, batch...), compressed...)).
+		str("events").int32(1).int32(0).bytes(entry)
+
+	type recordBatch struct {
+		topic string
+		codec int8
+		size  int
+	}
+	var batches []recordBatch
+	err := DecodeProduceRecordBatches(3, req, func(topic string, codec int8, size int) error {
+		batches = append(batches, recordBatch{topic: topic, codec: codec, size: size})
+		return nil
+	})
+	a.Nil(err)
+	a.Equal([]recordBatch{
+		{topic: "orders", codec: compressionNone, size: len(batch)},
+		{topic: "orders", codec: compressionGZIP, size: len(compressed)},
+		{topic: "events", codec: compressionGZIP, size: len(entry)},
+	}, batches)
+}
+
+func TestDecodeRecordBatchStats(t *testing.T) {
+	a := assert.New(t)
+
+	type recordBatch struct {
+		topic   string
+		size    int
+		records int
+	}
+	var batches []recordBatch
+	fn := func(topic string, size int, records int) {
+		batches = append(batches, recordBatch{topic: topic, size: size, records: records})
+	}
+
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")), testRecord(nil, []byte("v2")))
+	compressed := testRecordBatch(compressionGZIP, testRecord(nil, []byte("v3")))
+	entry := testMessageSetEntry(compressionNone, []byte("v4"))
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(2).
+		str("orders").int32(1).int32(0).bytes(append(append(testMessage{}, batch...), compressed...)).
+		str("events").int32(1).int32(0).bytes(entry)
+	a.Nil(DecodeProduceRecordBatchStats(3, req, fn))
+	a.Equal([]recordBatch{
+		{topic: "orders", size: len(batch), records: 2},
+		{topic: "orders", size: len(compressed), records: 1},
+		{topic: "events", size: len(entry), records: 1},
+	}, batches)
+
+	// partial batch at the end of the fetched record set
+	batches = nil
+	recordSet := append(append(testMessage{}, batch...), batch[:30]...)
+	resp := testMessage{}.int32(0).int32(1).str("orders").int32(1).
+		int32(0).int16(0).int64(100).int64(100).int32(-1).bytes(recordSet)
+	a.Nil(DecodeFetchRecordBatchStats(4, resp, fn))
+	a.Equal([]recordBatch{{topic: "orders", size: len(batch), records: 2}}, batches)
+
+	_, maxVersion, _ := RecordBatchVersions(apiKeyFetch)
+	a.NotNil(DecodeFetchRecordBatchStats(maxVersion+1, resp, fn))
+}
+
+func TestTransformRecordBatchValues(t *testing.T) {
+	a := assert.New(t)
+
+	upper := func(value []byte) ([]byte, error) {
+		if value == nil {
+			return nil, nil
+		}
+		return bytes.ToUpper(value), nil
+	}
+	recordSet := testRecordBatch(compressionNone, testRecord([]byte("k1"), []byte("v1")), testRecord(nil, nil))
+	recordSet = append(recordSet, testRecordBatch(compressionGZIP, testRecord(nil, []byte("value-3")))...)
+	// partial batch at the end of the set
+	recordSet = append(recordSet, testRecordBatch(compressionNone, testRecord(nil, []byte("v4")))[:30]...)
+
+	result, err := transformRecordValues(recordSet, false, testMaxDecompressedSize, upper)
+	a.Nil(err)
+	a.Equal([][]byte{[]byte("V1"), nil, []byte("VALUE-3")}, collectRecordValues(a, result))
+	a.Equal([]byte(recordSet[len(recordSet)-30:]), result[len(result)-30:])
+
+	for batch := result; len(batch) > 30; {
+		size, err := recordBatchSize(batch)
+		a.Nil(err)
+		a.Equal(binary.BigEndian.Uint32(batch[17:]), crc32.Checksum(batch[21:size], castagnoliTable))
+		batch = batch[size:]
+	}
+}
+
+func TestTransformRecordValuesMessageSets(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testMessageSetEntry(compressionNone, []byte("v1"))
+	_, err := transformRecordValues(recordSet, false, testMaxDecompressedSize, func(value []byte) ([]byte, error) { return value, nil })
+	a.EqualError(err, "message set with magic 1 cannot be transformed")
+
+	result, err := transformRecordValues(recordSet, true, testMaxDecompressedSize, func(value []byte) ([]byte, error) { return value, nil })
+	a.Nil(err)
+	a.Equal([]byte(recordSet), result)
+}
+
+func TestProduceRecordsModifier(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(testRecordBatch(compressionNone, testRecord(nil, []byte("v1"))))
+
+	modifier, err := GetProduceRecordsModifier(3, testMaxDecompressedSize, func(topic string, value []byte) ([]byte, error) {
+		return append([]byte(topic+":"), value...), nil
+	})
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	var values [][]byte
+	err = DecodeProduceRecordValues(3, result, testMaxDecompressedSize, func(topic string, value []byte) error {
+		values = append(values, value)
+		return nil
+	})
+	a.Nil(err)
+	a.Equal([][]byte{[]byte("orders:v1")}, values)
+}
+
+func TestRecompressRecordBatches(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(compressionNone, testRecord([]byte("k1"), bytes.Repeat([]byte("v1"), 100)), testRecord(nil, nil))
+	recordSet = append(recordSet, testRecordBatch(compressionGZIP, testRecord(nil, []byte("v2")))...)
+	// message set entry at the end of the set
+	recordSet = append(recordSet, testMessageSetEntry(compressionNone, []byte("v3"))...)
+	values := collectRecordValues(a, recordSet)
+
+	compressed, err := recompressRecordBatches(recordSet, compressionGZIP, gzip.BestCompression, testMaxDecompressedSize)
+	a.Nil(err)
+	a.True(len(compressed) < len(recordSet))
+	a.Equal(values, collectRecordValues(a, compressed))
+
+	uncompressed, err := recompressRecordBatches(compressed, compressionNone, gzip.DefaultCompression, testMaxDecompressedSize)
+	a.Nil(err)
+	a.Equal(values, collectRecordValues(a, uncompressed))
+
+	// both batches were rewritten
+	for batch := uncompressed; batch[magicOffset] == recordBatchMagic; {
+		size, err := recordBatchSize(batch)
+		a.Nil(err)
+		a.Equal(binary.BigEndian.Uint32(batch[17:]), crc32.Checksum(batch[21:size], castagnoliTable))
+		batch = batch[size:]
+	}
+	// zstd batches cannot be decompressed by the proxy
+	zstd := testRecordBatch(4, testRecord(nil, []byte("v4")))
+	unchanged, err := recompressRecordBatches(zstd, compressionGZIP, gzip.DefaultCompression, testMaxDecompressedSize)
+	a.Nil(err)
+	a.Equal([]byte(zstd), unchanged)
+
+	_, err = GetProduceRecompressModifier(3, 4, gzip.DefaultCompression, testMaxDecompressedSize)
+	a.EqualError(err, "record batches cannot be compressed with codec 4")
+}
+
+func TestSplitProduceRequest(t *testing.T) {
+	a := assert.New(t)
+
+	batch := testRecordBatch(compressionNone, testRecord(nil, []byte("v1")))
+	req := testMessage{}.int16(-1).int16(-1).int32(1000).int32(2).
+		str("orders").int32(3).int32(0).bytes(batch).int32(1).bytes(batch).int32(2).bytes(batch).
+		str("payments").int32(1).int32(0).bytes(batch)
+
+	leaders := map[string]map[int32]int32{"orders": {0: 1, 1: 2}, "payments": {0: 2}}
+	bodies, skipped, err := SplitProduceRequest(3, req, func(topic string, partition int32) (int32, bool) {
+		leader, ok := leaders[topic][partition]
+		return leader, ok
+	})
+	a.Nil(err)
+	a.Equal(1, skipped)
+	a.Len(bodies, 2)
+	a.Equal([]byte(testMessage{}.int16(-1).int16(0).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(batch)), bodies[1])
+	a.Equal([]byte(testMessage{}.int16(-1).int16(0).int32(1000).int32(2).
+		str("orders").int32(1).int32(1).bytes(batch).
+		str("payments").int32(1).int32(0).bytes(batch)), bodies[2])
+
+	transactional := testMessage{}.str("tx").int16(-1).int32(1000).int32(1).
+		str("orders").int32(1).int32(0).bytes(batch)
+	_, _, err = SplitProduceRequest(3, transactional, func(topic string, partition int32) (int32, bool) {
+		return 1, true
+	})
+	a.NotNil(err)
+}
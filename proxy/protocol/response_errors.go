@@ -0,0 +1,226 @@
+package protocol
+
+import (
+	"strconv"
+)
+
+const (
+	errorCodeKeyName    = "error_code"
+	errorMessageKeyName = "error_message"
+)
+
+// names of the error codes as used by the Kafka clients
+var errorNames = map[KError]string{
+	ErrUnknown:                            "UNKNOWN_SERVER_ERROR",
+	ErrNoError:                            "NONE",
+	ErrOffsetOutOfRange:                   "OFFSET_OUT_OF_RANGE",
+	ErrInvalidMessage:                     "CORRUPT_MESSAGE",
+	ErrUnknownTopicOrPartition:            "UNKNOWN_TOPIC_OR_PARTITION",
+	ErrInvalidMessageSize:                 "INVALID_FETCH_SIZE",
+	ErrLeaderNotAvailable:                 "LEADER_NOT_AVAILABLE",
+	ErrNotLeaderForPartition:              "NOT_LEADER_OR_FOLLOWER",
+	ErrRequestTimedOut:                    "REQUEST_TIMED_OUT",
+	ErrBrokerNotAvailable:                 "BROKER_NOT_AVAILABLE",
+	ErrReplicaNotAvailable:                "REPLICA_NOT_AVAILABLE",
+	ErrMessageSizeTooLarge:                "MESSAGE_TOO_LARGE",
+	ErrStaleControllerEpochCode:           "STALE_CONTROLLER_EPOCH",
+	ErrOffsetMetadataTooLarge:             "OFFSET_METADATA_TOO_LARGE",
+	ErrNetworkException:                   "NETWORK_EXCEPTION",
+	ErrOffsetsLoadInProgress:              "COORDINATOR_LOAD_IN_PROGRESS",
+	ErrConsumerCoordinatorNotAvailable:    "COORDINATOR_NOT_AVAILABLE",
+	ErrNotCoordinatorForConsumer:          "NOT_COORDINATOR",
+	ErrInvalidTopic:                       "INVALID_TOPIC_EXCEPTION",
+	ErrMessageSetSizeTooLarge:             "RECORD_LIST_TOO_LARGE",
+	ErrNotEnoughReplicas:                  "NOT_ENOUGH_REPLICAS",
+	ErrNotEnoughReplicasAfterAppend:       "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	ErrInvalidRequiredAcks:                "INVALID_REQUIRED_ACKS",
+	ErrIllegalGeneration:                  "ILLEGAL_GENERATION",
+	ErrInconsistentGroupProtocol:          "INCONSISTENT_GROUP_PROTOCOL",
+	ErrInvalidGroupId:                     "INVALID_GROUP_ID",
+	ErrUnknownMemberId:                    "UNKNOWN_MEMBER_ID",
+	ErrInvalidSessionTimeout:              "INVALID_SESSION_TIMEOUT",
+	ErrRebalanceInProgress:                "REBALANCE_IN_PROGRESS",
+	ErrInvalidCommitOffsetSize:            "INVALID_COMMIT_OFFSET_SIZE",
+	ErrTopicAuthorizationFailed:           "TOPIC_AUTHORIZATION_FAILED",
+	ErrGroupAuthorizationFailed:           "GROUP_AUTHORIZATION_FAILED",
+	ErrClusterAuthorizationFailed:         "CLUSTER_AUTHORIZATION_FAILED",
+	ErrInvalidTimestamp:                   "INVALID_TIMESTAMP",
+	ErrUnsupportedSASLMechanism:           "UNSUPPORTED_SASL_MECHANISM",
+	ErrIllegalSASLState:                   "ILLEGAL_SASL_STATE",
+	ErrUnsupportedVersion:                 "UNSUPPORTED_VERSION",
+	ErrTopicAlreadyExists:                 "TOPIC_ALREADY_EXISTS",
+	ErrInvalidPartitions:                  "INVALID_PARTITIONS",
+	ErrInvalidReplicationFactor:           "INVALID_REPLICATION_FACTOR",
+	ErrInvalidReplicaAssignment:           "INVALID_REPLICA_ASSIGNMENT",
+	ErrInvalidConfig:                      "INVALID_CONFIG",
+	ErrNotController:                      "NOT_CONTROLLER",
+	ErrInvalidRequest:                     "INVALID_REQUEST",
+	ErrUnsupportedForMessageFormat:        "UNSUPPORTED_FOR_MESSAGE_FORMAT",
+	ErrPolicyViolation:                    "POLICY_VIOLATION",
+	ErrOutOfOrderSequenceNumber:           "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	ErrDuplicateSequenceNumber:            "DUPLICATE_SEQUENCE_NUMBER",
+	ErrInvalidProducerEpoch:               "INVALID_PRODUCER_EPOCH",
+	ErrInvalidTxnState:                    "INVALID_TXN_STATE",
+	ErrInvalidProducerIDMapping:           "INVALID_PRODUCER_ID_MAPPING",
+	ErrInvalidTransactionTimeout:          "INVALID_TRANSACTION_TIMEOUT",
+	ErrConcurrentTransactions:             "CONCURRENT_TRANSACTIONS",
+	ErrTransactionCoordinatorFenced:       "TRANSACTION_COORDINATOR_FENCED",
+	ErrTransactionalIDAuthorizationFailed: "TRANSACTIONAL_ID_AUTHORIZATION_FAILED",
+	ErrSecurityDisabled:                   "SECURITY_DISABLED",
+	ErrOperationNotAttempted:              "OPERATION_NOT_ATTEMPTED",
+	ErrKafkaStorageError:                  "KAFKA_STORAGE_ERROR",
+	ErrLogDirNotFound:                     "LOG_DIR_NOT_FOUND",
+	ErrSASLAuthenticationFailed:           "SASL_AUTHENTICATION_FAILED",
+	ErrUnknownProducerID:                  "UNKNOWN_PRODUCER_ID",
+	ErrReassignmentInProgress:             "REASSIGNMENT_IN_PROGRESS",
+	ErrUnsupportedCompressionType:         "UNSUPPORTED_COMPRESSION_TYPE",
+	ErrInvalidRecord:                      "INVALID_RECORD",
+	ErrUnknownSubscriptionID:              "UNKNOWN_SUBSCRIPTION_ID",
+	ErrTelemetryTooLarge:                  "TELEMETRY_TOO_LARGE",
+}
+
+// Name returns the name of the error code e.g. NOT_LEADER_OR_FOLLOWER, the number for the codes without a name
+func (err KError) Name() string {
+	if name, ok := errorNames[err]; ok {
+		return name
+	}
+	return strconv.Itoa(int(err))
+}
+
+func responseSchemasOf(apiKey int16) []Schema {
+	switch apiKey {
+	case apiKeyMetadata:
+		return metadataResponseSchemaVersions
+	case apiKeyFindCoordinator:
+		return findCoordinatorResponseSchemaVersions
+	case apiKeyApiVersions:
+		return apiVersionsResponseSchemaVersions
+	}
+	if names, ok := namesByApiKey[apiKey]; ok {
+		return names.responseSchemas
+	}
+	return nil
+}
+
+// DecodeResponseErrors returns the errors of the response, one for every non zero error code of the response
+// and its topics, partitions or groups. Nil is returned if the response of the api key is not decoded by the proxy.
+func DecodeResponseErrors(apiKey int16, apiVersion int16, body []byte) ([]KError, error) {
+	schemas := responseSchemasOf(apiKey)
+	if schemas == nil {
+		return nil, nil
+	}
+	schema, err := getResponseSchema(apiKey, apiVersion, schemas)
+	if err != nil {
+		return nil, err
+	}
+	decodedStruct, err := DecodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]KError, 0)
+	collectErrors(decodedStruct, &result)
+	return result, nil
+}
+
+func collectErrors(value interface{}, result *[]KError) {
+	switch v := value.(type) {
+	case *Struct:
+		if v == nil {
+			return
+		}
+		for i, field := range v.schema.fields {
+			if i >= len(v.values) {
+				break
+			}
+			if errorCode, ok := v.values[i].(int16); ok && field.def.GetName() == errorCodeKeyName {
+				if errorCode != 0 {
+					*result = append(*result, KError(errorCode))
+				}
+				continue
+			}
+			collectErrors(v.values[i], result)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			collectErrors(elem, result)
+		}
+	}
+}
+
+// EncodeErrorResponse returns the response body of the api key with the error code and otherwise zero or empty fields, so a request
+// can be rejected without the broker. False is returned if the response of the api key or version is not known to the proxy
+// or it has no top level error code, as the error could not be seen by the client.
+func EncodeErrorResponse(apiKey int16, apiVersion int16, kerr KError) ([]byte, bool) {
+	return encodeErrorResponse(apiKey, apiVersion, kerr, nil)
+}
+
+// EncodeErrorResponseWithMessage returns the error response as EncodeErrorResponse with the message in the top level error message
+// of the responses having one, e.g. FindCoordinator version 1 and later
+func EncodeErrorResponseWithMessage(apiKey int16, apiVersion int16, kerr KError, message string) ([]byte, bool) {
+	return encodeErrorResponse(apiKey, apiVersion, kerr, &message)
+}
+
+func encodeErrorResponse(apiKey int16, apiVersion int16, kerr KError, message *string) ([]byte, bool) {
+	schemas := responseSchemasOf(apiKey)
+	if schemas == nil {
+		return nil, false
+	}
+	s, err := getResponseSchema(apiKey, apiVersion, schemas)
+	if err != nil {
+		return nil, false
+	}
+	st, ok := zeroValue(s).(*Struct)
+	if !ok || st.Get(errorCodeKeyName) == nil {
+		return nil, false
+	}
+	if err = st.Replace(errorCodeKeyName, int16(kerr)); err != nil {
+		return nil, false
+	}
+	if _, ok := st.Get(errorMessageKeyName).(*string); ok && message != nil {
+		if err = st.Replace(errorMessageKeyName, message); err != nil {
+			return nil, false
+		}
+	}
+	body, err := EncodeSchema(st, s)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// zeroValue returns the value of the type with zero numbers, empty strings and arrays and null nullable fields
+func zeroValue(ty EncoderDecoder) interface{} {
+	switch t := ty.(type) {
+	case *schema:
+		values := make([]interface{}, 0, len(t.fields))
+		for _, f := range t.fields {
+			values = append(values, zeroValue(f.def))
+		}
+		return &Struct{schema: t, values: values}
+	case *field:
+		return zeroValue(t.ty)
+	case *array, *compactArray:
+		return make([]interface{}, 0)
+	case *nullableArray:
+		return []interface{}(nil)
+	case *taggedFields:
+		return make([]taggedField, 0)
+	case *Bool:
+		return false
+	case *Int8:
+		return int8(0)
+	case *Int16:
+		return int16(0)
+	case *Int32:
+		return int32(0)
+	case *Int64:
+		return int64(0)
+	case *Str:
+		return ""
+	case *NullableStr:
+		return (*string)(nil)
+	case *Bytes:
+		return []byte{}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeResponseErrors(t *testing.T) {
+	a := assert.New(t)
+
+	findCoordinatorResponse := []byte{
+		// error_code COORDINATOR_NOT_AVAILABLE
+		0x00, 0x0f,
+		// coordinator node_id, host and port
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff,
+	}
+	errs, err := DecodeResponseErrors(apiKeyFindCoordinator, 0, findCoordinatorResponse)
+	a.Nil(err)
+	a.Equal([]KError{ErrConsumerCoordinatorNotAvailable}, errs)
+	a.Equal("COORDINATOR_NOT_AVAILABLE", errs[0].Name())
+
+	metadataResponse := []byte{
+		// brokers
+		0x00, 0x00, 0x00, 0x00,
+		// topic_metadata
+		0x00, 0x00, 0x00, 0x02,
+		// topic "a" with error_code UNKNOWN_TOPIC_OR_PARTITION without partitions
+		0x00, 0x03, 0x00, 0x01, 'a', 0x00, 0x00, 0x00, 0x00,
+		// topic "b" without error and its partition 0 with error_code NOT_LEADER_OR_FOLLOWER
+		0x00, 0x00, 0x00, 0x01, 'b', 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	errs, err = DecodeResponseErrors(apiKeyMetadata, 0, metadataResponse)
+	a.Nil(err)
+	a.Equal([]KError{ErrUnknownTopicOrPartition, ErrNotLeaderForPartition}, errs)
+
+	errs, err = DecodeResponseErrors(apiKeyHeartbeat, 0, []byte{0x00, 0x00})
+	a.Nil(err)
+	a.Nil(errs)
+
+	_, err = DecodeResponseErrors(apiKeyFindCoordinator, 0, findCoordinatorResponse[:4])
+	a.NotNil(err)
+
+	a.Equal("SASL_AUTHENTICATION_FAILED", ErrSASLAuthenticationFailed.Name())
+	a.Equal("INVALID_RECORD", ErrInvalidRecord.Name())
+	a.Equal("200", KError(200).Name())
+}
+
+func TestEncodeErrorResponse(t *testing.T) {
+	a := assert.New(t)
+
+	body, ok := EncodeErrorResponse(apiKeyFindCoordinator, 1, ErrInvalidRequest)
+	a.True(ok)
+	// throttle_time_ms, error_code, null error_message and the zero coordinator
+	a.Equal([]byte{
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x2a,
+		0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}, body)
+	errs, err := DecodeResponseErrors(apiKeyFindCoordinator, 1, body)
+	a.Nil(err)
+	a.Equal([]KError{ErrInvalidRequest}, errs)
+
+	body, ok = EncodeErrorResponse(apiKeyApiVersions, 3, ErrUnsupportedVersion)
+	a.True(ok)
+	a.Equal([]byte{0x00, 0x23, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, body)
+
+	// errors of the topics only
+	_, ok = EncodeErrorResponse(apiKeyMetadata, 0, ErrInvalidRequest)
+	a.False(ok)
+	_, ok = EncodeErrorResponse(apiKeyHeartbeat, 0, ErrInvalidRequest)
+	a.False(ok)
+	_, ok = EncodeErrorResponse(apiKeyFindCoordinator, 10, ErrInvalidRequest)
+	a.False(ok)
+}
+
+func TestEncodeErrorResponseWithMessage(t *testing.T) {
+	a := assert.New(t)
+
+	body, ok := EncodeErrorResponseWithMessage(apiKeyFindCoordinator, 1, ErrClusterAuthorizationFailed, "denied")
+	a.True(ok)
+	a.Equal([]byte(testMessage{}.int32(0).int16(int16(ErrClusterAuthorizationFailed)).str("denied").int32(0).str("").int32(0)), body)
+
+	// no error message in version 0
+	body, ok = EncodeErrorResponseWithMessage(apiKeyFindCoordinator, 0, ErrClusterAuthorizationFailed, "denied")
+	a.True(ok)
+	a.Equal([]byte(testMessage{}.int16(int16(ErrClusterAuthorizationFailed)).int32(0).str("").int32(0)), body)
+}
@@ -307,3 +307,33 @@ func getResponseSchema(apiKey, apiVersion int16, schemas []Schema) (Schema, erro
 	}
 	return schemas[apiVersion], nil
 }
+
+type responseModifiers []ResponseModifier
+
+func (m responseModifiers) Apply(resp []byte) ([]byte, error) {
+	var err error
+	for _, modifier := range m {
+		if resp, err = modifier.Apply(resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// ChainResponseModifiers returns the modifier applying the non nil modifiers in order. Nil is returned if all modifiers are nil.
+func ChainResponseModifiers(modifiers ...ResponseModifier) ResponseModifier {
+	result := make(responseModifiers, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		if modifier != nil {
+			result = append(result, modifier)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	default:
+		return result
+	}
+}
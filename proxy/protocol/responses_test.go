@@ -1975,3 +1975,90 @@ func (t *decodeCheck) Dump() {
 		fmt.Printf("\"%s\",\n", v)
 	}
 }
+
+func TestApiVersionsResponseModifierV0(t *testing.T) {
+	a := assert.New(t)
+
+	bytes := []byte{
+		// error_code
+		0x00, 0x00,
+		// api_versions
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x07, // Produce 0-7
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x0a, // Fetch 0-10
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x07, // Metadata 0-7
+	}
+	filter := func(apiKey int16, apiVersion int16) bool {
+		return apiKey == 0 || (apiKey == 1 && (apiVersion <= 3 || apiVersion == 10))
+	}
+	modifier, err := GetApiVersionsResponseModifier(0, filter)
+	a.Nil(err)
+	resp, err := modifier.Apply(bytes)
+	a.Nil(err)
+
+	s, err := DecodeSchema(resp, apiVersionsResponseSchemaVersions[0])
+	a.Nil(err)
+	a.Equal(`api_versions_response_v0{error_code:0,api_versions:[api_versions_v0{api_key:1,min_version:4,max_version:9} api_versions_v0{api_key:3,min_version:0,max_version:7}]}`, s.String())
+
+	// error responses are not modified
+	errorBytes := []byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07}
+	resp, err = modifier.Apply(errorBytes)
+	a.Nil(err)
+	a.Equal(errorBytes, resp)
+}
+
+func TestApiVersionsResponseModifierV3(t *testing.T) {
+	a := assert.New(t)
+
+	bytes := []byte{
+		// error_code
+		0x00, 0x00,
+		// api_versions compact array of 2 elements
+		0x03,
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, // Fetch 0-12
+		0x00, 0x12, 0x00, 0x00, 0x00, 0x03, 0x00, // ApiVersions 0-3
+		// throttle_time_ms
+		0x00, 0x00, 0x00, 0x00,
+		// tagged fields: tag 0 (SupportedFeatures) with 1 byte
+		0x01, 0x00, 0x01, 0x01,
+	}
+	filter := func(apiKey int16, apiVersion int16) bool {
+		return apiKey == 1 && apiVersion >= 11
+	}
+	modifier, err := GetApiVersionsResponseModifier(3, filter)
+	a.Nil(err)
+	resp, err := modifier.Apply(bytes)
+	a.Nil(err)
+
+	expected := append([]byte{}, bytes...)
+	expected[8] = 0x0a
+	a.Equal(expected, resp)
+
+	_, err = GetApiVersionsResponseModifier(5, filter)
+	a.NotNil(err)
+}
+
+func TestDecodeApiVersionsRequestClientSoftware(t *testing.T) {
+	a := assert.New(t)
+
+	body := []byte{
+		// tagged fields of the request header
+		0x00,
+		// client_software_name
+		0x0c, 'a', 'p', 'a', 'c', 'h', 'e', '-', 'j', 'a', 'v', 'a',
+		// client_software_version
+		0x06, '3', '.', '6', '.', '1',
+		// tagged fields
+		0x00,
+	}
+	software, err := DecodeApiVersionsRequestClientSoftware(3, body)
+	a.Nil(err)
+	a.Equal(&ClientSoftware{Name: "apache-java", Version: "3.6.1"}, software)
+
+	software, err = DecodeApiVersionsRequestClientSoftware(2, nil)
+	a.Nil(err)
+	a.Nil(software)
+
+	_, err = DecodeApiVersionsRequestClientSoftware(3, body[:8])
+	a.NotNil(err)
+}
@@ -8,10 +8,13 @@ import (
 
 var (
 	typeBool        = &Bool{}
+	typeInt8        = &Int8{}
 	typeInt16       = &Int16{}
 	typeInt32       = &Int32{}
+	typeInt64       = &Int64{}
 	typeStr         = &Str{}
 	typeNullableStr = &NullableStr{}
+	typeBytes       = &Bytes{}
 )
 
 type EncoderDecoder interface {
@@ -75,6 +78,23 @@ func (f *Bool) encode(pe packetEncoder, value interface{}) error {
 	return nil
 }
 
+// Field int8
+
+type Int8 struct{}
+
+func (f *Int8) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getInt8()
+}
+
+func (f *Int8) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(int8)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a int8", value)}
+	}
+	pe.putInt8(in)
+	return nil
+}
+
 // Field int16
 
 type Int16 struct{}
@@ -108,6 +128,39 @@ func (f *Int32) encode(pe packetEncoder, value interface{}) error {
 	return nil
 }
 
+// Field int64
+
+type Int64 struct{}
+
+func (f *Int64) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getInt64()
+}
+
+func (f *Int64) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(int64)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a int64", value)}
+	}
+	pe.putInt64(in)
+	return nil
+}
+
+// Field bytes, nil is encoded as null
+
+type Bytes struct{}
+
+func (f *Bytes) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getBytes()
+}
+
+func (f *Bytes) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]byte)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []byte", value)}
+	}
+	return pe.putBytes(in)
+}
+
 // Field string
 
 type Str struct {
@@ -195,6 +248,165 @@ func (f *array) GetName() string {
 	return f.name
 }
 
+// nullableArray decodes null as nil []interface{} and encodes nil []interface{} as null
+type nullableArray struct {
+	name string
+	ty   EncoderDecoder
+}
+
+func (f *nullableArray) decode(pd packetDecoder) (interface{}, error) {
+	n, err := pd.getArrayLength()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return []interface{}(nil), nil
+	}
+	result := make([]interface{}, 0)
+
+	for i := 0; i < n; i++ {
+		elem, err := f.ty.decode(pd)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, elem)
+	}
+	return result, nil
+}
+
+func (f *nullableArray) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]interface{})
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []interface{}", value)}
+	}
+	if in == nil {
+		return pe.putArrayLength(-1)
+	}
+	if err := pe.putArrayLength(len(in)); err != nil {
+		return err
+	}
+	for _, elem := range in {
+		if err := f.ty.encode(pe, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *nullableArray) GetName() string {
+	return f.name
+}
+
+// compactArray is used by the flexible versions, the length is encoded as unsigned varint N+1
+type compactArray struct {
+	name string
+	ty   EncoderDecoder
+}
+
+func (f *compactArray) decode(pd packetDecoder) (interface{}, error) {
+	n, err := pd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, SchemaDecodingError{fmt.Sprintf("compact array %s must not be null", f.name)}
+	}
+	if int(n-1) > pd.remaining() {
+		return nil, ErrInsufficientData
+	}
+	result := make([]interface{}, 0)
+
+	for i := 0; i < int(n-1); i++ {
+		elem, err := f.ty.decode(pd)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, elem)
+	}
+	return result, nil
+}
+
+func (f *compactArray) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]interface{})
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []interface{}", value)}
+	}
+	pe.putUVarint(uint64(len(in) + 1))
+
+	for _, elem := range in {
+		if err := f.ty.encode(pe, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *compactArray) GetName() string {
+	return f.name
+}
+
+type taggedField struct {
+	tag  uint64
+	data []byte
+}
+
+// taggedFields keeps the tagged fields of the flexible versions as they are
+type taggedFields struct {
+	name string
+}
+
+func (f *taggedFields) decode(pd packetDecoder) (interface{}, error) {
+	n, err := pd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > pd.remaining() {
+		return nil, ErrInsufficientData
+	}
+	result := make([]taggedField, 0)
+
+	for i := 0; i < int(n); i++ {
+		tag, err := pd.getUVarint()
+		if err != nil {
+			return nil, err
+		}
+		size, err := pd.getUVarint()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(pd.remaining()) {
+			return nil, ErrInsufficientData
+		}
+		data, err := pd.getRawBytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, taggedField{tag: tag, data: data})
+	}
+	return result, nil
+}
+
+func (f *taggedFields) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]taggedField)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []taggedField", value)}
+	}
+	pe.putUVarint(uint64(len(in)))
+
+	for _, elem := range in {
+		pe.putUVarint(elem.tag)
+		pe.putUVarint(uint64(len(elem.data)))
+		if err := pe.putRawBytes(elem.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *taggedFields) GetName() string {
+	return f.name
+}
+
 type Struct struct {
 	schema *schema
 	values []interface{}
@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// client telemetry (KIP-714)
+const (
+	apiKeyGetTelemetrySubscriptions = 71
+	apiKeyPushTelemetry             = 72
+
+	TelemetryCompressionNone = int8(0)
+	TelemetryCompressionGZIP = int8(1)
+)
+
+// TelemetrySubscription is the GetTelemetrySubscriptions response, all metrics are requested by the empty prefix
+type TelemetrySubscription struct {
+	ClientInstanceID        [16]byte
+	SubscriptionID          int32
+	AcceptedCompressionType []int8
+	PushIntervalMs          int32
+	TelemetryMaxBytes       int32
+	DeltaTemporality        bool
+	RequestedMetrics        []string
+}
+
+// PushTelemetryRequest carries the client metrics encoded as OTLP MetricsData
+type PushTelemetryRequest struct {
+	ClientInstanceID [16]byte
+	SubscriptionID   int32
+	Terminating      bool
+	CompressionType  int8
+	Metrics          []byte
+}
+
+// DecodeGetTelemetrySubscriptionsRequest returns the client instance id of the request body following the client id of the request header,
+// zero if the client asks for a new one
+func DecodeGetTelemetrySubscriptionsRequest(apiVersion int16, body []byte) ([16]byte, error) {
+	var clientInstanceID [16]byte
+	if apiVersion != 0 {
+		return clientInstanceID, fmt.Errorf("Unsupported request schema version %d for key %d ", apiVersion, apiKeyGetTelemetrySubscriptions)
+	}
+	pd := &realDecoder{raw: body}
+	// tagged fields of the request header v2
+	if _, err := (&taggedFields{name: "header_tagged_fields"}).decode(pd); err != nil {
+		return clientInstanceID, err
+	}
+	uuid, err := pd.getRawBytes(16)
+	if err != nil {
+		return clientInstanceID, err
+	}
+	copy(clientInstanceID[:], uuid)
+	return clientInstanceID, nil
+}
+
+// EncodeGetTelemetrySubscriptionsResponse returns the response body following the correlation id of the response header v1
+func EncodeGetTelemetrySubscriptionsResponse(apiVersion int16, subscription *TelemetrySubscription) ([]byte, error) {
+	if apiVersion != 0 {
+		return nil, fmt.Errorf("Unsupported response schema version %d for key %d ", apiVersion, apiKeyGetTelemetrySubscriptions)
+	}
+	// tagged fields of the response header
+	buf := []byte{0}
+	// throttle_time_ms, error_code
+	buf = append(buf, 0, 0, 0, 0, 0, 0)
+	buf = append(buf, subscription.ClientInstanceID[:]...)
+	buf = appendInt32(buf, subscription.SubscriptionID)
+	buf = appendUvarint(buf, uint64(len(subscription.AcceptedCompressionType)+1))
+	for _, compressionType := range subscription.AcceptedCompressionType {
+		buf = append(buf, byte(compressionType))
+	}
+	buf = appendInt32(buf, subscription.PushIntervalMs)
+	buf = appendInt32(buf, subscription.TelemetryMaxBytes)
+	if subscription.DeltaTemporality {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(subscription.RequestedMetrics)+1))
+	for _, metric := range subscription.RequestedMetrics {
+		buf = appendUvarint(buf, uint64(len(metric)+1))
+		buf = append(buf, metric...)
+	}
+	// tagged fields
+	return append(buf, 0), nil
+}
+
+// DecodePushTelemetryRequest decodes the request body following the client id of the request header
+func DecodePushTelemetryRequest(apiVersion int16, body []byte) (*PushTelemetryRequest, error) {
+	if apiVersion != 0 {
+		return nil, fmt.Errorf("Unsupported request schema version %d for key %d ", apiVersion, apiKeyPushTelemetry)
+	}
+	pd := &realDecoder{raw: body}
+	// tagged fields of the request header v2
+	if _, err := (&taggedFields{name: "header_tagged_fields"}).decode(pd); err != nil {
+		return nil, err
+	}
+	request := &PushTelemetryRequest{}
+	uuid, err := pd.getRawBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	copy(request.ClientInstanceID[:], uuid)
+	if request.SubscriptionID, err = pd.getInt32(); err != nil {
+		return nil, err
+	}
+	if request.Terminating, err = pd.getBool(); err != nil {
+		return nil, err
+	}
+	if request.CompressionType, err = pd.getInt8(); err != nil {
+		return nil, err
+	}
+	n, err := pd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || n-1 > uint64(pd.remaining()) {
+		return nil, errInvalidByteSliceLength
+	}
+	if request.Metrics, err = pd.getRawBytes(int(n - 1)); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecompressedMetrics returns the OTLP MetricsData of the request
+func (r *PushTelemetryRequest) DecompressedMetrics(maxDecompressedSize int) ([]byte, error) {
+	switch r.CompressionType {
+	case TelemetryCompressionNone:
+		return r.Metrics, nil
+	case TelemetryCompressionGZIP:
+		return gunzip(r.Metrics, maxDecompressedSize)
+	default:
+		return nil, fmt.Errorf("telemetry compression type %d is not supported", r.CompressionType)
+	}
+}
+
+// EncodePushTelemetryResponse returns the response body following the correlation id of the response header v1
+func EncodePushTelemetryResponse(apiVersion int16, kerr KError) ([]byte, error) {
+	if apiVersion != 0 {
+		return nil, fmt.Errorf("Unsupported response schema version %d for key %d ", apiVersion, apiKeyPushTelemetry)
+	}
+	// tagged fields of the response header, throttle_time_ms
+	buf := []byte{0, 0, 0, 0, 0}
+	buf = append(buf, byte(uint16(kerr)>>8), byte(kerr))
+	// tagged fields
+	return append(buf, 0), nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendInt32(buf []byte, v int32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetTelemetrySubscriptions(t *testing.T) {
+	a := assert.New(t)
+
+	instanceID := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	// header tagged fields, client instance id, tagged fields
+	body := append(append([]byte{0}, instanceID[:]...), 0)
+	decoded, err := DecodeGetTelemetrySubscriptionsRequest(0, body)
+	a.Nil(err)
+	a.Equal(instanceID, decoded)
+
+	_, err = DecodeGetTelemetrySubscriptionsRequest(1, body)
+	a.NotNil(err)
+	_, err = DecodeGetTelemetrySubscriptionsRequest(0, body[:8])
+	a.NotNil(err)
+
+	resp, err := EncodeGetTelemetrySubscriptionsResponse(0, &TelemetrySubscription{
+		ClientInstanceID:        instanceID,
+		SubscriptionID:          7,
+		AcceptedCompressionType: []int8{TelemetryCompressionGZIP},
+		PushIntervalMs:          60000,
+		TelemetryMaxBytes:       1024,
+		DeltaTemporality:        true,
+		RequestedMetrics:        []string{"org.apache.kafka."},
+	})
+	a.Nil(err)
+	expected := []byte{0, 0, 0, 0, 0, 0, 0}
+	expected = append(expected, instanceID[:]...)
+	expected = append(expected, 0, 0, 0, 7, 2, 1, 0, 0, 0xea, 0x60, 0, 0, 4, 0, 1, 2, 18)
+	expected = append(expected, "org.apache.kafka."...)
+	expected = append(expected, 0)
+	a.Equal(expected, resp)
+}
+
+func TestPushTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte("metrics data"))
+	a.Nil(err)
+	a.Nil(w.Close())
+
+	body := []byte{0}
+	body = append(body, make([]byte, 16)...)
+	// subscription id, terminating, compression type
+	body = append(body, 0, 0, 0, 7, 1, byte(TelemetryCompressionGZIP))
+	body = append(body, byte(compressed.Len()+1))
+	body = append(append(body, compressed.Bytes()...), 0)
+
+	request, err := DecodePushTelemetryRequest(0, body)
+	a.Nil(err)
+	a.Equal(int32(7), request.SubscriptionID)
+	a.True(request.Terminating)
+	metrics, err := request.DecompressedMetrics(1024)
+	a.Nil(err)
+	a.Equal("metrics data", string(metrics))
+
+	request.CompressionType = 3
+	_, err = request.DecompressedMetrics(1024)
+	a.NotNil(err)
+
+	// truncated metrics
+	_, err = DecodePushTelemetryRequest(0, body[:len(body)-4])
+	a.NotNil(err)
+
+	resp, err := EncodePushTelemetryResponse(0, ErrTelemetryTooLarge)
+	a.Nil(err)
+	a.Equal([]byte{0, 0, 0, 0, 0, 0, 118, 0}, resp)
+}
@@ -0,0 +1,626 @@
+package protocol
+
+const (
+	apiKeyProduce      = 0
+	apiKeyFetch        = 1
+	apiKeyListOffsets  = 2
+	apiKeyOffsetCommit = 8
+	apiKeyOffsetFetch  = 9
+)
+
+func createProduceRequestSchemaVersions() []Schema {
+	partitionDataV0 := NewSchema("partition_data_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	topicDataV0 := NewSchema("topic_data_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "data", ty: partitionDataV0},
+	)
+
+	produceRequestV0 := NewSchema("produce_request_v0",
+		&field{name: "acks", ty: typeInt16},
+		&field{name: "timeout", ty: typeInt32},
+		&array{name: "topic_data", ty: topicDataV0},
+	)
+
+	produceRequestV3 := NewSchema("produce_request_v3",
+		&field{name: "transactional_id", ty: typeNullableStr},
+		&field{name: "acks", ty: typeInt16},
+		&field{name: "timeout", ty: typeInt32},
+		&array{name: "topic_data", ty: topicDataV0},
+	)
+
+	return []Schema{produceRequestV0, produceRequestV0, produceRequestV0, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3}
+}
+
+func createProduceResponseSchemaVersions() []Schema {
+	partitionResponseV0 := NewSchema("partition_response_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+	)
+
+	partitionResponseV2 := NewSchema("partition_response_v2",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+	)
+
+	partitionResponseV5 := NewSchema("partition_response_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+	)
+
+	recordErrorV8 := NewSchema("record_error_v8",
+		&field{name: "batch_index", ty: typeInt32},
+		&field{name: "batch_index_error_message", ty: typeNullableStr},
+	)
+
+	partitionResponseV8 := NewSchema("partition_response_v8",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&array{name: "record_errors", ty: recordErrorV8},
+		&field{name: "error_message", ty: typeNullableStr},
+	)
+
+	responseV0 := NewSchema("response_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionResponseV0},
+	)
+	responseV2 := NewSchema("response_v2",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionResponseV2},
+	)
+	responseV5 := NewSchema("response_v5",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionResponseV5},
+	)
+	responseV8 := NewSchema("response_v8",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionResponseV8},
+	)
+
+	produceResponseV0 := NewSchema("produce_response_v0",
+		&array{name: "responses", ty: responseV0},
+	)
+
+	produceResponseV1 := NewSchema("produce_response_v1",
+		&array{name: "responses", ty: responseV0},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV2 := NewSchema("produce_response_v2",
+		&array{name: "responses", ty: responseV2},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV5 := NewSchema("produce_response_v5",
+		&array{name: "responses", ty: responseV5},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV8 := NewSchema("produce_response_v8",
+		&array{name: "responses", ty: responseV8},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	return []Schema{produceResponseV0, produceResponseV1, produceResponseV2, produceResponseV2, produceResponseV2, produceResponseV5, produceResponseV5, produceResponseV5, produceResponseV8}
+}
+
+func createFetchRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("fetch_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	partitionV5 := NewSchema("fetch_partition_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	partitionV9 := NewSchema("fetch_partition_v9",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "current_leader_epoch", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	topicV0 := NewSchema("fetch_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+	topicV5 := NewSchema("fetch_topic_v5",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV5},
+	)
+	topicV9 := NewSchema("fetch_topic_v9",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV9},
+	)
+
+	forgottenTopicV7 := NewSchema("forgotten_topic_v7",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	fetchRequestV0 := NewSchema("fetch_request_v0",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	fetchRequestV3 := NewSchema("fetch_request_v3",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	fetchRequestV4 := NewSchema("fetch_request_v4",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	fetchRequestV5 := NewSchema("fetch_request_v5",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: "topics", ty: topicV5},
+	)
+
+	fetchRequestV7 := NewSchema("fetch_request_v7",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: "topics", ty: topicV5},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+	)
+
+	fetchRequestV9 := NewSchema("fetch_request_v9",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: "topics", ty: topicV9},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+	)
+
+	fetchRequestV11 := NewSchema("fetch_request_v11",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: "topics", ty: topicV9},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+		&field{name: "rack_id", ty: typeStr},
+	)
+
+	return []Schema{fetchRequestV0, fetchRequestV0, fetchRequestV0, fetchRequestV3, fetchRequestV4, fetchRequestV5, fetchRequestV5, fetchRequestV7, fetchRequestV7, fetchRequestV9, fetchRequestV9, fetchRequestV11}
+}
+
+func createFetchResponseSchemaVersions() []Schema {
+	abortedTransactionV4 := NewSchema("aborted_transaction_v4",
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "first_offset", ty: typeInt64},
+	)
+
+	partitionV0 := NewSchema("fetch_partition_response_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	partitionV4 := NewSchema("fetch_partition_response_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&nullableArray{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	partitionV5 := NewSchema("fetch_partition_response_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&nullableArray{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	partitionV11 := NewSchema("fetch_partition_response_v11",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&nullableArray{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: "preferred_read_replica", ty: typeInt32},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	responseV0 := NewSchema("fetch_response_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV0},
+	)
+	responseV4 := NewSchema("fetch_response_topic_v4",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV4},
+	)
+	responseV5 := NewSchema("fetch_response_topic_v5",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV5},
+	)
+	responseV11 := NewSchema("fetch_response_topic_v11",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV11},
+	)
+
+	fetchResponseV0 := NewSchema("fetch_response_v0",
+		&array{name: "responses", ty: responseV0},
+	)
+
+	fetchResponseV1 := NewSchema("fetch_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV0},
+	)
+
+	fetchResponseV4 := NewSchema("fetch_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV4},
+	)
+
+	fetchResponseV5 := NewSchema("fetch_response_v5",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV5},
+	)
+
+	fetchResponseV7 := NewSchema("fetch_response_v7",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "session_id", ty: typeInt32},
+		&array{name: "responses", ty: responseV5},
+	)
+
+	fetchResponseV11 := NewSchema("fetch_response_v11",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "session_id", ty: typeInt32},
+		&array{name: "responses", ty: responseV11},
+	)
+
+	return []Schema{fetchResponseV0, fetchResponseV1, fetchResponseV1, fetchResponseV1, fetchResponseV4, fetchResponseV5, fetchResponseV5, fetchResponseV7, fetchResponseV7, fetchResponseV7, fetchResponseV7, fetchResponseV11}
+}
+
+func createListOffsetsRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("list_offsets_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "max_num_offsets", ty: typeInt32},
+	)
+
+	partitionV1 := NewSchema("list_offsets_partition_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+	)
+
+	partitionV4 := NewSchema("list_offsets_partition_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "current_leader_epoch", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+	)
+
+	topicV0 := NewSchema("list_offsets_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+	topicV1 := NewSchema("list_offsets_topic_v1",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV1},
+	)
+	topicV4 := NewSchema("list_offsets_topic_v4",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV4},
+	)
+
+	listOffsetsRequestV0 := NewSchema("list_offsets_request_v0",
+		&field{name: "replica_id", ty: typeInt32},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	listOffsetsRequestV1 := NewSchema("list_offsets_request_v1",
+		&field{name: "replica_id", ty: typeInt32},
+		&array{name: "topics", ty: topicV1},
+	)
+
+	listOffsetsRequestV2 := NewSchema("list_offsets_request_v2",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: "topics", ty: topicV1},
+	)
+
+	listOffsetsRequestV4 := NewSchema("list_offsets_request_v4",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: "topics", ty: topicV4},
+	)
+
+	return []Schema{listOffsetsRequestV0, listOffsetsRequestV1, listOffsetsRequestV2, listOffsetsRequestV2, listOffsetsRequestV4, listOffsetsRequestV4}
+}
+
+func createListOffsetsResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("list_offsets_partition_response_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: "offsets", ty: typeInt64},
+	)
+
+	partitionV1 := NewSchema("list_offsets_partition_response_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "offset", ty: typeInt64},
+	)
+
+	partitionV4 := NewSchema("list_offsets_partition_response_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "leader_epoch", ty: typeInt32},
+	)
+
+	responseV0 := NewSchema("list_offsets_response_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV0},
+	)
+	responseV1 := NewSchema("list_offsets_response_topic_v1",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV1},
+	)
+	responseV4 := NewSchema("list_offsets_response_topic_v4",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV4},
+	)
+
+	listOffsetsResponseV0 := NewSchema("list_offsets_response_v0",
+		&array{name: "responses", ty: responseV0},
+	)
+
+	listOffsetsResponseV1 := NewSchema("list_offsets_response_v1",
+		&array{name: "responses", ty: responseV1},
+	)
+
+	listOffsetsResponseV2 := NewSchema("list_offsets_response_v2",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV1},
+	)
+
+	listOffsetsResponseV4 := NewSchema("list_offsets_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV4},
+	)
+
+	return []Schema{listOffsetsResponseV0, listOffsetsResponseV1, listOffsetsResponseV2, listOffsetsResponseV2, listOffsetsResponseV4, listOffsetsResponseV4}
+}
+
+func createMetadataRequestSchemaVersions() []Schema {
+	metadataRequestV0 := NewSchema("metadata_request_v0",
+		&array{name: "topics", ty: typeStr},
+	)
+
+	metadataRequestV1 := NewSchema("metadata_request_v1",
+		&nullableArray{name: "topics", ty: typeStr},
+	)
+
+	metadataRequestV4 := NewSchema("metadata_request_v4",
+		&nullableArray{name: "topics", ty: typeStr},
+		&field{name: "allow_auto_topic_creation", ty: typeBool},
+	)
+
+	// metadataResponseSchemaVersions support versions up to 7
+	return []Schema{metadataRequestV0, metadataRequestV1, metadataRequestV1, metadataRequestV1, metadataRequestV4, metadataRequestV4, metadataRequestV4, metadataRequestV4}
+}
+
+func createOffsetCommitRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_commit_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	partitionV1 := NewSchema("offset_commit_partition_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	partitionV6 := NewSchema("offset_commit_partition_v6",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "committed_leader_epoch", ty: typeInt32},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	topicV0 := NewSchema("offset_commit_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+	topicV1 := NewSchema("offset_commit_topic_v1",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV1},
+	)
+	topicV6 := NewSchema("offset_commit_topic_v6",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: partitionV6},
+	)
+
+	offsetCommitRequestV0 := NewSchema("offset_commit_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	offsetCommitRequestV1 := NewSchema("offset_commit_request_v1",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "topics", ty: topicV1},
+	)
+
+	offsetCommitRequestV2 := NewSchema("offset_commit_request_v2",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "retention_time", ty: typeInt64},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	offsetCommitRequestV5 := NewSchema("offset_commit_request_v5",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	offsetCommitRequestV6 := NewSchema("offset_commit_request_v6",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "topics", ty: topicV6},
+	)
+
+	offsetCommitRequestV7 := NewSchema("offset_commit_request_v7",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&array{name: "topics", ty: topicV6},
+	)
+
+	return []Schema{offsetCommitRequestV0, offsetCommitRequestV1, offsetCommitRequestV2, offsetCommitRequestV2, offsetCommitRequestV2, offsetCommitRequestV5, offsetCommitRequestV6, offsetCommitRequestV7}
+}
+
+func createOffsetCommitResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_commit_partition_response_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	responseV0 := NewSchema("offset_commit_response_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV0},
+	)
+
+	offsetCommitResponseV0 := NewSchema("offset_commit_response_v0",
+		&array{name: "responses", ty: responseV0},
+	)
+
+	offsetCommitResponseV3 := NewSchema("offset_commit_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV0},
+	)
+
+	return []Schema{offsetCommitResponseV0, offsetCommitResponseV0, offsetCommitResponseV0, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3}
+}
+
+func createOffsetFetchRequestSchemaVersions() []Schema {
+	topicV0 := NewSchema("offset_fetch_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	offsetFetchRequestV0 := NewSchema("offset_fetch_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	offsetFetchRequestV2 := NewSchema("offset_fetch_request_v2",
+		&field{name: "group_id", ty: typeStr},
+		&nullableArray{name: "topics", ty: topicV0},
+	)
+
+	return []Schema{offsetFetchRequestV0, offsetFetchRequestV0, offsetFetchRequestV2, offsetFetchRequestV2, offsetFetchRequestV2, offsetFetchRequestV2}
+}
+
+func createOffsetFetchResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_fetch_partition_response_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	partitionV5 := NewSchema("offset_fetch_partition_response_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "committed_leader_epoch", ty: typeInt32},
+		&field{name: "metadata", ty: typeNullableStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	responseV0 := NewSchema("offset_fetch_response_topic_v0",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV0},
+	)
+	responseV5 := NewSchema("offset_fetch_response_topic_v5",
+		&field{name: "topic", ty: typeStr},
+		&array{name: "partition_responses", ty: partitionV5},
+	)
+
+	offsetFetchResponseV0 := NewSchema("offset_fetch_response_v0",
+		&array{name: "responses", ty: responseV0},
+	)
+
+	offsetFetchResponseV2 := NewSchema("offset_fetch_response_v2",
+		&array{name: "responses", ty: responseV0},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	offsetFetchResponseV3 := NewSchema("offset_fetch_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV0},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	offsetFetchResponseV5 := NewSchema("offset_fetch_response_v5",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: responseV5},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	return []Schema{offsetFetchResponseV0, offsetFetchResponseV0, offsetFetchResponseV2, offsetFetchResponseV3, offsetFetchResponseV3, offsetFetchResponseV5}
+}
@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testMessage []byte
+
+func (m testMessage) int8(v int8) testMessage { return append(m, byte(v)) }
+
+func (m testMessage) int16(v int16) testMessage {
+	return append(m, byte(uint16(v)>>8), byte(v))
+}
+
+func (m testMessage) int32(v int32) testMessage {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	return append(m, b...)
+}
+
+func (m testMessage) int64(v int64) testMessage {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return append(m, b...)
+}
+
+func (m testMessage) str(v string) testMessage {
+	return append(m.int16(int16(len(v))), v...)
+}
+
+func (m testMessage) bytes(v []byte) testMessage {
+	return append(m.int32(int32(len(v))), v...)
+}
+
+func TestProduceRequestTopicsAreMappedToBroker(t *testing.T) {
+	a := assert.New(t)
+
+	partition := testMessage{}.int32(0).bytes([]byte{1, 2, 3})
+	req := testMessage{}.int16(-1).int16(1).int32(1000).int32(2).
+		str("orders").int32(1)
+	req = append(req, partition...)
+	req = req.str("payments").int32(1)
+	req = append(req, partition...)
+
+	modifier, err := GetNamesRequestModifier(apiKeyProduce, 3, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	expected := testMessage{}.int16(-1).int16(1).int32(1000).int32(2).
+		str("tenant-a.orders").int32(1)
+	expected = append(expected, partition...)
+	expected = expected.str("tenant-a.payments").int32(1)
+	expected = append(expected, partition...)
+	a.Equal([]byte(expected), result)
+}
+
+func TestFetchRequestForgottenTopicsAreMappedToBroker(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.int32(-1).int32(500).int32(1).int32(1024).int8(0).int32(7).int32(1).
+		int32(1).str("orders").int32(1).int32(0).int64(42).int64(0).int32(1024).
+		int32(1).str("payments").int32(1).int32(0)
+
+	modifier, err := GetNamesRequestModifier(apiKeyFetch, 7, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	expected := testMessage{}.int32(-1).int32(500).int32(1).int32(1024).int8(0).int32(7).int32(1).
+		int32(1).str("tenant-a.orders").int32(1).int32(0).int64(42).int64(0).int32(1024).
+		int32(1).str("tenant-a.payments").int32(1).int32(0)
+	a.Equal([]byte(expected), result)
+}
+
+func TestMetadataRequestNullTopicsAreKept(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.int32(-1).int8(1)
+	modifier, err := GetNamesRequestModifier(apiKeyMetadata, 4, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+	a.Equal([]byte(req), result)
+}
+
+func TestDecodeRequestTopicNames(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.int32(-1).int32(500).int32(1).int32(1024).int8(0).int32(7).int32(1).
+		int32(1).str("orders").int32(1).int32(0).int64(42).int64(0).int32(1024).
+		int32(1).str("payments").int32(1).int32(0)
+	topics, err := DecodeRequestTopicNames(apiKeyFetch, 7, req)
+	a.Nil(err)
+	a.Equal([]string{"orders", "payments"}, topics)
+
+	topics, err = DecodeRequestTopicNames(apiKeyMetadata, 4, testMessage{}.int32(-1).int8(1))
+	a.Nil(err)
+	a.Empty(topics)
+
+	topics, err = DecodeRequestTopicNames(apiKeyJoinGroup, 0, nil)
+	a.Nil(err)
+	a.Nil(topics)
+}
+
+func TestFetchResponseTopicsAreMappedToClient(t *testing.T) {
+	a := assert.New(t)
+
+	partition := testMessage{}.int32(0).int16(0).int64(100).int64(100).int64(0).int32(-1).bytes([]byte{1, 2, 3})
+	resp := testMessage{}.int32(0).int16(0).int32(7).int32(1).str("tenant-a.orders").int32(1)
+	resp = append(resp, partition...)
+
+	modifier, err := GetNamesResponseModifier(apiKeyFetch, 7, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(resp)
+	a.Nil(err)
+
+	expected := testMessage{}.int32(0).int16(0).int32(7).int32(1).str("orders").int32(1)
+	expected = append(expected, partition...)
+	a.Equal([]byte(expected), result)
+}
+
+func TestOffsetFetchResponseHidesOtherTopics(t *testing.T) {
+	a := assert.New(t)
+
+	resp := testMessage{}.int32(0).int32(2).
+		str("tenant-a.orders").int32(1).int32(0).int64(42).int16(-1).int16(0).
+		str("tenant-b.orders").int32(1).int32(0).int64(7).int16(-1).int16(0).
+		int16(0)
+
+	modifier, err := GetNamesResponseModifier(apiKeyOffsetFetch, 3, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(resp)
+	a.Nil(err)
+
+	expected := testMessage{}.int32(0).int32(1).
+		str("orders").int32(1).int32(0).int64(42).int16(-1).int16(0).
+		int16(0)
+	a.Equal([]byte(expected), result)
+}
+
+func TestTopicNamesMaxVersion(t *testing.T) {
+	a := assert.New(t)
+
+	for apiKey, maxVersion := range map[int16]int16{apiKeyProduce: 8, apiKeyFetch: 11, apiKeyListOffsets: 5, apiKeyMetadata: 7, apiKeyOffsetCommit: 7, apiKeyOffsetFetch: 5} {
+		version, ok := TopicNamesMaxVersion(apiKey)
+		a.True(ok)
+		a.Equal(maxVersion, version)
+		names := namesByApiKey[apiKey]
+		a.Equal(len(names.requestSchemas), len(names.responseSchemas))
+	}
+	_, ok := TopicNamesMaxVersion(apiKeyFindCoordinator)
+	a.False(ok)
+}
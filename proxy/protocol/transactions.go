@@ -0,0 +1,160 @@
+package protocol
+
+import "fmt"
+
+const (
+	apiKeyInitProducerId     = 22
+	apiKeyAddPartitionsToTxn = 24
+	apiKeyAddOffsetsToTxn    = 25
+	apiKeyEndTxn             = 26
+	apiKeyTxnOffsetCommit    = 28
+)
+
+var initProducerIdRequestSchemaVersions = createInitProducerIdRequestSchemaVersions()
+
+// IsTransactionalRequest reports whether the request is sent by a transactional producer.
+// InitProducerId requests of idempotent producers without transactional id are not transactional.
+func IsTransactionalRequest(apiKey int16, apiVersion int16, body []byte) (bool, error) {
+	switch apiKey {
+	case apiKeyAddPartitionsToTxn, apiKeyAddOffsetsToTxn, apiKeyEndTxn, apiKeyTxnOffsetCommit:
+		return true, nil
+	case apiKeyInitProducerId:
+		schema, err := getRequestSchema(apiKey, apiVersion, initProducerIdRequestSchemaVersions)
+		if err != nil {
+			return false, err
+		}
+		decodedStruct, err := DecodeSchema(body, schema)
+		if err != nil {
+			return false, err
+		}
+		transactionalID, ok := decodedStruct.Get("transactional_id").(*string)
+		if !ok {
+			return false, fmt.Errorf("transactional_id of InitProducerId request is not a nullable string")
+		}
+		return transactionalID != nil, nil
+	default:
+		return false, nil
+	}
+}
+
+// InitProducerIdMaxVersion returns the highest InitProducerId version which transactional id can be decoded
+func InitProducerIdMaxVersion() int16 {
+	return int16(len(initProducerIdRequestSchemaVersions) - 1)
+}
+
+func createInitProducerIdRequestSchemaVersions() []Schema {
+	initProducerIdRequestV0 := NewSchema("init_producer_id_request_v0",
+		&field{name: "transactional_id", ty: typeNullableStr},
+		&field{name: "transaction_timeout_ms", ty: typeInt32},
+	)
+
+	return []Schema{initProducerIdRequestV0, initProducerIdRequestV0}
+}
+
+func createAddPartitionsToTxnRequestSchemaVersions() []Schema {
+	topicV0 := NewSchema("add_partitions_to_txn_topic_v0",
+		&field{name: "name", ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	addPartitionsToTxnRequestV0 := NewSchema("add_partitions_to_txn_request_v0",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	return []Schema{addPartitionsToTxnRequestV0, addPartitionsToTxnRequestV0, addPartitionsToTxnRequestV0}
+}
+
+func createAddPartitionsToTxnResponseSchemaVersions() []Schema {
+	partitionResultV0 := NewSchema("add_partitions_to_txn_partition_result_v0",
+		&field{name: "partition_index", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicResultV0 := NewSchema("add_partitions_to_txn_topic_result_v0",
+		&field{name: "name", ty: typeStr},
+		&array{name: "results", ty: partitionResultV0},
+	)
+
+	addPartitionsToTxnResponseV0 := NewSchema("add_partitions_to_txn_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "results", ty: topicResultV0},
+	)
+
+	return []Schema{addPartitionsToTxnResponseV0, addPartitionsToTxnResponseV0, addPartitionsToTxnResponseV0}
+}
+
+func createAddOffsetsToTxnRequestSchemaVersions() []Schema {
+	addOffsetsToTxnRequestV0 := NewSchema("add_offsets_to_txn_request_v0",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&field{name: "group_id", ty: typeStr},
+	)
+
+	return []Schema{addOffsetsToTxnRequestV0, addOffsetsToTxnRequestV0, addOffsetsToTxnRequestV0}
+}
+
+func createTxnOffsetCommitRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("txn_offset_commit_partition_v0",
+		&field{name: "partition_index", ty: typeInt32},
+		&field{name: "committed_offset", ty: typeInt64},
+		&field{name: "committed_metadata", ty: typeNullableStr},
+	)
+
+	partitionV2 := NewSchema("txn_offset_commit_partition_v2",
+		&field{name: "partition_index", ty: typeInt32},
+		&field{name: "committed_offset", ty: typeInt64},
+		&field{name: "committed_leader_epoch", ty: typeInt32},
+		&field{name: "committed_metadata", ty: typeNullableStr},
+	)
+
+	topicV0 := NewSchema("txn_offset_commit_topic_v0",
+		&field{name: "name", ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV2 := NewSchema("txn_offset_commit_topic_v2",
+		&field{name: "name", ty: typeStr},
+		&array{name: "partitions", ty: partitionV2},
+	)
+
+	txnOffsetCommitRequestV0 := NewSchema("txn_offset_commit_request_v0",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	txnOffsetCommitRequestV2 := NewSchema("txn_offset_commit_request_v2",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: "topics", ty: topicV2},
+	)
+
+	return []Schema{txnOffsetCommitRequestV0, txnOffsetCommitRequestV0, txnOffsetCommitRequestV2}
+}
+
+func createTxnOffsetCommitResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("txn_offset_commit_response_partition_v0",
+		&field{name: "partition_index", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicV0 := NewSchema("txn_offset_commit_response_topic_v0",
+		&field{name: "name", ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	txnOffsetCommitResponseV0 := NewSchema("txn_offset_commit_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "topics", ty: topicV0},
+	)
+
+	return []Schema{txnOffsetCommitResponseV0, txnOffsetCommitResponseV0, txnOffsetCommitResponseV0}
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTxnOffsetCommitGroupAndTopicsAreMapped(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.str("txn-1").str("orders-app").int64(1000).int16(0).int32(1).
+		str("orders").int32(1).int32(0).int64(42).int32(-1).str("")
+
+	modifier, err := GetNamesRequestModifier(apiKeyTxnOffsetCommit, 2, &PrefixNameMapper{Prefix: "tenant-a."}, &PrefixNameMapper{Prefix: "group-a."})
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+
+	expected := testMessage{}.str("txn-1").str("group-a.orders-app").int64(1000).int16(0).int32(1).
+		str("tenant-a.orders").int32(1).int32(0).int64(42).int32(-1).str("")
+	a.Equal([]byte(expected), result)
+
+	resp := testMessage{}.int32(0).int32(1).str("tenant-a.orders").int32(1).int32(0).int16(0)
+	responseModifier, err := GetNamesResponseModifier(apiKeyTxnOffsetCommit, 2, &PrefixNameMapper{Prefix: "tenant-a."}, &PrefixNameMapper{Prefix: "group-a."})
+	a.Nil(err)
+	result, err = responseModifier.Apply(resp)
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(0).int32(1).str("orders").int32(1).int32(0).int16(0)), result)
+}
+
+func TestAddPartitionsToTxnTopicsAreMapped(t *testing.T) {
+	a := assert.New(t)
+
+	req := testMessage{}.str("txn-1").int64(1000).int16(0).int32(1).str("orders").int32(2).int32(0).int32(1)
+
+	modifier, err := GetNamesRequestModifier(apiKeyAddPartitionsToTxn, 1, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.str("txn-1").int64(1000).int16(0).int32(1).str("tenant-a.orders").int32(2).int32(0).int32(1)), result)
+
+	resp := testMessage{}.int32(0).int32(1).str("tenant-a.orders").int32(1).int32(0).int16(0)
+	responseModifier, err := GetNamesResponseModifier(apiKeyAddPartitionsToTxn, 1, &PrefixNameMapper{Prefix: "tenant-a."}, nil)
+	a.Nil(err)
+	result, err = responseModifier.Apply(resp)
+	a.Nil(err)
+	a.Equal([]byte(testMessage{}.int32(0).int32(1).str("orders").int32(1).int32(0).int16(0)), result)
+}
+
+func TestIsTransactionalRequest(t *testing.T) {
+	a := assert.New(t)
+
+	transactional, err := IsTransactionalRequest(apiKeyInitProducerId, 1, testMessage{}.str("txn-1").int32(60000))
+	a.Nil(err)
+	a.True(transactional)
+
+	// idempotent producer
+	transactional, err = IsTransactionalRequest(apiKeyInitProducerId, 1, testMessage{}.int16(-1).int32(60000))
+	a.Nil(err)
+	a.False(transactional)
+
+	transactional, err = IsTransactionalRequest(apiKeyEndTxn, 3, nil)
+	a.Nil(err)
+	a.True(transactional)
+
+	transactional, err = IsTransactionalRequest(apiKeyProduce, 3, nil)
+	a.Nil(err)
+	a.False(transactional)
+
+	_, err = IsTransactionalRequest(apiKeyInitProducerId, 2, testMessage{}.str("txn-1").int32(60000))
+	a.NotNil(err)
+}
@@ -5,9 +5,20 @@ import (
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// kinds of the broker mappings added at runtime
+	MappingBootstrap = "bootstrap"
+	MappingExternal  = "external"
+	MappingDynamic   = "dynamic"
 )
 
 type ListenFunc func(cfg config.ListenerConfig) (l net.Listener, err error)
@@ -21,11 +32,57 @@ type Listeners struct {
 	tcpConnOptions TCPConnOptions
 
 	listenFunc ListenFunc
+	// source IP allow / deny lists
+	sourceFilters *sourceFilters
+	// accept throttling
+	acceptRate              float64
+	acceptBurst             int
+	maxConcurrentHandshakes int
+	handshakeTimeout        time.Duration
 
-	disableDynamicListeners bool
+	// strategy for the brokers without a mapping
+	unmappedBrokers string
+	// Unix socket paths by listener address and the principals of the peers
+	unixSockets map[string]string
+	unixPeers   *unixPeerPrincipals
+	// session ticket keys of the TLS listeners, nil if the keys are neither shared nor rotated
+	sessionTickets *sessionTicketKeys
+	// certificate of the listeners without cert file, nil if ACME is disabled
+	acme *acmeManager
+	// TLS configurations of all listeners
+	tlsConfigs []*tls.Config
+	// audits of the warn-only client auth policies by listener address, the global one with empty address
+	clientAuthAudits map[string]*clientAuthAudit
+	// allowlist of the client certificates of all TLS listeners, nil if all certificates are allowed
+	clientCertFingerprints *clientCertFingerprints
+	// new connections are rejected while set to 1
+	paused int32
+	// new connections are rejected while overloaded, nil if the load is not limited
+	loadShedder *loadShedder
 
 	brokerToListenerConfig map[string]config.ListenerConfig
 	lock                   sync.RWMutex
+
+	// started listeners
+	listeners []net.Listener
+	// dynamically started listeners by broker address
+	dynamicListeners map[string]net.Listener
+	// listeners of the bootstrap servers by broker address
+	staticListeners map[string][]net.Listener
+	// broker addresses with started listeners
+	listening map[string]bool
+	// ports of the dynamic listeners coordinated with the other replicas, nil if the ports are random
+	dynamicPorts dynamicPorts
+	// host advertised for the dynamic listeners
+	dynamicAdvertisedHost string
+	// listener configs of the retired dynamic listeners by broker address
+	retired map[string]config.ListenerConfig
+	closed  bool
+
+	// broker node ids by advertised address and the last time the broker addresses were mapped in a response
+	seenLock sync.Mutex
+	nodeIDs  map[string]int32
+	lastSeen map[string]time.Time
 }
 
 func NewListeners(cfg *config.Config) (*Listeners, error) {
@@ -38,16 +95,67 @@ func NewListeners(cfg *config.Config) (*Listeners, error) {
 		WriteBufferSize: cfg.Proxy.ListenerWriteBufferSize,
 	}
 
-	var tlsConfig *tls.Config
+	var defaultTLSConfig *tls.Config
 	if cfg.Proxy.TLS.Enable {
 		var err error
-		tlsConfig, err = newTLSListenerConfig(cfg)
+		defaultTLSConfig, err = newTLSListenerConfig(cfg)
 		if err != nil {
 			return nil, err
 		}
 	}
+	clientAuthAudits := map[string]*clientAuthAudit{"": newClientAuthAudit(cfg.ListenerTLSOf(""), defaultTLSConfig)}
+	// TLS configurations of the listeners with overrides, nil if TLS is disabled on the listener
+	listenerTLSConfigs := make(map[string]*tls.Config)
+	for _, v := range cfg.Proxy.ListenerTLS {
+		opts := cfg.ListenerTLSOf(v.ListenerAddress)
+		if !opts.Enabled() {
+			listenerTLSConfigs[v.ListenerAddress] = nil
+			clientAuthAudits[v.ListenerAddress] = nil
+			continue
+		}
+		tlsConfig, err := newListenerTLSConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("TLS of listener %s: %v", v.ListenerAddress, err)
+		}
+		listenerTLSConfigs[v.ListenerAddress] = tlsConfig
+		clientAuthAudits[v.ListenerAddress] = newClientAuthAudit(opts, tlsConfig)
+	}
+	tlsConfigs := make([]*tls.Config, 0)
+	if defaultTLSConfig != nil {
+		tlsConfigs = append(tlsConfigs, defaultTLSConfig)
+	}
+	for _, tlsConfig := range listenerTLSConfigs {
+		if tlsConfig != nil {
+			tlsConfigs = append(tlsConfigs, tlsConfig)
+		}
+	}
+	acme, err := newACMEManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	acme.setGetCertificate(tlsConfigs)
+	clientCertFingerprints, err := newClientCertFingerprints(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, tlsConfig := range tlsConfigs {
+		clientCertFingerprints.apply(tlsConfig)
+	}
+	sessionTickets := newSessionTicketKeys(cfg)
+	if sessionTickets != nil {
+		if err := sessionTickets.rotate(); err != nil {
+			return nil, err
+		}
+		for _, tlsConfig := range tlsConfigs {
+			sessionTickets.add(tlsConfig)
+		}
+	}
 
 	listenFunc := func(cfg config.ListenerConfig) (net.Listener, error) {
+		tlsConfig := defaultTLSConfig
+		if v, ok := listenerTLSConfigs[cfg.ListenerAddress]; ok {
+			tlsConfig = v
+		}
 		if tlsConfig != nil {
 			return tls.Listen("tcp", cfg.ListenerAddress, tlsConfig)
 		}
@@ -58,14 +166,55 @@ func NewListeners(cfg *config.Config) (*Listeners, error) {
 	if err != nil {
 		return nil, err
 	}
+	sourceFilters, err := newSourceFilters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	unixSockets := make(map[string]string)
+	for _, v := range cfg.Proxy.ListenerUnixSockets {
+		listenerAddress, path, err := config.ParseListenerUnixSocket(v)
+		if err != nil {
+			return nil, err
+		}
+		unixSockets[listenerAddress] = path
+	}
+	unixPeers, err := newUnixPeerPrincipals(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicAdvertisedHost := cfg.Proxy.DynamicPorts.AdvertisedHost
+	if dynamicAdvertisedHost == "" {
+		dynamicAdvertisedHost = defaultListenerIP
+	}
 
 	return &Listeners{
 		defaultListenerIP:       defaultListenerIP,
+		dynamicPorts:            newDynamicPorts(cfg),
+		dynamicAdvertisedHost:   dynamicAdvertisedHost,
 		connSrc:                 make(chan Conn, 1),
 		brokerToListenerConfig:  brokerToListenerConfig,
 		tcpConnOptions:          tcpConnOptions,
 		listenFunc:              listenFunc,
-		disableDynamicListeners: cfg.Proxy.DisableDynamicListeners,
+		sourceFilters:           sourceFilters,
+		acceptRate:              cfg.Proxy.ListenerAcceptRate,
+		acceptBurst:             cfg.Proxy.ListenerAcceptBurst,
+		maxConcurrentHandshakes: cfg.Proxy.TLS.ListenerMaxConcurrentHandshakes,
+		handshakeTimeout:        cfg.Proxy.TLS.ListenerHandshakeTimeout,
+		unmappedBrokers:         cfg.UnmappedBrokersStrategy(),
+		unixSockets:             unixSockets,
+		unixPeers:               unixPeers,
+		sessionTickets:          sessionTickets,
+		acme:                    acme,
+		tlsConfigs:              tlsConfigs,
+		clientAuthAudits:        clientAuthAudits,
+		clientCertFingerprints:  clientCertFingerprints,
+		dynamicListeners:        make(map[string]net.Listener),
+		staticListeners:         make(map[string][]net.Listener),
+		listening:               make(map[string]bool),
+		retired:                 make(map[string]config.ListenerConfig),
+		nodeIDs:                 make(map[string]int32),
+		lastSeen:                make(map[string]time.Time),
 	}, nil
 }
 
@@ -110,6 +259,23 @@ func getBrokerToListenerConfig(cfg *config.Config) (map[string]config.ListenerCo
 	return brokerToListenerConfig, nil
 }
 
+// setCertVerifier verifies the client certificates of all listeners with the verifier, it must be called before the listeners are started
+func (p *Listeners) setCertVerifier(verifier *certVerifier) {
+	for _, tlsConfig := range p.tlsConfigs {
+		verifier.applyListener(tlsConfig)
+	}
+}
+
+// setKeySigner sets the private key of the listener certificates without key file
+func (p *Listeners) setKeySigner(signer *keySigner) error {
+	for _, tlsConfig := range p.tlsConfigs {
+		if err := signer.apply(tlsConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *Listeners) GetNetAddressMapping(brokerHost string, brokerPort int32) (listenerHost string, listenerPort int32, err error) {
 	if brokerHost == "" || brokerPort <= 0 {
 		return "", 0, fmt.Errorf("broker address '%s:%d' is invalid", brokerHost, brokerPort)
@@ -122,12 +288,41 @@ func (p *Listeners) GetNetAddressMapping(brokerHost string, brokerPort int32) (l
 	p.lock.RUnlock()
 
 	if ok {
+		p.seen(brokerAddress)
 		return util.SplitHostPort(listenerConfig.AdvertisedAddress)
 	}
-	if !p.disableDynamicListeners {
-		return p.ListenDynamicInstance(brokerAddress)
+	proxyUnmappedBrokersTotal.WithLabelValues(brokerAddress, p.unmappedBrokers).Inc()
+	log := logrus.WithFields(logrus.Fields{"broker": brokerAddress, "strategy": p.unmappedBrokers})
+	switch p.unmappedBrokers {
+	case config.UnmappedBrokersAutoMap:
+		listenerHost, listenerPort, err = p.ListenDynamicInstance(brokerAddress)
+		if err != nil {
+			log.Warnf("Dynamic listener for unmapped broker could not be started: %v", err)
+			return "", 0, err
+		}
+		log.Infof("Unmapped broker is mapped to dynamic listener %s", net.JoinHostPort(listenerHost, fmt.Sprint(listenerPort)))
+		p.seen(brokerAddress)
+		return listenerHost, listenerPort, nil
+	case config.UnmappedBrokersPassthrough:
+		log.Warn("Unmapped broker is passed through, the clients will connect to it bypassing the proxy")
+		return brokerHost, brokerPort, nil
+	default:
+		log.Warn("Unmapped broker is rejected")
+		return "", 0, fmt.Errorf("net address mapping for %s:%d was not found", brokerHost, brokerPort)
 	}
-	return "", 0, fmt.Errorf("net address mapping for %s:%d was not found", brokerHost, brokerPort)
+}
+
+func (p *Listeners) seen(brokerAddress string) {
+	p.seenLock.Lock()
+	p.lastSeen[brokerAddress] = time.Now()
+	p.seenLock.Unlock()
+}
+
+// SetNodeID records the node id of the broker advertised with the address
+func (p *Listeners) SetNodeID(advertisedAddress string, nodeID int32) {
+	p.seenLock.Lock()
+	p.nodeIDs[advertisedAddress] = nodeID
+	p.seenLock.Unlock()
 }
 
 func (p *Listeners) ListenDynamicInstance(brokerAddress string) (string, int32, error) {
@@ -135,20 +330,57 @@ func (p *Listeners) ListenDynamicInstance(brokerAddress string) (string, int32,
 	defer p.lock.Unlock()
 	// double check
 	if v, ok := p.brokerToListenerConfig[brokerAddress]; ok {
-		return v.AdvertisedAddress, 0, nil
+		return util.SplitHostPort(v.AdvertisedAddress)
 	}
+	return p.listenDynamic(brokerAddress)
+}
 
-	defaultListenerAddress := net.JoinHostPort(p.defaultListenerIP, fmt.Sprint(0))
+func (p *Listeners) listenDynamic(brokerAddress string) (string, int32, error) {
+	port := 0
+	if p.dynamicPorts != nil {
+		var err error
+		if port, err = p.dynamicPorts.assign(brokerAddress); err != nil {
+			return "", 0, errors.Wrapf(err, "dynamic port of broker %s cannot be assigned", brokerAddress)
+		}
+	}
+	defaultListenerAddress := net.JoinHostPort(p.defaultListenerIP, fmt.Sprint(port))
 
 	cfg := config.ListenerConfig{ListenerAddress: defaultListenerAddress, BrokerAddress: brokerAddress}
-	l, err := listenInstance(p.connSrc, cfg, p.tcpConnOptions, p.listenFunc)
+	l, err := listenInstance(p.connSrc, cfg, p.tcpConnOptions, p.listenFunc, p.acceptOptions(cfg.ListenerAddress))
 	if err != nil {
 		return "", 0, err
 	}
-	port := l.Addr().(*net.TCPAddr).Port
+	p.listeners = append(p.listeners, l)
+	p.dynamicListeners[brokerAddress] = l
+	p.listening[brokerAddress] = true
+	delete(p.retired, brokerAddress)
+	port = l.Addr().(*net.TCPAddr).Port
 	address := net.JoinHostPort(p.defaultListenerIP, fmt.Sprint(port))
-	p.brokerToListenerConfig[brokerAddress] = config.ListenerConfig{BrokerAddress: brokerAddress, ListenerAddress: address, AdvertisedAddress: address}
-	return p.defaultListenerIP, int32(port), nil
+	advertisedAddress := net.JoinHostPort(p.dynamicAdvertisedHost, fmt.Sprint(port))
+	p.brokerToListenerConfig[brokerAddress] = config.ListenerConfig{BrokerAddress: brokerAddress, ListenerAddress: address, AdvertisedAddress: advertisedAddress}
+	return p.dynamicAdvertisedHost, int32(port), nil
+}
+
+// RetireDynamicListeners closes the dynamic listeners which advertised addresses are not in keep and returns their broker addresses.
+// Already accepted connections are not closed.
+func (p *Listeners) RetireDynamicListeners(keep map[string]bool) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	retired := make([]string, 0)
+	for brokerAddress, l := range p.dynamicListeners {
+		if keep[p.brokerToListenerConfig[brokerAddress].AdvertisedAddress] {
+			continue
+		}
+		p.closeListener(l)
+		delete(p.dynamicListeners, brokerAddress)
+		delete(p.listening, brokerAddress)
+		p.retired[brokerAddress] = p.brokerToListenerConfig[brokerAddress]
+		delete(p.brokerToListenerConfig, brokerAddress)
+		retired = append(retired, brokerAddress)
+	}
+	sort.Strings(retired)
+	return retired
 }
 
 func (p *Listeners) ListenInstances(cfgs []config.ListenerConfig) (<-chan Conn, error) {
@@ -157,34 +389,264 @@ func (p *Listeners) ListenInstances(cfgs []config.ListenerConfig) (<-chan Conn,
 
 	// allows multiple local addresses to point to the remote
 	for _, v := range cfgs {
-		_, err := listenInstance(p.connSrc, v, p.tcpConnOptions, p.listenFunc)
-		if err != nil {
+		if err := p.listenStatic(v); err != nil {
 			return nil, err
 		}
 	}
 	return p.connSrc, nil
 }
 
-func listenInstance(dst chan<- Conn, cfg config.ListenerConfig, opts TCPConnOptions, listenFunc ListenFunc) (net.Listener, error) {
+func (p *Listeners) listenStatic(cfg config.ListenerConfig) error {
+	l, err := listenInstance(p.connSrc, cfg, p.tcpConnOptions, p.listenFunc, p.acceptOptions(cfg.ListenerAddress))
+	if err != nil {
+		return err
+	}
+	p.listeners = append(p.listeners, l)
+	p.staticListeners[cfg.BrokerAddress] = append(p.staticListeners[cfg.BrokerAddress], l)
+	p.listening[cfg.BrokerAddress] = true
+
+	// local clients connect to the same broker through the Unix socket, TLS is not used
+	if path, ok := p.unixSockets[cfg.ListenerAddress]; ok {
+		unixCfg := cfg
+		unixCfg.ListenerAddress = "unix:" + path
+		unixListenFunc := func(config.ListenerConfig) (net.Listener, error) {
+			return listenUnix(path)
+		}
+		l, err = listenInstance(p.connSrc, unixCfg, p.tcpConnOptions, unixListenFunc, p.acceptOptions(cfg.ListenerAddress))
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+		p.staticListeners[cfg.BrokerAddress] = append(p.staticListeners[cfg.BrokerAddress], l)
+	}
+	return nil
+}
+
+// AddMapping maps the broker address at runtime. The listener of a bootstrap server or a dynamic mapping is started,
+// an external mapping advertises the address without a listener.
+func (p *Listeners) AddMapping(kind string, cfg config.ListenerConfig) (config.ListenerConfig, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return config.ListenerConfig{}, errors.New("listeners are closed")
+	}
+	if lc, ok := p.brokerToListenerConfig[cfg.BrokerAddress]; ok {
+		return config.ListenerConfig{}, fmt.Errorf("broker %s is already mapped to %s", cfg.BrokerAddress, lc.AdvertisedAddress)
+	}
+	switch kind {
+	case MappingBootstrap:
+		if err := p.listenStatic(cfg); err != nil {
+			p.closeStatic(cfg.BrokerAddress)
+			delete(p.listening, cfg.BrokerAddress)
+			return config.ListenerConfig{}, err
+		}
+		p.brokerToListenerConfig[cfg.BrokerAddress] = cfg
+	case MappingExternal:
+		if cfg.ListenerAddress != cfg.AdvertisedAddress {
+			return config.ListenerConfig{}, fmt.Errorf("external server mapping has different listener and advertised addresses %v", cfg)
+		}
+		p.brokerToListenerConfig[cfg.BrokerAddress] = cfg
+	case MappingDynamic:
+		if _, _, err := p.listenDynamic(cfg.BrokerAddress); err != nil {
+			return config.ListenerConfig{}, err
+		}
+	default:
+		return config.ListenerConfig{}, fmt.Errorf("mapping kind must be %s, %s or %s, got '%s'", MappingBootstrap, MappingExternal, MappingDynamic, kind)
+	}
+	logrus.Infof("Broker %s mapped to %s as %s server", cfg.BrokerAddress, p.brokerToListenerConfig[cfg.BrokerAddress].AdvertisedAddress, kind)
+	return p.brokerToListenerConfig[cfg.BrokerAddress], nil
+}
+
+// RemoveMapping removes the mapping of the broker address and closes its listeners. Already accepted connections are not closed.
+func (p *Listeners) RemoveMapping(brokerAddress string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.brokerToListenerConfig[brokerAddress]; !ok {
+		return fmt.Errorf("broker %s is not mapped", brokerAddress)
+	}
+	if l, ok := p.dynamicListeners[brokerAddress]; ok {
+		p.closeListener(l)
+		delete(p.dynamicListeners, brokerAddress)
+	}
+	p.closeStatic(brokerAddress)
+	delete(p.listening, brokerAddress)
+	delete(p.brokerToListenerConfig, brokerAddress)
+	logrus.Infof("Broker %s mapping removed", brokerAddress)
+	return nil
+}
+
+// Mappings returns the bootstrap and external server mappings, the dynamic mappings are not included
+func (p *Listeners) Mappings() config.ListenerMappings {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	mappings := config.ListenerMappings{BootstrapServerMappings: make([]string, 0), ExternalServerMappings: make([]string, 0)}
+	for brokerAddress, lc := range p.brokerToListenerConfig {
+		if _, ok := p.dynamicListeners[brokerAddress]; ok {
+			continue
+		}
+		if p.listening[brokerAddress] {
+			mappings.BootstrapServerMappings = append(mappings.BootstrapServerMappings, lc.Mapping())
+		} else {
+			mappings.ExternalServerMappings = append(mappings.ExternalServerMappings, lc.Mapping())
+		}
+	}
+	sort.Strings(mappings.BootstrapServerMappings)
+	sort.Strings(mappings.ExternalServerMappings)
+	return mappings
+}
+
+func (p *Listeners) closeStatic(brokerAddress string) {
+	for _, l := range p.staticListeners[brokerAddress] {
+		p.closeListener(l)
+	}
+	delete(p.staticListeners, brokerAddress)
+}
+
+// closeListener closes the started listener and removes it from the listeners
+func (p *Listeners) closeListener(l net.Listener) {
+	if err := l.Close(); err != nil {
+		logrus.Infof("Closing listener %v had error: %v", l.Addr(), err)
+	}
+	for i, v := range p.listeners {
+		if v == l {
+			p.listeners = append(p.listeners[:i], p.listeners[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *Listeners) acceptOptions(listenerAddress string) acceptOptions {
+	return acceptOptions{
+		sourceFilter:            p.sourceFilters.get(listenerAddress),
+		acceptRate:              p.acceptRate,
+		acceptBurst:             p.acceptBurst,
+		maxConcurrentHandshakes: p.maxConcurrentHandshakes,
+		handshakeTimeout:        p.handshakeTimeout,
+		clientAuthAudit:         p.clientAuthAudit(listenerAddress),
+		clientCertFingerprints:  p.clientCertFingerprints,
+		unixPeers:               p.unixPeers,
+		paused:                  &p.paused,
+		loadShedder:             p.loadShedder,
+	}
+}
+
+// clientAuthAudit returns the audit of the listener TLS override or the global one
+func (p *Listeners) clientAuthAudit(listenerAddress string) *clientAuthAudit {
+	if audit, ok := p.clientAuthAudits[listenerAddress]; ok {
+		return audit
+	}
+	return p.clientAuthAudits[""]
+}
+
+// Pause rejects new connections until Resume is called. Already accepted connections are not closed.
+func (p *Listeners) Pause() {
+	if atomic.CompareAndSwapInt32(&p.paused, 0, 1) {
+		logrus.Info("Listeners are paused, new connections are rejected")
+	}
+}
+
+// Resume accepts new connections again after Pause
+func (p *Listeners) Resume() {
+	if atomic.CompareAndSwapInt32(&p.paused, 1, 0) {
+		logrus.Info("Listeners are resumed")
+	}
+}
+
+// Close stops all started listeners. Already accepted connections are not closed.
+func (p *Listeners) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, l := range p.listeners {
+		if err := l.Close(); err != nil {
+			logrus.Infof("Closing listener %v had error: %v", l.Addr(), err)
+		}
+	}
+	p.listeners = nil
+	p.dynamicListeners = make(map[string]net.Listener)
+	p.staticListeners = make(map[string][]net.Listener)
+	p.closed = true
+}
+
+func listenInstance(dst chan<- Conn, cfg config.ListenerConfig, opts TCPConnOptions, listenFunc ListenFunc, acceptOpts acceptOptions) (net.Listener, error) {
 	l, err := listenFunc(cfg)
 	if err != nil {
 		return nil, err
 	}
+	limiter := newRateLimiter(acceptOpts.acceptRate, acceptOpts.acceptBurst)
+	var handshakeSlots chan struct{}
+	if acceptOpts.maxConcurrentHandshakes > 0 {
+		handshakeSlots = make(chan struct{}, acceptOpts.maxConcurrentHandshakes)
+	}
 	go withRecover(func() {
 		for {
+			// pending connections wait in the listen backlog
+			if delay := limiter.take(); delay > 0 {
+				proxyAcceptThrottledTotal.WithLabelValues(l.Addr().String()).Inc()
+				time.Sleep(delay)
+			}
 			c, err := l.Accept()
 			if err != nil {
 				logrus.Infof("Error in accept for %q on %v: %v", cfg, cfg.ListenerAddress, err)
 				l.Close()
 				return
 			}
+			if acceptOpts.paused != nil && atomic.LoadInt32(acceptOpts.paused) == 1 {
+				logrus.Infof("Rejected connection from %v on %v: proxy is paused", c.RemoteAddr(), l.Addr())
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonPaused).Inc()
+				c.Close()
+				continue
+			}
+			if reason, overloaded := acceptOpts.loadShedder.overloaded(); overloaded {
+				logrus.Debugf("Rejected connection from %v on %v: proxy is overloaded (%s)", c.RemoteAddr(), l.Addr(), reason)
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonOverloaded).Inc()
+				c.Close()
+				continue
+			}
+			if reason, ok := acceptOpts.sourceFilter.accept(c.RemoteAddr()); !ok {
+				logrus.Infof("Rejected connection from %v on %v: %s", c.RemoteAddr(), l.Addr(), reason)
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), reason).Inc()
+				c.Close()
+				continue
+			}
+			principal, err := acceptOpts.unixPeers.principal(c)
+			if err != nil {
+				logrus.Infof("Rejected connection on %v: %v", l.Addr(), err)
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonUnknownPeer).Inc()
+				c.Close()
+				continue
+			}
 			if tcpConn, ok := c.(*net.TCPConn); ok {
 				if err := opts.setTCPConnOptions(tcpConn); err != nil {
 					logrus.Infof("WARNING: Error while setting TCP options for accepted connection %q on %v: %v", cfg, l.Addr().String(), err)
 				}
 			}
+			tlsConn, isTLS := c.(*tls.Conn)
+			if isTLS && (handshakeSlots != nil || acceptOpts.clientAuthAudit != nil || acceptOpts.clientCertFingerprints != nil) {
+				go withConnectionRecover(panicGoroutineHandshake, tlsConn.RemoteAddr().String(), func() {
+					if err := tlsHandshake(tlsConn, handshakeSlots, acceptOpts.handshakeTimeout); err != nil {
+						logrus.Infof("TLS handshake with %v on %v failed: %v", tlsConn.RemoteAddr(), l.Addr(), err)
+						proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonHandshakeFailed).Inc()
+						tlsConn.Close()
+						return
+					}
+					if err := acceptOpts.clientCertFingerprints.check(tlsConn); err != nil {
+						logrus.Infof("Rejected connection from %v on %v: %v", tlsConn.RemoteAddr(), l.Addr(), err)
+						proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonNotEnrolled).Inc()
+						tlsConn.Close()
+						return
+					}
+					acceptOpts.clientAuthAudit.check(tlsConn, l.Addr().String())
+					logrus.Infof("New connection for %s", cfg.BrokerAddress)
+					dst <- Conn{BrokerAddress: cfg.BrokerAddress, LocalConnection: tlsConn, TLS: true}
+				}, tlsConn)
+				continue
+			}
 			logrus.Infof("New connection for %s", cfg.BrokerAddress)
-			dst <- Conn{BrokerAddress: cfg.BrokerAddress, LocalConnection: c}
+			dst <- Conn{BrokerAddress: cfg.BrokerAddress, LocalConnection: c, PeerPrincipal: principal, TLS: isTLS}
 		}
 	})
 
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/stretchr/testify/assert"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestGetBrokerToListenerConfig(t *testing.T) {
@@ -187,3 +189,72 @@ func TestGetBrokerToListenerConfig(t *testing.T) {
 		a.Equal(tt.mapping, mapping)
 	}
 }
+
+func TestGetNetAddressMappingOfUnmappedBrokers(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.DisableDynamicListeners = true
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	_, _, err = listeners.GetNetAddressMapping("kafka-1.grepplabs.com", 9092)
+	a.EqualError(err, "net address mapping for kafka-1.grepplabs.com:9092 was not found")
+
+	c.Proxy.UnmappedBrokers = config.UnmappedBrokersPassthrough
+	listeners, err = NewListeners(c)
+	a.Nil(err)
+	host, port, err := listeners.GetNetAddressMapping("kafka-1.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal("kafka-1.grepplabs.com", host)
+	a.Equal(int32(9092), port)
+
+	c = config.NewConfig()
+	listeners, err = NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+	host, port, err = listeners.GetNetAddressMapping("kafka-1.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal("127.0.0.1", host)
+	a.NotEqual(int32(9092), port)
+	// the second lookup returns the started listener
+	host2, port2, err := listeners.GetNetAddressMapping("kafka-1.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal(host, host2)
+	a.Equal(port, port2)
+}
+
+func TestListenersPauseRejectsNewConnections(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+
+	_, err = listeners.AddMapping(MappingBootstrap, config.ListenerConfig{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy:32401"})
+	a.Nil(err)
+	address := listeners.staticListeners["kafka-1:9092"][0].Addr().String()
+
+	// the rejected connection is closed by the proxy
+	listeners.Pause()
+	client, err := net.Dial("tcp", address)
+	a.Nil(err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	a.NotNil(err)
+	select {
+	case conn := <-listeners.connSrc:
+		conn.LocalConnection.Close()
+		a.Fail("connection accepted while paused")
+	default:
+	}
+
+	listeners.Resume()
+	client, err = net.Dial("tcp", address)
+	a.Nil(err)
+	defer client.Close()
+	conn := <-listeners.connSrc
+	a.Equal("kafka-1:9092", conn.BrokerAddress)
+	conn.LocalConnection.Close()
+}
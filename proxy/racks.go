@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+type rackNetwork struct {
+	rack    string
+	network *net.IPNet
+	host    string
+}
+
+// rackAdvertisedHosts advertises the brokers with the host of the rack of the client network, so the clients in each availability zone
+// connect to the proxy in their zone. The network of the client is used as the client rack of the Fetch requests is sent after the Metadata.
+type rackAdvertisedHosts struct {
+	networks []rackNetwork
+}
+
+// newRackAdvertisedHosts returns nil if no rack advertised hosts are configured
+func newRackAdvertisedHosts(c *config.Config) (*rackAdvertisedHosts, error) {
+	if len(c.Proxy.RackAdvertisedHosts) == 0 {
+		return nil, nil
+	}
+	r := &rackAdvertisedHosts{}
+	for _, v := range c.Proxy.RackAdvertisedHosts {
+		rack, network, host, err := config.ParseRackAdvertisedHost(v)
+		if err != nil {
+			return nil, err
+		}
+		r.networks = append(r.networks, rackNetwork{rack: rack, network: network, host: host})
+		logrus.Infof("Clients from %s (rack %s) will be advertised host %s", network, rack, host)
+	}
+	return r, nil
+}
+
+// rackOf returns the first rack which network contains the client address
+func (r *rackAdvertisedHosts) rackOf(addr net.Addr) (rackNetwork, bool) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return rackNetwork{}, false
+	}
+	for _, n := range r.networks {
+		if n.network.Contains(ip) {
+			return n, true
+		}
+	}
+	return rackNetwork{}, false
+}
+
+// rackName returns the rack of the client address, empty if it is in no rack network
+func (r *rackAdvertisedHosts) rackName(addr net.Addr) string {
+	if r == nil {
+		return ""
+	}
+	rack, _ := r.rackOf(addr)
+	return rack.rack
+}
+
+// netAddressMappingFunc returns the mapping of the client connection which replaces the advertised host with the host of the client rack
+func (r *rackAdvertisedHosts) netAddressMappingFunc(clientAddr net.Addr, fn config.NetAddressMappingFunc) config.NetAddressMappingFunc {
+	if r == nil {
+		return fn
+	}
+	rack, ok := r.rackOf(clientAddr)
+	if !ok {
+		return fn
+	}
+	proxyRackConnectionsTotal.WithLabelValues(rack.rack).Inc()
+	return func(brokerHost string, brokerPort int32) (string, int32, error) {
+		listenerHost, listenerPort, err := fn(brokerHost, brokerPort)
+		if err != nil {
+			return "", 0, err
+		}
+		if listenerHost == brokerHost && listenerPort == brokerPort {
+			// passed through unmapped broker
+			return listenerHost, listenerPort, nil
+		}
+		return rack.host, listenerPort, nil
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestRackAdvertisedHostsMapping(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("kafka-0.grepplabs.com:9092")
+	disabled, err := newRackAdvertisedHosts(c)
+	a.Nil(err)
+	a.Nil(disabled)
+
+	c.Proxy.RackAdvertisedHosts = []string{"az1,10.0.1.0/24,proxy-az1.grepplabs.com", "az2,10.0.2.0/24,proxy-az2.grepplabs.com"}
+	racks, err := newRackAdvertisedHosts(c)
+	a.Nil(err)
+
+	mapping := func(brokerHost string, brokerPort int32) (string, int32, error) {
+		if brokerHost == "kafka-9.grepplabs.com" {
+			return brokerHost, brokerPort, nil
+		}
+		return "proxy.grepplabs.com", 32400, nil
+	}
+	fn := racks.netAddressMappingFunc(&net.TCPAddr{IP: net.ParseIP("10.0.2.15"), Port: 50000}, mapping)
+	host, port, err := fn("kafka-0.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal("proxy-az2.grepplabs.com", host)
+	a.Equal(int32(32400), port)
+	// passed through broker is not changed
+	host, port, err = fn("kafka-9.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal("kafka-9.grepplabs.com", host)
+	a.Equal(int32(9092), port)
+
+	// client from other network
+	fn = racks.netAddressMappingFunc(&net.TCPAddr{IP: net.ParseIP("10.0.3.15"), Port: 50000}, mapping)
+	host, _, err = fn("kafka-0.grepplabs.com", 9092)
+	a.Nil(err)
+	a.Equal("proxy.grepplabs.com", host)
+}
+
+func TestProxyAdvertisesRackHost(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.RackAdvertisedHosts = []string{"local,127.0.0.0/8,proxy-local.grepplabs.com"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+	_, port, err := util.SplitHostPort(listenerAddress)
+	a.Nil(err)
+
+	a.Equal([]kafkatest.BrokerAddress{{NodeID: 1, Host: "proxy-local.grepplabs.com", Port: port}}, metadataBrokersThroughProxy(a, listenerAddress))
+}
@@ -0,0 +1,39 @@
+package proxy
+
+// readOnlyForbiddenApiKeys are the Kafka requests which modify data, topics, configs or the cluster state.
+// Metadata, Fetch, ListOffsets, consumer group and OffsetCommit requests are still allowed, so consumers can read.
+var readOnlyForbiddenApiKeys = []int16{
+	0,  // Produce
+	4,  // LeaderAndIsr
+	5,  // StopReplica
+	6,  // UpdateMetadata
+	7,  // ControlledShutdown
+	19, // CreateTopics
+	20, // DeleteTopics
+	21, // DeleteRecords
+	22, // InitProducerId
+	24, // AddPartitionsToTxn
+	25, // AddOffsetsToTxn
+	26, // EndTxn
+	27, // WriteTxnMarkers
+	28, // TxnOffsetCommit
+	30, // CreateAcls
+	31, // DeleteAcls
+	33, // AlterConfigs
+	34, // AlterReplicaLogDirs
+	37, // CreatePartitions
+	38, // CreateDelegationToken
+	39, // RenewDelegationToken
+	40, // ExpireDelegationToken
+	42, // DeleteGroups
+	43, // ElectLeaders
+	44, // IncrementalAlterConfigs
+	45, // AlterPartitionReassignments
+	47, // OffsetDelete
+	49, // AlterClientQuotas
+	51, // AlterUserScramCredentials
+	56, // AlterPartition
+	57, // UpdateFeatures
+	64, // UnregisterBroker
+	67, // AllocateProducerIds
+}
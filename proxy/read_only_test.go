@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyReadOnlyForbidsProduce(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ReadOnly = true
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 1, "app-1", kafkatest.MetadataRequestBody(1, nil)))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 2, "app-1", []byte{1}))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 3, "app-1", []byte{1}))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyFetch))
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+// recompression compresses the produced record batches sent to the brokers and the fetched record batches
+// sent to the clients with the configured codecs e.g. to save the bandwidth between the proxy and remote brokers
+type recompression struct {
+	// nil means the batches are not recompressed
+	produceCodec        *int8
+	fetchCodec          *int8
+	clientCodecs        []clientCodec
+	gzipLevel           int
+	maxDecompressedSize int
+}
+
+// clientCodec is the codec of the fetched batches sent to the clients from the network e.g. a branch office on a constrained link
+type clientCodec struct {
+	network *net.IPNet
+	codec   int8
+}
+
+// newRecompression returns nil if neither produced nor fetched batches are recompressed
+func newRecompression(c *config.Config) (*recompression, error) {
+	if c.Recompression.ProduceCodec == "" && c.Recompression.FetchCodec == "" && len(c.Recompression.ClientCodecs) == 0 {
+		return nil, nil
+	}
+	result := &recompression{gzipLevel: c.Recompression.GzipLevel, maxDecompressedSize: c.Compression.MaxDecompressedSize}
+	if c.Recompression.ProduceCodec != "" {
+		codec, err := config.ParseCompressionCodec(c.Recompression.ProduceCodec)
+		if err != nil {
+			return nil, err
+		}
+		result.produceCodec = &codec
+		logrus.Infof("Produced record batches will be recompressed with %s", c.Recompression.ProduceCodec)
+	}
+	if c.Recompression.FetchCodec != "" {
+		codec, err := config.ParseCompressionCodec(c.Recompression.FetchCodec)
+		if err != nil {
+			return nil, err
+		}
+		result.fetchCodec = &codec
+		logrus.Infof("Fetched record batches will be recompressed with %s", c.Recompression.FetchCodec)
+	}
+	for _, v := range c.Recompression.ClientCodecs {
+		network, codec, err := config.ParseClientCodec(v)
+		if err != nil {
+			return nil, err
+		}
+		result.clientCodecs = append(result.clientCodecs, clientCodec{network: network, codec: codec})
+		logrus.Infof("Fetched record batches sent to the clients will be recompressed with client codec %s", v)
+	}
+	return result, nil
+}
+
+// forClient returns the recompression of the connection from the client address. The codec of the first client network
+// containing the address replaces the fetch codec, nil is returned if the batches of the connection are not recompressed.
+func (r *recompression) forClient(addr net.Addr) *recompression {
+	if r == nil || len(r.clientCodecs) == 0 {
+		return r
+	}
+	ip := addrIP(addr)
+	for _, c := range r.clientCodecs {
+		if ip != nil && c.network.Contains(ip) {
+			codec := c.codec
+			result := *r
+			result.fetchCodec = &codec
+			return &result
+		}
+	}
+	if r.produceCodec == nil && r.fetchCodec == nil {
+		return nil
+	}
+	return r
+}
+
+func (r *recompression) requestModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.RequestModifier, error) {
+	if r == nil || r.produceCodec == nil || requestKeyVersion.ApiKey != apiKeyProduce {
+		return nil, nil
+	}
+	modifier, err := protocol.GetProduceRecompressModifier(requestKeyVersion.ApiVersion, *r.produceCodec, r.gzipLevel, r.maxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &recompressionModifier{modifier: modifier, direction: "produce"}, nil
+}
+
+func (r *recompression) responseModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.ResponseModifier, error) {
+	if r == nil || r.fetchCodec == nil || requestKeyVersion.ApiKey != apiKeyFetch {
+		return nil, nil
+	}
+	modifier, err := protocol.GetFetchRecompressModifier(requestKeyVersion.ApiVersion, *r.fetchCodec, r.gzipLevel, r.maxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &recompressionModifier{modifier: modifier, direction: "fetch"}, nil
+}
+
+// recompressionModifier reports the sizes of the messages before and after the recompression
+type recompressionModifier struct {
+	modifier interface {
+		Apply([]byte) ([]byte, error)
+	}
+	direction string
+}
+
+func (m *recompressionModifier) Apply(buf []byte) ([]byte, error) {
+	result, err := m.modifier.Apply(buf)
+	if err != nil {
+		return nil, err
+	}
+	proxyRecompressionBytesTotal.WithLabelValues(m.direction, "original").Add(float64(len(buf)))
+	proxyRecompressionBytesTotal.WithLabelValues(m.direction, "recompressed").Add(float64(len(result)))
+	return result, nil
+}
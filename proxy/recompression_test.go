@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestRecompressionRequestModifier(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := newRecompression(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	modifier, err := disabled.requestModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3})
+	a.Nil(err)
+	a.Nil(modifier)
+
+	c.Recompression.ProduceCodec = "gzip"
+	recompression, err := newRecompression(c)
+	a.Nil(err)
+	modifier, err = recompression.requestModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3})
+	a.Nil(err)
+	a.NotNil(modifier)
+	responseModifier, err := recompression.responseModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4})
+	a.Nil(err)
+	a.Nil(responseModifier)
+
+	value := make([]byte, 1024)
+	req := kafkatest.ProduceRequestBody("orders", [][]byte{value})
+	result, err := modifier.Apply(req)
+	a.Nil(err)
+	a.True(len(result) < len(req))
+
+	var values [][]byte
+	err = protocol.DecodeProduceRecordValues(3, result, c.Compression.MaxDecompressedSize, func(topic string, value []byte) error {
+		values = append(values, value)
+		return nil
+	})
+	a.Nil(err)
+	a.Equal([][]byte{value}, values)
+}
+
+func TestRecompressionForClient(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *recompression
+	a.Nil(disabled.forClient(&net.TCPAddr{IP: net.ParseIP("10.0.1.1"), Port: 1234}))
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Recompression.ClientCodecs = []string{"10.0.1.0/24=gzip"}
+	recompression, err := newRecompression(c)
+	a.Nil(err)
+	a.NotNil(recompression)
+
+	branch := recompression.forClient(&net.TCPAddr{IP: net.ParseIP("10.0.1.1"), Port: 1234})
+	a.NotNil(branch)
+	modifier, err := branch.responseModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4})
+	a.Nil(err)
+	a.NotNil(modifier)
+	requestModifier, err := branch.requestModifier(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3})
+	a.Nil(err)
+	a.Nil(requestModifier)
+	a.Nil(recompression.fetchCodec)
+
+	// other clients are not recompressed
+	a.Nil(recompression.forClient(&net.TCPAddr{IP: net.ParseIP("10.0.2.1"), Port: 1234}))
+
+	c.Recompression.FetchCodec = "none"
+	recompression, err = newRecompression(c)
+	a.Nil(err)
+	other := recompression.forClient(&net.TCPAddr{IP: net.ParseIP("10.0.2.1"), Port: 1234})
+	a.NotNil(other)
+	a.Equal(int8(0), *other.fetchCodec)
+	a.NotEqual(int8(0), *recompression.forClient(&net.TCPAddr{IP: net.ParseIP("10.0.1.1"), Port: 1234}).fetchCodec)
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	redactedFieldValue   = "[REDACTED]"
+	encryptedFieldPrefix = "enc:v1:"
+	dataKeySize          = 32
+)
+
+type recordFieldAction int
+
+const (
+	redactRecordField recordFieldAction = iota
+	encryptRecordField
+)
+
+type recordFieldRule struct {
+	pattern *regexp.Regexp
+	path    []string
+	action  recordFieldAction
+}
+
+// recordFieldsTransformer redacts or encrypts the fields of JSON record values.
+// Values in the schema registry wire format keep the magic byte and schema id.
+type recordFieldsTransformer struct {
+	rules      []*recordFieldRule
+	encryption *envelopeEncryption
+}
+
+// newRecordFieldsTransformer returns nil if no record fields are configured
+func newRecordFieldsTransformer(c *config.Config) (apis.RecordTransformer, error) {
+	if len(c.RecordFields.Redact) == 0 && len(c.RecordFields.Encrypt) == 0 {
+		return nil, nil
+	}
+	transformer := &recordFieldsTransformer{}
+	for _, v := range c.RecordFields.Redact {
+		pattern, path, err := config.ParseRecordField(v)
+		if err != nil {
+			return nil, err
+		}
+		transformer.rules = append(transformer.rules, &recordFieldRule{pattern: pattern, path: path, action: redactRecordField})
+	}
+	for _, v := range c.RecordFields.Encrypt {
+		pattern, path, err := config.ParseRecordField(v)
+		if err != nil {
+			return nil, err
+		}
+		transformer.rules = append(transformer.rules, &recordFieldRule{pattern: pattern, path: path, action: encryptRecordField})
+	}
+	if len(c.RecordFields.Encrypt) != 0 {
+		encryption, err := newEnvelopeEncryptionFromFile(c.RecordFields.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transformer.encryption = encryption
+	}
+	if len(c.RecordFields.Redact) != 0 {
+		logrus.Infof("Record fields %v will be redacted", c.RecordFields.Redact)
+	}
+	if len(c.RecordFields.Encrypt) != 0 {
+		logrus.Infof("Record fields %v will be encrypted", c.RecordFields.Encrypt)
+	}
+	return transformer, nil
+}
+
+func (t *recordFieldsTransformer) rulesFor(topic string, encryptedOnly bool) []*recordFieldRule {
+	var result []*recordFieldRule
+	for _, rule := range t.rules {
+		if encryptedOnly && rule.action != encryptRecordField {
+			continue
+		}
+		if rule.pattern.MatchString(topic) {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+func (t *recordFieldsTransformer) TransformProduced(topic string, value []byte) ([]byte, error) {
+	rules := t.rulesFor(topic, false)
+	if len(rules) == 0 || value == nil {
+		return value, nil
+	}
+	prefix, doc, err := decodeJSONRecord(value)
+	if err != nil {
+		return nil, fmt.Errorf("record produced to topic %s: %v", topic, err)
+	}
+	for _, rule := range rules {
+		parent, name, ok := lookupRecordField(doc, rule.path)
+		if !ok {
+			continue
+		}
+		switch rule.action {
+		case redactRecordField:
+			parent[name] = redactedFieldValue
+		case encryptRecordField:
+			plaintext, err := encodeJSON(parent[name])
+			if err != nil {
+				return nil, err
+			}
+			ciphertext, err := t.encryption.encrypt(plaintext)
+			if err != nil {
+				return nil, err
+			}
+			parent[name] = encryptedFieldPrefix + base64.StdEncoding.EncodeToString(ciphertext)
+		}
+	}
+	return encodeJSONRecord(prefix, doc)
+}
+
+func (t *recordFieldsTransformer) TransformFetched(topic string, value []byte) ([]byte, error) {
+	rules := t.rulesFor(topic, true)
+	if len(rules) == 0 || value == nil {
+		return value, nil
+	}
+	prefix, doc, err := decodeJSONRecord(value)
+	if err != nil {
+		// e.g. records produced before the encryption was enabled
+		return value, nil
+	}
+	changed := false
+	for _, rule := range rules {
+		parent, name, ok := lookupRecordField(doc, rule.path)
+		if !ok {
+			continue
+		}
+		encoded, ok := parent[name].(string)
+		if !ok || !strings.HasPrefix(encoded, encryptedFieldPrefix) {
+			continue
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, encryptedFieldPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("record fetched from topic %s: invalid encrypted field %s", topic, strings.Join(rule.path, "."))
+		}
+		plaintext, err := t.encryption.decrypt(ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("record fetched from topic %s: field %s: %v", topic, strings.Join(rule.path, "."), err)
+		}
+		var fieldValue interface{}
+		if err = decodeJSON(plaintext, &fieldValue); err != nil {
+			return nil, err
+		}
+		parent[name] = fieldValue
+		changed = true
+	}
+	if !changed {
+		return value, nil
+	}
+	return encodeJSONRecord(prefix, doc)
+}
+
+// lookupRecordField returns the object holding the last field of the path
+func lookupRecordField(doc map[string]interface{}, path []string) (map[string]interface{}, string, bool) {
+	parent := doc
+	for _, name := range path[:len(path)-1] {
+		child, ok := parent[name].(map[string]interface{})
+		if !ok {
+			return nil, "", false
+		}
+		parent = child
+	}
+	name := path[len(path)-1]
+	if _, ok := parent[name]; !ok {
+		return nil, "", false
+	}
+	return parent, name, true
+}
+
+// decodeJSONRecord returns the schema registry wire format header (if any) and the JSON object of the record value
+func decodeJSONRecord(value []byte) ([]byte, map[string]interface{}, error) {
+	var prefix []byte
+	if len(value) >= schemaRegistryHeaderSize && value[0] == schemaRegistryMagicByte {
+		prefix, value = value[:schemaRegistryHeaderSize], value[schemaRegistryHeaderSize:]
+	}
+	var doc map[string]interface{}
+	if err := decodeJSON(value, &doc); err != nil {
+		return nil, nil, errors.Wrap(err, "value is not a JSON object")
+	}
+	if doc == nil {
+		return nil, nil, errors.New("value is not a JSON object")
+	}
+	return prefix, doc, nil
+}
+
+func encodeJSONRecord(prefix []byte, doc map[string]interface{}) ([]byte, error) {
+	value, err := encodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, prefix...), value...), nil
+}
+
+// decodeJSON keeps the numbers as json.Number to preserve their precision
+func decodeJSON(buf []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// envelopeEncryption encrypts each value with a new data key. The data key is encrypted with the key encryption key
+// and stored with the value: wrapped key length (uint16), wrapped key, encrypted value. All keys are AES-256-GCM.
+type envelopeEncryption struct {
+	keyEncryptionKey cipher.AEAD
+}
+
+// newEnvelopeEncryptionFromFile reads the base64 encoded 256 bit key encryption key
+func newEnvelopeEncryptionFromFile(filename string) (*envelopeEncryption, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "encryption key file %s must contain base64 encoded key", filename)
+	}
+	if len(key) != dataKeySize {
+		return nil, errors.Errorf("encryption key in file %s must be %d bytes long", filename, dataKeySize)
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeEncryption{keyEncryptionKey: aead}, nil
+}
+
+func (e *envelopeEncryption) encrypt(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := sealAEAD(e.keyEncryptionKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := sealAEAD(aead, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 2, 2+len(wrappedKey)+len(ciphertext))
+	binary.BigEndian.PutUint16(result, uint16(len(wrappedKey)))
+	result = append(result, wrappedKey...)
+	return append(result, ciphertext...), nil
+}
+
+func (e *envelopeEncryption) decrypt(buf []byte) ([]byte, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("invalid encrypted value")
+	}
+	keyLength := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < 2+keyLength {
+		return nil, errors.New("invalid encrypted value")
+	}
+	dataKey, err := openAEAD(e.keyEncryptionKey, buf[2:2+keyLength])
+	if err != nil {
+		return nil, errors.Wrap(err, "data key cannot be decrypted")
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, buf[2+keyLength:])
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAEAD returns the nonce followed by the ciphertext
+func sealAEAD(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAEAD(aead cipher.AEAD, buf []byte) ([]byte, error) {
+	if len(buf) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return aead.Open(nil, buf[:aead.NonceSize()], buf[aead.NonceSize():], nil)
+}
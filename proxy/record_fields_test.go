@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestRecordFieldsTransformer(a *assert.Assertions) *recordFieldsTransformer {
+	keyFile, err := ioutil.TempFile("", "record-encryption-key")
+	a.Nil(err)
+	defer os.Remove(keyFile.Name())
+	_, err = keyFile.WriteString(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))) + "\n")
+	a.Nil(err)
+	keyFile.Close()
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.RecordFields.Redact = []string{"^orders$=customer.phone"}
+	c.RecordFields.Encrypt = []string{"^orders$=customer.email", "^orders$=amount"}
+	c.RecordFields.EncryptionKeyFile = keyFile.Name()
+	a.Nil(c.Validate())
+	transformer, err := newRecordFieldsTransformer(c)
+	a.Nil(err)
+	return transformer.(*recordFieldsTransformer)
+}
+
+func TestRecordFieldsAreRedactedAndEncrypted(t *testing.T) {
+	a := assert.New(t)
+
+	transformer := newTestRecordFieldsTransformer(a)
+	value := []byte(`{"amount":12.50,"customer":{"email":"a@b.com","name":"Ann","phone":"+49 1234"}}`)
+
+	produced, err := transformer.TransformProduced("orders", value)
+	a.Nil(err)
+	a.NotContains(string(produced), "a@b.com")
+	a.NotContains(string(produced), "+49 1234")
+	a.NotContains(string(produced), "12.50")
+	a.Contains(string(produced), `"name":"Ann"`)
+	a.Contains(string(produced), `"phone":"[REDACTED]"`)
+
+	fetched, err := transformer.TransformFetched("orders", produced)
+	a.Nil(err)
+	a.Equal(`{"amount":12.50,"customer":{"email":"a@b.com","name":"Ann","phone":"[REDACTED]"}}`, string(fetched))
+
+	// other topics and tombstones are not transformed
+	produced, err = transformer.TransformProduced("payments", []byte("plain"))
+	a.Nil(err)
+	a.Equal([]byte("plain"), produced)
+	produced, err = transformer.TransformProduced("orders", nil)
+	a.Nil(err)
+	a.Nil(produced)
+}
+
+func TestRecordFieldsKeepSchemaRegistryHeader(t *testing.T) {
+	a := assert.New(t)
+
+	transformer := newTestRecordFieldsTransformer(a)
+	value := append([]byte{0, 0, 0, 0, 7}, `{"customer":{"email":"a@b.com"}}`...)
+
+	produced, err := transformer.TransformProduced("orders", value)
+	a.Nil(err)
+	a.Equal(value[:5], produced[:5])
+
+	fetched, err := transformer.TransformFetched("orders", produced)
+	a.Nil(err)
+	a.Equal(value, fetched)
+}
+
+func TestRecordFieldsRejectNotJSONRecords(t *testing.T) {
+	a := assert.New(t)
+
+	transformer := newTestRecordFieldsTransformer(a)
+	_, err := transformer.TransformProduced("orders", []byte("plain"))
+	a.NotNil(err)
+
+	// fetched records which are not JSON are returned unchanged
+	fetched, err := transformer.TransformFetched("orders", []byte("plain"))
+	a.Nil(err)
+	a.Equal([]byte("plain"), fetched)
+}
+
+func TestRecordTransformForbidsVersionsWithoutRecordBatches(t *testing.T) {
+	a := assert.New(t)
+
+	transform := newRecordTransform(1024*1024, newTestRecordFieldsTransformer(a))
+	a.True(transform.isForbidden(kafkatest.ApiKeyProduce, 2))
+	a.False(transform.isForbidden(kafkatest.ApiKeyProduce, 3))
+	a.True(transform.isForbidden(kafkatest.ApiKeyProduce, 9))
+	a.True(transform.isForbidden(kafkatest.ApiKeyFetch, 3))
+	a.False(transform.isForbidden(kafkatest.ApiKeyFetch, 11))
+	a.False(transform.isForbidden(kafkatest.ApiKeyMetadata, 1))
+
+	a.Nil(newRecordTransform(1024*1024, nil))
+	var disabled *recordTransform
+	a.False(disabled.isForbidden(kafkatest.ApiKeyProduce, 2))
+}
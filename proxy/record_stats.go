@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"regexp"
+)
+
+// otherTopicsLabel is the topic label of the topics which are not labelled by name
+const otherTopicsLabel = "other"
+
+// recordStats exports the distributions of the record batch sizes and the records per request of the produced and fetched topics
+type recordStats struct {
+	// empty means all topics are labelled by name
+	topics []*regexp.Regexp
+}
+
+// newRecordStats returns nil if the record batches are not measured
+func newRecordStats(c *config.Config) (*recordStats, error) {
+	if !c.RecordStats.Enable {
+		return nil, nil
+	}
+	stats := &recordStats{}
+	for _, v := range c.RecordStats.Topics {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		stats.topics = append(stats.topics, re)
+	}
+	logrus.Infof("Record batch statistics of the produced and fetched topics %v will be exported", c.RecordStats.Topics)
+	return stats, nil
+}
+
+// inspects reports whether the Produce request body must be measured
+func (s *recordStats) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s != nil && requestKeyVersion.ApiKey == apiKeyProduce && recordStatsVersion(requestKeyVersion)
+}
+
+// inspectsResponse reports whether the Fetch response body must be measured
+func (s *recordStats) inspectsResponse(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return s != nil && requestKeyVersion.ApiKey == apiKeyFetch && recordStatsVersion(requestKeyVersion)
+}
+
+func recordStatsVersion(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	_, maxVersion, _ := protocol.RecordBatchVersions(requestKeyVersion.ApiKey)
+	return requestKeyVersion.ApiVersion <= maxVersion
+}
+
+// observeRequest measures the record batches of the Produce request
+func (s *recordStats) observeRequest(requestKeyVersion *protocol.RequestKeyVersion, req []byte) {
+	s.observe("produce", requestKeyVersion, req, protocol.DecodeProduceRecordBatchStats)
+}
+
+// observeResponse measures the record batches of the Fetch response
+func (s *recordStats) observeResponse(requestKeyVersion *protocol.RequestKeyVersion, resp []byte) {
+	s.observe("fetch", requestKeyVersion, resp, protocol.DecodeFetchRecordBatchStats)
+}
+
+func (s *recordStats) observe(direction string, requestKeyVersion *protocol.RequestKeyVersion, body []byte, decode func(int16, []byte, protocol.RecordBatchStatsFunc) error) {
+	if s == nil {
+		return
+	}
+	records := make(map[string]int)
+	err := decode(requestKeyVersion.ApiVersion, body, func(topic string, size int, n int) {
+		label := s.topicLabel(topic)
+		proxyRecordBatchSizeBytes.WithLabelValues(direction, label).Observe(float64(size))
+		records[label] += n
+	})
+	if err != nil {
+		logrus.Debugf("Record batches of %s key %d, version %d cannot be decoded: %v", direction, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, err)
+		return
+	}
+	for label, n := range records {
+		proxyRecordsPerRequest.WithLabelValues(direction, label).Observe(float64(n))
+	}
+}
+
+// topicLabel bounds the cardinality of the topic label with the allowlist
+func (s *recordStats) topicLabel(topic string) string {
+	if len(s.topics) == 0 {
+		return topic
+	}
+	for _, re := range s.topics {
+		if re.MatchString(topic) {
+			return topic
+		}
+	}
+	return otherTopicsLabel
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func histogramOf(a *assert.Assertions, histogram *prometheus.HistogramVec, labels ...string) *dto.Histogram {
+	m := &dto.Metric{}
+	a.Nil(histogram.WithLabelValues(labels...).(prometheus.Metric).Write(m))
+	return m.GetHistogram()
+}
+
+func TestRecordStats(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	stats, err := newRecordStats(c)
+	a.Nil(err)
+	a.Nil(stats)
+
+	c.RecordStats.Enable = true
+	c.RecordStats.Topics = []string{"^stats-orders$"}
+	stats, err = newRecordStats(c)
+	a.Nil(err)
+
+	produce := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}
+	a.True(stats.inspects(produce))
+	a.False(stats.inspectsResponse(produce))
+	a.True(stats.inspectsResponse(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4}))
+	_, maxVersion, _ := protocol.RecordBatchVersions(kafkatest.ApiKeyProduce)
+	a.False(stats.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: maxVersion + 1}))
+
+	stats.observeRequest(produce, kafkatest.ProduceRequestBody("stats-orders", [][]byte{[]byte("v1"), []byte("v2"), []byte("v3")}))
+	stats.observeRequest(produce, kafkatest.ProduceRequestBody("stats-events", [][]byte{[]byte("v1")}))
+
+	records := histogramOf(a, proxyRecordsPerRequest, "produce", "stats-orders")
+	a.Equal(uint64(1), records.GetSampleCount())
+	a.Equal(3.0, records.GetSampleSum())
+	size := histogramOf(a, proxyRecordBatchSizeBytes, "produce", "stats-orders")
+	a.Equal(uint64(1), size.GetSampleCount())
+	a.True(size.GetSampleSum() > 61)
+
+	a.Equal("stats-orders", stats.topicLabel("stats-orders"))
+	a.Equal(otherTopicsLabel, stats.topicLabel("stats-events"))
+	a.Equal(uint64(1), histogramOf(a, proxyRecordsPerRequest, "produce", otherTopicsLabel).GetSampleCount())
+
+	// not decodable
+	stats.observeRequest(produce, []byte{1, 2, 3})
+	a.Equal(uint64(1), histogramOf(a, proxyRecordsPerRequest, "produce", "stats-orders").GetSampleCount())
+
+	// nil safe
+	var disabled *recordStats
+	a.False(disabled.inspects(produce))
+	disabled.observeRequest(produce, nil)
+	disabled.observeResponse(produce, nil)
+}
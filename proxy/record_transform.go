@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+)
+
+// recordTransform applies the record transformers to the Produce requests and Fetch responses.
+// Fetched values are transformed in the reverse order.
+type recordTransform struct {
+	transformers        []apis.RecordTransformer
+	maxDecompressedSize int
+}
+
+// newRecordTransform returns nil if there are no transformers
+func newRecordTransform(maxDecompressedSize int, transformers ...apis.RecordTransformer) *recordTransform {
+	result := &recordTransform{maxDecompressedSize: maxDecompressedSize}
+	for _, transformer := range transformers {
+		if transformer != nil {
+			result.transformers = append(result.transformers, transformer)
+		}
+	}
+	if len(result.transformers) == 0 {
+		return nil
+	}
+	return result
+}
+
+// append returns the transform applying also the transformer
+func (t *recordTransform) append(maxDecompressedSize int, transformer apis.RecordTransformer) *recordTransform {
+	if t == nil {
+		return newRecordTransform(maxDecompressedSize, transformer)
+	}
+	return newRecordTransform(maxDecompressedSize, append(append([]apis.RecordTransformer{}, t.transformers...), transformer)...)
+}
+
+// isForbidden reports the Produce and Fetch versions without record batches which cannot be transformed
+func (t *recordTransform) isForbidden(apiKey int16, apiVersion int16) bool {
+	if t == nil {
+		return false
+	}
+	minVersion, maxVersion, ok := protocol.RecordBatchVersions(apiKey)
+	return ok && (apiVersion < minVersion || apiVersion > maxVersion)
+}
+
+func (t *recordTransform) requestModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.RequestModifier, error) {
+	if t == nil || requestKeyVersion.ApiKey != apiKeyProduce {
+		return nil, nil
+	}
+	return protocol.GetProduceRecordsModifier(requestKeyVersion.ApiVersion, t.maxDecompressedSize, t.produced)
+}
+
+func (t *recordTransform) responseModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.ResponseModifier, error) {
+	if t == nil || requestKeyVersion.ApiKey != apiKeyFetch {
+		return nil, nil
+	}
+	return protocol.GetFetchRecordsModifier(requestKeyVersion.ApiVersion, t.maxDecompressedSize, t.fetched)
+}
+
+func (t *recordTransform) produced(topic string, value []byte) ([]byte, error) {
+	var err error
+	for _, transformer := range t.transformers {
+		if value, err = transformer.TransformProduced(topic, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (t *recordTransform) fetched(topic string, value []byte) ([]byte, error) {
+	var err error
+	for i := len(t.transformers) - 1; i >= 0; i-- {
+		if value, err = t.transformers[i].TransformFetched(topic, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	rejectionActionAnswer = "answer"
+	rejectionActionClose  = "close"
+)
+
+// error codes of the rejected requests if not configured
+var defaultRejectionErrorCodes = map[string]protocol.KError{
+	config.RejectionAuth:   protocol.ErrSASLAuthenticationFailed,
+	config.RejectionACL:    protocol.ErrClusterAuthorizationFailed,
+	config.RejectionLimits: protocol.ErrPolicyViolation,
+	config.RejectionPolicy: protocol.ErrPolicyViolation,
+}
+
+// rejectionPolicy answers the requests rejected by the authentication, ACLs, limits or policies with a well-formed error response
+// before the connection is closed, so the clients report the error instead of a disconnection. The error message is sent in the
+// responses which have one, e.g. Produce version 8 and later. The connection is closed without a response if the response
+// of the api key has no top level error code known to the proxy.
+type rejectionPolicy struct {
+	message    string
+	errorCodes map[string]protocol.KError
+}
+
+// newRejectionPolicy returns nil if the connections of the rejected requests are closed without a response
+func newRejectionPolicy(c *config.Config) *rejectionPolicy {
+	if !c.Rejection.Enable {
+		return nil
+	}
+	p := &rejectionPolicy{
+		message:    c.Rejection.Message,
+		errorCodes: make(map[string]protocol.KError),
+	}
+	for reason, code := range defaultRejectionErrorCodes {
+		p.errorCodes[reason] = code
+	}
+	for _, v := range c.Rejection.ErrorCodes {
+		// validated by the config
+		if reason, code, err := config.ParseRejectionErrorCode(v); err == nil {
+			p.errorCodes[reason] = protocol.KError(code)
+		}
+	}
+	return p
+}
+
+// messageOf returns the error message of the rejection
+func (p *rejectionPolicy) messageOf(err error) string {
+	if p == nil || p.message == "" {
+		return err.Error()
+	}
+	return strings.Replace(p.message, "{reason}", err.Error(), -1)
+}
+
+// response returns the error response of the rejected request, false if the request is not answered
+func (p *rejectionPolicy) response(requestKeyVersion *protocol.RequestKeyVersion, req []byte, reason string, err error) ([]byte, bool) {
+	kerr, message := p.errorCodes[reason], p.messageOf(err)
+	if requestKeyVersion.ApiKey == apiKeyProduce {
+		// the errors of the partitions, acks 0 is not answered
+		resp, err := protocol.RejectProduce(requestKeyVersion.ApiVersion, req, kerr, message)
+		return resp, err == nil && resp != nil
+	}
+	return protocol.EncodeErrorResponseWithMessage(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, kerr, message)
+}
+
+// reject answers the rejected request with an error response if the rejections are answered, the connection is closed in both cases.
+// The body of a Produce request is read if it was not read before, req is nil.
+func (ctx *RequestsLoopContext) reject(src DeadlineReaderWriter, requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, req []byte, reason string, rejectErr error) (readErr bool, err error) {
+	if ctx.rejection == nil {
+		return true, rejectErr
+	}
+	action := rejectionActionClose
+	defer func() { proxyRejectionsTotal.WithLabelValues(reason, action).Inc() }()
+
+	// request header v0 has no correlation id
+	if len(headerBuf) < 4 {
+		return true, rejectErr
+	}
+	if req == nil && requestKeyVersion.ApiKey == apiKeyProduce {
+		if requestKeyVersion.Length > protocol.MaxRequestSize {
+			return true, rejectErr
+		}
+		req = make([]byte, int(requestKeyVersion.Length-4)-len(headerBuf))
+		if _, err = io.ReadFull(src, req); err != nil {
+			return true, rejectErr
+		}
+	}
+	resp, ok := ctx.rejection.response(requestKeyVersion, req, reason, rejectErr)
+	if !ok {
+		return true, rejectErr
+	}
+	// the rejected request itself is in flight
+	if err = ctx.writeLocalResponse(src, headerBuf, resp, 1); err != nil {
+		logrus.Debugf("Error response of the rejected request to %s was not written: %v", ctx.brokerAddress, err)
+		return true, rejectErr
+	}
+	action = rejectionActionAnswer
+	return true, rejectErr
+}
+
+// rejectUnread answers the rejected request which header was not read yet
+func (ctx *RequestsLoopContext) rejectUnread(src DeadlineReaderWriter, requestKeyVersion *protocol.RequestKeyVersion, reason string, rejectErr error) (readErr bool, err error) {
+	if ctx.rejection == nil {
+		return true, rejectErr
+	}
+	if err = src.SetReadDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return true, rejectErr
+	}
+	headerBuf, err := ctx.readRequestHeader(src, requestKeyVersion)
+	if err != nil {
+		return true, rejectErr
+	}
+	ctx.inFlight.add(1)
+	return ctx.reject(src, requestKeyVersion, headerBuf, nil, reason, rejectErr)
+}
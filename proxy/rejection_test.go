@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRejectionPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newRejectionPolicy(c))
+	var disabled *rejectionPolicy
+	a.Equal("topic \"orders\" is not allowed", disabled.messageOf(errors.New("topic \"orders\" is not allowed")))
+
+	c.Rejection.Enable = true
+	c.Rejection.Message = "{reason}, see https://wiki.example.com/kafka-access"
+	c.Rejection.ErrorCodes = []string{"acl=29"}
+	p := newRejectionPolicy(c)
+	a.Equal("denied, see https://wiki.example.com/kafka-access", p.messageOf(errors.New("denied")))
+
+	resp, ok := p.response(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFindCoordinator, ApiVersion: 1}, nil, config.RejectionACL, errors.New("denied"))
+	a.True(ok)
+	errs, err := protocol.DecodeResponseErrors(kafkatest.ApiKeyFindCoordinator, 1, resp)
+	a.Nil(err)
+	a.Equal([]protocol.KError{protocol.ErrTopicAuthorizationFailed}, errs)
+
+	resp, ok = p.response(&protocol.RequestKeyVersion{ApiKey: apiKeyProduce, ApiVersion: 3}, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")}), config.RejectionLimits, errors.New("too many connections"))
+	a.True(ok)
+	code, err := kafkatest.DecodeProduceErrorCode(resp)
+	a.Nil(err)
+	a.Equal(int16(protocol.ErrPolicyViolation), code)
+
+	// no top level error code
+	_, ok = p.response(&protocol.RequestKeyVersion{ApiKey: apiKeyMetadata, ApiVersion: 1}, nil, config.RejectionAuth, errors.New("denied"))
+	a.False(ok)
+}
+
+func TestProxyRejectionAnswersForbiddenApiKey(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ForbiddenApiKeys = []int{int(kafkatest.ApiKeyProduce)}
+	c.Rejection.Enable = true
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	answered := counterOf(a, proxyRejectionsTotal, config.RejectionACL, rejectionActionAnswer)
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 7, "test", kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("v1")})))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(7), correlationID)
+	code, err := kafkatest.DecodeProduceErrorCode(body)
+	a.Nil(err)
+	a.Equal(int16(protocol.ErrClusterAuthorizationFailed), code)
+	a.Equal(answered+1, counterOf(a, proxyRejectionsTotal, config.RejectionACL, rejectionActionAnswer))
+
+	// the connection is closed after the response
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
+
+func TestProxyRejectionAnswersForbiddenApiVersion(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Kafka.ForbiddenApiVersions = []string{"10=1"}
+	c.Rejection.Enable = true
+	c.Rejection.Message = "{reason}, see https://wiki.example.com/kafka-access"
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	answered := counterOf(a, proxyRejectionsTotal, config.RejectionPolicy, rejectionActionAnswer)
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// key "group-1" and key type 0
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFindCoordinator, 1, 3, "test", []byte{0, 7, 'g', 'r', 'o', 'u', 'p', '-', '1', 0}))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(3), correlationID)
+	errs, err := protocol.DecodeResponseErrors(kafkatest.ApiKeyFindCoordinator, 1, body)
+	a.Nil(err)
+	a.Equal([]protocol.KError{protocol.ErrUnsupportedVersion}, errs)
+	// throttle time, error code and the message of the policy
+	a.True(len(body) > 8)
+	length := int(binary.BigEndian.Uint16(body[6:]))
+	a.Equal("api key 10 version 1 is forbidden, see https://wiki.example.com/kafka-access", string(body[8:8+length]))
+	a.Equal(answered+1, counterOf(a, proxyRejectionsTotal, config.RejectionPolicy, rejectionActionAnswer))
+
+	// the connection stays open
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyApiVersions, 2, 4, "test", nil))
+	a.Nil(err)
+	correlationID, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(4), correlationID)
+}
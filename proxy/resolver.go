@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultResolverTimeout = 5 * time.Second
+
+// Resolver looks up the IP addresses of broker and forward proxy host names
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// lookupFunc returns the addresses and their TTL. Negative TTL means that TTL is unknown.
+type lookupFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+type resolverCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// resolver checks the static overrides first, then the cache and finally queries DNS
+type resolver struct {
+	hosts    map[string][]net.IP
+	lookup   lookupFunc
+	cacheTTL time.Duration
+
+	lock  sync.Mutex
+	cache map[string]resolverCacheEntry
+	now   func() time.Time
+}
+
+// newResolverIfEnabled returns nil if no resolver servers, static hosts or cache TTL are configured, the host names are
+// resolved by net.Dialer then
+func newResolverIfEnabled(c *config.Config) (Resolver, error) {
+	if len(c.Resolver.Servers) == 0 && len(c.Resolver.Hosts) == 0 && c.Resolver.CacheTTL == 0 {
+		return nil, nil
+	}
+	return newResolver(c)
+}
+
+func newResolver(c *config.Config) (*resolver, error) {
+	hosts, err := c.GetResolverHosts()
+	if err != nil {
+		return nil, err
+	}
+	timeout := c.Resolver.Timeout
+	if timeout <= 0 {
+		timeout = defaultResolverTimeout
+	}
+	var lookup lookupFunc
+	if len(c.Resolver.Servers) != 0 {
+		client := &dnsClient{servers: c.Resolver.Servers, timeout: timeout}
+		if c.Resolver.TLS.Enable {
+			if client.tlsConfig, err = newResolverTLSConfig(c); err != nil {
+				return nil, err
+			}
+			logrus.Infof("Broker names will be resolved by %v using DNS-over-TLS", c.Resolver.Servers)
+		} else {
+			logrus.Infof("Broker names will be resolved by %v", c.Resolver.Servers)
+		}
+		lookup = client.lookupIP
+	} else {
+		lookup = systemLookup(timeout)
+	}
+	return &resolver{
+		hosts:    hosts,
+		lookup:   lookup,
+		cacheTTL: c.Resolver.CacheTTL,
+		cache:    make(map[string]resolverCacheEntry),
+		now:      time.Now,
+	}, nil
+}
+
+func newResolverTLSConfig(c *config.Config) (*tls.Config, error) {
+	opts := c.Resolver.TLS
+	cfg := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify, ServerName: opts.ServerName}
+	if opts.CAChainCertFile != "" {
+		caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if ok := rootCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+			return nil, errors.New("Failed to parse resolver root certificate")
+		}
+		cfg.RootCAs = rootCAs
+	}
+	if c.FIPS.Enable {
+		restrictToFIPS(cfg)
+	}
+	return cfg, nil
+}
+
+func systemLookup(timeout time.Duration) lookupFunc {
+	return func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, 0, err
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return ips, -1, nil
+	}
+}
+
+func (r *resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if ips, ok := r.hosts[name]; ok {
+		return ips, nil
+	}
+	if ips, ok := r.getCached(name); ok {
+		return ips, nil
+	}
+	ips, ttl, err := r.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	r.putCached(name, ips, ttl)
+	return ips, nil
+}
+
+func (r *resolver) getCached(name string) ([]net.IP, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry, ok := r.cache[name]
+	if !ok {
+		return nil, false
+	}
+	if !r.now().Before(entry.expires) {
+		delete(r.cache, name)
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+func (r *resolver) putCached(name string, ips []net.IP, ttl time.Duration) {
+	if ttl < 0 || ttl > r.cacheTTL {
+		ttl = r.cacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cache[name] = resolverCacheEntry{ips: ips, expires: r.now().Add(ttl)}
+}
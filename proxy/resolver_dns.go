@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeA    = uint16(1)
+	dnsTypeAAAA = uint16(28)
+	dnsClassIN  = uint16(1)
+
+	dnsHeaderSize      = 12
+	dnsMaxUDPSize      = 512
+	dnsFlagResponse    = uint16(1 << 15)
+	dnsFlagTruncated   = uint16(1 << 9)
+	dnsFlagRecursion   = uint16(1 << 8)
+	dnsRcodeMask       = uint16(0x000F)
+	dnsRcodeNameError  = uint16(3)
+	dnsCompressionMask = byte(0xC0)
+	dnsMaxPointers     = 16
+)
+
+var errDNSMalformedMessage = errors.New("malformed DNS message")
+
+// dnsClient is a minimal stub resolver which queries A and AAAA records and reports their TTL.
+// Queries are sent over UDP with TCP fallback for truncated responses or over TLS (RFC 7858).
+type dnsClient struct {
+	servers   []string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+func (c *dnsClient) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		ips, ttl, err := c.lookupIPFrom(ctx, server, host)
+		if err == nil {
+			return ips, ttl, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+func (c *dnsClient) lookupIPFrom(ctx context.Context, server string, host string) ([]net.IP, time.Duration, error) {
+	ips := make([]net.IP, 0)
+	ttl := time.Duration(-1)
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		records, recordsTTL, err := c.query(ctx, server, host, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(records) != 0 && (ttl < 0 || recordsTTL < ttl) {
+			ttl = recordsTTL
+		}
+		ips = append(ips, records...)
+	}
+	if len(ips) == 0 {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: server}
+	}
+	return ips, ttl, nil
+}
+
+func (c *dnsClient) query(ctx context.Context, server string, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	id, err := newDNSQueryID()
+	if err != nil {
+		return nil, 0, err
+	}
+	query, err := newDNSQuery(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	var response []byte
+	if c.tlsConfig != nil {
+		response, err = c.exchangeStream(ctx, server, query, true)
+	} else {
+		response, err = c.exchangePacket(ctx, server, query)
+		if err == nil && len(response) >= dnsHeaderSize && binary.BigEndian.Uint16(response[2:])&dnsFlagTruncated != 0 {
+			response, err = c.exchangeStream(ctx, server, query, false)
+		}
+	}
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "DNS query to %s for %s failed", server, host)
+	}
+	return parseDNSResponse(id, host, qtype, response)
+}
+
+func (c *dnsClient) exchangePacket(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsMaxUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore responses to other queries
+		if n >= 2 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (c *dnsClient) exchangeStream(ctx context.Context, server string, query []byte, useTLS bool) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		config := c.tlsConfig
+		if config.ServerName == "" {
+			host, _, _ := net.SplitHostPort(server)
+			config = config.Clone()
+			config.ServerName = host
+		}
+		conn = tls.Client(conn, config)
+	}
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// messages are prefixed with a two byte length field
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err = conn.Write(msg); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(conn, msg[:2]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(msg))
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// newDNSQueryID returns an unpredictable query id, a spoofed response must guess it
+func newDNSQueryID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, errors.Wrap(err, "DNS query id cannot be generated")
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+func newDNSQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderSize, dnsHeaderSize+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.Errorf("invalid DNS name %s", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-4:], qtype)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], dnsClassIN)
+	return msg, nil
+}
+
+// parseDNSResponse returns addresses of the qtype records in the answer section and their minimal TTL.
+// The response must repeat the question of the query, the name is compared case-insensitively.
+func parseDNSResponse(id uint16, host string, qtype uint16, msg []byte) ([]net.IP, time.Duration, error) {
+	if len(msg) < dnsHeaderSize {
+		return nil, 0, errDNSMalformedMessage
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, 0, errors.New("DNS response id does not match the query")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, 0, errors.New("DNS message is not a response")
+	}
+	if qdcount := binary.BigEndian.Uint16(msg[4:]); qdcount != 1 {
+		return nil, 0, errors.Errorf("DNS response has %d questions, expected 1", qdcount)
+	}
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	name, off, err := readDNSName(msg, dnsHeaderSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+4 > len(msg) {
+		return nil, 0, errDNSMalformedMessage
+	}
+	if !strings.EqualFold(name, strings.TrimSuffix(host, ".")) || binary.BigEndian.Uint16(msg[off:]) != qtype || binary.BigEndian.Uint16(msg[off+2:]) != dnsClassIN {
+		return nil, 0, errors.New("DNS response question does not match the query")
+	}
+	off += 4
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, errors.Errorf("DNS server returned error code %d", rcode)
+	}
+	ips := make([]net.IP, 0)
+	var ttl time.Duration
+	for i := 0; i < ancount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, errDNSMalformedMessage
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		rrClass := binary.BigEndian.Uint16(msg[off+2:])
+		rrTTL := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdLength := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdLength > len(msg) {
+			return nil, 0, errDNSMalformedMessage
+		}
+		rdata := msg[off : off+rdLength]
+		off += rdLength
+
+		// CNAME records are skipped, recursive servers return the addresses of the canonical name as well
+		if rrType != qtype || rrClass != dnsClassIN {
+			continue
+		}
+		if (rrType == dnsTypeA && rdLength != net.IPv4len) || (rrType == dnsTypeAAAA && rdLength != net.IPv6len) {
+			return nil, 0, errDNSMalformedMessage
+		}
+		ip := make(net.IP, rdLength)
+		copy(ip, rdata)
+		if len(ips) == 0 || rrTTL < ttl {
+			ttl = rrTTL
+		}
+		ips = append(ips, ip)
+	}
+	return ips, ttl, nil
+}
+
+// readDNSName returns the dot separated name at the offset and the offset after it, compression pointers are followed
+func readDNSName(msg []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for pointers := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSMalformedMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case byte(length)&dnsCompressionMask == dnsCompressionMask:
+			if off+2 > len(msg) || pointers == dnsMaxPointers {
+				return "", 0, errDNSMalformedMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) &^ 0xC000)
+			pointers++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errDNSMalformedMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSMalformedMessage
+		}
+		length := msg[off]
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&dnsCompressionMask == dnsCompressionMask:
+			// pointer terminates the name
+			return off + 2, nil
+		default:
+			off += 1 + int(length)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testDNSRecord struct {
+	ip  net.IP
+	ttl uint32
+}
+
+type testDNSServer struct {
+	addr     string
+	queries  int32
+	truncate bool
+	records  map[string][]testDNSRecord
+	stop     func()
+}
+
+// startTestDNSServer answers A and AAAA queries over UDP and TCP on the same port
+func startTestDNSServer(a *assert.Assertions, records map[string][]testDNSRecord, truncate bool) *testDNSServer {
+	var pc net.PacketConn
+	var ln net.Listener
+	var err error
+	for i := 0; i < 10; i++ {
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			continue
+		}
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+	}
+	a.Nil(err)
+
+	s := &testDNSServer{addr: pc.LocalAddr().String(), truncate: truncate, records: records}
+	s.stop = func() {
+		pc.Close()
+		ln.Close()
+	}
+	go func() {
+		buf := make([]byte, dnsMaxUDPSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(s.answer(buf[:n], s.truncate), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				length := make([]byte, 2)
+				if _, err := io.ReadFull(conn, length); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				response := s.answer(query, false)
+				binary.BigEndian.PutUint16(length, uint16(len(response)))
+				conn.Write(append(length, response...))
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testDNSServer) answer(query []byte, truncate bool) []byte {
+	atomic.AddInt32(&s.queries, 1)
+
+	// single question, name starts at the header end
+	off, _ := skipDNSName(query, dnsHeaderSize)
+	qtype := binary.BigEndian.Uint16(query[off:])
+	name := ""
+	for i := dnsHeaderSize; query[i] != 0; i += int(query[i]) + 1 {
+		if name != "" {
+			name += "."
+		}
+		name += string(query[i+1 : i+1+int(query[i])])
+	}
+	response := append([]byte{}, query[:off+4]...)
+	flags := dnsFlagResponse | dnsFlagRecursion | 0x80
+	records, ok := s.records[name]
+	if !ok {
+		flags |= dnsRcodeNameError
+	}
+	if truncate {
+		binary.BigEndian.PutUint16(response[2:], flags|dnsFlagTruncated)
+		return response
+	}
+	var ancount uint16
+	for _, record := range records {
+		ip := record.ip.To4()
+		rrType := dnsTypeA
+		if ip == nil {
+			ip = record.ip.To16()
+			rrType = dnsTypeAAAA
+		}
+		if rrType != qtype {
+			continue
+		}
+		rr := make([]byte, 12)
+		binary.BigEndian.PutUint16(rr[0:], 0xC000|dnsHeaderSize)
+		binary.BigEndian.PutUint16(rr[2:], rrType)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:], record.ttl)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(ip)))
+		response = append(append(response, rr...), ip...)
+		ancount++
+	}
+	binary.BigEndian.PutUint16(response[2:], flags)
+	binary.BigEndian.PutUint16(response[6:], ancount)
+	return response
+}
+
+func TestDNSClientLookupIP(t *testing.T) {
+	a := assert.New(t)
+
+	server := startTestDNSServer(a, map[string][]testDNSRecord{
+		"kafka-0.example.com": {{ip: net.ParseIP("10.0.0.1"), ttl: 60}, {ip: net.ParseIP("10.0.0.2"), ttl: 20}, {ip: net.ParseIP("fd00::1"), ttl: 30}},
+	}, false)
+	defer server.stop()
+
+	client := &dnsClient{servers: []string{server.addr}, timeout: time.Second}
+	ips, ttl, err := client.lookupIP(context.Background(), "kafka-0.example.com")
+	a.Nil(err)
+	a.Equal(3, len(ips))
+	a.True(ips[0].Equal(net.ParseIP("10.0.0.1")))
+	a.True(ips[1].Equal(net.ParseIP("10.0.0.2")))
+	a.True(ips[2].Equal(net.ParseIP("fd00::1")))
+	a.Equal(20*time.Second, ttl)
+
+	_, _, err = client.lookupIP(context.Background(), "unknown.example.com")
+	a.NotNil(err)
+	a.Contains(err.Error(), "no such host")
+}
+
+func TestDNSClientFallsBackToTCPWhenTruncated(t *testing.T) {
+	a := assert.New(t)
+
+	server := startTestDNSServer(a, map[string][]testDNSRecord{
+		"kafka-0.example.com": {{ip: net.ParseIP("10.0.0.1"), ttl: 60}},
+	}, true)
+	defer server.stop()
+
+	client := &dnsClient{servers: []string{server.addr}, timeout: time.Second}
+	ips, ttl, err := client.lookupIP(context.Background(), "kafka-0.example.com")
+	a.Nil(err)
+	a.Equal(1, len(ips))
+	a.True(ips[0].Equal(net.ParseIP("10.0.0.1")))
+	a.Equal(60*time.Second, ttl)
+}
+
+func TestParseDNSResponseRejectsMismatchedQuestion(t *testing.T) {
+	a := assert.New(t)
+
+	server := &testDNSServer{records: map[string][]testDNSRecord{
+		"kafka-0.example.com": {{ip: net.ParseIP("10.0.0.1"), ttl: 60}},
+		"kafka-1.example.com": {{ip: net.ParseIP("10.0.0.2"), ttl: 60}},
+	}}
+	answer := func(id uint16, host string, qtype uint16) []byte {
+		query, err := newDNSQuery(id, host, qtype)
+		a.Nil(err)
+		return server.answer(query, false)
+	}
+
+	// resolvers may change the case of the name
+	response := answer(7, "kafka-0.example.com", dnsTypeA)
+	copy(response[dnsHeaderSize+1:], "KAFKA")
+	ips, _, err := parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, response)
+	a.Nil(err)
+	a.Equal(1, len(ips))
+	a.True(ips[0].Equal(net.ParseIP("10.0.0.1")))
+
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, answer(7, "kafka-1.example.com", dnsTypeA))
+	a.EqualError(err, "DNS response question does not match the query")
+
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeAAAA, answer(7, "kafka-0.example.com", dnsTypeA))
+	a.EqualError(err, "DNS response question does not match the query")
+
+	response = answer(7, "kafka-0.example.com", dnsTypeA)
+	binary.BigEndian.PutUint16(response[dnsHeaderSize+len("kafka-0.example.com")+4:], 3) // QCLASS CH
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, response)
+	a.EqualError(err, "DNS response question does not match the query")
+
+	// a spoofed name error must repeat the question as well
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, answer(7, "unknown.example.com", dnsTypeA))
+	a.EqualError(err, "DNS response question does not match the query")
+
+	response = answer(7, "kafka-0.example.com", dnsTypeA)
+	binary.BigEndian.PutUint16(response[4:], 0)
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, response)
+	a.EqualError(err, "DNS response has 0 questions, expected 1")
+
+	// the question name pointing to itself
+	response = answer(7, "kafka-0.example.com", dnsTypeA)
+	binary.BigEndian.PutUint16(response[dnsHeaderSize:], 0xC000|dnsHeaderSize)
+	_, _, err = parseDNSResponse(7, "kafka-0.example.com", dnsTypeA, response)
+	a.Equal(errDNSMalformedMessage, err)
+}
+
+func TestDNSQueryIDs(t *testing.T) {
+	a := assert.New(t)
+
+	ids := make(map[uint16]bool)
+	for i := 0; i < 16; i++ {
+		id, err := newDNSQueryID()
+		a.Nil(err)
+		ids[id] = true
+	}
+	a.True(len(ids) > 1)
+}
+
+func TestResolverHostsOverrideAndCache(t *testing.T) {
+	a := assert.New(t)
+
+	server := startTestDNSServer(a, map[string][]testDNSRecord{
+		"kafka-0.example.com": {{ip: net.ParseIP("10.0.0.1"), ttl: 10}},
+	}, false)
+	defer server.stop()
+
+	c := config.NewConfig()
+	c.Resolver.Servers = []string{server.addr}
+	c.Resolver.Hosts = []string{"Kafka-1.example.com=192.168.0.1,192.168.0.2"}
+	c.Resolver.CacheTTL = 30 * time.Second
+	r, err := newResolver(c)
+	a.Nil(err)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	ips, err := r.LookupIP(context.Background(), "kafka-1.example.com.")
+	a.Nil(err)
+	a.Equal([]net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}, ips)
+	a.Equal(int32(0), atomic.LoadInt32(&server.queries))
+
+	// record TTL is lower than the cache TTL
+	for i := 0; i < 3; i++ {
+		ips, err = r.LookupIP(context.Background(), "kafka-0.example.com")
+		a.Nil(err)
+		a.True(ips[0].Equal(net.ParseIP("10.0.0.1")))
+	}
+	a.Equal(int32(2), atomic.LoadInt32(&server.queries))
+
+	now = now.Add(10 * time.Second)
+	_, err = r.LookupIP(context.Background(), "kafka-0.example.com")
+	a.Nil(err)
+	a.Equal(int32(4), atomic.LoadInt32(&server.queries))
+}
+
+func TestDirectDialerUsesResolver(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	a.Nil(err)
+
+	c := config.NewConfig()
+	c.Resolver.Hosts = []string{"kafka-0.example.com=127.0.0.1"}
+	r, err := newResolver(c)
+	a.Nil(err)
+
+	dialer := directDialer{dialTimeout: time.Second, resolver: r}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("kafka-0.example.com", port))
+	a.Nil(err)
+	a.Equal(ln.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+}
+
+func TestResolverIsOptional(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	r, err := newResolverIfEnabled(c)
+	a.Nil(err)
+	a.Nil(r)
+
+	c.Resolver.CacheTTL = time.Minute
+	r, err = newResolverIfEnabled(c)
+	a.Nil(err)
+	a.NotNil(r)
+}
+
+func TestDirectDialerSplitsTimeoutAcrossAddresses(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(5*time.Second, partialDialTimeout(10*time.Second, 2))
+	a.Equal(minPartialDialTimeout, partialDialTimeout(3*time.Second, 3))
+	a.Equal(time.Second, partialDialTimeout(time.Second, 3))
+	a.Equal(time.Nanosecond, partialDialTimeout(-time.Second, 1))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	a.Nil(err)
+
+	// the unreachable first address leaves time for the second one
+	c := config.NewConfig()
+	c.Resolver.Hosts = []string{"kafka-0.example.com=192.0.2.1,127.0.0.1"}
+	r, err := newResolver(c)
+	a.Nil(err)
+
+	dialer := directDialer{dialTimeout: 3 * time.Second, resolver: r}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("kafka-0.example.com", port))
+	a.Nil(err)
+	if conn != nil {
+		a.Equal(ln.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+}
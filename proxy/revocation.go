@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revocation identifies the revoked sessions by the principal or by the id of the OAUTHBEARER token
+type Revocation struct {
+	Principal string `json:"principal,omitempty"`
+	TokenID   string `json:"token_id,omitempty"`
+}
+
+type revocationJSON struct {
+	Principal string `json:"principal,omitempty"`
+	TokenID   string `json:"token_id,omitempty"`
+	// zero if the revocation does not expire
+	Expires time.Time `json:"expires"`
+}
+
+// revocableConn is a client connection authenticated by the local SASL
+type revocableConn struct {
+	principal string
+	tokenID   string
+	conn      io.Closer
+}
+
+// Revocations closes the client connections of the revoked principals and tokens and rejects their new authentications
+type Revocations struct {
+	ttl time.Duration
+
+	lock sync.Mutex
+	// expiry of the revocations, zero time if the revocation does not expire
+	revoked map[Revocation]time.Time
+	conns   map[*revocableConn]struct{}
+}
+
+// NewRevocations returns nil if the revocations are not managed by the admin API
+func NewRevocations(c *config.Config) (*Revocations, error) {
+	if !c.Auth.Local.RevocationAdminEnable {
+		return nil, nil
+	}
+	if c.Auth.Local.RevocationTTL < 0 {
+		return nil, errors.New("Auth.Local.RevocationTTL must be greater or equal 0")
+	}
+	return &Revocations{
+		ttl:     c.Auth.Local.RevocationTTL,
+		revoked: make(map[Revocation]time.Time),
+		conns:   make(map[*revocableConn]struct{}),
+	}, nil
+}
+
+// Revoke closes the active connections of the principal or the token and rejects their authentications until the revocation expires.
+// It returns the number of the closed connections.
+func (r *Revocations) Revoke(revocation Revocation) (int, error) {
+	if revocation.Principal == "" && revocation.TokenID == "" {
+		return 0, errors.New("principal or token_id is required")
+	}
+	var expires time.Time
+	if r.ttl > 0 {
+		expires = time.Now().Add(r.ttl)
+	}
+	var closing []io.Closer
+	r.lock.Lock()
+	r.revoked[revocation] = expires
+	for conn := range r.conns {
+		if revocation.matches(conn.principal, conn.tokenID) {
+			closing = append(closing, conn.conn)
+			delete(r.conns, conn)
+		}
+	}
+	r.lock.Unlock()
+
+	for _, conn := range closing {
+		conn.Close()
+	}
+	proxyRevokedTotal.WithLabelValues("connection").Add(float64(len(closing)))
+	logrus.Warnf("Revoked principal '%s' token id '%s': %d connections closed", revocation.Principal, revocation.TokenID, len(closing))
+	return len(closing), nil
+}
+
+// Clear removes all revocations, the closed connections are not affected
+func (r *Revocations) Clear() {
+	r.lock.Lock()
+	r.revoked = make(map[Revocation]time.Time)
+	r.lock.Unlock()
+	logrus.Infof("Revocations are cleared")
+}
+
+// list returns the revocations which have not expired
+func (r *Revocations) list() []revocationJSON {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.expire(time.Now())
+
+	result := make([]revocationJSON, 0, len(r.revoked))
+	for revocation, expires := range r.revoked {
+		result = append(result, revocationJSON{Principal: revocation.Principal, TokenID: revocation.TokenID, Expires: expires})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Principal != result[j].Principal {
+			return result[i].Principal < result[j].Principal
+		}
+		return result[i].TokenID < result[j].TokenID
+	})
+	return result
+}
+
+// ServeHTTP returns the revocations on GET, revokes the principal or token of the JSON body on POST and clears the revocations on DELETE
+func (r *Revocations) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var revocation Revocation
+		if err := json.NewDecoder(req.Body).Decode(&revocation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := r.Revoke(revocation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		r.Clear()
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.list())
+}
+
+// rejects reports whether the authentication of the principal or the token is rejected
+func (r *Revocations) rejects(principal string, tokenID string) bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.expire(time.Now())
+
+	for revocation := range r.revoked {
+		if revocation.matches(principal, tokenID) {
+			proxyRevokedTotal.WithLabelValues("authentication").Inc()
+			return true
+		}
+	}
+	return false
+}
+
+// register tracks the authenticated client connection until unregister is called
+func (r *Revocations) register(principal string, tokenID string, conn io.Closer) *revocableConn {
+	if r == nil || conn == nil {
+		return nil
+	}
+	revocable := &revocableConn{principal: principal, tokenID: tokenID, conn: conn}
+	r.lock.Lock()
+	r.conns[revocable] = struct{}{}
+	r.lock.Unlock()
+	return revocable
+}
+
+func (r *Revocations) unregister(revocable *revocableConn) {
+	if r == nil || revocable == nil {
+		return
+	}
+	r.lock.Lock()
+	delete(r.conns, revocable)
+	r.lock.Unlock()
+}
+
+// expire removes the expired revocations, the lock must be held
+func (r *Revocations) expire(now time.Time) {
+	for revocation, expires := range r.revoked {
+		if !expires.IsZero() && now.After(expires) {
+			delete(r.revoked, revocation)
+		}
+	}
+}
+
+func (r Revocation) matches(principal string, tokenID string) bool {
+	if r.Principal != "" && r.Principal != principal {
+		return false
+	}
+	if r.TokenID != "" && r.TokenID != tokenID {
+		return false
+	}
+	return true
+}
+
+// revocableSession rejects the authentication of the revoked principals and tokens
+type revocableSession struct {
+	localSaslSession
+	revocations *Revocations
+}
+
+func (s *revocableSession) step(clientMessage []byte) (response []byte, principal string, done bool, err error) {
+	response, principal, done, err = s.localSaslSession.step(clientMessage)
+	if err == nil && done && s.revocations.rejects(principal, s.tokenID()) {
+		return nil, "", done, fmt.Errorf("principal %s is revoked", principal)
+	}
+	return response, principal, done, err
+}
+
+// oauthTokenID returns the jti claim of a JWT token, otherwise the hex encoded SHA-256 hash of the token
+func oauthTokenID(token string) string {
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "=")); err == nil {
+			var claims struct {
+				ID string `json:"jti"`
+			}
+			if json.Unmarshal(payload, &claims) == nil && claims.ID != "" {
+				return claims.ID
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCloser struct {
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestRevocations(a *assert.Assertions, ttl time.Duration) *Revocations {
+	c := &config.Config{}
+	c.Auth.Local.RevocationAdminEnable = true
+	c.Auth.Local.RevocationTTL = ttl
+	revocations, err := NewRevocations(c)
+	a.Nil(err)
+	return revocations
+}
+
+func TestOauthTokenID(t *testing.T) {
+	a := assert.New(t)
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"bob","jti":"token-1"}`))
+	a.Equal("token-1", oauthTokenID("eyJhbGciOiJub25lIn0."+payload+".signature"))
+	// opaque tokens are identified by the SHA-256 hash
+	a.Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", oauthTokenID("hello"))
+}
+
+func TestNewRevocationsDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	revocations, err := NewRevocations(&config.Config{})
+	a.Nil(err)
+	a.Nil(revocations)
+	a.False(revocations.rejects("bob", ""))
+	revocations.unregister(revocations.register("bob", "", &testCloser{}))
+}
+
+func TestRevocationsCloseConnections(t *testing.T) {
+	a := assert.New(t)
+
+	revocations := newTestRevocations(a, 0)
+	bob := &testCloser{}
+	bobToken := &testCloser{}
+	alice := &testCloser{}
+	revocations.register("bob", "", bob)
+	revocations.register("bob", "token-1", bobToken)
+	revocations.unregister(revocations.register("carol", "", &testCloser{}))
+	revocations.register("alice", "token-2", alice)
+
+	_, err := revocations.Revoke(Revocation{})
+	a.EqualError(err, "principal or token_id is required")
+
+	closed, err := revocations.Revoke(Revocation{TokenID: "token-1"})
+	a.Nil(err)
+	a.Equal(1, closed)
+	a.True(bobToken.closed)
+	a.False(bob.closed)
+
+	closed, err = revocations.Revoke(Revocation{Principal: "bob"})
+	a.Nil(err)
+	a.Equal(1, closed)
+	a.True(bob.closed)
+	a.False(alice.closed)
+
+	a.True(revocations.rejects("bob", "token-3"))
+	a.True(revocations.rejects("alice", "token-1"))
+	a.False(revocations.rejects("alice", "token-2"))
+
+	revocations.Clear()
+	a.False(revocations.rejects("bob", ""))
+}
+
+func TestRevocationsExpire(t *testing.T) {
+	a := assert.New(t)
+
+	revocations := newTestRevocations(a, time.Hour)
+	_, err := revocations.Revoke(Revocation{Principal: "bob"})
+	a.Nil(err)
+	a.True(revocations.rejects("bob", ""))
+
+	revocations.expire(time.Now().Add(2 * time.Hour))
+	a.False(revocations.rejects("bob", ""))
+}
+
+func TestRevocationsServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	revocations := newTestRevocations(a, 0)
+	bob := &testCloser{}
+	revocations.register("bob", "", bob)
+
+	w := httptest.NewRecorder()
+	revocations.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/revocations", strings.NewReader(`{"principal":"bob"}`)))
+	a.Equal(http.StatusOK, w.Code)
+	a.True(bob.closed)
+	var list []revocationJSON
+	a.Nil(json.NewDecoder(w.Body).Decode(&list))
+	a.Equal([]revocationJSON{{Principal: "bob"}}, list)
+
+	w = httptest.NewRecorder()
+	revocations.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/revocations", strings.NewReader(`{}`)))
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	revocations.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/revocations", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("[]\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	revocations.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/revocations", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestProxyLocalSaslRevocationClosesConnections(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{SASLPlain}
+	revocations := newTestRevocations(a, 0)
+	listenerAddress, stop := startTestProxy(a, c, WithLocalPasswordAuthenticator(testPasswordAuthenticator{"bob": "bob-secret"}), WithRevocations(revocations))
+	defer stop()
+
+	login := func() (net.Conn, *protocol.SaslAuthenticateResponseV0) {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+		_, _, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		return conn, saslAuthenticate(a, conn, 2, []byte("\x00bob\x00bob-secret"))
+	}
+
+	conn, res := login()
+	defer conn.Close()
+	a.Equal(protocol.ErrNoError, res.Err)
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 7, 3, "test", fetchRequestV7("orders")))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	closed, err := revocations.Revoke(Revocation{Principal: "bob"})
+	a.Nil(err)
+	a.Equal(1, closed)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+
+	// the revoked principal cannot authenticate again
+	second, res := login()
+	defer second.Close()
+	a.Equal(protocol.ErrSASLAuthenticationFailed, res.Err)
+	a.Equal("principal bob is revoked", *res.ErrMsg)
+}
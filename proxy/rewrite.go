@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+)
+
+// topicRewriteAllowedApiKeys are the requests without topic names which are allowed when the topic names are rewritten.
+// Other requests carrying topic names e.g. CreateTopics or DescribeConfigs would bypass the rewriting and are forbidden.
+var topicRewriteAllowedApiKeys = map[int16]struct{}{
+	10: {}, // FindCoordinator
+	11: {}, // JoinGroup
+	12: {}, // Heartbeat
+	13: {}, // LeaveGroup
+	14: {}, // SyncGroup
+	15: {}, // DescribeGroups
+	16: {}, // ListGroups
+	17: {}, // SaslHandshake
+	18: {}, // ApiVersions
+	22: {}, // InitProducerId
+	25: {}, // AddOffsetsToTxn
+	26: {}, // EndTxn
+	36: {}, // SaslAuthenticate
+	42: {}, // DeleteGroups
+	60: {}, // DescribeCluster
+}
+
+// groupRewriteForbiddenApiKeys are the requests carrying group names which cannot be rewritten
+var groupRewriteForbiddenApiKeys = map[int16]struct{}{
+	29: {}, // DescribeAcls
+	30: {}, // CreateAcls
+	31: {}, // DeleteAcls
+	47: {}, // OffsetDelete
+	68: {}, // ConsumerGroupHeartbeat
+	69: {}, // ConsumerGroupDescribe
+}
+
+// rewriter maps the topic and group names seen by the clients to the names on the brokers.
+// Nil mapper disables the rewriting of the names.
+type rewriter struct {
+	topics protocol.NameMapper
+	groups protocol.NameMapper
+}
+
+func newRewriter(c *config.Config) *rewriter {
+	if c.Rewrite.TopicPrefix == "" && c.Rewrite.GroupPrefix == "" {
+		return nil
+	}
+	r := &rewriter{}
+	if c.Rewrite.TopicPrefix != "" {
+		r.topics = &protocol.PrefixNameMapper{Prefix: c.Rewrite.TopicPrefix}
+	}
+	if c.Rewrite.GroupPrefix != "" {
+		r.groups = &protocol.PrefixNameMapper{Prefix: c.Rewrite.GroupPrefix}
+	}
+	return r
+}
+
+// isForbidden reports the requests which cannot be rewritten
+func (r *rewriter) isForbidden(apiKey int16, apiVersion int16) bool {
+	if r == nil {
+		return false
+	}
+	if r.topics != nil {
+		if maxVersion, ok := protocol.TopicNamesMaxVersion(apiKey); ok {
+			if apiVersion > maxVersion {
+				return true
+			}
+		} else if _, ok := topicRewriteAllowedApiKeys[apiKey]; !ok {
+			return true
+		}
+	}
+	if r.groups != nil {
+		if maxVersion, ok := protocol.GroupNamesMaxVersion(apiKey); ok {
+			if apiVersion > maxVersion {
+				return true
+			}
+		} else if _, ok := groupRewriteForbiddenApiKeys[apiKey]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rewriter) requestModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.RequestModifier, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return protocol.GetNamesRequestModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, r.topics, r.groups)
+}
+
+func (r *rewriter) responseModifier(requestKeyVersion *protocol.RequestKeyVersion) (protocol.ResponseModifier, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return protocol.GetNamesResponseModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, r.topics, r.groups)
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRewriterForbidsRequestsWhichCannotBeRewritten(t *testing.T) {
+	a := assert.New(t)
+
+	r := &rewriter{topics: &protocol.PrefixNameMapper{Prefix: "tenant-a."}}
+	a.False(r.isForbidden(kafkatest.ApiKeyMetadata, 7))
+	a.True(r.isForbidden(kafkatest.ApiKeyMetadata, 8))
+	a.False(r.isForbidden(kafkatest.ApiKeyFetch, 11))
+	a.True(r.isForbidden(kafkatest.ApiKeyFetch, 12))
+	a.False(r.isForbidden(kafkatest.ApiKeyApiVersions, 3))
+	a.True(r.isForbidden(19, 0))  // CreateTopics
+	a.True(r.isForbidden(32, 0))  // DescribeConfigs
+	a.False(r.isForbidden(24, 2)) // AddPartitionsToTxn
+	a.True(r.isForbidden(24, 3))  // AddPartitionsToTxn
+	a.False(r.isForbidden(25, 3)) // AddOffsetsToTxn
+
+	r = &rewriter{groups: &protocol.PrefixNameMapper{Prefix: "tenant-a."}}
+	a.False(r.isForbidden(19, 0))
+	a.False(r.isForbidden(11, 5)) // JoinGroup
+	a.True(r.isForbidden(11, 6))  // JoinGroup
+	a.False(r.isForbidden(28, 2)) // TxnOffsetCommit
+	a.True(r.isForbidden(28, 3))  // TxnOffsetCommit
+	a.True(r.isForbidden(47, 0))  // OffsetDelete
+	a.False(r.isForbidden(kafkatest.ApiKeyFetch, 12))
+
+	var disabled *rewriter
+	a.False(disabled.isForbidden(19, 0))
+}
+
+func TestProxyRewritesTopicNames(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Topics: map[string]int32{"tenant-a.orders": 1, "tenant-a.payments": 1, "tenant-b.orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Rewrite.TopicPrefix = "tenant-a."
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	// all topics
+	for _, version := range []int16{0, 1, 7} {
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, version, 1, "app-1", kafkatest.MetadataRequestBody(version, nil)))
+		a.Nil(err)
+		_, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		topics, err := kafkatest.DecodeMetadataTopics(version, body)
+		a.Nil(err)
+		sort.Strings(topics)
+		a.Equal([]string{"orders", "payments"}, topics, "version %d", version)
+	}
+
+	// requested topics
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 4, 2, "app-1", kafkatest.MetadataRequestBody(4, []string{"orders"})))
+	a.Nil(err)
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	topics, err := kafkatest.DecodeMetadataTopics(4, body)
+	a.Nil(err)
+	// broker returns the requested names, topics without the prefix would be hidden
+	a.Equal([]string{"orders"}, topics)
+
+	// CreateTopics is forbidden
+	_, err = conn.Write(kafkatest.EncodeRequest(19, 0, 3, "app-1", []byte{0, 0, 0, 0}))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+}
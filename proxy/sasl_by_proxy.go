@@ -5,11 +5,14 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
+	"net"
 	"time"
 )
 
@@ -18,32 +21,73 @@ const (
 	SASLOAuthBearer = "OAUTHBEARER"
 )
 
+// reasons of the failed SASL authentications to the brokers
+const (
+	saslFailureTimeout     = "timeout"
+	saslFailureNetwork     = "network"
+	saslFailureVersion     = "version"
+	saslFailureMechanism   = "mechanism"
+	saslFailureCredentials = "credentials"
+	saslFailureToken       = "token"
+	saslFailureProtocol    = "protocol"
+)
+
+// upstreamSASLError is the failed SASL authentication to the broker with the reason reported in the logs and metrics
+type upstreamSASLError struct {
+	mechanism string
+	reason    string
+	err       error
+	// error code returned by the broker in the response of the api key, none for the failures before the response
+	apiKey   int16
+	kafkaErr protocol.KError
+}
+
+func (e *upstreamSASLError) Error() string {
+	return fmt.Sprintf("SASL/%s authentication failed (%s): %v", e.mechanism, e.reason, e.err)
+}
+
+// upstreamSASLFailure records the failure of the mechanism, errors without a reason are timeout or network failures
+func upstreamSASLFailure(mechanism string, err error) error {
+	saslErr, ok := err.(*upstreamSASLError)
+	if !ok {
+		saslErr = &upstreamSASLError{reason: saslFailureNetwork, err: err}
+		if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+			saslErr.reason = saslFailureTimeout
+		}
+	}
+	saslErr.mechanism = mechanism
+	proxyUpstreamSASLFailuresTotal.WithLabelValues(mechanism, saslErr.reason).Inc()
+	return saslErr
+}
+
 type SASLHandshake struct {
 	clientID  string
 	version   int16
 	mechanism string
+}
 
-	writeTimeout time.Duration
-	readTimeout  time.Duration
+// saslExchange sends the authentication bytes of the mechanism either raw after SaslHandshake v0 or wrapped in
+// SaslAuthenticate request after SaslHandshake v1. The version is negotiated with ApiVersions request in auto mode.
+type saslExchange struct {
+	clientID            string
+	version             string
+	handshakeTimeout    time.Duration
+	authenticateTimeout time.Duration
 }
 
 type SASLOAuthBearerAuth struct {
-	clientID string
-
-	writeTimeout time.Duration
-	readTimeout  time.Duration
+	exchange *saslExchange
 
 	tokenProvider apis.TokenProvider
 }
 
 type SASLPlainAuth struct {
-	clientID string
-
-	writeTimeout time.Duration
-	readTimeout  time.Duration
+	exchange *saslExchange
 
 	username string
 	password string
+	// returns the current password e.g. a rotated secret, password is used if nil
+	passwordFunc func() string
 }
 
 type SASLAuthByProxy interface {
@@ -63,65 +107,130 @@ type SASLAuthByProxy interface {
 //                  ;; any UTF-8 encoded Unicode character except NUL
 //
 // When credentials are valid, Kafka returns a 4 byte array of null characters.
-// When credentials are invalid, Kafka closes the connection. This does not seem to be the ideal way
-// of responding to bad credentials but thats how its being done today.
+// When credentials are invalid, Kafka closes the connection (v0) or returns SASL_AUTHENTICATION_FAILED (v1).
 func (b *SASLPlainAuth) sendAndReceiveSASLAuth(conn DeadlineReaderWriter) error {
+	password := b.password
+	if b.passwordFunc != nil {
+		password = b.passwordFunc()
+	}
+	authBytes := []byte("\x00" + b.username + "\x00" + password)
+	if err := b.exchange.authenticate(conn, SASLPlain, authBytes, "user "+b.username); err != nil {
+		return upstreamSASLFailure(SASLPlain, err)
+	}
+	return nil
+}
 
+func (e *saslExchange) authenticate(conn DeadlineReaderWriter, mechanism string, authBytes []byte, principal string) error {
+	if err := conn.SetDeadline(time.Now().Add(e.handshakeTimeout)); err != nil {
+		return err
+	}
+	version, err := e.negotiateVersion(conn)
+	if err != nil {
+		return err
+	}
 	saslHandshake := &SASLHandshake{
-		clientID:     b.clientID,
-		version:      0,
-		mechanism:    SASLPlain,
-		writeTimeout: b.writeTimeout,
-		readTimeout:  b.readTimeout,
+		clientID:  e.clientID,
+		version:   version,
+		mechanism: mechanism,
+	}
+	if err = saslHandshake.sendAndReceiveHandshake(conn); err != nil {
+		return err
 	}
-	handshakeErr := saslHandshake.sendAndReceiveHandshake(conn)
-	if handshakeErr != nil {
-		return handshakeErr
+	if err = conn.SetDeadline(time.Now().Add(e.authenticateTimeout)); err != nil {
+		return err
 	}
-	return b.sendSaslAuthenticateRequest(conn)
+	if version == 0 {
+		return e.sendRawAuthBytes(conn, mechanism, authBytes, principal)
+	}
+	return e.sendSaslAuthenticateRequest(conn, mechanism, authBytes, principal)
 }
 
-func (b *SASLPlainAuth) sendSaslAuthenticateRequest(conn DeadlineReaderWriter) error {
-	logrus.Debugf("Sending authentication opaque packets, mechanism PLAIN")
-
-	length := 1 + len(b.username) + 1 + len(b.password)
-	authBytes := make([]byte, length+4) //4 byte length header + auth data
-	binary.BigEndian.PutUint32(authBytes, uint32(length))
-	copy(authBytes[4:], []byte("\x00"+b.username+"\x00"+b.password))
+// negotiateVersion returns the SaslHandshake version, in auto mode v1 is used if the broker supports SaslHandshake v1 and SaslAuthenticate
+func (e *saslExchange) negotiateVersion(conn DeadlineReaderWriter) (int16, error) {
+	switch e.version {
+	case config.SASLVersionV0:
+		return 0, nil
+	case config.SASLVersionV1:
+		return 1, nil
+	}
+	logrus.Debugf("Sending ApiVersionsRequest to negotiate SASL version")
 
-	err := conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
+	if _, err := conn.Write(requestFrame(apiKeyApiApiVersions, 0, 0, e.clientID, nil)); err != nil {
+		return 0, errors.Wrap(err, "Failed to send ApiVersions request")
+	}
+	payload, err := readSASLResponse(conn)
 	if err != nil {
-		return err
+		return 0, errors.Wrap(err, "Failed to read ApiVersions response")
 	}
-	_, err = conn.Write(authBytes)
+	versions, err := protocol.DecodeApiVersions(0, payload)
 	if err != nil {
-		return errors.Wrap(err, "Failed to write SASL auth header")
+		return 0, &upstreamSASLError{reason: saslFailureVersion, err: errors.Wrap(err, "Failed to parse ApiVersions response")}
+	}
+	handshake, ok := versions[apiKeySaslHandshake]
+	if !ok {
+		return 0, &upstreamSASLError{reason: saslFailureVersion, err: errors.New("SaslHandshake is not supported by the broker")}
 	}
+	if _, ok := versions[apiKeySaslAuthenticate]; ok && handshake.MaxVersion >= 1 {
+		return 1, nil
+	}
+	if handshake.MinVersion > 0 {
+		return 0, &upstreamSASLError{reason: saslFailureVersion, err: fmt.Errorf("SaslHandshake versions %d-%d without SaslAuthenticate are not supported", handshake.MinVersion, handshake.MaxVersion)}
+	}
+	return 0, nil
+}
 
-	err = conn.SetReadDeadline(time.Now().Add(b.readTimeout))
-	if err != nil {
-		return err
+// readSASLResponse reads the response and returns the payload after the correlation id
+func readSASLResponse(conn DeadlineReaderWriter) ([]byte, error) {
+	header := make([]byte, 8) // Size => int32, CorrelationId => int32
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int32(binary.BigEndian.Uint32(header[:4]))
+	if length < 4 || length > protocol.MaxResponseSize {
+		return nil, &upstreamSASLError{reason: saslFailureProtocol, err: fmt.Errorf("invalid response length %d", length)}
+	}
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
 	}
+	return payload, nil
+}
+
+func (e *saslExchange) sendRawAuthBytes(conn DeadlineReaderWriter, mechanism string, authBytes []byte, principal string) error {
+	logrus.Debugf("Sending authentication opaque packets, mechanism %s", mechanism)
+
+	buf := make([]byte, 4+len(authBytes)) //4 byte length header + auth data
+	binary.BigEndian.PutUint32(buf, uint32(len(authBytes)))
+	copy(buf[4:], authBytes)
 
+	if _, err := conn.Write(buf); err != nil {
+		return errors.Wrap(err, "Failed to write SASL auth header")
+	}
 	header := make([]byte, 4)
-	_, err = io.ReadFull(conn, header)
-	// If the credentials are valid, we would get a 4 byte response filled with null characters.
+	// If the credentials are valid, we would get the length of the (usually empty) server challenge.
 	// Otherwise, the broker closes the connection and we get an EOF
-	if err != nil {
+	if _, err := io.ReadFull(conn, header); err != nil {
 		if err == io.EOF {
-			return fmt.Errorf("SASL/PLAIN auth for user %s failed", b.username)
+			return &upstreamSASLError{reason: saslFailureCredentials, err: fmt.Errorf("broker closed the connection, credentials of %s were rejected", principal)}
 		}
 		return errors.Wrap(err, "Failed to read response while authenticating with SASL")
 	}
+	length := int32(binary.BigEndian.Uint32(header))
+	if length < 0 || length > protocol.MaxResponseSize {
+		return &upstreamSASLError{reason: saslFailureProtocol, err: fmt.Errorf("invalid SASL challenge length %d", length)}
+	}
+	if _, err := io.CopyN(ioutil.Discard, conn, int64(length)); err != nil {
+		return errors.Wrap(err, "Failed to read SASL challenge")
+	}
 	return nil
 }
 
-func (b *SASLHandshake) sendAndReceiveHandshake(conn DeadlineReaderWriter) error {
-	logrus.Debugf("Sending SaslHandshakeRequest")
+func (e *saslExchange) sendSaslAuthenticateRequest(conn DeadlineReaderWriter, mechanism string, authBytes []byte, principal string) error {
+	logrus.Debugf("Sending SaslAuthenticateRequest, mechanism %s", mechanism)
 
 	req := &protocol.Request{
-		ClientID: b.clientID,
-		Body:     &protocol.SaslHandshakeRequestV0orV1{Version: b.version, Mechanism: b.mechanism},
+		ClientID: e.clientID,
+		Body:     &protocol.SaslAuthenticateRequestV0{SaslAuthBytes: authBytes},
 	}
 	reqBuf, err := protocol.Encode(req)
 	if err != nil {
@@ -130,42 +239,66 @@ func (b *SASLHandshake) sendAndReceiveHandshake(conn DeadlineReaderWriter) error
 	sizeBuf := make([]byte, 4)
 	binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
 
-	err = conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-	if err != nil {
-		return err
+	if _, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil)); err != nil {
+		return errors.Wrap(err, "Failed to send SASL auth request")
 	}
-
-	_, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil))
+	payload, err := readSASLResponse(conn)
 	if err != nil {
-		return errors.Wrap(err, "Failed to send SASL handshake")
+		return errors.Wrap(err, "Failed to read SASL auth response")
 	}
+	res := &protocol.SaslAuthenticateResponseV0{}
+	if err = protocol.Decode(payload, res); err != nil {
+		return &upstreamSASLError{reason: saslFailureProtocol, err: errors.Wrap(err, "Failed to parse SASL auth response")}
+	}
+	errMsg := ""
+	if res.ErrMsg != nil {
+		errMsg = *res.ErrMsg
+	}
+	switch res.Err {
+	case protocol.ErrNoError:
+		return nil
+	case protocol.ErrSASLAuthenticationFailed:
+		return &upstreamSASLError{reason: saslFailureCredentials, err: fmt.Errorf("credentials of %s were rejected, error message is '%s'", principal, errMsg), apiKey: apiKeySaslAuthenticate, kafkaErr: res.Err}
+	default:
+		return &upstreamSASLError{reason: saslFailureProtocol, err: errors.Wrapf(res.Err, "SASL authentication failed, error message is '%s'", errMsg), apiKey: apiKeySaslAuthenticate, kafkaErr: res.Err}
+	}
+}
 
-	err = conn.SetReadDeadline(time.Now().Add(b.readTimeout))
+func (b *SASLHandshake) sendAndReceiveHandshake(conn DeadlineReaderWriter) error {
+	logrus.Debugf("Sending SaslHandshakeRequest v%d", b.version)
+
+	req := &protocol.Request{
+		ClientID: b.clientID,
+		Body:     &protocol.SaslHandshakeRequestV0orV1{Version: b.version, Mechanism: b.mechanism},
+	}
+	reqBuf, err := protocol.Encode(req)
 	if err != nil {
 		return err
 	}
+	sizeBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
 
-	//wait for the response
-	header := make([]byte, 8) // response header
-	_, err = io.ReadFull(conn, header)
+	_, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil))
 	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL handshake header")
+		return errors.Wrap(err, "Failed to send SASL handshake")
 	}
-	length := binary.BigEndian.Uint32(header[:4])
-	payload := make([]byte, length-4)
-	_, err = io.ReadFull(conn, payload)
+	payload, err := readSASLResponse(conn)
 	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL handshake payload")
+		return errors.Wrap(err, "Failed to read SASL handshake response")
 	}
 	res := &protocol.SaslHandshakeResponseV0orV1{}
 	err = protocol.Decode(payload, res)
 	if err != nil {
-		return errors.Wrap(err, "Failed to parse SASL handshake")
+		return &upstreamSASLError{reason: saslFailureProtocol, err: errors.Wrap(err, "Failed to parse SASL handshake")}
 	}
-	if res.Err != protocol.ErrNoError {
-		return errors.Wrap(res.Err, "Invalid SASL Mechanism")
+	switch res.Err {
+	case protocol.ErrNoError:
+		return nil
+	case protocol.ErrUnsupportedSASLMechanism:
+		return &upstreamSASLError{reason: saslFailureMechanism, err: fmt.Errorf("mechanism %s is not enabled by the broker, enabled mechanisms are %v", b.mechanism, res.EnabledMechanisms), apiKey: apiKeySaslHandshake, kafkaErr: res.Err}
+	default:
+		return &upstreamSASLError{reason: saslFailureProtocol, err: errors.Wrap(res.Err, "SASL handshake failed"), apiKey: apiKeySaslHandshake, kafkaErr: res.Err}
 	}
-	return nil
 }
 
 func (b *SASLOAuthBearerAuth) getOAuthBearerToken() (string, error) {
@@ -183,76 +316,13 @@ func (b *SASLOAuthBearerAuth) getOAuthBearerToken() (string, error) {
 }
 
 func (b *SASLOAuthBearerAuth) sendAndReceiveSASLAuth(conn DeadlineReaderWriter) error {
-
 	token, err := b.getOAuthBearerToken()
 	if err != nil {
-		return err
-	}
-	saslHandshake := &SASLHandshake{
-		clientID:     b.clientID,
-		version:      1,
-		mechanism:    SASLOAuthBearer,
-		writeTimeout: b.writeTimeout,
-		readTimeout:  b.readTimeout,
-	}
-	handshakeErr := saslHandshake.sendAndReceiveHandshake(conn)
-	if handshakeErr != nil {
-		return handshakeErr
-	}
-	return b.sendSaslAuthenticateRequest(token, conn)
-}
-
-func (b *SASLOAuthBearerAuth) sendSaslAuthenticateRequest(token string, conn DeadlineReaderWriter) error {
-	logrus.Debugf("Sending SaslAuthenticateRequest, mechanism OAUTHBEARER")
-
-	saslAuthReqV0 := protocol.SaslAuthenticateRequestV0{SaslAuthBytes: SaslOAuthBearer{}.ToBytes(token, "", make(map[string]string, 0))}
-
-	req := &protocol.Request{
-		ClientID: b.clientID,
-		Body:     &saslAuthReqV0,
-	}
-	reqBuf, err := protocol.Encode(req)
-	if err != nil {
-		return err
-	}
-	sizeBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
-
-	err = conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil))
-	if err != nil {
-		return errors.Wrap(err, "Failed to send SASL auth request")
-	}
-
-	err = conn.SetReadDeadline(time.Now().Add(b.readTimeout))
-	if err != nil {
-		return err
-	}
-
-	//wait for the response
-	header := make([]byte, 8) // response header
-	_, err = io.ReadFull(conn, header)
-	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL auth header")
-	}
-	length := binary.BigEndian.Uint32(header[:4])
-	payload := make([]byte, length-4)
-	_, err = io.ReadFull(conn, payload)
-	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL auth payload")
-	}
-
-	res := &protocol.SaslAuthenticateResponseV0{}
-	err = protocol.Decode(payload, res)
-	if err != nil {
-		return errors.Wrap(err, "Failed to parse SASL auth response")
+		return upstreamSASLFailure(SASLOAuthBearer, &upstreamSASLError{reason: saslFailureToken, err: err})
 	}
-	if res.Err != protocol.ErrNoError {
-		return errors.Wrapf(res.Err, "SASL authentication failed, error message is '%v'", res.ErrMsg)
+	authBytes := SaslOAuthBearer{}.ToBytes(token, "", make(map[string]string, 0))
+	if err = b.exchange.authenticate(conn, SASLOAuthBearer, authBytes, "the token"); err != nil {
+		return upstreamSASLFailure(SASLOAuthBearer, err)
 	}
 	return nil
 }
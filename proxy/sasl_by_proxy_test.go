@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestSASLPlainAuth(version string, username string, password string) *SASLPlainAuth {
+	return &SASLPlainAuth{
+		exchange: &saslExchange{
+			clientID:            "test",
+			version:             version,
+			handshakeTimeout:    time.Second,
+			authenticateTimeout: time.Second,
+		},
+		username: username,
+		password: password,
+	}
+}
+
+func TestSASLPlainAuthNegotiatesVersion(t *testing.T) {
+	a := assert.New(t)
+
+	legacyApiVersions := []kafkatest.ApiVersion{
+		{ApiKey: kafkatest.ApiKeyFetch, MinVersion: 0, MaxVersion: 4},
+		{ApiKey: kafkatest.ApiKeySaslHandshake, MinVersion: 0, MaxVersion: 0},
+		{ApiKey: kafkatest.ApiKeyApiVersions, MinVersion: 0, MaxVersion: 0},
+	}
+	for _, tt := range []struct {
+		version          string
+		apiVersions      []kafkatest.ApiVersion
+		apiVersionsCount int
+		authenticate     int
+	}{
+		{version: config.SASLVersionAuto, apiVersionsCount: 1, authenticate: 1},
+		{version: config.SASLVersionAuto, apiVersions: legacyApiVersions, apiVersionsCount: 1, authenticate: 0},
+		{version: config.SASLVersionV0, apiVersionsCount: 0, authenticate: 0},
+		{version: config.SASLVersionV1, apiVersionsCount: 0, authenticate: 1},
+	} {
+		broker, err := kafkatest.NewBroker(kafkatest.Config{Users: map[string]string{"alice": "secret"}, ApiVersions: tt.apiVersions})
+		a.Nil(err)
+
+		conn, err := net.Dial("tcp", broker.Addr())
+		a.Nil(err)
+		a.Nil(newTestSASLPlainAuth(tt.version, "alice", "secret").sendAndReceiveSASLAuth(conn))
+
+		// the connection is authenticated
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 7, "test", []byte("fetch")))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(int32(7), correlationID)
+		a.Equal([]byte("fetch"), body)
+
+		a.Equal(tt.apiVersionsCount, broker.RequestCount(kafkatest.ApiKeyApiVersions), tt.version)
+		a.Equal(1, broker.RequestCount(kafkatest.ApiKeySaslHandshake), tt.version)
+		a.Equal(tt.authenticate, broker.RequestCount(kafkatest.ApiKeySaslAuthenticate), tt.version)
+		conn.Close()
+		broker.Close()
+	}
+}
+
+func TestSASLPlainAuthFailureReasons(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tt := range []struct {
+		users    map[string]string
+		version  string
+		password string
+		reason   string
+	}{
+		{users: map[string]string{"alice": "secret"}, version: config.SASLVersionV0, password: "wrong", reason: saslFailureCredentials},
+		{users: map[string]string{"alice": "secret"}, version: config.SASLVersionV1, password: "wrong", reason: saslFailureCredentials},
+		{users: nil, version: config.SASLVersionAuto, password: "secret", reason: saslFailureMechanism},
+	} {
+		broker, err := kafkatest.NewBroker(kafkatest.Config{Users: tt.users})
+		a.Nil(err)
+
+		conn, err := net.Dial("tcp", broker.Addr())
+		a.Nil(err)
+		err = newTestSASLPlainAuth(tt.version, "alice", tt.password).sendAndReceiveSASLAuth(conn)
+		saslErr, ok := err.(*upstreamSASLError)
+		a.True(ok, "unexpected error %v", err)
+		if ok {
+			a.Equal(SASLPlain, saslErr.mechanism)
+			a.Equal(tt.reason, saslErr.reason, saslErr.Error())
+		}
+		conn.Close()
+		broker.Close()
+	}
+}
+
+func TestSASLPlainAuthHandshakeTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	go func() {
+		// accept and never respond
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+
+	auth := newTestSASLPlainAuth(config.SASLVersionAuto, "alice", "secret")
+	auth.exchange.handshakeTimeout = 50 * time.Millisecond
+	start := time.Now()
+	err = auth.sendAndReceiveSASLAuth(conn)
+	a.True(time.Since(start) < 500*time.Millisecond)
+	saslErr, ok := err.(*upstreamSASLError)
+	a.True(ok, "unexpected error %v", err)
+	if ok {
+		a.Equal(saslFailureTimeout, saslErr.reason)
+	}
+}
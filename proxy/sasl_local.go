@@ -5,64 +5,143 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
 	"io"
+	"sort"
 	"time"
 )
 
 type LocalSasl struct {
-	enabled             bool
-	timeout             time.Duration
-	localAuthenticators map[string]LocalSaslAuth
+	enabled bool
+	timeout time.Duration
+	// advertised in the SaslHandshake responses
+	mechanisms          []string
+	localAuthenticators map[string]localSaslMechanism
+	// connections of the principals limited by the session attributes
+	connections *principalConnections
+	// revoked principals and tokens, nil if the revocations are disabled
+	revocations *Revocations
+	// only the strongest mechanisms are accepted on the connections without TLS
+	downgradeProtection bool
+	// weaker mechanisms refused on the connection by the downgrade protection
+	downgraded map[string]bool
+	// error message of the failed authentications
+	rejection *rejectionPolicy
 }
 
 type LocalSaslParams struct {
 	enabled               bool
 	timeout               time.Duration
+	mechanisms            []string
 	passwordAuthenticator apis.PasswordAuthenticator
 	tokenAuthenticator    apis.TokenInfo
+	scramAuthenticators   []*LocalSaslScram
+	revocations           *Revocations
+	downgradeProtection   bool
+	rejection             *rejectionPolicy
 }
 
+// NewLocalSasl returns the local authentication with the mechanisms which have a verifier, if no mechanisms are given all verifiers are used
 func NewLocalSasl(params LocalSaslParams) *LocalSasl {
-	localAuthenticators := make(map[string]LocalSaslAuth)
+	available := make(map[string]localSaslMechanism)
 	if params.passwordAuthenticator != nil {
-		localAuthenticators[SASLPlain] = NewLocalSaslPlain(params.passwordAuthenticator)
+		available[SASLPlain] = NewLocalSaslPlain(params.passwordAuthenticator)
 	}
-
 	if params.tokenAuthenticator != nil {
-		localAuthenticators[SASLOAuthBearer] = NewLocalSaslOauth(params.tokenAuthenticator)
+		available[SASLOAuthBearer] = NewLocalSaslOauth(params.tokenAuthenticator)
+	}
+	for _, scram := range params.scramAuthenticators {
+		available[scram.mechanism] = scram
+	}
+	mechanisms := params.mechanisms
+	if len(mechanisms) == 0 {
+		for mechanism := range available {
+			mechanisms = append(mechanisms, mechanism)
+		}
+		sort.Strings(mechanisms)
 	}
-	return &LocalSasl{
+	localSasl := &LocalSasl{
 		enabled:             params.enabled,
 		timeout:             params.timeout,
-		localAuthenticators: localAuthenticators,
+		mechanisms:          make([]string, 0, len(mechanisms)),
+		localAuthenticators: make(map[string]localSaslMechanism),
+		connections:         newPrincipalConnections(),
+		revocations:         params.revocations,
+		downgradeProtection: params.downgradeProtection,
+		rejection:           params.rejection,
+	}
+	for _, mechanism := range mechanisms {
+		if localAuthenticator, ok := available[mechanism]; ok {
+			localSasl.mechanisms = append(localSasl.mechanisms, mechanism)
+			localSasl.localAuthenticators[mechanism] = localAuthenticator
+		}
 	}
+	return localSasl
 }
 
-func (p *LocalSasl) receiveAndSendSASLAuthV1(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (err error) {
-	var localSaslAuth LocalSaslAuth
-	if localSaslAuth, err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 1); err != nil {
-		return err
+// saslMechanismStrength ranks the mechanisms which send the password or a bearer token below the SCRAM challenge response
+func saslMechanismStrength(mechanism string) int {
+	switch mechanism {
+	case config.SASLScramSHA256, config.SASLScramSHA512:
+		return 1
+	default:
+		return 0
 	}
-	if err = p.receiveAndSendAuthV1(conn, localSaslAuth); err != nil {
-		return err
+}
+
+// forConnection returns the local authentication of a client connection. With the downgrade protection, the connections without TLS
+// accept only the strongest configured mechanisms, so a man in the middle cannot make the client fall back from SCRAM to PLAIN.
+func (p *LocalSasl) forConnection(tlsConnection bool) *LocalSasl {
+	if !p.enabled || !p.downgradeProtection || tlsConnection {
+		return p
+	}
+	strongest := 0
+	for _, mechanism := range p.mechanisms {
+		if strength := saslMechanismStrength(mechanism); strength > strongest {
+			strongest = strength
+		}
+	}
+	restricted := *p
+	restricted.mechanisms = make([]string, 0, len(p.mechanisms))
+	restricted.localAuthenticators = make(map[string]localSaslMechanism)
+	restricted.downgraded = make(map[string]bool)
+	for _, mechanism := range p.mechanisms {
+		if saslMechanismStrength(mechanism) < strongest {
+			restricted.downgraded[mechanism] = true
+			continue
+		}
+		restricted.mechanisms = append(restricted.mechanisms, mechanism)
+		restricted.localAuthenticators[mechanism] = p.localAuthenticators[mechanism]
 	}
-	return nil
+	return &restricted
 }
 
-func (p *LocalSasl) receiveAndSendSASLAuthV0(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (err error) {
-	var localSaslAuth LocalSaslAuth
-	if localSaslAuth, err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 0); err != nil {
-		return err
+// receiveAndSendSASLAuthV1 returns the principal and the session of the authenticated client
+func (p *LocalSasl) receiveAndSendSASLAuthV1(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (principal string, session localSaslSession, err error) {
+	if session, err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 1); err != nil {
+		return "", nil, err
 	}
-	if err = p.receiveAndSendAuthV0(conn, localSaslAuth); err != nil {
-		return err
+	if principal, err = p.receiveAndSendAuthV1(conn, session); err != nil {
+		return "", nil, err
 	}
-	return nil
+	return principal, session, nil
 }
 
-func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersionBuf []byte, version int16) (localSaslAuth LocalSaslAuth, err error) {
+// receiveAndSendSASLAuthV0 returns the principal and the session of the authenticated client
+func (p *LocalSasl) receiveAndSendSASLAuthV0(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (principal string, session localSaslSession, err error) {
+	if session, err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 0); err != nil {
+		return "", nil, err
+	}
+	if principal, err = p.receiveAndSendAuthV0(conn, session); err != nil {
+		return "", nil, err
+	}
+	return principal, session, nil
+}
+
+func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersionBuf []byte, version int16) (session localSaslSession, err error) {
 	requestDeadline := time.Now().Add(p.timeout)
 	err = conn.SetDeadline(requestDeadline)
 	if err != nil {
@@ -99,17 +178,22 @@ func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersi
 
 	var saslResult error
 	saslErr := protocol.ErrNoError
-	localSaslAuth = p.localAuthenticators[saslReqV0orV1.Mechanism]
-	if localSaslAuth == nil {
-		mechanisms := make([]string, 0)
-		for mechanism := range p.localAuthenticators {
-			mechanisms = append(mechanisms, mechanism)
+	if localAuthenticator, ok := p.localAuthenticators[saslReqV0orV1.Mechanism]; ok {
+		session = localAuthenticator.newSession()
+		if p.revocations != nil {
+			session = &revocableSession{localSaslSession: session, revocations: p.revocations}
 		}
-		saslResult = fmt.Errorf("PLAIN or OAUTHBEARER mechanism expected, %v are configured, but got %s", mechanisms, saslReqV0orV1.Mechanism)
+	} else if p.downgraded[saslReqV0orV1.Mechanism] {
+		logrus.Warnf("SASL mechanism %s was refused on a connection without TLS, only %v are accepted", saslReqV0orV1.Mechanism, p.mechanisms)
+		proxyLocalSaslDowngradesRejectedTotal.WithLabelValues(saslReqV0orV1.Mechanism).Inc()
+		saslResult = fmt.Errorf("%v mechanisms are accepted without TLS, but got %s", p.mechanisms, saslReqV0orV1.Mechanism)
+		saslErr = protocol.ErrUnsupportedSASLMechanism
+	} else {
+		saslResult = fmt.Errorf("%v mechanisms are enabled, but got %s", p.mechanisms, saslReqV0orV1.Mechanism)
 		saslErr = protocol.ErrUnsupportedSASLMechanism
 	}
 
-	saslResV0 := &protocol.SaslHandshakeResponseV0orV1{Err: saslErr, EnabledMechanisms: []string{saslReqV0orV1.Mechanism}}
+	saslResV0 := &protocol.SaslHandshakeResponseV0orV1{Err: saslErr, EnabledMechanisms: p.mechanisms}
 	newResponseBuf, err := protocol.Encode(saslResV0)
 	if err != nil {
 		return nil, err
@@ -124,108 +208,124 @@ func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersi
 	if _, err := conn.Write(newResponseBuf); err != nil {
 		return nil, err
 	}
-	return localSaslAuth, saslResult
+	return session, saslResult
 }
 
-func (p *LocalSasl) receiveAndSendAuthV1(conn DeadlineReaderWriter, localSaslAuth LocalSaslAuth) (err error) {
+// receiveAndSendAuthV1 answers the SaslAuthenticate requests until the session is done
+func (p *LocalSasl) receiveAndSendAuthV1(conn DeadlineReaderWriter, session localSaslSession) (principal string, err error) {
+	for {
+		var done bool
+		if principal, done, err = p.receiveAndSendAuthenticateRequest(conn, session); err != nil || done {
+			return principal, err
+		}
+	}
+}
+
+func (p *LocalSasl) receiveAndSendAuthenticateRequest(conn DeadlineReaderWriter, session localSaslSession) (principal string, done bool, err error) {
 	requestDeadline := time.Now().Add(p.timeout)
 	err = conn.SetDeadline(requestDeadline)
 	if err != nil {
-		return err
+		return "", false, err
 	}
 
 	keyVersionBuf := make([]byte, 8) // Size => int32 + ApiKey => int16 + ApiVersion => int16
 	if _, err = io.ReadFull(conn, keyVersionBuf); err != nil {
-		return err
+		return "", false, err
 	}
 	requestKeyVersion := &protocol.RequestKeyVersion{}
 	if err = protocol.Decode(keyVersionBuf, requestKeyVersion); err != nil {
-		return err
+		return "", false, err
 	}
 	if !(requestKeyVersion.ApiKey == 36 && requestKeyVersion.ApiVersion == 0) {
-		return errors.New("SaslAuthenticate version 0 is expected")
+		return "", false, errors.New("SaslAuthenticate version 0 is expected")
 	}
 
 	if int32(requestKeyVersion.Length) > protocol.MaxRequestSize {
-		return protocol.PacketDecodingError{Info: fmt.Sprintf("sasl authenticate message of length %d too large", requestKeyVersion.Length)}
+		return "", false, protocol.PacketDecodingError{Info: fmt.Sprintf("sasl authenticate message of length %d too large", requestKeyVersion.Length)}
 	}
 
 	resp := make([]byte, int(requestKeyVersion.Length-4))
 	if _, err = io.ReadFull(conn, resp); err != nil {
-		return err
+		return "", false, err
 	}
 	payload := bytes.Join([][]byte{keyVersionBuf[4:], resp}, nil)
 
 	saslAuthReqV0 := &protocol.SaslAuthenticateRequestV0{}
 	req := &protocol.Request{Body: saslAuthReqV0}
 	if err = protocol.Decode(payload, req); err != nil {
-		return err
+		return "", false, err
 	}
 
-	authErr := localSaslAuth.doLocalAuth(saslAuthReqV0.SaslAuthBytes)
+	response, principal, done, authErr := session.step(saslAuthReqV0.SaslAuthBytes)
 
 	var saslAuthResV0 *protocol.SaslAuthenticateResponseV0
 	if authErr == nil {
 		// Length of SaslAuthBytes !=0 for OAUTHBEARER causes that java SaslClientAuthenticator in INTERMEDIATE state will sent SaslAuthenticate(36) second time
-		saslAuthResV0 = &protocol.SaslAuthenticateResponseV0{Err: protocol.ErrNoError, SaslAuthBytes: make([]byte, 0)}
+		saslAuthResV0 = &protocol.SaslAuthenticateResponseV0{Err: protocol.ErrNoError, SaslAuthBytes: response}
 	} else {
-		errMsg := authErr.Error()
+		errMsg := p.rejection.messageOf(authErr)
 		saslAuthResV0 = &protocol.SaslAuthenticateResponseV0{Err: protocol.ErrSASLAuthenticationFailed, ErrMsg: &errMsg, SaslAuthBytes: make([]byte, 0)}
 	}
 
 	newResponseBuf, err := protocol.Encode(saslAuthResV0)
 	if err != nil {
-		return err
+		return "", false, err
 	}
 	newHeaderBuf, err := protocol.Encode(&protocol.ResponseHeader{Length: int32(len(newResponseBuf) + 4), CorrelationID: req.CorrelationID})
 	if err != nil {
-		return err
+		return "", false, err
 	}
 	if _, err := conn.Write(newHeaderBuf); err != nil {
-		return err
+		return "", false, err
 	}
 	if _, err := conn.Write(newResponseBuf); err != nil {
-		return err
+		return "", false, err
 	}
-	return authErr
-
+	return principal, done, authErr
 }
 
-func (p *LocalSasl) receiveAndSendAuthV0(conn DeadlineReaderWriter, localSaslAuth LocalSaslAuth) (err error) {
-	requestDeadline := time.Now().Add(p.timeout)
-	err = conn.SetDeadline(requestDeadline)
-	if err != nil {
-		return err
-	}
-
-	sizeBuf := make([]byte, 4) // Size => int32
-	if _, err = io.ReadFull(conn, sizeBuf); err != nil {
-		return err
-	}
+// receiveAndSendAuthV0 answers the raw authentication bytes until the session is done
+func (p *LocalSasl) receiveAndSendAuthV0(conn DeadlineReaderWriter, session localSaslSession) (principal string, err error) {
+	if session == nil {
+		return "", errors.New("session is nil")
+	}
+	for {
+		requestDeadline := time.Now().Add(p.timeout)
+		err = conn.SetDeadline(requestDeadline)
+		if err != nil {
+			return "", err
+		}
 
-	length := binary.BigEndian.Uint32(sizeBuf)
-	if int32(length) > protocol.MaxRequestSize {
-		return protocol.PacketDecodingError{Info: fmt.Sprintf("auth message of length %d too large", length)}
-	}
+		sizeBuf := make([]byte, 4) // Size => int32
+		if _, err = io.ReadFull(conn, sizeBuf); err != nil {
+			return "", err
+		}
 
-	saslAuthBytes := make([]byte, length)
-	_, err = io.ReadFull(conn, saslAuthBytes)
-	if err != nil {
-		return err
-	}
+		length := binary.BigEndian.Uint32(sizeBuf)
+		if length > uint32(protocol.MaxRequestSize) {
+			return "", protocol.PacketDecodingError{Info: fmt.Sprintf("auth message of length %d too large", length)}
+		}
 
-	if localSaslAuth == nil {
-		return errors.New("localSaslAuth is nil")
-	}
+		saslAuthBytes := make([]byte, length)
+		_, err = io.ReadFull(conn, saslAuthBytes)
+		if err != nil {
+			return "", err
+		}
 
-	if err = localSaslAuth.doLocalAuth(saslAuthBytes); err != nil {
-		return err
-	}
-	// If the credentials are valid, we would write a 4 byte response filled with null characters.
-	// Otherwise, the closes the connection i.e. return error
-	header := make([]byte, 4)
-	if _, err := conn.Write(header); err != nil {
-		return err
+		response, principal, done, err := session.step(saslAuthBytes)
+		if err != nil {
+			return "", err
+		}
+		// If the credentials are valid, we would write the length of the response followed by the response, e.g. 4 byte array of null characters for PLAIN.
+		// Otherwise, the closes the connection i.e. return error
+		header := make([]byte, 4+len(response))
+		binary.BigEndian.PutUint32(header, uint32(len(response)))
+		copy(header[4:], response)
+		if _, err := conn.Write(header); err != nil {
+			return "", err
+		}
+		if done {
+			return principal, nil
+		}
 	}
-	return nil
 }
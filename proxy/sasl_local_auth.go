@@ -10,7 +10,51 @@ import (
 )
 
 type LocalSaslAuth interface {
-	doLocalAuth(saslAuthBytes []byte) (err error)
+	// doLocalAuth returns the principal and the session attributes of the authenticated client, nil attributes are not limited
+	doLocalAuth(saslAuthBytes []byte) (principal string, attributes *apis.SessionAttributes, err error)
+}
+
+// localSaslMechanism starts the authentication sessions of the client connections
+type localSaslMechanism interface {
+	newSession() localSaslSession
+}
+
+// localSaslSession authenticates the client connection with one or more messages, e.g. SCRAM needs two client messages
+type localSaslSession interface {
+	// step returns the response to the client message, done is true when the authentication is finished
+	step(clientMessage []byte) (response []byte, principal string, done bool, err error)
+	// sessionAttributes returns the session attributes of the authenticated client, nil if the client is not limited
+	sessionAttributes() *apis.SessionAttributes
+	// tokenID returns the id of the token of the authenticated client, empty if the client is not authenticated with a token
+	tokenID() string
+}
+
+// localSaslTokenAuth is implemented by the mechanisms which authenticate the client with a token
+type localSaslTokenAuth interface {
+	tokenID(saslAuthBytes []byte) string
+}
+
+// singleStepSession authenticates the client with the first message
+type singleStepSession struct {
+	auth       LocalSaslAuth
+	attributes *apis.SessionAttributes
+	id         string
+}
+
+func (s *singleStepSession) step(clientMessage []byte) (response []byte, principal string, done bool, err error) {
+	principal, s.attributes, err = s.auth.doLocalAuth(clientMessage)
+	if tokenAuth, ok := s.auth.(localSaslTokenAuth); ok && err == nil {
+		s.id = tokenAuth.tokenID(clientMessage)
+	}
+	return make([]byte, 0), principal, true, err
+}
+
+func (s *singleStepSession) sessionAttributes() *apis.SessionAttributes {
+	return s.attributes
+}
+
+func (s *singleStepSession) tokenID() string {
+	return s.id
 }
 
 type LocalSaslPlain struct {
@@ -23,28 +67,41 @@ func NewLocalSaslPlain(localAuthenticator apis.PasswordAuthenticator) *LocalSasl
 	}
 }
 
+func (p *LocalSaslPlain) newSession() localSaslSession {
+	return &singleStepSession{auth: p}
+}
+
 // implements LocalSaslAuth
-func (p *LocalSaslPlain) doLocalAuth(saslAuthBytes []byte) (err error) {
+// the session attributes are returned if the authenticator implements apis.AttributesPasswordAuthenticator
+func (p *LocalSaslPlain) doLocalAuth(saslAuthBytes []byte) (principal string, attributes *apis.SessionAttributes, err error) {
 	tokens := strings.Split(string(saslAuthBytes), "\x00")
 	if len(tokens) != 3 {
-		return fmt.Errorf("invalid SASL/PLAIN request: expected 3 tokens, got %d", len(tokens))
+		return "", nil, fmt.Errorf("invalid SASL/PLAIN request: expected 3 tokens, got %d", len(tokens))
 	}
 	if p.localAuthenticator == nil {
-		return protocol.PacketDecodingError{Info: "Listener authenticator is not set"}
+		return "", nil, protocol.PacketDecodingError{Info: "Listener authenticator is not set"}
 	}
 
 	// logrus.Infof("user: %s , password: %s", tokens[1], tokens[2])
-	ok, status, err := p.localAuthenticator.Authenticate(tokens[1], tokens[2])
+	var (
+		ok     bool
+		status int32
+	)
+	if authenticator, isAttributes := p.localAuthenticator.(apis.AttributesPasswordAuthenticator); isAttributes {
+		ok, status, attributes, err = authenticator.AuthenticateWithAttributes(tokens[1], tokens[2])
+	} else {
+		ok, status, err = p.localAuthenticator.Authenticate(tokens[1], tokens[2])
+	}
 	if err != nil {
 		proxyLocalAuthTotal.WithLabelValues("error", "1").Inc()
-		return err
+		return "", nil, err
 	}
 	proxyLocalAuthTotal.WithLabelValues(strconv.FormatBool(ok), strconv.Itoa(int(status))).Inc()
 
 	if !ok {
-		return fmt.Errorf("user %s authentication failed", tokens[1])
+		return "", nil, fmt.Errorf("user %s authentication failed", tokens[1])
 	}
-	return nil
+	return tokens[1], attributes, nil
 }
 
 type LocalSaslOauth struct {
@@ -59,18 +116,32 @@ func NewLocalSaslOauth(tokenAuthenticator apis.TokenInfo) *LocalSaslOauth {
 	}
 }
 
+func (p *LocalSaslOauth) newSession() localSaslSession {
+	return &singleStepSession{auth: p}
+}
+
 // implements LocalSaslAuth
-func (p *LocalSaslOauth) doLocalAuth(saslAuthBytes []byte) (err error) {
-	token, _, _, err := p.saslOAuthBearer.GetClientInitialResponse(saslAuthBytes)
+// the principal is the authorization identity sent by the client
+func (p *LocalSaslOauth) doLocalAuth(saslAuthBytes []byte) (principal string, attributes *apis.SessionAttributes, err error) {
+	token, authzid, _, err := p.saslOAuthBearer.GetClientInitialResponse(saslAuthBytes)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	resp, err := p.tokenAuthenticator.VerifyToken(context.Background(), apis.VerifyRequest{Token: token})
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	if !resp.Success {
-		return fmt.Errorf("local oauth verify token failed with status: %d", resp.Status)
+		return "", nil, fmt.Errorf("local oauth verify token failed with status: %d", resp.Status)
+	}
+	return authzid, nil, nil
+}
+
+// implements localSaslTokenAuth
+func (p *LocalSaslOauth) tokenID(saslAuthBytes []byte) string {
+	token, _, _, err := p.saslOAuthBearer.GetClientInitialResponse(saslAuthBytes)
+	if err != nil {
+		return ""
 	}
-	return nil
+	return oauthTokenID(token)
 }
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// LocalSaslScram verifies SCRAM-SHA-256 or SCRAM-SHA-512 client proofs (https://tools.ietf.org/html/rfc5802)
+// with the salted credentials of the users looked up in the credential store
+type LocalSaslScram struct {
+	mechanism string
+	hashFunc  func() hash.Hash
+	store     apis.ScramCredentialStore
+}
+
+// NewLocalSaslScram returns the verifier of the mechanism with the credentials of the store
+func NewLocalSaslScram(mechanism string, store apis.ScramCredentialStore) (*LocalSaslScram, error) {
+	hashFunc, err := scramHashFunc(mechanism)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalSaslScram{mechanism: mechanism, hashFunc: hashFunc, store: store}, nil
+}
+
+func scramHashFunc(mechanism string) (func() hash.Hash, error) {
+	switch mechanism {
+	case config.SASLScramSHA256:
+		return sha256.New, nil
+	case config.SASLScramSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism %s", mechanism)
+	}
+}
+
+// scramFileStore is the credential store of the credentials file
+type scramFileStore map[string]apis.ScramCredentialResponse
+
+// NewScramFileStore returns the credential store of the credentials, the passwords are salted
+func NewScramFileStore(credentials []config.ScramCredential) (apis.ScramCredentialStore, error) {
+	store := make(scramFileStore)
+	for _, c := range credentials {
+		credential := apis.ScramCredentialResponse{Found: true, Iterations: int32(c.Iterations), Salt: c.Salt, StoredKey: c.StoredKey, ServerKey: c.ServerKey}
+		if c.Password != "" {
+			hashFunc, err := scramHashFunc(c.Mechanism)
+			if err != nil {
+				return nil, err
+			}
+			credential.Salt = make([]byte, 32)
+			if _, err := rand.Read(credential.Salt); err != nil {
+				return nil, err
+			}
+			credential.StoredKey, credential.ServerKey = scramSaltedKeys(hashFunc, c.Password, credential.Salt, c.Iterations)
+		}
+		store[c.Mechanism+" "+c.Username] = credential
+	}
+	return store, nil
+}
+
+func (s scramFileStore) GetScramCredential(request apis.ScramCredentialRequest) (apis.ScramCredentialResponse, error) {
+	return s[request.Mechanism+" "+request.Username], nil
+}
+
+// scramSaltedKeys returns the stored key and the server key of the password
+func scramSaltedKeys(hashFunc func() hash.Hash, password string, salt []byte, iterations int) ([]byte, []byte) {
+	saltedPassword := pbkdf2(hashFunc, []byte(password), salt, iterations)
+	sign := func(key []byte, message []byte) []byte {
+		mac := hmac.New(hashFunc, key)
+		mac.Write(message)
+		return mac.Sum(nil)
+	}
+	clientKey := sign(saltedPassword, []byte("Client Key"))
+	h := hashFunc()
+	h.Write(clientKey)
+	return h.Sum(nil), sign(saltedPassword, []byte("Server Key"))
+}
+
+func (p *LocalSaslScram) hmac(key []byte, message []byte) []byte {
+	mac := hmac.New(p.hashFunc, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (p *LocalSaslScram) newSession() localSaslSession {
+	return &localSaslScramSession{scram: p}
+}
+
+// pbkdf2 is the PBKDF2 key derivation with the key length of the hash size (RFC 2898), which is the SaltedPassword of SCRAM
+func pbkdf2(hashFunc func() hash.Hash, password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(hashFunc, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// localSaslScramSession is the SCRAM conversation: client-first, server-first, client-final and server-final message
+type localSaslScramSession struct {
+	scram *LocalSaslScram
+
+	username        string
+	credential      apis.ScramCredentialResponse
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+}
+
+func (s *localSaslScramSession) step(clientMessage []byte) (response []byte, principal string, done bool, err error) {
+	if s.serverFirst == "" {
+		response, err = s.clientFirst(string(clientMessage))
+		return response, "", false, err
+	}
+	response, err = s.clientFinal(string(clientMessage))
+	if err != nil {
+		proxyLocalAuthTotal.WithLabelValues("false", "0").Inc()
+		return nil, "", true, err
+	}
+	proxyLocalAuthTotal.WithLabelValues("true", "0").Inc()
+	return response, s.username, true, nil
+}
+
+// clientFirst parses 'gs2-header client-first-message-bare' and returns 'r=nonce,s=salt,i=iterations'
+func (s *localSaslScramSession) clientFirst(message string) ([]byte, error) {
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 {
+		return nil, errors.Errorf("invalid %s client first message", s.scram.mechanism)
+	}
+	if parts[0] != "n" && parts[0] != "y" {
+		return nil, errors.Errorf("%s channel binding is not supported", s.scram.mechanism)
+	}
+	s.gs2Header = parts[0] + "," + parts[1] + ","
+	s.clientFirstBare = parts[2]
+
+	attributes := parseScramAttributes(s.clientFirstBare)
+	username, ok := attributes["n"]
+	if !ok || attributes["r"] == "" {
+		return nil, errors.Errorf("invalid %s client first message: username and nonce are required", s.scram.mechanism)
+	}
+	s.username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(username)
+	if s.scram.store == nil {
+		return nil, protocol.PacketDecodingError{Info: "Listener SCRAM credential store is not set"}
+	}
+	credential, err := s.scram.store.GetScramCredential(apis.ScramCredentialRequest{Mechanism: s.scram.mechanism, Username: s.username})
+	if err != nil {
+		proxyLocalAuthTotal.WithLabelValues("error", "1").Inc()
+		return nil, err
+	}
+	if !credential.Found || credential.Iterations < 1 || len(credential.StoredKey) == 0 || len(credential.ServerKey) == 0 {
+		proxyLocalAuthTotal.WithLabelValues("false", strconv.Itoa(int(credential.Status))).Inc()
+		return nil, fmt.Errorf("user %s authentication failed", s.username)
+	}
+	s.credential = credential
+	serverNonce := make([]byte, 24)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	s.nonce = attributes["r"] + base64.RawStdEncoding.EncodeToString(serverNonce)
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", s.nonce, base64.StdEncoding.EncodeToString(s.credential.Salt), s.credential.Iterations)
+	return []byte(s.serverFirst), nil
+}
+
+// clientFinal verifies 'c=channel binding,r=nonce,p=proof' and returns 'v=server signature'
+func (s *localSaslScramSession) clientFinal(message string) ([]byte, error) {
+	proofIndex := strings.LastIndex(message, ",p=")
+	if proofIndex < 0 {
+		return nil, errors.Errorf("invalid %s client final message: proof is required", s.scram.mechanism)
+	}
+	withoutProof := message[:proofIndex]
+	attributes := parseScramAttributes(message)
+	if attributes["c"] != base64.StdEncoding.EncodeToString([]byte(s.gs2Header)) {
+		return nil, errors.Errorf("invalid %s client final message: channel binding does not match", s.scram.mechanism)
+	}
+	if attributes["r"] != s.nonce {
+		return nil, errors.Errorf("invalid %s client final message: nonce does not match", s.scram.mechanism)
+	}
+	proof, err := base64.StdEncoding.DecodeString(attributes["p"])
+	if err != nil || len(proof) != len(s.credential.StoredKey) {
+		return nil, errors.Errorf("invalid %s client final message: invalid proof", s.scram.mechanism)
+	}
+	authMessage := []byte(s.clientFirstBare + "," + s.serverFirst + "," + withoutProof)
+	clientSignature := s.scram.hmac(s.credential.StoredKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	h := s.scram.hashFunc()
+	h.Write(clientKey)
+	if !hmac.Equal(h.Sum(nil), s.credential.StoredKey) {
+		return nil, fmt.Errorf("user %s authentication failed", s.username)
+	}
+	serverSignature := s.scram.hmac(s.credential.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+func (s *localSaslScramSession) sessionAttributes() *apis.SessionAttributes {
+	return nil
+}
+
+func (s *localSaslScramSession) tokenID() string {
+	return ""
+}
+
+func parseScramAttributes(message string) map[string]string {
+	result := make(map[string]string)
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) >= 2 && attribute[1] == '=' {
+			result[attribute[:1]] = attribute[2:]
+		}
+	}
+	return result
+}
@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"hash"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPasswordAuthenticator map[string]string
+
+func (p testPasswordAuthenticator) Authenticate(username, password string) (bool, int32, error) {
+	expected, ok := p[username]
+	return ok && expected == password, 0, nil
+}
+
+// scramClientProof returns the client proof and the expected server signature of the conversation
+func scramClientProof(hashFunc func() hash.Hash, password string, salt []byte, iterations int, authMessage []byte) ([]byte, []byte) {
+	saltedPassword := pbkdf2(hashFunc, []byte(password), salt, iterations)
+	sign := func(key []byte, message []byte) []byte {
+		mac := hmac.New(hashFunc, key)
+		mac.Write(message)
+		return mac.Sum(nil)
+	}
+	clientKey := sign(saltedPassword, []byte("Client Key"))
+	h := hashFunc()
+	h.Write(clientKey)
+	clientSignature := sign(h.Sum(nil), authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	return proof, sign(sign(saltedPassword, []byte("Server Key")), authMessage)
+}
+
+func writeSaslRequest(a *assert.Assertions, conn net.Conn, correlationID int32, body protocol.ProtocolBody) {
+	buf, err := protocol.Encode(&protocol.Request{CorrelationID: correlationID, ClientID: "test", Body: body})
+	a.Nil(err)
+	sizeBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(buf)))
+	_, err = conn.Write(bytes.Join([][]byte{sizeBuf, buf}, nil))
+	a.Nil(err)
+}
+
+func saslAuthenticate(a *assert.Assertions, conn net.Conn, correlationID int32, authBytes []byte) *protocol.SaslAuthenticateResponseV0 {
+	writeSaslRequest(a, conn, correlationID, &protocol.SaslAuthenticateRequestV0{SaslAuthBytes: authBytes})
+	responseCorrelationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(correlationID, responseCorrelationID)
+	res := &protocol.SaslAuthenticateResponseV0{}
+	a.Nil(protocol.Decode(body, res))
+	return res
+}
+
+// scramAuthenticate runs the SCRAM conversation and returns the response to the client final message and the expected server signature
+func scramAuthenticate(a *assert.Assertions, conn net.Conn, mechanism string, hashFunc func() hash.Hash, username string, password string, enabledMechanisms []string) (*protocol.SaslAuthenticateResponseV0, []byte) {
+	writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: mechanism})
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	handshake := &protocol.SaslHandshakeResponseV0orV1{}
+	a.Nil(protocol.Decode(body, handshake))
+	a.Equal(protocol.ErrNoError, handshake.Err)
+	a.Equal(enabledMechanisms, handshake.EnabledMechanisms)
+
+	clientFirstBare := "n=" + username + ",r=client-nonce"
+	res := saslAuthenticate(a, conn, 2, []byte("n,,"+clientFirstBare))
+	if res.Err != protocol.ErrNoError {
+		return res, nil
+	}
+	serverFirst := string(res.SaslAuthBytes)
+	attributes := parseScramAttributes(serverFirst)
+	a.True(strings.HasPrefix(attributes["r"], "client-nonce"))
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	a.Nil(err)
+	a.Equal("4096", attributes["i"])
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + attributes["r"]
+	proof, serverSignature := scramClientProof(hashFunc, password, salt, 4096, []byte(clientFirstBare+","+serverFirst+","+withoutProof))
+	return saslAuthenticate(a, conn, 3, []byte(withoutProof+",p="+base64.StdEncoding.EncodeToString(proof))), serverSignature
+}
+
+func TestPbkdf2(t *testing.T) {
+	a := assert.New(t)
+	// RFC 7914 section 11 PBKDF2-HMAC-SHA256 test vector, first 32 bytes
+	a.Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc", fmt.Sprintf("%x", pbkdf2(sha256.New, []byte("passwd"), []byte("salt"), 1)))
+}
+
+func TestProxyLocalSaslAdvertisesMechanismsAndVerifiesScram(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	credentialsFile, err := ioutil.TempFile("", "scram-credentials-")
+	a.Nil(err)
+	defer os.Remove(credentialsFile.Name())
+	_, err = credentialsFile.WriteString("# test users\nalice SCRAM-SHA-256=[iterations=4096,password=alice-secret]\n")
+	a.Nil(err)
+	a.Nil(credentialsFile.Close())
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{config.SASLScramSHA256, SASLPlain}
+	c.Auth.Local.ScramCredentialsFile = credentialsFile.Name()
+	listenerAddress, stop := startTestProxy(a, c, WithLocalPasswordAuthenticator(testPasswordAuthenticator{"bob": "bob-secret"}))
+	defer stop()
+
+	for _, password := range []string{"alice-secret", "wrong"} {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+
+		res, serverSignature := scramAuthenticate(a, conn, config.SASLScramSHA256, sha256.New, "alice", password, []string{config.SASLScramSHA256, SASLPlain})
+		if password == "wrong" {
+			a.Equal(protocol.ErrSASLAuthenticationFailed, res.Err)
+			a.Equal("user alice authentication failed", *res.ErrMsg)
+			conn.Close()
+			continue
+		}
+		a.Equal(protocol.ErrNoError, res.Err)
+		a.Equal("v="+base64.StdEncoding.EncodeToString(serverSignature), string(res.SaslAuthBytes))
+
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 7, "test", []byte("fetch")))
+		a.Nil(err)
+		correlationID, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(int32(7), correlationID)
+		a.Equal([]byte("fetch"), body)
+		conn.Close()
+	}
+
+	// PLAIN is dispatched to the password authenticator
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	res := saslAuthenticate(a, conn, 2, []byte("\x00bob\x00bob-secret"))
+	a.Equal(protocol.ErrNoError, res.Err)
+}
+
+func TestProxyLocalSaslRejectsMechanismNotAdvertised(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{SASLPlain}
+	listenerAddress, stop := startTestProxy(a, c, WithLocalPasswordAuthenticator(testPasswordAuthenticator{"bob": "bob-secret"}))
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: config.SASLScramSHA512})
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	handshake := &protocol.SaslHandshakeResponseV0orV1{}
+	a.Nil(protocol.Decode(body, handshake))
+	a.Equal(protocol.ErrUnsupportedSASLMechanism, handshake.Err)
+	a.Equal([]string{SASLPlain}, handshake.EnabledMechanisms)
+}
+
+type testScramCredentialStore map[string]apis.ScramCredentialResponse
+
+func (s testScramCredentialStore) GetScramCredential(request apis.ScramCredentialRequest) (apis.ScramCredentialResponse, error) {
+	return s[request.Mechanism+" "+request.Username], nil
+}
+
+func TestProxyLocalSaslScramUsesCredentialStore(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	salt := []byte("carol-salt")
+	storedKey, serverKey := scramSaltedKeys(sha512.New, "carol-secret", salt, 4096)
+	store := testScramCredentialStore{
+		config.SASLScramSHA512 + " carol": {Found: true, Iterations: 4096, Salt: salt, StoredKey: storedKey, ServerKey: serverKey},
+	}
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{config.SASLScramSHA512}
+	listenerAddress, stop := startTestProxy(a, c, WithLocalScramCredentialStore(store))
+	defer stop()
+
+	for _, tt := range []struct {
+		username string
+		password string
+		err      protocol.KError
+	}{
+		{username: "carol", password: "carol-secret", err: protocol.ErrNoError},
+		{username: "carol", password: "wrong", err: protocol.ErrSASLAuthenticationFailed},
+		{username: "dave", password: "carol-secret", err: protocol.ErrSASLAuthenticationFailed},
+	} {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		res, serverSignature := scramAuthenticate(a, conn, config.SASLScramSHA512, sha512.New, tt.username, tt.password, []string{config.SASLScramSHA512})
+		a.Equal(tt.err, res.Err, tt.username+":"+tt.password)
+		if tt.err == protocol.ErrNoError {
+			a.Equal("v="+base64.StdEncoding.EncodeToString(serverSignature), string(res.SaslAuthBytes))
+		} else {
+			a.Equal("user "+tt.username+" authentication failed", *res.ErrMsg)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLocalSaslForConnection(t *testing.T) {
+	a := assert.New(t)
+
+	localSasl := &LocalSasl{
+		enabled:    true,
+		mechanisms: []string{config.SASLScramSHA512, SASLPlain, config.SASLScramSHA256, SASLOAuthBearer},
+		localAuthenticators: map[string]localSaslMechanism{
+			config.SASLScramSHA512: nil, SASLPlain: nil, config.SASLScramSHA256: nil, SASLOAuthBearer: nil,
+		},
+	}
+	a.True(localSasl == localSasl.forConnection(false))
+
+	localSasl.downgradeProtection = true
+	restricted := localSasl.forConnection(false)
+	a.Equal([]string{config.SASLScramSHA512, config.SASLScramSHA256}, restricted.mechanisms)
+	a.Len(restricted.localAuthenticators, 2)
+	a.Equal(map[string]bool{SASLPlain: true, SASLOAuthBearer: true}, restricted.downgraded)
+	a.Len(localSasl.mechanisms, 4)
+
+	// the mechanisms are not restricted with TLS
+	a.True(localSasl == localSasl.forConnection(true))
+
+	// nothing to protect without SCRAM
+	localSasl.mechanisms = []string{SASLPlain, SASLOAuthBearer}
+	a.Equal([]string{SASLPlain, SASLOAuthBearer}, localSasl.forConnection(false).mechanisms)
+}
+
+func TestProxyLocalSaslDowngradeProtection(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	credentialsFile, err := ioutil.TempFile("", "scram-credentials-")
+	a.Nil(err)
+	defer os.Remove(credentialsFile.Name())
+	_, err = credentialsFile.WriteString("alice SCRAM-SHA-256=[iterations=4096,password=alice-secret]\n")
+	a.Nil(err)
+	a.Nil(credentialsFile.Close())
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{config.SASLScramSHA256, SASLPlain}
+	c.Auth.Local.ScramCredentialsFile = credentialsFile.Name()
+	c.Auth.Local.DowngradeProtection = true
+	listenerAddress, stop := startTestProxy(a, c, WithLocalPasswordAuthenticator(testPasswordAuthenticator{"bob": "bob-secret"}))
+	defer stop()
+
+	// PLAIN is refused and only SCRAM is advertised
+	downgrades := counterOf(a, proxyLocalSaslDowngradesRejectedTotal, SASLPlain)
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	handshake := &protocol.SaslHandshakeResponseV0orV1{}
+	a.Nil(protocol.Decode(body, handshake))
+	a.Equal(protocol.ErrUnsupportedSASLMechanism, handshake.Err)
+	a.Equal([]string{config.SASLScramSHA256}, handshake.EnabledMechanisms)
+	a.Equal(downgrades+1, counterOf(a, proxyLocalSaslDowngradesRejectedTotal, SASLPlain))
+	conn.Close()
+
+	conn, err = net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	res, _ := scramAuthenticate(a, conn, config.SASLScramSHA256, sha256.New, "alice", "alice-secret", []string{config.SASLScramSHA256})
+	a.Equal(protocol.ErrNoError, res.Err)
+
+	// the re-authentication is not forwarded to the broker
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Write(kafkatest.EncodeRequest(apiKeySaslAuthenticate, 0, 5, "test", []byte{0, 0, 0, 0}))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(apiKeySaslAuthenticate))
+}
+
+func TestProxyLocalSaslDowngradeProtectionWithCanaryRouting(t *testing.T) {
+	a := assert.New(t)
+
+	primary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer primary.Close()
+	secondary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 2})
+	a.Nil(err)
+	defer secondary.Close()
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	credentialsFile, err := ioutil.TempFile("", "scram-credentials-")
+	a.Nil(err)
+	defer os.Remove(credentialsFile.Name())
+	_, err = credentialsFile.WriteString("alice SCRAM-SHA-256=[iterations=4096,password=alice-secret]\n")
+	a.Nil(err)
+	a.Nil(credentialsFile.Close())
+
+	c := newTestProxyConfig(primary.Addr())
+	c.Proxy.TLS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{config.SASLScramSHA256, SASLPlain}
+	c.Auth.Local.ScramCredentialsFile = credentialsFile.Name()
+	c.Auth.Local.DowngradeProtection = true
+	c.Upstream.SecondaryMapping = []string{primary.Addr() + "," + secondary.Addr()}
+	c.Upstream.CanaryPercent = 100
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	listenerAddress, stop := startTestProxy(a, c, WithUpstreamSwitch(upstream), WithLocalPasswordAuthenticator(testPasswordAuthenticator{"bob": "bob-secret"}))
+	defer stop()
+
+	canaries := &dto.Metric{}
+	a.Nil(proxyUpstreamCanaryConnectionsTotal.Write(canaries))
+
+	// the connection replaying the peeked client id is still a TLS connection, PLAIN is not a downgrade
+	conn, err := tls.Dial("tcp", listenerAddress, &tls.Config{InsecureSkipVerify: true})
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	handshake := &protocol.SaslHandshakeResponseV0orV1{}
+	a.Nil(protocol.Decode(body, handshake))
+	a.Equal(protocol.ErrNoError, handshake.Err)
+	a.Equal([]string{config.SASLScramSHA256, SASLPlain}, handshake.EnabledMechanisms)
+	res := saslAuthenticate(a, conn, 2, []byte("\x00bob\x00bob-secret"))
+	a.Equal(protocol.ErrNoError, res.Err)
+
+	m := &dto.Metric{}
+	a.Nil(proxyUpstreamCanaryConnectionsTotal.Write(m))
+	a.Equal(canaries.GetCounter().GetValue()+1, m.GetCounter().GetValue())
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Confluent wire format: magic byte 0 followed by the schema id (int32) and the serialized data
+	schemaRegistryMagicByte  = 0
+	schemaRegistryHeaderSize = 5
+)
+
+// schemaValidationTopic requires the records of matching topics to be serialized with a schema registered under the subject
+type schemaValidationTopic struct {
+	pattern *regexp.Regexp
+	// empty subject means the topic name strategy
+	subject string
+}
+
+func (t *schemaValidationTopic) subjectFor(topic string) string {
+	if t.subject != "" {
+		return t.subject
+	}
+	return topic + "-value"
+}
+
+// schemaValidator rejects Produce requests with records not serialized with a schema registered under the configured subject
+type schemaValidator struct {
+	topics              []*schemaValidationTopic
+	registry            *schemaRegistryClient
+	maxDecompressedSize int
+}
+
+func newSchemaValidator(c *config.Config) (*schemaValidator, error) {
+	if len(c.SchemaValidation.Topics) == 0 {
+		return nil, nil
+	}
+	validator := &schemaValidator{
+		maxDecompressedSize: c.Compression.MaxDecompressedSize,
+		registry: &schemaRegistryClient{
+			url:        strings.TrimSuffix(c.SchemaValidation.Registry.URL, "/"),
+			username:   c.SchemaValidation.Registry.Username,
+			password:   c.SchemaValidation.Registry.Password,
+			httpClient: &http.Client{Timeout: c.SchemaValidation.Registry.Timeout},
+			cacheTTL:   c.SchemaValidation.Registry.CacheTTL,
+			cache:      make(map[int32]*schemaSubjects),
+		},
+	}
+	for _, v := range c.SchemaValidation.Topics {
+		pattern, subject, err := config.ParseSchemaValidationTopic(v)
+		if err != nil {
+			return nil, err
+		}
+		validator.topics = append(validator.topics, &schemaValidationTopic{pattern: pattern, subject: subject})
+	}
+	logrus.Infof("Records produced to topics matching %v will be validated against schema registry %s", c.SchemaValidation.Topics, c.SchemaValidation.Registry.URL)
+	return validator, nil
+}
+
+// isForbidden reports the Produce versions which records cannot be decoded
+func (v *schemaValidator) isForbidden(apiKey int16, apiVersion int16) bool {
+	if v == nil {
+		return false
+	}
+	_, maxVersion, _ := protocol.RecordBatchVersions(apiKeyProduce)
+	return apiKey == apiKeyProduce && apiVersion > maxVersion
+}
+
+// inspects reports whether the request body must be validated
+func (v *schemaValidator) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return v != nil && requestKeyVersion.ApiKey == apiKeyProduce
+}
+
+func (v *schemaValidator) topicFor(topic string) *schemaValidationTopic {
+	for _, t := range v.topics {
+		if t.pattern.MatchString(topic) {
+			return t
+		}
+	}
+	return nil
+}
+
+// validate returns an error if a record of the Produce request does not conform to the subject of its topic
+func (v *schemaValidator) validate(apiVersion int16, body []byte) error {
+	return protocol.DecodeProduceRecordValues(apiVersion, body, v.maxDecompressedSize, func(topic string, value []byte) error {
+		t := v.topicFor(topic)
+		if t == nil || value == nil {
+			// not validated topic or tombstone
+			return nil
+		}
+		subject := t.subjectFor(topic)
+		if len(value) < schemaRegistryHeaderSize || value[0] != schemaRegistryMagicByte {
+			proxySchemaValidationRejectedTotal.WithLabelValues(subject).Inc()
+			return fmt.Errorf("record produced to topic %s is not serialized with a schema registry schema", topic)
+		}
+		schemaID := int32(binary.BigEndian.Uint32(value[1:]))
+		ok, err := v.registry.isRegistered(schemaID, subject)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			proxySchemaValidationRejectedTotal.WithLabelValues(subject).Inc()
+			return fmt.Errorf("schema id %d of record produced to topic %s is not registered under subject %s", schemaID, topic, subject)
+		}
+		return nil
+	})
+}
+
+type schemaSubjects struct {
+	subjects map[string]struct{}
+	expires  time.Time
+}
+
+// schemaRegistryClient looks up the subjects of the schema ids, the results are cached
+type schemaRegistryClient struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+	cacheTTL   time.Duration
+
+	lock  sync.Mutex
+	cache map[int32]*schemaSubjects
+}
+
+func (c *schemaRegistryClient) isRegistered(schemaID int32, subject string) (bool, error) {
+	subjects, err := c.getSubjects(schemaID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := subjects[subject]
+	return ok, nil
+}
+
+func (c *schemaRegistryClient) getSubjects(schemaID int32) (map[string]struct{}, error) {
+	now := time.Now()
+	c.lock.Lock()
+	cached, ok := c.cache[schemaID]
+	c.lock.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.subjects, nil
+	}
+	subjects, err := c.fetchSubjects(schemaID)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.cache[schemaID] = &schemaSubjects{subjects: subjects, expires: now.Add(c.cacheTTL)}
+	c.lock.Unlock()
+	return subjects, nil
+}
+
+// fetchSubjects returns the subjects under which the schema id is registered, empty if the schema id is unknown
+func (c *schemaRegistryClient) fetchSubjects(schemaID int32) (map[string]struct{}, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d/versions", c.url, schemaID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		proxySchemaRegistryErrorsTotal.Inc()
+		return nil, errors.Wrap(err, "schema registry request failed")
+	}
+	defer resp.Body.Close()
+
+	subjects := make(map[string]struct{})
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		io.Copy(ioutil.Discard, resp.Body)
+		return subjects, nil
+	default:
+		io.Copy(ioutil.Discard, resp.Body)
+		proxySchemaRegistryErrorsTotal.Inc()
+		return nil, errors.Errorf("schema registry returned status %d for schema id %d", resp.StatusCode, schemaID)
+	}
+	var versions []struct {
+		Subject string `json:"subject"`
+		Version int    `json:"version"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		proxySchemaRegistryErrorsTotal.Inc()
+		return nil, errors.Wrap(err, "invalid schema registry response")
+	}
+	for _, v := range versions {
+		subjects[v.Subject] = struct{}{}
+	}
+	return subjects, nil
+}
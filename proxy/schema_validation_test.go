@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSchemaRegistry(lookups *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(lookups, 1)
+		switch r.URL.Path {
+		case "/schemas/ids/1/versions":
+			w.Write([]byte(`[{"subject":"orders-value","version":1}]`))
+		case "/schemas/ids/2/versions":
+			w.Write([]byte(`[{"subject":"payments-value","version":3}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		}
+	}))
+}
+
+func TestSchemaValidatorValidatesRecordValues(t *testing.T) {
+	a := assert.New(t)
+
+	var lookups int32
+	registry := newTestSchemaRegistry(&lookups)
+	defer registry.Close()
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.SchemaValidation.Topics = []string{"^orders$", "^legacy-.*=payments-value"}
+	c.SchemaValidation.Registry.URL = registry.URL
+	validator, err := newSchemaValidator(c)
+	a.Nil(err)
+
+	a.Nil(validator.validate(3, kafkatest.ProduceRequestBody("orders", [][]byte{{0, 0, 0, 0, 1, 42}, nil})))
+	a.Nil(validator.validate(3, kafkatest.ProduceRequestBody("orders", [][]byte{{0, 0, 0, 0, 1, 43}})))
+	a.Equal(int32(1), atomic.LoadInt32(&lookups))
+
+	a.Nil(validator.validate(3, kafkatest.ProduceRequestBody("legacy-payments", [][]byte{{0, 0, 0, 0, 2}})))
+	a.Nil(validator.validate(3, kafkatest.ProduceRequestBody("other", [][]byte{[]byte("plain")})))
+
+	err = validator.validate(3, kafkatest.ProduceRequestBody("orders", [][]byte{{0, 0, 0, 0, 2, 42}}))
+	a.EqualError(err, "schema id 2 of record produced to topic orders is not registered under subject orders-value")
+	err = validator.validate(3, kafkatest.ProduceRequestBody("orders", [][]byte{{0, 0, 0, 0, 7, 42}}))
+	a.EqualError(err, "schema id 7 of record produced to topic orders is not registered under subject orders-value")
+	err = validator.validate(3, kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("plain")}))
+	a.EqualError(err, "record produced to topic orders is not serialized with a schema registry schema")
+}
+
+func TestSchemaValidatorForbidsNewerProduceVersions(t *testing.T) {
+	a := assert.New(t)
+
+	validator := &schemaValidator{}
+	a.False(validator.isForbidden(kafkatest.ApiKeyProduce, 8))
+	a.True(validator.isForbidden(kafkatest.ApiKeyProduce, 9))
+	a.False(validator.isForbidden(kafkatest.ApiKeyFetch, 12))
+
+	var disabled *schemaValidator
+	a.False(disabled.isForbidden(kafkatest.ApiKeyProduce, 9))
+}
+
+func TestProxyRejectsProduceNotConformingToSchema(t *testing.T) {
+	a := assert.New(t)
+
+	var lookups int32
+	registry := newTestSchemaRegistry(&lookups)
+	defer registry.Close()
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{Topics: map[string]int32{"orders": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.SchemaValidation.Topics = []string{"^orders$"}
+	c.SchemaValidation.Registry.URL = registry.URL
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "app-1", kafkatest.ProduceRequestBody("orders", [][]byte{{0, 0, 0, 0, 1, 42}})))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 2, "app-1", kafkatest.ProduceRequestBody("orders", [][]byte{[]byte("plain")})))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
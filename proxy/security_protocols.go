@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+type securityProtocol struct {
+	tls  bool
+	sasl bool
+}
+
+// securityProtocols overrides the TLS and SASL settings for the connections to some brokers,
+// e.g. when the listeners of a cluster are migrated to another security protocol
+type securityProtocols struct {
+	byAddress map[string]securityProtocol
+	// brokers with the address *:port
+	byPort map[string]securityProtocol
+}
+
+// newSecurityProtocols returns nil if all brokers use the TLS and SASL settings
+func newSecurityProtocols(c *config.Config) (*securityProtocols, error) {
+	if len(c.Kafka.SecurityProtocols) == 0 {
+		return nil, nil
+	}
+	protocols := &securityProtocols{byAddress: make(map[string]securityProtocol), byPort: make(map[string]securityProtocol)}
+	for _, v := range c.Kafka.SecurityProtocols {
+		brokerAddress, tls, sasl, err := config.ParseSecurityProtocol(v)
+		if err != nil {
+			return nil, err
+		}
+		host, port, _ := net.SplitHostPort(brokerAddress)
+		if host == "*" {
+			protocols.byPort[port] = securityProtocol{tls: tls, sasl: sasl}
+		} else {
+			protocols.byAddress[brokerAddress] = securityProtocol{tls: tls, sasl: sasl}
+		}
+	}
+	logrus.Infof("Security protocols of the brokers %v override the TLS and SASL settings", c.Kafka.SecurityProtocols)
+	return protocols, nil
+}
+
+// lookup returns the protocol of the broker, false if the broker uses the TLS and SASL settings
+func (s *securityProtocols) lookup(brokerAddress string) (securityProtocol, bool) {
+	if s == nil {
+		return securityProtocol{}, false
+	}
+	if protocol, ok := s.byAddress[brokerAddress]; ok {
+		return protocol, true
+	}
+	if _, port, err := net.SplitHostPort(brokerAddress); err == nil {
+		if protocol, ok := s.byPort[port]; ok {
+			return protocol, true
+		}
+	}
+	return securityProtocol{}, false
+}
+
+// sasl reports whether the connections to the broker are authenticated with SASL
+func (s *securityProtocols) sasl(brokerAddress string, enabled bool) bool {
+	if protocol, ok := s.lookup(brokerAddress); ok {
+		return protocol.sasl
+	}
+	return enabled
+}
+
+// securityProtocolDialer negotiates TLS with the brokers using TLS
+type securityProtocolDialer struct {
+	protocols  *securityProtocols
+	tlsEnabled bool
+	rawDialer  Dialer
+	tlsDialer  tlsDialer
+}
+
+func (d securityProtocolDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d securityProtocolDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	useTLS := d.tlsEnabled
+	if protocol, ok := d.protocols.lookup(addr); ok {
+		useTLS = protocol.tls
+	}
+	if useTLS {
+		return d.tlsDialer.DialContext(ctx, network, addr)
+	}
+	return d.rawDialer.DialContext(ctx, network, addr)
+}
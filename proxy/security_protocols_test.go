@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestSecurityProtocolsLookup(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	protocols, err := newSecurityProtocols(c)
+	a.Nil(err)
+	a.Nil(protocols)
+	a.True(protocols.sasl("kafka-1:9092", true))
+
+	c.Kafka.SecurityProtocols = []string{"kafka-1:9092=SASL_PLAINTEXT", "*:9093=SSL"}
+	protocols, err = newSecurityProtocols(c)
+	a.Nil(err)
+	protocol, ok := protocols.lookup("kafka-1:9092")
+	a.True(ok)
+	a.Equal(securityProtocol{sasl: true}, protocol)
+	protocol, ok = protocols.lookup("kafka-2:9093")
+	a.True(ok)
+	a.Equal(securityProtocol{tls: true}, protocol)
+	_, ok = protocols.lookup("kafka-2:9092")
+	a.False(ok)
+	a.True(protocols.sasl("kafka-1:9092", false))
+	a.False(protocols.sasl("kafka-2:9093", true))
+	a.True(protocols.sasl("kafka-2:9092", true))
+}
+
+// countingDialer counts the dials and fails them
+type countingDialer struct {
+	dials *int
+}
+
+func (d countingDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	*d.dials++
+	return nil, &net.OpError{Op: "dial", Net: network, Err: context.Canceled}
+}
+
+func TestSecurityProtocolDialerNegotiatesTLSByBroker(t *testing.T) {
+	a := assert.New(t)
+
+	var rawDials, tlsDials int
+	c := config.NewConfig()
+	c.Kafka.SecurityProtocols = []string{"*:9093=SASL_SSL"}
+	dialer, err := newTLSDialerIfEnabled(c, nil, countingDialer{dials: &rawDials})
+	a.EqualError(err, "tlsConfig must not be nil")
+
+	protocols, err := newSecurityProtocols(c)
+	a.Nil(err)
+	dialer = securityProtocolDialer{
+		protocols: protocols,
+		rawDialer: countingDialer{dials: &rawDials},
+		tlsDialer: tlsDialer{rawDialer: countingDialer{dials: &tlsDials}, config: &tls.Config{}},
+	}
+	_, err = dialer.Dial("tcp", "kafka-1:9093")
+	a.NotNil(err)
+	a.Equal(0, rawDials)
+	a.Equal(1, tlsDials)
+	_, err = dialer.Dial("tcp", "kafka-1:9092")
+	a.NotNil(err)
+	a.Equal(1, rawDials)
+	a.Equal(1, tlsDials)
+}
+
+func TestProxyAuthenticatesBySecurityProtocol(t *testing.T) {
+	a := assert.New(t)
+
+	sasl, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Users: map[string]string{"alice": "secret"}})
+	a.Nil(err)
+	defer sasl.Close()
+	plaintext, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 2})
+	a.Nil(err)
+	defer plaintext.Close()
+
+	for _, tt := range []struct {
+		broker   *kafkatest.Broker
+		enable   bool
+		protocol string
+	}{
+		{broker: sasl, enable: false, protocol: config.SecurityProtocolSASLPlaintext},
+		{broker: plaintext, enable: true, protocol: config.SecurityProtocolPlaintext},
+	} {
+		c := newTestProxyConfig(tt.broker.Addr())
+		c.Kafka.SASL.Enable = tt.enable
+		c.Kafka.SASL.Username = "alice"
+		c.Kafka.SASL.Password = "secret"
+		c.Kafka.SecurityProtocols = []string{tt.broker.Addr() + "=" + tt.protocol}
+		listenerAddress, stop := startTestProxy(a, c)
+
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 4, 7, "test", []byte("fetch")))
+		a.Nil(err)
+		_, body, err := kafkatest.ReadResponse(conn)
+		a.Nil(err, tt.protocol)
+		a.Equal([]byte("fetch"), body)
+		conn.Close()
+		stop()
+	}
+	a.Equal(1, sasl.RequestCount(kafkatest.ApiKeySaslHandshake))
+	a.Equal(0, plaintext.RequestCount(kafkatest.ApiKeySaslHandshake))
+}
@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Proxy runs kafka-proxy in-process. It wires the listeners and the client in the same way as the server command,
+// so applications can embed the proxy as a library and control its lifecycle with a context.
+type Proxy struct {
+	listeners *Listeners
+	client    *Client
+	connSrc   <-chan Conn
+	// nil if the upstream metadata is not refreshed in the background
+	topology *topologyRefresher
+	// nil if the forward proxy is not probed
+	forwardProxyProbe *forwardProxyProbe
+	// nil if the brokers are not reached through the tunnel agents
+	tunnelRelay *tunnelRelay
+	// nil if the load is not limited
+	loadShedder *loadShedder
+
+	drainOnce sync.Once
+	closeOnce sync.Once
+}
+
+type options struct {
+	connSet                    *ConnSet
+	dialer                     Dialer
+	localPasswordAuthenticator apis.PasswordAuthenticator
+	localTokenAuthenticator    apis.TokenInfo
+	localScramCredentialStore  apis.ScramCredentialStore
+	saslTokenProvider          apis.TokenProvider
+	gatewayTokenProvider       apis.TokenProvider
+	gatewayTokenInfo           apis.TokenInfo
+	recordTransformer          apis.RecordTransformer
+	faultInjector              *FaultInjector
+	maintenance                *Maintenance
+	upstreamSwitch             *UpstreamSwitch
+	revocations                *Revocations
+	topTalkers                 *TopTalkers
+	saslPassword               func() string
+	sessionTicketKeys          func() string
+	certificateVerifier        apis.CertificateVerifier
+	keySigner                  apis.KeySigner
+	telemetryExporter          *OTLPExporter
+	slo                        *SLO
+}
+
+// Option configures a Proxy created by New
+type Option func(*options)
+
+// WithConnSet sets the set used to track the active connections e.g. to expose it with NewCollector
+func WithConnSet(connSet *ConnSet) Option {
+	return func(o *options) {
+		o.connSet = connSet
+	}
+}
+
+// WithDialer replaces the dialer used to connect to the Kafka brokers.
+// If Kafka.TLS.Enable is set or the security protocol of the broker uses TLS, TLS is still negotiated on top of the connections returned by the dialer.
+func WithDialer(dialer Dialer) Option {
+	return func(o *options) {
+		o.dialer = dialer
+	}
+}
+
+// WithLocalPasswordAuthenticator sets the authenticator used for the local SASL PLAIN authentication
+func WithLocalPasswordAuthenticator(authenticator apis.PasswordAuthenticator) Option {
+	return func(o *options) {
+		o.localPasswordAuthenticator = authenticator
+	}
+}
+
+// WithLocalTokenAuthenticator sets the token info used for the local SASL OAUTHBEARER authentication
+func WithLocalTokenAuthenticator(authenticator apis.TokenInfo) Option {
+	return func(o *options) {
+		o.localTokenAuthenticator = authenticator
+	}
+}
+
+// WithLocalScramCredentialStore sets the credential store used for the local SASL SCRAM-SHA-256 and SCRAM-SHA-512 authentication.
+// Auth.Local.ScramCredentialsFile takes precedence over the store.
+func WithLocalScramCredentialStore(store apis.ScramCredentialStore) Option {
+	return func(o *options) {
+		o.localScramCredentialStore = store
+	}
+}
+
+// WithSASLTokenProvider sets the token provider used for the SASL OAUTHBEARER authentication to the Kafka brokers
+func WithSASLTokenProvider(provider apis.TokenProvider) Option {
+	return func(o *options) {
+		o.saslTokenProvider = provider
+	}
+}
+
+// WithGatewayTokenProvider sets the token provider used by the gateway client
+func WithGatewayTokenProvider(provider apis.TokenProvider) Option {
+	return func(o *options) {
+		o.gatewayTokenProvider = provider
+	}
+}
+
+// WithGatewayTokenInfo sets the token info used by the gateway server
+func WithGatewayTokenInfo(tokenInfo apis.TokenInfo) Option {
+	return func(o *options) {
+		o.gatewayTokenInfo = tokenInfo
+	}
+}
+
+// WithRecordTransformer adds the transformer of the produced and fetched record values e.g. to encrypt the values with keys managed by a KMS.
+// It is applied after the redaction and encryption of the configured record fields.
+func WithRecordTransformer(transformer apis.RecordTransformer) Option {
+	return func(o *options) {
+		o.recordTransformer = transformer
+	}
+}
+
+// WithFaultInjector sets the fault injector e.g. to change the injected faults at runtime.
+// It replaces the fault injector created from the configuration.
+func WithFaultInjector(faultInjector *FaultInjector) Option {
+	return func(o *options) {
+		o.faultInjector = faultInjector
+	}
+}
+
+// WithMaintenance sets the maintenance e.g. to change the maintenance rules of all proxies at runtime.
+// It replaces the maintenance created from the configuration.
+func WithMaintenance(maintenance *Maintenance) Option {
+	return func(o *options) {
+		o.maintenance = maintenance
+	}
+}
+
+// WithUpstreamSwitch sets the upstream switch e.g. to switch the active cluster at runtime.
+// It replaces the upstream switch created from the configuration.
+func WithUpstreamSwitch(upstreamSwitch *UpstreamSwitch) Option {
+	return func(o *options) {
+		o.upstreamSwitch = upstreamSwitch
+	}
+}
+
+// WithSASLPassword sets the function returning the SASL PLAIN password of the upstream connections e.g. to use a periodically refreshed secret.
+// It replaces Kafka.SASL.Password.
+func WithSASLPassword(password func() string) Option {
+	return func(o *options) {
+		o.saslPassword = password
+	}
+}
+
+// WithRevocations sets the revocations e.g. to revoke the principals and tokens of all proxies at runtime.
+// It replaces the revocations created from the configuration.
+func WithRevocations(revocations *Revocations) Option {
+	return func(o *options) {
+		o.revocations = revocations
+	}
+}
+
+// WithTopTalkers sets the top talkers e.g. to report the connections of all proxies with the HTTP admin API.
+// It replaces the top talkers created from the configuration.
+func WithTopTalkers(topTalkers *TopTalkers) Option {
+	return func(o *options) {
+		o.topTalkers = topTalkers
+	}
+}
+
+// WithSLO sets the SLIs e.g. to compute them over the requests of all proxies and report them with the HTTP admin API.
+// It replaces the SLIs created from the configuration.
+func WithSLO(slo *SLO) Option {
+	return func(o *options) {
+		o.slo = slo
+	}
+}
+
+// WithSessionTicketKeys sets the function returning the session ticket keys shared by the replicas e.g. to use a periodically refreshed secret.
+// The keys are read on every rotation, it replaces Proxy.TLS.ListenerSessionTicketKeys.
+func WithSessionTicketKeys(keys func() string) Option {
+	return func(o *options) {
+		o.sessionTicketKeys = keys
+	}
+}
+
+// WithCertificateVerifier sets the verifier of the peer certificates e.g. to check custom extensions or to query an internal revocation service.
+// The client certificates of the listeners and the broker certificates are verified if CertVerifier.Listener and CertVerifier.Broker are set.
+func WithCertificateVerifier(verifier apis.CertificateVerifier) Option {
+	return func(o *options) {
+		o.certificateVerifier = verifier
+	}
+}
+
+// WithKeySigner sets the signer of the private key kept in a PKCS#11 token, HSM or cloud KMS. It is the private key
+// of the listener certificates without key file if KeySigner.Enable is set.
+func WithKeySigner(signer apis.KeySigner) Option {
+	return func(o *options) {
+		o.keySigner = signer
+	}
+}
+
+// WithTelemetryExporter sets the exporter of the client telemetry terminated at the proxy e.g. to share the OTLP connection of the proxy metrics.
+// It is used only if Telemetry.Mode is terminate.
+func WithTelemetryExporter(exporter *OTLPExporter) Option {
+	return func(o *options) {
+		o.telemetryExporter = exporter
+	}
+}
+
+// newClient creates the client of New, replaced by the tests
+var newClient = NewClient
+
+// New validates the configuration and starts listening on the bootstrap server addresses.
+// Connections are not accepted until Run is called.
+func New(c *config.Config, opts ...Option) (*Proxy, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.connSet == nil {
+		o.connSet = NewConnSet()
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.FIPS.RequireBoringCrypto && !BoringCrypto {
+		return nil, errors.New("FIPS mode requires a binary built with BoringCrypto")
+	}
+	if o.recordTransformer != nil && c.OutageBuffer.Dir != "" {
+		// the buffered requests are replayed as sent by the clients
+		return nil, errors.New("record transformer cannot be used with OutageBuffer.Dir")
+	}
+	listeners, err := NewListeners(c)
+	if err != nil {
+		return nil, err
+	}
+	certVerifier := newCertVerifier(c, o.certificateVerifier)
+	listeners.setCertVerifier(certVerifier)
+	keySigner, err := newKeySigner(c, o.keySigner)
+	if err == nil {
+		err = listeners.setKeySigner(keySigner)
+	}
+	if err != nil {
+		listeners.Close()
+		return nil, err
+	}
+	loadShedder := newLoadShedder(c, o.connSet)
+	listeners.loadShedder = loadShedder
+	connSrc, err := listeners.ListenInstances(c.Proxy.BootstrapServers)
+	if err != nil {
+		listeners.Close()
+		return nil, err
+	}
+	if err = listeners.ListenSocks5(c); err != nil {
+		listeners.Close()
+		return nil, err
+	}
+	client, err := newClient(o.connSet, c, listeners.GetNetAddressMapping, o.localPasswordAuthenticator, o.localTokenAuthenticator, o.localScramCredentialStore, o.saslTokenProvider, o.gatewayTokenProvider, o.gatewayTokenInfo)
+	if err != nil {
+		listeners.Close()
+		return nil, err
+	}
+	client.loadShedder = loadShedder
+	if o.faultInjector != nil {
+		client.processorConfig.FaultInjector = o.faultInjector
+	}
+	if o.maintenance != nil {
+		client.processorConfig.Maintenance = o.maintenance
+	}
+	if o.upstreamSwitch != nil {
+		client.upstream = o.upstreamSwitch
+	}
+	if o.revocations != nil {
+		client.processorConfig.LocalSasl.revocations = o.revocations
+	}
+	if o.topTalkers != nil {
+		client.processorConfig.TopTalkers = o.topTalkers
+	}
+	if o.slo != nil {
+		client.processorConfig.SLO = o.slo
+	}
+	if o.telemetryExporter != nil && client.processorConfig.Telemetry != nil {
+		client.processorConfig.Telemetry.exporter = o.telemetryExporter
+	}
+	if plain, ok := client.saslAuthByProxy.(*SASLPlainAuth); ok && o.saslPassword != nil {
+		plain.passwordFunc = o.saslPassword
+	}
+	if o.sessionTicketKeys != nil {
+		listeners.sessionTickets.setKeysFunc(o.sessionTicketKeys)
+	}
+	if o.recordTransformer != nil {
+		client.processorConfig.RecordTransform = client.processorConfig.RecordTransform.append(c.Compression.MaxDecompressedSize, o.recordTransformer)
+	}
+	tunnelRelay, err := newTunnelRelay(c)
+	if err != nil {
+		listeners.Close()
+		client.Close()
+		return nil, err
+	}
+	rawDialer := o.dialer
+	if rawDialer == nil && tunnelRelay != nil {
+		rawDialer = tunnelRelay
+	}
+	if rawDialer != nil {
+		tlsConfig, err := newTLSClientConfig(c)
+		if err != nil {
+			listeners.Close()
+			tunnelRelay.Close()
+			client.Close()
+			return nil, err
+		}
+		if client.dialer, err = newTLSDialerIfEnabled(c, tlsConfig, rawDialer); err != nil {
+			listeners.Close()
+			tunnelRelay.Close()
+			client.Close()
+			return nil, err
+		}
+	}
+	switch dialer := client.dialer.(type) {
+	case tlsDialer:
+		dialer.config = certVerifier.applyBroker(dialer.config)
+		client.dialer = dialer
+	case securityProtocolDialer:
+		dialer.tlsDialer.config = certVerifier.applyBroker(dialer.tlsDialer.config)
+		client.dialer = dialer
+	}
+	forwardProxyProbe, err := newForwardProxyProbe(c)
+	if err != nil {
+		listeners.Close()
+		tunnelRelay.Close()
+		client.Close()
+		return nil, err
+	}
+	return &Proxy{listeners: listeners, client: client, connSrc: connSrc, topology: newTopologyRefresher(c, client, listeners), forwardProxyProbe: forwardProxyProbe, tunnelRelay: tunnelRelay, loadShedder: loadShedder}, nil
+}
+
+// Run proxies the accepted connections until the context is done or Close is called, the done context shuts down the proxy with Shutdown.
+// On return the listeners and all proxied connections are closed.
+func (p *Proxy) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Shutdown()
+		case <-done:
+		}
+	}()
+	if p.topology != nil {
+		go withRecover(func() { p.topology.run(p.client.ctx.Done()) })
+	}
+	go withRecover(func() { p.listeners.sessionTickets.run(p.client.ctx.Done()) })
+	go withRecover(func() { p.listeners.acme.run(p.client.ctx.Done()) })
+	go withRecover(func() { p.listeners.clientCertFingerprints.run(p.client.ctx.Done()) })
+	go withRecover(func() { p.forwardProxyProbe.run(p.client.ctx.Done()) })
+	go withRecover(func() { p.loadShedder.run(p.client.ctx.Done()) })
+	go withRecover(p.tunnelRelay.run)
+	err := p.client.Run(p.connSrc)
+	p.listeners.Close()
+	p.tunnelRelay.Close()
+	return err
+}
+
+// Shutdown stops accepting new connections and makes Run return. If Shutdown.Timeout is set, the connections are closed in batches
+// between their requests until the timeout elapses, otherwise they are closed at once.
+func (p *Proxy) Shutdown() {
+	if shutdown := p.client.processorConfig.Shutdown; shutdown != nil {
+		p.drainOnce.Do(func() {
+			logrus.Info("Shutting down proxy")
+			p.listeners.Close()
+			shutdown.drain()
+		})
+	}
+	p.Close()
+}
+
+// Pause rejects new connections until Resume is called, e.g. when the service is paused by the service manager.
+// Proxied connections are kept.
+func (p *Proxy) Pause() {
+	p.listeners.Pause()
+}
+
+// Resume accepts new connections again after Pause
+func (p *Proxy) Resume() {
+	p.listeners.Resume()
+}
+
+// Close stops accepting new connections and makes Run return
+func (p *Proxy) Close() {
+	p.closeOnce.Do(func() {
+		logrus.Info("Stopping proxy")
+		p.listeners.Close()
+		p.tunnelRelay.Close()
+		p.client.Close()
+	})
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyRunStopsWhenContextIsCancelled(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.BootstrapServers[0].ListenerAddress = "127.0.0.1:0"
+	p, err := New(c)
+	a.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- p.Run(ctx) }()
+	cancel()
+
+	select {
+	case err = <-result:
+		a.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop")
+	}
+}
+
+func TestProxyNewClosesClientOnError(t *testing.T) {
+	a := assert.New(t)
+
+	var client *Client
+	defer func() { newClient = NewClient }()
+	newClient = func(conns *ConnSet, c *config.Config, netAddressMappingFunc config.NetAddressMappingFunc, localPasswordAuthenticator apis.PasswordAuthenticator, localTokenAuthenticator apis.TokenInfo, localScramCredentialStore apis.ScramCredentialStore, saslTokenProvider apis.TokenProvider, gatewayTokenProvider apis.TokenProvider, gatewayTokenInfo apis.TokenInfo) (*Client, error) {
+		var err error
+		client, err = NewClient(conns, c, netAddressMappingFunc, localPasswordAuthenticator, localTokenAuthenticator, localScramCredentialStore, saslTokenProvider, gatewayTokenProvider, gatewayTokenInfo)
+		return client, err
+	}
+
+	// the tunnel relay cannot listen on the address in use
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer occupied.Close()
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Proxy.BootstrapServers[0].ListenerAddress = "127.0.0.1:0"
+	c.Tunnel.Token = "secret"
+	c.Tunnel.Relay.ListenAddress = occupied.Addr().String()
+	p, err := New(c)
+	a.NotNil(err)
+	a.Nil(p)
+	a.NotNil(client)
+	a.NotNil(client.ctx.Err())
+}
+
+func TestProxyUsesCustomDialer(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	dialed := make(chan string, 1)
+	dialer := dialerFunc(func(network, addr string) (net.Conn, error) {
+		dialed <- addr
+		return net.Dial(network, addr)
+	})
+	c := newTestProxyConfig(broker.Addr())
+	listenerAddress, stop := startTestProxy(a, c, WithDialer(dialer))
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", []byte{1, 2, 3}))
+	a.Nil(err)
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal([]byte{1, 2, 3}, body)
+	a.Equal(broker.Addr(), <-dialed)
+}
+
+type dialerFunc func(network, addr string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+func (f dialerFunc) DialContext(_ context.Context, network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+func TestProxyShutdownDrainsConnections(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.BootstrapServers[0].ListenerAddress = "127.0.0.1:0"
+	c.Shutdown.Timeout = 5 * time.Second
+	c.Shutdown.CloseInterval = 10 * time.Millisecond
+	p, err := New(c)
+	a.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- p.Run(ctx) }()
+
+	conn, err := net.Dial("tcp", p.listeners.listeners[0].Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "test", []byte{1, 2, 3}))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+
+	// the idle connection is closed without waiting for the timeout
+	cancel()
+	select {
+	case err = <-result:
+		a.Nil(err)
+	case <-time.After(time.Second):
+		t.Fatal("connections were not drained")
+	}
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// principalConnections counts the connections of the principals which number of connections is limited by the session attributes
+type principalConnections struct {
+	lock   sync.Mutex
+	counts map[string]int32
+}
+
+func newPrincipalConnections() *principalConnections {
+	return &principalConnections{counts: make(map[string]int32)}
+}
+
+// acquire reports whether the principal may open another connection
+func (c *principalConnections) acquire(principal string, maxConnections int32) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.counts[principal] >= maxConnections {
+		return false
+	}
+	c.counts[principal]++
+	return true
+}
+
+func (c *principalConnections) release(principal string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.counts[principal] <= 1 {
+		delete(c.counts, principal)
+		return
+	}
+	c.counts[principal]--
+}
+
+// sessionLimits enforces the session attributes returned by the local authentication for the connection of the principal
+type sessionLimits struct {
+	connections   *principalConnections
+	principal     string
+	acquired      bool
+	requests      *rateLimiter
+	requestBytes  *rateLimiter
+	allowedTopics []*regexp.Regexp
+}
+
+// newSessionLimits returns nil if the attributes do not limit the session.
+// An error is returned if the principal has too many connections.
+func newSessionLimits(connections *principalConnections, principal string, attributes *apis.SessionAttributes) (*sessionLimits, error) {
+	if attributes == nil || (attributes.MaxConnections <= 0 && attributes.RequestsPerSecond <= 0 && attributes.RequestBytesPerSecond <= 0 && len(attributes.AllowedTopics) == 0) {
+		return nil, nil
+	}
+	// one second of the rate is the burst
+	s := &sessionLimits{
+		connections:  connections,
+		principal:    principal,
+		requests:     newRateLimiter(attributes.RequestsPerSecond, int(attributes.RequestsPerSecond)),
+		requestBytes: newRateLimiter(attributes.RequestBytesPerSecond, int(attributes.RequestBytesPerSecond)),
+	}
+	for _, v := range attributes.AllowedTopics {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("allowed topic %q of principal %q is invalid: %v", v, principal, err)
+		}
+		s.allowedTopics = append(s.allowedTopics, pattern)
+	}
+	if attributes.MaxConnections > 0 {
+		if !connections.acquire(principal, attributes.MaxConnections) {
+			proxySessionLimitsRejectedTotal.WithLabelValues("connections").Inc()
+			return nil, fmt.Errorf("principal %q has already %d connections", principal, attributes.MaxConnections)
+		}
+		s.acquired = true
+	}
+	return s, nil
+}
+
+// close releases the connection of the principal
+func (s *sessionLimits) close() {
+	if s == nil || !s.acquired {
+		return
+	}
+	s.acquired = false
+	s.connections.release(s.principal)
+}
+
+// take consumes the request and returns how long the request has to be delayed
+func (s *sessionLimits) take(length int32) time.Duration {
+	if s == nil {
+		return 0
+	}
+	delay := s.requests.take()
+	if bytesDelay := s.requestBytes.takeN(float64(length)); bytesDelay > delay {
+		delay = bytesDelay
+	}
+	if delay > 0 {
+		proxySessionLimitsThrottledSecondsTotal.Add(delay.Seconds())
+	}
+	return delay
+}
+
+// inspects reports whether the topics of the request must be checked
+func (s *sessionLimits) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if s == nil || len(s.allowedTopics) == 0 {
+		return false
+	}
+	_, ok := protocol.TopicNamesMaxVersion(requestKeyVersion.ApiKey)
+	return ok
+}
+
+// check returns an error if the request carries a topic which the principal may not access
+func (s *sessionLimits) check(requestKeyVersion *protocol.RequestKeyVersion, body []byte) error {
+	if maxVersion, _ := protocol.TopicNamesMaxVersion(requestKeyVersion.ApiKey); requestKeyVersion.ApiVersion > maxVersion {
+		proxySessionLimitsRejectedTotal.WithLabelValues("version").Inc()
+		return fmt.Errorf("topics of api key %d version %d cannot be checked", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
+	}
+	topics, err := protocol.DecodeRequestTopicNames(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, body)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if !s.allows(topic) {
+			proxySessionLimitsRejectedTotal.WithLabelValues("topic").Inc()
+			return fmt.Errorf("topic %q is not allowed for principal %q", topic, s.principal)
+		}
+	}
+	return nil
+}
+
+func (s *sessionLimits) allows(topic string) bool {
+	for _, pattern := range s.allowedTopics {
+		if pattern.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
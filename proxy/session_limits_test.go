@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type testAttributesAuthenticator struct {
+	testPasswordAuthenticator
+	attributes *apis.SessionAttributes
+}
+
+func (p testAttributesAuthenticator) AuthenticateWithAttributes(username, password string) (bool, int32, *apis.SessionAttributes, error) {
+	ok, status, err := p.Authenticate(username, password)
+	return ok, status, p.attributes, err
+}
+
+// fetchRequestV7 returns the body of the fetch request of the topic partition 0
+func fetchRequestV7(topic string) []byte {
+	buf := new(bytes.Buffer)
+	for _, v := range []interface{}{int32(-1), int32(500), int32(1), int32(1024), int8(0), int32(0), int32(-1), int32(1), int16(len(topic))} {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+	buf.WriteString(topic)
+	// partition 0, fetch offset, log start offset, max bytes and no forgotten topics
+	for _, v := range []interface{}{int32(1), int32(0), int64(0), int64(0), int32(1024), int32(0)} {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func TestPrincipalConnections(t *testing.T) {
+	a := assert.New(t)
+
+	connections := newPrincipalConnections()
+	a.True(connections.acquire("alice", 2))
+	a.True(connections.acquire("alice", 2))
+	a.False(connections.acquire("alice", 2))
+	a.True(connections.acquire("bob", 1))
+	connections.release("alice")
+	a.True(connections.acquire("alice", 2))
+	connections.release("alice")
+	connections.release("alice")
+	connections.release("bob")
+	a.Empty(connections.counts)
+}
+
+func TestNewSessionLimits(t *testing.T) {
+	a := assert.New(t)
+
+	connections := newPrincipalConnections()
+	s, err := newSessionLimits(connections, "alice", nil)
+	a.Nil(err)
+	a.Nil(s)
+	s, err = newSessionLimits(connections, "alice", &apis.SessionAttributes{})
+	a.Nil(err)
+	a.Nil(s)
+
+	_, err = newSessionLimits(connections, "alice", &apis.SessionAttributes{AllowedTopics: []string{"("}})
+	a.NotNil(err)
+
+	s, err = newSessionLimits(connections, "alice", &apis.SessionAttributes{MaxConnections: 1, AllowedTopics: []string{"^orders$"}})
+	a.Nil(err)
+	_, err = newSessionLimits(connections, "alice", &apis.SessionAttributes{MaxConnections: 1})
+	a.EqualError(err, `principal "alice" has already 1 connections`)
+
+	fetch := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 7}
+	a.True(s.inspects(fetch))
+	a.False(s.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions}))
+	a.Nil(s.check(fetch, fetchRequestV7("orders")))
+	a.EqualError(s.check(fetch, fetchRequestV7("payments")), `topic "payments" is not allowed for principal "alice"`)
+	a.EqualError(s.check(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 100}, nil), "topics of api key 1 version 100 cannot be checked")
+
+	s.close()
+	s.close()
+	a.Empty(connections.counts)
+}
+
+func TestSessionLimitsTake(t *testing.T) {
+	a := assert.New(t)
+
+	s, err := newSessionLimits(newPrincipalConnections(), "alice", &apis.SessionAttributes{RequestsPerSecond: 1, RequestBytesPerSecond: 100})
+	a.Nil(err)
+	now := time.Now()
+	s.requests.now = func() time.Time { return now }
+	s.requestBytes.now = func() time.Time { return now }
+
+	a.Equal(time.Duration(0), s.take(50))
+	// the second request in the same second
+	a.Equal(time.Second, s.take(50))
+	// 200 bytes are above the burst of 100 bytes
+	a.True(s.take(200) > time.Second)
+}
+
+func TestProxyLocalSaslEnforcesSessionAttributes(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "test"
+	c.Auth.Local.Timeout = time.Second
+	c.Auth.Local.Mechanisms = []string{SASLPlain}
+	authenticator := testAttributesAuthenticator{
+		testPasswordAuthenticator: testPasswordAuthenticator{"bob": "bob-secret"},
+		attributes:                &apis.SessionAttributes{MaxConnections: 1, AllowedTopics: []string{"^orders$"}},
+	}
+	listenerAddress, stop := startTestProxy(a, c, WithLocalPasswordAuthenticator(authenticator))
+	defer stop()
+
+	login := func() net.Conn {
+		conn, err := net.Dial("tcp", listenerAddress)
+		a.Nil(err)
+		writeSaslRequest(a, conn, 1, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+		_, _, err = kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		res := saslAuthenticate(a, conn, 2, []byte("\x00bob\x00bob-secret"))
+		a.Equal(protocol.ErrNoError, res.Err)
+		return conn
+	}
+
+	conn := login()
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 7, 3, "test", fetchRequestV7("orders")))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(3), correlationID)
+	a.Equal(fetchRequestV7("orders"), body)
+
+	// the second connection of bob is closed
+	second := login()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 7, 3, "test", fetchRequestV7("orders")))
+	a.Nil(err)
+	_, _, err = kafkatest.ReadResponse(second)
+	a.NotNil(err)
+	second.Close()
+
+	// not allowed topic closes the connection
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyFetch, 7, 4, "test", fetchRequestV7("payments")))
+	a.Nil(err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = kafkatest.ReadResponse(conn)
+	a.NotNil(err)
+	conn.Close()
+}
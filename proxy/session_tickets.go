@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// random keys are kept for the tickets issued before the last rotations
+const maxGeneratedSessionTicketKeys = 3
+
+// sessionTicketKeys sets the session ticket keys of the listener TLS configurations. The replicas resume each others
+// sessions if they share the keys, otherwise a new random key is generated on every rotation.
+type sessionTicketKeys struct {
+	rotation time.Duration
+	// shared keys, nil if the keys are generated
+	keysFunc func() string
+
+	lock       sync.Mutex
+	configs    []*tls.Config
+	keys       [][32]byte
+	sharedKeys string
+}
+
+// newSessionTicketKeys returns nil if the keys are neither shared nor rotated
+func newSessionTicketKeys(cfg *config.Config) *sessionTicketKeys {
+	keys := cfg.Proxy.TLS.ListenerSessionTicketKeys
+	if keys == "" && cfg.Proxy.TLS.ListenerSessionTicketKeyRotation == 0 {
+		return nil
+	}
+	s := &sessionTicketKeys{rotation: cfg.Proxy.TLS.ListenerSessionTicketKeyRotation}
+	if keys != "" {
+		s.keysFunc = func() string { return keys }
+	}
+	return s
+}
+
+// add sets the current keys of the TLS configuration and the ones of the next rotations
+func (s *sessionTicketKeys) add(tlsConfig *tls.Config) {
+	if s == nil || tlsConfig == nil || tlsConfig.SessionTicketsDisabled {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.configs = append(s.configs, tlsConfig)
+	if len(s.keys) != 0 {
+		tlsConfig.SetSessionTicketKeys(s.keys)
+	}
+}
+
+// setKeysFunc replaces the source of the shared keys e.g. by a secret refresher
+func (s *sessionTicketKeys) setKeysFunc(keysFunc func() string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.keysFunc = keysFunc
+	s.lock.Unlock()
+}
+
+// rotate re-reads the shared keys or generates a new random key
+func (s *sessionTicketKeys) rotate() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.keysFunc != nil {
+		value := s.keysFunc()
+		if value == s.sharedKeys && len(s.keys) != 0 {
+			return nil
+		}
+		keys, err := parseSessionTicketKeys(value)
+		if err != nil {
+			return err
+		}
+		s.keys, s.sharedKeys = keys, value
+		logrus.Infof("Loaded %d shared session ticket keys", len(keys))
+	} else {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return errors.Wrap(err, "cannot generate session ticket key")
+		}
+		keys := append([][32]byte{key}, s.keys...)
+		if len(keys) > maxGeneratedSessionTicketKeys {
+			keys = keys[:maxGeneratedSessionTicketKeys]
+		}
+		s.keys = keys
+		logrus.Debug("Generated new session ticket key")
+	}
+	for _, tlsConfig := range s.configs {
+		tlsConfig.SetSessionTicketKeys(s.keys)
+	}
+	return nil
+}
+
+// run rotates the keys in the interval until done is closed
+func (s *sessionTicketKeys) run(done <-chan struct{}) {
+	if s == nil || s.rotation == 0 {
+		return
+	}
+	ticker := time.NewTicker(s.rotation)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.rotate(); err != nil {
+				logrus.Warnf("Rotation of session ticket keys failed, the previous keys are used: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// parseSessionTicketKeys parses the hex or base64 encoded 32 byte keys, one per line
+func parseSessionTicketKeys(value string) ([][32]byte, error) {
+	keys := make([][32]byte, 0)
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		data, err := hex.DecodeString(line)
+		if err != nil {
+			data, err = base64.StdEncoding.DecodeString(line)
+		}
+		if err != nil || len(data) != 32 {
+			return nil, errors.Errorf("session ticket key %d must be a hex or base64 encoded 32 byte key", len(keys)+1)
+		}
+		var key [32]byte
+		copy(key[:], data)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no session ticket keys found")
+	}
+	return keys, nil
+}
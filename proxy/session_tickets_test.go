@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testSessionTicketKeys = `
+# current key
+000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=
+`
+
+func TestParseSessionTicketKeys(t *testing.T) {
+	a := assert.New(t)
+
+	keys, err := parseSessionTicketKeys(testSessionTicketKeys)
+	a.Nil(err)
+	a.Len(keys, 2)
+	a.Equal(keys[0], keys[1])
+	a.Equal(byte(31), keys[0][31])
+
+	_, err = parseSessionTicketKeys("0001")
+	a.EqualError(err, "session ticket key 1 must be a hex or base64 encoded 32 byte key")
+	_, err = parseSessionTicketKeys("# no keys")
+	a.EqualError(err, "no session ticket keys found")
+}
+
+func TestGeneratedSessionTicketKeys(t *testing.T) {
+	a := assert.New(t)
+
+	c := new(config.Config)
+	a.Nil(newSessionTicketKeys(c))
+
+	c.Proxy.TLS.ListenerSessionTicketKeyRotation = 1
+	keys := newSessionTicketKeys(c)
+	a.NotNil(keys)
+	for i := 0; i < 5; i++ {
+		a.Nil(keys.rotate())
+	}
+	a.Len(keys.keys, maxGeneratedSessionTicketKeys)
+	a.NotEqual(keys.keys[0], keys.keys[1])
+}
+
+func TestSharedSessionTicketKeysResumeSessions(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.ListenerSessionTicketKeys = testSessionTicketKeys
+	c.Kafka.TLS.InsecureSkipVerify = true
+	c.Kafka.TLS.SessionCacheSize = 10
+
+	// two replicas sharing the keys
+	addrs := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		serverConfig, err := newTLSListenerConfig(c)
+		a.Nil(err)
+		keys := newSessionTicketKeys(c)
+		a.Nil(keys.rotate())
+		keys.add(serverConfig)
+
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		a.Nil(err)
+		defer ln.Close()
+		go acceptHandshakes(ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	a.NotNil(clientConfig.ClientSessionCache)
+	clientConfig.MaxVersion = tls.VersionTLS12
+
+	conn, err := tls.Dial("tcp", addrs[0], clientConfig)
+	a.Nil(err)
+	a.False(conn.ConnectionState().DidResume)
+	conn.Close()
+
+	conn, err = tls.Dial("tcp", addrs[1], clientConfig)
+	a.Nil(err)
+	a.True(conn.ConnectionState().DidResume)
+	conn.Close()
+}
+
+func TestDisabledSessionTickets(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.ListenerSessionTicketsDisabled = true
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	a.True(serverConfig.SessionTicketsDisabled)
+
+	// the keys are not set on the configurations without tickets
+	c.Proxy.TLS.ListenerSessionTicketKeys = strings.TrimSpace(testSessionTicketKeys)
+	keys := newSessionTicketKeys(c)
+	a.Nil(keys.rotate())
+	keys.add(serverConfig)
+	a.Len(keys.configs, 0)
+}
+
+func acceptHandshakes(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}()
+	}
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inFlightRequests counts the requests of a connection which were sent to the broker and which responses were not completely written to the client
+type inFlightRequests struct {
+	n int32
+}
+
+func (r *inFlightRequests) add(delta int32) {
+	if r == nil {
+		return
+	}
+	atomic.AddInt32(&r.n, delta)
+}
+
+func (r *inFlightRequests) count() int32 {
+	return atomic.LoadInt32(&r.n)
+}
+
+// gracefulShutdown closes the proxied connections in batches when the proxy is stopped. A connection is closed between the requests,
+// so the clients reconnect without failed requests; while draining the responses optionally advertise another host than this instance.
+type gracefulShutdown struct {
+	timeout        time.Duration
+	advertisedHost string
+	batchSize      int
+	interval       time.Duration
+
+	draining int32
+	lock     sync.Mutex
+	conns    map[io.Closer]*inFlightRequests
+}
+
+// newGracefulShutdown returns nil if the connections are closed at once
+func newGracefulShutdown(c *config.Config) *gracefulShutdown {
+	if c.Shutdown.Timeout <= 0 {
+		return nil
+	}
+	return &gracefulShutdown{
+		timeout:        c.Shutdown.Timeout,
+		advertisedHost: c.Shutdown.AdvertisedHost,
+		batchSize:      c.Shutdown.CloseBatchSize,
+		interval:       c.Shutdown.CloseInterval,
+		conns:          make(map[io.Closer]*inFlightRequests),
+	}
+}
+
+func (s *gracefulShutdown) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// redirects reports whether the metadata responses advertise the drain host instead of this instance
+func (s *gracefulShutdown) redirects() bool {
+	return s != nil && s.advertisedHost != "" && s.isDraining()
+}
+
+// register tracks the client connection until unregister is called, it returns the counter of the in-flight requests of the connection
+func (s *gracefulShutdown) register(conn io.Closer) *inFlightRequests {
+	if s == nil {
+		return nil
+	}
+	requests := &inFlightRequests{}
+	s.lock.Lock()
+	s.conns[conn] = requests
+	s.lock.Unlock()
+	return requests
+}
+
+func (s *gracefulShutdown) unregister(conn io.Closer) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if _, ok := s.conns[conn]; ok {
+		delete(s.conns, conn)
+		if s.isDraining() {
+			proxyShutdownRemainingConnections.Dec()
+		}
+	}
+	s.lock.Unlock()
+}
+
+// netAddressMappingFunc returns the mapping which advertises the shutdown host while draining
+func (s *gracefulShutdown) netAddressMappingFunc(fn config.NetAddressMappingFunc) config.NetAddressMappingFunc {
+	if s == nil || s.advertisedHost == "" {
+		return fn
+	}
+	return func(brokerHost string, brokerPort int32) (string, int32, error) {
+		listenerHost, listenerPort, err := fn(brokerHost, brokerPort)
+		if err != nil || !s.isDraining() {
+			return listenerHost, listenerPort, err
+		}
+		if listenerHost == brokerHost && listenerPort == brokerPort {
+			// passed through unmapped broker
+			return listenerHost, listenerPort, nil
+		}
+		return s.advertisedHost, listenerPort, nil
+	}
+}
+
+// drain closes the connections without in-flight requests in batches until all connections are closed or the timeout elapses.
+// The remaining connections are closed by the caller.
+func (s *gracefulShutdown) drain() {
+	s.lock.Lock()
+	atomic.StoreInt32(&s.draining, 1)
+	proxyShutdownRemainingConnections.Add(float64(len(s.conns)))
+	logrus.Infof("Draining %d connections, timeout %v", len(s.conns), s.timeout)
+	s.lock.Unlock()
+
+	deadline := time.Now().Add(s.timeout)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		closed, remaining := s.closeIdle(s.batchSize)
+		if remaining == 0 {
+			logrus.Info("All connections are drained")
+			return
+		}
+		logrus.Infof("Closed %d drained connections, %d connections remaining", closed, remaining)
+		if time.Now().After(deadline) {
+			logrus.Warnf("Shutdown timeout elapsed, closing %d remaining connections", remaining)
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// closeIdle closes up to limit connections without in-flight requests and returns the number of the closed and the remaining connections
+func (s *gracefulShutdown) closeIdle(limit int) (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	closed := 0
+	for conn, requests := range s.conns {
+		if closed == limit {
+			break
+		}
+		if requests.count() > 0 {
+			continue
+		}
+		conn.Close()
+		delete(s.conns, conn)
+		proxyShutdownRemainingConnections.Dec()
+		closed++
+	}
+	return closed, len(s.conns)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestGracefulShutdown() *gracefulShutdown {
+	c := config.NewConfig()
+	c.Shutdown.Timeout = 200 * time.Millisecond
+	c.Shutdown.AdvertisedHost = "kafka-proxy-peers"
+	c.Shutdown.CloseBatchSize = 1
+	c.Shutdown.CloseInterval = 10 * time.Millisecond
+	return newGracefulShutdown(c)
+}
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestGracefulShutdownDrainsIdleConnections(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(newGracefulShutdown(config.NewConfig()))
+
+	s := newTestGracefulShutdown()
+	idle1, idle2, busy := &closeRecorder{}, &closeRecorder{}, &closeRecorder{}
+	s.register(idle1)
+	s.register(idle2)
+	s.register(busy).add(1)
+
+	closed, remaining := s.closeIdle(1)
+	a.Equal(1, closed)
+	a.Equal(2, remaining)
+
+	start := time.Now()
+	s.drain()
+	a.True(time.Since(start) >= 200*time.Millisecond)
+	a.True(idle1.closed)
+	a.True(idle2.closed)
+	// the connection with the in-flight request is closed by the caller after the timeout
+	a.False(busy.closed)
+	s.unregister(busy)
+	a.Len(s.conns, 0)
+}
+
+func TestGracefulShutdownAdvertisedHost(t *testing.T) {
+	a := assert.New(t)
+
+	s := newTestGracefulShutdown()
+	fn := s.netAddressMappingFunc(func(brokerHost string, brokerPort int32) (string, int32, error) {
+		if brokerHost == "unmapped" {
+			return brokerHost, brokerPort, nil
+		}
+		return "kafka-proxy-0", 32400, nil
+	})
+	host, port, err := fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("kafka-proxy-0", host)
+	a.Equal(int32(32400), port)
+
+	s.drain()
+	host, port, err = fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("kafka-proxy-peers", host)
+	a.Equal(int32(32400), port)
+	host, _, err = fn("unmapped", 9092)
+	a.Nil(err)
+	a.Equal("unmapped", host)
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// every window is divided into the buckets
+	sloBuckets = 60
+
+	SLOSuccess = "success"
+	SLOLatency = "latency"
+)
+
+// sloWindows are the windows of the multi-window burn rate alerts, e.g. the 1h and 5m windows for the fast burn and 6h and 30m for the slow burn
+var sloWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// SLOWindow are the SLIs and burn rates within a rolling window
+type SLOWindow struct {
+	Window          string  `json:"window"`
+	Requests        int64   `json:"requests"`
+	Failed          int64   `json:"failed"`
+	Slow            int64   `json:"slow"`
+	SuccessRatio    float64 `json:"success_ratio"`
+	LatencyRatio    float64 `json:"latency_ratio"`
+	SuccessBurnRate float64 `json:"success_burn_rate"`
+	LatencyBurnRate float64 `json:"latency_burn_rate"`
+}
+
+type sloJSON struct {
+	Objective        float64     `json:"objective"`
+	LatencyThreshold string      `json:"latency_threshold"`
+	Windows          []SLOWindow `json:"windows"`
+}
+
+type sloBucket struct {
+	// number of the bucket since the epoch
+	index    int64
+	requests int64
+	failed   int64
+	slow     int64
+}
+
+type sloWindow struct {
+	window     time.Duration
+	bucketSize time.Duration
+	buckets    [sloBuckets]sloBucket
+}
+
+// bucket returns the current bucket which is reset if it was used by an earlier window
+func (w *sloWindow) bucket(now time.Time) *sloBucket {
+	index := now.UnixNano() / int64(w.bucketSize)
+	bucket := &w.buckets[index%sloBuckets]
+	if bucket.index != index {
+		*bucket = sloBucket{index: index}
+	}
+	return bucket
+}
+
+// SLO computes the rolling success rate and latency SLIs of the requests and the burn rates of the error budget,
+// so the multi-window burn rate alerts need only thresholds in the monitoring. A request fails if the connection is closed
+// before its response was written to the client, it is slow if the response was written after the latency threshold.
+type SLO struct {
+	objective        float64
+	latencyThreshold time.Duration
+	now              func() time.Time
+
+	lock    sync.Mutex
+	windows []*sloWindow
+}
+
+// NewSLO returns nil if the SLIs are not computed
+func NewSLO(c *config.Config) *SLO {
+	if !c.SLO.Enable {
+		return nil
+	}
+	result := &SLO{
+		objective:        c.SLO.Objective / 100,
+		latencyThreshold: c.SLO.LatencyThreshold,
+		now:              time.Now,
+	}
+	for _, window := range sloWindows {
+		result.windows = append(result.windows, &sloWindow{window: window, bucketSize: window / sloBuckets})
+	}
+	return result
+}
+
+// register returns the SLI session of the client connection
+func (s *SLO) register() *sloSession {
+	if s == nil {
+		return nil
+	}
+	return &sloSession{slo: s, pending: make(map[int32]time.Time)}
+}
+
+// unregister counts the requests of the closed connection which were not answered as failed
+func (s *SLO) unregister(session *sloSession) {
+	if s == nil || session == nil {
+		return
+	}
+	session.lock.Lock()
+	failed := len(session.pending)
+	session.pending = make(map[int32]time.Time)
+	session.lock.Unlock()
+	for i := 0; i < failed; i++ {
+		s.observe(false, false)
+	}
+}
+
+func (s *SLO) observe(answered bool, slow bool) {
+	now := s.now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, window := range s.windows {
+		bucket := window.bucket(now)
+		bucket.requests++
+		if !answered {
+			bucket.failed++
+		} else if slow {
+			bucket.slow++
+		}
+	}
+}
+
+// Windows returns the SLIs and burn rates of the rolling windows
+func (s *SLO) Windows() []SLOWindow {
+	now := s.now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make([]SLOWindow, 0, len(s.windows))
+	for _, window := range s.windows {
+		current := SLOWindow{Window: window.window.String()}
+		index := now.UnixNano() / int64(window.bucketSize)
+		for _, bucket := range window.buckets {
+			if bucket.index > index-sloBuckets && bucket.index <= index {
+				current.Requests += bucket.requests
+				current.Failed += bucket.failed
+				current.Slow += bucket.slow
+			}
+		}
+		current.SuccessRatio, current.LatencyRatio = 1, 1
+		if current.Requests != 0 {
+			current.SuccessRatio = 1 - float64(current.Failed)/float64(current.Requests)
+		}
+		if answered := current.Requests - current.Failed; answered != 0 {
+			current.LatencyRatio = 1 - float64(current.Slow)/float64(answered)
+		}
+		current.SuccessBurnRate = (1 - current.SuccessRatio) / (1 - s.objective)
+		current.LatencyBurnRate = (1 - current.LatencyRatio) / (1 - s.objective)
+		result = append(result, current)
+	}
+	return result
+}
+
+// Describe implements prometheus.Collector
+func (s *SLO) Describe(ch chan<- *prometheus.Desc) {
+	ch <- proxySLORequests
+	ch <- proxySLORatio
+	ch <- proxySLOBurnRate
+	ch <- proxySLOObjective
+}
+
+// Collect implements prometheus.Collector, the SLIs are computed on every scrape
+func (s *SLO) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(proxySLOObjective, prometheus.GaugeValue, s.objective)
+	for _, window := range s.Windows() {
+		ch <- prometheus.MustNewConstMetric(proxySLORequests, prometheus.GaugeValue, float64(window.Requests), window.Window)
+		ch <- prometheus.MustNewConstMetric(proxySLORatio, prometheus.GaugeValue, window.SuccessRatio, SLOSuccess, window.Window)
+		ch <- prometheus.MustNewConstMetric(proxySLORatio, prometheus.GaugeValue, window.LatencyRatio, SLOLatency, window.Window)
+		ch <- prometheus.MustNewConstMetric(proxySLOBurnRate, prometheus.GaugeValue, window.SuccessBurnRate, SLOSuccess, window.Window)
+		ch <- prometheus.MustNewConstMetric(proxySLOBurnRate, prometheus.GaugeValue, window.LatencyBurnRate, SLOLatency, window.Window)
+	}
+}
+
+// ServeHTTP returns the SLIs and burn rates of the rolling windows on GET
+func (s *SLO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sloJSON{Objective: s.objective * 100, LatencyThreshold: s.latencyThreshold.String(), Windows: s.Windows()})
+}
+
+// sloSession times the requests of a connection and matches the responses by the correlation id
+type sloSession struct {
+	slo *SLO
+
+	lock    sync.Mutex
+	pending map[int32]time.Time
+}
+
+// request starts timing the request, headerBuf is the request header starting with the correlation id
+func (s *sloSession) request(headerBuf []byte) {
+	// request header v0 has no correlation id
+	if s == nil || len(headerBuf) < 4 {
+		return
+	}
+	correlationID := int32(binary.BigEndian.Uint32(headerBuf))
+	received := s.slo.now()
+	s.lock.Lock()
+	s.pending[correlationID] = received
+	s.lock.Unlock()
+}
+
+// response counts the request of the response written to the client
+func (s *sloSession) response(correlationID int32) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	received, ok := s.pending[correlationID]
+	delete(s.pending, correlationID)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	s.slo.observe(true, s.slo.now().Sub(received) > s.slo.latencyThreshold)
+}
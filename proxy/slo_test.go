@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLO(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(NewSLO(c))
+	c.SLO.Enable = true
+	c.SLO.Objective = 99
+	c.SLO.LatencyThreshold = 100 * time.Millisecond
+	slo := NewSLO(c)
+	now := time.Unix(1700000000, 0)
+	slo.now = func() time.Time { return now }
+
+	session := slo.register()
+	for i := byte(0); i < 10; i++ {
+		session.request([]byte{0, 0, 0, i, 0, 1, 'c'})
+	}
+	// request header v0 has no correlation id
+	session.request([]byte{})
+	a.Len(session.pending, 10)
+
+	for i := int32(0); i < 8; i++ {
+		session.response(i)
+	}
+	now = now.Add(200 * time.Millisecond)
+	session.response(8)
+	// unknown correlation id
+	session.response(42)
+	// closed before the last response was written
+	slo.unregister(session)
+	a.Len(session.pending, 0)
+
+	windows := slo.Windows()
+	a.Len(windows, 4)
+	for _, window := range windows {
+		a.Equal(int64(10), window.Requests)
+		a.Equal(int64(1), window.Failed)
+		a.Equal(int64(1), window.Slow)
+		a.InDelta(0.9, window.SuccessRatio, 1e-9)
+		a.InDelta(8.0/9.0, window.LatencyRatio, 1e-9)
+		a.InDelta(10, window.SuccessBurnRate, 1e-9)
+		a.InDelta(100.0/9.0, window.LatencyBurnRate, 1e-9)
+	}
+	a.Equal("5m0s", windows[0].Window)
+	a.Equal("6h0m0s", windows[3].Window)
+
+	// the requests left the 5m and 30m windows
+	now = now.Add(time.Hour - time.Minute)
+	windows = slo.Windows()
+	a.Equal(int64(0), windows[0].Requests)
+	a.Equal(1.0, windows[0].SuccessRatio)
+	a.Equal(0.0, windows[0].SuccessBurnRate)
+	a.Equal(int64(0), windows[1].Requests)
+	a.Equal(int64(10), windows[2].Requests)
+	a.Equal(int64(10), windows[3].Requests)
+
+	registry := prometheus.NewRegistry()
+	a.Nil(registry.Register(slo))
+	families, err := registry.Gather()
+	a.Nil(err)
+	a.Len(families, 4)
+
+	rec := httptest.NewRecorder()
+	slo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slo", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	var result sloJSON
+	a.Nil(json.Unmarshal(rec.Body.Bytes(), &result))
+	a.Equal(99.0, result.Objective)
+	a.Equal("100ms", result.LatencyThreshold)
+	a.Len(result.Windows, 4)
+
+	rec = httptest.NewRecorder()
+	slo.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/slo", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+
+	// nil safe
+	var disabled *SLO
+	a.Nil(disabled.register())
+	disabled.unregister(nil)
+	var disabledSession *sloSession
+	disabledSession.request([]byte{0, 0, 0, 1})
+	disabledSession.response(1)
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slowRequests logs the requests which took longer than the threshold from reading the request of the client
+// until the response was written to the client. At most one slow request is logged per log interval.
+type slowRequests struct {
+	threshold   time.Duration
+	logInterval time.Duration
+	now         func() time.Time
+
+	lock    sync.Mutex
+	lastLog time.Time
+	// slow requests since the last log
+	suppressed int
+}
+
+// newSlowRequests returns nil if the requests are not timed
+func newSlowRequests(c *config.Config) *slowRequests {
+	if c.SlowRequests.Threshold == 0 {
+		return nil
+	}
+	return &slowRequests{
+		threshold:   c.SlowRequests.Threshold,
+		logInterval: c.SlowRequests.LogInterval,
+		now:         time.Now,
+	}
+}
+
+func (s *slowRequests) newSession(brokerAddress string) *slowRequestSession {
+	if s == nil {
+		return nil
+	}
+	return &slowRequestSession{
+		slowRequests:  s,
+		brokerAddress: brokerAddress,
+		pending:       make(map[int32]*timedRequest),
+	}
+}
+
+// log logs the slow request unless another one was logged within the log interval.
+// It returns the number of the slow requests which were not logged before this one or -1 if this one is not logged.
+func (s *slowRequests) log(brokerAddress string, request *timedRequest, duration time.Duration, responseSize int32) int {
+	proxySlowRequestsTotal.WithLabelValues(brokerAddress, strconv.Itoa(int(request.apiKey))).Inc()
+
+	now := s.now()
+	s.lock.Lock()
+	if !s.lastLog.IsZero() && now.Sub(s.lastLog) < s.logInterval {
+		s.suppressed++
+		s.lock.Unlock()
+		return -1
+	}
+	suppressed := s.suppressed
+	s.lastLog = now
+	s.suppressed = 0
+	s.lock.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"broker":        brokerAddress,
+		"api_key":       request.apiKey,
+		"api_version":   request.apiVersion,
+		"principal":     request.principal,
+		"client_id":     request.clientID,
+		"duration":      duration,
+		"request_size":  request.size,
+		"response_size": responseSize,
+		"suppressed":    suppressed,
+	}).Warnf("Slow request took %v, %d slow requests were not logged since the last log", duration, suppressed)
+	return suppressed
+}
+
+type timedRequest struct {
+	apiKey     int16
+	apiVersion int16
+	principal  string
+	clientID   string
+	size       int32
+	received   time.Time
+}
+
+// slowRequestSession times the requests of a connection and matches the responses by the correlation id
+type slowRequestSession struct {
+	slowRequests  *slowRequests
+	brokerAddress string
+
+	lock    sync.Mutex
+	pending map[int32]*timedRequest
+}
+
+// request starts timing the request, headerBuf is the request header starting with the correlation id
+func (s *slowRequestSession) request(requestKeyVersion *protocol.RequestKeyVersion, headerBuf []byte, principal string, clientID string) {
+	// request header v0 has no correlation id
+	if s == nil || len(headerBuf) < 4 {
+		return
+	}
+	correlationID := int32(binary.BigEndian.Uint32(headerBuf))
+	request := &timedRequest{
+		apiKey:     requestKeyVersion.ApiKey,
+		apiVersion: requestKeyVersion.ApiVersion,
+		principal:  principal,
+		clientID:   clientID,
+		size:       requestKeyVersion.Length + 4,
+		received:   s.slowRequests.now(),
+	}
+	s.lock.Lock()
+	s.pending[correlationID] = request
+	s.lock.Unlock()
+}
+
+// response stops timing the request of the response written to the client and logs it if it was slow
+func (s *slowRequestSession) response(correlationID int32, responseSize int32) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	request, ok := s.pending[correlationID]
+	delete(s.pending, correlationID)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	if duration := s.slowRequests.now().Sub(request.received); duration >= s.slowRequests.threshold {
+		s.slowRequests.log(s.brokerAddress, request, duration, responseSize)
+	}
+}
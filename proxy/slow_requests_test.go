@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSlowRequests(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newSlowRequests(c))
+	c.SlowRequests.Threshold = 100 * time.Millisecond
+	s := newSlowRequests(c)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	session := s.newSession("kafka-slow:9092")
+
+	produce := &protocol.RequestKeyVersion{Length: 100, ApiKey: 0, ApiVersion: 7}
+	// correlation id 1, client id "c"
+	session.request(produce, []byte{0, 0, 0, 1, 0, 1, 'c'}, "alice", "c")
+	session.request(produce, []byte{0, 0, 0, 2, 0, 1, 'c'}, "alice", "c")
+	session.request(produce, []byte{0, 0, 0, 3, 0, 1, 'c'}, "alice", "c")
+	a.Len(session.pending, 3)
+
+	// fast
+	now = now.Add(50 * time.Millisecond)
+	session.response(1, 20)
+	a.Len(session.pending, 2)
+	a.True(s.lastLog.IsZero())
+
+	// slow and logged, the next one is suppressed within the log interval
+	now = now.Add(50 * time.Millisecond)
+	session.response(2, 20)
+	a.Equal(now, s.lastLog)
+	session.response(3, 20)
+	a.Equal(1, s.suppressed)
+	a.Len(session.pending, 0)
+
+	// unknown correlation id
+	session.response(4, 20)
+	a.Equal(1, s.suppressed)
+
+	now = now.Add(10 * time.Second)
+	a.Equal(1, s.log("kafka-slow:9092", &timedRequest{apiKey: 1}, time.Second, 20))
+	a.Equal(-1, s.log("kafka-slow:9092", &timedRequest{apiKey: 1}, time.Second, 20))
+
+	// request header v0 has no correlation id
+	session.request(&protocol.RequestKeyVersion{Length: 10, ApiKey: 7, ApiVersion: 0}, []byte{}, "alice", "")
+	a.Len(session.pending, 0)
+
+	// nil safe
+	var disabled *slowRequests
+	a.Nil(disabled.newSession("kafka-slow:9092"))
+	var disabledSession *slowRequestSession
+	disabledSession.request(produce, []byte{0, 0, 0, 1, 0, 1, 'c'}, "alice", "c")
+	disabledSession.response(1, 20)
+}
@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+	socks5CommandBind      = 2
+	socks5AddressIPv4      = 1
+	socks5AddressDomain    = 3
+	socks5AddressIPv6      = 4
+)
+
+var socks5ReplyErrors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5BindDialer uses the SOCKS5 BIND command (RFC 1928). The proxy binds an address, which is announced to the remote side,
+// and returns the connection once the remote side connected to it. The broker address tells the proxy which peer to accept.
+type socks5BindDialer struct {
+	directDialer            directDialer
+	proxyNetwork, proxyAddr string
+	username, password      string
+	// how long to wait for the remote side to connect
+	bindTimeout time.Duration
+	// optional, the broker and the bound address are posted to the URL
+	notifyUrl  string
+	httpClient *http.Client
+}
+
+type socks5BindNotification struct {
+	Broker  string `json:"broker"`
+	Address string `json:"address"`
+}
+
+func (d socks5BindDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d socks5BindDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.proxyNetwork == "" || d.proxyAddr == "" {
+		return nil, errors.New("socks5 proxy network and addr must be not empty")
+	}
+	conn, err := forwardProxyDialer{dialer: d.directDialer}.DialContext(ctx, d.proxyNetwork, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	if err = d.bind(ctx, conn, addr); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d socks5BindDialer) bind(ctx context.Context, conn net.Conn, addr string) error {
+	if err := d.authenticate(conn); err != nil {
+		return err
+	}
+	request, err := socks5Request(socks5CommandBind, addr)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(request); err != nil {
+		return errors.Wrapf(err, "cannot write BIND request to SOCKS5 proxy %s", d.proxyAddr)
+	}
+	bound, err := readSocks5Reply(conn)
+	if err != nil {
+		return errors.Wrapf(err, "SOCKS5 proxy %s BIND failed", d.proxyAddr)
+	}
+	bound = d.externalAddress(bound)
+	logrus.Infof("SOCKS5 proxy %s bound %s for the connection from %s", d.proxyAddr, bound, addr)
+	if err = d.notify(ctx, addr, bound); err != nil {
+		return err
+	}
+
+	// the remote side connects
+	deadline := time.Time{}
+	if d.bindTimeout > 0 {
+		deadline = time.Now().Add(d.bindTimeout)
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	peer, err := readSocks5Reply(conn)
+	if err != nil {
+		return errors.Wrapf(err, "remote side did not connect to %s bound by SOCKS5 proxy %s", bound, d.proxyAddr)
+	}
+	logrus.Infof("Remote side %s connected to %s bound by SOCKS5 proxy %s", peer, bound, d.proxyAddr)
+	return nil
+}
+
+func (d socks5BindDialer) authenticate(conn net.Conn) error {
+	greeting := []byte{socks5Version, 1, socks5AuthNone}
+	if d.username != "" && d.password != "" {
+		greeting = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return errors.Wrapf(err, "cannot write greeting to SOCKS5 proxy %s", d.proxyAddr)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return errors.Wrapf(err, "cannot read greeting from SOCKS5 proxy %s", d.proxyAddr)
+	}
+	if buf[0] != socks5Version {
+		return errors.Errorf("SOCKS5 proxy %s has unexpected version %d", d.proxyAddr, buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if len(d.username) > 255 || len(d.password) > 255 {
+			return errors.New("SOCKS5 username and password must not be longer than 255 bytes")
+		}
+		// RFC 1929
+		request := []byte{1, byte(len(d.username))}
+		request = append(request, d.username...)
+		request = append(request, byte(len(d.password)))
+		request = append(request, d.password...)
+		if _, err := conn.Write(request); err != nil {
+			return errors.Wrapf(err, "cannot write authentication request to SOCKS5 proxy %s", d.proxyAddr)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return errors.Wrapf(err, "cannot read authentication reply from SOCKS5 proxy %s", d.proxyAddr)
+		}
+		if buf[1] != 0 {
+			return errors.Errorf("SOCKS5 proxy %s rejected username/password", d.proxyAddr)
+		}
+		return nil
+	case socks5AuthNoAcceptable:
+		return errors.Errorf("SOCKS5 proxy %s requires authentication", d.proxyAddr)
+	default:
+		return errors.Errorf("SOCKS5 proxy %s selected unsupported authentication method %d", d.proxyAddr, buf[1])
+	}
+}
+
+// externalAddress replaces the unspecified IP address bound by the proxy with the proxy host
+func (d socks5BindDialer) externalAddress(bound string) string {
+	host, port, err := net.SplitHostPort(bound)
+	if err != nil {
+		return bound
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if proxyHost, _, err := net.SplitHostPort(d.proxyAddr); err == nil {
+			return net.JoinHostPort(proxyHost, port)
+		}
+	}
+	return bound
+}
+
+func (d socks5BindDialer) notify(ctx context.Context, broker, bound string) error {
+	if d.notifyUrl == "" {
+		return nil
+	}
+	body, err := json.Marshal(socks5BindNotification{Broker: broker, Address: bound})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.notifyUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := d.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "cannot notify the bound address")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("cannot notify the bound address: %s", resp.Status)
+	}
+	return nil
+}
+
+// socks5Request encodes the command with the IP address or the host name of addr
+func socks5Request(command byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return nil, errors.Errorf("invalid port %s", portStr)
+	}
+	request := []byte{socks5Version, command, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(request, socks5AddressIPv4)
+			request = append(request, ip4...)
+		} else {
+			request = append(request, socks5AddressIPv6)
+			request = append(request, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.Errorf("host name %s is too long", host)
+		}
+		request = append(request, socks5AddressDomain, byte(len(host)))
+		request = append(request, host...)
+	}
+	return append(request, byte(port>>8), byte(port)), nil
+}
+
+// readSocks5Reply returns the address of the reply or the error reported by the proxy
+func readSocks5Reply(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", errors.Errorf("unexpected version %d", header[0])
+	}
+	if header[1] != 0 {
+		if reason, ok := socks5ReplyErrors[header[1]]; ok {
+			return "", errors.New(reason)
+		}
+		return "", errors.Errorf("unknown error %d", header[1])
+	}
+	var host string
+	switch header[3] {
+	case socks5AddressIPv4, socks5AddressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if header[3] == socks5AddressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errors.Errorf("unknown address type %d", header[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveSocks5Bind handles one BIND request without authentication, the bound listener accepts one connection
+func serveSocks5Bind(a *assert.Assertions, ln net.Listener, reply byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	greeting := make([]byte, 3)
+	_, err = io.ReadFull(conn, greeting)
+	a.Nil(err)
+	conn.Write([]byte{socks5Version, socks5AuthNone})
+
+	// VER CMD RSV ATYP=domain LEN
+	header := make([]byte, 5)
+	_, err = io.ReadFull(conn, header)
+	a.Nil(err)
+	a.Equal(byte(socks5CommandBind), header[1])
+	a.Equal(byte(socks5AddressDomain), header[3])
+	rest := make([]byte, int(header[4])+2)
+	_, err = io.ReadFull(conn, rest)
+	a.Nil(err)
+	a.Equal("kafka-1.internal", string(rest[:header[4]]))
+
+	if reply != 0 {
+		conn.Write([]byte{socks5Version, reply, 0, socks5AddressIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	bound, err := net.Listen("tcp", "0.0.0.0:0")
+	a.Nil(err)
+	defer bound.Close()
+	port := bound.Addr().(*net.TCPAddr).Port
+	conn.Write([]byte{socks5Version, 0, 0, socks5AddressIPv4, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+
+	remote, err := bound.Accept()
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+	peer := remote.RemoteAddr().(*net.TCPAddr)
+	conn.Write(append(append([]byte{socks5Version, 0, 0, socks5AddressIPv4}, peer.IP.To4()...), byte(peer.Port>>8), byte(peer.Port)))
+
+	// relay one message from the remote side
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(remote, buf); err == nil {
+		conn.Write(buf)
+	}
+}
+
+func TestSocks5Bind(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	go serveSocks5Bind(a, ln, 0)
+
+	// the notified agent initiates the connection
+	notifications := make(chan socks5BindNotification, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification socks5BindNotification
+		a.Nil(json.NewDecoder(r.Body).Decode(&notification))
+		notifications <- notification
+		go func() {
+			conn, err := net.Dial("tcp", notification.Address)
+			if a.Nil(err) {
+				conn.Write([]byte("hello"))
+				time.Sleep(100 * time.Millisecond)
+				conn.Close()
+			}
+		}()
+	}))
+	defer agent.Close()
+
+	dialer := socks5BindDialer{
+		directDialer: directDialer{dialTimeout: 2 * time.Second},
+		proxyNetwork: "tcp",
+		proxyAddr:    ln.Addr().String(),
+		bindTimeout:  2 * time.Second,
+		notifyUrl:    agent.URL,
+	}
+	conn, err := dialer.Dial("tcp", "kafka-1.internal:9092")
+	a.Nil(err)
+	defer conn.Close()
+
+	notification := <-notifications
+	a.Equal("kafka-1.internal:9092", notification.Broker)
+	host, _, _ := net.SplitHostPort(notification.Address)
+	a.Equal("127.0.0.1", host)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal("hello", string(buf))
+}
+
+func TestSocks5BindErrors(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	go serveSocks5Bind(a, ln, 7)
+
+	dialer := socks5BindDialer{
+		directDialer: directDialer{dialTimeout: 2 * time.Second},
+		proxyNetwork: "tcp",
+		proxyAddr:    ln.Addr().String(),
+		bindTimeout:  2 * time.Second,
+	}
+	_, err = dialer.Dial("tcp", "kafka-1.internal:9092")
+	a.EqualError(err, "SOCKS5 proxy "+ln.Addr().String()+" BIND failed: command not supported")
+
+	// the proxy is unreachable
+	ln.Close()
+	_, err = dialer.Dial("tcp", "kafka-1.internal:9092")
+	a.True(isForwardProxyDialError(err))
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	socks5CommandConnect = 1
+
+	socks5ReplySucceeded           = 0
+	socks5ReplyNotAllowed          = 2
+	socks5ReplyCommandNotSupported = 7
+	socks5ReplyAddrNotSupported    = 8
+)
+
+// socks5Listener accepts the SOCKS5 connections of the clients which can reach the brokers only through the proxy.
+// The clients can CONNECT to the mapped broker addresses or to their advertised addresses, the connections are proxied
+// as the connections accepted by the listeners of the brokers.
+type socks5Listener struct {
+	username         string
+	password         string
+	handshakeTimeout time.Duration
+	// broker address of the CONNECT target, false if the target is not mapped
+	target func(addr string) (string, bool)
+}
+
+// ListenSocks5 starts the SOCKS5 listener if configured
+func (p *Listeners) ListenSocks5(c *config.Config) error {
+	if c.Proxy.Socks5.ListenAddress == "" {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	l, err := net.Listen("tcp", c.Proxy.Socks5.ListenAddress)
+	if err != nil {
+		return err
+	}
+	p.listeners = append(p.listeners, l)
+	s := &socks5Listener{
+		username:         c.Proxy.Socks5.Username,
+		password:         c.Proxy.Socks5.Password,
+		handshakeTimeout: c.Proxy.Socks5.HandshakeTimeout,
+		target:           p.socks5Target,
+	}
+	acceptOpts := p.acceptOptions(c.Proxy.Socks5.ListenAddress)
+	go withRecover(func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				logrus.Infof("Error in accept on SOCKS5 listener %v: %v", l.Addr(), err)
+				l.Close()
+				return
+			}
+			if atomic.LoadInt32(&p.paused) == 1 {
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonPaused).Inc()
+				conn.Close()
+				continue
+			}
+			if _, overloaded := acceptOpts.loadShedder.overloaded(); overloaded {
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonOverloaded).Inc()
+				conn.Close()
+				continue
+			}
+			if reason, ok := acceptOpts.sourceFilter.accept(conn.RemoteAddr()); !ok {
+				logrus.Infof("Rejected SOCKS5 connection from %v on %v: %s", conn.RemoteAddr(), l.Addr(), reason)
+				proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), reason).Inc()
+				conn.Close()
+				continue
+			}
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				if err := p.tcpConnOptions.setTCPConnOptions(tcpConn); err != nil {
+					logrus.Infof("WARNING: Error while setting TCP options for accepted SOCKS5 connection on %v: %v", l.Addr(), err)
+				}
+			}
+			go withConnectionRecover(panicGoroutineHandshake, conn.RemoteAddr().String(), func() {
+				brokerAddress, err := s.handshake(conn)
+				if err != nil {
+					logrus.Infof("SOCKS5 handshake with %v on %v failed: %v", conn.RemoteAddr(), l.Addr(), err)
+					proxyRejectedConnectionsTotal.WithLabelValues(l.Addr().String(), rejectReasonHandshakeFailed).Inc()
+					conn.Close()
+					return
+				}
+				logrus.Infof("New SOCKS5 connection for %s", brokerAddress)
+				p.connSrc <- Conn{BrokerAddress: brokerAddress, LocalConnection: conn}
+			}, conn)
+		}
+	})
+	logrus.Infof("Listening on %s for SOCKS5 connections to the mapped brokers", l.Addr())
+	return nil
+}
+
+// socks5Target returns the broker address of the mapped broker or advertised address
+func (p *Listeners) socks5Target(addr string) (string, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if _, ok := p.brokerToListenerConfig[addr]; ok {
+		return addr, true
+	}
+	for brokerAddress, lc := range p.brokerToListenerConfig {
+		if lc.AdvertisedAddress == addr {
+			return brokerAddress, true
+		}
+	}
+	return "", false
+}
+
+// handshake negotiates the authentication and reads the CONNECT request, it returns the broker address of the target
+func (s *socks5Listener) handshake(conn net.Conn) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(s.handshakeTimeout)); err != nil {
+		return "", err
+	}
+	if err := s.authenticate(conn); err != nil {
+		return "", err
+	}
+	// VER, CMD, RSV, ATYP
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	addr, err := readSocks5Addr(conn, header[3])
+	if err != nil {
+		if err == errSocks5AddrType {
+			s.reply(conn, socks5ReplyAddrNotSupported)
+		}
+		return "", err
+	}
+	if header[1] != socks5CommandConnect {
+		s.reply(conn, socks5ReplyCommandNotSupported)
+		return "", fmt.Errorf("SOCKS5 command %d to %s is not supported", header[1], addr)
+	}
+	brokerAddress, ok := s.target(addr)
+	if !ok {
+		s.reply(conn, socks5ReplyNotAllowed)
+		return "", fmt.Errorf("CONNECT to %s is not allowed, the address is not mapped", addr)
+	}
+	if err = s.reply(conn, socks5ReplySucceeded); err != nil {
+		return "", err
+	}
+	return brokerAddress, conn.SetDeadline(time.Time{})
+}
+
+func (s *socks5Listener) authenticate(conn net.Conn) error {
+	// VER, NMETHODS
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	method := byte(socks5AuthNone)
+	if s.username != "" {
+		method = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == method
+	}
+	if !offered {
+		conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return fmt.Errorf("client did not offer the authentication method %d", method)
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return err
+	}
+	if method == socks5AuthNone {
+		return nil
+	}
+	// username / password authentication (RFC 1929): VER, ULEN, UNAME, PLEN, PASSWD
+	username, err := readSocks5String(conn, 2)
+	if err != nil {
+		return err
+	}
+	password, err := readSocks5String(conn, 1)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) != 1 || subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+		conn.Write([]byte{1, 1})
+		return fmt.Errorf("invalid credentials of user %q", username)
+	}
+	_, err = conn.Write([]byte{1, 0})
+	return err
+}
+
+// readSocks5String reads the string after the first skip bytes with its length
+func readSocks5String(r io.Reader, skip int) (string, error) {
+	buf := make([]byte, skip)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	value := make([]byte, buf[skip-1])
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+var errSocks5AddrType = errors.New("unsupported SOCKS5 address type")
+
+// readSocks5Addr reads DST.ADDR and DST.PORT of the request
+func readSocks5Addr(r io.Reader, addrType byte) (string, error) {
+	var host string
+	switch addrType {
+	case socks5AddressIPv4, socks5AddressIPv6:
+		ip := make([]byte, net.IPv4len)
+		if addrType == socks5AddressIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddressDomain:
+		domain, err := readSocks5String(r, 1)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		return "", errSocks5AddrType
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// reply writes the reply with the local address of the connection as bound address
+func (s *socks5Listener) reply(conn net.Conn, rep byte) error {
+	buf := []byte{socks5Version, rep, 0}
+	ip, port := net.IPv4zero.To4(), 0
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		ip, port = addr.IP, addr.Port
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		buf = append(append(buf, socks5AddressIPv4), ip4...)
+	} else {
+		buf = append(append(buf, socks5AddressIPv6), ip.To16()...)
+	}
+	buf = append(buf, byte(port>>8), byte(port))
+	_, err := conn.Write(buf)
+	return err
+}
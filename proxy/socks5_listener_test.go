@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/proxy"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTestSocks5Proxy(a *assert.Assertions, brokerAddress, username, password string) (string, string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	socks5Address := l.Addr().String()
+	l.Close()
+
+	c := newTestProxyConfig(brokerAddress)
+	c.Proxy.Socks5.ListenAddress = socks5Address
+	c.Proxy.Socks5.Username = username
+	c.Proxy.Socks5.Password = password
+	listenerAddress, stop := startTestProxy(a, c)
+	return listenerAddress, socks5Address, stop
+}
+
+func TestSocks5ListenerConnectsToMappedBrokers(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer broker.Close()
+	listenerAddress, socks5Address, stop := startTestSocks5Proxy(a, broker.Addr(), "", "")
+	defer stop()
+
+	dialer, err := proxy.SOCKS5("tcp", socks5Address, nil, proxy.Direct)
+	a.Nil(err)
+	// the broker address and the advertised address are accepted
+	for _, addr := range []string{broker.Addr(), listenerAddress} {
+		conn, err := dialer.Dial("tcp", addr)
+		a.Nil(err)
+		_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 1, "test", kafkatest.MetadataRequestBody(1, nil)))
+		a.Nil(err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		correlationID, _, err := kafkatest.ReadResponse(conn)
+		a.Nil(err)
+		a.Equal(int32(1), correlationID)
+		conn.Close()
+	}
+	a.Equal(2, broker.RequestCount(kafkatest.ApiKeyMetadata))
+
+	_, err = dialer.Dial("tcp", "127.0.0.1:1")
+	a.NotNil(err)
+}
+
+func TestSocks5ListenerAuthenticatesClients(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer broker.Close()
+	_, socks5Address, stop := startTestSocks5Proxy(a, broker.Addr(), "alice", "secret")
+	defer stop()
+
+	dialer, err := proxy.SOCKS5("tcp", socks5Address, &proxy.Auth{User: "alice", Password: "secret"}, proxy.Direct)
+	a.Nil(err)
+	conn, err := dialer.Dial("tcp", broker.Addr())
+	a.Nil(err)
+	conn.Close()
+
+	for _, auth := range []*proxy.Auth{nil, {User: "alice", Password: "wrong"}} {
+		dialer, err := proxy.SOCKS5("tcp", socks5Address, auth, proxy.Direct)
+		a.Nil(err)
+		_, err = dialer.Dial("tcp", broker.Addr())
+		a.NotNil(err)
+	}
+}
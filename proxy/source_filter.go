@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"net"
+)
+
+// sourceFilter checks the remote address of accepted connections before any data (e.g. TLS handshake) is read
+type sourceFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// accept returns the reject reason if the connection is not accepted
+func (f *sourceFilter) accept(addr net.Addr) (string, bool) {
+	if f == nil {
+		return "", true
+	}
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		ip = v.IP
+	case *net.UnixAddr:
+		// local Unix socket peers have no source address
+		return "", true
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return rejectReasonNotAllowed, false
+		}
+		ip = net.ParseIP(host)
+	}
+	if containsIP(f.denied, ip) {
+		return rejectReasonDenied, false
+	}
+	if len(f.allowed) != 0 && !containsIP(f.allowed, ip) {
+		return rejectReasonNotAllowed, false
+	}
+	return "", true
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceFilters holds the filters of the listener addresses
+type sourceFilters struct {
+	global      *sourceFilter
+	perListener map[string]*sourceFilter
+}
+
+func newSourceFilters(cfg *config.Config) (*sourceFilters, error) {
+	globalAllowed, allowed, err := config.ParseListenerCIDRs(cfg.Proxy.ListenerAllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	globalDenied, denied, err := config.ParseListenerCIDRs(cfg.Proxy.ListenerDeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	filters := &sourceFilters{perListener: make(map[string]*sourceFilter)}
+	if len(globalAllowed) != 0 || len(globalDenied) != 0 {
+		filters.global = &sourceFilter{allowed: globalAllowed, denied: globalDenied}
+	}
+	// listener specific networks replace the global ones
+	for _, listenerAddress := range mapKeys(allowed, denied) {
+		filter := &sourceFilter{allowed: globalAllowed, denied: globalDenied}
+		if v, ok := allowed[listenerAddress]; ok {
+			filter.allowed = v
+		}
+		if v, ok := denied[listenerAddress]; ok {
+			filter.denied = v
+		}
+		filters.perListener[listenerAddress] = filter
+	}
+	return filters, nil
+}
+
+// get returns nil when all sources are accepted
+func (f *sourceFilters) get(listenerAddress string) *sourceFilter {
+	if f == nil {
+		return nil
+	}
+	if filter, ok := f.perListener[listenerAddress]; ok {
+		return filter
+	}
+	return f.global
+}
+
+func mapKeys(maps ...map[string][]*net.IPNet) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, m := range maps {
+		for k := range m {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
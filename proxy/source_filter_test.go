@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSourceFilters(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.ListenerAllowedCIDRs = []string{"10.0.0.0/8", "0.0.0.0:32401=192.168.0.0/16"}
+	c.Proxy.ListenerDeniedCIDRs = []string{"10.1.0.0/16"}
+	filters, err := newSourceFilters(c)
+	a.Nil(err)
+
+	tests := []struct {
+		listenerAddress string
+		ip              string
+		reason          string
+		accepted        bool
+	}{
+		{"0.0.0.0:32400", "10.0.0.1", "", true},
+		{"0.0.0.0:32400", "10.1.0.1", rejectReasonDenied, false},
+		{"0.0.0.0:32400", "192.168.0.1", rejectReasonNotAllowed, false},
+		{"0.0.0.0:32401", "192.168.0.1", "", true},
+		{"0.0.0.0:32401", "10.0.0.1", rejectReasonNotAllowed, false},
+		{"0.0.0.0:32401", "10.1.0.1", rejectReasonDenied, false},
+	}
+	for _, tt := range tests {
+		reason, accepted := filters.get(tt.listenerAddress).accept(&net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 12345})
+		a.Equal(tt.accepted, accepted, "%v", tt)
+		a.Equal(tt.reason, reason, "%v", tt)
+	}
+
+	filters, err = newSourceFilters(config.NewConfig())
+	a.Nil(err)
+	a.Nil(filters.get("0.0.0.0:32400"))
+	_, accepted := filters.get("0.0.0.0:32400").accept(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")})
+	a.True(accepted)
+}
+
+func TestProxyRejectsDeniedSource(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.ListenerDeniedCIDRs = []string{"127.0.0.0/8"}
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// responseSpill reads the responses bigger than the threshold, which are inspected or rewritten by the proxy, into memory-mapped
+// temporary files instead of the heap. The pages of a mapped file are backed by the disk and can be reclaimed by the kernel,
+// so a consumer fetching huge batches does not blow the heap of the proxy.
+type responseSpill struct {
+	threshold int
+	dir       string
+}
+
+// newResponseSpill returns nil if the responses are always read into the heap
+func newResponseSpill(c *config.Config) *responseSpill {
+	if c.Proxy.ResponseSpillThreshold <= 0 {
+		return nil
+	}
+	dir := c.Proxy.ResponseSpillDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &responseSpill{threshold: c.Proxy.ResponseSpillThreshold, dir: dir}
+}
+
+func (s *responseSpill) spills(size int) bool {
+	return s != nil && size > s.threshold
+}
+
+// read reads the response body of the size into a memory-mapped temporary file, the returned func unmaps and removes the file.
+// The body is read into the heap if the file cannot be mapped, all returned errors are read errors.
+func (s *responseSpill) read(src io.Reader, size int, brokerAddress string) ([]byte, func(), error) {
+	buf, release, err := s.mmap(size)
+	if err != nil {
+		logrus.Warnf("Response of %d bytes from %s cannot be spilled to %s, it is read into memory: %v", size, brokerAddress, s.dir, err)
+		buf, release = make([]byte, size), noRelease
+	} else {
+		proxySpilledResponsesTotal.WithLabelValues(brokerAddress).Inc()
+		proxySpilledBytesTotal.WithLabelValues(brokerAddress).Add(float64(size))
+	}
+	if _, err = io.ReadFull(src, buf); err != nil {
+		release()
+		return nil, noRelease, err
+	}
+	return buf, release, nil
+}
+
+func (s *responseSpill) mmap(size int) ([]byte, func(), error) {
+	f, err := ioutil.TempFile(s.dir, "kafka-proxy-response-")
+	if err != nil {
+		return nil, nil, err
+	}
+	// the mapping keeps the file alive
+	defer f.Close()
+	if err = f.Truncate(int64(size)); err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	buf, unmap, err := mmapFile(f, size)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	return buf, func() {
+		if err := unmap(); err != nil {
+			logrus.Warnf("Unmapping the spilled response %s failed: %v", f.Name(), err)
+		}
+		os.Remove(f.Name())
+	}, nil
+}
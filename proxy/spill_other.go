@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the file of the size as shared writable memory
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+	buf, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf, func() error { return syscall.Munmap(buf) }, nil
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResponseSpill(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newResponseSpill(c))
+	var disabled *responseSpill
+	a.False(disabled.spills(1 << 30))
+
+	dir, err := ioutil.TempDir("", "spill")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	c.Proxy.ResponseSpillThreshold = 16
+	c.Proxy.ResponseSpillDir = dir
+	s := newResponseSpill(c)
+	a.False(s.spills(16))
+	a.True(s.spills(17))
+
+	body := []byte(randomString(10000))
+	before := counterOf(a, proxySpilledResponsesTotal, "broker:9092")
+	resp, release, err := s.read(bytes.NewReader(body), len(body), "broker:9092")
+	a.Nil(err)
+	a.Equal(body, resp)
+	a.Equal(before+1, counterOf(a, proxySpilledResponsesTotal, "broker:9092"))
+	files, err := ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 1)
+	release()
+	files, err = ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 0)
+
+	// truncated response
+	_, _, err = s.read(bytes.NewReader(body[:100]), len(body), "broker:9092")
+	a.NotNil(err)
+	files, err = ioutil.ReadDir(dir)
+	a.Nil(err)
+	a.Len(files, 0)
+
+	// read into memory if the file cannot be created
+	s.dir = dir + "/missing"
+	resp, release, err = s.read(bytes.NewReader(body), len(body), "broker:9092")
+	a.Nil(err)
+	a.Equal(body, resp)
+	release()
+}
+
+func TestProxyWithResponseSpill(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, Topics: map[string]int32{"test": 1}})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.ResponseSpillThreshold = 1
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	before := counterOf(a, proxySpilledResponsesTotal, broker.Addr())
+	// the brokers of the metadata response are rewritten
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 7, "test", kafkatest.MetadataRequestBody(1, []string{"test"})))
+	a.Nil(err)
+	correlationID, _, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(7), correlationID)
+	a.Equal(before+1, counterOf(a, proxySpilledResponsesTotal, broker.Addr()))
+}
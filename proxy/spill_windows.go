@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mmapFile maps the file of the size as shared writable memory
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READWRITE, uint32(uint64(size)>>32), uint32(size), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_WRITE, 0, 0, uintptr(size))
+	if err != nil {
+		syscall.CloseHandle(mapping)
+		return nil, nil, err
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(addr)), size)
+	return buf, func() error {
+		err := syscall.UnmapViewOfFile(addr)
+		if closeErr := syscall.CloseHandle(mapping); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
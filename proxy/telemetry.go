@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+const (
+	apiKeyGetTelemetrySubscriptions = int16(71)
+	apiKeyPushTelemetry             = int16(72)
+)
+
+// clientTelemetry strips the client telemetry requests (KIP-714) or terminates them at the proxy: the subscription is returned by the proxy
+// and the pushed metrics are forwarded to the OpenTelemetry collector of the proxy instead of the brokers
+type clientTelemetry struct {
+	mode                string
+	subscription        protocol.TelemetrySubscription
+	maxDecompressedSize int
+	// nil if the pushed metrics are counted only
+	exporter *OTLPExporter
+}
+
+// newClientTelemetry returns nil if the telemetry requests are forwarded to the brokers
+func newClientTelemetry(c *config.Config) *clientTelemetry {
+	switch c.Telemetry.Mode {
+	case config.TelemetryModeStrip:
+		logrus.Infof("Client telemetry api keys will not be advertised to the clients")
+	case config.TelemetryModeTerminate:
+		if c.OTLP.Endpoint == "" {
+			logrus.Warnf("Client telemetry is terminated at the proxy without an OTLP endpoint, the pushed metrics are only counted")
+		} else {
+			logrus.Infof("Client telemetry is terminated at the proxy and pushed to OTLP endpoint %s", c.OTLP.Endpoint)
+		}
+	default:
+		return nil
+	}
+	requestedMetrics := c.Telemetry.RequestedMetrics
+	if len(requestedMetrics) == 0 {
+		// the empty prefix requests all metrics
+		requestedMetrics = []string{""}
+	}
+	subscription := protocol.TelemetrySubscription{
+		AcceptedCompressionType: []int8{protocol.TelemetryCompressionGZIP, protocol.TelemetryCompressionNone},
+		PushIntervalMs:          int32(c.Telemetry.PushInterval / time.Millisecond),
+		TelemetryMaxBytes:       int32(c.Telemetry.MaxBytes),
+		DeltaTemporality:        c.Telemetry.DeltaTemporality,
+		RequestedMetrics:        requestedMetrics,
+	}
+	// clients get a new subscription when the proxy is restarted with other settings
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%d/%t/%q", subscription.PushIntervalMs, subscription.TelemetryMaxBytes, subscription.DeltaTemporality, subscription.RequestedMetrics)
+	subscription.SubscriptionID = int32(h.Sum32())
+	return &clientTelemetry{mode: c.Telemetry.Mode, subscription: subscription, maxDecompressedSize: c.Compression.MaxDecompressedSize}
+}
+
+// isForbidden hides the telemetry api keys when they are stripped and the versions which cannot be terminated
+func (t *clientTelemetry) isForbidden(apiKey int16, apiVersion int16) bool {
+	if t == nil || (apiKey != apiKeyGetTelemetrySubscriptions && apiKey != apiKeyPushTelemetry) {
+		return false
+	}
+	return t.mode == config.TelemetryModeStrip || apiVersion > 0
+}
+
+// respondsLocally reports whether the proxy writes responses to the clients
+func (t *clientTelemetry) respondsLocally() bool {
+	return t != nil && t.mode == config.TelemetryModeTerminate
+}
+
+// terminates reports whether the request is answered by the proxy and not forwarded to the broker
+func (t *clientTelemetry) terminates(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return t.respondsLocally() &&
+		(requestKeyVersion.ApiKey == apiKeyGetTelemetrySubscriptions || requestKeyVersion.ApiKey == apiKeyPushTelemetry)
+}
+
+// respond returns the response body of the terminated request, an error if the request cannot be decoded
+func (t *clientTelemetry) respond(requestKeyVersion *protocol.RequestKeyVersion, clientID string, body []byte) ([]byte, error) {
+	if requestKeyVersion.ApiKey == apiKeyGetTelemetrySubscriptions {
+		clientInstanceID, err := protocol.DecodeGetTelemetrySubscriptionsRequest(requestKeyVersion.ApiVersion, body)
+		if err != nil {
+			return nil, err
+		}
+		subscription := t.subscription
+		subscription.ClientInstanceID = clientInstanceID
+		if clientInstanceID == [16]byte{} {
+			if subscription.ClientInstanceID, err = newClientInstanceID(); err != nil {
+				return nil, err
+			}
+		}
+		return protocol.EncodeGetTelemetrySubscriptionsResponse(requestKeyVersion.ApiVersion, &subscription)
+	}
+	request, err := protocol.DecodePushTelemetryRequest(requestKeyVersion.ApiVersion, body)
+	if err != nil {
+		return nil, err
+	}
+	kerr := t.push(request, clientID)
+	proxyClientTelemetryPushesTotal.WithLabelValues(kerr.Name()).Inc()
+	return protocol.EncodePushTelemetryResponse(requestKeyVersion.ApiVersion, kerr)
+}
+
+func (t *clientTelemetry) push(request *protocol.PushTelemetryRequest, clientID string) protocol.KError {
+	if request.SubscriptionID != t.subscription.SubscriptionID {
+		return protocol.ErrUnknownSubscriptionID
+	}
+	if len(request.Metrics) > int(t.subscription.TelemetryMaxBytes) {
+		return protocol.ErrTelemetryTooLarge
+	}
+	if request.CompressionType != protocol.TelemetryCompressionNone && request.CompressionType != protocol.TelemetryCompressionGZIP {
+		return protocol.ErrUnsupportedCompressionType
+	}
+	metrics, err := request.DecompressedMetrics(t.maxDecompressedSize)
+	if err != nil {
+		logrus.Debugf("Telemetry of client %q cannot be decompressed: %v", clientID, err)
+		return protocol.ErrInvalidRecord
+	}
+	proxyClientTelemetryBytesTotal.Add(float64(len(metrics)))
+	if t.exporter != nil && len(metrics) != 0 && !t.exporter.Forward(metrics) {
+		proxyOTLPExportErrorsTotal.Inc()
+		logrus.Debugf("Telemetry of client %q is dropped, the OTLP export queue is full", clientID)
+	}
+	return protocol.ErrNoError
+}
+
+func newClientInstanceID() ([16]byte, error) {
+	var id [16]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return id, err
+	}
+	// random UUID version 4
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// handleTelemetryRequest answers the terminated telemetry request. The response is written when the responses of the broker
+// to the previous requests were written, so the client receives the responses in the order of the requests.
+func (ctx *RequestsLoopContext) handleTelemetryRequest(src DeadlineReaderWriter, requestKeyVersion *protocol.RequestKeyVersion) (readErr bool, err error) {
+	if requestKeyVersion.Length > protocol.MaxRequestSize {
+		return true, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", requestKeyVersion.Length)}
+	}
+	deadline := time.Now().Add(ctx.timeout)
+	if err = src.SetReadDeadline(deadline); err != nil {
+		return true, err
+	}
+	headerBuf, err := ctx.readRequestHeader(src, requestKeyVersion)
+	if err != nil {
+		return true, err
+	}
+	if err = ctx.checkClientID(); err != nil {
+		return true, err
+	}
+	req := make([]byte, int(requestKeyVersion.Length-4)-len(headerBuf))
+	if _, err = io.ReadFull(src, req); err != nil {
+		return true, err
+	}
+	resp, err := ctx.telemetry.respond(requestKeyVersion, ctx.clientID, req)
+	if err != nil {
+		return true, err
+	}
+	// the body starts with the tagged fields of the response header
+	if err = ctx.writeLocalResponse(src, headerBuf, resp, 0); err != nil {
+		return false, err
+	}
+	// no response of the broker is expected
+	return false, ctx.putNextRequestHandler(defaultRequestHandler)
+}
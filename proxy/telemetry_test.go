@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func pushTelemetryBody(subscriptionID int32, compressionType int8, metrics []byte) []byte {
+	body := append([]byte{0}, make([]byte, 16)...)
+	body = append(body, byte(subscriptionID>>24), byte(subscriptionID>>16), byte(subscriptionID>>8), byte(subscriptionID), 0, byte(compressionType))
+	body = append(body, byte(len(metrics)+1))
+	return append(append(body, metrics...), 0)
+}
+
+func TestClientTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(newClientTelemetry(c))
+
+	c.Telemetry.Mode = config.TelemetryModeStrip
+	telemetry := newClientTelemetry(c)
+	a.True(telemetry.isForbidden(apiKeyPushTelemetry, 0))
+	a.True(telemetry.isForbidden(apiKeyGetTelemetrySubscriptions, 0))
+	a.False(telemetry.isForbidden(apiKeyMetadata, 0))
+	a.False(telemetry.terminates(&protocol.RequestKeyVersion{ApiKey: apiKeyPushTelemetry}))
+	a.False(telemetry.respondsLocally())
+
+	c.Telemetry.Mode = config.TelemetryModeTerminate
+	c.Telemetry.MaxBytes = 16
+	telemetry = newClientTelemetry(c)
+	telemetry.exporter = &OTLPExporter{forwarded: make(chan []byte, 1)}
+	a.False(telemetry.isForbidden(apiKeyPushTelemetry, 0))
+	a.True(telemetry.isForbidden(apiKeyPushTelemetry, 1))
+	a.True(telemetry.respondsLocally())
+	a.True(telemetry.terminates(&protocol.RequestKeyVersion{ApiKey: apiKeyGetTelemetrySubscriptions}))
+	a.False(telemetry.terminates(&protocol.RequestKeyVersion{ApiKey: apiKeyMetadata}))
+	a.Equal([]string{""}, telemetry.subscription.RequestedMetrics)
+
+	// a new client instance id is assigned
+	resp, err := telemetry.respond(&protocol.RequestKeyVersion{ApiKey: apiKeyGetTelemetrySubscriptions}, "client-1", make([]byte, 18))
+	a.Nil(err)
+	a.Len(resp, 1+6+16+4+3+4+4+1+2+1)
+	a.NotEqual(make([]byte, 16), resp[7:23])
+	a.Equal(byte(0x40), resp[7+6]&0xf0)
+
+	subscriptionID := telemetry.subscription.SubscriptionID
+	push := &protocol.RequestKeyVersion{ApiKey: apiKeyPushTelemetry}
+	resp, err = telemetry.respond(push, "client-1", pushTelemetryBody(subscriptionID, protocol.TelemetryCompressionNone, []byte("metrics")))
+	a.Nil(err)
+	a.Equal([]byte{0, 0, 0, 0, 0, 0, 0, 0}, resp)
+	a.Equal([]byte("metrics"), <-telemetry.exporter.forwarded)
+
+	for _, tc := range []struct {
+		body     []byte
+		expected protocol.KError
+	}{
+		{pushTelemetryBody(subscriptionID+1, protocol.TelemetryCompressionNone, []byte("metrics")), protocol.ErrUnknownSubscriptionID},
+		{pushTelemetryBody(subscriptionID, protocol.TelemetryCompressionNone, make([]byte, 17)), protocol.ErrTelemetryTooLarge},
+		{pushTelemetryBody(subscriptionID, 4, []byte("metrics")), protocol.ErrUnsupportedCompressionType},
+		{pushTelemetryBody(subscriptionID, protocol.TelemetryCompressionGZIP, []byte("metrics")), protocol.ErrInvalidRecord},
+	} {
+		before := counterOf(a, proxyClientTelemetryPushesTotal, tc.expected.Name())
+		resp, err = telemetry.respond(push, "client-1", tc.body)
+		a.Nil(err)
+		a.Equal([]byte{0, 0, 0, 0, 0, byte(uint16(tc.expected) >> 8), byte(tc.expected), 0}, resp)
+		a.Equal(before+1, counterOf(a, proxyClientTelemetryPushesTotal, tc.expected.Name()))
+	}
+
+	_, err = telemetry.respond(push, "client-1", []byte{0, 1, 2})
+	a.NotNil(err)
+
+	// nil safe
+	var forwarded *clientTelemetry
+	a.False(forwarded.isForbidden(apiKeyPushTelemetry, 0))
+	a.False(forwarded.terminates(push))
+	a.False(forwarded.respondsLocally())
+}
+
+func TestProxyTerminatesClientTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Telemetry.Mode = config.TelemetryModeTerminate
+	listenerAddress, stop := startTestProxy(a, c)
+	defer stop()
+
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(kafkatest.EncodeRequest(apiKeyGetTelemetrySubscriptions, 0, 1, "app-1", make([]byte, 18)))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+	// header tagged fields, throttle_time_ms, error_code, client instance id, subscription id
+	subscriptionID := int32(binary.BigEndian.Uint32(body[23:]))
+
+	// the response of the broker to the pipelined Metadata request is written first
+	request := kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 2, "app-1", kafkatest.MetadataRequestBody(1, nil))
+	request = append(request, kafkatest.EncodeRequest(apiKeyPushTelemetry, 0, 3, "app-1", pushTelemetryBody(subscriptionID, protocol.TelemetryCompressionNone, []byte("metrics")))...)
+	_, err = conn.Write(request)
+	a.Nil(err)
+	correlationID, _, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(2), correlationID)
+	correlationID, body, err = kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(3), correlationID)
+	a.Equal([]byte{0, 0, 0, 0, 0, 0, 0, 0}, body)
+
+	a.Equal(0, broker.RequestCount(apiKeyGetTelemetrySubscriptions))
+	a.Equal(0, broker.RequestCount(apiKeyPushTelemetry))
+	a.Equal(1, broker.RequestCount(kafkatest.ApiKeyMetadata))
+}
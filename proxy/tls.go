@@ -21,6 +21,9 @@ var (
 		"P256":   tls.CurveP256,
 		"P384":   tls.CurveP384,
 		"P521":   tls.CurveP521,
+		// post-quantum hybrid key exchanges of TLS 1.3, ignored by the Go versions which don't implement them
+		"X25519MLKEM768":        tls.CurveID(0x11ec), // tls.X25519MLKEM768 of Go 1.24
+		"X25519Kyber768Draft00": tls.CurveID(0x6399), // draft key exchange of Go 1.23
 	}
 
 	defaultCipherSuites = []uint16{
@@ -48,23 +51,128 @@ var (
 		"ECDHE-RSA-3DES-EDE-CBC-SHA":         tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
 		"RSA-3DES-EDE-CBC-SHA":               tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
 	}
+
+	supportedVersionsMap = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": 0x0304, // tls.VersionTLS13 of Go 1.12
+	}
 )
 
 func newTLSListenerConfig(conf *config.Config) (*tls.Config, error) {
-	opts := conf.Proxy.TLS
+	return newListenerTLSConfig(conf.ListenerTLSOf(""))
+}
+
+// newListenerTLSConfig returns the TLS configuration of a proxy listener
+func newListenerTLSConfig(opts config.ListenerTLS) (*tls.Config, error) {
+	var cfg *tls.Config
+	var err error
+	if opts.ACMECertificate() {
+		// GetCertificate is set by the ACME manager
+		cfg, err = newServerTLSConfigOf(nil, opts.CAChainCertFile, opts.CipherSuites, opts.CurvePreferences)
+	} else if opts.SignsWithKeySigner() {
+		// the private key is set by the key signer
+		var cert tls.Certificate
+		if cert, err = loadCertificateChain(opts.CertFile); err == nil {
+			cfg, err = newServerTLSConfigOf([]tls.Certificate{cert}, opts.CAChainCertFile, opts.CipherSuites, opts.CurvePreferences)
+		}
+	} else {
+		cfg, err = newServerTLSConfig(opts.CertFile, opts.KeyFile, opts.KeyPassword, opts.CAChainCertFile, opts.CipherSuites, opts.CurvePreferences)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch opts.ClientAuth {
+	case config.TLSClientAuthNone:
+		cfg.ClientAuth = tls.NoClientCert
+	case config.TLSClientAuthRequestAny:
+		// the certificates are verified by the client auth audit after the handshake
+		cfg.ClientAuth = tls.RequestClientCert
+	case config.TLSClientAuthWarn, config.TLSClientAuthRequest:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.TLSClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if opts.MinVersion != "" {
+		minVersion, ok := supportedVersionsMap[opts.MinVersion]
+		if !ok {
+			return nil, errors.Errorf("invalid TLS version '%s' selected", opts.MinVersion)
+		}
+		cfg.MinVersion = minVersion
+	}
+	cfg.SessionTicketsDisabled = !opts.SessionTicketsEnabled()
+	if opts.FIPS {
+		restrictToFIPS(cfg)
+	}
+	if len(opts.ALPNProtocols) != 0 {
+		cfg.NextProtos = opts.ALPNProtocols
+		if opts.ALPNEnforced() {
+			cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if !containsAny(opts.ALPNProtocols, hello.SupportedProtos) {
+					proxyRejectedConnectionsTotal.WithLabelValues(hello.Conn.LocalAddr().String(), rejectReasonALPN).Inc()
+					return nil, errors.Errorf("client %v offered ALPN protocols %v, one of %v is required", hello.Conn.RemoteAddr(), hello.SupportedProtos, opts.ALPNProtocols)
+				}
+				return nil, nil
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// chainVerifyPeerCertificate returns the verification calling the next one only if the first one succeeds, nil functions are skipped
+func chainVerifyPeerCertificate(first, next func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	if first == nil {
+		return next
+	}
+	if next == nil {
+		return first
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if err := first(rawCerts, verifiedChains); err != nil {
+			return err
+		}
+		return next(rawCerts, verifiedChains)
+	}
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		for _, value := range values {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewHTTPTLSConfig returns the TLS configuration of the HTTP endpoints, client certificates are required if Http.TLS.CAChainCertFile is set
+func NewHTTPTLSConfig(conf *config.Config) (*tls.Config, error) {
+	opts := conf.Http.TLS
+	cfg, err := newServerTLSConfig(opts.ListenerCertFile, opts.ListenerKeyFile, opts.ListenerKeyPassword, opts.CAChainCertFile, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if conf.FIPS.Enable {
+		restrictToFIPS(cfg)
+	}
+	return cfg, nil
+}
 
-	if opts.ListenerKeyFile == "" || opts.ListenerCertFile == "" {
+func newServerTLSConfig(certFile, keyFile, keyPassword, caChainCertFile string, enabledCipherSuites, enabledCurvePreferences []string) (*tls.Config, error) {
+	if keyFile == "" || certFile == "" {
 		return nil, errors.New("Listener key and cert files must not be empty")
 	}
-	certPEMBlock, err := ioutil.ReadFile(opts.ListenerCertFile)
+	certPEMBlock, err := ioutil.ReadFile(certFile)
 	if err != nil {
 		return nil, err
 	}
-	keyPEMBlock, err := ioutil.ReadFile(opts.ListenerKeyFile)
+	keyPEMBlock, err := ioutil.ReadFile(keyFile)
 	if err != nil {
 		return nil, err
 	}
-	keyPEMBlock, err = decryptPEM(keyPEMBlock, opts.ListenerKeyPassword)
+	keyPEMBlock, err = decryptPEM(keyPEMBlock, keyPassword)
 	if err != nil {
 		return nil, err
 	}
@@ -72,25 +180,53 @@ func newTLSListenerConfig(conf *config.Config) (*tls.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	cipherSuites, err := getCipherSuites(opts.ListenerCipherSuites)
+	return newServerTLSConfigOf([]tls.Certificate{cert}, caChainCertFile, enabledCipherSuites, enabledCurvePreferences)
+}
+
+// loadCertificateChain returns the certificate chain of the PEM file without the private key
+func loadCertificateChain(certFile string) (tls.Certificate, error) {
+	certPEMBlock, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, certPEMBlock = pem.Decode(certPEMBlock)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.Errorf("no certificate found in %s", certFile)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+func newServerTLSConfigOf(certificates []tls.Certificate, caChainCertFile string, enabledCipherSuites, enabledCurvePreferences []string) (*tls.Config, error) {
+	cipherSuites, err := getCipherSuites(enabledCipherSuites)
 	if err != nil {
 		return nil, err
 	}
-	curvePreferences, err := getCurvePreferences(opts.ListenerCurvePreferences)
+	curvePreferences, err := getCurvePreferences(enabledCurvePreferences)
 	if err != nil {
 		return nil, err
 	}
 
 	cfg := &tls.Config{
-		Certificates:             []tls.Certificate{cert},
+		Certificates:             certificates,
 		ClientAuth:               tls.NoClientCert,
 		PreferServerCipherSuites: true,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         curvePreferences,
 		CipherSuites:             cipherSuites,
 	}
-	if opts.CAChainCertFile != "" {
-		caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+	if caChainCertFile != "" {
+		caCertPEMBlock, err := ioutil.ReadFile(caChainCertFile)
 		if err != nil {
 			return nil, err
 		}
@@ -173,6 +309,20 @@ func newTLSClientConfig(conf *config.Config) (*tls.Config, error) {
 
 		cfg.RootCAs = rootCAs
 	}
+	if len(opts.CurvePreferences) != 0 {
+		curvePreferences, err := getCurvePreferences(opts.CurvePreferences)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CurvePreferences = curvePreferences
+	}
+	cfg.NextProtos = opts.ALPNProtocols
+	if opts.SessionCacheSize > 0 {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(opts.SessionCacheSize)
+	}
+	if conf.FIPS.Enable {
+		restrictToFIPS(cfg)
+	}
 	return cfg, nil
 }
 
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/tls"
 	"crypto/x509"
 	"github.com/armon/go-socks5"
 	"github.com/grepplabs/kafka-proxy/config"
@@ -514,3 +515,176 @@ QQ7OBKTbsAxGV+wTiyb//9BChqcIlwgBwYwmEEUWCnV/RrxVeGIPy09ptjd/
 		a.EqualValues(sameKey, data.pemData)
 	}
 }
+
+func TestListenerTLSOverrides(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.CAChainCertFile = bundle.CACert.Name()
+
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	a.Equal(tls.RequireAndVerifyClientCert, serverConfig.ClientAuth)
+	a.Equal(uint16(tls.VersionTLS12), serverConfig.MinVersion)
+
+	c.Proxy.ListenerTLS = []config.ListenerTLS{{ListenerAddress: "127.0.0.1:32400", ClientAuth: config.TLSClientAuthRequest, MinVersion: "1.1"}}
+	serverConfig, err = newListenerTLSConfig(c.ListenerTLSOf("127.0.0.1:32400"))
+	a.Nil(err)
+	a.Equal(tls.VerifyClientCertIfGiven, serverConfig.ClientAuth)
+	a.Equal(uint16(tls.VersionTLS11), serverConfig.MinVersion)
+
+	// the global settings are not changed by the override
+	serverConfig, err = newListenerTLSConfig(c.ListenerTLSOf("127.0.0.1:32401"))
+	a.Nil(err)
+	a.Equal(tls.RequireAndVerifyClientCert, serverConfig.ClientAuth)
+}
+
+func TestListenerALPNRequired(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	required := true
+	serverConfig, err := newListenerTLSConfig(config.ListenerTLS{
+		CertFile:      bundle.ServerCert.Name(),
+		KeyFile:       bundle.ServerKey.Name(),
+		ALPNProtocols: []string{"kafka"},
+		ALPNRequired:  &required,
+	})
+	a.Nil(err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	a.Nil(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "kafka"}})
+	a.Nil(err)
+	a.Equal("kafka", conn.ConnectionState().NegotiatedProtocol)
+	conn.Close()
+
+	_, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	a.NotNil(err)
+	_, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	a.NotNil(err)
+}
+
+func TestBrokerALPNRequired(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	a.Nil(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	c.Kafka.TLS.InsecureSkipVerify = true
+	c.Kafka.TLS.ALPNProtocols = []string{"kafka"}
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	a.Equal([]string{"kafka"}, clientConfig.NextProtos)
+
+	dialer := tlsDialer{timeout: 3 * time.Second, rawDialer: directDialer{dialTimeout: 3 * time.Second}, config: clientConfig}
+	conn, err := dialer.Dial("tcp", ln.Addr().String())
+	a.Nil(err)
+	conn.Close()
+
+	// the broker doesn't support ALPN
+	dialer.alpnRequired = true
+	_, err = dialer.Dial("tcp", ln.Addr().String())
+	a.EqualError(err, "broker "+ln.Addr().String()+" selected none of the ALPN protocols [kafka]")
+}
+
+func TestFIPSRestrictsTLSConfigs(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.FIPS.Enable = true
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	a.Equal(fipsCipherSuites, serverConfig.CipherSuites)
+	a.Equal([]tls.CurveID{tls.CurveP256}, serverConfig.CurvePreferences)
+
+	c.Proxy.TLS.ListenerCipherSuites = []string{"ECDHE-RSA-AES128-GCM-SHA256"}
+	serverConfig, err = newTLSListenerConfig(c)
+	a.Nil(err)
+	a.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, serverConfig.CipherSuites)
+
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	a.Equal(fipsCipherSuites, clientConfig.CipherSuites)
+	a.Equal(fipsCurvePreferences, clientConfig.CurvePreferences)
+	a.Equal(uint16(tls.VersionTLS12), clientConfig.MinVersion)
+
+	c.FIPS.Enable = false
+	clientConfig, err = newTLSClientConfig(c)
+	a.Nil(err)
+	a.Nil(clientConfig.CipherSuites)
+}
+
+func TestHybridCurvePreferences(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.ListenerCurvePreferences = []string{"X25519MLKEM768", "X25519"}
+	serverConfig, err := newTLSListenerConfig(c)
+	a.Nil(err)
+	a.Equal([]tls.CurveID{tls.CurveID(0x11ec), tls.X25519}, serverConfig.CurvePreferences)
+
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	a.Nil(clientConfig.CurvePreferences)
+
+	c.Kafka.TLS.CurvePreferences = []string{"X25519Kyber768Draft00", "P256"}
+	clientConfig, err = newTLSClientConfig(c)
+	a.Nil(err)
+	a.Equal([]tls.CurveID{tls.CurveID(0x6399), tls.CurveP256}, clientConfig.CurvePreferences)
+
+	c.Kafka.TLS.CurvePreferences = []string{"Kyber"}
+	_, err = newTLSClientConfig(c)
+	a.EqualError(err, "invalid curveID 'Kyber' selected")
+}
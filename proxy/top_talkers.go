@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// the sliding window is divided into the buckets
+	talkerBuckets          = 60
+	defaultTopTalkersLimit = 10
+
+	TopTalkersByBytes    = "bytes"
+	TopTalkersByRequests = "requests"
+
+	TopTalkersGroupConnection = "connection"
+	TopTalkersGroupPrincipal  = "principal"
+	TopTalkersGroupClientID   = "client_id"
+)
+
+// TopTalker are the bytes and requests of a connection, a principal or a client id within the sliding window
+type TopTalker struct {
+	ClientAddress     string  `json:"client_address,omitempty"`
+	Broker            string  `json:"broker,omitempty"`
+	Principal         string  `json:"principal"`
+	ClientID          string  `json:"client_id"`
+	Connections       int     `json:"connections"`
+	Requests          int64   `json:"requests"`
+	RequestBytes      int64   `json:"request_bytes"`
+	ResponseBytes     int64   `json:"response_bytes"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+}
+
+type topTalkersJSON struct {
+	Window  string      `json:"window"`
+	By      string      `json:"by"`
+	Group   string      `json:"group"`
+	Talkers []TopTalker `json:"talkers"`
+}
+
+// TopTalkers counts the bytes and requests of the client connections over a sliding window,
+// so the connections, principals or client ids sending most bytes or requests can be reported e.g. with the HTTP admin API
+type TopTalkers struct {
+	window     time.Duration
+	bucketSize time.Duration
+	now        func() time.Time
+
+	lock sync.Mutex
+	// closed connections are kept until their traffic left the window
+	talkers map[*talker]struct{}
+}
+
+// NewTopTalkers returns nil if the top talkers are not reported by the admin API
+func NewTopTalkers(c *config.Config) *TopTalkers {
+	if !c.TopTalkers.AdminEnable {
+		return nil
+	}
+	return &TopTalkers{
+		window:     c.TopTalkers.Window,
+		bucketSize: c.TopTalkers.Window / talkerBuckets,
+		now:        time.Now,
+		talkers:    make(map[*talker]struct{}),
+	}
+}
+
+// register starts counting the traffic of the client connection until unregister is called
+func (t *TopTalkers) register(clientAddress string, brokerAddress string) *talker {
+	if t == nil {
+		return nil
+	}
+	result := &talker{topTalkers: t, clientAddress: clientAddress, brokerAddress: brokerAddress}
+	t.lock.Lock()
+	t.talkers[result] = struct{}{}
+	t.lock.Unlock()
+	return result
+}
+
+func (t *TopTalkers) unregister(talker *talker) {
+	if t == nil || talker == nil {
+		return
+	}
+	talker.lock.Lock()
+	talker.closed = t.now()
+	talker.lock.Unlock()
+}
+
+// Top returns the first limit talkers grouped by the connection, principal or client id with most bytes or requests
+func (t *TopTalkers) Top(by string, group string, limit int) ([]TopTalker, error) {
+	if by != TopTalkersByBytes && by != TopTalkersByRequests {
+		return nil, fmt.Errorf("by must be %s or %s, got '%s'", TopTalkersByBytes, TopTalkersByRequests, by)
+	}
+	if group != TopTalkersGroupConnection && group != TopTalkersGroupPrincipal && group != TopTalkersGroupClientID {
+		return nil, fmt.Errorf("group must be %s, %s or %s, got '%s'", TopTalkersGroupConnection, TopTalkersGroupPrincipal, TopTalkersGroupClientID, group)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be greater than 0, got %d", limit)
+	}
+	now := t.now()
+	t.lock.Lock()
+	talkers := make([]*talker, 0, len(t.talkers))
+	for talker := range t.talkers {
+		talker.lock.Lock()
+		expired := !talker.closed.IsZero() && now.Sub(talker.closed) >= t.window
+		talker.lock.Unlock()
+		if expired {
+			delete(t.talkers, talker)
+			continue
+		}
+		talkers = append(talkers, talker)
+	}
+	t.lock.Unlock()
+
+	groups := make(map[string]*TopTalker)
+	for _, talker := range talkers {
+		current := talker.snapshot(now)
+		var key string
+		switch group {
+		case TopTalkersGroupConnection:
+			key = current.ClientAddress + " " + current.Broker
+		case TopTalkersGroupPrincipal:
+			key = current.Principal
+			current.ClientAddress, current.Broker, current.ClientID = "", "", ""
+		case TopTalkersGroupClientID:
+			key = current.ClientID
+			current.ClientAddress, current.Broker, current.Principal = "", "", ""
+		}
+		if total, ok := groups[key]; ok {
+			total.Connections += current.Connections
+			total.Requests += current.Requests
+			total.RequestBytes += current.RequestBytes
+			total.ResponseBytes += current.ResponseBytes
+		} else {
+			groups[key] = &current
+		}
+	}
+	result := make([]TopTalker, 0, len(groups))
+	seconds := t.window.Seconds()
+	for _, total := range groups {
+		total.RequestsPerSecond = float64(total.Requests) / seconds
+		total.BytesPerSecond = float64(total.RequestBytes+total.ResponseBytes) / seconds
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		var vi, vj int64
+		if by == TopTalkersByBytes {
+			vi, vj = result[i].RequestBytes+result[i].ResponseBytes, result[j].RequestBytes+result[j].ResponseBytes
+		} else {
+			vi, vj = result[i].Requests, result[j].Requests
+		}
+		if vi != vj {
+			return vi > vj
+		}
+		// stable order of the equal talkers
+		if result[i].Principal != result[j].Principal {
+			return result[i].Principal < result[j].Principal
+		}
+		if result[i].ClientID != result[j].ClientID {
+			return result[i].ClientID < result[j].ClientID
+		}
+		return result[i].ClientAddress+result[i].Broker < result[j].ClientAddress+result[j].Broker
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// ServeHTTP returns the top talkers on GET, the query parameters by (bytes or requests), group (connection, principal or client_id)
+// and limit default to bytes, connection and 10
+func (t *TopTalkers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	by := query.Get("by")
+	if by == "" {
+		by = TopTalkersByBytes
+	}
+	group := query.Get("group")
+	if group == "" {
+		group = TopTalkersGroupConnection
+	}
+	limit := defaultTopTalkersLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("limit '%s' is not a number", v), http.StatusBadRequest)
+			return
+		}
+	}
+	talkers, err := t.Top(by, group, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topTalkersJSON{Window: t.window.String(), By: by, Group: group, Talkers: talkers})
+}
+
+type talkerBucket struct {
+	// number of the bucket since the epoch
+	index         int64
+	requests      int64
+	requestBytes  int64
+	responseBytes int64
+}
+
+// talker counts the traffic of a client connection in the buckets of the sliding window
+type talker struct {
+	topTalkers    *TopTalkers
+	clientAddress string
+	brokerAddress string
+
+	lock      sync.Mutex
+	principal string
+	clientID  string
+	closed    time.Time
+	buckets   [talkerBuckets]talkerBucket
+}
+
+// update sets the principal or the client id when they are changed
+func (t *talker) update(principal string, clientID string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.principal, t.clientID = principal, clientID
+	t.lock.Unlock()
+}
+
+func (t *talker) request(size int32) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	bucket := t.bucket(t.topTalkers.now())
+	bucket.requests++
+	bucket.requestBytes += int64(size)
+	t.lock.Unlock()
+}
+
+func (t *talker) response(size int32) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	bucket := t.bucket(t.topTalkers.now())
+	bucket.responseBytes += int64(size)
+	t.lock.Unlock()
+}
+
+// bucket returns the current bucket which is reset if it was used by an earlier window, the lock must be held
+func (t *talker) bucket(now time.Time) *talkerBucket {
+	index := now.UnixNano() / int64(t.topTalkers.bucketSize)
+	bucket := &t.buckets[index%talkerBuckets]
+	if bucket.index != index {
+		*bucket = talkerBucket{index: index}
+	}
+	return bucket
+}
+
+// snapshot sums the buckets of the sliding window
+func (t *talker) snapshot(now time.Time) TopTalker {
+	index := now.UnixNano() / int64(t.topTalkers.bucketSize)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := TopTalker{ClientAddress: t.clientAddress, Broker: t.brokerAddress, Principal: t.principal, ClientID: t.clientID, Connections: 1}
+	if !t.closed.IsZero() {
+		result.Connections = 0
+	}
+	for _, bucket := range t.buckets {
+		if bucket.index > index-talkerBuckets && bucket.index <= index {
+			result.Requests += bucket.requests
+			result.RequestBytes += bucket.requestBytes
+			result.ResponseBytes += bucket.responseBytes
+		}
+	}
+	return result
+}
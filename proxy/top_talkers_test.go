@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTopTalkers(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	a.Nil(NewTopTalkers(c))
+	c.TopTalkers.AdminEnable = true
+	topTalkers := NewTopTalkers(c)
+	now := time.Unix(1000, 0)
+	topTalkers.now = func() time.Time { return now }
+
+	alice1 := topTalkers.register("10.0.0.1:50000", "kafka-1:9092")
+	alice1.update("alice", "producer")
+	alice2 := topTalkers.register("10.0.0.1:50001", "kafka-2:9092")
+	alice2.update("alice", "producer")
+	bob := topTalkers.register("10.0.0.2:50000", "kafka-1:9092")
+	bob.update("bob", "consumer")
+
+	for i := 0; i < 3; i++ {
+		alice1.request(1000)
+		alice1.response(100)
+	}
+	alice2.request(1000)
+	bob.request(100)
+	bob.request(100)
+	bob.response(60000)
+
+	top, err := topTalkers.Top(TopTalkersByBytes, TopTalkersGroupConnection, 2)
+	a.Nil(err)
+	a.Equal([]TopTalker{
+		{ClientAddress: "10.0.0.2:50000", Broker: "kafka-1:9092", Principal: "bob", ClientID: "consumer", Connections: 1, Requests: 2, RequestBytes: 200, ResponseBytes: 60000, RequestsPerSecond: 2.0 / 60, BytesPerSecond: 60200.0 / 60},
+		{ClientAddress: "10.0.0.1:50000", Broker: "kafka-1:9092", Principal: "alice", ClientID: "producer", Connections: 1, Requests: 3, RequestBytes: 3000, ResponseBytes: 300, RequestsPerSecond: 3.0 / 60, BytesPerSecond: 3300.0 / 60},
+	}, top)
+
+	top, err = topTalkers.Top(TopTalkersByRequests, TopTalkersGroupPrincipal, 10)
+	a.Nil(err)
+	a.Len(top, 2)
+	a.Equal(TopTalker{Principal: "alice", Connections: 2, Requests: 4, RequestBytes: 4000, ResponseBytes: 300, RequestsPerSecond: 4.0 / 60, BytesPerSecond: 4300.0 / 60}, top[0])
+	a.Equal("bob", top[1].Principal)
+
+	// closed connections are reported until their traffic left the window
+	topTalkers.unregister(alice1)
+	now = now.Add(30 * time.Second)
+	alice2.request(1000)
+	top, err = topTalkers.Top(TopTalkersByRequests, TopTalkersGroupClientID, 10)
+	a.Nil(err)
+	a.Equal(TopTalker{ClientID: "producer", Connections: 1, Requests: 5, RequestBytes: 5000, ResponseBytes: 300, RequestsPerSecond: 5.0 / 60, BytesPerSecond: 5300.0 / 60}, top[0])
+
+	now = now.Add(30 * time.Second)
+	top, err = topTalkers.Top(TopTalkersByRequests, TopTalkersGroupConnection, 10)
+	a.Nil(err)
+	a.Len(top, 2)
+	a.Equal("10.0.0.1:50001", top[0].ClientAddress)
+	a.Equal(int64(1), top[0].Requests)
+	a.Equal("10.0.0.2:50000", top[1].ClientAddress)
+	a.Equal(int64(0), top[1].Requests)
+	a.Len(topTalkers.talkers, 2)
+
+	_, err = topTalkers.Top("latency", TopTalkersGroupConnection, 10)
+	a.EqualError(err, "by must be bytes or requests, got 'latency'")
+	_, err = topTalkers.Top(TopTalkersByBytes, "topic", 10)
+	a.EqualError(err, "group must be connection, principal or client_id, got 'topic'")
+	_, err = topTalkers.Top(TopTalkersByBytes, TopTalkersGroupConnection, 0)
+	a.EqualError(err, "limit must be greater than 0, got 0")
+
+	// nil safe
+	var disabled *TopTalkers
+	a.Nil(disabled.register("10.0.0.1:50000", "kafka-1:9092"))
+	disabled.unregister(nil)
+	var disabledTalker *talker
+	disabledTalker.update("alice", "producer")
+	disabledTalker.request(100)
+	disabledTalker.response(100)
+}
+
+func TestTopTalkersHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.TopTalkers.AdminEnable = true
+	topTalkers := NewTopTalkers(c)
+	talker := topTalkers.register("10.0.0.1:50000", "kafka-1:9092")
+	talker.update("alice", "producer")
+	talker.request(100)
+
+	w := httptest.NewRecorder()
+	topTalkers.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/top-talkers?group=principal&limit=1", nil))
+	a.Equal(http.StatusOK, w.Code)
+	var result topTalkersJSON
+	a.Nil(json.NewDecoder(w.Body).Decode(&result))
+	a.Equal("1m0s", result.Window)
+	a.Equal(TopTalkersByBytes, result.By)
+	a.Equal(TopTalkersGroupPrincipal, result.Group)
+	a.Len(result.Talkers, 1)
+	a.Equal("alice", result.Talkers[0].Principal)
+	a.Equal(int64(100), result.Talkers[0].RequestBytes)
+
+	w = httptest.NewRecorder()
+	topTalkers.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/top-talkers?limit=x", nil))
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	topTalkers.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/top-talkers", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+)
+
+// topicMetrics counts the requests and the record batch bytes per topic of the Produce, Fetch and Metadata requests.
+// Only the allowlisted topics are labelled by name, the other topics are hashed into a fixed number of buckets
+// or labelled as other, so the number of the series is bounded.
+type topicMetrics struct {
+	topics      []*regexp.Regexp
+	hashBuckets int
+}
+
+// newTopicMetrics returns nil if the topics are not counted
+func newTopicMetrics(c *config.Config) (*topicMetrics, error) {
+	if !c.TopicMetrics.Enable {
+		return nil, nil
+	}
+	metrics := &topicMetrics{hashBuckets: c.TopicMetrics.HashBuckets}
+	for _, v := range c.TopicMetrics.Topics {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		metrics.topics = append(metrics.topics, re)
+	}
+	logrus.Infof("Requests of the topics %v will be counted, other topics are hashed into %d buckets", c.TopicMetrics.Topics, c.TopicMetrics.HashBuckets)
+	return metrics, nil
+}
+
+// inspects reports whether the request body must be decoded
+func (m *topicMetrics) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if m == nil {
+		return false
+	}
+	switch requestKeyVersion.ApiKey {
+	case apiKeyProduce, apiKeyFetch, apiKeyMetadata:
+		maxVersion, ok := protocol.TopicNamesMaxVersion(requestKeyVersion.ApiKey)
+		return ok && requestKeyVersion.ApiVersion <= maxVersion
+	default:
+		return false
+	}
+}
+
+// inspectsResponse reports whether the Fetch response body must be decoded
+func (m *topicMetrics) inspectsResponse(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	return m != nil && requestKeyVersion.ApiKey == apiKeyFetch && recordStatsVersion(requestKeyVersion)
+}
+
+// observeRequest counts the request once per topic and the produced record batch bytes
+func (m *topicMetrics) observeRequest(brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, req []byte) {
+	if m == nil {
+		return
+	}
+	topics, err := protocol.DecodeRequestTopicNames(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, req)
+	if err != nil {
+		logrus.Debugf("Topics of request key %d, version %d to %s cannot be decoded: %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, brokerAddress, err)
+		return
+	}
+	apiKey := strconv.Itoa(int(requestKeyVersion.ApiKey))
+	labels := make(map[string]bool)
+	for _, topic := range topics {
+		labels[m.topicLabel(topic)] = true
+	}
+	for label := range labels {
+		proxyTopicRequestsTotal.WithLabelValues(brokerAddress, apiKey, label).Inc()
+	}
+	if requestKeyVersion.ApiKey == apiKeyProduce && recordStatsVersion(requestKeyVersion) {
+		m.observeBytes(brokerAddress, "produce", requestKeyVersion, req, protocol.DecodeProduceRecordBatchStats)
+	}
+}
+
+// observeResponse counts the fetched record batch bytes as returned by the broker
+func (m *topicMetrics) observeResponse(brokerAddress string, requestKeyVersion *protocol.RequestKeyVersion, resp []byte) {
+	if m == nil {
+		return
+	}
+	m.observeBytes(brokerAddress, "fetch", requestKeyVersion, resp, protocol.DecodeFetchRecordBatchStats)
+}
+
+func (m *topicMetrics) observeBytes(brokerAddress string, direction string, requestKeyVersion *protocol.RequestKeyVersion, body []byte, decode func(int16, []byte, protocol.RecordBatchStatsFunc) error) {
+	bytes := make(map[string]int)
+	err := decode(requestKeyVersion.ApiVersion, body, func(topic string, size int, records int) {
+		bytes[m.topicLabel(topic)] += size
+	})
+	if err != nil {
+		logrus.Debugf("Record batches of %s key %d, version %d from %s cannot be decoded: %v", direction, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, brokerAddress, err)
+		return
+	}
+	for label, n := range bytes {
+		proxyTopicBytesTotal.WithLabelValues(brokerAddress, direction, label).Add(float64(n))
+	}
+}
+
+// topicLabel returns the name of an allowlisted topic, the hash bucket of the name or other
+func (m *topicMetrics) topicLabel(topic string) string {
+	for _, re := range m.topics {
+		if re.MatchString(topic) {
+			return topic
+		}
+	}
+	if m.hashBuckets == 0 {
+		return otherTopicsLabel
+	}
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	return fmt.Sprintf("hash-%d", h.Sum32()%uint32(m.hashBuckets))
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func counterOf(a *assert.Assertions, counter *prometheus.CounterVec, labels ...string) float64 {
+	m := &dto.Metric{}
+	a.Nil(counter.WithLabelValues(labels...).(prometheus.Metric).Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func TestTopicMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	metrics, err := newTopicMetrics(c)
+	a.Nil(err)
+	a.Nil(metrics)
+
+	c.TopicMetrics.Enable = true
+	c.TopicMetrics.Topics = []string{"^tm-orders$"}
+	c.TopicMetrics.HashBuckets = 4
+	metrics, err = newTopicMetrics(c)
+	a.Nil(err)
+
+	a.Equal("tm-orders", metrics.topicLabel("tm-orders"))
+	bucket := metrics.topicLabel("tm-events")
+	a.Regexp("^hash-[0-3]$", bucket)
+	a.Equal(bucket, metrics.topicLabel("tm-events"))
+	metrics.hashBuckets = 0
+	a.Equal(otherTopicsLabel, metrics.topicLabel("tm-events"))
+	metrics.hashBuckets = 4
+
+	produce := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyProduce, ApiVersion: 3}
+	metadata := &protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyMetadata, ApiVersion: 1}
+	a.True(metrics.inspects(produce))
+	a.True(metrics.inspects(metadata))
+	a.False(metrics.inspects(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyApiVersions, ApiVersion: 0}))
+	a.False(metrics.inspectsResponse(produce))
+	a.True(metrics.inspectsResponse(&protocol.RequestKeyVersion{ApiKey: kafkatest.ApiKeyFetch, ApiVersion: 4}))
+
+	body := kafkatest.ProduceRequestBody("tm-orders", [][]byte{[]byte("v1")})
+	metrics.observeRequest("kafka-tm:9092", produce, body)
+	metrics.observeRequest("kafka-tm:9092", produce, body)
+	metrics.observeRequest("kafka-tm:9092", metadata, kafkatest.MetadataRequestBody(1, []string{"tm-orders", "tm-events"}))
+
+	a.Equal(2.0, counterOf(a, proxyTopicRequestsTotal, "kafka-tm:9092", "0", "tm-orders"))
+	a.Equal(1.0, counterOf(a, proxyTopicRequestsTotal, "kafka-tm:9092", "3", "tm-orders"))
+	a.Equal(1.0, counterOf(a, proxyTopicRequestsTotal, "kafka-tm:9092", "3", bucket))
+	a.True(counterOf(a, proxyTopicBytesTotal, "kafka-tm:9092", "produce", "tm-orders") > 2*61)
+
+	// nil safe
+	var disabled *topicMetrics
+	a.False(disabled.inspects(produce))
+	disabled.observeRequest("kafka-tm:9092", produce, body)
+	disabled.observeResponse("kafka-tm:9092", produce, nil)
+}
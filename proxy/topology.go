@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// api version of the topology metadata requests
+const topologyMetadataVersion = int16(1)
+
+// topologyRefresher periodically fetches the upstream metadata and starts the listeners of new brokers, so the clients do not have
+// to send a Metadata request before the brokers are reachable. The dynamic listeners of the brokers which left the cluster are optionally closed.
+type topologyRefresher struct {
+	client           *Client
+	listeners        *Listeners
+	bootstrapServers []string
+	interval         time.Duration
+	retireListeners  bool
+
+	// used by the run goroutine only
+	brokers       map[int32]string
+	correlationID int32
+}
+
+// newTopologyRefresher returns nil if the metadata is not refreshed in the background
+func newTopologyRefresher(c *config.Config, client *Client, listeners *Listeners) *topologyRefresher {
+	if c.Topology.RefreshInterval == 0 {
+		return nil
+	}
+	bootstrapServers := make([]string, 0, len(c.Proxy.BootstrapServers))
+	for _, v := range c.Proxy.BootstrapServers {
+		bootstrapServers = append(bootstrapServers, v.BrokerAddress)
+	}
+	logrus.Infof("Upstream metadata will be refreshed every %v", c.Topology.RefreshInterval)
+	return &topologyRefresher{
+		client:           client,
+		listeners:        listeners,
+		bootstrapServers: bootstrapServers,
+		interval:         c.Topology.RefreshInterval,
+		retireListeners:  c.Topology.RetireListeners,
+	}
+}
+
+// run refreshes the metadata until done is closed
+func (t *topologyRefresher) run(done <-chan struct{}) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.refresh()
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (t *topologyRefresher) refresh() {
+	metadata, err := t.fetch()
+	if err != nil {
+		proxyTopologyRefreshErrorsTotal.Inc()
+		logrus.Infof("Upstream metadata refresh failed: %v", err)
+		return
+	}
+	t.reconcile(metadata.Brokers)
+}
+
+func (t *topologyRefresher) fetch() (*protocol.MetadataLeaders, error) {
+	// empty topics array requests the brokers only
+	body, err := protocol.Encode(&protocol.MetadataRequestV1{Topics: []string{}})
+	if err != nil {
+		return nil, err
+	}
+	for _, brokerAddress := range t.bootstrapServers {
+		var metadata *protocol.MetadataLeaders
+		if metadata, err = t.fetchFrom(brokerAddress, body); err == nil {
+			return metadata, nil
+		}
+		logrus.Debugf("Upstream metadata request to %s failed: %v", brokerAddress, err)
+	}
+	return nil, err
+}
+
+func (t *topologyRefresher) fetchFrom(brokerAddress string, body []byte) (*protocol.MetadataLeaders, error) {
+	_, address, err := t.client.upstream.route(brokerAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := t.client.bootstrap.dial(t.client.ctx, address, func(address string) (net.Conn, error) {
+		return t.client.DialAndAuth(t.client.ctx, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	t.correlationID++
+	frame := requestFrame(apiKeyMetadata, topologyMetadataVersion, t.correlationID, t.client.config.Kafka.ClientID, body)
+	return requestMetadataLeaders(conn, frame, t.client.config.Kafka.WriteTimeout, t.client.config.Kafka.ReadTimeout)
+}
+
+// reconcile records the broker changes and starts the listeners of the brokers, the dynamic listeners of the missing brokers are retired if enabled
+func (t *topologyRefresher) reconcile(brokers map[int32]string) {
+	if t.brokers != nil {
+		for _, nodeID := range sortedNodeIDs(brokers) {
+			previous, ok := t.brokers[nodeID]
+			switch {
+			case !ok:
+				proxyTopologyChangesTotal.WithLabelValues("added").Inc()
+				logrus.Infof("Upstream broker %d %s joined the cluster", nodeID, brokers[nodeID])
+			case previous != brokers[nodeID]:
+				proxyTopologyChangesTotal.WithLabelValues("moved").Inc()
+				logrus.Infof("Upstream broker %d moved from %s to %s", nodeID, previous, brokers[nodeID])
+			}
+		}
+		for _, nodeID := range sortedNodeIDs(t.brokers) {
+			if _, ok := brokers[nodeID]; !ok {
+				proxyTopologyChangesTotal.WithLabelValues("removed").Inc()
+				logrus.Infof("Upstream broker %d %s left the cluster", nodeID, t.brokers[nodeID])
+			}
+		}
+	}
+	t.brokers = brokers
+	proxyTopologyBrokers.Set(float64(len(brokers)))
+
+	keep := make(map[string]bool)
+	complete := len(brokers) != 0
+	for _, nodeID := range sortedNodeIDs(brokers) {
+		listenerAddress, err := t.listen(brokers[nodeID])
+		if err != nil {
+			logrus.Infof("Listener of upstream broker %d %s is not available: %v", nodeID, brokers[nodeID], err)
+			complete = false
+			continue
+		}
+		keep[listenerAddress] = true
+		t.listeners.SetNodeID(listenerAddress, nodeID)
+	}
+	// listeners are retired only when all brokers are mapped
+	if t.retireListeners && complete {
+		for _, brokerAddress := range t.listeners.RetireDynamicListeners(keep) {
+			proxyTopologyChangesTotal.WithLabelValues("retired").Inc()
+			logrus.Infof("Retired dynamic listener of %s", brokerAddress)
+		}
+	}
+}
+
+// listen returns the listener address of the broker, the dynamic listener is started if needed
+func (t *topologyRefresher) listen(brokerAddress string) (string, error) {
+	host, port, err := util.SplitHostPort(brokerAddress)
+	if err != nil {
+		return "", err
+	}
+	listenerHost, listenerPort, err := t.client.processorConfig.NetAddressMappingFunc(host, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(listenerHost, fmt.Sprint(listenerPort)), nil
+}
+
+func sortedNodeIDs(brokers map[int32]string) []int32 {
+	nodeIDs := make([]int32, 0, len(brokers))
+	for nodeID := range brokers {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+	return nodeIDs
+}
+
+// requestFrame returns the request with the size, request header v1 and the body
+func requestFrame(apiKey int16, apiVersion int16, correlationID int32, clientID string, body []byte) []byte {
+	// ApiKey, ApiVersion, CorrelationId, ClientId
+	headerSize := 2 + 2 + 4 + 2 + len(clientID)
+	frame := make([]byte, 4+headerSize, 4+headerSize+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(headerSize+len(body)))
+	binary.BigEndian.PutUint16(frame[4:], uint16(apiKey))
+	binary.BigEndian.PutUint16(frame[6:], uint16(apiVersion))
+	binary.BigEndian.PutUint32(frame[8:], uint32(correlationID))
+	binary.BigEndian.PutUint16(frame[12:], uint16(len(clientID)))
+	copy(frame[14:], clientID)
+	return append(frame, body...)
+}
+
+// requestMetadataLeaders writes the Metadata request frame and decodes the response
+func requestMetadataLeaders(conn net.Conn, frame []byte, writeTimeout time.Duration, readTimeout time.Duration) (*protocol.MetadataLeaders, error) {
+	apiVersion := int16(binary.BigEndian.Uint16(frame[6:]))
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(frame); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8) // Size => int32, CorrelationId => int32
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int32(binary.BigEndian.Uint32(header))
+	if length < 4 || length > protocol.MaxResponseSize {
+		return nil, fmt.Errorf("invalid metadata response length %d", length)
+	}
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	return protocol.DecodeMetadataLeaders(apiVersion, payload)
+}
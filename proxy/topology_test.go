@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func dynamicListenerConfig(p *Proxy, brokerAddress string) (config.ListenerConfig, bool) {
+	p.listeners.lock.RLock()
+	defer p.listeners.lock.RUnlock()
+	lc, ok := p.listeners.brokerToListenerConfig[brokerAddress]
+	return lc, ok
+}
+
+func TestTopologyRefresherStartsDynamicListeners(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1, AdvertisedAddress: "kafka-1.grepplabs.com:9092"})
+	a.Nil(err)
+	defer broker.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.BootstrapServers[0].ListenerAddress = "127.0.0.1:0"
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = "127.0.0.1:0"
+	c.Proxy.DisableDynamicListeners = false
+	c.Topology.RefreshInterval = 10 * time.Millisecond
+	p, err := New(c)
+	a.Nil(err)
+	go p.Run(context.Background())
+	defer p.Close()
+
+	// the listener is started without a client Metadata request
+	deadline := time.Now().Add(5 * time.Second)
+	lc, ok := dynamicListenerConfig(p, "kafka-1.grepplabs.com:9092")
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		lc, ok = dynamicListenerConfig(p, "kafka-1.grepplabs.com:9092")
+	}
+	a.True(ok)
+	conn, err := net.Dial("tcp", lc.ListenerAddress)
+	a.Nil(err)
+	conn.Close()
+	a.True(broker.RequestCount(kafkatest.ApiKeyMetadata) >= 1)
+}
+
+func TestListenersRetireDynamicListeners(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	listeners, err := NewListeners(c)
+	a.Nil(err)
+	defer listeners.Close()
+
+	host, port, err := listeners.ListenDynamicInstance("kafka-1.grepplabs.com:9092")
+	a.Nil(err)
+	kept := net.JoinHostPort(host, fmt.Sprint(port))
+	_, _, err = listeners.ListenDynamicInstance("kafka-2.grepplabs.com:9092")
+	a.Nil(err)
+	retiredAddress := listeners.brokerToListenerConfig["kafka-2.grepplabs.com:9092"].ListenerAddress
+
+	a.Equal([]string{"kafka-2.grepplabs.com:9092"}, listeners.RetireDynamicListeners(map[string]bool{kept: true}))
+	a.Len(listeners.listeners, 1)
+	_, ok := listeners.brokerToListenerConfig["kafka-2.grepplabs.com:9092"]
+	a.False(ok)
+	_, err = net.Dial("tcp", retiredAddress)
+	a.NotNil(err)
+	conn, err := net.Dial("tcp", kept)
+	a.Nil(err)
+	conn.Close()
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"regexp"
+)
+
+const apiKeyInitProducerId = int16(22)
+
+// transactionalApiKeys are the requests sent by transactional producers.
+// InitProducerId is sent also by idempotent producers.
+var transactionalApiKeys = map[int16]struct{}{
+	22: {}, // InitProducerId
+	24: {}, // AddPartitionsToTxn
+	25: {}, // AddOffsetsToTxn
+	26: {}, // EndTxn
+	28: {}, // TxnOffsetCommit
+}
+
+// transactionPolicy rejects the requests of transactional producers which principals are not allowed.
+// Idempotent producers are not restricted.
+type transactionPolicy struct {
+	// empty allows all principals which are not denied
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+	// the requests are not rejected if the policy is evaluated in a dry run
+	dryRun *policyDryRun
+}
+
+// newTransactionPolicy returns nil if transactional producers are not restricted
+func newTransactionPolicy(c *config.Config) (*transactionPolicy, error) {
+	if len(c.Transactions.AllowPrincipals) == 0 && len(c.Transactions.DenyPrincipals) == 0 {
+		return nil, nil
+	}
+	policy := &transactionPolicy{}
+	for _, v := range c.Transactions.AllowPrincipals {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		policy.allow = append(policy.allow, pattern)
+	}
+	for _, v := range c.Transactions.DenyPrincipals {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		policy.deny = append(policy.deny, pattern)
+	}
+	logrus.Infof("Transactional producers are allowed for principals matching %v and denied for principals matching %v", c.Transactions.AllowPrincipals, c.Transactions.DenyPrincipals)
+	return policy, nil
+}
+
+// isForbidden reports the InitProducerId versions which transactional id cannot be decoded
+func (p *transactionPolicy) isForbidden(apiKey int16, apiVersion int16) bool {
+	return p != nil && apiKey == apiKeyInitProducerId && apiVersion > protocol.InitProducerIdMaxVersion()
+}
+
+// inspects reports whether the request must be checked
+func (p *transactionPolicy) inspects(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := transactionalApiKeys[requestKeyVersion.ApiKey]
+	return ok
+}
+
+// allows reports whether the principal may use transactions, the principal is empty for not authenticated clients
+func (p *transactionPolicy) allows(principal string) bool {
+	for _, pattern := range p.deny {
+		if pattern.MatchString(principal) {
+			return false
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.allow {
+		if pattern.MatchString(principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error if the request is sent by a transactional producer of a not allowed principal
+func (p *transactionPolicy) check(principal string, requestKeyVersion *protocol.RequestKeyVersion, body []byte) error {
+	if p.allows(principal) {
+		return nil
+	}
+	transactional, err := protocol.IsTransactionalRequest(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, body)
+	if err != nil {
+		return err
+	}
+	if transactional && p.dryRun.enabled(config.DryRunTransactions) {
+		p.dryRun.deny(config.DryRunTransactions, fmt.Sprintf("transactional request api key %d of principal %q", requestKeyVersion.ApiKey, principal))
+		return nil
+	}
+	if transactional {
+		proxyTransactionsRejectedTotal.WithLabelValues(fmt.Sprint(requestKeyVersion.ApiKey)).Inc()
+		return fmt.Errorf("transactional request api key %d of principal %q is forbidden", requestKeyVersion.ApiKey, principal)
+	}
+	return nil
+}
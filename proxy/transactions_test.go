@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransactionPolicyChecksPrincipals(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := newTransactionPolicy(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	a.False(disabled.inspects(&protocol.RequestKeyVersion{ApiKey: 26}))
+	a.False(disabled.isForbidden(apiKeyInitProducerId, 4))
+
+	c.Transactions.AllowPrincipals = []string{"^payments-"}
+	c.Transactions.DenyPrincipals = []string{"^payments-test$"}
+	policy, err := newTransactionPolicy(c)
+	a.Nil(err)
+	a.True(policy.inspects(&protocol.RequestKeyVersion{ApiKey: 26}))
+	a.False(policy.inspects(&protocol.RequestKeyVersion{ApiKey: apiKeyProduce}))
+	a.False(policy.isForbidden(apiKeyInitProducerId, 1))
+	a.True(policy.isForbidden(apiKeyInitProducerId, 2))
+
+	transactional := []byte{0, 5, 't', 'x', 'n', '-', '1', 0, 0, 0xea, 0x60}
+	idempotent := []byte{0xff, 0xff, 0, 0, 0xea, 0x60}
+	initProducerId := &protocol.RequestKeyVersion{ApiKey: apiKeyInitProducerId, ApiVersion: 1}
+	endTxn := &protocol.RequestKeyVersion{ApiKey: 26, ApiVersion: 1}
+
+	a.Nil(policy.check("payments-app", initProducerId, transactional))
+	a.Nil(policy.check("payments-app", endTxn, nil))
+	a.Nil(policy.check("orders-app", initProducerId, idempotent))
+	a.EqualError(policy.check("orders-app", initProducerId, transactional), `transactional request api key 22 of principal "orders-app" is forbidden`)
+	a.EqualError(policy.check("payments-test", endTxn, nil), `transactional request api key 26 of principal "payments-test" is forbidden`)
+	a.NotNil(policy.check("", endTxn, nil))
+}
@@ -0,0 +1,575 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/hashicorp/yamux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The agent connects to the relay and sends the open request with the token, the relay answers with the status and both sides start a yamux session.
+// The relay opens a stream for every broker connection and sends the broker address, the agent dials the broker and answers with the status.
+// With the join request the agent sends the join token and a certificate signing request, the relay answers with the certificate signed by its CA.
+const (
+	tunnelStatusOK    = 0
+	tunnelStatusError = 1
+
+	tunnelRequestOpen = 1
+	tunnelRequestJoin = 2
+
+	tunnelHandshakeTimeout = 10 * time.Second
+)
+
+func writeTunnelStatus(w io.Writer, status byte, message string) error {
+	if _, err := w.Write([]byte{status}); err != nil {
+		return err
+	}
+	return writeTunnelString(w, message)
+}
+
+// readTunnelStatus returns the message of the OK status or the error
+func readTunnelStatus(r io.Reader) (string, error) {
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		return "", err
+	}
+	message, err := readTunnelString(r)
+	if err != nil {
+		return "", err
+	}
+	if status[0] != tunnelStatusOK {
+		return "", errors.New(message)
+	}
+	return message, nil
+}
+
+func writeTunnelString(w io.Writer, value string) error {
+	if len(value) > 0xffff {
+		value = value[:0xffff]
+	}
+	buf := make([]byte, 2+len(value))
+	binary.BigEndian.PutUint16(buf, uint16(len(value)))
+	copy(buf[2:], value)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readTunnelString(r io.Reader) (string, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	value := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func newTunnelSessionConfig() *yamux.Config {
+	cfg := yamux.DefaultConfig()
+	cfg.LogOutput = ioutil.Discard
+	return cfg
+}
+
+// tunnelRelay accepts the tunnels of the agents and dials the brokers through them
+type tunnelRelay struct {
+	listener net.Listener
+	token    string
+	// nil if the agents cannot join
+	ca                *tunnelCA
+	joinToken         string
+	agentCertValidity time.Duration
+
+	lock     sync.Mutex
+	sessions []*yamux.Session
+	next     int
+}
+
+// newTunnelRelay returns nil if the relay is not enabled
+func newTunnelRelay(c *config.Config) (*tunnelRelay, error) {
+	if c.Tunnel.Relay.ListenAddress == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", c.Tunnel.Relay.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	relay := &tunnelRelay{token: c.Tunnel.Token, joinToken: c.Tunnel.JoinToken, agentCertValidity: c.Tunnel.Relay.CA.AgentCertValidity}
+	if relay.agentCertValidity == 0 {
+		relay.agentCertValidity = tunnelRelayCertValidity
+	}
+	if c.Tunnel.Relay.CA.CertFile != "" {
+		if relay.ca, err = loadOrCreateTunnelCA(c.Tunnel.Relay.CA.CertFile, c.Tunnel.Relay.CA.KeyFile); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		logrus.Infof("Tunnel agents join with the CA pin sha256:%s", relay.ca.pin())
+	}
+	if c.Tunnel.Relay.TLS.Enable || relay.ca != nil {
+		tlsConfig := &tls.Config{}
+		var cert tls.Certificate
+		if relay.ca != nil {
+			// the agents verify the relay with the CA
+			cert, err = relay.ca.serverCertificate()
+			tlsConfig.ClientCAs = relay.ca.pool()
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			cert, err = tls.LoadX509KeyPair(c.Tunnel.Relay.TLS.CertFile, c.Tunnel.Relay.TLS.KeyFile)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, errors.Wrap(err, "cannot load tunnel relay certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		if c.FIPS.Enable {
+			restrictToFIPS(tlsConfig)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	relay.listener = listener
+	logrus.Infof("Kafka clients will connect through the tunnel agents connected to %s", listener.Addr())
+	return relay, nil
+}
+
+// run accepts the agents until the listener is closed
+func (r *tunnelRelay) run() {
+	if r == nil {
+		return
+	}
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go withConnectionRecover(panicGoroutineConnection, "tunnel agent "+conn.RemoteAddr().String(), func() { r.handleAgent(conn) }, conn)
+	}
+}
+
+func (r *tunnelRelay) handleAgent(conn net.Conn) {
+	if err := conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+	request := make([]byte, 1)
+	_, err := io.ReadFull(conn, request)
+	var token string
+	if err == nil {
+		token, err = readTunnelString(conn)
+	}
+	if err != nil {
+		logrus.Infof("Tunnel handshake with agent %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	switch request[0] {
+	case tunnelRequestOpen:
+	case tunnelRequestJoin:
+		r.join(conn, token)
+		conn.Close()
+		return
+	default:
+		_ = writeTunnelStatus(conn, tunnelStatusError, "unknown request")
+		conn.Close()
+		return
+	}
+	if !r.authorized(conn, token) {
+		logrus.Warnf("Tunnel agent %s rejected: invalid token", conn.RemoteAddr())
+		_ = writeTunnelStatus(conn, tunnelStatusError, "invalid token")
+		conn.Close()
+		return
+	}
+	if err = writeTunnelStatus(conn, tunnelStatusOK, ""); err != nil {
+		conn.Close()
+		return
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return
+	}
+	session, err := yamux.Server(conn, newTunnelSessionConfig())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	logrus.Infof("Tunnel agent %s connected", conn.RemoteAddr())
+	r.add(session)
+	<-session.CloseChan()
+	r.remove(session)
+	logrus.Infof("Tunnel agent %s disconnected", conn.RemoteAddr())
+}
+
+// authorized accepts the agents with the token or the client certificate issued by the CA
+func (r *tunnelRelay) authorized(conn net.Conn, token string) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok && len(tlsConn.ConnectionState().VerifiedChains) != 0 {
+		return true
+	}
+	return r.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) == 1
+}
+
+// join signs the certificate signing request of the agent with the join token
+func (r *tunnelRelay) join(conn net.Conn, joinToken string) {
+	csr, err := readTunnelString(conn)
+	if err != nil {
+		return
+	}
+	if r.ca == nil || r.joinToken == "" || subtle.ConstantTimeCompare([]byte(joinToken), []byte(r.joinToken)) != 1 {
+		logrus.Warnf("Tunnel agent %s rejected: invalid join token", conn.RemoteAddr())
+		_ = writeTunnelStatus(conn, tunnelStatusError, "invalid join token")
+		return
+	}
+	certs, err := r.ca.sign(csr, r.agentCertValidity)
+	if err != nil {
+		logrus.Warnf("Tunnel agent %s join failed: %v", conn.RemoteAddr(), err)
+		_ = writeTunnelStatus(conn, tunnelStatusError, err.Error())
+		return
+	}
+	if err = writeTunnelStatus(conn, tunnelStatusOK, certs); err == nil {
+		logrus.Infof("Tunnel agent %s joined", conn.RemoteAddr())
+	}
+}
+
+func (r *tunnelRelay) add(session *yamux.Session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sessions = append(r.sessions, session)
+	proxyTunnelAgents.Set(float64(len(r.sessions)))
+}
+
+func (r *tunnelRelay) remove(session *yamux.Session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, s := range r.sessions {
+		if s == session {
+			r.sessions = append(r.sessions[:i], r.sessions[i+1:]...)
+			break
+		}
+	}
+	proxyTunnelAgents.Set(float64(len(r.sessions)))
+}
+
+// session returns the connected agents in turn
+func (r *tunnelRelay) session() *yamux.Session {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for range r.sessions {
+		session := r.sessions[r.next%len(r.sessions)]
+		r.next++
+		if !session.IsClosed() {
+			return session
+		}
+	}
+	return nil
+}
+
+func (r *tunnelRelay) Dial(network, addr string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, addr)
+}
+
+func (r *tunnelRelay) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	session := r.session()
+	if session == nil {
+		return nil, errors.Errorf("cannot dial %s: no tunnel agent is connected", addr)
+	}
+	stream, err := session.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open tunnel stream to %s", addr)
+	}
+	stop := closeOnDone(ctx, stream)
+	defer stop()
+
+	if err = writeTunnelString(stream, addr); err == nil {
+		_, err = readTunnelStatus(stream)
+	}
+	if err != nil {
+		stream.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "tunnel agent cannot dial %s", addr)
+	}
+	return stream, nil
+}
+
+// Close stops accepting the agents and closes the tunnels
+func (r *tunnelRelay) Close() {
+	if r == nil {
+		return
+	}
+	r.listener.Close()
+	r.lock.Lock()
+	sessions := append([]*yamux.Session(nil), r.sessions...)
+	r.lock.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+// TunnelAgent dials out to the relay kafka-proxy and connects the streams of the relay to the brokers in the private network
+type TunnelAgent struct {
+	relayAddress      string
+	token             string
+	reconnectInterval time.Duration
+	relayDialer       Dialer
+	// nil if TLS is disabled
+	tlsConfig *tls.Config
+	// dials the brokers
+	dialer Dialer
+
+	// the client certificate is requested with the join token if the agent did not join yet
+	certs     tunnelAgentCerts
+	joinToken string
+	caPin     string
+	fips      bool
+}
+
+// NewTunnelAgent creates the agent from Tunnel.Agent, the brokers are dialed with the Kafka dial options and the resolver
+func NewTunnelAgent(c *config.Config) (*TunnelAgent, error) {
+	if err := c.ValidateTunnelAgent(); err != nil {
+		return nil, err
+	}
+	resolver, err := newResolverIfEnabled(c)
+	if err != nil {
+		return nil, err
+	}
+	agent := &TunnelAgent{
+		relayAddress:      c.Tunnel.Agent.RelayAddress,
+		token:             c.Tunnel.Token,
+		reconnectInterval: c.Tunnel.Agent.ReconnectInterval,
+		relayDialer:       directDialer{dialTimeout: c.Kafka.DialTimeout, keepAlive: c.Kafka.KeepAlive},
+		dialer:            directDialer{dialTimeout: c.Kafka.DialTimeout, keepAlive: c.Kafka.KeepAlive, resolver: resolver},
+		certs:             tunnelAgentCerts{dir: c.Tunnel.Agent.CertDir},
+		joinToken:         c.Tunnel.JoinToken,
+		caPin:             normalizeTunnelCertPin(c.Tunnel.Agent.CAPin),
+		fips:              c.FIPS.Enable,
+	}
+	if c.Tunnel.Agent.TLS.Enable {
+		opts := c.Tunnel.Agent.TLS
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify, ServerName: opts.ServerName}
+		if opts.CAChainCertFile != "" {
+			caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+			if err != nil {
+				return nil, err
+			}
+			rootCAs := x509.NewCertPool()
+			if ok := rootCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+				return nil, errors.New("Failed to parse tunnel relay CA certificate")
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+		if c.FIPS.Enable {
+			restrictToFIPS(tlsConfig)
+		}
+		agent.tlsConfig = tlsConfig
+	}
+	return agent, nil
+}
+
+// Run keeps the tunnel to the relay open until the context is done
+func (a *TunnelAgent) Run(ctx context.Context) error {
+	for {
+		err := a.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logrus.Warnf("Tunnel to relay %s is closed, reconnecting in %v: %v", a.relayAddress, a.reconnectInterval, err)
+		select {
+		case <-time.After(a.reconnectInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// connect opens the tunnel and serves the streams until the tunnel is closed
+func (a *TunnelAgent) connect(ctx context.Context) error {
+	tlsConfig, err := a.clientTLSConfig(ctx)
+	if err != nil {
+		return err
+	}
+	conn, err := a.relayDialer.DialContext(ctx, "tcp", a.relayAddress)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	if _, err = a.request(conn, tunnelRequestOpen, a.token); err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "tunnel handshake failed")
+	}
+	session, err := yamux.Client(conn, newTunnelSessionConfig())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer session.Close()
+	logrus.Infof("Tunnel to relay %s is open", a.relayAddress)
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return err
+		}
+		go withConnectionRecover(panicGoroutineConnection, "tunnel stream", func() { a.handleStream(ctx, stream) }, stream)
+	}
+}
+
+// request sends the request with the strings and returns the message of the status
+func (a *TunnelAgent) request(conn net.Conn, request byte, values ...string) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{request}); err != nil {
+		return "", err
+	}
+	for _, value := range values {
+		if err := writeTunnelString(conn, value); err != nil {
+			return "", err
+		}
+	}
+	return readTunnelStatus(conn)
+}
+
+// clientTLSConfig returns the configuration with the client certificate of the agent, it joins if the agent has no valid certificate
+func (a *TunnelAgent) clientTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if a.certs.dir == "" {
+		if a.tlsConfig == nil || a.tlsConfig.ServerName != "" {
+			return a.tlsConfig, nil
+		}
+		tlsConfig := a.tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(a.relayAddress)
+		return tlsConfig, nil
+	}
+	cert, roots, err := a.certs.load()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		if a.joinToken == "" {
+			return nil, errors.New("tunnel agent has no valid certificate and no join token")
+		}
+		if err = a.join(ctx); err != nil {
+			return nil, errors.Wrap(err, "tunnel join failed")
+		}
+		if cert, roots, err = a.certs.load(); err != nil || cert == nil {
+			return nil, errors.Errorf("cannot load the certificate received on join: %v", err)
+		}
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		// the relay certificate is issued by the relay CA for a fixed name
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyTunnelPeer(roots, x509.ExtKeyUsageServerAuth, rawCerts)
+		},
+	}
+	if a.fips {
+		restrictToFIPS(tlsConfig)
+	}
+	return tlsConfig, nil
+}
+
+// join requests the client certificate, the relay is verified with the CA pin
+func (a *TunnelAgent) join(ctx context.Context) error {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				if tunnelCertPin(raw) != a.caPin {
+					continue
+				}
+				ca, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(ca)
+				return verifyTunnelPeer(roots, x509.ExtKeyUsageServerAuth, rawCerts)
+			}
+			return errors.New("relay certificate does not match the CA pin")
+		},
+	}
+	if a.fips {
+		restrictToFIPS(tlsConfig)
+	}
+	commonName, err := os.Hostname()
+	if err != nil {
+		commonName = "kafka-proxy-agent"
+	}
+	key, csr, err := a.certs.newRequest(commonName)
+	if err != nil {
+		return err
+	}
+	conn, err := a.relayDialer.DialContext(ctx, "tcp", a.relayAddress)
+	if err != nil {
+		return err
+	}
+	conn = tls.Client(conn, tlsConfig)
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	certs, err := a.request(conn, tunnelRequestJoin, a.joinToken, csr)
+	if err != nil {
+		return err
+	}
+	if err = a.certs.store(key, certs); err != nil {
+		return err
+	}
+	logrus.Infof("Tunnel agent joined relay %s, the certificate is stored in %s", a.relayAddress, a.certs.dir)
+	return nil
+}
+
+func (a *TunnelAgent) handleStream(ctx context.Context, stream net.Conn) {
+	defer stream.Close()
+
+	addr, err := readTunnelString(stream)
+	if err != nil {
+		return
+	}
+	conn, err := a.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		logrus.Infof("Tunnel agent couldn't connect to %s: %v", addr, err)
+		_ = writeTunnelStatus(stream, tunnelStatusError, err.Error())
+		return
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	if err = writeTunnelStatus(stream, tunnelStatusOK, ""); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	tunnelCAValidity         = 10 * 365 * 24 * time.Hour
+	tunnelRelayCertValidity  = 365 * 24 * time.Hour
+	tunnelAgentRenewBefore   = 24 * time.Hour
+	tunnelAgentCertFileName  = "agent-cert.pem"
+	tunnelAgentKeyFileName   = "agent-key.pem"
+	tunnelAgentCAFileName    = "ca.pem"
+	tunnelRelayServerName    = "kafka-proxy-relay"
+	tunnelCertificateSubject = "kafka-proxy tunnel"
+)
+
+// tunnelCA signs the certificates of the relay and the agents which joined with the join token
+type tunnelCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// loadOrCreateTunnelCA loads the CA or creates it and writes the files if both do not exist
+func loadOrCreateTunnelCA(certFile, keyFile string) (*tunnelCA, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		ca, err := newTunnelCA()
+		if err != nil {
+			return nil, err
+		}
+		if err = writePEMFile(certFile, "CERTIFICATE", ca.cert.Raw, 0644); err != nil {
+			return nil, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(ca.key.(*ecdsa.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		if err = writePEMFile(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+			return nil, err
+		}
+		logrus.Infof("Created tunnel CA %s", certFile)
+		return ca, nil
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load tunnel CA")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, errors.Errorf("%s is not a CA certificate", certFile)
+	}
+	return &tunnelCA{cert: cert, key: key}, nil
+}
+
+func newTunnelCA() (*tunnelCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: tunnelCertificateSubject + " CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(tunnelCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tunnelCA{cert: cert, key: key}, nil
+}
+
+// pin is the hex encoded SHA-256 of the CA certificate, the agents verify the relay with it before they have the CA
+func (ca *tunnelCA) pin() string {
+	return tunnelCertPin(ca.cert.Raw)
+}
+
+func (ca *tunnelCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue signs the public key for the client or the server authentication
+func (ca *tunnelCA) issue(commonName string, publicKey interface{}, validity time.Duration, usage x509.ExtKeyUsage, dnsNames []string) ([]byte, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+	}
+	return x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+}
+
+// serverCertificate issues the certificate of the relay listener
+func (ca *tunnelCA) serverCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	der, err := ca.issue(tunnelRelayServerName, key.Public(), tunnelRelayCertValidity, x509.ExtKeyUsageServerAuth, []string{tunnelRelayServerName})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}, nil
+}
+
+// sign issues the client certificate of the agent certificate signing request, the response contains the certificate and the CA in PEM
+func (ca *tunnelCA) sign(csrPEM string, validity time.Duration) (string, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "", errors.New("certificate signing request must be PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return "", errors.Wrap(err, "invalid certificate signing request")
+	}
+	der, err := ca.issue(csr.Subject.CommonName, csr.PublicKey, validity, x509.ExtKeyUsageClientAuth, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})), nil
+}
+
+// verifyTunnelPeer verifies the chain of the peer against the roots without the host name check
+func verifyTunnelPeer(roots *x509.CertPool, usage x509.ExtKeyUsage, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no peer certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}})
+	return err
+}
+
+func tunnelCertPin(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writePEMFile(name string, blockType string, der []byte, perm os.FileMode) error {
+	if dir := filepath.Dir(name); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+// tunnelAgentCerts are the certificate, the key and the CA received by the agent on join
+type tunnelAgentCerts struct {
+	dir string
+}
+
+func (d tunnelAgentCerts) path(name string) string {
+	return filepath.Join(d.dir, name)
+}
+
+// load returns the client certificate and the CA pool, nil if the agent did not join yet or the certificate expires soon
+func (d tunnelAgentCerts) load() (*tls.Certificate, *x509.CertPool, error) {
+	if d.dir == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(d.path(tunnelAgentCertFileName)); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(d.path(tunnelAgentCertFileName), d.path(tunnelAgentKeyFileName))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot load tunnel agent certificate")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().Add(tunnelAgentRenewBefore).After(cert.NotAfter) {
+		logrus.Infof("Tunnel agent certificate expires at %v, it is renewed", cert.NotAfter)
+		return nil, nil, nil
+	}
+	caPEM, err := ioutil.ReadFile(d.path(tunnelAgentCAFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, nil, errors.New("Failed to parse tunnel CA certificate")
+	}
+	return &pair, pool, nil
+}
+
+// newRequest generates the key of the agent and returns the PEM encoded certificate signing request
+func (d tunnelAgentCerts) newRequest(commonName string) (*ecdsa.PrivateKey, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// store writes the key, the certificate and the CA of the join response
+func (d tunnelAgentCerts) store(key *ecdsa.PrivateKey, response string) error {
+	var certs [][]byte
+	rest := []byte(response)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	if len(certs) != 2 {
+		return errors.New("join response must contain the certificate and the CA")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = writePEMFile(d.path(tunnelAgentKeyFileName), "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err = writePEMFile(d.path(tunnelAgentCAFileName), "CERTIFICATE", certs[1], 0644); err != nil {
+		return err
+	}
+	return writePEMFile(d.path(tunnelAgentCertFileName), "CERTIFICATE", certs[0], 0644)
+}
+
+// normalizeTunnelCertPin accepts the pin with or without the sha256: prefix and colons
+func normalizeTunnelCertPin(pin string) string {
+	pin = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(pin)), "sha256:")
+	return strings.Replace(pin, ":", "", -1)
+}
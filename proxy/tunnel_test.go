@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTunnelConfig() *config.Config {
+	c := new(config.Config)
+	c.Tunnel.Token = "secret"
+	c.Tunnel.Relay.ListenAddress = "127.0.0.1:0"
+	c.Tunnel.Agent.ReconnectInterval = 100 * time.Millisecond
+	c.Kafka.DialTimeout = 2 * time.Second
+	return c
+}
+
+func waitForTunnelAgents(relay *tunnelRelay, count int) bool {
+	for i := 0; i < 100; i++ {
+		relay.lock.Lock()
+		n := len(relay.sessions)
+		relay.lock.Unlock()
+		if n == count {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestTunnelRelayDialsThroughAgent(t *testing.T) {
+	a := assert.New(t)
+
+	// echo broker in the private network
+	broker, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer broker.Close()
+	go func() {
+		for {
+			conn, err := broker.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	c := newTestTunnelConfig()
+	relay, err := newTunnelRelay(c)
+	a.Nil(err)
+	defer relay.Close()
+	go relay.run()
+
+	_, err = relay.Dial("tcp", broker.Addr().String())
+	a.EqualError(err, "cannot dial "+broker.Addr().String()+": no tunnel agent is connected")
+
+	c.Tunnel.Agent.RelayAddress = relay.listener.Addr().String()
+	agent, err := NewTunnelAgent(c)
+	a.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+	a.True(waitForTunnelAgents(relay, 1))
+
+	conn, err := relay.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	a.Nil(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal("ping", string(buf))
+
+	// the agent cannot reach the broker
+	broker.Close()
+	_, err = relay.Dial("tcp", broker.Addr().String())
+	a.NotNil(err)
+	a.Contains(err.Error(), "tunnel agent cannot dial "+broker.Addr().String())
+
+	cancel()
+	a.True(waitForTunnelAgents(relay, 0))
+}
+
+func TestTunnelRelayRejectsInvalidToken(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestTunnelConfig()
+	relay, err := newTunnelRelay(c)
+	a.Nil(err)
+	defer relay.Close()
+	go relay.run()
+
+	c.Tunnel.Agent.RelayAddress = relay.listener.Addr().String()
+	c.Tunnel.Token = "wrong"
+	agent, err := NewTunnelAgent(c)
+	a.Nil(err)
+	a.EqualError(agent.connect(context.Background()), "tunnel handshake failed: invalid token")
+	a.True(waitForTunnelAgents(relay, 0))
+}
+
+func TestTunnelAgentJoinsWithJoinToken(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "tunnel-pki")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := newTestTunnelConfig()
+	c.Tunnel.Token = ""
+	c.Tunnel.JoinToken = "join-secret"
+	c.Tunnel.Relay.CA.CertFile = filepath.Join(dir, "relay", "ca.pem")
+	c.Tunnel.Relay.CA.KeyFile = filepath.Join(dir, "relay", "ca-key.pem")
+	relay, err := newTunnelRelay(c)
+	a.Nil(err)
+	defer relay.Close()
+	go relay.run()
+
+	c.Tunnel.Agent.RelayAddress = relay.listener.Addr().String()
+	c.Tunnel.Agent.CertDir = filepath.Join(dir, "agent")
+
+	// the pin does not match the relay CA
+	c.Tunnel.Agent.CAPin = strings.Repeat("0", 64)
+	agent, err := NewTunnelAgent(c)
+	a.Nil(err)
+	err = agent.connect(context.Background())
+	a.NotNil(err)
+	a.Contains(err.Error(), "relay certificate does not match the CA pin")
+
+	// the join token is wrong
+	c.Tunnel.Agent.CAPin = "sha256:" + relay.ca.pin()
+	c.Tunnel.JoinToken = "wrong"
+	agent, err = NewTunnelAgent(c)
+	a.Nil(err)
+	a.EqualError(agent.connect(context.Background()), "tunnel join failed: invalid join token")
+
+	c.Tunnel.JoinToken = "join-secret"
+	agent, err = NewTunnelAgent(c)
+	a.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+	a.True(waitForTunnelAgents(relay, 1))
+	cancel()
+	a.True(waitForTunnelAgents(relay, 0))
+
+	// the stored certificate authenticates the agent without the join token
+	c.Tunnel.JoinToken = ""
+	agent, err = NewTunnelAgent(c)
+	a.Nil(err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+	a.True(waitForTunnelAgents(relay, 1))
+
+	// the relay CA is reused after restart
+	ca, err := loadOrCreateTunnelCA(c.Tunnel.Relay.CA.CertFile, c.Tunnel.Relay.CA.KeyFile)
+	a.Nil(err)
+	a.Equal(relay.ca.pin(), ca.pin())
+}
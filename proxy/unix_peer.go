@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"net"
+	"os"
+)
+
+const rejectReasonUnknownPeer = "unknown_peer"
+
+// unixPeerPrincipals maps the peer credentials of the Unix socket connections to principals, uid mappings take precedence over gid mappings
+type unixPeerPrincipals struct {
+	uids map[uint32]string
+	gids map[uint32]string
+}
+
+// newUnixPeerPrincipals returns nil if the Unix peer authentication is disabled
+func newUnixPeerPrincipals(c *config.Config) (*unixPeerPrincipals, error) {
+	if !c.Auth.UnixPeer.Enable {
+		return nil, nil
+	}
+	p := &unixPeerPrincipals{uids: make(map[uint32]string), gids: make(map[uint32]string)}
+	for _, v := range c.Auth.UnixPeer.Principals {
+		kind, id, principal, err := config.ParseUnixPeerPrincipal(v)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "uid" {
+			p.uids[id] = principal
+		} else {
+			p.gids[id] = principal
+		}
+	}
+	return p, nil
+}
+
+// principal returns the principal of the connected peer, an empty principal if the authentication is disabled or the connection is not a Unix socket connection
+func (p *unixPeerPrincipals) principal(conn net.Conn) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", nil
+	}
+	uid, gid, err := peerCredentials(unixConn)
+	if err != nil {
+		return "", err
+	}
+	return p.lookup(uid, gid)
+}
+
+func (p *unixPeerPrincipals) lookup(uid, gid uint32) (string, error) {
+	if principal, ok := p.uids[uid]; ok {
+		return principal, nil
+	}
+	if principal, ok := p.gids[gid]; ok {
+		return principal, nil
+	}
+	return "", fmt.Errorf("no principal is mapped to peer uid %d gid %d", uid, gid)
+}
+
+// listenUnix listens on the socket path, a stale socket of a previous process is removed
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
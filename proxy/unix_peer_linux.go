@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the uid and gid of the peer process (SO_PEERCRED)
+func peerCredentials(conn *net.UnixConn) (uid uint32, gid uint32, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if credErr != nil {
+		return 0, 0, credErr
+	}
+	return ucred.Uid, ucred.Gid, nil
+}
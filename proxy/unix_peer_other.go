@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is supported on Linux only
+func peerCredentials(conn *net.UnixConn) (uid uint32, gid uint32, err error) {
+	return 0, 0, errors.New("peer credentials of unix sockets are supported on linux only")
+}
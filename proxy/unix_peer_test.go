@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUnixPeerPrincipals(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	p, err := newUnixPeerPrincipals(c)
+	a.Nil(err)
+	a.Nil(p)
+	principal, err := p.principal(nil)
+	a.Nil(err)
+	a.Equal("", principal)
+
+	c.Auth.UnixPeer.Enable = true
+	c.Auth.UnixPeer.Principals = []string{"uid:1000=alice", "gid:2000=team-a", "gid:1000=team-b"}
+	p, err = newUnixPeerPrincipals(c)
+	a.Nil(err)
+
+	principal, err = p.lookup(1000, 2000)
+	a.Nil(err)
+	a.Equal("alice", principal)
+	principal, err = p.lookup(1001, 2000)
+	a.Nil(err)
+	a.Equal("team-a", principal)
+	_, err = p.lookup(1001, 2001)
+	a.EqualError(err, "no principal is mapped to peer uid 1001 gid 2001")
+
+	// TCP connections are not authenticated by the peer credentials
+	principal, err = p.principal(&net.TCPConn{})
+	a.Nil(err)
+	a.Equal("", principal)
+}
+
+func TestProxyAuthenticatesUnixPeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported on linux only")
+	}
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	dir, err := ioutil.TempDir("", "unix-peer")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "broker-0.sock")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	listenerAddress := l.Addr().String()
+	l.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.BootstrapServers[0].ListenerAddress = listenerAddress
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = listenerAddress
+	c.Proxy.ListenerUnixSockets = []string{listenerAddress + "=" + socketPath}
+	c.Auth.UnixPeer.Enable = true
+	c.Auth.UnixPeer.Principals = []string{"uid:" + strconv.Itoa(os.Getuid()) + "=sidecar"}
+	a.Nil(c.Validate())
+
+	p, err := New(c)
+	a.Nil(err)
+	go p.Run(context.Background())
+	defer p.Close()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", socketPath); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyProduce, 3, 1, "app-1", []byte{1, 2, 3}))
+	a.Nil(err)
+	correlationID, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	a.Equal(int32(1), correlationID)
+	a.Equal([]byte{1, 2, 3}, body)
+}
+
+func TestProxyRejectsUnknownUnixPeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported on linux only")
+	}
+	a := assert.New(t)
+
+	broker, err := kafkatest.NewBroker(kafkatest.Config{})
+	a.Nil(err)
+	defer broker.Close()
+
+	dir, err := ioutil.TempDir("", "unix-peer")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "broker-0.sock")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	listenerAddress := l.Addr().String()
+	l.Close()
+
+	c := newTestProxyConfig(broker.Addr())
+	c.Proxy.BootstrapServers[0].ListenerAddress = listenerAddress
+	c.Proxy.BootstrapServers[0].AdvertisedAddress = listenerAddress
+	c.Proxy.ListenerUnixSockets = []string{listenerAddress + "=" + socketPath}
+	c.Auth.UnixPeer.Enable = true
+	c.Auth.UnixPeer.Principals = []string{"uid:" + strconv.Itoa(os.Getuid()+1) + "=other"}
+
+	p, err := New(c)
+	a.Nil(err)
+	go p.Run(context.Background())
+	defer p.Close()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", socketPath); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	a.Nil(err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.Equal(0, broker.RequestCount(kafkatest.ApiKeyProduce))
+}
@@ -0,0 +1,346 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	UpstreamPrimary   = "primary"
+	UpstreamSecondary = "secondary"
+)
+
+// UpstreamSettings select the cluster to which new connections are routed
+type UpstreamSettings struct {
+	Active string `json:"active"`
+	// open connections to the previously active cluster are closed after the drain timeout
+	Drain bool `json:"drain,omitempty"`
+	// percentage of the client ids routed to the secondary cluster while the primary cluster is active, unchanged when nil
+	CanaryPercent *float64 `json:"canary_percent,omitempty"`
+}
+
+type upstreamStatus struct {
+	Active        string         `json:"active"`
+	CanaryPercent float64        `json:"canary_percent"`
+	Connections   map[string]int `json:"connections"`
+}
+
+// upstreamRoute routes the connections of the clients with the matching attribute to the cluster regardless of the active cluster
+type upstreamRoute struct {
+	cluster   string
+	attribute string
+	// network of the cidr attribute, nil otherwise
+	network *net.IPNet
+	pattern *regexp.Regexp
+}
+
+// clientAttributes are the attributes of the client connection known before the upstream connection is opened
+type clientAttributes struct {
+	ip          net.IP
+	sni         string
+	certSubject string
+	// principal of the Unix socket peer or the common name of the verified client certificate
+	principal string
+}
+
+func (r *upstreamRoute) matches(attributes *clientAttributes) bool {
+	switch r.attribute {
+	case config.UpstreamRouteCIDR:
+		return attributes.ip != nil && r.network.Contains(attributes.ip)
+	case config.UpstreamRouteSNI:
+		return r.pattern.MatchString(attributes.sni)
+	case config.UpstreamRouteCertSubject:
+		return attributes.certSubject != "" && r.pattern.MatchString(attributes.certSubject)
+	case config.UpstreamRoutePrincipal:
+		return attributes.principal != "" && r.pattern.MatchString(attributes.principal)
+	default:
+		return false
+	}
+}
+
+// UpstreamSwitch routes the connections of the primary brokers to the paired secondary brokers when the secondary cluster is active.
+// The active cluster can be switched at runtime e.g. with the HTTP admin API. The connections of the clients matching a route
+// are routed to the cluster of the route, e.g. the principals of the QA clients to the QA cluster through the same listeners.
+type UpstreamSwitch struct {
+	secondaryByPrimary map[string]string
+	primaryBySecondary map[string]string
+	drainTimeout       time.Duration
+	// the first matching route selects the cluster
+	routes           []*upstreamRoute
+	handshakeTimeout time.Duration
+	// the client id of the first request is not known if the gateway authentication precedes it
+	canaryByClientIP bool
+	// open connections by cluster
+	conns map[string]*ConnSet
+
+	lock          sync.RWMutex
+	active        string
+	canaryPercent float64
+}
+
+// NewUpstreamSwitch returns nil if no secondary brokers are configured
+func NewUpstreamSwitch(c *config.Config) (*UpstreamSwitch, error) {
+	if len(c.Upstream.SecondaryMapping) == 0 {
+		return nil, nil
+	}
+	u := &UpstreamSwitch{
+		secondaryByPrimary: make(map[string]string),
+		primaryBySecondary: make(map[string]string),
+		drainTimeout:       c.Upstream.DrainTimeout,
+		conns:              map[string]*ConnSet{UpstreamPrimary: NewConnSet(), UpstreamSecondary: NewConnSet()},
+		active:             c.Upstream.Active,
+		handshakeTimeout:   c.Proxy.TLS.ListenerHandshakeTimeout,
+		canaryByClientIP:   c.Auth.Gateway.Server.Enable,
+		canaryPercent:      c.Upstream.CanaryPercent,
+	}
+	for _, v := range c.Upstream.SecondaryMapping {
+		primary, secondary, err := config.ParseUpstreamMapping(v)
+		if err != nil {
+			return nil, err
+		}
+		u.secondaryByPrimary[primary] = secondary
+		u.primaryBySecondary[secondary] = primary
+	}
+	for _, v := range c.Upstream.Routes {
+		cluster, attribute, network, pattern, err := config.ParseUpstreamRoute(v)
+		if err != nil {
+			return nil, err
+		}
+		u.routes = append(u.routes, &upstreamRoute{cluster: cluster, attribute: attribute, network: network, pattern: pattern})
+	}
+	if len(u.routes) != 0 {
+		logrus.Infof("Upstream connections are routed by %v", c.Upstream.Routes)
+	}
+	if u.canaryPercent != 0 {
+		logrus.Infof("Connections of %v%% of the client ids are routed to the secondary cluster while the primary cluster is active", u.canaryPercent)
+	}
+	logrus.Infof("Upstream %s cluster is active, secondary brokers %v", u.active, u.secondaryByPrimary)
+	return u, nil
+}
+
+// Active returns the cluster to which new connections are routed
+func (u *UpstreamSwitch) Active() string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.active
+}
+
+// CanaryPercent returns the percentage of the client ids routed to the secondary cluster while the primary cluster is active
+func (u *UpstreamSwitch) CanaryPercent() float64 {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.canaryPercent
+}
+
+// Switch routes new connections to the cluster. With drain the open connections to the previously active cluster are closed after the drain timeout,
+// otherwise they are kept until closed by the clients or the brokers.
+func (u *UpstreamSwitch) Switch(settings UpstreamSettings) error {
+	if settings.Active != UpstreamPrimary && settings.Active != UpstreamSecondary {
+		return fmt.Errorf("active upstream must be %s or %s, got '%s'", UpstreamPrimary, UpstreamSecondary, settings.Active)
+	}
+	if settings.CanaryPercent != nil && (*settings.CanaryPercent < 0 || *settings.CanaryPercent > 100) {
+		return fmt.Errorf("canary percent must be between 0 and 100, got %v", *settings.CanaryPercent)
+	}
+	u.lock.Lock()
+	previous := u.active
+	u.active = settings.Active
+	if settings.CanaryPercent != nil && *settings.CanaryPercent != u.canaryPercent {
+		logrus.Warnf("Upstream canary changed from %v%% to %v%% of the client ids", u.canaryPercent, *settings.CanaryPercent)
+		u.canaryPercent = *settings.CanaryPercent
+	}
+	u.lock.Unlock()
+
+	if previous == settings.Active {
+		return nil
+	}
+	proxyUpstreamSwitchesTotal.WithLabelValues(settings.Active).Inc()
+	logrus.Warnf("Upstream switched from %s to %s cluster", previous, settings.Active)
+	if settings.Drain {
+		time.AfterFunc(u.drainTimeout, func() {
+			if u.Active() == previous {
+				// switched back in the meantime
+				return
+			}
+			logrus.Infof("Closing drained connections to %s cluster", previous)
+			if err := u.conns[previous].Close(); err != nil {
+				logrus.Info(err)
+			}
+		})
+	}
+	return nil
+}
+
+// ServeHTTP returns the active cluster and the number of the open connections on GET and switches the cluster with the JSON settings on PUT
+func (u *UpstreamSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings UpstreamSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := u.Switch(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	status := upstreamStatus{Active: u.Active(), CanaryPercent: u.CanaryPercent(), Connections: make(map[string]int)}
+	for cluster, conns := range u.conns {
+		status.Connections[cluster] = 0
+		for _, n := range conns.Count() {
+			status.Connections[cluster] += n
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// route returns the active cluster and the address of the broker in the active cluster
+func (u *UpstreamSwitch) route(brokerAddress string) (string, string, error) {
+	if u == nil {
+		return "", brokerAddress, nil
+	}
+	return u.routeTo(u.Active(), brokerAddress)
+}
+
+// routeConn returns the cluster of the first route matching the client, the canary or the active cluster and the address of the broker in the cluster.
+// The TLS handshake of the client connection is completed if a route needs the SNI or the client certificate. If the canary is routed
+// by the client id, the local connection is replaced by the connection replaying the peeked header of the first request.
+func (u *UpstreamSwitch) routeConn(conn *Conn) (string, string, error) {
+	if u == nil {
+		return u.route(conn.BrokerAddress)
+	}
+	if len(u.routes) != 0 {
+		attributes, err := u.clientAttributes(conn)
+		if err != nil {
+			return "", "", err
+		}
+		for _, r := range u.routes {
+			if r.matches(attributes) {
+				proxyUpstreamRoutedConnectionsTotal.WithLabelValues(r.cluster, r.attribute).Inc()
+				return u.routeTo(r.cluster, conn.BrokerAddress)
+			}
+		}
+	}
+	u.lock.RLock()
+	active, canaryPercent := u.active, u.canaryPercent
+	u.lock.RUnlock()
+	if active != UpstreamPrimary || canaryPercent == 0 {
+		return u.routeTo(active, conn.BrokerAddress)
+	}
+	key, err := u.canaryKey(conn)
+	if err != nil {
+		return "", "", err
+	}
+	if !isCanary(key, canaryPercent) {
+		return u.routeTo(active, conn.BrokerAddress)
+	}
+	proxyUpstreamCanaryConnectionsTotal.Inc()
+	return u.routeTo(UpstreamSecondary, conn.BrokerAddress)
+}
+
+// canaryKey returns the client id of the first request or the client IP if the client id is not known
+func (u *UpstreamSwitch) canaryKey(conn *Conn) (string, error) {
+	if !u.canaryByClientIP {
+		clientID, local, err := peekClientID(conn.LocalConnection, canaryClientIDTimeout)
+		if err != nil {
+			return "", errors.Wrap(err, "first request cannot be read")
+		}
+		conn.LocalConnection = local
+		if clientID != "" {
+			return clientID, nil
+		}
+	}
+	host, _, err := net.SplitHostPort(conn.LocalConnection.RemoteAddr().String())
+	if err != nil {
+		return conn.LocalConnection.RemoteAddr().String(), nil
+	}
+	return host, nil
+}
+
+func (u *UpstreamSwitch) routeTo(cluster string, brokerAddress string) (string, string, error) {
+	if cluster == UpstreamPrimary {
+		return cluster, brokerAddress, nil
+	}
+	secondary, ok := u.secondaryByPrimary[brokerAddress]
+	if !ok {
+		return "", "", errors.Errorf("secondary broker of %s is not configured", brokerAddress)
+	}
+	return cluster, secondary, nil
+}
+
+func (u *UpstreamSwitch) clientAttributes(conn *Conn) (*clientAttributes, error) {
+	attributes := &clientAttributes{principal: conn.PeerPrincipal}
+	if addr, ok := conn.LocalConnection.RemoteAddr().(*net.TCPAddr); ok {
+		attributes.ip = addr.IP
+	}
+	tlsConn, ok := conn.LocalConnection.(*tls.Conn)
+	if !ok {
+		return attributes, nil
+	}
+	if !tlsConn.ConnectionState().HandshakeComplete {
+		if err := tlsHandshake(tlsConn, nil, u.handshakeTimeout); err != nil {
+			return nil, errors.Wrap(err, "TLS handshake failed")
+		}
+	}
+	state := tlsConn.ConnectionState()
+	attributes.sni = state.ServerName
+	// certificates which were not verified are ignored
+	if len(state.VerifiedChains) != 0 {
+		subject := state.VerifiedChains[0][0].Subject
+		attributes.certSubject = subject.String()
+		if attributes.principal == "" {
+			attributes.principal = subject.CommonName
+		}
+	}
+	return attributes, nil
+}
+
+func (u *UpstreamSwitch) add(cluster string, brokerAddress string, conn net.Conn) {
+	if u == nil {
+		return
+	}
+	u.conns[cluster].Add(brokerAddress, conn)
+}
+
+func (u *UpstreamSwitch) remove(cluster string, brokerAddress string, conn net.Conn) {
+	if u == nil {
+		return
+	}
+	if err := u.conns[cluster].Remove(brokerAddress, conn); err != nil {
+		logrus.Info(err)
+	}
+}
+
+// netAddressMappingFunc maps the secondary brokers to the listeners of the paired primary brokers,
+// so the clients keep using the same listeners after the switch
+func (u *UpstreamSwitch) netAddressMappingFunc(fn config.NetAddressMappingFunc) config.NetAddressMappingFunc {
+	if u == nil {
+		return fn
+	}
+	return func(brokerHost string, brokerPort int32) (string, int32, error) {
+		if primary, ok := u.primaryBySecondary[net.JoinHostPort(brokerHost, strconv.Itoa(int(brokerPort)))]; ok {
+			host, port, err := util.SplitHostPort(primary)
+			if err != nil {
+				return "", 0, err
+			}
+			return fn(host, port)
+		}
+		return fn(brokerHost, brokerPort)
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/kafkatest"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func metadataBrokersThroughProxy(a *assert.Assertions, listenerAddress string) []kafkatest.BrokerAddress {
+	conn, err := net.Dial("tcp", listenerAddress)
+	a.Nil(err)
+	defer conn.Close()
+
+	_, err = conn.Write(kafkatest.EncodeRequest(kafkatest.ApiKeyMetadata, 1, 1, "app-1", kafkatest.MetadataRequestBody(1, nil)))
+	a.Nil(err)
+	_, body, err := kafkatest.ReadResponse(conn)
+	a.Nil(err)
+	brokers, err := kafkatest.DecodeMetadataBrokers(1, body)
+	a.Nil(err)
+	return brokers
+}
+
+func TestProxySwitchesUpstreamCluster(t *testing.T) {
+	a := assert.New(t)
+
+	primary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer primary.Close()
+	secondary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 2})
+	a.Nil(err)
+	defer secondary.Close()
+
+	c := newTestProxyConfig(primary.Addr())
+	c.Upstream.SecondaryMapping = []string{primary.Addr() + "," + secondary.Addr()}
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	listenerAddress, stop := startTestProxy(a, c, WithUpstreamSwitch(upstream))
+	defer stop()
+	host, port, err := util.SplitHostPort(listenerAddress)
+	a.Nil(err)
+
+	a.Equal([]kafkatest.BrokerAddress{{NodeID: 1, Host: host, Port: port}}, metadataBrokersThroughProxy(a, listenerAddress))
+	a.Equal(1, primary.RequestCount(kafkatest.ApiKeyMetadata))
+
+	a.Nil(upstream.Switch(UpstreamSettings{Active: UpstreamSecondary}))
+	// the secondary broker is advertised with the listener of the paired primary broker
+	a.Equal([]kafkatest.BrokerAddress{{NodeID: 2, Host: host, Port: port}}, metadataBrokersThroughProxy(a, listenerAddress))
+	a.Equal(1, primary.RequestCount(kafkatest.ApiKeyMetadata))
+	a.Equal(1, secondary.RequestCount(kafkatest.ApiKeyMetadata))
+}
+
+func TestUpstreamSwitchDrainsConnections(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	disabled, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	a.Nil(disabled)
+	cluster, address, err := disabled.route("127.0.0.1:9092")
+	a.Nil(err)
+	a.Equal("", cluster)
+	a.Equal("127.0.0.1:9092", address)
+
+	c.Upstream.SecondaryMapping = []string{"127.0.0.1:9092,127.0.0.1:19092"}
+	c.Upstream.DrainTimeout = 10 * time.Millisecond
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	cluster, address, err = upstream.route("127.0.0.1:9092")
+	a.Nil(err)
+	a.Equal(UpstreamPrimary, cluster)
+	a.Equal("127.0.0.1:9092", address)
+	upstream.add(cluster, address, local)
+
+	a.NotNil(upstream.Switch(UpstreamSettings{Active: "blue"}))
+	a.Nil(upstream.Switch(UpstreamSettings{Active: UpstreamSecondary, Drain: true}))
+	cluster, address, err = upstream.route("127.0.0.1:9092")
+	a.Nil(err)
+	a.Equal(UpstreamSecondary, cluster)
+	a.Equal("127.0.0.1:19092", address)
+	_, _, err = upstream.route("127.0.0.1:9093")
+	a.NotNil(err)
+
+	// closed after the drain timeout
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = remote.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.False(strings.Contains(err.Error(), "timeout"), err)
+}
+
+func TestUpstreamSwitchAdminAPI(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Upstream.SecondaryMapping = []string{"127.0.0.1:9092,127.0.0.1:19092"}
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"active":"secondary"}`))
+	a.Nil(err)
+	resp, err := http.DefaultClient.Do(req)
+	a.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Nil(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.JSONEq(`{"active":"secondary","canary_percent":0,"connections":{"primary":0,"secondary":0}}`, string(body))
+	a.Equal(UpstreamSecondary, upstream.Active())
+
+	req, err = http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"active":"green"}`))
+	a.Nil(err)
+	resp, err = http.DefaultClient.Do(req)
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestUpstreamRoutesByClientAttributes(t *testing.T) {
+	a := assert.New(t)
+
+	c := newTestProxyConfig("127.0.0.1:9092")
+	c.Upstream.SecondaryMapping = []string{"127.0.0.1:9092,127.0.0.1:19092"}
+	c.Upstream.Routes = []string{"secondary:principal=^qa-", "secondary:cidr=10.1.0.0/16", "primary:cidr=10.0.0.0/8"}
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	route := func(ip string, principal string) string {
+		conn := Conn{BrokerAddress: "127.0.0.1:9092", PeerPrincipal: principal,
+			LocalConnection: &remoteAddrConn{Conn: local, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}}}
+		cluster, address, err := upstream.routeConn(&conn)
+		a.Nil(err)
+		if cluster == UpstreamSecondary {
+			a.Equal("127.0.0.1:19092", address)
+		}
+		return cluster
+	}
+	a.Equal(UpstreamSecondary, route("192.168.1.1", "qa-orders"))
+	a.Equal(UpstreamPrimary, route("192.168.1.1", "orders"))
+	a.Equal(UpstreamSecondary, route("10.1.2.3", ""))
+
+	a.Nil(upstream.Switch(UpstreamSettings{Active: UpstreamSecondary}))
+	a.Equal(UpstreamPrimary, route("10.2.2.3", ""))
+	a.Equal(UpstreamSecondary, route("192.168.1.1", "orders"))
+}
+
+func TestProxyRoutesClientsToUpstreamCluster(t *testing.T) {
+	a := assert.New(t)
+
+	primary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 1})
+	a.Nil(err)
+	defer primary.Close()
+	secondary, err := kafkatest.NewBroker(kafkatest.Config{NodeID: 2})
+	a.Nil(err)
+	defer secondary.Close()
+
+	c := newTestProxyConfig(primary.Addr())
+	c.Upstream.SecondaryMapping = []string{primary.Addr() + "," + secondary.Addr()}
+	c.Upstream.Routes = []string{"secondary:cidr=127.0.0.0/8"}
+	upstream, err := NewUpstreamSwitch(c)
+	a.Nil(err)
+	listenerAddress, stop := startTestProxy(a, c, WithUpstreamSwitch(upstream))
+	defer stop()
+	host, port, err := util.SplitHostPort(listenerAddress)
+	a.Nil(err)
+
+	// routed to the secondary cluster although the primary cluster is active
+	a.Equal([]kafkatest.BrokerAddress{{NodeID: 2, Host: host, Port: port}}, metadataBrokersThroughProxy(a, listenerAddress))
+	a.Equal(0, primary.RequestCount(kafkatest.ApiKeyMetadata))
+	a.Equal(1, secondary.RequestCount(kafkatest.ApiKeyMetadata))
+}